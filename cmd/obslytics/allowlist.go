@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/thanos-community/obslytics/pkg/series"
+)
+
+// seriesAllowlist is the exact set of series to export, the series read by the matchers are filtered to them.
+type seriesAllowlist struct {
+	// series are the label sets by their hash.
+	series map[uint64][]labels.Labels
+}
+
+// loadSeriesAllowlist reads the label sets from the file, one per line in the Prometheus text format, e.g.
+// up{instance="a:9090",job="prometheus"}. Empty lines and lines starting with # are ignored.
+func loadSeriesAllowlist(file string) (*seriesAllowlist, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "open series file")
+	}
+	defer f.Close()
+
+	a := &seriesAllowlist{series: map[uint64][]labels.Labels{}}
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lset, err := parser.ParseMetric(line)
+		if err != nil {
+			return nil, errors.Wrapf(err, "series file line %d", n)
+		}
+		sort.Sort(lset)
+		if !a.contains(lset) {
+			a.series[lset.Hash()] = append(a.series[lset.Hash()], lset)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, errors.Wrap(err, "read series file")
+	}
+	if len(a.series) == 0 {
+		return nil, errors.Errorf("no series in series file %s", file)
+	}
+	return a, nil
+}
+
+func (a *seriesAllowlist) contains(lset labels.Labels) bool {
+	if !sort.IsSorted(lset) {
+		lset = lset.Copy()
+		sort.Sort(lset)
+	}
+	for _, l := range a.series[lset.Hash()] {
+		if labels.Equal(l, lset) {
+			return true
+		}
+	}
+	return false
+}
+
+// selector returns the selector of all the metrics of the allowlist, used when no matchers are given.
+func (a *seriesAllowlist) selector() (string, error) {
+	names := map[string]struct{}{}
+	for _, lsets := range a.series {
+		for _, lset := range lsets {
+			name := lset.Get(labels.MetricName)
+			if name == "" {
+				return "", errors.Errorf("series %s has no metric name, matchers are required", lset)
+			}
+			names[name] = struct{}{}
+		}
+	}
+	sorted := sortedNames(names)
+	if len(sorted) == 1 {
+		return "{" + labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, sorted[0]).String() + "}", nil
+	}
+	for i, n := range sorted {
+		sorted[i] = regexp.QuoteMeta(n)
+	}
+	return "{" + labels.MustNewMatcher(labels.MatchRegexp, labels.MetricName, strings.Join(sorted, "|")).String() + "}", nil
+}
+
+// filter returns the set of the allowlisted series only.
+func (a *seriesAllowlist) filter(s series.Set) series.Set {
+	return &allowlistSet{Set: s, allowlist: a}
+}
+
+type allowlistSet struct {
+	series.Set
+
+	allowlist *seriesAllowlist
+	cur       storage.Series
+}
+
+func (s *allowlistSet) Next() bool {
+	for s.Set.Next() {
+		if s.cur = s.Set.At(); s.allowlist.contains(s.cur.Labels()) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *allowlistSet) At() storage.Series { return s.cur }
+
+func sortedNames(m map[string]struct{}) []string {
+	ret := make([]string, 0, len(m))
+	for n := range m {
+		ret = append(ret, n)
+	}
+	sort.Strings(ret)
+	return ret
+}
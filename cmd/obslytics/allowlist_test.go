@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestSeriesAllowlist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "allowlist")
+	testutil.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "series.txt")
+	testutil.Ok(t, ioutil.WriteFile(file, []byte(`# Identified by the previous analysis.
+up{job="a",instance="x"}
+
+node_load1{instance="x"}
+up{instance="x",job="a"}
+`), 0600))
+
+	a, err := loadSeriesAllowlist(file)
+	testutil.Ok(t, err)
+	sel, err := a.selector()
+	testutil.Ok(t, err)
+	testutil.Equals(t, `{__name__=~"node_load1|up"}`, sel)
+	_, err = parser.ParseMetricSelector(sel)
+	testutil.Ok(t, err)
+
+	s := a.filter(&listSet{series: []storage.Series{
+		storage.NewListSeries(labels.FromStrings("__name__", "up", "instance", "x", "job", "a"), nil),
+		// Superset and subset of the allowed label sets.
+		storage.NewListSeries(labels.FromStrings("__name__", "up", "instance", "x", "job", "a", "env", "prod"), nil),
+		storage.NewListSeries(labels.FromStrings("__name__", "up", "job", "a"), nil),
+		storage.NewListSeries(labels.Labels{{Name: "instance", Value: "x"}, {Name: "__name__", Value: "node_load1"}}, nil),
+	}, i: -1})
+
+	var got []labels.Labels
+	for s.Next() {
+		got = append(got, s.At().Labels())
+	}
+	testutil.Equals(t, []labels.Labels{
+		labels.FromStrings("__name__", "up", "instance", "x", "job", "a"),
+		{{Name: "instance", Value: "x"}, {Name: "__name__", Value: "node_load1"}},
+	}, got)
+
+	testutil.Ok(t, ioutil.WriteFile(file, []byte(`{job="a"}`), 0600))
+	a, err = loadSeriesAllowlist(file)
+	testutil.Ok(t, err)
+	_, err = a.selector()
+	testutil.NotOk(t, err)
+
+	testutil.Ok(t, ioutil.WriteFile(file, []byte(`up{job=~"a"}`), 0600))
+	_, err = loadSeriesAllowlist(file)
+	testutil.NotOk(t, err)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// seriesMethod is the StoreAPI method of the audited requests.
+const seriesMethod = "/thanos.Store/Series"
+
+// tenantHeaders are the metadata keys of the tenant recorded by the audit, of Thanos and of Cortex.
+var tenantHeaders = []string{"thanos-tenant", "x-scope-orgid"}
+
+// auditRecord is the record of a StoreAPI Series request, as sent to the endpoint. The times are in milliseconds, as in
+// the request. Peer is the address the endpoint resolved to, Job and Output the job and the output path of the export
+// reading the series.
+type auditRecord struct {
+	Time     time.Time `json:"time"`
+	Endpoint string    `json:"endpoint"`
+	Peer     string    `json:"peer,omitempty"`
+	Tenant   string    `json:"tenant,omitempty"`
+	Job      string    `json:"job,omitempty"`
+	Output   string    `json:"output,omitempty"`
+
+	Matchers                string   `json:"matchers"`
+	MinTime                 int64    `json:"min_time"`
+	MaxTime                 int64    `json:"max_time"`
+	MaxResolutionWindow     int64    `json:"max_resolution_window"`
+	Aggregates              []string `json:"aggregates,omitempty"`
+	PartialResponseStrategy string   `json:"partial_response_strategy"`
+	SkipChunks              bool     `json:"skip_chunks"`
+}
+
+func newAuditRecord(endpoint, peer, tenant string, req *storepb.SeriesRequest) auditRecord {
+	r := auditRecord{
+		Time:                    time.Now().UTC(),
+		Endpoint:                endpoint,
+		Peer:                    peer,
+		Tenant:                  tenant,
+		Matchers:                storepb.MatchersToString(req.Matchers...),
+		MinTime:                 req.MinTime,
+		MaxTime:                 req.MaxTime,
+		MaxResolutionWindow:     req.MaxResolutionWindow,
+		PartialResponseStrategy: req.PartialResponseStrategy.String(),
+		SkipChunks:              req.SkipChunks,
+	}
+	for _, a := range req.Aggregates {
+		r.Aggregates = append(r.Aggregates, a.String())
+	}
+	return r
+}
+
+// auditLog is the destination of the audit records, shared by the writers of the jobs.
+type auditLog struct {
+	mtx sync.Mutex
+	enc *json.Encoder
+}
+
+// auditWriter writes the audit records of the StoreAPI Series requests as JSON objects, one per line, before the
+// requests are sent, with the job and output they are read for. It's safe for concurrent use.
+type auditWriter struct {
+	log         *auditLog
+	job, output string
+}
+
+func newAuditWriter(w io.Writer) *auditWriter {
+	return &auditWriter{log: &auditLog{enc: json.NewEncoder(w)}}
+}
+
+// forOutput returns the writer recording the requests of the job and the output path into the same destination. It's
+// safe to call on nil writer.
+func (w *auditWriter) forOutput(job, output string) *auditWriter {
+	if w == nil {
+		return nil
+	}
+	return &auditWriter{log: w.log, job: job, output: output}
+}
+
+func (w *auditWriter) write(r auditRecord) error {
+	r.Job, r.Output = w.job, w.output
+	w.log.mtx.Lock()
+	defer w.log.mtx.Unlock()
+	return w.log.enc.Encode(r)
+}
+
+// dialOptions returns the options auditing the requests of the gRPC client of the input. It's safe to call on nil
+// writer.
+func (w *auditWriter) dialOptions() []grpc.DialOption {
+	if w == nil {
+		return nil
+	}
+	return []grpc.DialOption{grpc.WithChainStreamInterceptor(w.streamInterceptor)}
+}
+
+func (w *auditWriter) streamInterceptor(
+	ctx context.Context,
+	desc *grpc.StreamDesc,
+	cc *grpc.ClientConn,
+	method string,
+	streamer grpc.Streamer,
+	opts ...grpc.CallOption,
+) (grpc.ClientStream, error) {
+	s, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil || method != seriesMethod {
+		return s, err
+	}
+	return &auditStream{ClientStream: s, w: w, endpoint: cc.Target(), tenant: tenant(ctx)}, nil
+}
+
+// tenant returns the tenant of the outgoing metadata, empty if there is none.
+func tenant(ctx context.Context) string {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	for _, h := range tenantHeaders {
+		if v := md.Get(h); len(v) > 0 {
+			return v[0]
+		}
+	}
+	return ""
+}
+
+// auditStream writes the record of the request before sending it, failing the request if the record is not written.
+type auditStream struct {
+	grpc.ClientStream
+
+	w                *auditWriter
+	endpoint, tenant string
+}
+
+func (s *auditStream) SendMsg(m interface{}) error {
+	if req, ok := m.(*storepb.SeriesRequest); ok {
+		var addr string
+		if p, ok := peer.FromContext(s.Context()); ok && p.Addr != nil {
+			addr = p.Addr.String()
+		}
+		if err := s.w.write(newAuditRecord(s.endpoint, addr, s.tenant, req)); err != nil {
+			return errors.Wrap(err, "write audit record")
+		}
+	}
+	return s.ClientStream.SendMsg(m)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/thanos-community/obslytics/pkg/series"
+	infactory "github.com/thanos-community/obslytics/pkg/series/factory"
+	"github.com/thanos-community/obslytics/pkg/series/storeapi/storetest"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("disk full") }
+
+func TestAuditWriter(t *testing.T) {
+	store := &storetest.Store{Fixtures: []*storepb.Series{
+		storetest.NewSeries(labels.FromStrings("__name__", "up", "job", "a"), storetest.Sample{T: 1000, V: 1}),
+	}}
+	addr := storetest.Serve(t, store)
+	_, port, err := net.SplitHostPort(addr)
+	testutil.Ok(t, err)
+	// The endpoint is given by name, the peer is its resolved address.
+	endpoint := net.JoinHostPort("localhost", port)
+
+	read := func(opts exportOptions) error {
+		in, err := infactory.NewSeriesReader(log.NewNopLogger(), series.Config{
+			Type:     series.STOREAPI,
+			Endpoint: endpoint,
+			Metadata: map[string]string{"THANOS-TENANT": "team-a", "authorization": "Bearer secret"},
+		}, opts.dialOptions()...)
+		testutil.Ok(t, err)
+		set, err := in.Read(context.Background(), series.Params{
+			Matchers:      []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "__name__", "up")},
+			MinTime:       time.Unix(0, 0),
+			MaxTime:       time.Unix(60, 0),
+			MaxResolution: 5 * time.Minute,
+		})
+		if err != nil {
+			return err
+		}
+		defer set.Close()
+		for set.Next() {
+		}
+		return set.Err()
+	}
+
+	b := &bytes.Buffer{}
+	testutil.Ok(t, read(exportOptions{audit: newAuditWriter(b).forOutput("daily", "out/{{.Shard}}.parquet")}))
+
+	testutil.Equals(t, 1, strings.Count(b.String(), "\n"))
+	r := auditRecord{}
+	testutil.Ok(t, json.Unmarshal(b.Bytes(), &r))
+	req := store.Requests()[0]
+	testutil.Equals(t, endpoint, r.Endpoint)
+	testutil.Equals(t, addr, r.Peer)
+	testutil.Equals(t, "daily", r.Job)
+	testutil.Equals(t, "out/{{.Shard}}.parquet", r.Output)
+	testutil.Equals(t, "team-a", r.Tenant)
+	testutil.Equals(t, `{__name__="up"}`, r.Matchers)
+	testutil.Equals(t, req.MinTime, r.MinTime)
+	testutil.Equals(t, req.MaxTime, r.MaxTime)
+	testutil.Equals(t, int64(300000), r.MaxResolutionWindow)
+	testutil.Equals(t, req.PartialResponseStrategy.String(), r.PartialResponseStrategy)
+	testutil.Assert(t, !strings.Contains(b.String(), "secret"), "credentials written to the audit: %s", b.String())
+
+	// The request is not sent if its record is not written.
+	n := len(store.Requests())
+	testutil.NotOk(t, read(exportOptions{audit: newAuditWriter(failingWriter{})}))
+	testutil.Equals(t, n, len(store.Requests()))
+
+	// No audit without the writer.
+	testutil.Ok(t, read(exportOptions{}))
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/thanos-community/obslytics/pkg/series"
+)
+
+const (
+	// cardinalityAbort fails the export once a label exceeds its limit. This is the default.
+	cardinalityAbort = "abort"
+	// cardinalityDrop skips the series bringing new values of a label over its limit.
+	cardinalityDrop = "drop"
+)
+
+// parseCardinalityLimits parses the limits of the distinct values by label, as given by the flags.
+func parseCardinalityLimits(m map[string]string) (map[string]int, error) {
+	if len(m) == 0 {
+		return nil, nil
+	}
+	limits := make(map[string]int, len(m))
+	for name, v := range m {
+		limit, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, errors.Errorf("cardinality limit of label %s has to be an integer, got %q", name, v)
+		}
+		limits[name] = limit
+	}
+	return limits, validateCardinality(limits, cardinalityAbort)
+}
+
+func validateCardinality(limits map[string]int, action string) error {
+	for name, limit := range limits {
+		if limit <= 0 {
+			return errors.Errorf("cardinality limit of label %s has to be positive, got %d", name, limit)
+		}
+	}
+	switch action {
+	case "", cardinalityAbort, cardinalityDrop:
+		return nil
+	}
+	return errors.Errorf("unsupported cardinality action %q, expected abort or drop", action)
+}
+
+// cardinalityGuard tracks the distinct values of the limited labels of the exported series, e.g. to protect the
+// downstream schemas from a runaway label. It's shared by the reads of an export (e.g. its shards) and safe for
+// concurrent use.
+type cardinalityGuard struct {
+	logger log.Logger
+	limits map[string]int
+	drop   bool
+
+	mtx     sync.Mutex
+	values  map[string]map[string]struct{}
+	dropped map[string]int
+}
+
+func newCardinalityGuard(logger log.Logger, limits map[string]int, action string) *cardinalityGuard {
+	g := &cardinalityGuard{
+		logger:  logger,
+		limits:  limits,
+		drop:    action == cardinalityDrop,
+		values:  map[string]map[string]struct{}{},
+		dropped: map[string]int{},
+	}
+	for name := range limits {
+		g.values[name] = map[string]struct{}{}
+	}
+	return g
+}
+
+// cardinalityError reports the label the series would bring over its limit.
+type cardinalityError struct {
+	label  string
+	limit  int
+	series string
+}
+
+func (e *cardinalityError) Error() string {
+	return fmt.Sprintf("label %s exceeded its cardinality limit of %d distinct values with series %s", e.label, e.limit, e.series)
+}
+
+// admit records the label values of the series. It returns the error of the first label the series would bring over
+// its limit, in which case none of the series values are recorded.
+func (g *cardinalityGuard) admit(s storage.Series) *cardinalityError {
+	lset := s.Labels()
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	var added []string
+	for _, l := range lset {
+		limit, ok := g.limits[l.Name]
+		if !ok {
+			continue
+		}
+		if _, ok := g.values[l.Name][l.Value]; ok {
+			continue
+		}
+		if len(g.values[l.Name]) >= limit {
+			for _, name := range added {
+				delete(g.values[name], lset.Get(name))
+			}
+			return &cardinalityError{label: l.Name, limit: limit, series: lset.String()}
+		}
+		g.values[l.Name][l.Value] = struct{}{}
+		added = append(added, l.Name)
+	}
+	return nil
+}
+
+// recordDrop records the series dropped by the label, logging the first one.
+func (g *cardinalityGuard) recordDrop(err *cardinalityError) {
+	g.mtx.Lock()
+	g.dropped[err.label]++
+	first := g.dropped[err.label] == 1
+	g.mtx.Unlock()
+
+	if first {
+		level.Warn(g.logger).Log("msg", "dropping series over cardinality limit", "label", err.label, "limit", err.limit, "series", err.series)
+	}
+}
+
+// warnings returns a warning per label that had series dropped.
+func (g *cardinalityGuard) warnings() storage.Warnings {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	names := make([]string, 0, len(g.dropped))
+	for name := range g.dropped {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var ws storage.Warnings
+	for _, name := range names {
+		ws = append(ws, errors.Errorf("label %s exceeded its cardinality limit of %d distinct values, dropped %d series",
+			name, g.limits[name], g.dropped[name]))
+	}
+	return ws
+}
+
+// filter returns the set failing, or skipping the series if drop is set, once a label exceeds its limit.
+func (g *cardinalityGuard) filter(s series.Set) series.Set {
+	return &cardinalitySet{Set: s, guard: g}
+}
+
+type cardinalitySet struct {
+	series.Set
+
+	guard *cardinalityGuard
+	cur   storage.Series
+	err   error
+}
+
+func (s *cardinalitySet) Next() bool {
+	for s.err == nil && s.Set.Next() {
+		s.cur = s.Set.At()
+		err := s.guard.admit(s.cur)
+		if err == nil {
+			return true
+		}
+		if !s.guard.drop {
+			s.err = err
+			return false
+		}
+		s.guard.recordDrop(err)
+	}
+	return false
+}
+
+func (s *cardinalitySet) At() storage.Series { return s.cur }
+
+func (s *cardinalitySet) Err() error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.Set.Err()
+}
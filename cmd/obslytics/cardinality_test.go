@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestCardinalityGuard(t *testing.T) {
+	newSet := func() *listSet {
+		return &listSet{series: []storage.Series{
+			storage.NewListSeries(labels.FromStrings("__name__", "up", "pod", "a"), nil),
+			storage.NewListSeries(labels.FromStrings("__name__", "up", "pod", "b"), nil),
+			// Over the limit of pod, its new job value is not counted.
+			storage.NewListSeries(labels.FromStrings("__name__", "up", "job", "x", "pod", "c"), nil),
+			storage.NewListSeries(labels.FromStrings("__name__", "up", "pod", "a", "job", "y"), nil),
+			storage.NewListSeries(labels.FromStrings("__name__", "up", "job", "z"), nil),
+		}, i: -1}
+	}
+	limits, err := parseCardinalityLimits(map[string]string{"pod": "2", "job": "2"})
+	testutil.Ok(t, err)
+
+	s := newCardinalityGuard(log.NewNopLogger(), limits, cardinalityAbort).filter(newSet())
+	testutil.Assert(t, s.Next())
+	testutil.Assert(t, s.Next())
+	testutil.Assert(t, !s.Next())
+	testutil.NotOk(t, s.Err())
+	testutil.Assert(t, strings.Contains(s.Err().Error(), "label pod exceeded its cardinality limit of 2"), s.Err().Error())
+
+	g := newCardinalityGuard(log.NewNopLogger(), limits, cardinalityDrop)
+	s = g.filter(newSet())
+	var got []string
+	for s.Next() {
+		got = append(got, s.At().Labels().String())
+	}
+	testutil.Ok(t, s.Err())
+	testutil.Equals(t, []string{
+		`{__name__="up", pod="a"}`,
+		`{__name__="up", pod="b"}`,
+		`{__name__="up", job="y", pod="a"}`,
+		`{__name__="up", job="z"}`,
+	}, got)
+	testutil.Equals(t, 1, len(g.warnings()))
+	testutil.Equals(t, "label pod exceeded its cardinality limit of 2 distinct values, dropped 1 series", g.warnings()[0].Error())
+
+	_, err = parseCardinalityLimits(map[string]string{"pod": "many"})
+	testutil.NotOk(t, err)
+	_, err = parseCardinalityLimits(map[string]string{"pod": "0"})
+	testutil.NotOk(t, err)
+	testutil.NotOk(t, validateCardinality(nil, "ignore"))
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/relabel"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/thanos-community/obslytics/pkg/dataframe"
+	"github.com/thanos-community/obslytics/pkg/exporter"
+	"github.com/thanos-community/obslytics/pkg/series"
+	"github.com/thanos-io/thanos/pkg/objstore/client"
+	"gopkg.in/alecthomas/kingpin.v2"
+	"gopkg.in/yaml.v2"
+)
+
+// pipelineConfig configures a whole export run from a single YAML file given by the config flag.
+// Flags given on the command line take precedence over the file values.
+type pipelineConfig struct {
+	Input  *series.Config   `yaml:"input"`
+	Output *exporter.Config `yaml:"output"`
+
+	Match       []string `yaml:"match"`
+	Concurrency int      `yaml:"concurrency"`
+
+	// MinTime and MaxTime accept the same formats as the since and until flags, e.g. "30d", "now" or "2021-01-01".
+	MinTime          string `yaml:"min_time"`
+	MaxTime          string `yaml:"max_time"`
+	MinTimeExclusive bool   `yaml:"min_time_exclusive"`
+	MaxTimeExclusive bool   `yaml:"max_time_exclusive"`
+	AllowLargeRange  bool   `yaml:"allow_large_range"`
+
+	Resolution model.Duration `yaml:"resolution"`
+	// ReadWindow splits the read into consecutive windows, see the read-window export flag.
+	ReadWindow model.Duration `yaml:"read_window"`
+	Snapshot   bool           `yaml:"snapshot"`
+	Downsample bool           `yaml:"downsample"`
+	Exemplars  bool           `yaml:"exemplars"`
+	// ExemplarTraces writes the exemplars as the table of their trace IDs, see the exemplar-traces export flags.
+	ExemplarTraces exemplarTracesConfig `yaml:"exemplar_traces"`
+	Aggregations   aggregationsConfig   `yaml:"aggregations"`
+	// Tidy writes the tidy table of all the metrics, see the tidy export flags.
+	Tidy tidyConfig `yaml:"tidy"`
+	// Order is the time order of the exported rows, see the order export flag.
+	Order dataframe.Order `yaml:"order"`
+	// TopSeries and TopSeriesBy limit the export to the series ranked highest, see the export flags of the same name.
+	TopSeries   int             `yaml:"top_series"`
+	TopSeriesBy dataframe.TopBy `yaml:"top_series_by"`
+
+	// ShardBy and ShardMerge split the read by the values of a label, see the export flags of the same name.
+	ShardBy    string `yaml:"shard_by"`
+	ShardMerge bool   `yaml:"shard_merge"`
+
+	// SplitSeries and SplitSeriesMaxFiles export every series into its own file, see the export flags of the same name.
+	SplitSeries         bool `yaml:"split_series"`
+	SplitSeriesMaxFiles int  `yaml:"split_series_max_files"`
+	// PartitionBy and PartitionMaxFiles export the series of every value of a label into its own file, see the export
+	// flags of the same name.
+	PartitionBy       string `yaml:"partition_by"`
+	PartitionMaxFiles int    `yaml:"partition_max_files"`
+	// OutputWriters bounds the files of split_series and partition_by written in parallel, see the output-writers
+	// export flag.
+	OutputWriters int `yaml:"output_writers"`
+
+	// SeriesFile lists the exact series to export, see the export flag of the same name.
+	SeriesFile string `yaml:"series_file"`
+
+	// RelabelConfigs are applied to the series labels before aggregation.
+	RelabelConfigs []*relabel.Config `yaml:"relabel_configs"`
+	// Enrichments add the labels looked up in the tables of the files by the value of a label, after relabeling.
+	Enrichments []enrichConfig `yaml:"enrichments"`
+	// StaticLabels are added to every series, see the static-label export flag.
+	StaticLabels map[string]string `yaml:"static_labels"`
+
+	// SampleSeries is the fraction of the series exported, see the sample-series export flag.
+	SampleSeries float64 `yaml:"sample_series"`
+	// ChangedOnly and ChangedEpsilon drop the series constant within the range, see the export flags of the same name.
+	ChangedOnly    bool    `yaml:"changed_only"`
+	ChangedEpsilon float64 `yaml:"changed_epsilon"`
+
+	// CardinalityLimits and CardinalityAction cap the distinct values of the labels, see the cardinality-limit and
+	// cardinality-action export flags.
+	CardinalityLimits map[string]int `yaml:"cardinality_limits"`
+	CardinalityAction string         `yaml:"cardinality_action"`
+	// MaxSeriesLabels and MaxSeriesLabelsAction limit the number of labels of the series, see the export flags of the
+	// same name.
+	MaxSeriesLabels       int    `yaml:"max_series_labels"`
+	MaxSeriesLabelsAction string `yaml:"max_series_labels_action"`
+
+	// Units convert the aggregated values of metrics, e.g. from bytes to GiB.
+	Units []unitConfig `yaml:"units"`
+	// units are the validated conversions by metric name.
+	units map[string]dataframe.UnitConversion
+
+	// Jobs, if given, turn the run into a batch of exports, e.g. to backfill many metrics and ranges. Jobs run
+	// in order, at most concurrency of them in parallel.
+	Jobs []jobConfig `yaml:"jobs"`
+	// ContinueOnError keeps starting the remaining jobs after a job fails.
+	ContinueOnError bool `yaml:"continue_on_error"`
+}
+
+// aggregationsConfig selects the optional aggregations, see the export flags of the same name.
+type aggregationsConfig struct {
+	// Functions are the aggregation functions computed per window, each into its own column, see the aggregation
+	// export flag.
+	Functions []string `yaml:"functions"`
+
+	SeriesID                 bool `yaml:"series_id"`
+	First                    bool `yaml:"first"`
+	Last                     bool `yaml:"last"`
+	CountDistinct            bool `yaml:"count_distinct"`
+	CountDistinctApproximate bool `yaml:"count_distinct_approximate"`
+	EmptyWindows             bool `yaml:"empty_windows"`
+	Summaries                bool `yaml:"summaries"`
+	ScrapeInterval           bool `yaml:"scrape_interval"`
+	SampleCount              bool `yaml:"sample_count"`
+	Delta                    bool `yaml:"delta"`
+	DeltaPerSecond           bool `yaml:"delta_per_second"`
+	// MetricColumn is the column of the metric name, __name__ by default, omitted if empty.
+	MetricColumn *string `yaml:"metric_column"`
+	// DeltaFirst is omit (default) or zero.
+	DeltaFirst series.DeltaFirst `yaml:"delta_first"`
+	// RateUnit is second (default), minute or hour.
+	RateUnit series.RateUnit `yaml:"rate_unit"`
+	// RoundTo and RoundMode quantize the aggregated values, see the round-to and round-mode export flags.
+	RoundTo   float64                `yaml:"round_to"`
+	RoundMode dataframe.RoundingMode `yaml:"round_mode"`
+	// Overrides select the function of the samples aggregated per metric, the first matching one replacing the delta
+	// options above, e.g. rate of the *_total counters and values of the gauges in a single run.
+	Overrides []overrideConfig `yaml:"overrides"`
+	// overrides are the validated overrides.
+	overrides []dataframe.AggrOverride
+}
+
+// overrideConfig selects the sample function (value, delta, derivative, increase or rate) of the series of the metric,
+// or of the series matching the selector, e.g. {__name__=~".+_total"}.
+type overrideConfig struct {
+	Metric   string                   `yaml:"metric"`
+	Match    string                   `yaml:"match"`
+	Function dataframe.SampleFunction `yaml:"function"`
+}
+
+func (c overrideConfig) override() (dataframe.AggrOverride, error) {
+	if err := c.Function.Validate(); err != nil {
+		return dataframe.AggrOverride{}, err
+	}
+	if (c.Metric == "") == (c.Match == "") {
+		return dataframe.AggrOverride{}, errors.New("either metric or match is required")
+	}
+	if c.Metric != "" {
+		return dataframe.AggrOverride{
+			Matchers: []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, c.Metric)},
+			Function: c.Function,
+		}, nil
+	}
+	ms, err := parser.ParseMetricSelector(c.Match)
+	if err != nil {
+		return dataframe.AggrOverride{}, errors.Wrapf(err, "parse match %s", c.Match)
+	}
+	return dataframe.AggrOverride{Matchers: ms, Function: c.Function}, nil
+}
+
+// exemplarTracesConfig configures the table of the exemplar trace IDs, see the exemplar-traces export flags.
+type exemplarTracesConfig struct {
+	Enabled    bool   `yaml:"enabled"`
+	TraceLabel string `yaml:"trace_label"`
+}
+
+// tidyConfig configures the tidy table, see the tidy export flags.
+type tidyConfig struct {
+	Enabled      bool     `yaml:"enabled"`
+	Value        string   `yaml:"value"`
+	Dimensions   []string `yaml:"dimensions"`
+	PackedLabels string   `yaml:"packed_labels"`
+}
+
+// unitConfig converts the aggregated values of a metric, either between known units (e.g. from bytes to GiB)
+// or by an explicit scale and unit.
+type unitConfig struct {
+	Metric string  `yaml:"metric"`
+	From   string  `yaml:"from"`
+	To     string  `yaml:"to"`
+	Scale  float64 `yaml:"scale"`
+	Unit   string  `yaml:"unit"`
+}
+
+func (c unitConfig) conversion() (dataframe.UnitConversion, error) {
+	if c.Scale != 0 {
+		if c.From != "" || c.To != "" {
+			return dataframe.UnitConversion{}, errors.New("either from and to or scale and unit can be given, not both")
+		}
+		// Negative scale would swap min and max.
+		if c.Scale < 0 {
+			return dataframe.UnitConversion{}, errors.New("scale has to be positive")
+		}
+		return dataframe.UnitConversion{Scale: c.Scale, Unit: c.Unit}, nil
+	}
+	return dataframe.NewUnitConversion(c.From, c.To)
+}
+
+func loadPipelineConfig(file string) (pipelineConfig, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return pipelineConfig{}, errors.Wrap(err, "read config file")
+	}
+	return parsePipelineConfig(b)
+}
+
+func parsePipelineConfig(b []byte) (pipelineConfig, error) {
+	cfg := pipelineConfig{
+		// Default Storage Type is Filesystem.
+		Output: &exporter.Config{Storage: client.BucketConfig{Type: client.FILESYSTEM}},
+	}
+	// Unknown keys are rejected to catch typos.
+	if err := yaml.UnmarshalStrict(b, &cfg); err != nil {
+		return pipelineConfig{}, errors.Wrap(err, "parse config file")
+	}
+
+	if err := validateCardinality(cfg.CardinalityLimits, cfg.CardinalityAction); err != nil {
+		return pipelineConfig{}, err
+	}
+	if err := validateStaticLabels(cfg.StaticLabels); err != nil {
+		return pipelineConfig{}, err
+	}
+	if err := validateLabelLimit(cfg.MaxSeriesLabels, cfg.MaxSeriesLabelsAction); err != nil {
+		return pipelineConfig{}, err
+	}
+	if err := cfg.Order.Validate(); err != nil {
+		return pipelineConfig{}, err
+	}
+	if err := cfg.TopSeriesBy.Validate(); err != nil {
+		return pipelineConfig{}, err
+	}
+	if err := cfg.Aggregations.DeltaFirst.Validate(); err != nil {
+		return pipelineConfig{}, errors.Wrap(err, "aggregations")
+	}
+	if err := cfg.Aggregations.RateUnit.Validate(); err != nil {
+		return pipelineConfig{}, errors.Wrap(err, "aggregations")
+	}
+	if v := cfg.Tidy.Value; v != "" && !contains(aggregationFunctions, v) {
+		return pipelineConfig{}, errors.Errorf("tidy: unsupported value function %q, expected one of %s", v, strings.Join(aggregationFunctions, ", "))
+	}
+	if err := cfg.Aggregations.RoundMode.Validate(); err != nil {
+		return pipelineConfig{}, errors.Wrap(err, "aggregations")
+	}
+	if cfg.Aggregations.RoundTo < 0 {
+		return pipelineConfig{}, errors.New("aggregations: round_to cannot be negative")
+	}
+	if cfg.ChangedEpsilon < 0 {
+		return pipelineConfig{}, errors.New("changed_epsilon cannot be negative")
+	}
+	for _, f := range cfg.Aggregations.Functions {
+		if !contains(aggregationFunctions, f) {
+			return pipelineConfig{}, errors.Errorf("aggregations: unsupported function %q, expected one of %s", f, strings.Join(aggregationFunctions, ", "))
+		}
+	}
+	for i, e := range cfg.Enrichments {
+		if err := e.validate(); err != nil {
+			return pipelineConfig{}, errors.Wrapf(err, "enrichments: %d", i)
+		}
+	}
+	for i, o := range cfg.Aggregations.Overrides {
+		override, err := o.override()
+		if err != nil {
+			return pipelineConfig{}, errors.Wrapf(err, "aggregations: override %d", i)
+		}
+		cfg.Aggregations.overrides = append(cfg.Aggregations.overrides, override)
+	}
+	for _, u := range cfg.Units {
+		if u.Metric == "" {
+			return pipelineConfig{}, errors.New("units: metric is required")
+		}
+		if _, ok := cfg.units[u.Metric]; ok {
+			return pipelineConfig{}, errors.Errorf("units: duplicate conversion of metric %s", u.Metric)
+		}
+		conv, err := u.conversion()
+		if err != nil {
+			return pipelineConfig{}, errors.Wrapf(err, "units: metric %s", u.Metric)
+		}
+		if cfg.units == nil {
+			cfg.units = map[string]dataframe.UnitConversion{}
+		}
+		cfg.units[u.Metric] = conv
+	}
+	return cfg, nil
+}
+
+// apply sets the options not given by flags from the config.
+func (c pipelineConfig) apply(opts *exportOptions, set setFlags) {
+	for name, o := range map[string]struct {
+		dst *bool
+		v   bool
+	}{
+		"min-time-exclusive":         {&opts.mintExclusive, c.MinTimeExclusive},
+		"max-time-exclusive":         {&opts.maxtExclusive, c.MaxTimeExclusive},
+		"snapshot":                   {&opts.snapshot, c.Snapshot},
+		"downsample":                 {&opts.downsample, c.Downsample},
+		"exemplars":                  {&opts.exemplars, c.Exemplars},
+		"series-id":                  {&opts.seriesID, c.Aggregations.SeriesID},
+		"first":                      {&opts.first, c.Aggregations.First},
+		"last":                       {&opts.last, c.Aggregations.Last},
+		"count-distinct":             {&opts.countDistinct, c.Aggregations.CountDistinct},
+		"count-distinct-approximate": {&opts.countDistinctApprox, c.Aggregations.CountDistinctApproximate},
+		"empty-windows":              {&opts.emptyWindows, c.Aggregations.EmptyWindows},
+		"summaries":                  {&opts.summaries, c.Aggregations.Summaries},
+		"scrape-interval":            {&opts.scrapeInterval, c.Aggregations.ScrapeInterval},
+		"sample-count":               {&opts.sampleCount, c.Aggregations.SampleCount},
+		"delta":                      {&opts.delta, c.Aggregations.Delta},
+		"delta-per-second":           {&opts.deltaPerSecond, c.Aggregations.DeltaPerSecond},
+		"shard-merge":                {&opts.shardMerge, c.ShardMerge},
+		"split-series":               {&opts.splitSeries, c.SplitSeries},
+		"changed-only":               {&opts.changedOnly, c.ChangedOnly},
+		"tidy":                       {&opts.tidy, c.Tidy.Enabled},
+		"exemplar-traces":            {&opts.exemplarTraces, c.ExemplarTraces.Enabled},
+	} {
+		if !set.isSet(name) {
+			*o.dst = o.v
+		}
+	}
+	if !set.isSet("resolution") {
+		opts.resolution = time.Duration(c.Resolution)
+	}
+	if !set.isSet("read-window") && c.ReadWindow > 0 {
+		opts.readWindow = time.Duration(c.ReadWindow)
+	}
+	if !set.isSet("metric-column") && c.Aggregations.MetricColumn != nil {
+		opts.metricColumn = *c.Aggregations.MetricColumn
+	}
+	if !set.isSet("delta-first") && c.Aggregations.DeltaFirst != "" {
+		opts.deltaFirst = string(c.Aggregations.DeltaFirst)
+	}
+	if !set.isSet("rate-unit") && c.Aggregations.RateUnit != "" {
+		opts.rateUnit = string(c.Aggregations.RateUnit)
+	}
+	if !set.isSet("exemplar-trace-label") && c.ExemplarTraces.TraceLabel != "" {
+		opts.exemplarTraceLabel = c.ExemplarTraces.TraceLabel
+	}
+	if !set.isSet("tidy-value") && c.Tidy.Value != "" {
+		opts.tidyValue = c.Tidy.Value
+	}
+	if !set.isSet("tidy-dimension") && len(c.Tidy.Dimensions) > 0 {
+		opts.tidyDimensions = c.Tidy.Dimensions
+	}
+	if !set.isSet("tidy-packed-labels") {
+		opts.tidyPackedLabels = c.Tidy.PackedLabels
+	}
+	if !set.isSet("round-to") && c.Aggregations.RoundTo > 0 {
+		opts.roundTo = c.Aggregations.RoundTo
+	}
+	if !set.isSet("round-mode") && c.Aggregations.RoundMode != "" {
+		opts.roundMode = string(c.Aggregations.RoundMode)
+	}
+	if !set.isSet("aggregation") && len(c.Aggregations.Functions) > 0 {
+		opts.functions = c.Aggregations.Functions
+	}
+	if !set.isSet("order") && c.Order != "" {
+		opts.order = string(c.Order)
+	}
+	if !set.isSet("top-series") && c.TopSeries > 0 {
+		opts.topSeries = c.TopSeries
+	}
+	if !set.isSet("top-series-by") && c.TopSeriesBy != "" {
+		opts.topBy = string(c.TopSeriesBy)
+	}
+	if !set.isSet("shard-by") {
+		opts.shardBy = c.ShardBy
+	}
+	if !set.isSet("split-series-max-files") && c.SplitSeriesMaxFiles > 0 {
+		opts.maxSeriesFiles = c.SplitSeriesMaxFiles
+	}
+	if !set.isSet("partition-by") {
+		opts.partitionBy = c.PartitionBy
+	}
+	if !set.isSet("partition-max-files") && c.PartitionMaxFiles > 0 {
+		opts.maxPartitionFiles = c.PartitionMaxFiles
+	}
+	if !set.isSet("output-writers") && c.OutputWriters > 0 {
+		opts.outputWriters = c.OutputWriters
+	}
+	if !set.isSet("series-file") {
+		opts.seriesFile = c.SeriesFile
+	}
+	if !set.isSet("changed-epsilon") && c.ChangedEpsilon > 0 {
+		opts.changedEpsilon = c.ChangedEpsilon
+	}
+	if !set.isSet("sample-series") && c.SampleSeries > 0 {
+		opts.sampleSeries = c.SampleSeries
+	}
+	if !set.isSet("cardinality-limit") && len(c.CardinalityLimits) > 0 {
+		opts.cardinalityLimits = c.CardinalityLimits
+	}
+	if !set.isSet("cardinality-action") && c.CardinalityAction != "" {
+		opts.cardinalityAction = c.CardinalityAction
+	}
+	if !set.isSet("static-label") && len(c.StaticLabels) > 0 {
+		opts.staticLabels = c.StaticLabels
+	}
+	if !set.isSet("max-series-labels") && c.MaxSeriesLabels > 0 {
+		opts.maxSeriesLabels = c.MaxSeriesLabels
+	}
+	if !set.isSet("max-series-labels-action") && c.MaxSeriesLabelsAction != "" {
+		opts.maxSeriesLabelsAction = c.MaxSeriesLabelsAction
+	}
+	opts.relabelConfigs = c.RelabelConfigs
+	opts.units = c.units
+	opts.overrides = c.Aggregations.overrides
+}
+
+// setFlags records which flags were given on the command line.
+type setFlags map[string]*bool
+
+// flag registers a flag on the command, tracking whether it was set.
+func (s setFlags) flag(cmd *kingpin.CmdClause, name, help string) *kingpin.FlagClause {
+	s[name] = new(bool)
+	return cmd.Flag(name, help).IsSetByUser(s[name])
+}
+
+func (s setFlags) isSet(name string) bool {
+	p, ok := s[name]
+	return ok && *p
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/thanos-community/obslytics/pkg/dataframe"
+	"github.com/thanos-community/obslytics/pkg/series"
+	"github.com/thanos-io/thanos/pkg/objstore/client"
+	"github.com/thanos-io/thanos/pkg/testutil"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+const testPipelineConfig = `
+input:
+  type: STOREAPI
+  endpoint: localhost:10901
+output:
+  type: PARQUET
+  path: out.parquet
+match: ['up', 'node_load1']
+min_time: 2021-01-01
+max_time: 2021-01-02
+resolution: 30m
+snapshot: true
+aggregations:
+  first: true
+  last: true
+relabel_configs:
+- source_labels: [job]
+  regex: drop
+  action: drop
+`
+
+func TestParsePipelineConfig(t *testing.T) {
+	cfg, err := parsePipelineConfig([]byte(testPipelineConfig))
+	testutil.Ok(t, err)
+	testutil.Equals(t, series.STOREAPI, cfg.Input.Type)
+	testutil.Equals(t, "out.parquet", cfg.Output.Path)
+	testutil.Equals(t, client.FILESYSTEM, cfg.Output.Storage.Type)
+	testutil.Equals(t, []string{"up", "node_load1"}, cfg.Match)
+	testutil.Equals(t, 1, len(cfg.RelabelConfigs))
+
+	_, err = parsePipelineConfig([]byte("resolution: 30m\naggregation:\n  first: true\n"))
+	testutil.NotOk(t, err)
+	_, err = parsePipelineConfig([]byte("aggregations:\n  delta: true\n  delta_first: first\n"))
+	testutil.NotOk(t, err)
+	_, err = parsePipelineConfig([]byte("aggregations:\n  delta_per_second: true\n  rate_unit: day\n"))
+	testutil.NotOk(t, err)
+	_, err = parsePipelineConfig([]byte("aggregations:\n  round_to: 10\n  round_mode: half-even\n"))
+	testutil.NotOk(t, err)
+	_, err = parsePipelineConfig([]byte("order: descending\n"))
+	testutil.NotOk(t, err)
+	_, err = parsePipelineConfig([]byte("top_series: 10\ntop_series_by: sum\n"))
+	testutil.NotOk(t, err)
+	_, err = parsePipelineConfig([]byte("aggregations:\n  functions: [min, median]\n"))
+	testutil.NotOk(t, err)
+	_, err = parsePipelineConfig([]byte("changed_only: true\nchanged_epsilon: -1\n"))
+	testutil.NotOk(t, err)
+	_, err = parsePipelineConfig([]byte("max_series_labels: 50\nmax_series_labels_action: truncate\n"))
+	testutil.NotOk(t, err)
+	_, err = parsePipelineConfig([]byte("enrichments:\n- file: teams.csv\n  key: instance\n  default: {team-name: x}\n"))
+	testutil.NotOk(t, err)
+}
+
+func TestParsePipelineConfig_Units(t *testing.T) {
+	cfg, err := parsePipelineConfig([]byte(`
+units:
+- metric: node_memory_MemTotal_bytes
+  from: bytes
+  to: GiB
+- metric: http_request_duration_seconds_sum
+  scale: 1000
+  unit: ms
+`))
+	testutil.Ok(t, err)
+	testutil.Equals(t, map[string]dataframe.UnitConversion{
+		"node_memory_MemTotal_bytes":        {Scale: 1.0 / (1 << 30), Unit: "GiB"},
+		"http_request_duration_seconds_sum": {Scale: 1000, Unit: "ms"},
+	}, cfg.units)
+
+	for _, c := range []string{
+		"units:\n- from: bytes\n  to: GiB\n",
+		"units:\n- metric: up\n  from: bytes\n  to: ms\n",
+		"units:\n- metric: up\n  from: bytes\n  to: GiB\n  scale: 2\n",
+		"units:\n- metric: up\n  scale: -1\n",
+		"units:\n- metric: up\n  scale: 2\n- metric: up\n  scale: 3\n",
+	} {
+		_, err := parsePipelineConfig([]byte(c))
+		testutil.NotOk(t, err, c)
+	}
+}
+
+func TestParsePipelineConfig_Overrides(t *testing.T) {
+	cfg, err := parsePipelineConfig([]byte(`
+aggregations:
+  overrides:
+  - match: '{__name__=~".+_total"}'
+    function: rate
+  - metric: node_load1
+    function: value
+`))
+	testutil.Ok(t, err)
+	testutil.Equals(t, 2, len(cfg.Aggregations.overrides))
+	testutil.Equals(t, `__name__=~".+_total"`, cfg.Aggregations.overrides[0].Matchers[0].String())
+	testutil.Equals(t, dataframe.SampleRate, cfg.Aggregations.overrides[0].Function)
+	testutil.Equals(t, `__name__="node_load1"`, cfg.Aggregations.overrides[1].Matchers[0].String())
+
+	for _, c := range []string{
+		"aggregations:\n  overrides:\n  - metric: up\n    function: avg\n",
+		"aggregations:\n  overrides:\n  - function: rate\n",
+		"aggregations:\n  overrides:\n  - metric: up\n    match: up\n    function: rate\n",
+		"aggregations:\n  overrides:\n  - match: '{'\n    function: rate\n",
+	} {
+		_, err := parsePipelineConfig([]byte(c))
+		testutil.NotOk(t, err, c)
+	}
+}
+
+func TestPipelineConfig_Apply(t *testing.T) {
+	cfg, err := parsePipelineConfig([]byte(testPipelineConfig))
+	testutil.Ok(t, err)
+
+	app := kingpin.New("test", "")
+	opts := exportOptions{}
+	set := setFlags{}
+	set.flag(app.Command("export", ""), "resolution", "").DurationVar(&opts.resolution)
+	set.flag(app.GetCommand("export"), "snapshot", "").BoolVar(&opts.snapshot)
+	set.flag(app.GetCommand("export"), "first", "").BoolVar(&opts.first)
+	set.flag(app.GetCommand("export"), "last", "").BoolVar(&opts.last)
+
+	_, err = app.Parse([]string{"export", "--resolution=5m", "--no-snapshot", "--first"})
+	testutil.Ok(t, err)
+	cfg.apply(&opts, set)
+
+	// Flags take precedence, values not given by flags come from the config.
+	testutil.Equals(t, 5*time.Minute, opts.resolution)
+	testutil.Equals(t, false, opts.snapshot)
+	testutil.Equals(t, true, opts.first)
+	testutil.Equals(t, true, opts.last)
+	testutil.Equals(t, cfg.RelabelConfigs, opts.relabelConfigs)
+}
+
+func TestPipelineConfig_Apply_MetricColumn(t *testing.T) {
+	for _, tcase := range []struct {
+		config, flag, exp string
+	}{
+		{exp: "__name__"},
+		{config: "aggregations:\n  metric_column: metric\n", exp: "metric"},
+		// Empty column given by the config omits the metric name.
+		{config: "aggregations:\n  metric_column: ''\n", exp: ""},
+		{config: "aggregations:\n  metric_column: ''\n", flag: "--metric-column=name", exp: "name"},
+	} {
+		cfg, err := parsePipelineConfig([]byte(tcase.config))
+		testutil.Ok(t, err)
+
+		app := kingpin.New("test", "")
+		opts := exportOptions{}
+		set := setFlags{}
+		set.flag(app.Command("export", ""), "metric-column", "").Default(labels.MetricName).StringVar(&opts.metricColumn)
+		args := []string{"export"}
+		if tcase.flag != "" {
+			args = append(args, tcase.flag)
+		}
+		_, err = app.Parse(args)
+		testutil.Ok(t, err)
+		cfg.apply(&opts, set)
+		testutil.Equals(t, tcase.exp, opts.metricColumn)
+	}
+}
+
+func TestPipelineConfig_Tidy(t *testing.T) {
+	cfg, err := parsePipelineConfig([]byte("tidy:\n  enabled: true\n  value: last\n  dimensions: [job, instance]\n  packed_labels: labels\n"))
+	testutil.Ok(t, err)
+
+	app := kingpin.New("test", "")
+	opts := exportOptions{}
+	set := setFlags{}
+	set.flag(app.Command("export", ""), "tidy-value", "").Default("avg").StringVar(&opts.tidyValue)
+	_, err = app.Parse([]string{"export"})
+	testutil.Ok(t, err)
+	cfg.apply(&opts, set)
+	testutil.Assert(t, opts.tidy)
+	testutil.Assert(t, opts.aggregated("last"))
+	testutil.Equals(t, dataframe.TidyOptions{Value: "_last", Dimensions: []string{"job", "instance"}, PackedLabels: "labels"}, opts.tidyOptions())
+
+	_, err = parsePipelineConfig([]byte("tidy:\n  enabled: true\n  value: median\n"))
+	testutil.NotOk(t, err)
+}
+
+func TestRelabelSet(t *testing.T) {
+	cfg, err := parsePipelineConfig([]byte(testPipelineConfig))
+	testutil.Ok(t, err)
+
+	s := newRelabelSet(&listSet{series: []storage.Series{
+		storage.NewListSeries(labels.FromStrings("__name__", "up", "job", "drop"), nil),
+		storage.NewListSeries(labels.FromStrings("__name__", "up", "job", "keep"), nil),
+		storage.NewListSeries(labels.FromStrings("__name__", "up", "job", "drop"), nil),
+	}, i: -1}, cfg.RelabelConfigs)
+
+	var got []labels.Labels
+	for s.Next() {
+		got = append(got, s.At().Labels())
+	}
+	testutil.Equals(t, []labels.Labels{labels.FromStrings("__name__", "up", "job", "keep")}, got)
+}
+
+type listSet struct {
+	series []storage.Series
+	i      int
+}
+
+func (s *listSet) Next() bool {
+	s.i++
+	return s.i < len(s.series)
+}
+
+func (s *listSet) At() storage.Series { return s.series[s.i] }
+
+func (s *listSet) Err() error { return nil }
+
+func (s *listSet) Warnings() storage.Warnings { return nil }
+
+func (s *listSet) Close() error { return nil }
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/oklog/run"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/thanos-community/obslytics/pkg/series"
+	"github.com/thanos-io/thanos/pkg/extflag"
+	"github.com/thanos-io/thanos/pkg/model"
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	infactory "github.com/thanos-community/obslytics/pkg/series/factory"
+)
+
+func registerCount(m map[string]setupFunc, app *kingpin.Application) {
+	cmd := app.Command("count", "Count series per metric name, e.g. to plan an export.")
+	inputFlag := extflag.RegisterPathOrContent(cmd, "input-config", "YAML for input, series configuration.", true)
+
+	matchersStr := cmd.Flag("match", "Metric matcher for metrics to count (e.g up{a=\"1\"}").Required().String()
+	timeRange := registerTimeRangeFlags(cmd)
+	encodings := cmd.Flag("chunk-encodings", "Count the series, chunks and bytes per metric name and chunk encoding (e.g. XOR) instead, "+
+		"to understand the storage and decoding costs. The chunks are read, but not decoded. Inputs not exposing the encodings "+
+		"(other than StoreAPI) report them as unknown").Bool()
+
+	m["count"] = func(g *run.Group, logger log.Logger, _ *prometheus.Registry) error {
+		mint, maxt, err := timeRange.resolve(time.Now())
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(func() error {
+			inputConfig, err := parseInputConfig(inputFlag, nil)
+			if err != nil {
+				return err
+			}
+
+			return count(ctx, logger, os.Stdout, *matchersStr, inputConfig, mint, maxt, *encodings)
+		}, func(error) { cancel() })
+		return nil
+	}
+}
+
+func count(
+	ctx context.Context,
+	logger log.Logger,
+	w io.Writer,
+	matchersStr string,
+	inputConfig series.Config,
+	mint, maxt model.TimeOrDurationValue,
+	encodings bool,
+) error {
+	matchers, err := parser.ParseMetricSelector(matchersStr)
+	if err != nil {
+		return errors.Wrap(err, "parsing provided matchers")
+	}
+
+	in, err := infactory.NewSeriesReader(logger, inputConfig)
+	if err != nil {
+		return err
+	}
+
+	ser, err := in.Read(ctx, series.Params{
+		Matchers:   matchers,
+		MinTime:    timestamp.Time(mint.PrometheusTimestamp()),
+		MaxTime:    timestamp.Time(maxt.PrometheusTimestamp()),
+		SkipChunks: !encodings,
+	})
+	if err != nil {
+		return err
+	}
+	if encodings {
+		return printEncodings(w, ser)
+	}
+
+	counts, err := series.CountByMetricName(ser)
+	if err != nil {
+		return errors.Wrap(err, "counting series")
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprint(tw, "METRIC\tSERIES\n")
+	for _, c := range counts {
+		fmt.Fprintf(tw, "%s\t%d\n", c.Name, c.Series)
+	}
+	return tw.Flush()
+}
+
+// printEncodings prints the series, chunks and bytes of the set per metric name and chunk encoding.
+func printEncodings(w io.Writer, ser series.Set) error {
+	counts, err := series.CountByEncoding(ser)
+	if err != nil {
+		return errors.Wrap(err, "counting chunk encodings")
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprint(tw, "METRIC\tENCODING\tSERIES\tCHUNKS\tBYTES\n")
+	for _, c := range counts {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\n", c.Name, c.Encoding, c.Series, c.Chunks, c.Bytes)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/oklog/run"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	channelz "google.golang.org/grpc/channelz/service"
+	"google.golang.org/grpc/reflection"
+)
+
+// newDebugServer returns the gRPC server exposing the channelz service, with the state of the gRPC channels,
+// subchannels and sockets of the process (e.g. to the StoreAPI), and the reflection service, so that it can be
+// queried by generic clients like grpcurl or grpcdebug.
+//
+// Channelz data are collected by gRPC once the channelz service package is linked, regardless of the server.
+func newDebugServer() *grpc.Server {
+	srv := grpc.NewServer()
+	channelz.RegisterChannelzServiceToServer(srv)
+	reflection.Register(srv)
+	return srv
+}
+
+// runDebugServer serves the debug gRPC server on the address until the group is interrupted.
+func runDebugServer(g *run.Group, logger log.Logger, addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return errors.Wrap(err, "listen debug gRPC address")
+	}
+	srv := newDebugServer()
+	g.Add(func() error {
+		level.Info(logger).Log("msg", "serving gRPC channelz", "address", l.Addr())
+		return srv.Serve(l)
+	}, func(error) {
+		srv.Stop()
+	})
+	return nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/thanos-io/thanos/pkg/testutil"
+	"google.golang.org/grpc"
+	channelzpb "google.golang.org/grpc/channelz/grpc_channelz_v1"
+)
+
+func TestDebugServer_Channelz(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	testutil.Ok(t, err)
+	srv := newDebugServer()
+	go func() { _ = srv.Serve(l) }()
+	defer srv.Stop()
+
+	ctx := context.Background()
+	conn, err := grpc.DialContext(ctx, l.Addr().String(), grpc.WithInsecure(), grpc.WithBlock())
+	testutil.Ok(t, err)
+	defer conn.Close()
+
+	client := channelzpb.NewChannelzClient(conn)
+	resp, err := client.GetTopChannels(ctx, &channelzpb.GetTopChannelsRequest{})
+	testutil.Ok(t, err)
+
+	// The connection used for the query is listed with its target and subchannel.
+	var found bool
+	for _, c := range resp.Channel {
+		if c.Data.Target == l.Addr().String() {
+			found = true
+			testutil.Equals(t, channelzpb.ChannelConnectivityState_READY, c.Data.State.State)
+			testutil.Equals(t, 1, len(c.SubchannelRef))
+		}
+	}
+	testutil.Assert(t, found, "channel to %v not found in %v", l.Addr(), resp.Channel)
+}
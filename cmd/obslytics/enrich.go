@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/thanos-community/obslytics/pkg/series"
+)
+
+// enrichConfig adds the labels looked up in the table of the file by the value of the key label of every series, e.g.
+// the team owning the instance, so that the business metadata need not be joined downstream.
+type enrichConfig struct {
+	// File is the lookup table: CSV with a header row, or JSON if the file has the .json extension, either an array of
+	// objects (the rows by column name) or an object of the labels by the key value.
+	File string `yaml:"file"`
+	// Key is the label whose value is looked up.
+	Key string `yaml:"key"`
+	// Column is the column of the rows holding the key values, the key label by default.
+	Column string `yaml:"column"`
+	// Labels are the columns added as labels, all the other columns by default.
+	Labels []string `yaml:"labels"`
+	// Default are the labels added to the series without the key value in the table, including the series without the
+	// key label. Such series are left unchanged by default.
+	Default map[string]string `yaml:"default"`
+}
+
+func (c enrichConfig) validate() error {
+	if c.File == "" {
+		return errors.New("file is required")
+	}
+	if !model.LabelName(c.Key).IsValid() {
+		return errors.Errorf("invalid key label %q", c.Key)
+	}
+	for _, n := range c.Labels {
+		if !model.LabelName(n).IsValid() {
+			return errors.Errorf("invalid label %q", n)
+		}
+	}
+	for n := range c.Default {
+		if !model.LabelName(n).IsValid() {
+			return errors.Errorf("invalid default label %q", n)
+		}
+	}
+	return nil
+}
+
+// enricher adds the labels of the lookup table to the series by the value of their key label. The looked up labels
+// replace the labels of the series of the same name. Empty values of the table add no label.
+type enricher struct {
+	key   string
+	table map[string]labels.Labels
+	def   labels.Labels
+}
+
+// loadEnricher reads the lookup table of the config.
+func loadEnricher(c enrichConfig) (*enricher, error) {
+	f, err := os.Open(c.File)
+	if err != nil {
+		return nil, errors.Wrap(err, "open lookup file")
+	}
+	defer f.Close()
+
+	column := c.Column
+	if column == "" {
+		column = c.Key
+	}
+	var t lookupTable
+	if strings.EqualFold(filepath.Ext(c.File), ".json") {
+		t, err = readJSONLookup(f, column)
+	} else {
+		t, err = readCSVLookup(f, column)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "read lookup file %s", c.File)
+	}
+
+	e := &enricher{key: c.Key, table: make(map[string]labels.Labels, len(t)), def: labels.FromMap(c.Default)}
+	for key, row := range t {
+		m := map[string]string{}
+		for n, v := range row {
+			if v == "" || n == column || (len(c.Labels) > 0 && !contains(c.Labels, n)) {
+				continue
+			}
+			if !model.LabelName(n).IsValid() {
+				return nil, errors.Errorf("lookup file %s: column %q is not a valid label name", c.File, n)
+			}
+			m[n] = v
+		}
+		e.table[key] = labels.FromMap(m)
+	}
+	return e, nil
+}
+
+// loadEnrichers reads the lookup tables of the configs, in order.
+func loadEnrichers(cs []enrichConfig) ([]*enricher, error) {
+	ret := make([]*enricher, 0, len(cs))
+	for i, c := range cs {
+		e, err := loadEnricher(c)
+		if err != nil {
+			return nil, errors.Wrapf(err, "enrichments: %d", i)
+		}
+		ret = append(ret, e)
+	}
+	return ret, nil
+}
+
+func contains(ss []string, s string) bool {
+	for _, x := range ss {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// labels returns the labels of the series enriched by the table, or the default.
+func (e *enricher) labels(lset labels.Labels) labels.Labels {
+	add, ok := e.table[lset.Get(e.key)]
+	if !ok || !lset.Has(e.key) {
+		add = e.def
+	}
+	if len(add) == 0 {
+		return lset
+	}
+	b := labels.NewBuilder(lset)
+	for _, l := range add {
+		b.Set(l.Name, l.Value)
+	}
+	return b.Labels()
+}
+
+// lookupTable are the rows of the lookup file, the values by column name, by the value of the key column.
+type lookupTable map[string]map[string]string
+
+// add adds the row by the value of its key column.
+func (t lookupTable) add(row map[string]string, column string) error {
+	key, ok := row[column]
+	if !ok {
+		return errors.Errorf("no key column %q", column)
+	}
+	if _, ok := t[key]; ok {
+		return errors.Errorf("duplicate key %q", key)
+	}
+	t[key] = row
+	return nil
+}
+
+func readCSVLookup(r io.Reader, column string) (lookupTable, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, errors.New("no header row")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !contains(header, column) {
+		return nil, errors.Errorf("no key column %q in the header", column)
+	}
+
+	t := lookupTable{}
+	for n := 1; ; n++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return t, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		row := make(map[string]string, len(header))
+		for i, name := range header {
+			row[name] = rec[i]
+		}
+		if err := t.add(row, column); err != nil {
+			return nil, errors.Wrapf(err, "row %d", n)
+		}
+	}
+}
+
+func readJSONLookup(r io.Reader, column string) (lookupTable, error) {
+	d := json.NewDecoder(r)
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+
+	t := lookupTable{}
+	switch v := v.(type) {
+	case []interface{}:
+		for i, o := range v {
+			row, err := jsonRow(o)
+			if err != nil {
+				return nil, errors.Wrapf(err, "row %d", i)
+			}
+			if err := t.add(row, column); err != nil {
+				return nil, errors.Wrapf(err, "row %d", i)
+			}
+		}
+	case map[string]interface{}:
+		for key, o := range v {
+			row, err := jsonRow(o)
+			if err != nil {
+				return nil, errors.Wrapf(err, "key %q", key)
+			}
+			row[column] = key
+			t[key] = row
+		}
+	default:
+		return nil, errors.New("expected an array of objects or an object of the labels by key")
+	}
+	return t, nil
+}
+
+// jsonRow returns the values of the object, formatting the numbers and booleans. Null values are empty.
+func jsonRow(o interface{}) (map[string]string, error) {
+	m, ok := o.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("expected an object")
+	}
+	row := make(map[string]string, len(m))
+	for n, v := range m {
+		switch v := v.(type) {
+		case nil:
+			row[n] = ""
+		case string:
+			row[n] = v
+		case json.Number, bool:
+			row[n] = fmt.Sprint(v)
+		default:
+			return nil, errors.Errorf("value of %q is not a string, number or boolean", n)
+		}
+	}
+	return row, nil
+}
+
+// enrichSet applies the enrichers in order to the labels of every series, so that an enricher can look up a label
+// added by a previous one.
+type enrichSet struct {
+	series.Set
+
+	enrichers []*enricher
+	cur       storage.Series
+}
+
+func newEnrichSet(s series.Set, enrichers []*enricher) series.Set {
+	if len(enrichers) == 0 {
+		return s
+	}
+	return &enrichSet{Set: s, enrichers: enrichers}
+}
+
+func (s *enrichSet) Next() bool {
+	if !s.Set.Next() {
+		return false
+	}
+	ser := s.Set.At()
+	lset := ser.Labels()
+	for _, e := range s.enrichers {
+		lset = e.labels(lset)
+	}
+	s.cur = relabeledSeries{Series: ser, lset: lset}
+	return true
+}
+
+func (s *enrichSet) At() storage.Series { return s.cur }
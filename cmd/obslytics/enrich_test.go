@@ -0,0 +1,80 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestEnrichSet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "enrich")
+	testutil.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	teams := filepath.Join(dir, "teams.csv")
+	testutil.Ok(t, ioutil.WriteFile(teams, []byte("host,team,cost_center\na:9090,storage,42\nb:9090,,7\n"), 0600))
+	owners := filepath.Join(dir, "owners.json")
+	testutil.Ok(t, ioutil.WriteFile(owners, []byte(`{"storage": {"owner": "alice", "oncall": true}}`), 0600))
+
+	enrichers, err := loadEnrichers([]enrichConfig{
+		{File: teams, Key: "instance", Column: "host", Default: map[string]string{"team": "unknown"}},
+		// Looks up the label added by the previous enrichment.
+		{File: owners, Key: "team", Labels: []string{"owner"}},
+	})
+	testutil.Ok(t, err)
+
+	s := newEnrichSet(&listSet{series: []storage.Series{
+		storage.NewListSeries(labels.FromStrings("__name__", "up", "instance", "a:9090"), nil),
+		// The looked up labels replace the labels of the series, empty values add no label.
+		storage.NewListSeries(labels.FromStrings("__name__", "up", "instance", "b:9090", "team", "db"), nil),
+		storage.NewListSeries(labels.FromStrings("__name__", "up", "instance", "c:9090"), nil),
+		storage.NewListSeries(labels.FromStrings("__name__", "up"), nil),
+	}, i: -1}, enrichers)
+
+	var got []labels.Labels
+	for s.Next() {
+		got = append(got, s.At().Labels())
+	}
+	testutil.Equals(t, []labels.Labels{
+		labels.FromStrings("__name__", "up", "cost_center", "42", "instance", "a:9090", "owner", "alice", "team", "storage"),
+		labels.FromStrings("__name__", "up", "cost_center", "7", "instance", "b:9090", "team", "db"),
+		labels.FromStrings("__name__", "up", "instance", "c:9090", "team", "unknown"),
+		labels.FromStrings("__name__", "up", "team", "unknown"),
+	}, got)
+}
+
+func TestLoadEnricher_Invalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "enrich")
+	testutil.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, c := range []struct {
+		name, content string
+	}{
+		{name: "no-key.csv", content: "host,team\na,x\n"},
+		{name: "duplicate.csv", content: "instance,team\na,x\na,y\n"},
+		{name: "invalid-label.csv", content: "instance,team-name\na,x\n"},
+		{name: "empty.csv", content: ""},
+		{name: "rows.json", content: `[{"instance": "a", "team": "x"}, {"team": "y"}]`},
+		{name: "nested.json", content: `{"a": {"team": {"name": "x"}}}`},
+		{name: "scalar.json", content: `"a"`},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			file := filepath.Join(dir, c.name)
+			testutil.Ok(t, ioutil.WriteFile(file, []byte(c.content), 0600))
+			_, err := loadEnricher(enrichConfig{File: file, Key: "instance"})
+			testutil.NotOk(t, err)
+		})
+	}
+
+	file := filepath.Join(dir, "rows.json")
+	testutil.Ok(t, ioutil.WriteFile(file, []byte(`[{"instance": "a", "team": "x", "tier": 1}, {"instance": "b", "team": null}]`), 0600))
+	e, err := loadEnricher(enrichConfig{File: file, Key: "instance"})
+	testutil.Ok(t, err)
+	testutil.Equals(t, map[string]labels.Labels{"a": labels.FromStrings("team", "x", "tier", "1"), "b": {}}, e.table)
+}
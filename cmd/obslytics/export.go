@@ -2,20 +2,28 @@ package main
 
 import (
 	"context"
-	"fmt"
 	"os"
+	"path"
+	"strings"
 	"time"
 
 	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
 	"github.com/oklog/run"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/relabel"
 	"github.com/prometheus/prometheus/pkg/timestamp"
 	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/prometheus/prometheus/storage"
 	"github.com/thanos-community/obslytics/pkg/dataframe"
 	"github.com/thanos-community/obslytics/pkg/exporter"
 	"github.com/thanos-community/obslytics/pkg/series"
+	"github.com/thanos-community/obslytics/pkg/series/memory"
 	"github.com/thanos-io/thanos/pkg/model"
 	"github.com/thanos-io/thanos/pkg/objstore/client"
+	"google.golang.org/grpc"
 	"gopkg.in/yaml.v2"
 
 	"github.com/thanos-io/thanos/pkg/extflag"
@@ -25,108 +33,954 @@ import (
 	infactory "github.com/thanos-community/obslytics/pkg/series/factory"
 )
 
+// exportOptions defines what data is exported and how.
+type exportOptions struct {
+	matchers string
+
+	mint, maxt                   model.TimeOrDurationValue
+	mintExclusive, maxtExclusive bool
+
+	resolution time.Duration
+	snapshot   bool
+	seriesID   bool
+	// metricColumn is the column of the metric name, none if empty.
+	metricColumn string
+	// tidy writes the tidy table of the timestamp, metric and value of the tidyValue function, with the tidyDimensions
+	// labels as columns and the other labels packed into the tidyPackedLabels column, or dropped.
+	tidy             bool
+	tidyValue        string
+	tidyDimensions   []string
+	tidyPackedLabels string
+
+	// functions are the aggregation functions computed per window, each into its own column, see aggregated.
+	functions                          []string
+	first, last                        bool
+	countDistinct, countDistinctApprox bool
+	emptyWindows                       bool
+	summaries                          bool
+	scrapeInterval                     bool
+	sampleCount                        bool
+	delta, deltaPerSecond              bool
+	deltaFirst                         string
+	rateUnit                           string
+	roundTo                            float64
+	roundMode                          string
+	downsample                         bool
+	exemplars                          bool
+	// exemplarTraces writes the exemplars as the table of their trace IDs, by exemplarTraceLabel, joinable to the
+	// output by the _series_id.
+	exemplarTraces     bool
+	exemplarTraceLabel string
+	debug              bool
+	// schemaSeries, if positive, turns the export into printing the output schema computed from the labels of at
+	// most this many series, without reading the samples.
+	schemaSeries int
+
+	// order is the time order of the exported rows, see dataframe.Order.
+	order string
+	// topSeries, if positive, limits the export to this many series ranked highest by topBy, see dataframe.TopSeries.
+	topSeries int
+	topBy     string
+
+	// readWindow, if set, splits the read into consecutive reads of the time range, aligned to the resolution.
+	readWindow time.Duration
+
+	// shardBy, if set, splits the read into one per value of the label. The shards are exported into separate
+	// outputs (with the output path as a template), or merged into a single one if shardMerge is set.
+	shardBy          string
+	shardMerge       bool
+	shardConcurrency int
+
+	// splitSeries exports every series into its own output, with the output path as a template. At most
+	// maxSeriesFiles outputs are written.
+	splitSeries    bool
+	maxSeriesFiles int
+	// partitionBy exports the series of every value of the label into its own output, with the output path as a
+	// template. At most maxPartitionFiles outputs are written.
+	partitionBy       string
+	maxPartitionFiles int
+	// outputWriters is the maximum number of the files of splitSeries and partitionBy written in parallel.
+	outputWriters int
+
+	// seriesFile lists the exact series to export, loaded into the allowlist the read series are filtered by.
+	seriesFile string
+	allowlist  *seriesAllowlist
+
+	// relabelConfigs are applied to the series labels before aggregation.
+	relabelConfigs []*relabel.Config
+	// enrichers add the labels looked up by the labels of the series, after relabeling.
+	enrichers []*enricher
+	// staticLabels are added to every series after relabeling, enrichment and sampling.
+	staticLabels map[string]string
+	// sampleSeries is the fraction of the series exported, selected by their fingerprint after relabeling and
+	// enrichment.
+	sampleSeries float64
+	// changedOnly drops the series with all the samples within changedEpsilon of the first one, see
+	// series.NewVaryingSet.
+	changedOnly    bool
+	changedEpsilon float64
+	// cardinalityLimits cap the distinct values of the labels of the exported series, failing the export or
+	// dropping the series over the limit by cardinalityAction. The guard tracking the values is created per export.
+	cardinalityLimits map[string]int
+	cardinalityAction string
+	cardinality       *cardinalityGuard
+	// maxSeriesLabels, if positive, limits the number of labels of the exported series, failing the export or
+	// dropping the series over the limit by maxSeriesLabelsAction. The guard is created per export.
+	maxSeriesLabels       int
+	maxSeriesLabelsAction string
+	labelLimit            *labelLimitGuard
+	// overrides select the sample function per series, from the config only.
+	overrides []dataframe.AggrOverride
+	// units convert the aggregated values by metric name.
+	units map[string]dataframe.UnitConversion
+
+	// progress, if set, receives the estimated fraction of the read completed, see series.Progress. logProgress logs
+	// the estimate every 10%. The estimate is created per export.
+	progress     series.ProgressFunc
+	logProgress  bool
+	readProgress *series.Progress
+
+	// summary, if set, receives the summary of the export.
+	summary *summaryWriter
+	// seen, if set, skips the samples exported by the previous polls in follow mode.
+	seen *seenSamples
+	// metrics, if set, instrument the export and the input gRPC client.
+	metrics *exportMetrics
+	// audit, if set, receives the StoreAPI requests of the input as sent.
+	audit *auditWriter
+}
+
+// dialOptions returns the options of the input gRPC client, instrumenting and auditing its requests.
+func (opts exportOptions) dialOptions() []grpc.DialOption {
+	return append(opts.metrics.dialOptions(), opts.audit.dialOptions()...)
+}
+
 func registerExport(m map[string]setupFunc, app *kingpin.Application) {
 	cmd := app.Command("export", "Export observability series data into popular analytics formats.")
-	inputFlag := extflag.RegisterPathOrContent(cmd, "input-config", "YAML for input, series configuration.", true)
+	configFile := cmd.Flag("config", "YAML file configuring the whole export (input, output, matchers, time range, aggregations, relabeling). "+
+		"Flags given on the command line take precedence over the file values").PlaceHolder("<file-path>").String()
+	inputFlag := extflag.RegisterPathOrContent(cmd, "input-config", "YAML for input, series configuration. Required unless given by config.", false)
 	outputFlag := extflag.RegisterPathOrContent(cmd, "output-config", "YAML for dataframe export configuration.", false)
 
+	opts := exportOptions{}
+	set := setFlags{}
 	// TODO(bwplotka): Describe more how the format looks like.
-	matchersStr := cmd.Flag("match", "Metric matcher for metrics to export (e.g up{a=\"1\"}").Required().String()
-	timeFmt := time.RFC3339
+	matchers := cmd.Flag("match", "Metric matcher for metrics to export (e.g up{a=\"1\"}. Can be repeated to export each matcher into a separate file, "+
+		"in which case the output path is a template, e.g. \"{{.Name}}.parquet\" (see also concurrency flag). Required unless given by config").Strings()
+	concurrency := set.flag(cmd, "concurrency", "Maximum number of exports (or shard reads) running in parallel when multiple matchers "+
+		"or shard-by are given").Default("1").Int()
+	timeRange := registerTimeRangeFlags(cmd)
 
-	var mint, maxt model.TimeOrDurationValue
-	cmd.Flag("min-time", fmt.Sprintf("The lower boundary of the time series in %s or duration format", timeFmt)).
-		Required().SetValue(&mint)
+	set.flag(cmd, "min-time-exclusive", "Exclude samples exactly at min-time").BoolVar(&opts.mintExclusive)
+	set.flag(cmd, "max-time-exclusive", "Exclude samples exactly at max-time").BoolVar(&opts.maxtExclusive)
 
-	cmd.Flag("max-time", fmt.Sprintf("The upper boundary of the time series in %s or duration format", timeFmt)).
-		Required().SetValue(&maxt)
+	set.flag(cmd, "resolution", "Sample resolution (e.g. 30m). Required unless given by config").DurationVar(&opts.resolution)
+	cmd.Flag("debug", "Show additional debug info (such as produced table)").BoolVar(&opts.debug)
+	set.flag(cmd, "snapshot", "Export only the latest sample of every series at or before max-time").BoolVar(&opts.snapshot)
+	set.flag(cmd, "series-id", "Add a _series_id column with a stable fingerprint of the series labels").BoolVar(&opts.seriesID)
+	set.flag(cmd, "metric-column", "Name of the column with the metric name (the __name__ label) of the series, placed before the label "+
+		"columns, e.g. metric. Empty omits the metric name from the output. Summaries have the _summary column instead").
+		Default(labels.MetricName).StringVar(&opts.metricColumn)
+	set.flag(cmd, "aggregation", "Aggregation function computed for every series and resolution window into a column of its own: "+
+		"count, sum, min, max, avg (sum divided by count), first or last, e.g. _avg. Repeatable, e.g. --aggregation=min "+
+		"--aggregation=max --aggregation=avg. The run summary counts the exported samples only with count").
+		Default(defaultFunctions...).EnumsVar(&opts.functions, aggregationFunctions...)
+	set.flag(cmd, "tidy", "Write a tidy table with the same columns for all the metrics: timestamp (the window start), metric, value "+
+		"(of tidy-value), followed by the tidy-dimension labels, null for the series without them. The other labels are dropped, "+
+		"or packed into the tidy-packed-labels column").BoolVar(&opts.tidy)
+	set.flag(cmd, "tidy-value", "Aggregation function written to the value column by tidy, computed even if not selected by aggregation").
+		Default("avg").EnumVar(&opts.tidyValue, aggregationFunctions...)
+	set.flag(cmd, "tidy-dimension", "Label written to a column of its own by tidy. Repeatable, the columns follow the order of the flags").
+		StringsVar(&opts.tidyDimensions)
+	set.flag(cmd, "tidy-packed-labels", "Column the labels other than the tidy-dimension ones are packed into by tidy, as a JSON object "+
+		"of the values by label name, e.g. labels. The labels are dropped if empty").StringVar(&opts.tidyPackedLabels)
+	set.flag(cmd, "first", "Add a _first column with the value of the earliest sample in each resolution window").BoolVar(&opts.first)
+	set.flag(cmd, "last", "Add a _last column with the value of the latest sample in each resolution window").BoolVar(&opts.last)
+	set.flag(cmd, "count-distinct", "Add a _count_distinct column with the number of distinct sample values in each resolution window").BoolVar(&opts.countDistinct)
+	set.flag(cmd, "count-distinct-approximate", "Estimate the count distinct using HyperLogLog in constant memory (~1.6% standard error) "+
+		"instead of keeping every distinct value of the window in memory").BoolVar(&opts.countDistinctApprox)
+	set.flag(cmd, "empty-windows", "Emit rows with zero count and NaN min/max for the resolution windows without samples between the first "+
+		"and the last sample of each series. By default, such windows are omitted").BoolVar(&opts.emptyWindows)
+	set.flag(cmd, "summaries", "Group the series of classic summary metrics (quantile, _sum and _count series) into a row per summary and "+
+		"resolution window, with the latest sum, count and quantile values of the window as columns. Other series are skipped").BoolVar(&opts.summaries)
+	set.flag(cmd, "scrape-interval", "Add a _scrape_interval column with the native sample interval of every series in seconds (median "+
+		"delta between its samples) and a _step column with the resolution in seconds, to interpret the aggregated values").BoolVar(&opts.scrapeInterval)
+	set.flag(cmd, "sample-count", "Add a _sample_count column with the number of raw samples each resolution window is aggregated from, "+
+		"to judge the reliability of the aggregated values. Equal to _count for raw data, summed from the counts of the downsampled "+
+		"aggregates for downsampled data (see downsample)").BoolVar(&opts.sampleCount)
+	set.flag(cmd, "delta", "Aggregate the difference between consecutive samples of every series instead of the sample values, e.g. the "+
+		"per-step change of a gauge. Decreases are kept as negative deltas, unlike counter rates").BoolVar(&opts.delta)
+	set.flag(cmd, "delta-per-second", "Divide the delta by the seconds elapsed since the previous sample, giving the derivative").BoolVar(&opts.deltaPerSecond)
+	set.flag(cmd, "delta-first", "How the first sample of every series in the range, without previous sample, is handled by delta: "+
+		"omit or zero").Default(string(series.DeltaFirstOmit)).EnumVar(&opts.deltaFirst, string(series.DeltaFirstOmit), string(series.DeltaFirstZero))
+	set.flag(cmd, "rate-unit", "Time unit the per-second deltas (delta-per-second, and the rate and derivative functions of the overrides) are "+
+		"normalized to: second, minute or hour. Every delta is divided by the time elapsed since its previous sample, and the avg "+
+		"of such deltas is weighted by the time they span, so irregularly spaced samples give the increase of the window divided by "+
+		"its time. Once set, their unit, e.g. bytes/s, is written to the _unit column. Second by default").EnumVar(&opts.rateUnit,
+		string(series.RateUnitSecond), string(series.RateUnitMinute), string(series.RateUnitHour))
+	set.flag(cmd, "round-to", "Round the aggregated values (sum, min, max, avg, first and last) to multiples of the granularity, e.g. 10 or "+
+		"0.01, for k-anonymity style exports or better compression. Disabled if 0").Default("0").Float64Var(&opts.roundTo)
+	set.flag(cmd, "round-mode", "Direction of round-to: nearest (halves away from zero), floor or ceil").Default(string(dataframe.RoundNearest)).
+		EnumVar(&opts.roundMode, string(dataframe.RoundNearest), string(dataframe.RoundFloor), string(dataframe.RoundCeil))
+	set.flag(cmd, "order", "Time order of the exported rows: asc, desc (the latest window of every series first) or desc-series (desc, "+
+		"with the series ordered by their latest window, latest first). The rows are reordered once all the series are read and "+
+		"aggregated, as the samples are read in ascending order").Default(string(dataframe.OrderAscending)).
+		EnumVar(&opts.order, string(dataframe.OrderAscending), string(dataframe.OrderDescending), string(dataframe.OrderDescendingSeries))
+	set.flag(cmd, "top-series", "Export only the series ranked highest by top-series-by, e.g. the busiest series for reports, ordered by "+
+		"rank (highest first) unless reordered by order. The series are ranked once all of them are read and aggregated, only the "+
+		"ranks are computed on top of the aggregated rows held in memory. Applies to every export (job, shard or follow poll) on its "+
+		"own. 0 means no limit").Default("0").IntVar(&opts.topSeries)
+	set.flag(cmd, "top-series-by", "Metric the series are ranked by for top-series: count (total number of samples), max (maximum "+
+		"value) or last (value of the latest window, requires last)").Default(string(dataframe.TopByCount)).
+		EnumVar(&opts.topBy, string(dataframe.TopByCount), string(dataframe.TopByMax), string(dataframe.TopByLast))
+	set.flag(cmd, "downsample", "Allow the input to serve data downsampled server-side up to the resolution (StoreAPI only). "+
+		"The coarsest downsampling level not coarser than the resolution (5m or 1h) is requested from stores holding downsampled "+
+		"data, as told by their Info, falling back to raw data for finer resolutions, sidecars, rulers and receivers. The "+
+		"downsampled data is read as the averages of the downsampling windows. _count, _sum, _min, _max and _avg are of the raw "+
+		"samples, read from the count, sum, min and max aggregates, the other aggregations are of the averages").BoolVar(&opts.downsample)
+	set.flag(cmd, "exemplars", "Export also exemplars of the matched series into a separate file next to the output, e.g. out-exemplars.parquet "+
+		"(StoreAPI only, skipped when the endpoint does not support exemplars)").BoolVar(&opts.exemplars)
+	set.flag(cmd, "exemplar-traces", "Write the exemplars as a table of the traces they link to, with the same columns for all the "+
+		"series: _timestamp, _value, trace_id and the _series_id of the series, joinable to the _series_id of the output (requires "+
+		"exemplars and series-id). The exemplars without the exemplar-trace-label are skipped").BoolVar(&opts.exemplarTraces)
+	set.flag(cmd, "exemplar-trace-label", "Exemplar label holding the trace ID written by exemplar-traces, e.g. traceID").
+		Default("trace_id").StringVar(&opts.exemplarTraceLabel)
+	set.flag(cmd, "read-window", "Read the time range in consecutive windows of the duration, e.g. 1d, to bound the data read by every "+
+		"request. Has to be a multiple of the resolution, the read windows are aligned to the resolution windows so that every row is "+
+		"aggregated from a single read. Disabled if 0").Default("0").DurationVar(&opts.readWindow)
+	set.flag(cmd, "shard-by", "Split the export into one read per value of the label, e.g. instance, running up to concurrency in parallel. "+
+		"Every shard is exported into a separate file, in which case the output path is a template, e.g. \"{{.Shard}}.parquet\"").StringVar(&opts.shardBy)
+	set.flag(cmd, "split-series", "Export every series into its own file, in which case the output path is a template using the series "+
+		"labels, e.g. \"{{.Name}}/{{.Labels.instance}}.parquet\" or \"{{.Fingerprint}}.parquet\" (PARQUET output only)").BoolVar(&opts.splitSeries)
+	set.flag(cmd, "split-series-max-files", "Maximum number of files written by split-series, the export fails without writing anything "+
+		"if there are more series. 0 means no limit").Default("10000").IntVar(&opts.maxSeriesFiles)
+	set.flag(cmd, "partition-by", "Export the series of every value of the label, e.g. namespace, into its own file, in which case the "+
+		"output path is a template, e.g. \"{{.Partition}}.parquet\", with the value escaped to a single path element. The series "+
+		"without the label are exported with the empty value. "+
+		"Unlike shard-by, the series are read and aggregated at once and partitioned before writing, up to output-writers files in "+
+		"parallel. File outputs only").StringVar(&opts.partitionBy)
+	set.flag(cmd, "partition-max-files", "Maximum number of files written by partition-by, the export fails without writing anything "+
+		"if there are more label values. 0 means no limit").Default("1000").IntVar(&opts.maxPartitionFiles)
+	set.flag(cmd, "output-writers", "Maximum number of the files of split-series and partition-by written in parallel, every file by a "+
+		"single writer. Once any of them fails, no more files are started, and the uploads of the files being written are canceled "+
+		"if the object storage supports it (FILESYSTEM writes them until the end)").Default("1").IntVar(&opts.outputWriters)
+	set.flag(cmd, "series-file", "File with the exact series to export, one label set per line, e.g. up{instance=\"a:9090\",job=\"prometheus\"}. "+
+		"The series read by the matchers are filtered to them, without matchers all the series of their metrics are read").StringVar(&opts.seriesFile)
+	set.flag(cmd, "shard-merge", "Merge the shards given by shard-by into a single output").BoolVar(&opts.shardMerge)
+	set.flag(cmd, "sample-series", "Export only the fraction of the series, e.g. 0.1, selected by the fingerprint of their labels (the "+
+		"_series_id) after relabeling and enrichment, so that the same subset is exported across runs. 1 exports all the series").Default("1").Float64Var(&opts.sampleSeries)
+	set.flag(cmd, "changed-only", "Export only the series changing within the time range, dropping the series with all the samples "+
+		"equal to the first one (within changed-epsilon), e.g. to export only the changes of config or state metrics. The samples "+
+		"of every series are scanned before aggregation: the constant series are decoded whole and dropped, the scan of the others "+
+		"stops at their first change. Cannot be used with read-window, follow or snapshot, which read a part of the range").BoolVar(&opts.changedOnly)
+	set.flag(cmd, "changed-epsilon", "Maximum difference from the first sample of the series still considered unchanged by "+
+		"changed-only, e.g. 0.01 for noisy gauges").Default("0").Float64Var(&opts.changedEpsilon)
+	set.flag(cmd, "static-label", "Label added to every exported series, e.g. env=prod or extraction_id=42, to tag the export with "+
+		"run metadata. Added after relabeling, enrichment and sample-series, replacing the label of the same name of the series, "+
+		"and part of the _series_id fingerprint. Can be repeated for multiple labels").PlaceHolder("<label>=<value>").StringMapVar(&opts.staticLabels)
+	cardinalityLimits := set.flag(cmd, "cardinality-limit", "Maximum number of distinct values of the label in the exported series, e.g. "+
+		"pod=1000, to protect downstream schemas from a runaway label. Can be repeated for multiple labels").PlaceHolder("<label>=<limit>").StringMap()
+	set.flag(cmd, "cardinality-action", "What to do once a label exceeds its cardinality limit: abort the export, or drop the series "+
+		"bringing new values of the label").Default(cardinalityAbort).EnumVar(&opts.cardinalityAction, cardinalityAbort, cardinalityDrop)
+	set.flag(cmd, "max-series-labels", "Maximum number of labels of the exported series, after relabeling and enrichment, to bound the "+
+		"width of the output schema, which has a column per label. A limit (e.g. 50) is recommended for outputs loaded into "+
+		"warehouses, where a few pathological series can add hundreds of sparse columns. 0 means no limit").Default("0").IntVar(&opts.maxSeriesLabels)
+	set.flag(cmd, "max-series-labels-action", "What to do with a series over max-series-labels: abort the export naming the series, or drop "+
+		"the series").Default(cardinalityAbort).EnumVar(&opts.maxSeriesLabelsAction, cardinalityAbort, cardinalityDrop)
+	schemaOnly := cmd.Flag("schema-only", "Print the output columns and their types computed from the labels of the series, without reading "+
+		"the samples nor writing the output, e.g. to catch label filter mistakes before a long run").Bool()
+	schemaSeries := cmd.Flag("schema-only-series", "Maximum number of series whose labels are read by schema-only").Default("1000").Int()
+	set.flag(cmd, "log-progress", "Log the estimated progress of the read every 10%, by the timestamp of the latest sample read "+
+		"relative to the time range. The estimate is approximate: it is accurate for reads split by read-window, but reaches 100% "+
+		"with the first series spanning the whole range otherwise").BoolVar(&opts.logProgress)
+	printSummary := cmd.Flag("summary", "Print the JSON summary of every export (counts, written outputs, duration) to stderr").Bool()
+	summaryFile := cmd.Flag("summary-file", "Write the JSON summary of every export into the file instead of stderr").String()
+	auditFile := cmd.Flag("audit-file", "Write the effective StoreAPI Series requests (matchers, time range, resolution, "+
+		"partial response strategy), with the endpoint, its resolved peer address, the tenant, the job and the output path, into the file as JSON lines before streaming, for auditing. "+
+		"STOREAPI input only").String()
+	validateOutput := cmd.Flag("validate-output", "Read the written files back and check they are complete (same as validate output option)").Bool()
+	followMode := cmd.Flag("follow", "Keep exporting the trailing window every poll-interval until interrupted, skipping the samples exported "+
+		"by previous polls. Only complete resolution windows are exported. File outputs get a new file per poll, suffixed with the poll end "+
+		"(e.g. out-1617235200000.parquet). If min-time or since is given, the first poll starts there").Bool()
+	fopts := followOptions{}
+	cmd.Flag("poll-interval", "Time between the polls in follow mode").Default("1m").DurationVar(&fopts.interval)
+	cmd.Flag("lookback", "How far back every poll reads in follow mode, so that late samples are still exported").Default("10m").DurationVar(&fopts.lookback)
 
-	resolution := cmd.Flag("resolution", "Sample resolution (e.g. 30m)").Required().Duration()
-	dbgOut := cmd.Flag("debug", "Show additional debug info (such as produced table)").Bool()
+	m["export"] = func(g *run.Group, logger log.Logger, reg *prometheus.Registry) error {
+		var (
+			cfg pipelineConfig
+			err error
+		)
+		opts.metrics = newExportMetrics(reg)
+		if *schemaOnly {
+			if *schemaSeries <= 0 {
+				return errors.New("schema-only-series has to be positive")
+			}
+			opts.schemaSeries = *schemaSeries
+		}
+		if opts.cardinalityLimits, err = parseCardinalityLimits(*cardinalityLimits); err != nil {
+			return err
+		}
+		if err := validateStaticLabels(opts.staticLabels); err != nil {
+			return err
+		}
+		var jobs []job
+		if *configFile != "" {
+			if cfg, err = loadPipelineConfig(*configFile); err != nil {
+				return err
+			}
+			cfg.apply(&opts, set)
+			if opts.enrichers, err = loadEnrichers(cfg.Enrichments); err != nil {
+				return err
+			}
+			timeRange.setDefaults(cfg.MinTime, cfg.MaxTime, cfg.AllowLargeRange)
+			if len(*matchers) == 0 {
+				*matchers = cfg.Match
+			}
+			if !set.isSet("concurrency") && cfg.Concurrency > 0 {
+				*concurrency = cfg.Concurrency
+			}
+			if len(cfg.Jobs) > 0 {
+				if jobs, err = cfg.resolveJobs(opts, *matchers, *timeRange, time.Now()); err != nil {
+					return err
+				}
+			}
+		}
+		if opts.seriesFile != "" {
+			if opts.allowlist, err = loadSeriesAllowlist(opts.seriesFile); err != nil {
+				return err
+			}
+			for i := range jobs {
+				jobs[i].opts.allowlist = opts.allowlist
+			}
+		}
+		if opts.deltas() && opts.snapshot {
+			return errors.New("delta (or override functions other than value) cannot be used with snapshot, there is no previous sample")
+		}
+		if opts.roundTo < 0 {
+			return errors.New("round-to cannot be negative")
+		}
+		if opts.sampleSeries <= 0 || opts.sampleSeries > 1 {
+			return errors.Errorf("sample-series has to be in (0, 1], got %v", opts.sampleSeries)
+		}
+		if err := opts.validateReadWindow(); err != nil {
+			return err
+		}
+		if opts.changedEpsilon < 0 {
+			return errors.New("changed-epsilon cannot be negative")
+		}
+		if opts.changedOnly && (opts.readWindow > 0 || *followMode || opts.snapshot) {
+			return errors.New("changed-only cannot be used with read-window, follow or snapshot")
+		}
+		if err := validateLabelLimit(opts.maxSeriesLabels, opts.maxSeriesLabelsAction); err != nil {
+			return err
+		}
+		if opts.maxPartitionFiles < 0 {
+			return errors.New("partition-max-files cannot be negative")
+		}
+		if opts.outputWriters <= 0 {
+			return errors.New("output-writers has to be positive")
+		}
+		if opts.scrapeInterval && opts.summaries {
+			return errors.New("scrape-interval cannot be used with summaries")
+		}
+		if opts.sampleCount && opts.summaries {
+			return errors.New("sample-count cannot be used with summaries")
+		}
+		if err := opts.validateTopSeries(); err != nil {
+			return err
+		}
+		if opts.exemplarTraces {
+			switch {
+			case !opts.exemplars || !opts.seriesID:
+				return errors.New("exemplar-traces requires exemplars and series-id, the traces are joined to the output by the _series_id")
+			case opts.exemplarTraceLabel == "":
+				return errors.New("exemplar-trace-label cannot be empty")
+			}
+		}
+		if opts.tidy {
+			switch {
+			case opts.summaries || opts.splitSeries:
+				return errors.New("tidy cannot be used with summaries or split-series")
+			case opts.metricColumn == "":
+				return errors.New("tidy requires the metric name column, metric-column cannot be empty")
+			case opts.exemplarTraces:
+				return errors.New("tidy cannot be used with exemplar-traces, the tidy table has no _series_id to join the traces by")
+			}
+		}
+		if len(jobs) == 0 {
+			if len(*matchers) == 0 && opts.allowlist != nil {
+				sel, err := opts.allowlist.selector()
+				if err != nil {
+					return err
+				}
+				*matchers = []string{sel}
+			}
+			if len(*matchers) == 0 {
+				return errors.New("at least one matcher is required, given by match flag or config")
+			}
+			if opts.resolution <= 0 {
+				return errors.New("positive resolution is required, given by resolution flag or config")
+			}
+			if !*followMode || timeRange.hasMin() {
+				if opts.mint, opts.maxt, err = timeRange.resolve(time.Now()); err != nil {
+					return err
+				}
+			}
+		}
+		if *followMode {
+			switch {
+			case len(jobs) > 0 || len(*matchers) > 1:
+				return errors.New("follow mode can be used only with a single matcher")
+			case opts.shardBy != "" && !opts.shardMerge:
+				return errors.New("follow mode requires shard-merge with shard-by")
+			case opts.snapshot:
+				return errors.New("follow mode cannot be used with snapshot")
+			case opts.splitSeries || opts.partitionBy != "":
+				return errors.New("follow mode cannot be used with split-series or partition-by")
+			case opts.schemaSeries > 0:
+				return errors.New("follow mode cannot be used with schema-only")
+			case opts.deltas():
+				// Every poll would miss the previous sample of its first sample.
+				return errors.New("follow mode cannot be used with delta (or override functions other than value)")
+			}
+			if timeRange.hasMin() {
+				start := timestamp.Time(opts.mint.PrometheusTimestamp())
+				fopts.start = &start
+			}
+		}
 
-	m["export"] = func(g *run.Group, logger log.Logger) error {
 		ctx, cancel := context.WithCancel(context.Background())
 		g.Add(func() error {
-			inputCfg, err := inputFlag.Content()
+			inputConfig, err := parseInputConfig(inputFlag, cfg.Input)
 			if err != nil {
 				return err
 			}
 
-			inputConfig := series.Config{}
-			if err := yaml.UnmarshalStrict(inputCfg, &inputConfig); err != nil {
-				return err
-			}
-
-			outputCfg, err := outputFlag.Content()
+			outputConfig, err := parseOutputConfig(outputFlag, cfg.Output)
 			if err != nil {
 				return err
 			}
+			outputConfig.Validate = outputConfig.Validate || *validateOutput
+			if opts.exemplars && exporter.Type(strings.ToUpper(string(outputConfig.Type))) != exporter.PARQUET {
+				return errors.Errorf("exemplars can only be exported to PARQUET output, not %v", outputConfig.Type)
+			}
+			if t := exporter.Type(strings.ToUpper(string(outputConfig.Type))); opts.tidy && (t == exporter.PROMTEXT || t == exporter.REMOTEWRITE) {
+				return errors.Errorf("tidy cannot be used with %v output, which writes the aggregation columns as samples", outputConfig.Type)
+			}
+			if opts.partitionBy != "" {
+				switch t := exporter.Type(strings.ToUpper(string(outputConfig.Type))); {
+				case t == exporter.KAFKA || t == exporter.REMOTEWRITE || t == exporter.BIGQUERY:
+					return errors.Errorf("partition-by can only be used with file outputs, not %v", outputConfig.Type)
+				case len(jobs) > 0 || len(*matchers) > 1 || (opts.shardBy != "" && !opts.shardMerge):
+					return errors.New("partition-by can be used only with a single matcher, without jobs and shard-by unless shard-merge")
+				case opts.splitSeries || opts.exemplars:
+					return errors.New("partition-by cannot be used with split-series or exemplars")
+				}
+			}
+			if opts.splitSeries {
+				switch {
+				case exporter.Type(strings.ToUpper(string(outputConfig.Type))) != exporter.PARQUET:
+					return errors.Errorf("split-series can only be used with PARQUET output, not %v", outputConfig.Type)
+				case len(jobs) > 0 || len(*matchers) > 1 || opts.shardBy != "":
+					return errors.New("split-series can be used only with a single matcher, without jobs and shard-by")
+				case opts.summaries || opts.exemplars:
+					return errors.New("split-series cannot be used with summaries or exemplars")
+				}
+			}
 
-			outputConfig := exporter.Config{
-				// Default Storage Type is Filesystem.
-				Storage: client.BucketConfig{Type: client.FILESYSTEM},
+			switch {
+			case *summaryFile != "":
+				f, err := os.Create(*summaryFile)
+				if err != nil {
+					return errors.Wrap(err, "create summary file")
+				}
+				defer f.Close()
+				opts.summary = newSummaryWriter(f)
+			case *printSummary:
+				opts.summary = newSummaryWriter(os.Stderr)
 			}
-			if err := yaml.UnmarshalStrict(outputCfg, &outputConfig); err != nil {
-				return err
+			if *auditFile != "" {
+				if series.Type(strings.ToUpper(string(inputConfig.Type))) != series.STOREAPI || inputConfig.GRPCWeb {
+					return errors.Errorf("audit-file can only be used with STOREAPI input over native gRPC, not %v", inputConfig.Type)
+				}
+				f, err := os.Create(*auditFile)
+				if err != nil {
+					return errors.Wrap(err, "create audit file")
+				}
+				defer f.Close()
+				opts.audit = newAuditWriter(f).forOutput("", outputConfig.Path)
 			}
 
-			return export(ctx, logger, *matchersStr, inputConfig, outputConfig, mint, maxt, *resolution, *dbgOut)
+			if len(jobs) > 0 {
+				for i := range jobs {
+					jobs[i].opts.summary = opts.summary
+					jobs[i].opts.metrics = opts.metrics
+					jobs[i].opts.audit = opts.audit
+					if jobs[i].output != nil {
+						jobs[i].output.Validate = jobs[i].output.Validate || *validateOutput
+					}
+				}
+				return runJobs(ctx, logger, inputConfig, outputConfig, jobs, *concurrency, cfg.ContinueOnError)
+			}
+			if *followMode {
+				opts.matchers, opts.shardConcurrency = (*matchers)[0], *concurrency
+				return follow(ctx, logger, inputConfig, outputConfig, opts, fopts, time.Now)
+			}
+			return exportMatchers(ctx, logger, inputConfig, outputConfig, opts, *matchers, *concurrency)
 		}, func(error) { cancel() })
 		return nil
 	}
 }
 
+// parseInputConfig parses the input configuration given by the flag, falling back to def (e.g. from the config file)
+// if the flag is not set.
+func parseInputConfig(inputFlag *extflag.PathOrContent, def *series.Config) (series.Config, error) {
+	inputCfg, err := inputFlag.Content()
+	if err != nil {
+		return series.Config{}, err
+	}
+	if len(inputCfg) == 0 {
+		if def == nil {
+			return series.Config{}, errors.New("input configuration is required, given by input-config flags or config")
+		}
+		return *def, nil
+	}
+
+	inputConfig := series.Config{}
+	if err := yaml.UnmarshalStrict(inputCfg, &inputConfig); err != nil {
+		return series.Config{}, err
+	}
+	return inputConfig, nil
+}
+
+// parseOutputConfig parses the output configuration given by the flag, falling back to def (e.g. from the config file)
+// if the flag is not set.
+func parseOutputConfig(outputFlag *extflag.PathOrContent, def *exporter.Config) (exporter.Config, error) {
+	outputCfg, err := outputFlag.Content()
+	if err != nil {
+		return exporter.Config{}, err
+	}
+	if len(outputCfg) == 0 && def != nil {
+		return *def, nil
+	}
+
+	outputConfig := exporter.Config{
+		// Default Storage Type is Filesystem.
+		Storage: client.BucketConfig{Type: client.FILESYSTEM},
+	}
+	if err := yaml.UnmarshalStrict(outputCfg, &outputConfig); err != nil {
+		return exporter.Config{}, err
+	}
+	return outputConfig, nil
+}
+
 func export(
 	ctx context.Context,
 	logger log.Logger,
-	matchersStr string,
 	inputConfig series.Config,
 	outputCfg exporter.Config,
-	mint, maxt model.TimeOrDurationValue,
-	resolution time.Duration,
-	printDebug bool,
-) error {
-	matchers, err := parser.ParseMetricSelector(matchersStr)
+	opts exportOptions,
+) (err error) {
+	if opts.schemaSeries > 0 {
+		return exportSchema(ctx, logger, inputConfig, opts)
+	}
+	var (
+		start   = time.Now()
+		summary = exportSummary{
+			Matchers:   opts.matchers,
+			MinTime:    timestamp.Time(opts.mint.PrometheusTimestamp()),
+			MaxTime:    timestamp.Time(opts.maxt.PrometheusTimestamp()),
+			Resolution: opts.resolution.String(),
+		}
+	)
+	defer func() { opts.metrics.observe(summary, time.Since(start), err) }()
+	if opts.summary != nil {
+		defer func() {
+			summary.Duration = time.Since(start).String()
+			if err != nil {
+				summary.Error = err.Error()
+			}
+			if serr := opts.summary.write(summary); serr != nil && err == nil {
+				err = errors.Wrap(serr, "write summary")
+			}
+		}()
+	}
+
+	matchers, err := parser.ParseMetricSelector(opts.matchers)
 	if err != nil {
 		return errors.Wrap(err, "parsing provided matchers")
 	}
 
-	in, err := infactory.NewSeriesReader(logger, inputConfig)
+	in, err := infactory.NewSeriesReader(logger, inputConfig, opts.dialOptions()...)
 	if err != nil {
 		return err
 	}
 
+	// The outputs writing the rows as labeled metrics name them by the metric name column.
+	outputCfg.MetricColumn = opts.metricColumn
 	exp, err := exportertfactory.NewExporter(logger, outputCfg)
 	if err != nil {
 		return err
 	}
 
-	ser, err := in.Read(ctx, series.Params{
-		Matchers: matchers,
-		MinTime:  timestamp.Time(mint.PrometheusTimestamp()),
-		MaxTime:  timestamp.Time(maxt.PrometheusTimestamp()),
-	})
+	if len(opts.cardinalityLimits) > 0 {
+		opts.cardinality = newCardinalityGuard(logger, opts.cardinalityLimits, opts.cardinalityAction)
+	}
+	if opts.maxSeriesLabels > 0 {
+		opts.labelLimit = newLabelLimitGuard(logger, opts.maxSeriesLabels, opts.maxSeriesLabelsAction)
+	}
+	params := readParams(matchers, opts)
+	if fn := opts.progressFunc(logger); fn != nil {
+		opts.readProgress = series.NewProgress(params.MinTime, params.MaxTime, fn)
+	}
+	read := func(p series.Params) (readResult, error) {
+		if opts.shardBy != "" {
+			return readShards(ctx, logger, in, p, opts)
+		}
+		return readDataframe(ctx, in, p, opts)
+	}
+	var res readResult
+	if opts.readWindow > 0 {
+		res, err = readWindows(params, opts, read)
+	} else {
+		res, err = read(params)
+	}
 	if err != nil {
 		return err
 	}
-
-	df, err := dataframe.FromSeries(ser, resolution, func(o *dataframe.AggrsOptions) {
-		// TODO(inecas): Expose the enabled aggregations via flag.
-		o.Count.Enabled = true
-		o.Sum.Enabled = true
-		o.Min.Enabled = true
-		o.Max.Enabled = true
-	})
+	df, err := dataframe.TopSeries(res.df, opts.topSeries, dataframe.TopBy(opts.topBy))
 	if err != nil {
-		return errors.Wrap(err, "dataframe creation")
+		return err
+	}
+	df = dataframe.InOrder(df, dataframe.Order(opts.order))
+	summary.Series = res.series
+	if opts.cardinality != nil {
+		res.warnings = append(res.warnings, opts.cardinality.warnings()...)
+	}
+	if opts.labelLimit != nil {
+		res.warnings = append(res.warnings, opts.labelLimit.warnings()...)
+	}
+	for _, w := range res.warnings {
+		summary.Warnings = append(summary.Warnings, w.Error())
+	}
+	if opts.summary != nil {
+		summary.Samples = countSamples(df, res.countColumn)
+	}
+	// The partitions are tidied one by one, as the partition label may not be a column of the tidy table.
+	if opts.tidy && opts.partitionBy == "" {
+		if df, err = dataframe.Tidy(df, opts.tidyOptions()); err != nil {
+			return err
+		}
 	}
 
-	if printDebug {
+	if opts.debug {
 		dataframe.Print(os.Stdout, df)
 	}
 
-	if err := exp.Export(ctx, df); err != nil {
+	// Once the data is read, the output is written regardless of cancellation, so that interrupted exports
+	// produce valid (partial) files.
+	switch {
+	case opts.splitSeries:
+		summary.Outputs, err = exportSeriesFiles(logger, outputCfg, df, opts.maxSeriesFiles, opts.outputWriters)
+	case opts.partitionBy != "":
+		var tidy *dataframe.TidyOptions
+		if opts.tidy {
+			o := opts.tidyOptions()
+			tidy = &o
+		}
+		summary.Outputs, err = exportPartitions(logger, outputCfg, df, opts.partitionBy, tidy, opts.maxPartitionFiles, opts.outputWriters)
+	default:
+		err = exp.Export(context.Background(), df)
+		summary.Outputs = exp.Outputs()
+	}
+	for _, o := range summary.Outputs {
+		summary.BytesWritten += o.Bytes
+	}
+	if err != nil {
 		return errors.Wrapf(err, "export dataframe")
 	}
+
+	if res.interrupted {
+		level.Warn(logger).Log("msg", "export interrupted, partial output written", "outputs", len(summary.Outputs))
+		return errors.Wrap(ctx.Err(), "export interrupted, partial output written")
+	}
+
+	if opts.exemplars {
+		outputs, err := exportExemplars(ctx, logger, in, outputCfg, params, opts)
+		for _, o := range outputs {
+			summary.Outputs = append(summary.Outputs, o)
+			summary.BytesWritten += o.Bytes
+		}
+		return err
+	}
 	return nil
 }
+
+// progressFunc returns the function receiving the progress estimate of the export, logging it if logProgress is set,
+// or nil if the progress is not needed.
+func (opts exportOptions) progressFunc(logger log.Logger) series.ProgressFunc {
+	if !opts.logProgress {
+		return opts.progress
+	}
+	logged := -1
+	return func(f float64) {
+		if opts.progress != nil {
+			opts.progress(f)
+		}
+		if step := int(f * 10); step > logged {
+			logged = step
+			level.Info(logger).Log("msg", "read progress", "percent", step*10)
+		}
+	}
+}
+
+// readParams returns the params to read the series selected by the matchers.
+func readParams(matchers []*labels.Matcher, opts exportOptions) series.Params {
+	params := series.Params{
+		Matchers: matchers,
+		MinTime:  timestamp.Time(opts.mint.PrometheusTimestamp()),
+		MaxTime:  timestamp.Time(opts.maxt.PrometheusTimestamp()),
+		Snapshot: opts.snapshot,
+
+		MinTimeExclusive: opts.mintExclusive,
+		MaxTimeExclusive: opts.maxtExclusive,
+	}
+	if opts.downsample {
+		params.MaxResolution = opts.resolution
+	}
+	return params
+}
+
+// deltas returns whether the delta of consecutive samples is aggregated for some of the series.
+func (opts exportOptions) deltas() bool {
+	if opts.delta {
+		return true
+	}
+	for _, o := range opts.overrides {
+		if o.Function != dataframe.SampleValue {
+			return true
+		}
+	}
+	return false
+}
+
+// readResult is the dataframe aggregated from the read series, with the statistics of the read.
+type readResult struct {
+	df          dataframe.Dataframe
+	countColumn string
+	series      int
+	// seen are the hashes of the read series, to count the distinct series of the reads of overlapping series.
+	seen     map[uint64]struct{}
+	warnings storage.Warnings
+	// interrupted is set if the read stopped early due to cancellation.
+	interrupted bool
+}
+
+// readDataframe reads the series selected by the params and aggregates them into the dataframe.
+func readDataframe(ctx context.Context, in series.Reader, params series.Params, opts exportOptions) (readResult, error) {
+	s, err := in.Read(ctx, params)
+	if err != nil {
+		return readResult{}, err
+	}
+	if opts.readProgress != nil {
+		s = opts.readProgress.Set(s)
+	}
+	if opts.allowlist != nil {
+		s = opts.allowlist.filter(s)
+	}
+	if opts.seen != nil {
+		s = opts.seen.filter(s)
+	}
+	s = outputSeries(s, opts)
+	if opts.changedOnly {
+		s = series.NewVaryingSet(s, opts.changedEpsilon)
+	}
+	if opts.labelLimit != nil {
+		s = opts.labelLimit.filter(s)
+	}
+	if opts.cardinality != nil {
+		s = opts.cardinality.filter(s)
+	}
+	is := &interruptibleSet{Set: s, ctx: ctx}
+	ser := newCountingSet(is)
+
+	var countColumn string
+	df, err := dataframe.FromSeries(ser, opts.resolution, opts.aggrOptions, func(o *dataframe.AggrsOptions) {
+		countColumn = o.Count.Column
+	})
+	if err != nil {
+		return readResult{}, errors.Wrap(err, "dataframe creation")
+	}
+	return readResult{
+		df:          df,
+		countColumn: countColumn,
+		series:      len(ser.seen),
+		seen:        ser.seen,
+		warnings:    ser.Warnings(),
+		interrupted: is.interrupted,
+	}, nil
+}
+
+// outputSeries returns the set of the series with the labels of the output, i.e. relabeled, enriched, sampled and with
+// the static labels, as fingerprinted by the _series_id.
+func outputSeries(s series.Set, opts exportOptions) series.Set {
+	if len(opts.relabelConfigs) > 0 {
+		s = newRelabelSet(s, opts.relabelConfigs)
+	}
+	s = newEnrichSet(s, opts.enrichers)
+	s = sampleSeries(s, opts.sampleSeries)
+	return newStaticLabelsSet(s, opts.staticLabels)
+}
+
+// aggrOptions sets the aggregations of the dataframe by the options.
+func (opts exportOptions) aggrOptions(o *dataframe.AggrsOptions) {
+	o.Count.Enabled = opts.aggregated("count")
+	o.Sum.Enabled = opts.aggregated("sum")
+	o.Min.Enabled = opts.aggregated("min")
+	o.Max.Enabled = opts.aggregated("max")
+	o.Avg.Enabled = opts.aggregated("avg")
+	o.SeriesID.Enabled = opts.seriesID
+	o.MetricName = dataframe.AggrOption{Enabled: opts.metricColumn != "", Column: opts.metricColumn}
+	o.First.Enabled = opts.aggregated("first")
+	o.Last.Enabled = opts.aggregated("last")
+	o.CountDistinct.Enabled = opts.countDistinct || opts.countDistinctApprox
+	o.CountDistinct.Approximate = opts.countDistinctApprox
+	o.EmptyWindows = opts.emptyWindows
+	o.Summaries.Enabled = opts.summaries
+	o.Interval.Enabled = opts.scrapeInterval
+	o.SampleCount.Enabled = opts.sampleCount
+	o.Delta.Enabled = opts.delta
+	o.Delta.PerSecond = opts.deltaPerSecond
+	o.Delta.First = series.DeltaFirst(opts.deltaFirst)
+	o.Delta.Unit = series.RateUnit(opts.rateUnit)
+	o.Overrides = opts.overrides
+	o.Units.Conversions = opts.units
+	o.Rounding = dataframe.RoundingOption{Granularity: opts.roundTo, Mode: dataframe.RoundingMode(opts.roundMode)}
+}
+
+var (
+	// aggregationFunctions are the functions selectable by the aggregation flag.
+	aggregationFunctions = []string{"count", "sum", "min", "max", "avg", "first", "last"}
+	// defaultFunctions are the functions aggregated unless selected by the aggregation flag or config.
+	defaultFunctions = []string{"count", "sum", "min", "max"}
+)
+
+// aggregated returns true if the aggregation function is computed, selected by the aggregation flag (or config), or
+// by the first, last and tidy-value flags. The default functions are computed if none are selected.
+func (opts exportOptions) aggregated(fn string) bool {
+	switch {
+	case fn == "first" && opts.first, fn == "last" && opts.last, opts.tidy && fn == opts.tidyValue:
+		return true
+	case len(opts.functions) == 0:
+		return contains(defaultFunctions, fn)
+	}
+	return contains(opts.functions, fn)
+}
+
+// tidyOptions returns the options of the tidy table. The value column is the default column of the function, e.g.
+// _avg, as the columns are not renamed by the export.
+func (opts exportOptions) tidyOptions() dataframe.TidyOptions {
+	return dataframe.TidyOptions{
+		Metric:       opts.metricColumn,
+		Value:        "_" + opts.tidyValue,
+		Dimensions:   opts.tidyDimensions,
+		PackedLabels: opts.tidyPackedLabels,
+	}
+}
+
+// validateTopSeries returns error if the series cannot be ranked by the options, as the ranking column is not
+// aggregated.
+func (opts exportOptions) validateTopSeries() error {
+	switch {
+	case opts.topSeries == 0:
+		return nil
+	case opts.topSeries < 0:
+		return errors.New("top-series cannot be negative")
+	case opts.summaries:
+		return errors.New("top-series cannot be used with summaries")
+	case !opts.aggregated(opts.topBy):
+		return errors.Errorf("top-series-by %s requires the %s aggregation", opts.topBy, opts.topBy)
+	}
+	return nil
+}
+
+// exportExemplars exports exemplars of the series into a file next to the output path, e.g. out-exemplars.parquet.
+// Inputs not supporting exemplars are skipped with a warning.
+func exportExemplars(
+	ctx context.Context,
+	logger log.Logger,
+	in series.Reader,
+	outputCfg exporter.Config,
+	params series.Params,
+	opts exportOptions,
+) ([]exporter.Output, error) {
+	er, ok := in.(series.ExemplarsReader)
+	if !ok {
+		level.Warn(logger).Log("msg", "input does not support exemplars, skipping")
+		return nil, nil
+	}
+	es, err := er.ReadExemplars(ctx, params)
+	if err == series.ErrExemplarsUnsupported {
+		level.Warn(logger).Log("msg", "endpoint does not support exemplars, skipping", "err", err)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "read exemplars")
+	}
+
+	ext := path.Ext(outputCfg.Path)
+	outputCfg.Path = strings.TrimSuffix(outputCfg.Path, ext) + "-exemplars" + ext
+	exp, err := exportertfactory.NewExporter(logger, outputCfg)
+	if err != nil {
+		return nil, err
+	}
+	df := dataframe.FromExemplars(es)
+	if opts.exemplarTraces {
+		df = dataframe.ExemplarTraces(outputExemplars(es, opts), opts.exemplarTraceLabel)
+	}
+	if err := exp.Export(ctx, df); err != nil {
+		return exp.Outputs(), errors.Wrap(err, "export exemplars")
+	}
+	return exp.Outputs(), nil
+}
+
+// outputExemplars returns the exemplars with the labels of their series in the output, see outputSeries, so that their
+// fingerprint is the _series_id of the series. The exemplars of the series dropped by relabeling or sampling are
+// dropped.
+func outputExemplars(es []series.SeriesExemplars, opts exportOptions) []series.SeriesExemplars {
+	ret := make([]series.SeriesExemplars, 0, len(es))
+	for _, se := range es {
+		s := outputSeries(memory.NewSet(storage.NewListSeries(se.SeriesLabels, nil)), opts)
+		if !s.Next() {
+			continue
+		}
+		se.SeriesLabels = s.At().Labels()
+		ret = append(ret, se)
+	}
+	return ret
+}
+
+// interruptibleSet stops the iteration without error once the context is canceled, keeping the series
+// read so far.
+type interruptibleSet struct {
+	series.Set
+
+	ctx         context.Context
+	interrupted bool
+}
+
+func (s *interruptibleSet) Next() bool {
+	if s.ctx.Err() == nil && s.Set.Next() {
+		return true
+	}
+	s.interrupted = s.ctx.Err() != nil
+	return false
+}
+
+func (s *interruptibleSet) Err() error {
+	if s.interrupted {
+		return nil
+	}
+	return s.Set.Err()
+}
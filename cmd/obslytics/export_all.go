@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"sync"
+	"text/template"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/thanos-community/obslytics/pkg/exporter"
+	"github.com/thanos-community/obslytics/pkg/series"
+)
+
+// outputPathData is available to the output path template.
+type outputPathData struct {
+	// Index of the matcher, starting at 0.
+	Index int
+	// Match is the matcher string.
+	Match string
+	// Name is the metric name selected by the matcher, or the index if the matcher doesn't select a single metric.
+	Name string
+	// Shard is the value of the shard-by label selected by the matcher, if exporting shards. It is escaped as a single
+	// path element, see pathValue.
+	Shard string
+}
+
+// outputPaths renders the output path template for every matcher. Shards, if given, are the shard label values
+// of the matchers.
+func outputPaths(pathTmpl string, matchers []string, shards []string) ([]string, error) {
+	tmpl, err := template.New("path").Parse(pathTmpl)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing output path template")
+	}
+
+	paths := make([]string, 0, len(matchers))
+	seen := map[string]struct{}{}
+	for i, m := range matchers {
+		ms, err := parser.ParseMetricSelector(m)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing matcher %q", m)
+		}
+
+		d := outputPathData{Index: i, Match: m, Name: strconv.Itoa(i)}
+		if shards != nil {
+			d.Shard = pathValue(shards[i])
+		}
+		for _, lm := range ms {
+			if lm.Name == labels.MetricName && lm.Type == labels.MatchEqual {
+				d.Name = lm.Value
+			}
+		}
+
+		b := &bytes.Buffer{}
+		if err := tmpl.Execute(b, d); err != nil {
+			return nil, errors.Wrapf(err, "rendering output path for matcher %q", m)
+		}
+		if _, ok := seen[b.String()]; ok {
+			return nil, errors.Errorf("output path %q is not unique, use a template, e.g. {{.Name}}.parquet or {{.Shard}}.parquet", b.String())
+		}
+		seen[b.String()] = struct{}{}
+		paths = append(paths, b.String())
+	}
+	return paths, nil
+}
+
+// exportMatchers exports the matchers, either into a single output or, with multiple matchers, each into its own
+// output. With shard-by set, the shards of a single matcher are exported into their own outputs, unless merged.
+func exportMatchers(
+	ctx context.Context,
+	logger log.Logger,
+	inputConfig series.Config,
+	outputCfg exporter.Config,
+	opts exportOptions,
+	matchers []string,
+	concurrency int,
+) error {
+	if len(matchers) > 1 {
+		if opts.shardBy != "" {
+			return errors.New("shard-by can be used only with a single matcher")
+		}
+		return exportAll(ctx, logger, inputConfig, outputCfg, opts, matchers, concurrency)
+	}
+
+	opts.matchers = matchers[0]
+	if opts.shardBy != "" && !opts.shardMerge {
+		return exportShards(ctx, logger, inputConfig, outputCfg, opts, concurrency)
+	}
+	opts.shardConcurrency = concurrency
+	return export(ctx, logger, inputConfig, outputCfg, opts)
+}
+
+// exportAll exports every matcher into its own output file, running at most concurrency exports in parallel.
+// Returns error if any of the exports failed.
+func exportAll(
+	ctx context.Context,
+	logger log.Logger,
+	inputConfig series.Config,
+	outputCfg exporter.Config,
+	opts exportOptions,
+	matchers []string,
+	concurrency int,
+) error {
+	paths, err := outputPaths(outputCfg.Path, matchers, nil)
+	if err != nil {
+		return err
+	}
+	return exportEach(ctx, logger, inputConfig, outputCfg, opts, matchers, paths, concurrency)
+}
+
+// exportEach exports every matcher into the output path of the same index, running at most concurrency exports
+// in parallel. Returns error if any of the exports failed.
+func exportEach(
+	ctx context.Context,
+	logger log.Logger,
+	inputConfig series.Config,
+	outputCfg exporter.Config,
+	opts exportOptions,
+	matchers []string,
+	paths []string,
+	concurrency int,
+) error {
+	if concurrency <= 0 {
+		return errors.New("concurrency has to be positive")
+	}
+
+	var (
+		wg    sync.WaitGroup
+		slots = make(chan struct{}, concurrency)
+		errs  = make([]error, len(matchers))
+	)
+	for i := range matchers {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+
+			o, out := opts, outputCfg
+			o.matchers, out.Path = matchers[i], paths[i]
+			errs[i] = export(ctx, log.With(logger, "match", matchers[i]), inputConfig, out, o)
+		}(i)
+	}
+	wg.Wait()
+
+	failed := 0
+	for i, err := range errs {
+		if err != nil {
+			failed++
+			level.Error(logger).Log("msg", "export failed", "match", matchers[i], "path", paths[i], "err", err)
+			continue
+		}
+		level.Info(logger).Log("msg", "export succeeded", "match", matchers[i], "path", paths[i])
+	}
+	if failed > 0 {
+		return errors.Errorf("%d of %d exports failed", failed, len(matchers))
+	}
+	return nil
+}
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestOutputPaths(t *testing.T) {
+	paths, err := outputPaths("out/{{.Name}}.parquet", []string{`up{job="a"}`, `{__name__=~"go_.*"}`, `node_load1`}, nil)
+	testutil.Ok(t, err)
+	testutil.Equals(t, []string{"out/up.parquet", "out/1.parquet", "out/node_load1.parquet"}, paths)
+
+	_, err = outputPaths("out.parquet", []string{"up", "node_load1"}, nil)
+	testutil.NotOk(t, err)
+}
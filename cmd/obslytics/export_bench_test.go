@@ -16,7 +16,6 @@ import (
 	"github.com/prometheus/prometheus/tsdb/chunkenc"
 	"github.com/thanos-community/obslytics/pkg/exporter"
 	"github.com/thanos-community/obslytics/pkg/series"
-	"github.com/thanos-io/thanos/pkg/model"
 	"github.com/thanos-io/thanos/pkg/objstore/client"
 	"github.com/thanos-io/thanos/pkg/objstore/filesystem"
 	"github.com/thanos-io/thanos/pkg/store/labelpb"
@@ -104,7 +103,6 @@ func benchExport(b *testing.B, resp []*storepb.SeriesResponse) {
 		testutil.Ok(b, export(
 			ctx,
 			logger,
-			matchers,
 			series.Config{
 				Type:     series.STOREAPI,
 				Endpoint: list.Addr().String(),
@@ -117,10 +115,10 @@ func benchExport(b *testing.B, resp []*storepb.SeriesResponse) {
 					},
 				},
 			},
-			model.TimeOrDurationValue{},
-			model.TimeOrDurationValue{},
-			5*time.Minute,
-			false,
+			exportOptions{
+				matchers:   matchers,
+				resolution: 5 * time.Minute,
+			},
 		))
 	}
 
@@ -89,7 +89,7 @@ func exportToParquet(t *testing.T, ctx context.Context, r series.Reader, bkt obj
 	testutil.Ok(t, err)
 
 	t.Log("Dataframe:", dataframe.ToString(df))
-	testutil.Ok(t, exporter.New(parquet.NewEncoder(), fileName, bkt).Export(ctx, df))
+	testutil.Ok(t, exporter.New(parquet.NewEncoder(exporter.TimeUnitMilliseconds, parquet.Config{}), fileName, bkt).Export(ctx, df))
 }
 
 func TestRemoteReadAndThanos_Parquet_e2e(t *testing.T) {
@@ -117,7 +117,7 @@ func TestRemoteReadAndThanos_Parquet_e2e(t *testing.T) {
 
 		api, err := promread.NewSeries(logger, series.Config{
 			Endpoint:  "http://" + prom.HTTPEndpoint() + "/api/v1/read",
-			TLSConfig: http.TLSConfig{InsecureSkipVerify: true},
+			TLSConfig: series.TLSConfig{TLSConfig: http.TLSConfig{InsecureSkipVerify: true}},
 		})
 		testutil.Ok(t, err)
 
@@ -129,7 +129,7 @@ func TestRemoteReadAndThanos_Parquet_e2e(t *testing.T) {
 
 		api, err := storeapi.NewSeries(logger, series.Config{
 			Endpoint:  sidecar.GRPCEndpoint(),
-			TLSConfig: http.TLSConfig{InsecureSkipVerify: true},
+			TLSConfig: series.TLSConfig{TLSConfig: http.TLSConfig{InsecureSkipVerify: true}},
 		})
 		testutil.Ok(t, err)
 
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/relabel"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/thanos-community/obslytics/pkg/dataframe"
+	"github.com/thanos-community/obslytics/pkg/series"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+// cancelingSet returns n series, canceling the context after the first one. Once the context is canceled,
+// it fails like a set reading from a remote endpoint would.
+type cancelingSet struct {
+	n      int
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func (s *cancelingSet) Next() bool {
+	if s.ctx.Err() != nil || s.n == 0 {
+		return false
+	}
+	s.n--
+	s.cancel()
+	return true
+}
+
+func (s *cancelingSet) At() storage.Series { return nil }
+
+func (s *cancelingSet) Err() error { return s.ctx.Err() }
+
+func (s *cancelingSet) Warnings() storage.Warnings { return nil }
+
+func (s *cancelingSet) Close() error { return nil }
+
+func TestInterruptibleSet(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &interruptibleSet{Set: &cancelingSet{n: 3, ctx: ctx, cancel: cancel}, ctx: ctx}
+
+	testutil.Assert(t, s.Next())
+	testutil.Assert(t, !s.Next())
+	testutil.Assert(t, s.interrupted)
+	testutil.Ok(t, s.Err())
+
+	// Errors not caused by the cancellation are kept.
+	fail := &interruptibleSet{Set: &failingSet{}, ctx: context.Background()}
+	testutil.Assert(t, !fail.Next())
+	testutil.Assert(t, !fail.interrupted)
+	testutil.NotOk(t, fail.Err())
+}
+
+type failingSet struct{ cancelingSet }
+
+func (s *failingSet) Next() bool { return false }
+
+func (s *failingSet) Err() error { return errors.New("failed") }
+
+func TestOutputExemplars(t *testing.T) {
+	opts := exportOptions{
+		relabelConfigs: []*relabel.Config{{
+			SourceLabels: model.LabelNames{"job"},
+			Regex:        relabel.MustNewRegexp("debug"),
+			Action:       relabel.Drop,
+		}},
+		staticLabels: map[string]string{"env": "prod"},
+	}
+	exemplars := []series.Exemplar{{Labels: labels.FromStrings("trace_id", "abc"), Value: 1, Ts: 1000}}
+	es := outputExemplars([]series.SeriesExemplars{
+		{SeriesLabels: labels.FromStrings("__name__", "up", "job", "api"), Exemplars: exemplars},
+		{SeriesLabels: labels.FromStrings("__name__", "up", "job", "debug"), Exemplars: exemplars},
+	}, opts)
+
+	// The exemplars are linked to the series by the fingerprint of the labels of the series in the output.
+	s := outputSeries(&listSet{series: []storage.Series{
+		storage.NewListSeries(labels.FromStrings("__name__", "up", "job", "api"), nil),
+	}, i: -1}, opts)
+	testutil.Assert(t, s.Next())
+	testutil.Equals(t, []series.SeriesExemplars{{SeriesLabels: s.At().Labels(), Exemplars: exemplars}}, es)
+	testutil.Equals(t, dataframe.Fingerprint(labels.FromStrings("__name__", "up", "env", "prod", "job", "api")),
+		dataframe.Fingerprint(es[0].SeriesLabels))
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/thanos-community/obslytics/pkg/timerange"
+	"github.com/thanos-io/thanos/pkg/model"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// maxRangeWithoutConfirm is the longest time range allowed without the allow-large-range flag.
+const maxRangeWithoutConfirm = 90 * 24 * time.Hour
+
+// timeRangeFlags select the time range of the series, either by min/max-time or since/until flags.
+type timeRangeFlags struct {
+	mint, maxt      model.TimeOrDurationValue
+	since, until    string
+	untilSet        bool
+	allowLargeRange bool
+}
+
+func registerTimeRangeFlags(cmd *kingpin.CmdClause) *timeRangeFlags {
+	f := &timeRangeFlags{}
+	timeFmt := time.RFC3339
+
+	cmd.Flag("min-time", fmt.Sprintf("The lower boundary of the time series in %s or duration format", timeFmt)).
+		SetValue(&f.mint)
+
+	cmd.Flag("max-time", fmt.Sprintf("The upper boundary of the time series in %s or duration format", timeFmt)).
+		SetValue(&f.maxt)
+
+	cmd.Flag("since", "The lower boundary of the time series as a duration before now (e.g. 30d), \"now\" or absolute time (UTC by default). Alternative to min-time").
+		StringVar(&f.since)
+	cmd.Flag("until", "The upper boundary of the time series as a duration before now (e.g. 1h), \"now\" or absolute time (UTC by default). Alternative to max-time").
+		Default("now").IsSetByUser(&f.untilSet).StringVar(&f.until)
+	cmd.Flag("allow-large-range", fmt.Sprintf("Allow time ranges longer than %s", maxRangeWithoutConfirm)).
+		BoolVar(&f.allowLargeRange)
+	return f
+}
+
+// setDefaults uses since and until (e.g. from the config file) for the boundaries not given by flags.
+func (f *timeRangeFlags) setDefaults(since, until string, allowLargeRange bool) {
+	if f.mint.Time == nil && f.mint.Dur == nil && f.since == "" {
+		f.since = since
+	}
+	if f.maxt.Time == nil && f.maxt.Dur == nil && !f.untilSet && until != "" {
+		f.until = until
+	}
+	f.allowLargeRange = f.allowLargeRange || allowLargeRange
+}
+
+// hasMin returns true if the lower boundary is given.
+func (f *timeRangeFlags) hasMin() bool {
+	return f.mint.Time != nil || f.mint.Dur != nil || f.since != ""
+}
+
+// resolve returns the selected time range.
+func (f *timeRangeFlags) resolve(now time.Time) (mint, maxt model.TimeOrDurationValue, err error) {
+	mint, maxt = f.mint, f.maxt
+
+	switch {
+	case mint.Time != nil || mint.Dur != nil:
+		if f.since != "" {
+			return mint, maxt, errors.New("min-time and since flags are mutually exclusive")
+		}
+	case f.since != "":
+		t, err := timerange.Parse(f.since, now)
+		if err != nil {
+			return mint, maxt, errors.Wrap(err, "parsing since")
+		}
+		mint = model.TimeOrDurationValue{Time: &t}
+	default:
+		return mint, maxt, errors.New("one of min-time or since flags is required")
+	}
+
+	if maxt.Time == nil && maxt.Dur == nil {
+		t, err := timerange.Parse(f.until, now)
+		if err != nil {
+			return mint, maxt, errors.Wrap(err, "parsing until")
+		}
+		maxt = model.TimeOrDurationValue{Time: &t}
+	}
+
+	maxRange := maxRangeWithoutConfirm
+	if f.allowLargeRange {
+		maxRange = 0
+	}
+	if err := timerange.Validate(timeOf(mint, now), timeOf(maxt, now), maxRange); err != nil {
+		return mint, maxt, errors.Wrap(err, "invalid time range (use allow-large-range flag to allow long ranges)")
+	}
+	return mint, maxt, nil
+}
+
+func timeOf(v model.TimeOrDurationValue, now time.Time) time.Time {
+	if v.Time != nil {
+		return *v.Time
+	}
+	return now.Add(time.Duration(*v.Dur))
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/thanos-community/obslytics/pkg/exporter"
+	"github.com/thanos-community/obslytics/pkg/series"
+	"github.com/thanos-io/thanos/pkg/model"
+)
+
+// followOptions configure the follow mode, re-exporting the trailing window periodically.
+type followOptions struct {
+	// interval is the time between the polls.
+	interval time.Duration
+	// lookback is how far back every poll reads, so that samples arriving late are still exported.
+	lookback time.Duration
+	// start, if set, is the beginning of the first poll, e.g. to backfill before following.
+	start *time.Time
+}
+
+// follow exports the trailing window every interval until the context is canceled. Every poll ends at the latest
+// resolution window boundary, so that only complete windows are exported, and starts lookback before. Samples
+// exported by the previous polls are skipped, based on the latest exported timestamp of every series. Late
+// samples within the lookback are exported in additional rows of their (already exported) windows.
+//
+// File outputs get a new file per poll, suffixed with the poll end in milliseconds, e.g. out-1617235200000.parquet.
+// The DUCKDB table is handled by the if_exists policy by the first poll only, the following polls append to it.
+// A failed poll is logged and its samples are retried by the next poll, as long as they are within the lookback.
+func follow(
+	ctx context.Context,
+	logger log.Logger,
+	inputConfig series.Config,
+	outputCfg exporter.Config,
+	opts exportOptions,
+	fopts followOptions,
+	now func() time.Time,
+) error {
+	if fopts.interval <= 0 || fopts.lookback <= 0 {
+		return errors.New("follow mode requires positive poll interval and lookback")
+	}
+
+	var (
+		seen     = newSeenSamples()
+		lastMaxt time.Time
+		start    = fopts.start
+		polled   bool
+	)
+	opts.seen = seen
+	for {
+		maxt := now().Truncate(opts.resolution)
+		if maxt.After(lastMaxt) {
+			mint := maxt.Add(-fopts.lookback)
+			if start != nil && start.Before(mint) {
+				mint = *start
+			}
+
+			o, out := opts, pollOutput(outputCfg, maxt, polled)
+			o.mint, o.maxt = model.TimeOrDurationValue{Time: &mint}, model.TimeOrDurationValue{Time: &maxt}
+
+			err := export(ctx, log.With(logger, "maxt", maxt), inputConfig, out, o)
+			if ctx.Err() != nil {
+				return nil
+			}
+			if err != nil {
+				seen.rollback()
+				level.Error(logger).Log("msg", "poll failed, retrying in next poll", "mint", mint, "maxt", maxt, "err", err)
+			} else {
+				seen.commit(timestamp.FromTime(mint))
+				lastMaxt, start, polled = maxt, nil, true
+				level.Info(logger).Log("msg", "poll succeeded", "mint", mint, "maxt", maxt, "series", seen.len())
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(fopts.interval):
+		}
+	}
+}
+
+// pollOutput returns the output of the poll ending at maxt, after a successful poll if polled is set.
+func pollOutput(outputCfg exporter.Config, maxt time.Time, polled bool) exporter.Config {
+	switch exporter.Type(strings.ToUpper(string(outputCfg.Type))) {
+	case exporter.PARQUET, exporter.HDF5, exporter.PROMTEXT:
+		outputCfg.Path = pollPath(outputCfg.Path, maxt)
+	case exporter.DUCKDB:
+		// Every poll creates a new writer, the table written by the earlier polls is appended to.
+		if polled {
+			outputCfg.IfExists = exporter.ExistsAppend
+		}
+	}
+	return outputCfg
+}
+
+// pollPath returns the output path of the poll ending at maxt.
+func pollPath(p string, maxt time.Time) string {
+	ext := path.Ext(p)
+	return fmt.Sprintf("%s-%d%s", strings.TrimSuffix(p, ext), timestamp.FromTime(maxt), ext)
+}
+
+// seenSamples tracks the latest exported sample timestamp of every series across the polls. Timestamps read by
+// the current poll are pending until the poll is committed. It is safe for concurrent use, e.g. by shard reads.
+type seenSamples struct {
+	mtx     sync.Mutex
+	latest  map[uint64]int64
+	pending map[uint64]int64
+}
+
+func newSeenSamples() *seenSamples {
+	return &seenSamples{latest: map[uint64]int64{}, pending: map[uint64]int64{}}
+}
+
+// filter returns the set without the samples at or before the latest exported timestamp of their series.
+func (s *seenSamples) filter(set series.Set) series.Set {
+	return &unseenSet{Set: set, seen: s}
+}
+
+func (s *seenSamples) get(hash uint64) (int64, bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	t, ok := s.latest[hash]
+	return t, ok
+}
+
+func (s *seenSamples) observe(hash uint64, t int64) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if p, ok := s.pending[hash]; !ok || t > p {
+		s.pending[hash] = t
+	}
+}
+
+// commit marks the pending timestamps as exported. Series without samples since mint are forgotten, as their
+// samples are not read by the following polls anymore.
+func (s *seenSamples) commit(mint int64) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for h, t := range s.pending {
+		if l, ok := s.latest[h]; !ok || t > l {
+			s.latest[h] = t
+		}
+	}
+	for h, t := range s.latest {
+		if t < mint {
+			delete(s.latest, h)
+		}
+	}
+	s.pending = map[uint64]int64{}
+}
+
+// rollback discards the pending timestamps, so that the samples are exported again by the next poll.
+func (s *seenSamples) rollback() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.pending = map[uint64]int64{}
+}
+
+func (s *seenSamples) len() int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return len(s.latest)
+}
+
+// unseenSet skips the samples exported by previous polls.
+type unseenSet struct {
+	series.Set
+
+	seen *seenSamples
+}
+
+func (s *unseenSet) At() storage.Series {
+	ser := s.Set.At()
+	hash := ser.Labels().Hash()
+	latest, ok := s.seen.get(hash)
+	return &unseenSeries{Series: ser, seen: s.seen, hash: hash, latest: latest, filter: ok}
+}
+
+type unseenSeries struct {
+	storage.Series
+
+	seen   *seenSamples
+	hash   uint64
+	latest int64
+	filter bool
+}
+
+func (s *unseenSeries) Iterator() chunkenc.Iterator {
+	return &unseenIterator{Iterator: s.Series.Iterator(), series: s}
+}
+
+type unseenIterator struct {
+	chunkenc.Iterator
+
+	series *unseenSeries
+}
+
+func (it *unseenIterator) Next() bool {
+	for it.Iterator.Next() {
+		if it.accept() {
+			return true
+		}
+	}
+	return false
+}
+
+func (it *unseenIterator) Seek(t int64) bool {
+	if !it.Iterator.Seek(t) {
+		return false
+	}
+	return it.accept() || it.Next()
+}
+
+func (it *unseenIterator) SampleCount() uint64 { return series.SampleCount(it.Iterator) }
+
+func (it *unseenIterator) Aggregate() (series.Aggregate, bool) {
+	return series.SampleAggregate(it.Iterator)
+}
+
+// accept returns true if the current sample was not exported yet, recording it as seen.
+func (it *unseenIterator) accept() bool {
+	t, _ := it.Iterator.At()
+	if it.series.filter && t <= it.series.latest {
+		return false
+	}
+	it.series.seen.observe(it.series.hash, t)
+	return true
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/tsdbutil"
+	"github.com/thanos-community/obslytics/pkg/exporter"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestSeenSamples(t *testing.T) {
+	seen := newSeenSamples()
+	poll := func(samples map[string][]tsdbutil.Sample) map[string][]int64 {
+		set := &listSet{i: -1}
+		for _, name := range []string{"a", "b"} {
+			if s, ok := samples[name]; ok {
+				set.series = append(set.series, storage.NewListSeries(labels.FromStrings("__name__", name), s))
+			}
+		}
+
+		ret := map[string][]int64{}
+		s := seen.filter(set)
+		for s.Next() {
+			it := s.At().Iterator()
+			for it.Next() {
+				ts, _ := it.At()
+				ret[s.At().Labels().Get("__name__")] = append(ret[s.At().Labels().Get("__name__")], ts)
+			}
+			testutil.Ok(t, it.Err())
+		}
+		return ret
+	}
+
+	testutil.Equals(t, map[string][]int64{"a": {10, 20}, "b": {15}}, poll(map[string][]tsdbutil.Sample{
+		"a": {sample{t: 10}, sample{t: 20}},
+		"b": {sample{t: 15}},
+	}))
+	seen.commit(0)
+
+	// The next poll overlaps the previous one, only new samples are returned.
+	next := map[string][]tsdbutil.Sample{
+		"a": {sample{t: 10}, sample{t: 20}, sample{t: 30}},
+		"b": {sample{t: 15}, sample{t: 25}},
+	}
+	testutil.Equals(t, map[string][]int64{"a": {30}, "b": {25}}, poll(next))
+	// A failed poll is retried.
+	seen.rollback()
+	testutil.Equals(t, map[string][]int64{"a": {30}, "b": {25}}, poll(next))
+	seen.commit(0)
+	testutil.Equals(t, map[string][]int64{}, poll(next))
+	seen.commit(0)
+
+	// Series without samples since the poll start are forgotten.
+	seen.commit(26)
+	testutil.Equals(t, 1, seen.len())
+}
+
+func TestPollPath(t *testing.T) {
+	testutil.Equals(t, "out/up-60000.parquet", pollPath("out/up.parquet", time.Unix(60, 0)))
+}
+
+func TestPollOutput(t *testing.T) {
+	maxt := time.Unix(60, 0)
+	out := exporter.Config{Type: exporter.PARQUET, Path: "out/up.parquet"}
+	testutil.Equals(t, "out/up-60000.parquet", pollOutput(out, maxt, false).Path)
+
+	// The DUCKDB table of the first poll is handled by the policy, the following polls append to it.
+	out = exporter.Config{Type: "duckdb", Path: "out.duckdb"}
+	testutil.Equals(t, out, pollOutput(out, maxt, false))
+	testutil.Equals(t, exporter.ExistsAppend, pollOutput(out, maxt, true).IfExists)
+	out.IfExists = exporter.ExistsOverwrite
+	testutil.Equals(t, exporter.ExistsOverwrite, pollOutput(out, maxt, false).IfExists)
+	testutil.Equals(t, exporter.ExistsAppend, pollOutput(out, maxt, true).IfExists)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"github.com/thanos-community/obslytics/pkg/exporter"
+	"github.com/thanos-community/obslytics/pkg/series"
+)
+
+// jobConfig is a single export of a batch defined by the config file. Set fields take precedence over the flags and
+// the top-level config values, which the unset fields default to.
+type jobConfig struct {
+	// Name identifies the job in logs, defaults to its index.
+	Name       string         `yaml:"name"`
+	Match      []string       `yaml:"match"`
+	MinTime    string         `yaml:"min_time"`
+	MaxTime    string         `yaml:"max_time"`
+	Resolution model.Duration `yaml:"resolution"`
+
+	// Path overrides the output path, Output replaces the whole output configuration.
+	Path   string           `yaml:"path"`
+	Output *exporter.Config `yaml:"output"`
+}
+
+// job is a jobConfig resolved against the defaults.
+type job struct {
+	name     string
+	matchers []string
+	opts     exportOptions
+
+	path   string
+	output *exporter.Config
+}
+
+// resolveJobs resolves the jobs of the config. The time range of every job is validated up front, so that invalid
+// jobs fail the batch before anything is exported.
+func (c pipelineConfig) resolveJobs(opts exportOptions, matchers []string, tr timeRangeFlags, now time.Time) ([]job, error) {
+	jobs := make([]job, 0, len(c.Jobs))
+	for i, jc := range c.Jobs {
+		j := job{name: jc.Name, matchers: jc.Match, opts: opts, path: jc.Path, output: jc.Output}
+		if j.name == "" {
+			j.name = strconv.Itoa(i)
+		}
+		if len(j.matchers) == 0 {
+			j.matchers = matchers
+		}
+		if len(j.matchers) == 0 {
+			return nil, errors.Errorf("job %s: at least one matcher is required", j.name)
+		}
+		if len(j.matchers) > 1 && j.opts.shardBy != "" {
+			return nil, errors.Errorf("job %s: shard-by can be used only with a single matcher", j.name)
+		}
+		if jc.Resolution > 0 {
+			j.opts.resolution = time.Duration(jc.Resolution)
+		}
+		if j.opts.resolution <= 0 {
+			return nil, errors.Errorf("job %s: positive resolution is required", j.name)
+		}
+
+		jtr := tr
+		if jc.MinTime != "" {
+			jtr.mint.Time, jtr.mint.Dur, jtr.since = nil, nil, jc.MinTime
+		}
+		if jc.MaxTime != "" {
+			jtr.maxt.Time, jtr.maxt.Dur, jtr.until = nil, nil, jc.MaxTime
+		}
+		var err error
+		if j.opts.mint, j.opts.maxt, err = jtr.resolve(now); err != nil {
+			return nil, errors.Wrapf(err, "job %s", j.name)
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, nil
+}
+
+// runJobs runs the jobs, at most concurrency in parallel, and reports the status of every job. Unless continueOnError
+// is set, no more jobs are started after the first failure. Returns error if any of the jobs failed.
+func runJobs(
+	ctx context.Context,
+	logger log.Logger,
+	inputConfig series.Config,
+	outputCfg exporter.Config,
+	jobs []job,
+	concurrency int,
+	continueOnError bool,
+) error {
+	if concurrency <= 0 {
+		return errors.New("concurrency has to be positive")
+	}
+
+	var (
+		wg      sync.WaitGroup
+		mtx     sync.Mutex
+		failed  bool
+		slots   = make(chan struct{}, concurrency)
+		errs    = make([]error, len(jobs))
+		started = make([]bool, len(jobs))
+	)
+	for i := range jobs {
+		slots <- struct{}{}
+		mtx.Lock()
+		stop := (failed && !continueOnError) || ctx.Err() != nil
+		mtx.Unlock()
+		if stop {
+			<-slots
+			break
+		}
+
+		started[i] = true
+		wg.Add(1)
+		go func(j job, i int) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+
+			out := outputCfg
+			if j.output != nil {
+				out = *j.output
+			}
+			if j.path != "" {
+				out.Path = j.path
+			}
+
+			j.opts.audit = j.opts.audit.forOutput(j.name, out.Path)
+			jlogger := log.With(logger, "job", j.name)
+			level.Info(jlogger).Log("msg", "job started", "match", strings.Join(j.matchers, ","), "path", out.Path)
+			errs[i] = exportMatchers(ctx, jlogger, inputConfig, out, j.opts, j.matchers, 1)
+			if errs[i] != nil {
+				mtx.Lock()
+				failed = true
+				mtx.Unlock()
+			}
+		}(jobs[i], i)
+	}
+	wg.Wait()
+
+	var nFailed, nSkipped int
+	for i, j := range jobs {
+		switch {
+		case !started[i]:
+			nSkipped++
+			level.Warn(logger).Log("msg", "job skipped", "job", j.name)
+		case errs[i] != nil:
+			nFailed++
+			level.Error(logger).Log("msg", "job failed", "job", j.name, "err", errs[i])
+		default:
+			level.Info(logger).Log("msg", "job succeeded", "job", j.name)
+		}
+	}
+	if nFailed > 0 || nSkipped > 0 {
+		return errors.Errorf("%d of %d jobs failed, %d skipped", nFailed, len(jobs), nSkipped)
+	}
+	return nil
+}
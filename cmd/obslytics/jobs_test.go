@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/thanos-community/obslytics/pkg/exporter"
+	"github.com/thanos-community/obslytics/pkg/series"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestResolveJobs(t *testing.T) {
+	cfg, err := parsePipelineConfig([]byte(`
+match: [up]
+min_time: 2021-01-01
+max_time: 2021-01-02
+resolution: 1h
+jobs:
+- name: defaults
+- match: [node_load1]
+  min_time: 2021-02-01
+  max_time: 2021-02-02
+  resolution: 5m
+  path: load.parquet
+`))
+	testutil.Ok(t, err)
+
+	opts := exportOptions{resolution: time.Hour}
+	tr := timeRangeFlags{}
+	tr.setDefaults(cfg.MinTime, cfg.MaxTime, cfg.AllowLargeRange)
+	jobs, err := cfg.resolveJobs(opts, cfg.Match, tr, time.Now())
+	testutil.Ok(t, err)
+	testutil.Equals(t, 2, len(jobs))
+
+	testutil.Equals(t, "defaults", jobs[0].name)
+	testutil.Equals(t, []string{"up"}, jobs[0].matchers)
+	testutil.Equals(t, time.Hour, jobs[0].opts.resolution)
+	testutil.Equals(t, time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), *jobs[0].opts.mint.Time)
+
+	testutil.Equals(t, "1", jobs[1].name)
+	testutil.Equals(t, []string{"node_load1"}, jobs[1].matchers)
+	testutil.Equals(t, 5*time.Minute, jobs[1].opts.resolution)
+	testutil.Equals(t, time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC), *jobs[1].opts.mint.Time)
+	testutil.Equals(t, time.Date(2021, 2, 2, 0, 0, 0, 0, time.UTC), *jobs[1].opts.maxt.Time)
+	testutil.Equals(t, "load.parquet", jobs[1].path)
+
+	// Invalid ranges fail before anything runs.
+	cfg.Jobs[1].MaxTime = "2020-01-01"
+	_, err = cfg.resolveJobs(opts, cfg.Match, tr, time.Now())
+	testutil.NotOk(t, err)
+}
+
+func TestRunJobs_ContinueOnError(t *testing.T) {
+	// Invalid matchers fail the jobs before any input is read.
+	jobs := []job{
+		{name: "a", matchers: []string{"{"}},
+		{name: "b", matchers: []string{"{"}},
+	}
+
+	err := runJobs(context.Background(), log.NewNopLogger(), series.Config{}, exporter.Config{}, jobs, 1, false)
+	testutil.NotOk(t, err)
+	testutil.Equals(t, "1 of 2 jobs failed, 1 skipped", err.Error())
+
+	err = runJobs(context.Background(), log.NewNopLogger(), series.Config{}, exporter.Config{}, jobs, 1, true)
+	testutil.NotOk(t, err)
+	testutil.Equals(t, "2 of 2 jobs failed, 0 skipped", err.Error())
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/thanos-community/obslytics/pkg/series"
+)
+
+func validateLabelLimit(limit int, action string) error {
+	if limit < 0 {
+		return errors.Errorf("max series labels cannot be negative, got %d", limit)
+	}
+	switch action {
+	case "", cardinalityAbort, cardinalityDrop:
+		return nil
+	}
+	return errors.Errorf("unsupported max series labels action %q, expected abort or drop", action)
+}
+
+// labelLimitGuard limits the number of labels of the exported series, e.g. to bound the width of the output schema,
+// which has a column per label. Like cardinalityGuard, it's shared by the reads of an export and safe for concurrent
+// use.
+type labelLimitGuard struct {
+	logger log.Logger
+	limit  int
+	drop   bool
+
+	mtx     sync.Mutex
+	dropped int
+}
+
+func newLabelLimitGuard(logger log.Logger, limit int, action string) *labelLimitGuard {
+	return &labelLimitGuard{logger: logger, limit: limit, drop: action == cardinalityDrop}
+}
+
+// labelLimitError reports the series with more labels than the limit.
+type labelLimitError struct {
+	labels int
+	limit  int
+	series string
+}
+
+func (e *labelLimitError) Error() string {
+	return fmt.Sprintf("series %s has %d labels, exceeding the limit of %d labels", e.series, e.labels, e.limit)
+}
+
+// recordDrop records the dropped series, logging the first one as a warning and the others at debug level.
+func (g *labelLimitGuard) recordDrop(err *labelLimitError) {
+	g.mtx.Lock()
+	g.dropped++
+	first := g.dropped == 1
+	g.mtx.Unlock()
+
+	l := level.Debug(g.logger)
+	if first {
+		l = level.Warn(g.logger)
+	}
+	l.Log("msg", "dropping series over labels limit", "labels", err.labels, "limit", err.limit, "series", err.series)
+}
+
+// warnings returns the warning of the dropped series, if any.
+func (g *labelLimitGuard) warnings() storage.Warnings {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	if g.dropped == 0 {
+		return nil
+	}
+	return storage.Warnings{errors.Errorf("dropped %d series with more than %d labels", g.dropped, g.limit)}
+}
+
+// filter returns the set failing, or skipping the series if drop is set, on the series over the limit.
+func (g *labelLimitGuard) filter(s series.Set) series.Set {
+	return &labelLimitSet{Set: s, guard: g}
+}
+
+type labelLimitSet struct {
+	series.Set
+
+	guard *labelLimitGuard
+	err   error
+}
+
+func (s *labelLimitSet) Next() bool {
+	for s.err == nil && s.Set.Next() {
+		lset := s.Set.At().Labels()
+		if len(lset) <= s.guard.limit {
+			return true
+		}
+		err := &labelLimitError{labels: len(lset), limit: s.guard.limit, series: lset.String()}
+		if !s.guard.drop {
+			s.err = err
+			return false
+		}
+		s.guard.recordDrop(err)
+	}
+	return false
+}
+
+func (s *labelLimitSet) Err() error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.Set.Err()
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestLabelLimitGuard(t *testing.T) {
+	newSet := func() *listSet {
+		return &listSet{series: []storage.Series{
+			storage.NewListSeries(labels.FromStrings("__name__", "up", "pod", "a"), nil),
+			storage.NewListSeries(labels.FromStrings("__name__", "up", "pod", "b", "job", "x", "team", "y"), nil),
+			storage.NewListSeries(labels.FromStrings("__name__", "up", "pod", "c", "job", "x"), nil),
+		}, i: -1}
+	}
+
+	s := newLabelLimitGuard(log.NewNopLogger(), 3, cardinalityAbort).filter(newSet())
+	testutil.Assert(t, s.Next())
+	testutil.Assert(t, !s.Next())
+	testutil.NotOk(t, s.Err())
+	testutil.Equals(t, `series {__name__="up", job="x", pod="b", team="y"} has 4 labels, exceeding the limit of 3 labels`, s.Err().Error())
+
+	g := newLabelLimitGuard(log.NewNopLogger(), 3, cardinalityDrop)
+	testutil.Equals(t, 0, len(g.warnings()))
+	s = g.filter(newSet())
+	var got []string
+	for s.Next() {
+		got = append(got, s.At().Labels().Get("pod"))
+	}
+	testutil.Ok(t, s.Err())
+	testutil.Equals(t, []string{"a", "c"}, got)
+	testutil.Equals(t, 1, len(g.warnings()))
+	testutil.Equals(t, "dropped 1 series with more than 3 labels", g.warnings()[0].Error())
+
+	testutil.NotOk(t, validateLabelLimit(-1, cardinalityAbort))
+	err := validateLabelLimit(10, "ignore")
+	testutil.NotOk(t, err)
+	testutil.Assert(t, strings.Contains(err.Error(), "expected abort or drop"), err.Error())
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/go-kit/kit/log/level"
 	"github.com/oklog/run"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/version"
 	"go.uber.org/automaxprocs/maxprocs"
 	"gopkg.in/alecthomas/kingpin.v2"
@@ -22,7 +23,7 @@ const (
 	logFormatJson   = "json"
 )
 
-type setupFunc func(*run.Group, log.Logger) error
+type setupFunc func(*run.Group, log.Logger, *prometheus.Registry) error
 
 func main() {
 	if os.Getenv("DEBUG") != "" {
@@ -38,9 +39,15 @@ func main() {
 		Default("info").Enum("error", "warn", "info", "debug")
 	logFormat := app.Flag("log.format", "Log format to use.").
 		Default(logFormatLogfmt).Enum(logFormatLogfmt, logFormatJson)
+	debugGRPCAddr := app.Flag("debug.grpc-address", "Listen host:port for the gRPC channelz and reflection services, to inspect "+
+		"the state of the gRPC connections (e.g. to StoreAPI), their subchannels and in-flight calls. Disabled by default.").String()
+	metricsAddr := app.Flag("metrics-listen", "Listen host:port for the HTTP server exposing obslytics' own Prometheus metrics on /metrics "+
+		"(exports, gRPC client, Go runtime), and the /-/healthy and /-/ready probes. Disabled by default.").String()
 
 	cmds := map[string]setupFunc{}
 	registerExport(cmds, app)
+	registerCount(cmds, app)
+	registerServe(cmds, app)
 
 	cmd, err := app.Parse(os.Args[1:])
 	if err != nil {
@@ -84,11 +91,31 @@ func main() {
 		level.Warn(logger).Log("msg", "failed to set GOMAXPROCS", "err", err)
 	}
 
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		version.NewCollector("obslytics"),
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	)
+
 	var g run.Group
-	if err := cmds[cmd](&g, logger); err != nil {
+	if err := cmds[cmd](&g, logger, reg); err != nil {
 		level.Error(logger).Log("err", fmt.Sprintf("%v", errors.Wrapf(err, "%s command failed", cmd)))
 		os.Exit(1)
 	}
+	if *debugGRPCAddr != "" {
+		if err := runDebugServer(&g, logger, *debugGRPCAddr); err != nil {
+			level.Error(logger).Log("err", err)
+			os.Exit(1)
+		}
+	}
+	ready := &readiness{}
+	if *metricsAddr != "" {
+		if err := runMetricsServer(&g, logger, *metricsAddr, reg, ready); err != nil {
+			level.Error(logger).Log("err", err)
+			os.Exit(1)
+		}
+	}
 
 	// Listen for termination signals.
 	{
@@ -100,7 +127,14 @@ func main() {
 		})
 	}
 
+	ready.set(true)
 	if err := g.Run(); err != nil {
+		var sigErr signalError
+		if errors.As(err, &sigErr) {
+			// All actors have finished at this point, so any partial output is already flushed.
+			level.Warn(logger).Log("msg", "interrupted", "signal", sigErr.sig)
+			os.Exit(sigErr.exitCode())
+		}
 		level.Error(logger).Log("msg", "running command failed", "err", err)
 		os.Exit(1)
 	}
@@ -115,8 +149,23 @@ func interrupt(logger log.Logger, cancel <-chan struct{}) error {
 	select {
 	case s := <-c:
 		level.Info(logger).Log("msg", "caught signal. Exiting.", "signal", s)
-		return nil
+		return signalError{sig: s}
 	case <-cancel:
 		return errors.New("canceled")
 	}
 }
+
+// signalError is returned when the command was interrupted by a signal.
+type signalError struct {
+	sig os.Signal
+}
+
+func (e signalError) Error() string { return "caught signal " + e.sig.String() }
+
+// exitCode follows the shell convention of 128 + signal number, e.g. 130 for SIGINT.
+func (e signalError) exitCode() int {
+	if s, ok := e.sig.(syscall.Signal); ok {
+		return 128 + int(s)
+	}
+	return 1
+}
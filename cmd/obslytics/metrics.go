@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
+	"github.com/oklog/run"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+)
+
+// exportMetrics instrument the exports and the gRPC client of the input, to monitor long-running exports
+// (follow mode, serve) through the metrics server.
+type exportMetrics struct {
+	grpcClient *grpc_prometheus.ClientMetrics
+
+	exports      *prometheus.CounterVec
+	duration     prometheus.Histogram
+	lastSuccess  prometheus.Gauge
+	seriesRead   prometheus.Counter
+	outputs      prometheus.Counter
+	bytesWritten prometheus.Counter
+}
+
+func newExportMetrics(reg prometheus.Registerer) *exportMetrics {
+	m := &exportMetrics{
+		grpcClient: grpc_prometheus.NewClientMetrics(),
+		exports: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "obslytics_exports_total",
+			Help: "Total number of exports (including follow mode polls and serve jobs) by result.",
+		}, []string{"result"}),
+		duration: promauto.With(reg).NewHistogram(prometheus.HistogramOpts{
+			Name:    "obslytics_export_duration_seconds",
+			Help:    "Duration of the exports, from reading the series to writing the outputs.",
+			Buckets: []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800, 3600},
+		}),
+		lastSuccess: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+			Name: "obslytics_export_last_success_timestamp_seconds",
+			Help: "Unix timestamp of the last successful export.",
+		}),
+		seriesRead: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "obslytics_series_read_total",
+			Help: "Total number of series read from the input.",
+		}),
+		outputs: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "obslytics_outputs_written_total",
+			Help: "Total number of outputs (files, batches of messages) written.",
+		}),
+		bytesWritten: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "obslytics_output_bytes_written_total",
+			Help: "Total number of bytes written to the outputs.",
+		}),
+	}
+	// Initialize the results, so that the failures are exposed as zero before the first one.
+	m.exports.WithLabelValues("success")
+	m.exports.WithLabelValues("failure")
+	if reg != nil {
+		reg.MustRegister(m.grpcClient)
+	}
+	return m
+}
+
+// dialOptions returns the options instrumenting the gRPC client of the input. It's safe to call on nil metrics.
+func (m *exportMetrics) dialOptions() []grpc.DialOption {
+	if m == nil {
+		return nil
+	}
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(m.grpcClient.UnaryClientInterceptor()),
+		grpc.WithChainStreamInterceptor(m.grpcClient.StreamClientInterceptor()),
+	}
+}
+
+// observe records the finished export given by its summary. It's safe to call on nil metrics.
+func (m *exportMetrics) observe(summary exportSummary, duration time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.duration.Observe(duration.Seconds())
+	m.seriesRead.Add(float64(summary.Series))
+	m.outputs.Add(float64(len(summary.Outputs)))
+	m.bytesWritten.Add(float64(summary.BytesWritten))
+	if err != nil {
+		m.exports.WithLabelValues("failure").Inc()
+		return
+	}
+	m.exports.WithLabelValues("success").Inc()
+	m.lastSuccess.SetToCurrentTime()
+}
+
+// readiness is the state of the /-/ready probe.
+type readiness struct {
+	ready int32
+}
+
+func (r *readiness) set(ready bool) {
+	v := int32(0)
+	if ready {
+		v = 1
+	}
+	atomic.StoreInt32(&r.ready, v)
+}
+
+func (r *readiness) isReady() bool { return atomic.LoadInt32(&r.ready) == 1 }
+
+// newMetricsHandler returns the handler exposing the metrics of the registry on /metrics, and the /-/healthy and
+// /-/ready probes. The process is ready once the command is running, until it is interrupted.
+func newMetricsHandler(reg *prometheus.Registry, ready *readiness) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{Registry: reg}))
+	mux.HandleFunc("/-/healthy", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("obslytics is healthy.\n"))
+	})
+	mux.HandleFunc("/-/ready", func(w http.ResponseWriter, _ *http.Request) {
+		if !ready.isReady() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte("obslytics is not ready.\n"))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("obslytics is ready.\n"))
+	})
+	return mux
+}
+
+// runMetricsServer serves the metrics handler on the address until the group is interrupted.
+func runMetricsServer(g *run.Group, logger log.Logger, addr string, reg *prometheus.Registry, ready *readiness) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return errors.Wrap(err, "listen metrics address")
+	}
+	srv := &http.Server{Handler: newMetricsHandler(reg, ready)}
+	g.Add(func() error {
+		level.Info(logger).Log("msg", "serving metrics", "address", l.Addr())
+		if err := srv.Serve(l); err != http.ErrServerClosed {
+			return err
+		}
+		return nil
+	}, func(error) {
+		ready.set(false)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			level.Warn(logger).Log("msg", "metrics server shutdown failed", "err", err)
+		}
+	})
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/thanos-community/obslytics/pkg/exporter"
+	"github.com/thanos-io/thanos/pkg/testutil"
+	"google.golang.org/grpc"
+	channelzpb "google.golang.org/grpc/channelz/grpc_channelz_v1"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m := newExportMetrics(reg)
+	ready := &readiness{}
+	srv := httptest.NewServer(newMetricsHandler(reg, ready))
+	defer srv.Close()
+
+	get := func(path string) (int, string) {
+		resp, err := http.Get(srv.URL + path)
+		testutil.Ok(t, err)
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		testutil.Ok(t, err)
+		return resp.StatusCode, string(b)
+	}
+
+	code, _ := get("/-/healthy")
+	testutil.Equals(t, http.StatusOK, code)
+	code, _ = get("/-/ready")
+	testutil.Equals(t, http.StatusServiceUnavailable, code)
+	ready.set(true)
+	code, _ = get("/-/ready")
+	testutil.Equals(t, http.StatusOK, code)
+
+	// gRPC calls of the instrumented client are counted.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	testutil.Ok(t, err)
+	gsrv := newDebugServer()
+	go func() { _ = gsrv.Serve(l) }()
+	defer gsrv.Stop()
+
+	ctx := context.Background()
+	conn, err := grpc.DialContext(ctx, l.Addr().String(), append(m.dialOptions(), grpc.WithInsecure(), grpc.WithBlock())...)
+	testutil.Ok(t, err)
+	defer conn.Close()
+	_, err = channelzpb.NewChannelzClient(conn).GetTopChannels(ctx, &channelzpb.GetTopChannelsRequest{})
+	testutil.Ok(t, err)
+
+	m.observe(exportSummary{Series: 3, Outputs: []exporter.Output{{Bytes: 100}}, BytesWritten: 100}, time.Second, nil)
+	m.observe(exportSummary{Series: 1}, time.Second, errors.New("failed"))
+
+	code, body := get("/metrics")
+	testutil.Equals(t, http.StatusOK, code)
+	for _, exp := range []string{
+		`obslytics_exports_total{result="success"} 1`,
+		`obslytics_exports_total{result="failure"} 1`,
+		`obslytics_series_read_total 4`,
+		`obslytics_outputs_written_total 1`,
+		`obslytics_output_bytes_written_total 100`,
+		`obslytics_export_duration_seconds_count 2`,
+		`grpc_client_handled_total{grpc_code="OK",grpc_method="GetTopChannels",grpc_service="grpc.channelz.v1.Channelz",grpc_type="unary"} 1`,
+	} {
+		testutil.Assert(t, strings.Contains(body, exp), "%q not found in metrics:\n%s", exp, body)
+	}
+}
+
+func TestExportMetrics_Nil(t *testing.T) {
+	var m *exportMetrics
+	testutil.Equals(t, 0, len(m.dialOptions()))
+	m.observe(exportSummary{}, time.Second, nil)
+}
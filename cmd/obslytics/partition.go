@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/thanos-community/obslytics/pkg/dataframe"
+	"github.com/thanos-community/obslytics/pkg/exporter"
+
+	exportertfactory "github.com/thanos-community/obslytics/pkg/exporter/factory"
+)
+
+// partitionPathData is available to the output path template when partitioning the output by a label.
+type partitionPathData struct {
+	// Label is the label the output is partitioned by.
+	Label string
+	// Partition is the value of the label, empty for the series without it, escaped by pathValue.
+	Partition string
+}
+
+var pathValueReplacer = strings.NewReplacer("%", "%25", "/", "%2F", "\\", "%5C")
+
+// pathValue escapes the label value for the output path template, so that the value is a single path element and
+// cannot escape the directory of the template: the path separators are percent-encoded, as are the "." and ".."
+// values.
+func pathValue(v string) string {
+	v = pathValueReplacer.Replace(v)
+	if v == "." || v == ".." {
+		return strings.Repeat("%2E", len(v))
+	}
+	return v
+}
+
+// uniquePaths returns error if any of the rendered paths refer to the same file, which the parallel writers would
+// race on.
+func uniquePaths(paths []string, describe func(i int) string, example string) error {
+	seen := make(map[string]int, len(paths))
+	for i, p := range paths {
+		if j, ok := seen[filepath.Clean(p)]; ok {
+			return errors.Errorf("output path %q of %s is the same as of %s, use a template, e.g. %s",
+				p, describe(i), describe(j), example)
+		}
+		seen[filepath.Clean(p)] = i
+	}
+	return nil
+}
+
+// partitionPaths renders the output path template for every partition.
+func partitionPaths(pathTmpl, label string, parts []dataframe.Partition) ([]string, error) {
+	tmpl, err := template.New("path").Parse(pathTmpl)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing output path template")
+	}
+
+	paths := make([]string, 0, len(parts))
+	for _, p := range parts {
+		b := &bytes.Buffer{}
+		if err := tmpl.Execute(b, partitionPathData{Label: label, Partition: pathValue(p.Value)}); err != nil {
+			return nil, errors.Wrapf(err, "rendering output path for %s=%q", label, p.Value)
+		}
+		paths = append(paths, b.String())
+	}
+	describe := func(i int) string { return fmt.Sprintf("%s=%q", label, parts[i].Value) }
+	if err := uniquePaths(paths, describe, "{{.Partition}}.parquet"); err != nil {
+		return nil, err
+	}
+	return paths, nil
+}
+
+// exportPartitions exports the rows of every value of the label into its own output, with the output path as a
+// template rendered from the value. The dataframe is aggregated whole before it's partitioned, so every output is
+// written at once, at most writers of them in parallel. Nothing is written if there are more partitions than maxFiles.
+// Every partition is written as a tidy table if tidy is set, so that the label needs not to be a tidy column.
+func exportPartitions(logger log.Logger, outputCfg exporter.Config, df dataframe.Dataframe, label string, tidy *dataframe.TidyOptions, maxFiles, writers int) ([]exporter.Output, error) {
+	parts := dataframe.PartitionBy(df, label)
+	if maxFiles > 0 && len(parts) > maxFiles {
+		level.Warn(logger).Log("msg", "too many partitions to write, nothing written", "label", label, "partitions", len(parts), "max", maxFiles)
+		return nil, errors.Errorf("partitioning by %s into %d files would exceed partition-max-files %d, narrow the matchers or raise the limit",
+			label, len(parts), maxFiles)
+	}
+	paths, err := partitionPaths(outputCfg.Path, label, parts)
+	if err != nil {
+		return nil, err
+	}
+
+	return writeFiles(len(parts), writers, func(ctx context.Context, i int) ([]exporter.Output, error) {
+		out := outputCfg
+		out.Path = paths[i]
+		var part dataframe.Dataframe = parts[i]
+		if tidy != nil {
+			var err error
+			if part, err = dataframe.Tidy(parts[i], *tidy); err != nil {
+				return nil, err
+			}
+		}
+		exp, err := exportertfactory.NewExporter(logger, out)
+		if err != nil {
+			return nil, err
+		}
+		// Like the single output, the files are written regardless of the cancellation of the export, their uploads are
+		// canceled only once any of the other files fails, by the storages supporting it.
+		if err := exp.Export(ctx, part); err != nil {
+			return exp.Outputs(), errors.Wrapf(err, "export partition %s=%q", label, parts[i].Value)
+		}
+		return exp.Outputs(), nil
+	})
+}
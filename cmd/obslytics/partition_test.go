@@ -0,0 +1,122 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/tsdbutil"
+	"github.com/thanos-community/obslytics/pkg/dataframe"
+	"github.com/thanos-community/obslytics/pkg/exporter"
+	"github.com/thanos-io/thanos/pkg/objstore/client"
+	"github.com/thanos-io/thanos/pkg/testutil"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+func TestExportPartitions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "partition")
+	testutil.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	df, err := dataframe.FromSeries(&listSet{series: []storage.Series{
+		storage.NewListSeries(labels.FromStrings("__name__", "up", "instance", "a", "namespace", "x"), []tsdbutil.Sample{sample{t: 0, v: 1}}),
+		storage.NewListSeries(labels.FromStrings("__name__", "up", "instance", "b", "namespace", "y"), []tsdbutil.Sample{sample{t: 0, v: 2}}),
+		storage.NewListSeries(labels.FromStrings("__name__", "up", "instance", "c", "namespace", "x"), []tsdbutil.Sample{sample{t: 0, v: 3}}),
+		storage.NewListSeries(labels.FromStrings("__name__", "up", "instance", "d"), []tsdbutil.Sample{sample{t: 0, v: 4}}),
+	}, i: -1}, time.Minute, func(o *dataframe.AggrsOptions) { o.Count.Enabled = true })
+	testutil.Ok(t, err)
+
+	out := exporter.Config{
+		Type:    exporter.PARQUET,
+		Path:    "{{.Label}}/{{or .Partition \"none\"}}.parquet",
+		Storage: client.BucketConfig{Type: client.FILESYSTEM, Config: map[string]string{"directory": dir}},
+	}
+
+	// Nothing is written over the limit.
+	_, err = exportPartitions(log.NewNopLogger(), out, df, "namespace", nil, 2, 1)
+	testutil.NotOk(t, err)
+	_, err = os.Stat(filepath.Join(dir, "namespace"))
+	testutil.Assert(t, os.IsNotExist(err))
+
+	// The outputs written in parallel are returned in order.
+	outputs, err := exportPartitions(log.NewNopLogger(), out, df, "namespace", nil, 3, 2)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 3, len(outputs))
+	for i, name := range []string{"x", "y", "none"} {
+		testutil.Equals(t, "namespace/"+name+".parquet", outputs[i].Path)
+		_, err := os.Stat(filepath.Join(dir, "namespace", name+".parquet"))
+		testutil.Ok(t, err)
+	}
+
+	// Paths have to be unique.
+	out.Path = "{{.Label}}.parquet"
+	_, err = exportPartitions(log.NewNopLogger(), out, df, "namespace", nil, 0, 1)
+	testutil.NotOk(t, err)
+}
+
+func TestExportPartitions_Tidy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "partition")
+	testutil.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	df, err := dataframe.FromSeries(&listSet{series: []storage.Series{
+		storage.NewListSeries(labels.FromStrings("__name__", "up", "instance", "a"), []tsdbutil.Sample{sample{t: 0, v: 1}}),
+		storage.NewListSeries(labels.FromStrings("__name__", "down", "instance", "b"), []tsdbutil.Sample{sample{t: 0, v: 2}}),
+		storage.NewListSeries(labels.FromStrings("__name__", "up", "instance", "c"), []tsdbutil.Sample{sample{t: 0, v: 3}}),
+	}, i: -1}, time.Minute, func(o *dataframe.AggrsOptions) {
+		o.MetricName.Enabled = true
+		o.Sum.Enabled = true
+	})
+	testutil.Ok(t, err)
+
+	out := exporter.Config{
+		Type:    exporter.PARQUET,
+		Path:    "{{.Partition}}.parquet",
+		Storage: client.BucketConfig{Type: client.FILESYSTEM, Config: map[string]string{"directory": dir}},
+	}
+	// The metric name is not a column of the tidy table, the partitions are made before tidying.
+	tidy := &dataframe.TidyOptions{Metric: "__name__", Value: "_sum", Dimensions: []string{"instance"}}
+	outputs, err := exportPartitions(log.NewNopLogger(), out, df, "__name__", tidy, 0, 1)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 2, len(outputs))
+	for i, p := range []struct {
+		name string
+		rows int64
+	}{{name: "up", rows: 2}, {name: "down", rows: 1}} {
+		testutil.Equals(t, p.name+".parquet", outputs[i].Path)
+
+		f, err := local.NewLocalFileReader(filepath.Join(dir, p.name+".parquet"))
+		testutil.Ok(t, err)
+		r, err := reader.NewParquetColumnReader(f, 1)
+		testutil.Ok(t, err)
+		testutil.Equals(t, p.rows, r.GetNumRows())
+		var columns []string
+		for i := 1; i < len(r.SchemaHandler.Infos); i++ {
+			columns = append(columns, r.SchemaHandler.GetExName(i))
+		}
+		testutil.Equals(t, []string{"timestamp", "metric", "value", "instance"}, columns)
+		r.ReadStop()
+		testutil.Ok(t, f.Close())
+	}
+}
+
+func TestPartitionPaths(t *testing.T) {
+	parts := []dataframe.Partition{{Value: "../../etc"}, {Value: ".."}, {Value: "a\\b"}, {Value: "50%"}}
+	paths, err := partitionPaths("out/{{.Partition}}.parquet", "namespace", parts)
+	testutil.Ok(t, err)
+	testutil.Equals(t, []string{"out/..%2F..%2Fetc.parquet", "out/%2E%2E.parquet", "out/a%5Cb.parquet", "out/50%25.parquet"}, paths)
+
+	paths, err = partitionPaths("out/{{.Partition}}", "namespace", parts[1:2])
+	testutil.Ok(t, err)
+	testutil.Equals(t, []string{"out/%2E%2E"}, paths)
+
+	// The paths of the same file are not unique.
+	_, err = partitionPaths("out/{{if .Partition}}a{{else}}./a{{end}}.parquet", "namespace", []dataframe.Partition{{Value: "x"}, {Value: ""}})
+	testutil.NotOk(t, err)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/relabel"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/thanos-community/obslytics/pkg/series"
+)
+
+// relabelSet applies the relabel configs to the labels of every series. Series relabeled to empty labels,
+// e.g. by the drop action, are skipped.
+type relabelSet struct {
+	series.Set
+
+	cfgs []*relabel.Config
+	cur  storage.Series
+}
+
+func newRelabelSet(s series.Set, cfgs []*relabel.Config) *relabelSet {
+	return &relabelSet{Set: s, cfgs: cfgs}
+}
+
+func (s *relabelSet) Next() bool {
+	for s.Set.Next() {
+		ser := s.Set.At()
+		if lset := relabel.Process(ser.Labels(), s.cfgs...); lset != nil {
+			s.cur = relabeledSeries{Series: ser, lset: lset}
+			return true
+		}
+	}
+	return false
+}
+
+func (s *relabelSet) At() storage.Series { return s.cur }
+
+type relabeledSeries struct {
+	storage.Series
+
+	lset labels.Labels
+}
+
+func (s relabeledSeries) Labels() labels.Labels { return s.lset }
+
+func (s relabeledSeries) Unwrap() storage.Series { return s.Series }
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"math"
+
+	"github.com/prometheus/prometheus/storage"
+	"github.com/thanos-community/obslytics/pkg/dataframe"
+	"github.com/thanos-community/obslytics/pkg/series"
+)
+
+// sampleSeries returns the set of the fraction of the series whose fingerprint (the _series_id column) falls under
+// the fraction of the fingerprint space. The selection depends only on the series labels, so that the same subset is
+// selected across runs, and a subset of a larger fraction contains the subsets of the smaller ones. Zero (unset)
+// fraction keeps all the series.
+func sampleSeries(s series.Set, fraction float64) series.Set {
+	if fraction <= 0 || fraction >= 1 {
+		return s
+	}
+	return &sampledSet{Set: s, max: uint64(fraction * math.Exp2(64))}
+}
+
+type sampledSet struct {
+	series.Set
+
+	max uint64
+	cur storage.Series
+}
+
+func (s *sampledSet) Next() bool {
+	for s.Set.Next() {
+		if s.cur = s.Set.At(); dataframe.Fingerprint(s.cur.Labels()) < s.max {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *sampledSet) At() storage.Series { return s.cur }
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/thanos-community/obslytics/pkg/dataframe"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestSampleSeries(t *testing.T) {
+	var all []storage.Series
+	for i := 0; i < 1000; i++ {
+		all = append(all, storage.NewListSeries(labels.FromStrings("__name__", "up", "instance", fmt.Sprint(i)), nil))
+	}
+	sample := func(fraction float64) map[string]struct{} {
+		s := sampleSeries(&listSet{series: all, i: -1}, fraction)
+		got := map[string]struct{}{}
+		for s.Next() {
+			lset := s.At().Labels()
+			if fraction > 0 && fraction < 1 {
+				testutil.Assert(t, float64(dataframe.Fingerprint(lset)) < fraction*math.Exp2(64))
+			}
+			got[lset.String()] = struct{}{}
+		}
+		testutil.Ok(t, s.Err())
+		return got
+	}
+
+	tenth := sample(0.1)
+	testutil.Assert(t, len(tenth) > 50 && len(tenth) < 150, "expected about 100 series, got %d", len(tenth))
+	// Stable across runs, and contained in the larger fractions.
+	testutil.Equals(t, tenth, sample(0.1))
+	half := sample(0.5)
+	for s := range tenth {
+		_, ok := half[s]
+		testutil.Assert(t, ok, "series %s of 0.1 missing in 0.5", s)
+	}
+	testutil.Equals(t, 1000, len(sample(1)))
+	testutil.Equals(t, 1000, len(sample(0)))
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/thanos-community/obslytics/pkg/dataframe"
+	"github.com/thanos-community/obslytics/pkg/series"
+
+	infactory "github.com/thanos-community/obslytics/pkg/series/factory"
+)
+
+// exportSchema prints the schema of the export instead of running it.
+func exportSchema(ctx context.Context, logger log.Logger, inputConfig series.Config, opts exportOptions) error {
+	matchers, err := parser.ParseMetricSelector(opts.matchers)
+	if err != nil {
+		return errors.Wrap(err, "parsing provided matchers")
+	}
+	in, err := infactory.NewSeriesReader(logger, inputConfig, opts.dialOptions()...)
+	if err != nil {
+		return err
+	}
+	return printSchema(ctx, logger, os.Stdout, in, readParams(matchers, opts), opts, opts.schemaSeries)
+}
+
+// printSchema prints the columns the export would write, computed from the label sets of at most maxSeries of the
+// series read without their samples. The series are filtered, relabeled and enriched as by the export, so that
+// mistakes in the label filters show up before a long run.
+func printSchema(ctx context.Context, logger log.Logger, w io.Writer, in series.Reader, params series.Params, opts exportOptions, maxSeries int) error {
+	params.SkipChunks = true
+	params.Snapshot = false
+	s, err := in.Read(ctx, params)
+	if err != nil {
+		return err
+	}
+	if opts.allowlist != nil {
+		s = opts.allowlist.filter(s)
+	}
+	s = outputSeries(s, opts)
+	ls := &limitSet{Set: &interruptibleSet{Set: s, ctx: ctx}, limit: maxSeries}
+
+	schema, err := dataframe.SchemaOf(ls, opts.aggrOptions)
+	if err != nil {
+		return errors.Wrap(err, "schema")
+	}
+	level.Info(logger).Log("msg", "schema computed", "matchers", opts.matchers, "series", ls.n, "limited", ls.limited)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "# %s\n", opts.matchers)
+	fmt.Fprintln(tw, "COLUMN\tTYPE")
+	for _, c := range schema {
+		fmt.Fprintf(tw, "%s\t%s\n", c.Name, c.Type)
+	}
+	return tw.Flush()
+}
+
+// limitSet stops after limit series.
+type limitSet struct {
+	series.Set
+
+	limit   int
+	n       int
+	limited bool
+}
+
+func (s *limitSet) Next() bool {
+	if s.n >= s.limit {
+		s.limited = true
+		return false
+	}
+	if !s.Set.Next() {
+		return false
+	}
+	s.n++
+	return true
+}
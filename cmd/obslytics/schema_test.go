@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/relabel"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/thanos-community/obslytics/pkg/series"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestPrintSchema(t *testing.T) {
+	r := &matchingReader{series: []storage.Series{
+		storage.NewListSeries(labels.FromStrings("__name__", "up", "instance", "a", "pod", "x"), nil),
+		storage.NewListSeries(labels.FromStrings("__name__", "up", "job", "b"), nil),
+	}}
+	opts := exportOptions{
+		matchers: "up",
+		delta:    true,
+		first:    true,
+		relabelConfigs: []*relabel.Config{{
+			Regex:  relabel.MustNewRegexp("pod"),
+			Action: relabel.LabelDrop,
+		}},
+	}
+	params := series.Params{Matchers: []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "__name__", "up")}}
+
+	var b bytes.Buffer
+	testutil.Ok(t, printSchema(context.Background(), log.NewNopLogger(), &b, r, params, opts, 10))
+	testutil.Equals(t, strings.Join([]string{
+		"# up",
+		"COLUMN         TYPE",
+		"instance       string",
+		"job            string",
+		"_sample_start  time",
+		"_sample_end    time",
+		"_min_time      time",
+		"_max_time      time",
+		"_count         uint",
+		"_sum           float",
+		"_min           float",
+		"_max           float",
+		"_first         float",
+		"",
+	}, "\n"), b.String())
+
+	// Labels of the series over the limit are not included.
+	b.Reset()
+	testutil.Ok(t, printSchema(context.Background(), log.NewNopLogger(), &b, r, params, opts, 1))
+	testutil.Assert(t, !strings.Contains(b.String(), "job"), b.String())
+
+	// The selected aggregation functions replace the default ones, first is added by its flag.
+	b.Reset()
+	opts.functions = []string{"min", "max", "avg", "count"}
+	testutil.Ok(t, printSchema(context.Background(), log.NewNopLogger(), &b, r, params, opts, 10))
+	testutil.Assert(t, strings.HasSuffix(b.String(), strings.Join([]string{
+		"_count         uint",
+		"_min           float",
+		"_max           float",
+		"_avg           float",
+		"_first         float",
+		"",
+	}, "\n")), b.String())
+}
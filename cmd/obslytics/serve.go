@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/oklog/run"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/thanos-community/obslytics/pkg/server"
+	"github.com/thanos-io/thanos/pkg/extflag"
+	"github.com/thanos-io/thanos/pkg/model"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+func registerServe(m map[string]setupFunc, app *kingpin.Application) {
+	cmd := app.Command("serve", "Run as a service exposing export over HTTP API.")
+	inputFlag := extflag.RegisterPathOrContent(cmd, "input-config", "YAML for input, series configuration.", true)
+	outputFlag := extflag.RegisterPathOrContent(cmd, "output-config", "YAML for dataframe export configuration. Path is given by each job.", false)
+
+	httpAddr := cmd.Flag("http-address", "Listen host:port for HTTP API.").Default("0.0.0.0:8080").String()
+	maxJobs := cmd.Flag("max-concurrent-jobs", "Maximum number of jobs running at the same time.").Default("4").Int()
+
+	m["serve"] = func(g *run.Group, logger log.Logger, reg *prometheus.Registry) error {
+		if *maxJobs <= 0 {
+			return errors.New("max-concurrent-jobs has to be positive")
+		}
+
+		inputConfig, err := parseInputConfig(inputFlag, nil)
+		if err != nil {
+			return err
+		}
+
+		outputConfig, err := parseOutputConfig(outputFlag, nil)
+		if err != nil {
+			return err
+		}
+
+		metrics := newExportMetrics(reg)
+		srv := server.New(logger, func(ctx context.Context, job server.Job, progress func(float64)) error {
+			out := outputConfig
+			out.Path = job.Path
+			return export(ctx, logger, inputConfig, out, exportOptions{
+				matchers:   job.Match,
+				mint:       model.TimeOrDurationValue{Time: &job.MinTime},
+				maxt:       model.TimeOrDurationValue{Time: &job.MaxTime},
+				resolution: time.Duration(job.Resolution),
+				metrics:    metrics,
+				progress:   progress,
+			})
+		}, *maxJobs)
+
+		httpSrv := &http.Server{Addr: *httpAddr, Handler: srv.Handler()}
+		g.Add(func() error {
+			level.Info(logger).Log("msg", "listening for requests", "address", *httpAddr)
+			return httpSrv.ListenAndServe()
+		}, func(error) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := httpSrv.Shutdown(ctx); err != nil {
+				level.Warn(logger).Log("msg", "HTTP server shutdown failed", "err", err)
+			}
+		})
+		return nil
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/thanos-community/obslytics/pkg/dataframe"
+	"github.com/thanos-community/obslytics/pkg/exporter"
+	"github.com/thanos-community/obslytics/pkg/series"
+
+	infactory "github.com/thanos-community/obslytics/pkg/series/factory"
+)
+
+// shardValues returns the values of the label across the series selected by the params, reading only the series
+// labels. The empty value is included if some of the series don't have the label.
+func shardValues(ctx context.Context, in series.Reader, params series.Params, label string) ([]string, error) {
+	params.SkipChunks = true
+	s, err := in.Read(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	values, err := series.LabelValues(s, label)
+	if err != nil {
+		return nil, errors.Wrapf(err, "listing values of shard label %q", label)
+	}
+	return values, nil
+}
+
+// shardMatchers returns the matchers restricted to the series with the label value.
+func shardMatchers(ms []*labels.Matcher, label, value string) []*labels.Matcher {
+	ret := make([]*labels.Matcher, 0, len(ms)+1)
+	ret = append(ret, ms...)
+	return append(ret, labels.MustNewMatcher(labels.MatchEqual, label, value))
+}
+
+// selectorString formats the matchers as a metric selector, e.g. {__name__="up",job="a"}.
+func selectorString(ms []*labels.Matcher) string {
+	strs := make([]string, 0, len(ms))
+	for _, m := range ms {
+		strs = append(strs, m.String())
+	}
+	return "{" + strings.Join(strs, ",") + "}"
+}
+
+// readShards reads the series selected by the params in shards, one per value of the shard-by label, running at
+// most shardConcurrency reads in parallel. The shards are merged into a single dataframe.
+func readShards(ctx context.Context, logger log.Logger, in series.Reader, params series.Params, opts exportOptions) (readResult, error) {
+	concurrency := opts.shardConcurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	values, err := shardValues(ctx, in, params, opts.shardBy)
+	if err != nil {
+		return readResult{}, err
+	}
+	level.Debug(logger).Log("msg", "reading shards", "label", opts.shardBy, "shards", len(values))
+
+	var (
+		wg      sync.WaitGroup
+		slots   = make(chan struct{}, concurrency)
+		results = make([]readResult, len(values))
+		errs    = make([]error, len(values))
+	)
+	for i, v := range values {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(i int, v string) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+
+			p := params
+			p.Matchers = shardMatchers(params.Matchers, opts.shardBy, v)
+			results[i], errs[i] = readDataframe(ctx, in, p, opts)
+		}(i, v)
+	}
+	wg.Wait()
+
+	ret := readResult{seen: map[uint64]struct{}{}}
+	dfs := make([]dataframe.Dataframe, 0, len(results))
+	for i, r := range results {
+		if errs[i] != nil {
+			return readResult{}, errors.Wrapf(errs[i], "read shard %s=%q", opts.shardBy, values[i])
+		}
+		dfs = append(dfs, r.df)
+		ret.countColumn = r.countColumn
+		ret.series += r.series
+		for h := range r.seen {
+			ret.seen[h] = struct{}{}
+		}
+		ret.warnings = append(ret.warnings, r.warnings...)
+		ret.interrupted = ret.interrupted || r.interrupted
+	}
+	ret.df = dataframe.Merge(dfs...)
+	return ret, nil
+}
+
+// exportShards exports every shard of the matcher, one per value of the shard-by label, into its own output file,
+// running at most concurrency exports in parallel. Returns error if any of the exports failed.
+func exportShards(
+	ctx context.Context,
+	logger log.Logger,
+	inputConfig series.Config,
+	outputCfg exporter.Config,
+	opts exportOptions,
+	concurrency int,
+) error {
+	ms, err := parser.ParseMetricSelector(opts.matchers)
+	if err != nil {
+		return errors.Wrap(err, "parsing provided matchers")
+	}
+
+	in, err := infactory.NewSeriesReader(logger, inputConfig, opts.dialOptions()...)
+	if err != nil {
+		return err
+	}
+	values, err := shardValues(ctx, in, readParams(ms, opts), opts.shardBy)
+	if err != nil {
+		return err
+	}
+
+	matchers := make([]string, 0, len(values))
+	for _, v := range values {
+		matchers = append(matchers, selectorString(shardMatchers(ms, opts.shardBy, v)))
+	}
+	paths, err := outputPaths(outputCfg.Path, matchers, values)
+	if err != nil {
+		return err
+	}
+
+	opts.shardBy = ""
+	return exportEach(ctx, logger, inputConfig, outputCfg, opts, matchers, paths, concurrency)
+}
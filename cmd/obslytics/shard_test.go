@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/tsdbutil"
+	"github.com/thanos-community/obslytics/pkg/dataframe"
+	"github.com/thanos-community/obslytics/pkg/series"
+	"github.com/thanos-io/thanos/pkg/model"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func (s sample) T() int64 { return s.t }
+
+func (s sample) V() float64 { return s.v }
+
+// matchingReader returns the series matching the params matchers.
+type matchingReader struct {
+	series []storage.Series
+	reads  int
+}
+
+func (r *matchingReader) Read(_ context.Context, p series.Params) (series.Set, error) {
+	r.reads++
+	ret := &listSet{i: -1}
+	for _, s := range r.series {
+		matches := true
+		for _, m := range p.Matchers {
+			matches = matches && m.Matches(s.Labels().Get(m.Name))
+		}
+		if matches {
+			ret.series = append(ret.series, s)
+		}
+	}
+	return ret, nil
+}
+
+func TestShardMatchers(t *testing.T) {
+	ms := []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "up")}
+	testutil.Equals(t, `{__name__="up",instance="a:80"}`, selectorString(shardMatchers(ms, "instance", "a:80")))
+	testutil.Equals(t, `{__name__="up",instance=""}`, selectorString(shardMatchers(ms, "instance", "")))
+	// The original matchers are not modified.
+	testutil.Equals(t, 1, len(ms))
+
+	paths, err := outputPaths("out/{{.Shard}}.parquet", []string{`{instance="a"}`, `{instance="b"}`}, []string{"a", "b"})
+	testutil.Ok(t, err)
+	testutil.Equals(t, []string{"out/a.parquet", "out/b.parquet"}, paths)
+
+	// The shard values cannot escape the directory of the template.
+	paths, err = outputPaths("out/{{.Shard}}", []string{`{instance="a"}`, `{instance="b"}`, `{instance="c"}`}, []string{"../etc/passwd", "..", "a/b"})
+	testutil.Ok(t, err)
+	testutil.Equals(t, []string{"out/..%2Fetc%2Fpasswd", "out/%2E%2E", "out/a%2Fb"}, paths)
+}
+
+func TestReadShards(t *testing.T) {
+	samples := []tsdbutil.Sample{sample{t: 0, v: 1}, sample{t: 1000, v: 3}}
+	in := &matchingReader{series: []storage.Series{
+		storage.NewListSeries(labels.FromStrings("__name__", "up", "instance", "a"), samples),
+		storage.NewListSeries(labels.FromStrings("__name__", "up", "instance", "b", "zone", "z1"), samples),
+		storage.NewListSeries(labels.FromStrings("__name__", "up"), samples),
+		storage.NewListSeries(labels.FromStrings("__name__", "down", "instance", "a"), samples),
+	}}
+
+	mint, maxt := time.Unix(0, 0), time.Unix(3600, 0)
+	opts := exportOptions{
+		mint:             model.TimeOrDurationValue{Time: &mint},
+		maxt:             model.TimeOrDurationValue{Time: &maxt},
+		resolution:       time.Hour,
+		shardBy:          "instance",
+		shardConcurrency: 2,
+	}
+	params := readParams([]*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "up")}, opts)
+	res, err := readShards(context.Background(), log.NewNopLogger(), in, params, opts)
+	testutil.Ok(t, err)
+
+	// One read to list the shards, one per shard ("", "a" and "b").
+	testutil.Equals(t, 4, in.reads)
+	testutil.Equals(t, 3, res.series)
+	testutil.Equals(t, "_count", res.countColumn)
+
+	cols := map[string]int{}
+	for i, c := range res.df.Schema() {
+		cols[c.Name] = i
+	}
+	label := func(r dataframe.Row, name string) string {
+		v, _ := r[cols[name]].(string)
+		return v
+	}
+
+	var got []string
+	for i := res.df.RowsIterator(); i.Next(); {
+		row := i.At()
+		testutil.Equals(t, uint64(2), row[cols["_count"]])
+		got = append(got, label(row, "instance")+"/"+label(row, "zone"))
+	}
+	testutil.Equals(t, []string{"/", "a/", "b/z1"}, got)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"text/template"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/thanos-community/obslytics/pkg/dataframe"
+	"github.com/thanos-community/obslytics/pkg/exporter"
+
+	exportertfactory "github.com/thanos-community/obslytics/pkg/exporter/factory"
+)
+
+// seriesPathData is available to the output path template when splitting the output by series.
+type seriesPathData struct {
+	// Name is the metric name of the series, escaped by pathValue.
+	Name string
+	// Labels are the series labels by name, e.g. {{.Labels.instance}}, with the values escaped by pathValue.
+	Labels map[string]string
+	// Fingerprint is the hex encoded stable fingerprint of the series labels, same as the _series_id column.
+	Fingerprint string
+}
+
+// seriesPaths renders the output path template for every series.
+func seriesPaths(pathTmpl string, sdfs []dataframe.SeriesDataframe) ([]string, error) {
+	tmpl, err := template.New("path").Option("missingkey=zero").Parse(pathTmpl)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing output path template")
+	}
+
+	paths := make([]string, 0, len(sdfs))
+	for _, sdf := range sdfs {
+		d := seriesPathData{
+			Name:        pathValue(sdf.Labels.Get(labels.MetricName)),
+			Labels:      make(map[string]string, len(sdf.Labels)),
+			Fingerprint: fmt.Sprintf("%016x", sdf.Fingerprint),
+		}
+		for _, l := range sdf.Labels {
+			d.Labels[l.Name] = pathValue(l.Value)
+		}
+		b := &bytes.Buffer{}
+		if err := tmpl.Execute(b, d); err != nil {
+			return nil, errors.Wrapf(err, "rendering output path for series %s", sdf.Labels)
+		}
+		paths = append(paths, b.String())
+	}
+	describe := func(i int) string { return "series " + sdfs[i].Labels.String() }
+	if err := uniquePaths(paths, describe, "{{.Fingerprint}}.parquet"); err != nil {
+		return nil, err
+	}
+	return paths, nil
+}
+
+// exportSeriesFiles exports every series of the dataframe into its own output, with the output path as a template
+// rendered from the series labels, at most writers of them in parallel. Nothing is written if there are more series
+// than maxFiles.
+func exportSeriesFiles(logger log.Logger, outputCfg exporter.Config, df dataframe.Dataframe, maxFiles, writers int) ([]exporter.Output, error) {
+	sdfs, ok := dataframe.SplitSeries(df)
+	if !ok {
+		return nil, errors.New("the dataframe cannot be split by series")
+	}
+	if maxFiles > 0 && len(sdfs) > maxFiles {
+		level.Warn(logger).Log("msg", "too many series to split into files, nothing written", "series", len(sdfs), "max", maxFiles)
+		return nil, errors.Errorf("splitting %d series would exceed split-series-max-files %d, narrow the matchers or raise the limit",
+			len(sdfs), maxFiles)
+	}
+	paths, err := seriesPaths(outputCfg.Path, sdfs)
+	if err != nil {
+		return nil, err
+	}
+
+	return writeFiles(len(sdfs), writers, func(ctx context.Context, i int) ([]exporter.Output, error) {
+		out := outputCfg
+		out.Path = paths[i]
+		exp, err := exportertfactory.NewExporter(logger, out)
+		if err != nil {
+			return nil, err
+		}
+		// Like the single output, the files are written regardless of the cancellation of the export, their uploads are
+		// canceled only once any of the other files fails, by the storages supporting it.
+		if err := exp.Export(ctx, sdfs[i]); err != nil {
+			return exp.Outputs(), errors.Wrapf(err, "export series %s", sdfs[i].Labels)
+		}
+		return exp.Outputs(), nil
+	})
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/tsdbutil"
+	"github.com/thanos-community/obslytics/pkg/dataframe"
+	"github.com/thanos-community/obslytics/pkg/exporter"
+	"github.com/thanos-io/thanos/pkg/objstore/client"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestExportSeriesFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "split")
+	testutil.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	df, err := dataframe.FromSeries(&listSet{series: []storage.Series{
+		storage.NewListSeries(labels.FromStrings("__name__", "up", "instance", "a"), []tsdbutil.Sample{sample{t: 0, v: 1}}),
+		storage.NewListSeries(labels.FromStrings("__name__", "up", "instance", "b"), []tsdbutil.Sample{sample{t: 0, v: 2}}),
+		storage.NewListSeries(labels.FromStrings("__name__", "up", "instance", "c"), []tsdbutil.Sample{sample{t: 0, v: 3}}),
+	}, i: -1}, time.Minute, func(o *dataframe.AggrsOptions) { o.Count.Enabled = true })
+	testutil.Ok(t, err)
+
+	out := exporter.Config{
+		Type:    exporter.PARQUET,
+		Path:    "{{.Name}}/{{.Labels.instance}}.parquet",
+		Storage: client.BucketConfig{Type: client.FILESYSTEM, Config: map[string]string{"directory": dir}},
+	}
+
+	// Nothing is written over the limit.
+	_, err = exportSeriesFiles(log.NewNopLogger(), out, df, 2, 1)
+	testutil.NotOk(t, err)
+	_, err = os.Stat(filepath.Join(dir, "up"))
+	testutil.Assert(t, os.IsNotExist(err))
+
+	outputs, err := exportSeriesFiles(log.NewNopLogger(), out, df, 3, 1)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 3, len(outputs))
+	for i, name := range []string{"a", "b", "c"} {
+		testutil.Equals(t, "up/"+name+".parquet", outputs[i].Path)
+		_, err := os.Stat(filepath.Join(dir, "up", name+".parquet"))
+		testutil.Ok(t, err)
+	}
+
+	// Paths have to be unique.
+	out.Path = "{{.Name}}.parquet"
+	_, err = exportSeriesFiles(log.NewNopLogger(), out, df, 0, 1)
+	testutil.NotOk(t, err)
+}
+
+func TestSeriesPaths(t *testing.T) {
+	lset := labels.FromStrings("__name__", "up", "job", "a")
+	paths, err := seriesPaths("{{.Name}}-{{.Labels.job}}{{.Labels.missing}}-{{.Fingerprint}}.parquet", []dataframe.SeriesDataframe{
+		{Labels: lset, Fingerprint: dataframe.Fingerprint(lset)},
+	})
+	testutil.Ok(t, err)
+	testutil.Equals(t, []string{"up-a-" + fmt.Sprintf("%016x", dataframe.Fingerprint(lset)) + ".parquet"}, paths)
+
+	// The label values cannot escape the directory of the template.
+	lset = labels.FromStrings("__name__", "up", "job", "../../etc")
+	paths, err = seriesPaths("out/{{.Labels.job}}/{{.Name}}.parquet", []dataframe.SeriesDataframe{{Labels: lset}})
+	testutil.Ok(t, err)
+	testutil.Equals(t, []string{"out/..%2F..%2Fetc/up.parquet"}, paths)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/thanos-community/obslytics/pkg/series"
+)
+
+// validateStaticLabels checks the static labels are valid label names with values, other than the metric name.
+func validateStaticLabels(m map[string]string) error {
+	for n, v := range m {
+		if !model.LabelName(n).IsValid() || n == labels.MetricName {
+			return errors.Errorf("invalid static label name %q", n)
+		}
+		if v == "" {
+			return errors.Errorf("static label %s has to have a value", n)
+		}
+	}
+	return nil
+}
+
+// staticLabelsSet adds the same labels to every series, e.g. the environment or the id of the export run, replacing
+// the labels of the series of the same name. Unlike relabeling, it needs no rules for the common case of tagging all
+// the series.
+type staticLabelsSet struct {
+	series.Set
+
+	lset labels.Labels
+	cur  storage.Series
+}
+
+func newStaticLabelsSet(s series.Set, m map[string]string) series.Set {
+	if len(m) == 0 {
+		return s
+	}
+	return &staticLabelsSet{Set: s, lset: labels.FromMap(m)}
+}
+
+func (s *staticLabelsSet) Next() bool {
+	if !s.Set.Next() {
+		return false
+	}
+	ser := s.Set.At()
+	b := labels.NewBuilder(ser.Labels())
+	for _, l := range s.lset {
+		b.Set(l.Name, l.Value)
+	}
+	s.cur = relabeledSeries{Series: ser, lset: b.Labels()}
+	return true
+}
+
+func (s *staticLabelsSet) At() storage.Series { return s.cur }
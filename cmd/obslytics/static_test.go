@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestStaticLabelsSet(t *testing.T) {
+	s := newStaticLabelsSet(&listSet{series: []storage.Series{
+		storage.NewListSeries(labels.FromStrings("__name__", "up", "pod", "a"), nil),
+		storage.NewListSeries(labels.FromStrings("__name__", "up", "env", "dev", "pod", "b"), nil),
+	}, i: -1}, map[string]string{"env": "prod", "run": "42"})
+
+	var got []labels.Labels
+	for s.Next() {
+		got = append(got, s.At().Labels())
+	}
+	testutil.Ok(t, s.Err())
+	testutil.Equals(t, []labels.Labels{
+		labels.FromStrings("__name__", "up", "env", "prod", "pod", "a", "run", "42"),
+		labels.FromStrings("__name__", "up", "env", "prod", "pod", "b", "run", "42"),
+	}, got)
+
+	testutil.Ok(t, validateStaticLabels(map[string]string{"env": "prod"}))
+	testutil.NotOk(t, validateStaticLabels(map[string]string{"__name__": "up"}))
+	testutil.NotOk(t, validateStaticLabels(map[string]string{"a-b": "c"}))
+	testutil.NotOk(t, validateStaticLabels(map[string]string{"env": ""}))
+}
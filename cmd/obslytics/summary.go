@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/prometheus/prometheus/storage"
+	"github.com/thanos-community/obslytics/pkg/dataframe"
+	"github.com/thanos-community/obslytics/pkg/exporter"
+	"github.com/thanos-community/obslytics/pkg/series"
+)
+
+// exportSummary is the machine-readable summary of a single export.
+type exportSummary struct {
+	// Effective configuration of the export.
+	Matchers   string    `json:"matchers"`
+	MinTime    time.Time `json:"min_time"`
+	MaxTime    time.Time `json:"max_time"`
+	Resolution string    `json:"resolution"`
+
+	Series       int               `json:"series"`
+	Samples      uint64            `json:"samples"`
+	BytesWritten int64             `json:"bytes_written"`
+	Outputs      []exporter.Output `json:"outputs"`
+	Duration     string            `json:"duration"`
+	Warnings     []string          `json:"warnings,omitempty"`
+	Error        string            `json:"error,omitempty"`
+}
+
+// summaryWriter writes the summaries as JSON objects, one per line. It's safe for concurrent use.
+type summaryWriter struct {
+	mtx sync.Mutex
+	enc *json.Encoder
+}
+
+func newSummaryWriter(w io.Writer) *summaryWriter {
+	return &summaryWriter{enc: json.NewEncoder(w)}
+}
+
+func (w *summaryWriter) write(s exportSummary) error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	return w.enc.Encode(s)
+}
+
+// countingSet counts the unique series read from the set.
+type countingSet struct {
+	series.Set
+
+	seen map[uint64]struct{}
+}
+
+func newCountingSet(s series.Set) *countingSet {
+	return &countingSet{Set: s, seen: map[uint64]struct{}{}}
+}
+
+func (c *countingSet) At() storage.Series {
+	s := c.Set.At()
+	c.seen[s.Labels().Hash()] = struct{}{}
+	return s
+}
+
+// countSamples returns the number of samples aggregated into the dataframe, summing up the count column.
+func countSamples(df dataframe.Dataframe, countColumn string) uint64 {
+	col := -1
+	for i, c := range df.Schema() {
+		if c.Name == countColumn {
+			col = i
+		}
+	}
+	if col < 0 {
+		return 0
+	}
+
+	var n uint64
+	for i := df.RowsIterator(); i.Next(); {
+		if v, ok := i.At()[col].(uint64); ok {
+			n += v
+		}
+	}
+	return n
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/thanos-community/obslytics/pkg/dataframe"
+	"github.com/thanos-community/obslytics/pkg/exporter/memory"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+var testSchema = dataframe.Schema{{Name: "job", Type: dataframe.TypeString}, {Name: "_count", Type: dataframe.TypeUint}}
+
+func TestCountSamples(t *testing.T) {
+	df := memory.Frame{Columns: testSchema, Rows: []dataframe.Row{{"a", uint64(3)}, {"b", uint64(4)}}}
+	testutil.Equals(t, uint64(7), countSamples(df, "_count"))
+	testutil.Equals(t, uint64(0), countSamples(df, "_missing"))
+}
+
+func TestSummaryWriter(t *testing.T) {
+	b := &bytes.Buffer{}
+	w := newSummaryWriter(b)
+	testutil.Ok(t, w.write(exportSummary{Matchers: "up", Series: 2, Samples: 7}))
+	testutil.Ok(t, w.write(exportSummary{Matchers: "down", Error: "failed"}))
+
+	testutil.Equals(t, `{"matchers":"up","min_time":"0001-01-01T00:00:00Z","max_time":"0001-01-01T00:00:00Z","resolution":"","series":2,"samples":7,"bytes_written":0,"outputs":null,"duration":""}
+{"matchers":"down","min_time":"0001-01-01T00:00:00Z","max_time":"0001-01-01T00:00:00Z","resolution":"","series":0,"samples":0,"bytes_written":0,"outputs":null,"duration":"","error":"failed"}
+`, b.String())
+}
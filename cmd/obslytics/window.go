@@ -0,0 +1,80 @@
+package main
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/thanos-community/obslytics/pkg/dataframe"
+	"github.com/thanos-community/obslytics/pkg/series"
+)
+
+// validateReadWindow returns error if the options cannot be read in windows, as they depend on the samples before
+// the window (delta functions) or on all the samples of a series (scrape interval and empty windows).
+func (opts exportOptions) validateReadWindow() error {
+	switch {
+	case opts.readWindow == 0:
+		return nil
+	case opts.readWindow < 0:
+		return errors.New("read-window cannot be negative")
+	case opts.snapshot:
+		return errors.New("read-window cannot be used with snapshot")
+	case opts.deltas():
+		return errors.New("read-window cannot be used with delta (or override functions other than value)")
+	case opts.scrapeInterval:
+		return errors.New("read-window cannot be used with scrape-interval")
+	case opts.emptyWindows:
+		return errors.New("read-window cannot be used with empty-windows")
+	}
+	return nil
+}
+
+// readWindows splits the params into consecutive reads of at most the read window, with the boundaries aligned to the
+// resolution windows. Every read ends exclusively at the boundary the next read starts at, the same as the resolution
+// windows, so that every resolution window is aggregated from a single read. The dataframes of the reads are merged,
+// giving the same rows as a single read.
+func readWindows(params series.Params, opts exportOptions, read func(series.Params) (readResult, error)) (readResult, error) {
+	if opts.readWindow%opts.resolution != 0 {
+		return readResult{}, errors.Errorf("read-window %s has to be a multiple of the resolution %s", opts.readWindow, opts.resolution)
+	}
+	if params.MinTime.IsZero() || params.MaxTime.IsZero() {
+		return readResult{}, errors.New("read-window requires both min and max time")
+	}
+
+	var (
+		ret = readResult{seen: map[uint64]struct{}{}}
+		dfs []dataframe.Dataframe
+	)
+	for _, p := range splitParams(params, opts.readWindow) {
+		r, err := read(p)
+		if err != nil {
+			return readResult{}, errors.Wrapf(err, "read window %s - %s", p.MinTime.Format(time.RFC3339), p.MaxTime.Format(time.RFC3339))
+		}
+		dfs = append(dfs, r.df)
+		ret.countColumn = r.countColumn
+		for h := range r.seen {
+			ret.seen[h] = struct{}{}
+		}
+		ret.warnings = append(ret.warnings, r.warnings...)
+		if r.interrupted {
+			ret.interrupted = true
+			break
+		}
+	}
+	ret.series = len(ret.seen)
+	ret.df = dataframe.Merge(dfs...)
+	return ret, nil
+}
+
+// splitParams returns the params of the consecutive windows of the range, the boundaries being the multiples of the
+// window within the range.
+func splitParams(params series.Params, window time.Duration) []series.Params {
+	var ret []series.Params
+	p := params
+	for b := params.MinTime.Truncate(window).Add(window); b.Before(params.MaxTime); b = b.Add(window) {
+		p.MaxTime, p.MaxTimeExclusive = b, true
+		ret = append(ret, p)
+		p.MinTime, p.MinTimeExclusive = b, false
+	}
+	p.MaxTime, p.MaxTimeExclusive = params.MaxTime, params.MaxTimeExclusive
+	return append(ret, p)
+}
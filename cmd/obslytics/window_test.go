@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/tsdbutil"
+	"github.com/thanos-community/obslytics/pkg/series"
+	"github.com/thanos-io/thanos/pkg/model"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+// rangeReader returns the samples of the series within the params bounds.
+type rangeReader struct {
+	series []storage.Series
+	reads  []string
+}
+
+func (r *rangeReader) Read(_ context.Context, p series.Params) (series.Set, error) {
+	mint, maxt := p.Bounds()
+	r.reads = append(r.reads, fmt.Sprintf("%d-%d", mint, maxt))
+	ret := &listSet{i: -1}
+	for _, s := range r.series {
+		var samples []tsdbutil.Sample
+		for it := s.Iterator(); it.Next(); {
+			if t, v := it.At(); t >= mint && t <= maxt {
+				samples = append(samples, sample{t: t, v: v})
+			}
+		}
+		if len(samples) > 0 {
+			ret.series = append(ret.series, storage.NewListSeries(s.Labels(), samples))
+		}
+	}
+	return ret, nil
+}
+
+func TestReadWindows(t *testing.T) {
+	var a, b []tsdbutil.Sample
+	for ts := int64(0); ts <= 7200; ts += 60 {
+		a = append(a, sample{t: ts * 1000, v: float64(ts % 7)})
+		if ts%600 == 0 {
+			// Samples exactly at the resolution boundaries only.
+			b = append(b, sample{t: ts * 1000, v: float64(ts)})
+		}
+	}
+	in := &rangeReader{series: []storage.Series{
+		storage.NewListSeries(labels.FromStrings("__name__", "up", "instance", "a"), a),
+		storage.NewListSeries(labels.FromStrings("__name__", "up", "instance", "b"), b),
+	}}
+
+	mint, maxt := time.Unix(420, 0), time.Unix(7080, 0)
+	opts := exportOptions{
+		mint:       model.TimeOrDurationValue{Time: &mint},
+		maxt:       model.TimeOrDurationValue{Time: &maxt},
+		resolution: 10 * time.Minute,
+		first:      true,
+		last:       true,
+	}
+	read := func(p series.Params) (readResult, error) { return readDataframe(context.Background(), in, p, opts) }
+	rows := func(res readResult) []string {
+		var ret []string
+		for i := res.df.RowsIterator(); i.Next(); {
+			ret = append(ret, fmt.Sprint(i.At()))
+		}
+		sort.Strings(ret)
+		return ret
+	}
+
+	params := readParams(nil, opts)
+	exp, err := read(params)
+	testutil.Ok(t, err)
+	testutil.Equals(t, []string{"420000-7080000"}, in.reads)
+
+	// Every resolution window is aggregated from a single read, giving the same rows.
+	in.reads = nil
+	opts.readWindow = 30 * time.Minute
+	var reported []float64
+	opts.readProgress = series.NewProgress(mint, maxt, func(f float64) { reported = append(reported, f) })
+	res, err := readWindows(params, opts, read)
+	testutil.Ok(t, err)
+	testutil.Equals(t, []string{"420000-1799999", "1800000-3599999", "3600000-5399999", "5400000-7080000"}, in.reads)
+	testutil.Equals(t, rows(exp), rows(res))
+	testutil.Equals(t, 2, res.series)
+	// The progress is estimated across the windows.
+	testutil.Equals(t, 1.0, opts.readProgress.Fraction())
+	testutil.Assert(t, len(reported) > 4, "expected progress every window, got %v", reported)
+	opts.readProgress = nil
+
+	opts.readWindow = 25 * time.Minute
+	_, err = readWindows(params, opts, read)
+	testutil.NotOk(t, err)
+
+	opts.readWindow, opts.delta = time.Hour, true
+	testutil.NotOk(t, opts.validateReadWindow())
+}
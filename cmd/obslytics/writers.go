@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/thanos-community/obslytics/pkg/exporter"
+)
+
+// writeFiles writes the n files of an export by write, at most writers of them in parallel, every file by a single
+// call. On the first failure, the context of the calls is canceled and no more files are started. The outputs are
+// returned in the order of the files, including the outputs written by the failed calls, with the error of the file
+// that failed first. The writers are expected to be validated as positive.
+func writeFiles(n, writers int, write func(ctx context.Context, i int) ([]exporter.Output, error)) ([]exporter.Output, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		mtx      sync.Mutex
+		firstErr error
+		slots    = make(chan struct{}, writers)
+		outputs  = make([][]exporter.Output, n)
+	)
+	for i := 0; i < n; i++ {
+		slots <- struct{}{}
+		if ctx.Err() != nil {
+			<-slots
+			break
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+
+			var err error
+			outputs[i], err = write(ctx, i)
+			if err != nil {
+				mtx.Lock()
+				if firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				mtx.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	var ret []exporter.Output
+	for i := range outputs {
+		ret = append(ret, outputs[i]...)
+	}
+	return ret, firstErr
+}
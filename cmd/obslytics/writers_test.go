@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/thanos-community/obslytics/pkg/exporter"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+// barrier returns the function blocking until it is called n times.
+func barrier(n int) func() {
+	var wg sync.WaitGroup
+	wg.Add(n)
+	return func() {
+		wg.Done()
+		wg.Wait()
+	}
+}
+
+func TestWriteFiles(t *testing.T) {
+	var (
+		mtx           sync.Mutex
+		running, peak int
+		written       []int
+	)
+	// write records the calls writing the files by f, the files failed by f are written with the error, the
+	// canceled ones are not written.
+	write := func(f func(ctx context.Context, i int) error) func(ctx context.Context, i int) ([]exporter.Output, error) {
+		return func(ctx context.Context, i int) ([]exporter.Output, error) {
+			mtx.Lock()
+			running++
+			if running > peak {
+				peak = running
+			}
+			written = append(written, i)
+			mtx.Unlock()
+			defer func() {
+				mtx.Lock()
+				running--
+				mtx.Unlock()
+			}()
+
+			if err := f(ctx, i); err != nil {
+				if err == context.Canceled {
+					return nil, err
+				}
+				return []exporter.Output{{Path: fmt.Sprint(i)}}, err
+			}
+			return []exporter.Output{{Path: fmt.Sprint(i)}}, nil
+		}
+	}
+
+	// The first files wait for each other, so that the writers run all at once.
+	wait := barrier(3)
+	outputs, err := writeFiles(6, 3, write(func(_ context.Context, i int) error {
+		if i < 3 {
+			wait()
+		}
+		return nil
+	}))
+	testutil.Ok(t, err)
+	testutil.Equals(t, []exporter.Output{{Path: "0"}, {Path: "1"}, {Path: "2"}, {Path: "3"}, {Path: "4"}, {Path: "5"}}, outputs)
+	testutil.Equals(t, 3, peak)
+
+	// No more files are started after the failure, the outputs of the written ones are returned.
+	written = nil
+	outputs, err = writeFiles(10, 1, write(func(_ context.Context, i int) error {
+		if i == 2 {
+			return errors.Errorf("file %d failed", i)
+		}
+		return nil
+	}))
+	testutil.NotOk(t, err)
+	testutil.Equals(t, "file 2 failed", err.Error())
+	testutil.Equals(t, []int{0, 1, 2}, written)
+	testutil.Equals(t, []exporter.Output{{Path: "0"}, {Path: "1"}, {Path: "2"}}, outputs)
+
+	// The error of the file failed first is returned, the context of the files being written is canceled.
+	written = nil
+	wait = barrier(3)
+	outputs, err = writeFiles(10, 3, write(func(ctx context.Context, i int) error {
+		wait()
+		switch i {
+		case 2:
+			return errors.Errorf("file %d failed", i)
+		case 0:
+			// The file fails once canceled, after the failure of the other file.
+			<-ctx.Done()
+			return errors.Errorf("file %d failed", i)
+		}
+		<-ctx.Done()
+		return ctx.Err()
+	}))
+	testutil.NotOk(t, err)
+	testutil.Equals(t, "file 2 failed", err.Error())
+	sort.Ints(written)
+	testutil.Equals(t, []int{0, 1, 2}, written)
+	testutil.Equals(t, []exporter.Output{{Path: "0"}, {Path: "2"}}, outputs)
+}
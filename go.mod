@@ -3,17 +3,28 @@ module github.com/gmfrasca/obslytics
 go 1.16
 
 require (
+	github.com/Shopify/sarama v1.19.0
 	github.com/alicebob/gopher-json v0.0.0-20200520072559-a9ecdc9d1d3a // indirect
 	github.com/baiyubin/aliyun-sts-go-sdk v0.0.0-20180326062324-cfa1a18b161f // indirect
+	github.com/cespare/xxhash/v2 v2.1.1
 	github.com/cortexproject/cortex v1.8.1-0.20210422151339-cf1c444e0905
+	github.com/desertbit/timer v0.0.0-20180107155436-c41aec40b27f // indirect
 	github.com/go-kit/kit v0.10.0
 	github.com/gogo/googleapis v1.4.0 // indirect
+	github.com/golang/snappy v0.0.3
 	github.com/gomodule/redigo v1.8.4 // indirect
 	github.com/googleapis/gnostic v0.5.1 // indirect
+	github.com/grpc-ecosystem/go-grpc-prometheus v1.2.0
 	github.com/hashicorp/go-hclog v0.14.1 // indirect
+	github.com/improbable-eng/grpc-web v0.14.0
 	github.com/mattn/go-colorable v0.1.7 // indirect
+	github.com/minio/minio-go/v7 v7.0.10
 	github.com/oklog/run v1.1.0
+	github.com/oklog/ulid v1.3.1
+	github.com/opentracing/opentracing-go v1.2.0
 	github.com/pkg/errors v0.9.1
+	github.com/prometheus/client_golang v1.10.0
+	github.com/prometheus/client_model v0.2.0
 	github.com/prometheus/common v0.21.0
 	github.com/prometheus/prometheus v1.8.2-0.20210421143221-52df5ef7a3be
 	github.com/thanos-io/thanos v0.20.1
@@ -21,9 +32,13 @@ require (
 	github.com/xitongsys/parquet-go-source v0.0.0-20200817004010-026bad9b25d0
 	github.com/yuin/gopher-lua v0.0.0-20200816102855-ee81675732da // indirect
 	go.uber.org/automaxprocs v1.3.0
+	golang.org/x/crypto v0.0.0-20210220033148-5ea612d1eb83
+	golang.org/x/time v0.0.0-20210220033141-f8bda1e9f3ba
+	google.golang.org/api v0.42.0
 	google.golang.org/grpc v1.36.0
 	gopkg.in/alecthomas/kingpin.v2 v2.2.6
 	gopkg.in/yaml.v2 v2.4.0
+	nhooyr.io/websocket v1.8.6 // indirect
 )
 
 // Compatibility constraints
@@ -25,6 +25,16 @@ type Column struct {
 // Schema defines columns to be exposed by the dataframe.
 type Schema []Column
 
+// index returns the position of the column of the name and type, or -1 if there is none.
+func (s Schema) index(name string, t Type) int {
+	for i, c := range s {
+		if c.Name == name && c.Type == t {
+			return i
+		}
+	}
+	return -1
+}
+
 // RowsIterator exposes the rows of the dataframe.
 type RowsIterator interface {
 	Next() bool
@@ -0,0 +1,79 @@
+package dataframe
+
+import (
+	"encoding/binary"
+	"math"
+	"math/bits"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// hllPrecision is the number of bits used to select the HyperLogLog register. 2^12 registers take 4KB
+// and give standard error of 1.04/sqrt(2^12) ~= 1.6%.
+const hllPrecision = 12
+
+// distinctCounter counts distinct sample values.
+type distinctCounter interface {
+	add(v float64)
+	count() uint64
+}
+
+func newDistinctCounter(approximate bool) distinctCounter {
+	if approximate {
+		return newHyperLogLog(hllPrecision)
+	}
+	return exactDistinct{}
+}
+
+// exactDistinct keeps every distinct value in memory.
+type exactDistinct map[uint64]struct{}
+
+func (e exactDistinct) add(v float64) { e[math.Float64bits(v)] = struct{}{} }
+
+func (e exactDistinct) count() uint64 { return uint64(len(e)) }
+
+// hyperLogLog estimates the number of distinct values in constant memory.
+// See https://algo.inria.fr/flajolet/Publications/FlFuGaMe07.pdf.
+type hyperLogLog struct {
+	p         uint8
+	registers []uint8
+}
+
+func newHyperLogLog(p uint8) *hyperLogLog {
+	return &hyperLogLog{p: p, registers: make([]uint8, 1<<p)}
+}
+
+func (h *hyperLogLog) add(v float64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], math.Float64bits(v))
+	x := xxhash.Sum64(b[:])
+
+	// First p bits select the register, the rest is used to count the leading zeros. The sentinel bit
+	// bounds the rank in case the rest is all zeros.
+	idx := x >> (64 - h.p)
+	rank := uint8(bits.LeadingZeros64(x<<h.p|1<<(h.p-1))) + 1
+	if rank > h.registers[idx] {
+		h.registers[idx] = rank
+	}
+}
+
+func (h *hyperLogLog) count() uint64 {
+	m := float64(len(h.registers))
+	var (
+		sum   float64
+		zeros int
+	)
+	for _, r := range h.registers {
+		sum += 1 / float64(uint64(1)<<r)
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+	// Small range correction. Large range correction is not needed with 64-bit hashes.
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(estimate + 0.5)
+}
@@ -0,0 +1,52 @@
+package dataframe
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestDistinctCounter(t *testing.T) {
+	for _, n := range []int{0, 1, 10, 1000, 100000} {
+		exact, approx := newDistinctCounter(false), newDistinctCounter(true)
+		for i := 0; i < n; i++ {
+			// Every value added twice.
+			exact.add(float64(i))
+			exact.add(float64(i))
+			approx.add(float64(i))
+			approx.add(float64(i))
+		}
+
+		testutil.Equals(t, uint64(n), exact.count())
+		// Allow 3 standard errors.
+		testutil.Assert(t, math.Abs(float64(approx.count())-float64(n)) <= 0.05*float64(n)+1, "n=%d, estimate=%d", n, approx.count())
+	}
+}
+
+func TestFromSeries_CountDistinct(t *testing.T) {
+	for _, approximate := range []bool{false, true} {
+		df, err := FromSeries(newTestSeriesSet(
+			testSeries{
+				lset:    labels.FromStrings("__name__", "up"),
+				samples: []sample{{t: 0, v: 1}, {t: 10000, v: 1}, {t: 20000, v: 0}, {t: 70000, v: 1}, {t: 80000, v: 1}},
+			},
+		), time.Minute, func(o *AggrsOptions) {
+			o.Count.Enabled = true
+			o.CountDistinct.Enabled = true
+			o.CountDistinct.Approximate = approximate
+		})
+		testutil.Ok(t, err)
+
+		s := df.Schema()
+		testutil.Equals(t, Column{Name: "_count_distinct", Type: TypeUint}, s[len(s)-1])
+
+		var distinct []interface{}
+		for i := df.RowsIterator(); i.Next(); {
+			distinct = append(distinct, i.At()[len(s)-1])
+		}
+		testutil.Equals(t, []interface{}{uint64(2), uint64(1)}, distinct)
+	}
+}
@@ -0,0 +1,125 @@
+package dataframe
+
+import (
+	"sort"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/thanos-community/obslytics/pkg/series"
+)
+
+// exemplarColumnPrefix is prepended to the exemplar label columns clashing with series label columns.
+const exemplarColumnPrefix = "_exemplar_"
+
+// FromExemplars returns dataframe with a row per exemplar. The series labels are followed by the exemplar
+// timestamp and value columns and the exemplar labels (e.g. trace_id).
+func FromExemplars(ses []series.SeriesExemplars) Dataframe {
+	seriesNames := map[string]struct{}{}
+	exemplarNames := map[string]struct{}{}
+	for _, se := range ses {
+		for _, l := range se.SeriesLabels {
+			if l.Name != labels.MetricName {
+				seriesNames[l.Name] = struct{}{}
+			}
+		}
+		for _, e := range se.Exemplars {
+			for _, l := range e.Labels {
+				exemplarNames[l.Name] = struct{}{}
+			}
+		}
+	}
+
+	schema := Schema{}
+	for _, n := range sortedKeys(seriesNames) {
+		schema = append(schema, Column{Name: n, Type: TypeString})
+	}
+	schema = append(schema, Column{Name: "_timestamp", Type: TypeTime}, Column{Name: "_value", Type: TypeFloat})
+
+	exemplarColumns := map[string]string{}
+	for _, n := range sortedKeys(exemplarNames) {
+		c := n
+		if _, ok := seriesNames[n]; ok {
+			c = exemplarColumnPrefix + n
+		}
+		exemplarColumns[n] = c
+		schema = append(schema, Column{Name: c, Type: TypeString})
+	}
+
+	df := &rowsDataframe{schema: schema}
+	for _, se := range ses {
+		for _, e := range se.Exemplars {
+			vals := map[string]interface{}{
+				"_timestamp": timestamp.Time(e.Ts),
+				"_value":     e.Value,
+			}
+			for _, l := range se.SeriesLabels {
+				vals[l.Name] = l.Value
+			}
+			for _, l := range e.Labels {
+				vals[exemplarColumns[l.Name]] = l.Value
+			}
+
+			row := make(Row, 0, len(schema))
+			for _, c := range schema {
+				row = append(row, vals[c.Name])
+			}
+			df.rows = append(df.rows, row)
+		}
+	}
+	return df
+}
+
+// ExemplarTraces returns dataframe with a row per exemplar linked to a trace, i.e. with the trace label, e.g.
+// trace_id. The columns are the same for all series: the exemplar _timestamp and _value, the trace_id with the value
+// of the trace label and the _series_id fingerprint of the series labels, joinable to the _series_id of the series
+// dataframe. The exemplars without the trace label are skipped.
+func ExemplarTraces(ses []series.SeriesExemplars, traceLabel string) Dataframe {
+	df := &rowsDataframe{schema: Schema{
+		{Name: "_timestamp", Type: TypeTime},
+		{Name: "_value", Type: TypeFloat},
+		{Name: "trace_id", Type: TypeString},
+		{Name: "_series_id", Type: TypeUint},
+	}}
+	for _, se := range ses {
+		fp := Fingerprint(se.SeriesLabels)
+		for _, e := range se.Exemplars {
+			traceID := e.Labels.Get(traceLabel)
+			if traceID == "" {
+				continue
+			}
+			df.rows = append(df.rows, Row{timestamp.Time(e.Ts), e.Value, traceID, fp})
+		}
+	}
+	return df
+}
+
+func sortedKeys(m map[string]struct{}) []string {
+	ret := make([]string, 0, len(m))
+	for k := range m {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// rowsDataframe implements dataframe.Dataframe on top of in-memory rows.
+type rowsDataframe struct {
+	schema Schema
+	rows   []Row
+}
+
+func (df *rowsDataframe) Schema() Schema { return df.schema }
+
+func (df *rowsDataframe) RowsIterator() RowsIterator { return &rowsIterator{rows: df.rows, i: -1} }
+
+type rowsIterator struct {
+	rows []Row
+	i    int
+}
+
+func (it *rowsIterator) Next() bool {
+	it.i++
+	return it.i < len(it.rows)
+}
+
+func (it *rowsIterator) At() Row { return it.rows[it.i] }
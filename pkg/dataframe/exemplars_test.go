@@ -0,0 +1,73 @@
+package dataframe
+
+import (
+	"testing"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/thanos-community/obslytics/pkg/series"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestFromExemplars(t *testing.T) {
+	df := FromExemplars([]series.SeriesExemplars{
+		{
+			SeriesLabels: labels.FromStrings("__name__", "http_requests_total", "job", "api"),
+			Exemplars: []series.Exemplar{
+				{Labels: labels.FromStrings("trace_id", "abc"), Value: 1, Ts: 1000},
+				{Labels: labels.FromStrings("trace_id", "def", "job", "worker"), Value: 2, Ts: 2000},
+			},
+		},
+		{
+			SeriesLabels: labels.FromStrings("__name__", "http_requests_total", "job", "web"),
+			Exemplars:    []series.Exemplar{{Labels: labels.FromStrings("trace_id", "ghi"), Value: 3, Ts: 3000}},
+		},
+	})
+
+	testutil.Equals(t, Schema{
+		{Name: "job", Type: TypeString},
+		{Name: "_timestamp", Type: TypeTime},
+		{Name: "_value", Type: TypeFloat},
+		// Exemplar label clashing with the series label.
+		{Name: "_exemplar_job", Type: TypeString},
+		{Name: "trace_id", Type: TypeString},
+	}, df.Schema())
+
+	var rows []Row
+	for i := df.RowsIterator(); i.Next(); {
+		rows = append(rows, i.At())
+	}
+	testutil.Equals(t, []Row{
+		{"api", timestamp.Time(1000), 1.0, nil, "abc"},
+		{"api", timestamp.Time(2000), 2.0, "worker", "def"},
+		{"web", timestamp.Time(3000), 3.0, nil, "ghi"},
+	}, rows)
+}
+
+func TestExemplarTraces(t *testing.T) {
+	api := labels.FromStrings("__name__", "http_requests_total", "job", "api")
+	df := ExemplarTraces([]series.SeriesExemplars{
+		{
+			SeriesLabels: api,
+			Exemplars: []series.Exemplar{
+				{Labels: labels.FromStrings("traceID", "abc"), Value: 1, Ts: 1000},
+				// Not linked to a trace.
+				{Labels: labels.FromStrings("span_id", "def"), Value: 2, Ts: 2000},
+			},
+		},
+		{SeriesLabels: labels.FromStrings("__name__", "http_requests_total", "job", "web")},
+	}, "traceID")
+
+	testutil.Equals(t, Schema{
+		{Name: "_timestamp", Type: TypeTime},
+		{Name: "_value", Type: TypeFloat},
+		{Name: "trace_id", Type: TypeString},
+		{Name: "_series_id", Type: TypeUint},
+	}, df.Schema())
+
+	var rows []Row
+	for i := df.RowsIterator(); i.Next(); {
+		rows = append(rows, i.At())
+	}
+	testutil.Equals(t, []Row{{timestamp.Time(1000), 1.0, "abc", Fingerprint(api)}}, rows)
+}
@@ -0,0 +1,40 @@
+package dataframe
+
+import (
+	"github.com/cespare/xxhash/v2"
+	"github.com/prometheus/prometheus/pkg/labels"
+)
+
+// fingerprintSep separates label names and values in the fingerprint input.
+var fingerprintSep = []byte{'\xff'}
+
+// Fingerprint returns a stable identifier of the series labels.
+//
+// The fingerprint is the 64-bit xxhash (seed 0) of all labels (including __name__) sorted by
+// name, each encoded as <name> 0xff <value> 0xff. The algorithm is part of the output format
+// and must not change, so that identifiers can be joined across runs and versions.
+func Fingerprint(ls labels.Labels) uint64 {
+	h := xxhash.New()
+	for _, l := range normalizeLabels(ls) {
+		_, _ = h.WriteString(l.Name)
+		_, _ = h.Write(fingerprintSep)
+		_, _ = h.WriteString(l.Value)
+		_, _ = h.Write(fingerprintSep)
+	}
+	return h.Sum64()
+}
+
+// RowLabels returns the labels of the row, given by its non-empty string columns other than the unit column, e.g. to
+// fingerprint the rows of the dataframes SplitSeries cannot split. The metric name is the __name__ column, the labels
+// match the series labels only if the dataframe has its metric name in that column.
+func RowLabels(s Schema, r Row) labels.Labels {
+	unit := defaultSeriesAggrsOptions().Units.Column
+	var ls labels.Labels
+	for i, c := range s {
+		if c.Type != TypeString || c.Name == unit || r[i] == nil || r[i].(string) == "" {
+			continue
+		}
+		ls = append(ls, labels.Label{Name: c.Name, Value: r[i].(string)})
+	}
+	return ls
+}
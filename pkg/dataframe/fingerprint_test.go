@@ -0,0 +1,21 @@
+package dataframe
+
+import (
+	"testing"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestFingerprint(t *testing.T) {
+	ls := labels.Labels{
+		{Name: "__name__", Value: "up"},
+		{Name: "instance", Value: "localhost:9090"},
+		{Name: "job", Value: "prometheus"},
+	}
+
+	// The value is part of the output format and must stay the same across versions.
+	testutil.Equals(t, uint64(17477497072380077407), Fingerprint(ls))
+	testutil.Equals(t, Fingerprint(ls), Fingerprint(labels.Labels{ls[2], ls[0], ls[1]}))
+	testutil.Assert(t, Fingerprint(ls) != Fingerprint(ls[1:]), "different label sets should not share a fingerprint")
+}
@@ -0,0 +1,46 @@
+package dataframe
+
+import (
+	"math"
+	"sort"
+)
+
+// maxIntervalDeltas bounds the deltas kept per series to estimate its interval. The median of the first deltas is
+// representative of regularly scraped series, while the memory stays constant for long ranges.
+const maxIntervalDeltas = 1024
+
+// IntervalOption defines options of the columns describing the time resolution of the series.
+type IntervalOption struct {
+	AggrOption
+
+	// StepColumn is the column with the aggregation step (resolution) in seconds, next to the native interval.
+	StepColumn string
+}
+
+// intervalEstimator estimates the native sample interval (e.g. the scrape interval) of a series as the median
+// delta between its consecutive samples. Samples with equal timestamps are ignored.
+type intervalEstimator struct {
+	prev    int64
+	hasPrev bool
+	deltas  []int64
+}
+
+func (e *intervalEstimator) add(t int64) {
+	if e.hasPrev && t > e.prev && len(e.deltas) < maxIntervalDeltas {
+		e.deltas = append(e.deltas, t-e.prev)
+	}
+	e.prev, e.hasPrev = t, true
+}
+
+// seconds returns the median interval in seconds, NaN for series with a single sample timestamp.
+func (e *intervalEstimator) seconds() float64 {
+	if len(e.deltas) == 0 {
+		return math.NaN()
+	}
+	sort.Slice(e.deltas, func(i, j int) bool { return e.deltas[i] < e.deltas[j] })
+	n := len(e.deltas)
+	if n%2 == 1 {
+		return float64(e.deltas[n/2]) / 1000
+	}
+	return float64(e.deltas[n/2-1]+e.deltas[n/2]) / 2000
+}
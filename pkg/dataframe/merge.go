@@ -0,0 +1,99 @@
+package dataframe
+
+import "sort"
+
+// mergedDataframe concatenates the rows of multiple dataframes.
+type mergedDataframe struct {
+	schema Schema
+	dfs    []Dataframe
+	// columns maps the columns of every dataframe to the merged schema.
+	columns [][]int
+}
+
+// Merge concatenates the rows of the dataframes, e.g. of dataframes aggregated from disjoint sets of series.
+// The dataframes are expected to have the same non-label columns. Label (string) columns are merged, so that
+// the merged schema contains the sorted union of all label columns, empty in rows of dataframes without them.
+func Merge(dfs ...Dataframe) Dataframe {
+	var (
+		labelNames []string
+		seen       = map[string]struct{}{}
+		others     Schema
+	)
+	for _, df := range dfs {
+		for _, c := range df.Schema() {
+			if c.Type != TypeString {
+				continue
+			}
+			if _, ok := seen[c.Name]; !ok {
+				seen[c.Name] = struct{}{}
+				labelNames = append(labelNames, c.Name)
+			}
+		}
+	}
+	sort.Strings(labelNames)
+	if len(dfs) > 0 {
+		for _, c := range dfs[0].Schema() {
+			if c.Type != TypeString {
+				others = append(others, c)
+			}
+		}
+	}
+
+	m := &mergedDataframe{dfs: dfs}
+	for _, n := range labelNames {
+		m.schema = append(m.schema, Column{Name: n, Type: TypeString})
+	}
+	m.schema = append(m.schema, others...)
+
+	index := make(map[string]int, len(m.schema))
+	for i, c := range m.schema {
+		index[c.Name] = i
+	}
+	for _, df := range dfs {
+		cols := make([]int, 0, len(df.Schema()))
+		for _, c := range df.Schema() {
+			i, ok := index[c.Name]
+			if !ok {
+				i = -1
+			}
+			cols = append(cols, i)
+		}
+		m.columns = append(m.columns, cols)
+	}
+	return m
+}
+
+func (m *mergedDataframe) Schema() Schema { return m.schema }
+
+func (m *mergedDataframe) RowsIterator() RowsIterator {
+	return &mergedRowsIterator{df: m, i: -1}
+}
+
+type mergedRowsIterator struct {
+	df  *mergedDataframe
+	i   int
+	cur RowsIterator
+	row Row
+}
+
+func (it *mergedRowsIterator) Next() bool {
+	for {
+		if it.cur != nil && it.cur.Next() {
+			r := it.cur.At()
+			it.row = make(Row, len(it.df.schema))
+			for j, i := range it.df.columns[it.i] {
+				if i >= 0 {
+					it.row[i] = r[j]
+				}
+			}
+			return true
+		}
+		it.i++
+		if it.i >= len(it.df.dfs) {
+			return false
+		}
+		it.cur = it.df.dfs[it.i].RowsIterator()
+	}
+}
+
+func (it *mergedRowsIterator) At() Row { return it.row }
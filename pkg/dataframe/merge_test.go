@@ -0,0 +1,45 @@
+package dataframe
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestMerge(t *testing.T) {
+	aggr := func(o *AggrsOptions) { o.Count.Enabled = true }
+	a, err := FromSeries(newTestSeriesSet(
+		testSeries{lset: labels.FromStrings("__name__", "up", "instance", "a", "job", "x"), samples: []sample{{t: 0, v: 1}}},
+	), time.Minute, aggr)
+	testutil.Ok(t, err)
+	b, err := FromSeries(newTestSeriesSet(
+		testSeries{lset: labels.FromStrings("__name__", "up", "instance", "b", "zone", "z"), samples: []sample{{t: 0, v: 1}, {t: 10000, v: 1}}},
+	), time.Minute, aggr)
+	testutil.Ok(t, err)
+
+	df := Merge(a, b)
+	testutil.Equals(t, Schema{
+		{Name: "instance", Type: TypeString},
+		{Name: "job", Type: TypeString},
+		{Name: "zone", Type: TypeString},
+		{Name: "_sample_start", Type: TypeTime},
+		{Name: "_sample_end", Type: TypeTime},
+		{Name: "_min_time", Type: TypeTime},
+		{Name: "_max_time", Type: TypeTime},
+		{Name: "_count", Type: TypeUint},
+	}, df.Schema())
+
+	var rows [][]interface{}
+	for i := df.RowsIterator(); i.Next(); {
+		r := i.At()
+		rows = append(rows, []interface{}{r[0], r[1], r[2], r[7]})
+	}
+	testutil.Equals(t, [][]interface{}{
+		{"a", "x", nil, uint64(1)},
+		{"b", nil, "z", uint64(2)},
+	}, rows)
+
+	testutil.Equals(t, 0, countRows(Merge()))
+}
@@ -0,0 +1,155 @@
+package dataframe
+
+import (
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Order defines the time order of the rows of the dataframe.
+type Order string
+
+const (
+	// OrderAscending emits the rows of every series in ascending time order, the earliest window first, with the
+	// series in the order they were read. This is the default.
+	OrderAscending Order = "asc"
+	// OrderDescending emits the rows of every series in descending time order, the latest window first, with the
+	// series in the order they were read.
+	OrderDescending Order = "desc"
+	// OrderDescendingSeries emits the rows as OrderDescending, with the series ordered by their latest window, latest
+	// first. Series with the same latest window keep the order they were read in.
+	OrderDescendingSeries Order = "desc-series"
+)
+
+// Validate returns error if the order is not known.
+func (o Order) Validate() error {
+	switch o {
+	case "", OrderAscending, OrderDescending, OrderDescendingSeries:
+		return nil
+	}
+	return errors.Errorf("unsupported order %q, expected asc, desc or desc-series", o)
+}
+
+// InOrder returns the dataframe with the rows in the order. The samples of a series are read (e.g. StoreAPI chunks
+// come) in ascending time order, so the descending orders cannot be streamed: the rows are reordered once the
+// dataframe is complete. The aggregated rows are held in memory anyway, so the dataframes created by FromSeries are
+// reordered without copying the rows, keeping them splittable by SplitSeries. Other dataframes, e.g. merged or with
+// grouped summaries, are read into memory, their rows grouped into series by the values of the string (label)
+// columns.
+func InOrder(df Dataframe, o Order) Dataframe {
+	if o == "" || o == OrderAscending {
+		return df
+	}
+	if sdf, ok := df.(*seriesDataframe); ok {
+		return sdf.descending(o == OrderDescendingSeries)
+	}
+	return descendingRows(df, o == OrderDescendingSeries)
+}
+
+// descending returns the dataframe with the records of every series reversed, and the series ordered by their latest
+// window if bySeries is set.
+func (df *seriesDataframe) descending(bySeries bool) *seriesDataframe {
+	ret := &seriesDataframe{
+		schema:           df.schema,
+		seriesRecordSets: make(map[uint64]*seriesRecordSet, len(df.seriesRecordSets)),
+		seriesOrder:      append([]uint64(nil), df.seriesOrder...),
+	}
+	for h, rs := range df.seriesRecordSets {
+		records := make([]Record, len(rs.Records))
+		for i, r := range rs.Records {
+			records[len(records)-1-i] = r
+		}
+		ret.seriesRecordSets[h] = &seriesRecordSet{Labels: rs.Labels, Fingerprint: rs.Fingerprint, Records: records}
+	}
+	if bySeries {
+		latest := func(h uint64) time.Time {
+			rs := ret.seriesRecordSets[h]
+			if len(rs.Records) == 0 {
+				return time.Time{}
+			}
+			t, _ := rs.Records[0].Values["_sample_start"].(time.Time)
+			return t
+		}
+		sort.SliceStable(ret.seriesOrder, func(i, j int) bool {
+			return latest(ret.seriesOrder[i]).After(latest(ret.seriesOrder[j]))
+		})
+	}
+	return ret
+}
+
+// descendingRows reads the rows of the dataframe, grouped into series in the order of their first rows, sorting the
+// rows of every series by descending _sample_start, and the series by their latest window if bySeries is set.
+// Dataframes without the _sample_start column are returned unchanged.
+func descendingRows(df Dataframe, bySeries bool) Dataframe {
+	schema := df.Schema()
+	start := schema.index("_sample_start", TypeTime)
+	if start < 0 {
+		return df
+	}
+
+	groups := groupRows(df)
+	latest := make(map[*rowGroup]time.Time, len(groups))
+	for _, g := range groups {
+		sort.SliceStable(g.rows, func(i, j int) bool {
+			ti, _ := g.rows[i][start].(time.Time)
+			tj, _ := g.rows[j][start].(time.Time)
+			return ti.After(tj)
+		})
+		latest[g], _ = g.rows[0][start].(time.Time)
+	}
+	if bySeries {
+		sort.SliceStable(groups, func(i, j int) bool { return latest[groups[i]].After(latest[groups[j]]) })
+	}
+	return groupsDataframe(schema, groups)
+}
+
+// rowGroup are the rows of a series of a dataframe, read into memory.
+type rowGroup struct {
+	rows []Row
+}
+
+// groupRows reads the rows of the dataframe, grouped into series by the values of the string (label) columns, in the
+// order of the first rows of the series.
+func groupRows(df Dataframe) []*rowGroup {
+	var keys []int
+	for i, c := range df.Schema() {
+		if c.Type == TypeString {
+			keys = append(keys, i)
+		}
+	}
+
+	var (
+		groups []*rowGroup
+		index  = map[string]*rowGroup{}
+		key    []byte
+	)
+	for it := df.RowsIterator(); it.Next(); {
+		r := it.At()
+		key = key[:0]
+		for _, i := range keys {
+			// Absent values (e.g. labels missing in merged dataframes) differ from empty ones.
+			if s, ok := r[i].(string); ok {
+				key = append(append(key, 1), s...)
+			}
+			key = append(key, 0)
+		}
+		g, ok := index[string(key)]
+		if !ok {
+			g = &rowGroup{}
+			index[string(key)] = g
+			groups = append(groups, g)
+		}
+		g.rows = append(g.rows, r)
+	}
+	return groups
+}
+
+// groupsDataframe returns the dataframe of the rows of the groups, in order.
+func groupsDataframe(schema Schema, groups []*rowGroup) Dataframe {
+	ret := &rowsDataframe{schema: schema}
+	for _, g := range groups {
+		ret.rows = append(ret.rows, g.rows...)
+	}
+	return ret
+}
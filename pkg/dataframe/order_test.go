@@ -0,0 +1,71 @@
+package dataframe
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestInOrder(t *testing.T) {
+	set := func() *testSeriesSet {
+		return newTestSeriesSet(
+			testSeries{lset: labels.FromStrings("__name__", "up", "instance", "a"), samples: []sample{{t: 0, v: 1}, {t: 60000, v: 2}}},
+			testSeries{lset: labels.FromStrings("__name__", "up", "instance", "b"), samples: []sample{{t: 0, v: 3}, {t: 60000, v: 4}, {t: 120000, v: 5}}},
+		)
+	}
+	aggr := func(o *AggrsOptions) { o.Last.Enabled = true }
+	rows := func(df Dataframe) [][]interface{} {
+		var ret [][]interface{}
+		for i := df.RowsIterator(); i.Next(); {
+			r := i.At()
+			// Instance, sample start minutes and last value.
+			ret = append(ret, []interface{}{r[0], r[1].(time.Time).Unix() / 60, r[5]})
+		}
+		return ret
+	}
+
+	df, err := FromSeries(set(), time.Minute, aggr)
+	testutil.Ok(t, err)
+	testutil.Equals(t, df, InOrder(df, OrderAscending))
+	testutil.Equals(t, [][]interface{}{
+		{"a", int64(1), 2.0}, {"a", int64(0), 1.0},
+		{"b", int64(2), 5.0}, {"b", int64(1), 4.0}, {"b", int64(0), 3.0},
+	}, rows(InOrder(df, OrderDescending)))
+
+	desc := InOrder(df, OrderDescendingSeries)
+	testutil.Equals(t, [][]interface{}{
+		{"b", int64(2), 5.0}, {"b", int64(1), 4.0}, {"b", int64(0), 3.0},
+		{"a", int64(1), 2.0}, {"a", int64(0), 1.0},
+	}, rows(desc))
+	// The input dataframe is unchanged, and the reordered one can be split.
+	testutil.Equals(t, [][]interface{}{
+		{"a", int64(0), 1.0}, {"a", int64(1), 2.0},
+		{"b", int64(0), 3.0}, {"b", int64(1), 4.0}, {"b", int64(2), 5.0},
+	}, rows(df))
+	split, ok := SplitSeries(desc)
+	testutil.Assert(t, ok, "expected splittable dataframe")
+	testutil.Equals(t, "b", split[0].Labels.Get("instance"))
+
+	// Merged dataframes, e.g. of read windows, have the rows of a series in multiple dataframes.
+	first, err := FromSeries(newTestSeriesSet(
+		testSeries{lset: labels.FromStrings("__name__", "up", "instance", "a"), samples: []sample{{t: 0, v: 1}}},
+		testSeries{lset: labels.FromStrings("__name__", "up", "instance", "b"), samples: []sample{{t: 0, v: 3}}},
+	), time.Minute, aggr)
+	testutil.Ok(t, err)
+	second, err := FromSeries(newTestSeriesSet(
+		testSeries{lset: labels.FromStrings("__name__", "up", "instance", "a"), samples: []sample{{t: 60000, v: 2}}},
+		testSeries{lset: labels.FromStrings("__name__", "up", "instance", "b"), samples: []sample{{t: 60000, v: 4}, {t: 120000, v: 5}}},
+	), time.Minute, aggr)
+	testutil.Ok(t, err)
+	merged := Merge(first, second)
+	testutil.Equals(t, rows(InOrder(df, OrderDescending)), rows(InOrder(merged, OrderDescending)))
+	testutil.Equals(t, rows(desc), rows(InOrder(merged, OrderDescendingSeries)))
+}
+
+func TestOrder_Validate(t *testing.T) {
+	testutil.Ok(t, Order("").Validate())
+	testutil.Ok(t, OrderDescendingSeries.Validate())
+	testutil.NotOk(t, Order("descending").Validate())
+}
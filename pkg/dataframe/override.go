@@ -0,0 +1,114 @@
+package dataframe
+
+import (
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/thanos-community/obslytics/pkg/series"
+)
+
+// SampleFunction is the function of the consecutive samples of a series aggregated instead of the sample values.
+type SampleFunction string
+
+const (
+	// SampleValue aggregates the sample values, e.g. of gauges, giving the average as sum divided by count.
+	SampleValue SampleFunction = "value"
+	// SampleDelta aggregates the differences between consecutive samples.
+	SampleDelta SampleFunction = "delta"
+	// SampleDerivative aggregates the differences per second.
+	SampleDerivative SampleFunction = "derivative"
+	// SampleIncrease aggregates the increases of counters, handling the decreases as counter resets.
+	SampleIncrease SampleFunction = "increase"
+	// SampleRate aggregates the increases of counters per second.
+	SampleRate SampleFunction = "rate"
+)
+
+// Validate returns error if the function is not known.
+func (f SampleFunction) Validate() error {
+	switch f {
+	case SampleValue, SampleDelta, SampleDerivative, SampleIncrease, SampleRate:
+		return nil
+	}
+	return errors.Errorf("unsupported function %q, expected value, delta, derivative, increase or rate", f)
+}
+
+// delta returns the delta option of the function, with the first sample and the rate unit of the default delta.
+func (f SampleFunction) delta(def DeltaOption) DeltaOption {
+	return DeltaOption{
+		Enabled: f != SampleValue,
+		DeltaOptions: series.DeltaOptions{
+			PerSecond: f.perSecond(),
+			First:     def.First,
+			Counter:   f == SampleIncrease || f == SampleRate,
+			Unit:      def.Unit,
+		},
+	}
+}
+
+func (f SampleFunction) perSecond() bool { return f == SampleDerivative || f == SampleRate }
+
+// AggrOverride selects the sample function of the series matching all the matchers, e.g. rate of the metrics with
+// the _total suffix.
+type AggrOverride struct {
+	Matchers []*labels.Matcher
+	Function SampleFunction
+}
+
+func (o AggrOverride) matches(lset labels.Labels) bool {
+	for _, m := range o.Matchers {
+		if !m.Matches(lset.Get(m.Name)) {
+			return false
+		}
+	}
+	return true
+}
+
+// overrideSet applies the delta of the first matching override, or the default delta, to every series.
+type overrideSet struct {
+	series.Set
+
+	def       DeltaOption
+	overrides []AggrOverride
+}
+
+func (s *overrideSet) At() storage.Series {
+	ser := s.Set.At()
+	delta := s.def
+	for _, o := range s.overrides {
+		if o.matches(ser.Labels()) {
+			delta = o.Function.delta(s.def)
+			break
+		}
+	}
+	if !delta.Enabled {
+		return ser
+	}
+	return series.NewDeltaSeries(ser, delta.DeltaOptions)
+}
+
+// perSecond returns whether the samples of the series are aggregated as the per-second deltas, by the first matching
+// override or by the default delta.
+func (o AggrsOptions) perSecond(lset labels.Labels) bool {
+	for _, ov := range o.Overrides {
+		if ov.matches(lset) {
+			return ov.Function.perSecond()
+		}
+	}
+	return o.Delta.Enabled && o.Delta.PerSecond
+}
+
+// anyPerSecond returns whether the samples of some series can be aggregated as the per-second deltas.
+func (o AggrsOptions) anyPerSecond() bool {
+	for _, ov := range o.Overrides {
+		if ov.Function.perSecond() {
+			return true
+		}
+	}
+	return o.Delta.Enabled && o.Delta.PerSecond
+}
+
+// unitColumn returns whether the rows have the unit column: with the unit conversions, or with the per-second deltas
+// normalized to the requested rate unit.
+func (o AggrsOptions) unitColumn() bool {
+	return len(o.Units.Conversions) > 0 || (o.Delta.Unit != "" && o.anyPerSecond())
+}
@@ -0,0 +1,38 @@
+package dataframe
+
+// Partition is the dataframe of the rows having the same value of a string (label) column.
+type Partition struct {
+	Dataframe
+
+	// Value is the value of the column, empty for the rows without it.
+	Value string
+}
+
+// PartitionBy groups the rows of the dataframe by the value of the string column, e.g. namespace, keeping the order
+// of the rows within every partition. The partitions are ordered by their first row and have the schema of the whole
+// dataframe, so that their outputs have the same columns. The rows are held in memory. All the rows are in a single
+// partition with empty value if the dataframe has no such column.
+func PartitionBy(df Dataframe, column string) []Partition {
+	schema := df.Schema()
+	col := schema.index(column, TypeString)
+
+	var (
+		parts []Partition
+		index = map[string]*rowsDataframe{}
+	)
+	for i := df.RowsIterator(); i.Next(); {
+		r := i.At()
+		var v string
+		if col >= 0 && r[col] != nil {
+			v = r[col].(string)
+		}
+		p, ok := index[v]
+		if !ok {
+			p = &rowsDataframe{schema: schema}
+			index[v] = p
+			parts = append(parts, Partition{Dataframe: p, Value: v})
+		}
+		p.rows = append(p.rows, r)
+	}
+	return parts
+}
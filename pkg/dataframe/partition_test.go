@@ -0,0 +1,35 @@
+package dataframe
+
+import (
+	"testing"
+
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestPartitionBy(t *testing.T) {
+	df := &rowsDataframe{
+		schema: Schema{{Name: "namespace", Type: TypeString}, {Name: "_sum", Type: TypeFloat}},
+		rows:   []Row{{"b", 1.0}, {"a", 2.0}, {nil, 3.0}, {"b", 4.0}},
+	}
+
+	parts := PartitionBy(df, "namespace")
+	testutil.Equals(t, 3, len(parts))
+	for i, exp := range []struct {
+		value string
+		rows  []Row
+	}{
+		{value: "b", rows: []Row{{"b", 1.0}, {"b", 4.0}}},
+		{value: "a", rows: []Row{{"a", 2.0}}},
+		// Rows without the label.
+		{value: "", rows: []Row{{nil, 3.0}}},
+	} {
+		testutil.Equals(t, exp.value, parts[i].Value)
+		testutil.Equals(t, df.Schema(), parts[i].Schema())
+		testutil.Equals(t, exp.rows, parts[i].Dataframe.(*rowsDataframe).rows)
+	}
+
+	parts = PartitionBy(df, "missing")
+	testutil.Equals(t, 1, len(parts))
+	testutil.Equals(t, "", parts[0].Value)
+	testutil.Equals(t, 4, countRows(parts[0]))
+}
@@ -0,0 +1,58 @@
+package dataframe
+
+import (
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// RoundingMode is the direction the aggregated values are rounded in.
+type RoundingMode string
+
+const (
+	// RoundNearest rounds to the nearest multiple, halves away from zero. This is the default.
+	RoundNearest RoundingMode = "nearest"
+	// RoundFloor rounds down to the multiple.
+	RoundFloor RoundingMode = "floor"
+	// RoundCeil rounds up to the multiple.
+	RoundCeil RoundingMode = "ceil"
+)
+
+// Validate returns error if the mode is not known.
+func (m RoundingMode) Validate() error {
+	switch m {
+	case "", RoundNearest, RoundFloor, RoundCeil:
+		return nil
+	}
+	return errors.Errorf("unsupported rounding mode %q, expected nearest, floor or ceil", m)
+}
+
+// RoundingOption quantizes the aggregated values (sum, min, max, avg, first and last) to multiples of the granularity,
+// e.g. for k-anonymity style exports or to reduce the entropy, improving the compression of the columns. It's
+// applied after aggregation and unit conversion, counts are kept exact.
+type RoundingOption struct {
+	// Granularity the values are rounded to multiples of, e.g. 10 or 0.01. Disabled if 0.
+	Granularity float64
+	Mode        RoundingMode
+}
+
+func (o RoundingOption) round(v float64) float64 {
+	if o.Granularity <= 0 || math.IsNaN(v) || math.IsInf(v, 0) {
+		return v
+	}
+	q := v / o.Granularity
+	switch o.Mode {
+	case RoundFloor:
+		q = math.Floor(q)
+	case RoundCeil:
+		q = math.Ceil(q)
+	default:
+		q = math.Round(q)
+	}
+	// Dividing by the inverse of fractional granularities, e.g. 0.1, gives the exact decimal, 0.3 instead of
+	// 0.30000000000000004.
+	if inv := 1 / o.Granularity; o.Granularity < 1 && inv == math.Trunc(inv) {
+		return q / inv
+	}
+	return q * o.Granularity
+}
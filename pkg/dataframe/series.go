@@ -1,13 +1,16 @@
 package dataframe
 
 import (
+	"math"
 	"sort"
 	"time"
 
 	"github.com/pkg/errors"
 	"github.com/prometheus/prometheus/pkg/labels"
 	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/prometheus/storage"
 	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/prometheus/tsdb/tsdbutil"
 	"github.com/thanos-community/obslytics/pkg/series"
 )
 
@@ -31,6 +34,80 @@ type AggrsOptions struct {
 	Count AggrOption
 	Min   AggrOption
 	Max   AggrOption
+	// Avg is the mean of the sample values within each resolution window, the sum divided by the count.
+	Avg AggrOption
+	// SampleCount is the number of raw samples each resolution window is aggregated from, equal to Count for raw data
+	// and summed from the counts of the downsampled aggregates otherwise, see series.SampleCounter, e.g. to judge the
+	// reliability of the aggregated values. Not supported with Summaries.
+	SampleCount AggrOption
+
+	// First and Last pick the value of the earliest and the latest sample within each resolution window.
+	// Samples with equal timestamps are resolved by value (First picks the lower, Last the higher one),
+	// so the result does not depend on the order the duplicates are received in.
+	First AggrOption
+	Last  AggrOption
+
+	// CountDistinct counts the distinct sample values within each resolution window.
+	CountDistinct CountDistinctOption
+
+	// SeriesID adds a column with the stable Fingerprint of the series labels.
+	SeriesID AggrOption
+	// MetricName adds a string column with the metric name (the __name__ label) of the series, placed before the label
+	// columns, which never include the metric name. The column is named __name__ by default, so that the outputs
+	// turning the string columns back into labels keep the metric name. Not applied with Summaries, which have the
+	// summary name column.
+	MetricName AggrOption
+
+	// Units converts the aggregated values (sum, min, max, avg, first and last) of the metrics, e.g. from bytes to GiB,
+	// adding a column with the converted unit. The conversion is applied once per window, after aggregation. The
+	// column is added for the per-second deltas too, with their unit.
+	Units UnitsOption
+	// Rounding quantizes the aggregated values, after the unit conversion. Not applied to Summaries.
+	Rounding RoundingOption
+
+	// EmptyWindows emits explicit rows for the windows without samples between the first and the last sample
+	// of each series, instead of omitting them. The rows have zero count and sum, NaN min, max, avg, first and last,
+	// and min and max time set to the window start.
+	EmptyWindows bool
+
+	// Transform, if set, is applied to every sample before aggregation, e.g. to redact or calibrate the values.
+	// Samples it returns false for are dropped.
+	Transform series.SampleTransform
+
+	// Summaries groups the series of classic summary metrics into a row per summary and window, replacing the
+	// aggregations above with the latest sum, count and quantile values of the window.
+	Summaries SummariesOption
+
+	// Delta replaces the sample values by their difference from the previous sample of the series, applied after
+	// the transform and before aggregation. The average of the per-second deltas is weighted by the time every delta
+	// spans, i.e. it's the increase of the window divided by its elapsed time, also for irregularly spaced samples.
+	Delta DeltaOption
+	// Overrides select the sample function per series, the first matching override replacing Delta, e.g. to
+	// aggregate the rate of the counters and the values of the gauges in a single run. The delta of the first
+	// sample is handled by Delta.First.
+	Overrides []AggrOverride
+
+	// Interval adds columns with the native sample interval of the series in seconds, i.e. the median delta between
+	// its consecutive samples read (e.g. the scrape interval), and with the aggregation step, so that the aggregated
+	// values (e.g. counts) can be interpreted. The interval is the same for all the rows of the series. Not supported
+	// with Summaries.
+	Interval IntervalOption
+}
+
+// DeltaOption defines options of the delta of consecutive samples.
+type DeltaOption struct {
+	Enabled bool
+	series.DeltaOptions
+}
+
+// CountDistinctOption defines options of the count distinct aggregation.
+type CountDistinctOption struct {
+	AggrOption
+
+	// Approximate estimates the count using HyperLogLog, taking 4KB per series regardless of the number of
+	// distinct values, with standard error of about 1.6%. The exact count keeps every distinct value of the
+	// window in memory, which can be expensive for high cardinality values and large windows.
+	Approximate bool
 }
 
 // By default, all aggregations are disabled and target columns set with `_` prefix.
@@ -44,6 +121,22 @@ func defaultSeriesAggrsOptions() AggrsOptions {
 		Count: AggrOption{Column: "_count"},
 		Min:   AggrOption{Column: "_min"},
 		Max:   AggrOption{Column: "_max"},
+		Avg:   AggrOption{Column: "_avg"},
+		First: AggrOption{Column: "_first"},
+		Last:  AggrOption{Column: "_last"},
+
+		SampleCount: AggrOption{Column: "_sample_count"},
+
+		CountDistinct: CountDistinctOption{AggrOption: AggrOption{Column: "_count_distinct"}},
+
+		SeriesID:   AggrOption{Column: "_series_id"},
+		MetricName: AggrOption{Column: labels.MetricName},
+
+		Units: UnitsOption{Column: "_unit"},
+
+		Summaries: SummariesOption{Column: "_summary"},
+
+		Interval: IntervalOption{AggrOption: AggrOption{Column: "_scrape_interval"}, StepColumn: "_step"},
 	}
 }
 
@@ -65,15 +158,28 @@ type aggregatedSeries struct {
 	minTime     time.Time
 	maxTime     time.Time
 	count       uint64
+	sampleCount uint64
 	min         float64
 	max         float64
 	sum         float64
+	first       float64
+	last        float64
+	distinct    distinctCounter
+
+	// weightedSum and elapsed are the sum of the per-second deltas weighted by the milliseconds they span, and the
+	// sum of the milliseconds, giving the average of irregularly spaced deltas.
+	weightedSum float64
+	elapsed     int64
 }
 
 type seriesAggregator struct {
 	df         *seriesDataframe
+	summaries  *summaryBuilder
 	resolution time.Duration
 	options    AggrsOptions
+
+	// interval estimates the interval of the active series, if enabled.
+	interval *intervalEstimator
 }
 
 // IteratorFromSeries returns iterator that produce dataframe for every series.
@@ -82,17 +188,29 @@ func FromSeries(r series.Set, resolution time.Duration, opts ...AggrOptionFunc)
 	defer r.Close()
 
 	// TODO(bwplotka): What if resolution is 0?
+	options := evalOptions(opts)
+	if options.Transform != nil {
+		r = series.NewTransformedSet(r, options.Transform)
+	}
+	if len(options.Overrides) > 0 {
+		r = &overrideSet{Set: r, def: options.Delta, overrides: options.Overrides}
+	} else if options.Delta.Enabled {
+		r = series.NewDeltaSet(r, options.Delta.DeltaOptions)
+	}
 	a := &seriesAggregator{
 		resolution: resolution,
-		options:    *evalOptions(opts),
+		options:    *options,
 		df:         &seriesDataframe{seriesRecordSets: make(map[uint64]*seriesRecordSet)},
 	}
+	if a.options.Summaries.Enabled {
+		a.summaries = newSummaryBuilder(a.options.Summaries)
+	}
 
 	var activeSeries *aggregatedSeries
 	var currentHash uint64
 	for r.Next() {
 		s := r.At()
-		ls := s.Labels()
+		ls := normalizeLabels(s.Labels())
 		seriesHash := ls.Hash()
 
 		i := s.Iterator()
@@ -107,6 +225,10 @@ func FromSeries(r series.Set, resolution time.Duration, opts ...AggrOptionFunc)
 		if currentHash != seriesHash {
 			if activeSeries != nil {
 				_ = a.finalizeSample(activeSeries, activeSeries.sampleEnd)
+				a.finalizeInterval(activeSeries.hash)
+			}
+			if a.options.Interval.Enabled && a.summaries == nil {
+				a.interval = &intervalEstimator{}
 			}
 
 			mint, _ := i.At()
@@ -125,25 +247,77 @@ func FromSeries(r series.Set, resolution time.Duration, opts ...AggrOptionFunc)
 			continue
 		}
 
-		if err := a.ingestSamples(activeSeries, i); err != nil {
+		var err error
+		if activeSeries, err = a.ingestSamples(activeSeries, i); err != nil {
 			return nil, errors.Wrap(err, "aggregating samples")
 		}
 	}
 
 	if activeSeries != nil {
 		_ = a.finalizeSample(activeSeries, activeSeries.sampleEnd)
+		a.finalizeInterval(activeSeries.hash)
+	}
+
+	if a.summaries != nil {
+		return a.summaries.dataframe(), r.Err()
 	}
 
 	// We postpone the schema calculation to the time just before sending the df out
 	// so that we can use the ingested data to determine the labels to be exported.
-	a.df.schema = a.getSchema()
+	schema, err := a.getSchema()
+	if err != nil {
+		return nil, err
+	}
+	a.df.schema = schema
 	return a.df, r.Err()
 }
 
+// SchemaOf returns the schema of the dataframe created by FromSeries from the series with the options, reading only the
+// labels of the series, e.g. to preview the output columns without reading the samples.
+func SchemaOf(r series.Set, opts ...AggrOptionFunc) (Schema, error) {
+	df, err := FromSeries(labelsOnlySet{Set: r}, time.Minute, append(opts, func(o *AggrsOptions) {
+		// The functions of the samples do not change the columns, but the unit of the per-second deltas. The
+		// single sample is kept as the zero delta, it could be dropped otherwise.
+		o.Transform = nil
+		o.Delta.First = series.DeltaFirstZero
+		o.EmptyWindows = false
+	})...)
+	if err != nil {
+		return nil, err
+	}
+	return df.Schema(), nil
+}
+
+// labelsOnlySet replaces the samples of every series by a single zero sample.
+type labelsOnlySet struct {
+	series.Set
+}
+
+func (s labelsOnlySet) At() storage.Series {
+	return storage.NewListSeries(s.Set.At().Labels(), []tsdbutil.Sample{zeroSample{}})
+}
+
+type zeroSample struct{}
+
+func (zeroSample) T() int64   { return 0 }
+func (zeroSample) V() float64 { return 0 }
+
+// normalizeLabels returns the labels sorted by name. This is the single point where the label order
+// is made canonical: hashing, fingerprinting and the output all rely on it.
+func normalizeLabels(ls labels.Labels) labels.Labels {
+	if sort.IsSorted(ls) {
+		return ls
+	}
+	ls = ls.Copy()
+	sort.Sort(ls)
+	return ls
+}
+
 // ingestSamples ingests samples provided via an iterator for single series. We
 // assume the iterator returns values ordered by the timestamp.
 // The iterator is expected to already be at the point of the first sample after as.sampleStart.
-func (a *seriesAggregator) ingestSamples(as *aggregatedSeries, i chunkenc.Iterator) error {
+// Returns the aggregated series active after the last sample.
+func (a *seriesAggregator) ingestSamples(as *aggregatedSeries, i chunkenc.Iterator) (*aggregatedSeries, error) {
 	var (
 		ts int64
 		v  float64
@@ -152,33 +326,62 @@ func (a *seriesAggregator) ingestSamples(as *aggregatedSeries, i chunkenc.Iterat
 	for {
 		ts, v = i.At()
 		t = timestamp.Time(ts)
+		// The downsampled samples are averages, count, sum, min and max are of their raw samples when known.
+		ag, ok := series.SampleAggregate(i)
+		if !ok {
+			ag = series.Aggregate{Count: 1, Sum: v, Min: v, Max: v}
+		}
 		if t.Before(as.sampleStart) {
-			return errors.Errorf("Chunk timestamp %s is less than the sampleStart %s", t, as.sampleStart)
+			return as, errors.Errorf("Chunk timestamp %s is less than the sampleStart %s", t, as.sampleStart)
 		}
-		if t.After(as.sampleEnd) {
+		if !t.Before(as.sampleEnd) {
 			as = a.finalizeSample(as, t)
 		}
 
 		if as.count == 0 {
 			as.minTime = t
 			as.maxTime = t
-			as.min = v
-			as.max = v
+			as.min = ag.Min
+			as.max = ag.Max
+			as.first = v
+			as.last = v
+			if a.options.CountDistinct.Enabled {
+				as.distinct = newDistinctCounter(a.options.CountDistinct.Approximate)
+			}
 		}
 		if as.maxTime.After(t) {
-			return errors.Errorf("Incoming chunks are not sorted by timestamp: expected %s after %s", t, as.maxTime)
+			return as, errors.Errorf("Incoming chunks are not sorted by timestamp: expected %s after %s", t, as.maxTime)
+		}
+		if t.Equal(as.minTime) && v < as.first {
+			as.first = v
+		}
+		if t.After(as.maxTime) || v > as.last {
+			as.last = v
 		}
 		as.maxTime = t
-		as.count += 1
-		as.sum += v
-		if as.max < v {
-			as.max = v
+		as.count += ag.Count
+		if a.options.SampleCount.Enabled {
+			as.sampleCount += series.SampleCount(i)
 		}
-		if as.min > v {
-			as.min = v
+		as.sum += ag.Sum
+		if d := series.Elapsed(i); d > 0 {
+			as.weightedSum += v * float64(d)
+			as.elapsed += d
+		}
+		if as.max < ag.Max {
+			as.max = ag.Max
+		}
+		if as.min > ag.Min {
+			as.min = ag.Min
+		}
+		if as.distinct != nil {
+			as.distinct.add(v)
+		}
+		if a.interval != nil {
+			a.interval.add(ts)
 		}
 		if !i.Next() {
-			return i.Err()
+			return as, i.Err()
 		}
 	}
 }
@@ -187,7 +390,7 @@ func (a *seriesAggregator) ingestSamples(as *aggregatedSeries, i chunkenc.Iterat
 // sample end time. Returns pointer to a new instance of the aggregatedSeries.
 func (a *seriesAggregator) finalizeSample(as *aggregatedSeries, nextT time.Time) *aggregatedSeries {
 	if as.count > 0 {
-		a.df.addSeries(as, a.options)
+		a.addSeries(as)
 	}
 
 	// calculate the next sample cycle to contain the nextT time. First calculate how many
@@ -196,6 +399,12 @@ func (a *seriesAggregator) finalizeSample(as *aggregatedSeries, nextT time.Time)
 	nextSampleCycle := (nextT.Unix() - as.sampleStart.Unix()) / (int64)(a.resolution/time.Second)
 	nextSampleStart := as.sampleStart.Add((time.Duration(nextSampleCycle)) * a.resolution)
 
+	if a.options.EmptyWindows {
+		for c := int64(1); c < nextSampleCycle; c++ {
+			a.addSeries(a.emptyWindow(as, as.sampleStart.Add(time.Duration(c)*a.resolution)))
+		}
+	}
+
 	return &aggregatedSeries{
 		labels:      as.labels,
 		hash:        as.hash,
@@ -204,6 +413,51 @@ func (a *seriesAggregator) finalizeSample(as *aggregatedSeries, nextT time.Time)
 	}
 }
 
+// finalizeInterval sets the estimated interval of the series to all its rows, once all its samples were ingested.
+func (a *seriesAggregator) finalizeInterval(hash uint64) {
+	if a.interval == nil {
+		return
+	}
+	rs, ok := a.df.seriesRecordSets[hash]
+	if !ok {
+		return
+	}
+	interval := a.interval.seconds()
+	for _, r := range rs.Records {
+		r.Values[a.options.Interval.Column] = interval
+	}
+}
+
+// addSeries adds the aggregated window of the series to the dataframe, or to the summaries if they are grouped.
+func (a *seriesAggregator) addSeries(as *aggregatedSeries) {
+	if a.summaries != nil {
+		a.summaries.add(as)
+		return
+	}
+	a.df.addSeries(as, a.options)
+}
+
+// emptyWindow returns the aggregated series of a window without samples.
+func (a *seriesAggregator) emptyWindow(as *aggregatedSeries, sampleStart time.Time) *aggregatedSeries {
+	nan := math.NaN()
+	e := &aggregatedSeries{
+		labels:      as.labels,
+		hash:        as.hash,
+		sampleStart: sampleStart,
+		sampleEnd:   sampleStart.Add(a.resolution),
+		minTime:     sampleStart,
+		maxTime:     sampleStart,
+		min:         nan,
+		max:         nan,
+		first:       nan,
+		last:        nan,
+	}
+	if a.options.CountDistinct.Enabled {
+		e.distinct = newDistinctCounter(false)
+	}
+	return e
+}
+
 // getLabelNames assumes all series having the same labels and just takes the first
 // series to get the label names.
 // The returned strings are always sorted alphabetically.
@@ -234,13 +488,22 @@ func (a *seriesAggregator) getLabelNames() []string {
 	return ret
 }
 
-func (a *seriesAggregator) getSchema() Schema {
+func (a *seriesAggregator) getSchema() (Schema, error) {
 	ao := a.options
 	schema := Schema{}
 
+	if ao.MetricName.Enabled {
+		schema = append(schema, Column{Name: ao.MetricName.Column, Type: TypeString})
+	}
 	for _, l := range a.getLabelNames() {
+		if ao.MetricName.Enabled && l == ao.MetricName.Column {
+			return nil, errors.Errorf("metric name column %s collides with the label of the same name", l)
+		}
 		schema = append(schema, Column{Name: l, Type: TypeString})
 	}
+	if ao.SeriesID.Enabled {
+		schema = append(schema, Column{Name: ao.SeriesID.Column, Type: TypeUint})
+	}
 
 	timeColumns := []Column{
 		{Name: "_sample_start", Type: TypeTime},
@@ -249,10 +512,16 @@ func (a *seriesAggregator) getSchema() Schema {
 		{Name: "_max_time", Type: TypeTime},
 	}
 	schema = append(schema, timeColumns...)
+	if ao.Interval.Enabled {
+		schema = append(schema, Column{Name: ao.Interval.Column, Type: TypeFloat}, Column{Name: ao.Interval.StepColumn, Type: TypeFloat})
+	}
 
 	if ao.Count.Enabled {
 		schema = append(schema, Column{Name: ao.Count.Column, Type: TypeUint})
 	}
+	if ao.SampleCount.Enabled {
+		schema = append(schema, Column{Name: ao.SampleCount.Column, Type: TypeUint})
+	}
 	if ao.Sum.Enabled {
 		schema = append(schema, Column{Name: ao.Sum.Column, Type: TypeFloat})
 	}
@@ -262,8 +531,23 @@ func (a *seriesAggregator) getSchema() Schema {
 	if ao.Max.Enabled {
 		schema = append(schema, Column{Name: ao.Max.Column, Type: TypeFloat})
 	}
+	if ao.Avg.Enabled {
+		schema = append(schema, Column{Name: ao.Avg.Column, Type: TypeFloat})
+	}
+	if ao.First.Enabled {
+		schema = append(schema, Column{Name: ao.First.Column, Type: TypeFloat})
+	}
+	if ao.Last.Enabled {
+		schema = append(schema, Column{Name: ao.Last.Column, Type: TypeFloat})
+	}
+	if ao.CountDistinct.Enabled {
+		schema = append(schema, Column{Name: ao.CountDistinct.Column, Type: TypeUint})
+	}
+	if ao.unitColumn() {
+		schema = append(schema, Column{Name: ao.Units.Column, Type: TypeString})
+	}
 
-	return schema
+	return schema, nil
 }
 
 // seriesDataframe implements dataframe.Dataframe.
@@ -292,25 +576,73 @@ func (df *seriesDataframe) addSeries(as *aggregatedSeries, opts AggrsOptions) {
 		}
 		vals[l.Name] = l.Value
 	}
+	if name := as.labels.Get(labels.MetricName); opts.MetricName.Enabled && name != "" {
+		vals[opts.MetricName.Column] = name
+	}
 
+	scale := 1.0
+	name := as.labels.Get(labels.MetricName)
+	conv, converted := opts.Units.Conversions[name]
+	if converted {
+		scale = conv.Scale
+		vals[opts.Units.Column] = conv.Unit
+	}
+	if opts.unitColumn() && opts.perSecond(as.labels) {
+		unit := conv.Unit
+		if !converted {
+			unit = metricUnit(name)
+		}
+		vals[opts.Units.Column] = unit + "/" + opts.Delta.Unit.Symbol()
+	}
+
+	if opts.SeriesID.Enabled {
+		vals[opts.SeriesID.Column] = rs.Fingerprint
+	}
+	if opts.Interval.Enabled {
+		vals[opts.Interval.StepColumn] = as.sampleEnd.Sub(as.sampleStart).Seconds()
+	}
 	if opts.Count.Enabled {
 		vals[opts.Count.Column] = as.count
 	}
+	if opts.SampleCount.Enabled {
+		vals[opts.SampleCount.Column] = as.sampleCount
+	}
+	round := opts.Rounding.round
 	if opts.Sum.Enabled {
-		vals[opts.Sum.Column] = as.sum
+		vals[opts.Sum.Column] = round(as.sum * scale)
 	}
 	if opts.Min.Enabled {
-		vals[opts.Min.Column] = as.min
+		vals[opts.Min.Column] = round(as.min * scale)
 	}
 	if opts.Max.Enabled {
-		vals[opts.Max.Column] = as.max
+		vals[opts.Max.Column] = round(as.max * scale)
+	}
+	if opts.Avg.Enabled {
+		avg := math.NaN()
+		switch {
+		case as.elapsed > 0:
+			// The per-second deltas are weighted by the time they span, giving the average rate of the window.
+			avg = as.weightedSum / float64(as.elapsed) * scale
+		case as.count > 0:
+			avg = as.sum / float64(as.count) * scale
+		}
+		vals[opts.Avg.Column] = round(avg)
+	}
+	if opts.First.Enabled {
+		vals[opts.First.Column] = round(as.first * scale)
+	}
+	if opts.Last.Enabled {
+		vals[opts.Last.Column] = round(as.last * scale)
+	}
+	if opts.CountDistinct.Enabled {
+		vals[opts.CountDistinct.Column] = as.distinct.count()
 	}
 	rs.Records = append(rs.Records, Record{Values: vals})
 }
 
 // Initiate new recordset for specific label.
 func (df *seriesDataframe) addRecordSet(ls labels.Labels) *seriesRecordSet {
-	rs := &seriesRecordSet{Labels: ls, Records: make([]Record, 0)}
+	rs := &seriesRecordSet{Labels: ls, Fingerprint: Fingerprint(ls), Records: make([]Record, 0)}
 	hash := ls.Hash()
 	df.seriesRecordSets[hash] = rs
 	df.seriesOrder = append(df.seriesOrder, hash)
@@ -329,6 +661,51 @@ func (df seriesDataframe) RowsIterator() RowsIterator {
 	return &seriesDataframeRowIterator{seriesRecordSets: rs, schema: df.schema, seriesPos: 0, recordPos: -1}
 }
 
+// SeriesDataframe is the dataframe of a single series.
+type SeriesDataframe struct {
+	Dataframe
+
+	Labels      labels.Labels
+	Fingerprint uint64
+}
+
+// Wrapper is implemented by the dataframes passing the rows of another dataframe through unchanged, e.g. to count
+// them, so that the wrapped dataframe can be split by SplitSeries.
+type Wrapper interface {
+	Unwrap() Dataframe
+}
+
+// SplitSeries splits the dataframe created by FromSeries into a dataframe per series, in the order the series were
+// read. The dataframes have the schema of the whole dataframe, so that they can be read together. Returns false for
+// other dataframes, e.g. merged or with grouped summaries.
+func SplitSeries(df Dataframe) ([]SeriesDataframe, bool) {
+	for {
+		w, ok := df.(Wrapper)
+		if !ok {
+			break
+		}
+		df = w.Unwrap()
+	}
+	sdf, ok := df.(*seriesDataframe)
+	if !ok {
+		return nil, false
+	}
+	ret := make([]SeriesDataframe, 0, len(sdf.seriesOrder))
+	for _, h := range sdf.seriesOrder {
+		rs := sdf.seriesRecordSets[h]
+		ret = append(ret, SeriesDataframe{
+			Dataframe: &seriesDataframe{
+				schema:           sdf.schema,
+				seriesRecordSets: map[uint64]*seriesRecordSet{h: rs},
+				seriesOrder:      []uint64{h},
+			},
+			Labels:      rs.Labels,
+			Fingerprint: rs.Fingerprint,
+		})
+	}
+	return ret, true
+}
+
 // seriesDataframeRowIterator implements dataframe.RowIterator.
 type seriesDataframeRowIterator struct {
 	seriesRecordSets []seriesRecordSet
@@ -369,8 +746,9 @@ func (i *seriesDataframeRowIterator) At() Row {
 
 // seriesRecordSet is a set of records for specific labels values.
 type seriesRecordSet struct {
-	Labels  labels.Labels
-	Records []Record
+	Labels      labels.Labels
+	Fingerprint uint64
+	Records     []Record
 }
 
 // Record is a single instance of values for specific sample.
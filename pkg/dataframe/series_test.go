@@ -0,0 +1,577 @@
+package dataframe
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/thanos-community/obslytics/pkg/series"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+type sample struct {
+	t int64
+	v float64
+}
+
+// testSeries implements storage.Series on top of in-memory samples.
+type testSeries struct {
+	lset    labels.Labels
+	samples []sample
+}
+
+func (s testSeries) Labels() labels.Labels { return s.lset }
+
+func (s testSeries) Iterator() chunkenc.Iterator {
+	return &testSeriesIterator{samples: s.samples, i: -1}
+}
+
+type testSeriesIterator struct {
+	samples []sample
+	i       int
+}
+
+func (it *testSeriesIterator) Next() bool {
+	it.i++
+	return it.i < len(it.samples)
+}
+
+func (it *testSeriesIterator) Seek(t int64) bool {
+	if it.i < 0 {
+		it.i = 0
+	}
+	for ; it.i < len(it.samples); it.i++ {
+		if it.samples[it.i].t >= t {
+			return true
+		}
+	}
+	return false
+}
+
+func (it *testSeriesIterator) At() (int64, float64) {
+	return it.samples[it.i].t, it.samples[it.i].v
+}
+
+func (it *testSeriesIterator) Err() error { return nil }
+
+// testSeriesSet implements series.Set on top of in-memory series.
+type testSeriesSet struct {
+	series []storage.Series
+	i      int
+}
+
+func newTestSeriesSet(series ...storage.Series) *testSeriesSet {
+	return &testSeriesSet{series: series, i: -1}
+}
+
+func (s *testSeriesSet) Next() bool {
+	s.i++
+	return s.i < len(s.series)
+}
+
+func (s *testSeriesSet) At() storage.Series         { return s.series[s.i] }
+func (s *testSeriesSet) Err() error                 { return nil }
+func (s *testSeriesSet) Warnings() storage.Warnings { return nil }
+func (s *testSeriesSet) Close() error               { return nil }
+
+func countRows(df Dataframe) int {
+	n := 0
+	for i := df.RowsIterator(); i.Next(); {
+		n++
+	}
+	return n
+}
+
+func TestFromSeries_Windows(t *testing.T) {
+	df, err := FromSeries(newTestSeriesSet(
+		testSeries{lset: labels.FromStrings("job", "a"), samples: []sample{{t: 0, v: 1}, {t: 10000, v: 2}, {t: 70000, v: 3}}},
+	), time.Minute, func(o *AggrsOptions) { o.Count.Enabled = true })
+	testutil.Ok(t, err)
+
+	// Every window of the series is written once, the last one included.
+	var starts, counts []interface{}
+	for i := df.RowsIterator(); i.Next(); {
+		starts = append(starts, i.At()[1])
+		counts = append(counts, i.At()[5])
+	}
+	testutil.Equals(t, []interface{}{time.Unix(0, 0).UTC(), time.Unix(60, 0).UTC()}, starts)
+	testutil.Equals(t, []interface{}{uint64(2), uint64(1)}, counts)
+}
+
+func TestFromSeries_LabelOrderStability(t *testing.T) {
+	sorted := labels.Labels{
+		{Name: "__name__", Value: "up"},
+		{Name: "instance", Value: "a"},
+		{Name: "job", Value: "b"},
+	}
+	unsorted := labels.Labels{sorted[2], sorted[0], sorted[1]}
+
+	// The same series partitioned between two iterations, with the labels in different order.
+	df, err := FromSeries(newTestSeriesSet(
+		testSeries{lset: sorted, samples: []sample{{t: 0, v: 1}, {t: 10000, v: 2}}},
+		testSeries{lset: unsorted, samples: []sample{{t: 20000, v: 3}}},
+	), time.Minute, func(o *AggrsOptions) {
+		o.Count.Enabled = true
+		o.SeriesID.Enabled = true
+	})
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, countRows(df))
+	testutil.Equals(t, Schema{
+		{Name: "instance", Type: TypeString},
+		{Name: "job", Type: TypeString},
+		{Name: "_series_id", Type: TypeUint},
+		{Name: "_sample_start", Type: TypeTime},
+		{Name: "_sample_end", Type: TypeTime},
+		{Name: "_min_time", Type: TypeTime},
+		{Name: "_max_time", Type: TypeTime},
+		{Name: "_count", Type: TypeUint},
+	}, df.Schema())
+
+	i := df.RowsIterator()
+	testutil.Assert(t, i.Next())
+	row := i.At()
+	testutil.Equals(t, "a", row[0])
+	testutil.Equals(t, "b", row[1])
+	testutil.Equals(t, Fingerprint(unsorted), row[2])
+	testutil.Equals(t, uint64(3), row[7])
+}
+
+func TestFromSeries_FirstLast(t *testing.T) {
+	for _, samples := range [][]sample{
+		{{t: 0, v: 5}, {t: 0, v: 3}, {t: 10000, v: 7}, {t: 10000, v: 2}, {t: 70000, v: 4}},
+		// Duplicates in a different order resolve the same way.
+		{{t: 0, v: 3}, {t: 0, v: 5}, {t: 10000, v: 2}, {t: 10000, v: 7}, {t: 70000, v: 4}},
+	} {
+		df, err := FromSeries(newTestSeriesSet(
+			testSeries{lset: labels.FromStrings("__name__", "up"), samples: samples},
+		), time.Minute, func(o *AggrsOptions) {
+			o.First.Enabled = true
+			o.Last.Enabled = true
+		})
+		testutil.Ok(t, err)
+
+		s := df.Schema()
+		testutil.Equals(t, Schema{{Name: "_first", Type: TypeFloat}, {Name: "_last", Type: TypeFloat}}, s[len(s)-2:])
+
+		var got [][]interface{}
+		for i := df.RowsIterator(); i.Next(); {
+			row := i.At()
+			got = append(got, row[len(row)-2:])
+		}
+		testutil.Equals(t, [][]interface{}{{3.0, 7.0}, {4.0, 4.0}}, got)
+	}
+}
+
+func TestFromSeries_Avg(t *testing.T) {
+	df, err := FromSeries(newTestSeriesSet(
+		testSeries{lset: labels.FromStrings("__name__", "up"), samples: []sample{{t: 0, v: 1}, {t: 10000, v: 4}, {t: 200000, v: 2}}},
+	), time.Minute, func(o *AggrsOptions) {
+		o.Min.Enabled = true
+		o.Max.Enabled = true
+		o.Avg.Enabled = true
+		o.EmptyWindows = true
+	})
+	testutil.Ok(t, err)
+
+	s := df.Schema()
+	testutil.Equals(t, Schema{{Name: "_min", Type: TypeFloat}, {Name: "_max", Type: TypeFloat}, {Name: "_avg", Type: TypeFloat}}, s[len(s)-3:])
+
+	var avgs []float64
+	for i := df.RowsIterator(); i.Next(); {
+		row := i.At()
+		avgs = append(avgs, row[len(row)-1].(float64))
+	}
+	testutil.Equals(t, 4, len(avgs))
+	testutil.Equals(t, 2.5, avgs[0])
+	// Empty windows have no average.
+	testutil.Assert(t, math.IsNaN(avgs[1]) && math.IsNaN(avgs[2]))
+	testutil.Equals(t, 2.0, avgs[3])
+}
+
+// countedSeries is a series of downsampled samples, each aggregated from count raw samples.
+type countedSeries struct {
+	testSeries
+
+	count uint64
+}
+
+func (s countedSeries) Iterator() chunkenc.Iterator {
+	return &countedIterator{Iterator: s.testSeries.Iterator(), count: s.count}
+}
+
+type countedIterator struct {
+	chunkenc.Iterator
+
+	count uint64
+}
+
+func (it *countedIterator) SampleCount() uint64 { return it.count }
+
+func TestFromSeries_SampleCount(t *testing.T) {
+	samples := []sample{{t: 0, v: 1}, {t: 10000, v: 4}, {t: 200000, v: 2}}
+	df, err := FromSeries(newTestSeriesSet(
+		testSeries{lset: labels.FromStrings("__name__", "up", "job", "raw"), samples: samples},
+		countedSeries{testSeries: testSeries{lset: labels.FromStrings("__name__", "up", "job", "downsampled"), samples: samples}, count: 10},
+	), time.Minute, func(o *AggrsOptions) {
+		o.Count.Enabled = true
+		o.SampleCount.Enabled = true
+		o.EmptyWindows = true
+	})
+	testutil.Ok(t, err)
+
+	s := df.Schema()
+	testutil.Equals(t, Schema{{Name: "_count", Type: TypeUint}, {Name: "_sample_count", Type: TypeUint}}, s[len(s)-2:])
+
+	var counts []interface{}
+	for i := df.RowsIterator(); i.Next(); {
+		row := i.At()
+		counts = append(counts, row[len(row)-1])
+	}
+	testutil.Equals(t, []interface{}{
+		uint64(2), uint64(0), uint64(0), uint64(1),
+		uint64(20), uint64(0), uint64(0), uint64(10),
+	}, counts)
+}
+
+func TestFromSeries_MetricName(t *testing.T) {
+	set := func() *testSeriesSet {
+		return newTestSeriesSet(
+			testSeries{lset: labels.FromStrings("__name__", "up", "job", "a"), samples: []sample{{t: 0, v: 1}}},
+			testSeries{lset: labels.FromStrings("job", "b"), samples: []sample{{t: 0, v: 2}}},
+		)
+	}
+	df, err := FromSeries(set(), time.Minute, func(o *AggrsOptions) {
+		o.Count.Enabled = true
+		o.MetricName.Enabled = true
+	})
+	testutil.Ok(t, err)
+	testutil.Equals(t, Schema{{Name: "__name__", Type: TypeString}, {Name: "job", Type: TypeString}}, df.Schema()[:2])
+
+	var names []interface{}
+	for i := df.RowsIterator(); i.Next(); {
+		names = append(names, i.At()[0])
+	}
+	// Series without the metric name have none.
+	testutil.Equals(t, []interface{}{"up", nil}, names)
+
+	df, err = FromSeries(set(), time.Minute, func(o *AggrsOptions) {
+		o.MetricName = AggrOption{Enabled: true, Column: "metric"}
+	})
+	testutil.Ok(t, err)
+	testutil.Equals(t, "metric", df.Schema()[0].Name)
+
+	_, err = FromSeries(set(), time.Minute, func(o *AggrsOptions) {
+		o.MetricName = AggrOption{Enabled: true, Column: "job"}
+	})
+	testutil.NotOk(t, err)
+}
+
+func TestFromSeries_WindowBoundary(t *testing.T) {
+	// A sample at the end of a window belongs to the next window, whether or not the series has samples before it.
+	for _, samples := range [][]sample{
+		{{t: 30000, v: 1}, {t: 60000, v: 2}, {t: 90000, v: 3}},
+		{{t: 60000, v: 2}, {t: 90000, v: 3}},
+	} {
+		df, err := FromSeries(newTestSeriesSet(
+			testSeries{lset: labels.FromStrings("__name__", "up"), samples: samples},
+		), time.Minute, func(o *AggrsOptions) { o.Sum.Enabled = true })
+		testutil.Ok(t, err)
+
+		var got []interface{}
+		for i := df.RowsIterator(); i.Next(); {
+			row := i.At()
+			got = append(got, row[len(row)-1])
+		}
+		testutil.Equals(t, 5.0, got[len(got)-1])
+	}
+}
+
+func TestFromSeries_EmptyWindows(t *testing.T) {
+	set := func() *testSeriesSet {
+		return newTestSeriesSet(testSeries{
+			lset:    labels.FromStrings("__name__", "up"),
+			samples: []sample{{t: 0, v: 1}, {t: 200000, v: 2}},
+		})
+	}
+
+	// Windows without samples are omitted by default.
+	df, err := FromSeries(set(), time.Minute, func(o *AggrsOptions) { o.Count.Enabled = true })
+	testutil.Ok(t, err)
+	testutil.Equals(t, 2, countRows(df))
+
+	df, err = FromSeries(set(), time.Minute, func(o *AggrsOptions) {
+		o.Count.Enabled = true
+		o.Max.Enabled = true
+		o.EmptyWindows = true
+	})
+	testutil.Ok(t, err)
+
+	var (
+		starts []int64
+		counts []interface{}
+		maxs   []float64
+	)
+	for i := df.RowsIterator(); i.Next(); {
+		row := i.At()
+		starts = append(starts, timestamp.FromTime(row[0].(time.Time)))
+		counts = append(counts, row[4])
+		maxs = append(maxs, row[5].(float64))
+	}
+	testutil.Equals(t, []int64{0, 60000, 120000, 180000}, starts)
+	testutil.Equals(t, []interface{}{uint64(1), uint64(0), uint64(0), uint64(1)}, counts)
+	testutil.Equals(t, 1.0, maxs[0])
+	testutil.Assert(t, math.IsNaN(maxs[1]) && math.IsNaN(maxs[2]))
+	testutil.Equals(t, 2.0, maxs[3])
+}
+
+func TestFromSeries_Units(t *testing.T) {
+	toGiB, err := NewUnitConversion("bytes", "GiB")
+	testutil.Ok(t, err)
+
+	df, err := FromSeries(newTestSeriesSet(
+		testSeries{lset: labels.FromStrings("__name__", "mem_bytes"), samples: []sample{{t: 0, v: 1 << 30}, {t: 10000, v: 3 << 30}}},
+		testSeries{lset: labels.FromStrings("__name__", "up"), samples: []sample{{t: 0, v: 1}}},
+	), time.Minute, func(o *AggrsOptions) {
+		o.Count.Enabled = true
+		o.Sum.Enabled = true
+		o.Max.Enabled = true
+		o.Units.Conversions = map[string]UnitConversion{"mem_bytes": toGiB}
+	})
+	testutil.Ok(t, err)
+
+	s := df.Schema()
+	testutil.Equals(t, Column{Name: "_unit", Type: TypeString}, s[len(s)-1])
+
+	var got [][]interface{}
+	for i := df.RowsIterator(); i.Next(); {
+		row := i.At()
+		got = append(got, row[len(row)-4:])
+	}
+	// Counts are not converted, metrics without conversion have no unit.
+	testutil.Equals(t, [][]interface{}{{uint64(2), 4.0, 3.0, "GiB"}, {uint64(1), 1.0, 1.0, nil}}, got)
+}
+
+func TestFromSeries_Transform(t *testing.T) {
+	df, err := FromSeries(newTestSeriesSet(
+		testSeries{lset: labels.FromStrings("__name__", "up"), samples: []sample{{t: 0, v: 1}, {t: 10000, v: 50}, {t: 20000, v: 3}}},
+	), time.Minute, func(o *AggrsOptions) {
+		o.Count.Enabled = true
+		o.Sum.Enabled = true
+		o.Transform = func(_ labels.Labels, t int64, v float64) (int64, float64, bool) {
+			return t, v * 10, v < 10
+		}
+	})
+	testutil.Ok(t, err)
+
+	i := df.RowsIterator()
+	testutil.Assert(t, i.Next())
+	row := i.At()
+	testutil.Equals(t, []interface{}{uint64(2), 40.0}, []interface{}(row[len(row)-2:]))
+}
+
+func TestFromSeries_Delta(t *testing.T) {
+	df, err := FromSeries(newTestSeriesSet(
+		testSeries{lset: labels.FromStrings("__name__", "temp"), samples: []sample{{t: 0, v: 20}, {t: 10000, v: 25}, {t: 20000, v: 21}}},
+	), time.Minute, func(o *AggrsOptions) {
+		o.Count.Enabled = true
+		o.Sum.Enabled = true
+		o.Delta.Enabled = true
+	})
+	testutil.Ok(t, err)
+
+	i := df.RowsIterator()
+	testutil.Assert(t, i.Next())
+	row := i.At()
+	// The first sample is omitted.
+	testutil.Equals(t, []interface{}{uint64(2), 1.0}, []interface{}(row[len(row)-2:]))
+}
+
+func TestFromSeries_Overrides(t *testing.T) {
+	samples := []sample{{t: 0, v: 20}, {t: 10000, v: 30}, {t: 20000, v: 5}}
+	df, err := FromSeries(newTestSeriesSet(
+		testSeries{lset: labels.FromStrings("__name__", "requests_total"), samples: samples},
+		testSeries{lset: labels.FromStrings("__name__", "temp"), samples: samples},
+		testSeries{lset: labels.FromStrings("__name__", "queue"), samples: samples},
+	), time.Minute, func(o *AggrsOptions) {
+		o.Count.Enabled = true
+		o.Sum.Enabled = true
+		o.Delta.Enabled = true
+		o.Delta.Unit = series.RateUnitSecond
+		o.Overrides = []AggrOverride{
+			{Matchers: []*labels.Matcher{labels.MustNewMatcher(labels.MatchRegexp, "__name__", ".+_total")}, Function: SampleRate},
+			{Matchers: []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "__name__", "temp")}, Function: SampleValue},
+		}
+	})
+	testutil.Ok(t, err)
+
+	var got [][]interface{}
+	for i := df.RowsIterator(); i.Next(); {
+		row := i.At()
+		got = append(got, row[len(row)-3:])
+	}
+	testutil.Equals(t, [][]interface{}{
+		// Rate with the counter reset, per second, with the unit.
+		{uint64(2), 1.5, "/s"},
+		// Values of the gauge.
+		{uint64(3), 55.0, nil},
+		// Default delta.
+		{uint64(2), -15.0, nil},
+	}, got)
+}
+
+func TestFromSeries_RateUnit(t *testing.T) {
+	// Irregularly spaced samples of a counter growing by 60 per minute for 40s, then by 600 per minute for 10s.
+	samples := []sample{{t: 0, v: 0}, {t: 10000, v: 10}, {t: 40000, v: 40}, {t: 50000, v: 140}}
+	df, err := FromSeries(newTestSeriesSet(
+		testSeries{lset: labels.FromStrings("__name__", "transmit_bytes_total"), samples: samples},
+		testSeries{lset: labels.FromStrings("__name__", "requests_total"), samples: samples},
+	), time.Minute, func(o *AggrsOptions) {
+		o.Count.Enabled = true
+		o.Avg.Enabled = true
+		o.Delta = DeltaOption{Enabled: true, DeltaOptions: series.DeltaOptions{PerSecond: true, Counter: true, Unit: series.RateUnitMinute}}
+		o.Units.Conversions = map[string]UnitConversion{"transmit_bytes_total": {Scale: 8, Unit: "bits"}}
+	})
+	testutil.Ok(t, err)
+
+	var got [][]interface{}
+	for i := df.RowsIterator(); i.Next(); {
+		row := i.At()
+		got = append(got, row[len(row)-3:])
+	}
+	testutil.Equals(t, [][]interface{}{
+		// The average is the increase of the window per its elapsed time, 140 per 50s, not the average of the
+		// per-sample rates.
+		{uint64(3), 168.0 * 8, "bits/min"},
+		{uint64(3), 168.0, "/min"},
+	}, got)
+
+	testutil.Equals(t, "bytes", metricUnit("transmit_bytes_total"))
+	testutil.Equals(t, "seconds", metricUnit("process_cpu_seconds_total"))
+	testutil.Equals(t, "", metricUnit("up"))
+}
+
+func TestFromSeries_PerSecondWrapped(t *testing.T) {
+	// The per-second deltas of the input are transformed, their elapsed time is read through the transformation.
+	samples := []sample{{t: 0, v: 0}, {t: 10000, v: 10}, {t: 40000, v: 40}, {t: 50000, v: 140}}
+	df, err := FromSeries(series.NewDeltaSet(newTestSeriesSet(
+		testSeries{lset: labels.FromStrings("__name__", "transmit_bytes_total"), samples: samples},
+	), series.DeltaOptions{PerSecond: true, Counter: true}), time.Minute, func(o *AggrsOptions) {
+		o.Avg.Enabled = true
+		o.Delta.PerSecond = true
+		o.Transform = func(_ labels.Labels, t int64, v float64) (int64, float64, bool) { return t, v, true }
+	})
+	testutil.Ok(t, err)
+
+	// No unit column is written unless the rate unit is set.
+	s := df.Schema()
+	testutil.Equals(t, Column{Name: "_avg", Type: TypeFloat}, s[len(s)-1])
+
+	i := df.RowsIterator()
+	testutil.Assert(t, i.Next())
+	row := i.At()
+	testutil.Equals(t, 2.8, row[len(row)-1])
+}
+
+func TestFromSeries_Interval(t *testing.T) {
+	df, err := FromSeries(newTestSeriesSet(
+		// Missed scrape and duplicate timestamp do not change the median.
+		testSeries{lset: labels.FromStrings("__name__", "up", "job", "a"), samples: []sample{
+			{t: 0, v: 1}, {t: 15000, v: 1}, {t: 30000, v: 1}, {t: 30000, v: 1}, {t: 60000, v: 1}, {t: 75000, v: 1},
+		}},
+		testSeries{lset: labels.FromStrings("__name__", "up", "job", "b"), samples: []sample{{t: 0, v: 1}}},
+	), time.Minute, func(o *AggrsOptions) {
+		o.Count.Enabled = true
+		o.Interval.Enabled = true
+	})
+	testutil.Ok(t, err)
+
+	s := df.Schema()
+	testutil.Equals(t, Schema{{Name: "_scrape_interval", Type: TypeFloat}, {Name: "_step", Type: TypeFloat}, {Name: "_count", Type: TypeUint}}, s[len(s)-3:])
+
+	var got [][]interface{}
+	for i := df.RowsIterator(); i.Next(); {
+		row := i.At()
+		got = append(got, row[len(row)-3:len(row)-1])
+	}
+	testutil.Equals(t, [][]interface{}{{15.0, 60.0}, {15.0, 60.0}}, got[:2])
+	testutil.Equals(t, 3, len(got))
+	testutil.Assert(t, math.IsNaN(got[2][0].(float64)), "single sample interval %v", got[2][0])
+}
+
+func TestFromSeries_Rounding(t *testing.T) {
+	samples := []sample{{t: 0, v: 14}, {t: 10000, v: 26}, {t: 20000, v: -15}}
+	for _, c := range []struct {
+		opt RoundingOption
+		exp []interface{}
+	}{
+		// Sum, min, max.
+		{opt: RoundingOption{}, exp: []interface{}{25.0, -15.0, 26.0}},
+		{opt: RoundingOption{Granularity: 10}, exp: []interface{}{30.0, -20.0, 30.0}},
+		{opt: RoundingOption{Granularity: 10, Mode: RoundFloor}, exp: []interface{}{20.0, -20.0, 20.0}},
+		{opt: RoundingOption{Granularity: 10, Mode: RoundCeil}, exp: []interface{}{30.0, -10.0, 30.0}},
+		{opt: RoundingOption{Granularity: 0.1, Mode: RoundNearest}, exp: []interface{}{25.0, -15.0, 26.0}},
+	} {
+		df, err := FromSeries(newTestSeriesSet(
+			testSeries{lset: labels.FromStrings("__name__", "temp"), samples: samples},
+		), time.Minute, func(o *AggrsOptions) {
+			o.Sum.Enabled = true
+			o.Min.Enabled = true
+			o.Max.Enabled = true
+			o.Rounding = c.opt
+		})
+		testutil.Ok(t, err)
+
+		i := df.RowsIterator()
+		testutil.Assert(t, i.Next())
+		row := i.At()
+		testutil.Equals(t, c.exp, []interface{}(row[len(row)-3:]))
+	}
+
+	testutil.Equals(t, 0.3, RoundingOption{Granularity: 0.1}.round(0.29))
+	testutil.Equals(t, 0.25, RoundingOption{Granularity: 0.05}.round(0.26))
+	testutil.Assert(t, math.IsNaN(RoundingOption{Granularity: 10}.round(math.NaN())))
+	testutil.NotOk(t, RoundingMode("half-even").Validate())
+}
+
+func TestSplitSeries(t *testing.T) {
+	a := labels.FromStrings("__name__", "up", "job", "a")
+	b := labels.FromStrings("__name__", "up", "instance", "x")
+	df, err := FromSeries(newTestSeriesSet(
+		testSeries{lset: a, samples: []sample{{t: 0, v: 1}, {t: 70000, v: 2}}},
+		testSeries{lset: b, samples: []sample{{t: 0, v: 3}}},
+	), time.Minute, func(o *AggrsOptions) { o.Count.Enabled = true })
+	testutil.Ok(t, err)
+
+	sdfs, ok := SplitSeries(df)
+	testutil.Assert(t, ok)
+	testutil.Equals(t, 2, len(sdfs))
+	testutil.Equals(t, a, sdfs[0].Labels)
+	testutil.Equals(t, Fingerprint(a), sdfs[0].Fingerprint)
+	testutil.Equals(t, 2, countRows(sdfs[0]))
+	testutil.Equals(t, b, sdfs[1].Labels)
+	testutil.Equals(t, 1, countRows(sdfs[1]))
+	// The schema is the one of the whole dataframe, with labels of all the series.
+	testutil.Equals(t, df.Schema(), sdfs[1].Schema())
+
+	_, ok = SplitSeries(Merge(df))
+	testutil.Assert(t, !ok)
+}
+
+func TestNewUnitConversion(t *testing.T) {
+	c, err := NewUnitConversion("seconds", "ms")
+	testutil.Ok(t, err)
+	testutil.Equals(t, UnitConversion{Scale: 1000, Unit: "ms"}, c)
+
+	c, err = NewUnitConversion("MiB", "bytes")
+	testutil.Ok(t, err)
+	testutil.Equals(t, UnitConversion{Scale: 1 << 20, Unit: "bytes"}, c)
+
+	_, err = NewUnitConversion("bytes", "ms")
+	testutil.NotOk(t, err)
+	_, err = NewUnitConversion("bytes", "parsecs")
+	testutil.NotOk(t, err)
+}
@@ -0,0 +1,234 @@
+package dataframe
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+)
+
+// quantileLabel is the label of the quantile series of classic summaries.
+const quantileLabel = "quantile"
+
+// SummariesOption defines options of grouping the classic summary metrics.
+type SummariesOption struct {
+	// Enabled groups the series of every summary (the quantile series and the _sum and _count series) into a single
+	// row per summary and resolution window. Series not being part of a summary are skipped.
+	Enabled bool
+	// Column to store the summary (base metric) name at. The sum and count are stored in the columns with _sum and
+	// _count suffix, the quantiles in columns with _quantile_ prefix and dots replaced by underscores,
+	// e.g. _quantile_0_99. The numeric quantiles are normalized, e.g. 0.50 is written to _quantile_0_5.
+	Column string
+}
+
+// summaryPart returns the base name of the summary the series may be part of and which part it is: the quantile
+// value, or "_sum", "_count" and "_bucket" suffixes. Bucket series are recognized to tell histograms apart.
+func summaryPart(ls labels.Labels) (base, quantile, suffix string) {
+	name := ls.Get(labels.MetricName)
+	if q := ls.Get(quantileLabel); q != "" {
+		return name, normalizeQuantile(q), ""
+	}
+	for _, s := range []string{"_sum", "_count", "_bucket"} {
+		if strings.HasSuffix(name, s) {
+			return strings.TrimSuffix(name, s), "", s
+		}
+	}
+	return "", "", ""
+}
+
+// summaryParts tracks the parts of the series having the same base name.
+type summaryParts struct {
+	quantiles, sum, count, bucket bool
+}
+
+// isSummary returns true if the parts form a summary: quantile series, or _sum and _count series without
+// _bucket ones (those are histograms).
+func (p summaryParts) isSummary() bool {
+	return p.quantiles || (p.sum && p.count && !p.bucket)
+}
+
+// summaryWindow holds the values of a summary within a resolution window. Values are the latest ones of the
+// window, nil if the window has no samples of the part.
+type summaryWindow struct {
+	sampleStart, sampleEnd time.Time
+	sum, count             *float64
+	quantiles              map[string]float64
+}
+
+type summaryGroup struct {
+	base    string
+	labels  labels.Labels
+	windows map[int64]*summaryWindow
+}
+
+// summaryBuilder groups the aggregated summary series by the base name and the labels other than quantile.
+type summaryBuilder struct {
+	opts SummariesOption
+
+	parts  map[string]*summaryParts
+	groups map[uint64]*summaryGroup
+	order  []uint64
+}
+
+func newSummaryBuilder(opts SummariesOption) *summaryBuilder {
+	return &summaryBuilder{opts: opts, parts: map[string]*summaryParts{}, groups: map[uint64]*summaryGroup{}}
+}
+
+func (b *summaryBuilder) add(as *aggregatedSeries) {
+	base, quantile, suffix := summaryPart(as.labels)
+	if base == "" || as.count == 0 {
+		return
+	}
+	p, ok := b.parts[base]
+	if !ok {
+		p = &summaryParts{}
+		b.parts[base] = p
+	}
+	switch {
+	case quantile != "":
+		p.quantiles = true
+	case suffix == "_sum":
+		p.sum = true
+	case suffix == "_count":
+		p.count = true
+	case suffix == "_bucket":
+		p.bucket = true
+		return
+	}
+
+	ls := labels.NewBuilder(as.labels).Del(quantileLabel).Set(labels.MetricName, base).Labels()
+	hash := ls.Hash()
+	g, ok := b.groups[hash]
+	if !ok {
+		g = &summaryGroup{base: base, labels: ls, windows: map[int64]*summaryWindow{}}
+		b.groups[hash] = g
+		b.order = append(b.order, hash)
+	}
+	w, ok := g.windows[as.sampleStart.UnixNano()]
+	if !ok {
+		w = &summaryWindow{sampleStart: as.sampleStart, sampleEnd: as.sampleEnd, quantiles: map[string]float64{}}
+		g.windows[as.sampleStart.UnixNano()] = w
+	}
+
+	v := as.last
+	switch {
+	case quantile != "":
+		w.quantiles[quantile] = v
+	case suffix == "_sum":
+		w.sum = &v
+	case suffix == "_count":
+		w.count = &v
+	}
+}
+
+// dataframe returns the dataframe with a row per summary and window.
+func (b *summaryBuilder) dataframe() Dataframe {
+	var (
+		labelNames = map[string]struct{}{}
+		quantiles  = map[string]struct{}{}
+		groups     []*summaryGroup
+	)
+	for _, h := range b.order {
+		g := b.groups[h]
+		if !b.parts[g.base].isSummary() {
+			continue
+		}
+		groups = append(groups, g)
+		for _, l := range g.labels {
+			if l.Name != labels.MetricName {
+				labelNames[l.Name] = struct{}{}
+			}
+		}
+		for _, w := range g.windows {
+			for q := range w.quantiles {
+				quantiles[q] = struct{}{}
+			}
+		}
+	}
+
+	schema := Schema{}
+	for _, n := range sortedKeys(labelNames) {
+		schema = append(schema, Column{Name: n, Type: TypeString})
+	}
+	schema = append(schema,
+		Column{Name: b.opts.Column, Type: TypeString},
+		Column{Name: "_sample_start", Type: TypeTime},
+		Column{Name: "_sample_end", Type: TypeTime},
+		Column{Name: b.opts.Column + "_sum", Type: TypeFloat},
+		Column{Name: b.opts.Column + "_count", Type: TypeFloat},
+	)
+	qs := sortedQuantiles(quantiles)
+	for _, q := range qs {
+		schema = append(schema, Column{Name: quantileColumn(q), Type: TypeFloat})
+	}
+
+	df := &rowsDataframe{schema: schema}
+	for _, g := range groups {
+		windows := make([]*summaryWindow, 0, len(g.windows))
+		for _, w := range g.windows {
+			windows = append(windows, w)
+		}
+		sort.Slice(windows, func(i, j int) bool { return windows[i].sampleStart.Before(windows[j].sampleStart) })
+
+		for _, w := range windows {
+			vals := map[string]interface{}{
+				b.opts.Column:   g.base,
+				"_sample_start": w.sampleStart,
+				"_sample_end":   w.sampleEnd,
+			}
+			for _, l := range g.labels {
+				if l.Name != labels.MetricName {
+					vals[l.Name] = l.Value
+				}
+			}
+			if w.sum != nil {
+				vals[b.opts.Column+"_sum"] = *w.sum
+			}
+			if w.count != nil {
+				vals[b.opts.Column+"_count"] = *w.count
+			}
+			for q, v := range w.quantiles {
+				vals[quantileColumn(q)] = v
+			}
+
+			row := make(Row, 0, len(schema))
+			for _, c := range schema {
+				row = append(row, vals[c.Name])
+			}
+			df.rows = append(df.rows, row)
+		}
+	}
+	return df
+}
+
+// normalizeQuantile returns the shortest representation of the numeric quantile, so that e.g. "0.5" and "0.50" are the
+// same quantile, written to a single column. Unparsable quantiles are returned as they are.
+func normalizeQuantile(q string) string {
+	f, err := strconv.ParseFloat(q, 64)
+	if err != nil {
+		return q
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// sortedQuantiles returns the quantile label values sorted numerically, unparsable ones last.
+func sortedQuantiles(m map[string]struct{}) []string {
+	qs := sortedKeys(m)
+	parse := func(q string) float64 {
+		f, err := strconv.ParseFloat(q, 64)
+		if err != nil {
+			return math.Inf(1)
+		}
+		return f
+	}
+	sort.SliceStable(qs, func(i, j int) bool { return parse(qs[i]) < parse(qs[j]) })
+	return qs
+}
+
+// quantileColumn returns the column of the quantile. Dots are replaced as they delimit nested columns in Parquet.
+func quantileColumn(q string) string {
+	return "_quantile_" + strings.ReplaceAll(q, ".", "_")
+}
@@ -0,0 +1,78 @@
+package dataframe
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestFromSeries_Summaries(t *testing.T) {
+	df, err := FromSeries(newTestSeriesSet(
+		testSeries{
+			lset:    labels.FromStrings("__name__", "rpc_seconds", "job", "a", "quantile", "0.99"),
+			samples: []sample{{t: 0, v: 0.5}, {t: 30000, v: 0.7}, {t: 90000, v: 0.6}},
+		},
+		testSeries{
+			lset:    labels.FromStrings("__name__", "rpc_seconds", "job", "a", "quantile", "0.5"),
+			samples: []sample{{t: 0, v: 0.1}, {t: 90000, v: 0.2}},
+		},
+		testSeries{lset: labels.FromStrings("__name__", "rpc_seconds_count", "job", "a"), samples: []sample{{t: 0, v: 10}, {t: 90000, v: 20}}},
+		testSeries{lset: labels.FromStrings("__name__", "rpc_seconds_sum", "job", "a"), samples: []sample{{t: 0, v: 2}, {t: 90000, v: 4}}},
+		// Summary without quantiles.
+		testSeries{lset: labels.FromStrings("__name__", "gc_seconds_count", "job", "b"), samples: []sample{{t: 0, v: 3}}},
+		testSeries{lset: labels.FromStrings("__name__", "gc_seconds_sum", "job", "b"), samples: []sample{{t: 0, v: 1}}},
+		// Histogram and plain series are skipped.
+		testSeries{lset: labels.FromStrings("__name__", "http_seconds_bucket", "le", "1"), samples: []sample{{t: 0, v: 1}}},
+		testSeries{lset: labels.FromStrings("__name__", "http_seconds_count"), samples: []sample{{t: 0, v: 1}}},
+		testSeries{lset: labels.FromStrings("__name__", "http_seconds_sum"), samples: []sample{{t: 0, v: 1}}},
+		testSeries{lset: labels.FromStrings("__name__", "up", "job", "a"), samples: []sample{{t: 0, v: 1}}},
+	), time.Minute, func(o *AggrsOptions) {
+		o.Count.Enabled = true
+		o.Summaries.Enabled = true
+	})
+	testutil.Ok(t, err)
+
+	testutil.Equals(t, Schema{
+		{Name: "job", Type: TypeString},
+		{Name: "_summary", Type: TypeString},
+		{Name: "_sample_start", Type: TypeTime},
+		{Name: "_sample_end", Type: TypeTime},
+		{Name: "_summary_sum", Type: TypeFloat},
+		{Name: "_summary_count", Type: TypeFloat},
+		{Name: "_quantile_0_5", Type: TypeFloat},
+		{Name: "_quantile_0_99", Type: TypeFloat},
+	}, df.Schema())
+
+	var got [][]interface{}
+	for i := df.RowsIterator(); i.Next(); {
+		row := i.At()
+		got = append(got, append(Row{row[0], row[1], timestamp.FromTime(row[2].(time.Time))}, row[4:]...))
+	}
+	testutil.Equals(t, [][]interface{}{
+		// The latest values of the window.
+		{"a", "rpc_seconds", int64(0), 2.0, 10.0, 0.1, 0.7},
+		{"a", "rpc_seconds", int64(60000), 4.0, 20.0, 0.2, 0.6},
+		{"b", "gc_seconds", int64(0), 1.0, 3.0, nil, nil},
+	}, got)
+}
+
+func TestFromSeries_SummaryQuantiles(t *testing.T) {
+	// The same quantiles written differently, e.g. by replicas of different client versions.
+	df, err := FromSeries(newTestSeriesSet(
+		testSeries{lset: labels.FromStrings("__name__", "rpc_seconds", "quantile", "0.50"), samples: []sample{{t: 0, v: 0.1}}},
+		testSeries{lset: labels.FromStrings("__name__", "rpc_seconds", "quantile", "0.5"), samples: []sample{{t: 0, v: 0.1}}},
+		testSeries{lset: labels.FromStrings("__name__", "rpc_seconds", "quantile", "1"), samples: []sample{{t: 0, v: 0.3}}},
+		testSeries{lset: labels.FromStrings("__name__", "rpc_seconds", "quantile", "1.0"), samples: []sample{{t: 0, v: 0.3}}},
+	), time.Minute, func(o *AggrsOptions) {
+		o.Count.Enabled = true
+		o.Summaries.Enabled = true
+	})
+	testutil.Ok(t, err)
+
+	s := df.Schema()
+	testutil.Equals(t, Schema{{Name: "_quantile_0_5", Type: TypeFloat}, {Name: "_quantile_1", Type: TypeFloat}}, s[len(s)-2:])
+	testutil.Equals(t, 1, countRows(df))
+}
@@ -0,0 +1,132 @@
+package dataframe
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// Columns of the tidy dataframe, followed by the dimension labels.
+const (
+	TidyTimestampColumn = "timestamp"
+	TidyMetricColumn    = "metric"
+	TidyValueColumn     = "value"
+)
+
+// TidyOptions configures the tidy dataframe.
+type TidyOptions struct {
+	// Metric is the string column of the metric name, e.g. __name__.
+	Metric string
+	// Value is the column of the aggregated value, e.g. _avg. Count columns are converted to floats.
+	Value string
+	// Dimensions are the labels kept as string columns, in order, null for the series without them.
+	Dimensions []string
+	// PackedLabels, if set, is the string column the other labels are packed into, as a JSON object of the values by
+	// label name, e.g. for the map columns of the downstream tables. The other labels are dropped otherwise.
+	PackedLabels string
+}
+
+func (o TidyOptions) validate() error {
+	seen := map[string]struct{}{TidyTimestampColumn: {}, TidyMetricColumn: {}, TidyValueColumn: {}}
+	columns := o.Dimensions
+	if o.PackedLabels != "" {
+		columns = append(columns[:len(columns):len(columns)], o.PackedLabels)
+	}
+	for _, c := range columns {
+		if _, ok := seen[c]; ok || c == "" {
+			return errors.Errorf("invalid or duplicate tidy column %q", c)
+		}
+		seen[c] = struct{}{}
+	}
+	return nil
+}
+
+// Tidy returns the dataframe in the tidy (long) shape, with the same columns regardless of the metrics: the timestamp
+// (the window start), metric and value columns, followed by the dimension labels and the packed labels, if any. Every
+// row of df gives a row. The labels are the string columns of df other than the metric one and the unit column of the
+// aggregations, so the labels prefixed by _ are kept.
+func Tidy(df Dataframe, opts TidyOptions) (Dataframe, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	schema := df.Schema()
+	start := schema.index("_sample_start", TypeTime)
+	if start < 0 {
+		return nil, errors.New("tidy: no _sample_start column, the dataframe is not aggregated")
+	}
+	metric := schema.index(opts.Metric, TypeString)
+	if metric < 0 {
+		return nil, errors.Errorf("tidy: no metric column %q", opts.Metric)
+	}
+	value := schema.index(opts.Value, TypeFloat)
+	if value < 0 {
+		value = schema.index(opts.Value, TypeUint)
+	}
+	if value < 0 {
+		return nil, errors.Errorf("tidy: no value column %q", opts.Value)
+	}
+
+	dims := make(map[string]int, len(opts.Dimensions))
+	for _, d := range opts.Dimensions {
+		dims[d] = -1
+	}
+	var (
+		packed []int
+		unit   = defaultSeriesAggrsOptions().Units.Column
+	)
+	for i, c := range schema {
+		if c.Type != TypeString || i == metric || c.Name == unit {
+			continue
+		}
+		if _, ok := dims[c.Name]; ok {
+			dims[c.Name] = i
+			continue
+		}
+		packed = append(packed, i)
+	}
+
+	out := Schema{
+		{Name: TidyTimestampColumn, Type: TypeTime},
+		{Name: TidyMetricColumn, Type: TypeString},
+		{Name: TidyValueColumn, Type: TypeFloat},
+	}
+	for _, d := range opts.Dimensions {
+		out = append(out, Column{Name: d, Type: TypeString})
+	}
+	if opts.PackedLabels != "" {
+		out = append(out, Column{Name: opts.PackedLabels, Type: TypeString})
+	}
+
+	ret := &rowsDataframe{schema: out}
+	for it := df.RowsIterator(); it.Next(); {
+		r := it.At()
+		v := r[value]
+		if u, ok := v.(uint64); ok {
+			v = float64(u)
+		}
+		row := make(Row, 0, len(out))
+		row = append(row, r[start], r[metric], v)
+		for _, d := range opts.Dimensions {
+			if i := dims[d]; i >= 0 {
+				row = append(row, r[i])
+				continue
+			}
+			row = append(row, nil)
+		}
+		if opts.PackedLabels != "" {
+			m := map[string]string{}
+			for _, i := range packed {
+				if s, ok := r[i].(string); ok {
+					m[schema[i].Name] = s
+				}
+			}
+			b, err := json.Marshal(m)
+			if err != nil {
+				return nil, errors.Wrap(err, "tidy: pack labels")
+			}
+			row = append(row, string(b))
+		}
+		ret.rows = append(ret.rows, row)
+	}
+	return ret, nil
+}
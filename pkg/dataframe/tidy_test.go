@@ -0,0 +1,59 @@
+package dataframe
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestTidy(t *testing.T) {
+	df, err := FromSeries(newTestSeriesSet(
+		testSeries{lset: labels.FromStrings("__name__", "up", "job", "a", "instance", "x", "_zone", "eu"), samples: []sample{{t: 0, v: 1}, {t: 60000, v: 0}}},
+		testSeries{lset: labels.FromStrings("__name__", "load", "job", "b"), samples: []sample{{t: 0, v: 2.5}}},
+	), time.Minute, func(o *AggrsOptions) {
+		o.Count.Enabled = true
+		o.Avg.Enabled = true
+		o.MetricName.Enabled = true
+		o.Units.Conversions = map[string]UnitConversion{"load": {Scale: 1, Unit: "cores"}}
+	})
+	testutil.Ok(t, err)
+
+	tidy, err := Tidy(df, TidyOptions{Metric: "__name__", Value: "_avg", Dimensions: []string{"job", "pod"}, PackedLabels: "labels"})
+	testutil.Ok(t, err)
+	testutil.Equals(t, Schema{
+		{Name: "timestamp", Type: TypeTime},
+		{Name: "metric", Type: TypeString},
+		{Name: "value", Type: TypeFloat},
+		{Name: "job", Type: TypeString},
+		{Name: "pod", Type: TypeString},
+		{Name: "labels", Type: TypeString},
+	}, tidy.Schema())
+
+	var rows []Row
+	for it := tidy.RowsIterator(); it.Next(); {
+		rows = append(rows, it.At())
+	}
+	// The labels prefixed by _ are kept, the unit column is not a label.
+	testutil.Equals(t, []Row{
+		{time.Unix(0, 0).UTC(), "up", 1.0, "a", nil, `{"_zone":"eu","instance":"x"}`},
+		{time.Unix(60, 0).UTC(), "up", 0.0, "a", nil, `{"_zone":"eu","instance":"x"}`},
+		{time.Unix(0, 0).UTC(), "load", 2.5, "b", nil, `{}`},
+	}, rows)
+
+	// Counts are converted to floats, the other labels are dropped without the packed column.
+	tidy, err = Tidy(df, TidyOptions{Metric: "__name__", Value: "_count"})
+	testutil.Ok(t, err)
+	testutil.Equals(t, 3, len(tidy.Schema()))
+	it := tidy.RowsIterator()
+	testutil.Assert(t, it.Next())
+	testutil.Equals(t, Row{time.Unix(0, 0).UTC(), "up", 1.0}, it.At())
+
+	_, err = Tidy(df, TidyOptions{Metric: "__name__", Value: "_sum"})
+	testutil.NotOk(t, err)
+	_, err = Tidy(df, TidyOptions{Metric: "__name__", Value: "_avg", Dimensions: []string{"value"}})
+	testutil.NotOk(t, err)
+	_, err = Tidy(df, TidyOptions{Metric: "__name__", Value: "_avg", Dimensions: []string{"job"}, PackedLabels: "job"})
+	testutil.NotOk(t, err)
+}
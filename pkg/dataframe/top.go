@@ -0,0 +1,143 @@
+package dataframe
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// TopBy defines the metric the series are ranked by to select the top series.
+type TopBy string
+
+const (
+	// TopByCount ranks the series by the total number of their samples, summing up the _count column.
+	TopByCount TopBy = "count"
+	// TopByMax ranks the series by their maximum value, the maximum of the _max column.
+	TopByMax TopBy = "max"
+	// TopByLast ranks the series by their last value, the _last column of their latest window with a value.
+	TopByLast TopBy = "last"
+)
+
+// Validate returns error if the ranking metric is not known.
+func (b TopBy) Validate() error {
+	switch b {
+	case "", TopByCount, TopByMax, TopByLast:
+		return nil
+	}
+	return errors.Errorf("unsupported top series ranking %q, expected count, max or last", b)
+}
+
+// column returns the column the series are ranked by, and its type.
+func (b TopBy) column() (string, Type) {
+	switch b {
+	case TopByMax:
+		return "_max", TypeFloat
+	case TopByLast:
+		return "_last", TypeFloat
+	}
+	return "_count", TypeUint
+}
+
+// TopSeries returns the dataframe with the rows of the n series ranked highest by the metric, the series ordered
+// by rank, highest first. Series ranking the same keep the order they were read in, series without any value (e.g.
+// only NaN values) rank lowest. The ranking needs all the series, so the top series cannot be streamed: they are
+// selected once the dataframe is complete. The rows of the dataframes created by FromSeries are held in memory
+// anyway, only the ranks of the series are computed on top, and the result stays splittable by SplitSeries. Other
+// dataframes are read into memory, their rows grouped into series by the values of the string (label) columns.
+// Returns error if the dataframe has no column to rank by, e.g. _last without the last aggregation.
+func TopSeries(df Dataframe, n int, by TopBy) (Dataframe, error) {
+	if n <= 0 {
+		return df, nil
+	}
+	name, t := by.column()
+	schema := df.Schema()
+	col := schema.index(name, t)
+	if col < 0 {
+		return nil, errors.Errorf("no %s column to rank the series by %s", name, by)
+	}
+	start := schema.index("_sample_start", TypeTime)
+
+	if sdf, ok := df.(*seriesDataframe); ok {
+		ranks := make([]float64, len(sdf.seriesOrder))
+		for i, h := range sdf.seriesOrder {
+			r := ranker{by: by}
+			for _, rec := range sdf.seriesRecordSets[h].Records {
+				st, _ := rec.Values["_sample_start"].(time.Time)
+				r.add(rec.Values[name], st)
+			}
+			ranks[i] = r.rank()
+		}
+		ret := &seriesDataframe{schema: sdf.schema, seriesRecordSets: map[uint64]*seriesRecordSet{}}
+		for _, i := range top(ranks, n) {
+			h := sdf.seriesOrder[i]
+			ret.seriesRecordSets[h] = sdf.seriesRecordSets[h]
+			ret.seriesOrder = append(ret.seriesOrder, h)
+		}
+		return ret, nil
+	}
+
+	groups := groupRows(df)
+	ranks := make([]float64, len(groups))
+	for i, g := range groups {
+		r := ranker{by: by}
+		for _, row := range g.rows {
+			var st time.Time
+			if start >= 0 {
+				st, _ = row[start].(time.Time)
+			}
+			r.add(row[col], st)
+		}
+		ranks[i] = r.rank()
+	}
+	topGroups := make([]*rowGroup, 0, n)
+	for _, i := range top(ranks, n) {
+		topGroups = append(topGroups, groups[i])
+	}
+	return groupsDataframe(schema, topGroups), nil
+}
+
+// ranker computes the rank of a series from the values of its rows.
+type ranker struct {
+	by     TopBy
+	value  float64
+	latest time.Time
+	ok     bool
+}
+
+// add accounts the value of the row of the window starting at the time. Missing and NaN values are skipped.
+func (r *ranker) add(v interface{}, start time.Time) {
+	switch v := v.(type) {
+	case uint64:
+		r.value += float64(v)
+		r.ok = true
+	case float64:
+		switch {
+		case math.IsNaN(v):
+		case !r.ok, r.by == TopByLast && !start.Before(r.latest), r.by == TopByMax && v > r.value:
+			r.value, r.latest, r.ok = v, start, true
+		}
+	}
+}
+
+// rank returns the rank of the series, -Inf if it has no value.
+func (r *ranker) rank() float64 {
+	if !r.ok {
+		return math.Inf(-1)
+	}
+	return r.value
+}
+
+// top returns the indexes of the n highest ranks, highest first, keeping the order of the same ranks.
+func top(ranks []float64, n int) []int {
+	idx := make([]int, len(ranks))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool { return ranks[idx[i]] > ranks[idx[j]] })
+	if len(idx) > n {
+		idx = idx[:n]
+	}
+	return idx
+}
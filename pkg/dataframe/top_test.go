@@ -0,0 +1,76 @@
+package dataframe
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestTopSeries(t *testing.T) {
+	set := func() *testSeriesSet {
+		return newTestSeriesSet(
+			testSeries{lset: labels.FromStrings("__name__", "up", "instance", "a"), samples: []sample{{t: 0, v: 1}, {t: 60000, v: 2}}},
+			testSeries{lset: labels.FromStrings("__name__", "up", "instance", "b"), samples: []sample{{t: 0, v: 3}, {t: 60000, v: 4}, {t: 120000, v: 5}}},
+			testSeries{lset: labels.FromStrings("__name__", "up", "instance", "c"), samples: []sample{{t: 0, v: 9}, {t: 60000, v: 0}}},
+		)
+	}
+	aggr := func(o *AggrsOptions) {
+		o.Count.Enabled = true
+		o.Max.Enabled = true
+		o.Last.Enabled = true
+	}
+	// instances returns the instances of the rows, once per series.
+	instances := func(df Dataframe) []string {
+		var ret []string
+		for i := df.RowsIterator(); i.Next(); {
+			if v := i.At()[0].(string); len(ret) == 0 || ret[len(ret)-1] != v {
+				ret = append(ret, v)
+			}
+		}
+		return ret
+	}
+	top := func(df Dataframe, n int, by TopBy) []string {
+		ret, err := TopSeries(df, n, by)
+		testutil.Ok(t, err)
+		return instances(ret)
+	}
+
+	df, err := FromSeries(set(), time.Minute, aggr)
+	testutil.Ok(t, err)
+	testutil.Equals(t, []string{"b", "a"}, top(df, 2, TopByCount))
+	testutil.Equals(t, []string{"c", "b"}, top(df, 2, TopByMax))
+	testutil.Equals(t, []string{"b", "a", "c"}, top(df, 5, TopByLast))
+	testutil.Equals(t, []string{"a", "b", "c"}, top(df, 0, TopByCount))
+
+	// The top series can be split, and the input dataframe is unchanged.
+	res, err := TopSeries(df, 1, TopByMax)
+	testutil.Ok(t, err)
+	split, ok := SplitSeries(res)
+	testutil.Assert(t, ok, "expected splittable dataframe")
+	testutil.Equals(t, 1, len(split))
+	testutil.Equals(t, "c", split[0].Labels.Get("instance"))
+	testutil.Equals(t, []string{"a", "b", "c"}, instances(df))
+
+	// Merged dataframes, e.g. of read windows, have the rows of a series in multiple dataframes.
+	first, err := FromSeries(newTestSeriesSet(
+		testSeries{lset: labels.FromStrings("__name__", "up", "instance", "a"), samples: []sample{{t: 0, v: 1}}},
+		testSeries{lset: labels.FromStrings("__name__", "up", "instance", "b"), samples: []sample{{t: 0, v: 3}}},
+	), time.Minute, aggr)
+	testutil.Ok(t, err)
+	second, err := FromSeries(newTestSeriesSet(
+		testSeries{lset: labels.FromStrings("__name__", "up", "instance", "a"), samples: []sample{{t: 60000, v: 2}, {t: 70000, v: 2}}},
+		testSeries{lset: labels.FromStrings("__name__", "up", "instance", "b"), samples: []sample{{t: 60000, v: 1}}},
+	), time.Minute, aggr)
+	testutil.Ok(t, err)
+	merged := Merge(first, second)
+	testutil.Equals(t, []string{"a", "b"}, top(merged, 2, TopByCount))
+	testutil.Equals(t, []string{"b"}, top(merged, 1, TopByMax))
+	testutil.Equals(t, []string{"a"}, top(merged, 1, TopByLast))
+
+	noLast, err := FromSeries(set(), time.Minute)
+	testutil.Ok(t, err)
+	_, err = TopSeries(noLast, 1, TopByLast)
+	testutil.NotOk(t, err)
+}
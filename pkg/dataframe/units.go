@@ -0,0 +1,79 @@
+package dataframe
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// UnitConversion scales the aggregated values of a series, e.g. from bytes to GiB.
+type UnitConversion struct {
+	// Scale multiplies the aggregated values.
+	Scale float64
+	// Unit of the converted values, stored in the units column.
+	Unit string
+}
+
+// UnitsOption defines the unit conversions applied to the aggregated values.
+type UnitsOption struct {
+	// Column to store the unit of the converted values at. The unit of the per-second deltas (rates and derivatives)
+	// is stored too, per the rate unit of the delta, e.g. bytes/s. Its base unit is the converted unit, or the unit in
+	// the metric name, e.g. bytes of the _bytes_total counters, or none, e.g. /s.
+	Column string
+	// Conversions by metric name. Values of metrics without conversion are kept as they are, with empty unit.
+	Conversions map[string]UnitConversion
+}
+
+type unit struct {
+	dimension string
+	factor    float64
+}
+
+// knownUnits are the units NewUnitConversion converts between, with the factor to the base unit of the dimension.
+var knownUnits = map[string]unit{
+	"bytes": {"bytes", 1},
+	"KB":    {"bytes", 1e3},
+	"MB":    {"bytes", 1e6},
+	"GB":    {"bytes", 1e9},
+	"TB":    {"bytes", 1e12},
+	"KiB":   {"bytes", 1 << 10},
+	"MiB":   {"bytes", 1 << 20},
+	"GiB":   {"bytes", 1 << 30},
+	"TiB":   {"bytes", 1 << 40},
+
+	"ns":      {"seconds", 1e-9},
+	"us":      {"seconds", 1e-6},
+	"ms":      {"seconds", 1e-3},
+	"seconds": {"seconds", 1},
+	"minutes": {"seconds", 60},
+	"hours":   {"seconds", 3600},
+	"days":    {"seconds", 86400},
+}
+
+// NewUnitConversion returns the conversion between known units of the same dimension, e.g. from bytes to GiB
+// or from seconds to ms.
+func NewUnitConversion(from, to string) (UnitConversion, error) {
+	f, ok := knownUnits[from]
+	if !ok {
+		return UnitConversion{}, errors.Errorf("unknown unit %q", from)
+	}
+	t, ok := knownUnits[to]
+	if !ok {
+		return UnitConversion{}, errors.Errorf("unknown unit %q", to)
+	}
+	if f.dimension != t.dimension {
+		return UnitConversion{}, errors.Errorf("cannot convert %s to %s", from, to)
+	}
+	return UnitConversion{Scale: f.factor / t.factor, Unit: to}, nil
+}
+
+// metricUnit returns the known unit in the name of the metric by the Prometheus naming conventions, e.g. bytes of
+// node_network_receive_bytes_total, or empty if there is none.
+func metricUnit(name string) string {
+	name = strings.TrimSuffix(name, "_total")
+	u := name[strings.LastIndex(name, "_")+1:]
+	if _, ok := knownUnits[u]; !ok {
+		return ""
+	}
+	return u
+}
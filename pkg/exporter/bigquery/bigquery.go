@@ -0,0 +1,368 @@
+package bigquery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"github.com/thanos-community/obslytics/pkg/dataframe"
+	"github.com/thanos-community/obslytics/pkg/exporter"
+	"github.com/thanos-community/obslytics/pkg/version"
+	bq "google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	"gopkg.in/yaml.v2"
+)
+
+// Compile-time check if BigQuery Writer implements exporter.Writer interface.
+var _ exporter.Writer = &Writer{}
+
+// LabelsFormat defines how the labels of a row are stored in the table.
+type LabelsFormat string
+
+const (
+	// LabelsRepeated stores labels in a REPEATED RECORD column of name and value pairs.
+	LabelsRepeated LabelsFormat = "repeated"
+	// LabelsJSON stores labels as a JSON object in a STRING column.
+	LabelsJSON LabelsFormat = "json"
+)
+
+// BigQuery limits the streaming insert requests to 50000 rows and 10MB.
+const (
+	maxRowsPerRequest  = 50000
+	maxBytesPerRequest = 10 << 20
+)
+
+// Config contains the options of the BigQuery streaming insert client.
+type Config struct {
+	Project string `yaml:"project"`
+	Dataset string `yaml:"dataset"`
+	Table   string `yaml:"table"`
+	// CredentialsFile is the service account key file. Application default credentials are used if not set.
+	CredentialsFile string `yaml:"credentials_file"`
+	// CreateTable creates the table with the schema of the dataframe if it does not exist.
+	CreateTable bool `yaml:"create_table"`
+	// LabelsFormat is either "repeated" (default) or "json". Label columns of the dataframe are stored in a single
+	// labels column, so that the table schema does not depend on the label names of the exported series.
+	LabelsFormat LabelsFormat `yaml:"labels_format"`
+	// MaxRowsPerRequest is the maximum number of rows in a single insert request. Requests are also kept under the
+	// 10MB limit of BigQuery.
+	MaxRowsPerRequest int `yaml:"max_rows_per_request"`
+	// Timeout is the timeout of a single request.
+	Timeout model.Duration `yaml:"timeout"`
+	// MaxRetries is the number of retries of requests failing with 5xx or 429 (Too Many Requests) status, or
+	// rows failing with transient errors. Rows have insert IDs, so that retries do not duplicate them.
+	MaxRetries int            `yaml:"max_retries"`
+	MinBackoff model.Duration `yaml:"min_backoff"`
+	MaxBackoff model.Duration `yaml:"max_backoff"`
+}
+
+// ParseConfig parses the YAML BigQuery configuration.
+func ParseConfig(conf []byte) (Config, error) {
+	config := Config{
+		LabelsFormat:      LabelsRepeated,
+		MaxRowsPerRequest: 500,
+		Timeout:           model.Duration(30 * time.Second),
+		MaxRetries:        10,
+		MinBackoff:        model.Duration(100 * time.Millisecond),
+		MaxBackoff:        model.Duration(10 * time.Second),
+	}
+	if err := yaml.UnmarshalStrict(conf, &config); err != nil {
+		return Config{}, err
+	}
+	if config.Project == "" || config.Dataset == "" || config.Table == "" {
+		return Config{}, errors.New("project, dataset and table are required")
+	}
+	if config.LabelsFormat != LabelsRepeated && config.LabelsFormat != LabelsJSON {
+		return Config{}, errors.Errorf("unsupported labels_format %q, expected repeated or json", config.LabelsFormat)
+	}
+	if config.MaxRowsPerRequest <= 0 || config.MaxRowsPerRequest > maxRowsPerRequest {
+		return Config{}, errors.Errorf("max_rows_per_request has to be between 1 and %d", maxRowsPerRequest)
+	}
+	if config.MaxRetries < 0 {
+		return Config{}, errors.New("max_retries cannot be negative")
+	}
+	return config, nil
+}
+
+// Writer streams the dataframe rows into a BigQuery table. Label columns are stored in a single labels column,
+// time columns as TIMESTAMP, float columns as FLOAT and uint columns as INTEGER. Uint values above the INTEGER
+// range (e.g. series IDs) wrap around to negative values, keeping them distinct.
+type Writer struct {
+	logger log.Logger
+	conf   Config
+	opts   []option.ClientOption
+
+	maxBytes int64
+	written  int64
+}
+
+// NewWriter returns Writer for the given configuration. If maxBytes is positive, Export stops with
+// exporter.ErrOutputLimitReached once the sent rows reach maxBytes. Client options are passed to the BigQuery
+// client on top of the configured credentials.
+func NewWriter(logger log.Logger, conf Config, maxBytes int64, opts ...option.ClientOption) *Writer {
+	return &Writer{logger: logger, conf: conf, maxBytes: maxBytes, opts: opts}
+}
+
+// Export inserts the dataframe rows in batches of at most MaxRowsPerRequest rows. On error, part of the rows
+// might have been inserted already.
+func (w *Writer) Export(ctx context.Context, df dataframe.Dataframe) error {
+	opts := append([]option.ClientOption{option.WithUserAgent(path.Join("obslytics", version.Version))}, w.opts...)
+	if w.conf.CredentialsFile != "" {
+		opts = append(opts, option.WithCredentialsFile(w.conf.CredentialsFile))
+	}
+	svc, err := bq.NewService(ctx, opts...)
+	if err != nil {
+		return errors.Wrap(err, "create BigQuery client")
+	}
+
+	s := df.Schema()
+	if w.conf.CreateTable {
+		if err := w.ensureTable(ctx, svc, s); err != nil {
+			return err
+		}
+	}
+
+	var (
+		rows  []*bq.TableDataInsertAllRequestRows
+		bytes int64
+	)
+	flush := func() error {
+		if len(rows) == 0 {
+			return nil
+		}
+		if w.maxBytes > 0 && w.written+bytes > w.maxBytes {
+			return errors.Wrapf(exporter.ErrOutputLimitReached, "stopped after %d bytes", w.written)
+		}
+		if err := w.insert(ctx, svc, rows); err != nil {
+			return err
+		}
+		w.written += bytes
+		rows, bytes = rows[:0], 0
+		return nil
+	}
+
+	i := df.RowsIterator()
+	for i.Next() {
+		row, err := w.row(s, i.At())
+		if err != nil {
+			return err
+		}
+		b, err := json.Marshal(row)
+		if err != nil {
+			return errors.Wrap(err, "encode row")
+		}
+		if bytes+int64(len(b)) > maxBytesPerRequest {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		rows = append(rows, &bq.TableDataInsertAllRequestRows{InsertId: strconv.FormatUint(xxhash.Sum64(b), 16), Json: row})
+		bytes += int64(len(b))
+		if len(rows) >= w.conf.MaxRowsPerRequest {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
+
+// Outputs returns the table with the size of the rows inserted so far.
+func (w *Writer) Outputs() []exporter.Output {
+	return []exporter.Output{{Path: fmt.Sprintf("%s.%s.%s", w.conf.Project, w.conf.Dataset, w.conf.Table), Bytes: w.written}}
+}
+
+// ensureTable creates the table with the schema of the dataframe, if it does not exist.
+func (w *Writer) ensureTable(ctx context.Context, svc *bq.Service, s dataframe.Schema) error {
+	_, err := svc.Tables.Get(w.conf.Project, w.conf.Dataset, w.conf.Table).Context(ctx).Do()
+	if err == nil {
+		return nil
+	}
+	if gerr, ok := err.(*googleapi.Error); !ok || gerr.Code != http.StatusNotFound {
+		return errors.Wrap(err, "get table")
+	}
+
+	level.Info(w.logger).Log("msg", "creating table", "table", w.conf.Table)
+	_, err = svc.Tables.Insert(w.conf.Project, w.conf.Dataset, &bq.Table{
+		TableReference: &bq.TableReference{ProjectId: w.conf.Project, DatasetId: w.conf.Dataset, TableId: w.conf.Table},
+		Schema:         w.tableSchema(s),
+	}).Context(ctx).Do()
+	// The table might have been created concurrently, e.g. by another export into the same table.
+	if gerr, ok := err.(*googleapi.Error); ok && gerr.Code == http.StatusConflict {
+		return nil
+	}
+	return errors.Wrap(err, "create table")
+}
+
+// tableSchema returns the table schema for the dataframe schema.
+func (w *Writer) tableSchema(s dataframe.Schema) *bq.TableSchema {
+	labelsField := &bq.TableFieldSchema{Name: "labels", Type: "STRING"}
+	if w.conf.LabelsFormat == LabelsRepeated {
+		labelsField = &bq.TableFieldSchema{
+			Name: "labels",
+			Type: "RECORD",
+			Mode: "REPEATED",
+			Fields: []*bq.TableFieldSchema{
+				{Name: "name", Type: "STRING", Mode: "REQUIRED"},
+				{Name: "value", Type: "STRING", Mode: "REQUIRED"},
+			},
+		}
+	}
+
+	fields := []*bq.TableFieldSchema{labelsField}
+	for _, c := range s {
+		switch c.Type {
+		case dataframe.TypeTime:
+			fields = append(fields, &bq.TableFieldSchema{Name: c.Name, Type: "TIMESTAMP"})
+		case dataframe.TypeFloat:
+			fields = append(fields, &bq.TableFieldSchema{Name: c.Name, Type: "FLOAT"})
+		case dataframe.TypeUint:
+			fields = append(fields, &bq.TableFieldSchema{Name: c.Name, Type: "INTEGER"})
+		}
+	}
+	return &bq.TableSchema{Fields: fields}
+}
+
+type label struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// row returns the JSON representation of the dataframe row.
+func (w *Writer) row(s dataframe.Schema, r dataframe.Row) (map[string]bq.JsonValue, error) {
+	var (
+		ret = make(map[string]bq.JsonValue, len(s))
+		ls  []label
+	)
+	for i, c := range s {
+		if r[i] == nil {
+			continue
+		}
+		switch c.Type {
+		case dataframe.TypeString:
+			if v := r[i].(string); v != "" {
+				ls = append(ls, label{Name: c.Name, Value: v})
+			}
+		case dataframe.TypeTime:
+			ret[c.Name] = r[i].(time.Time).UTC().Format(time.RFC3339Nano)
+		case dataframe.TypeFloat:
+			ret[c.Name] = floatValue(r[i].(float64))
+		case dataframe.TypeUint:
+			// INTEGER values are sent as strings, as JSON numbers lose precision above 2^53.
+			ret[c.Name] = strconv.FormatInt(int64(r[i].(uint64)), 10)
+		default:
+			return nil, errors.Errorf("unsupported column type %v of column %s", c.Type, c.Name)
+		}
+	}
+
+	if w.conf.LabelsFormat == LabelsRepeated {
+		if ls == nil {
+			ls = []label{}
+		}
+		ret["labels"] = ls
+		return ret, nil
+	}
+	m := make(map[string]string, len(ls))
+	for _, l := range ls {
+		m[l.Name] = l.Value
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, errors.Wrap(err, "encode labels")
+	}
+	ret["labels"] = string(b)
+	return ret, nil
+}
+
+// floatValue returns the JSON value of the float. BigQuery accepts NaN and infinite values as strings.
+func floatValue(v float64) bq.JsonValue {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "Infinity"
+	case math.IsInf(v, -1):
+		return "-Infinity"
+	}
+	return v
+}
+
+// transientReasons are the row error reasons worth retrying.
+var transientReasons = map[string]bool{
+	"backendError":  true,
+	"internalError": true,
+	"timeout":       true,
+}
+
+// insert inserts the rows, retrying failed requests and rows failed with transient errors with backoff.
+func (w *Writer) insert(ctx context.Context, svc *bq.Service, rows []*bq.TableDataInsertAllRequestRows) error {
+	backoff := time.Duration(w.conf.MinBackoff)
+	for try := 0; ; try++ {
+		failed, err := w.insertOnce(ctx, svc, rows)
+		if err == nil {
+			return nil
+		}
+		if failed == nil || try >= w.conf.MaxRetries {
+			return err
+		}
+		rows = failed
+
+		level.Warn(w.logger).Log("msg", "BigQuery insert failed, retrying", "err", err, "rows", len(rows), "backoff", backoff)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > time.Duration(w.conf.MaxBackoff) {
+			backoff = time.Duration(w.conf.MaxBackoff)
+		}
+	}
+}
+
+// insertOnce sends a single insert request. On transient failure, it returns the rows to retry with the error.
+func (w *Writer) insertOnce(parent context.Context, svc *bq.Service, rows []*bq.TableDataInsertAllRequestRows) ([]*bq.TableDataInsertAllRequestRows, error) {
+	ctx, cancel := context.WithTimeout(parent, time.Duration(w.conf.Timeout))
+	defer cancel()
+
+	resp, err := svc.Tabledata.InsertAll(w.conf.Project, w.conf.Dataset, w.conf.Table, &bq.TableDataInsertAllRequest{Rows: rows}).Context(ctx).Do()
+	if err != nil {
+		if gerr, ok := err.(*googleapi.Error); ok {
+			if gerr.Code == http.StatusTooManyRequests || gerr.Code/100 == 5 {
+				return rows, errors.Wrap(err, "insert rows")
+			}
+			return nil, errors.Wrap(err, "insert rows")
+		}
+		// Network errors and the timeouts of the request are worth retrying, unless the export is canceled.
+		if parent.Err() != nil {
+			return nil, errors.Wrap(err, "insert rows")
+		}
+		return rows, errors.Wrap(err, "insert rows")
+	}
+	if len(resp.InsertErrors) == 0 {
+		return nil, nil
+	}
+
+	failed := make([]*bq.TableDataInsertAllRequestRows, 0, len(resp.InsertErrors))
+	for _, ie := range resp.InsertErrors {
+		for _, e := range ie.Errors {
+			if !transientReasons[e.Reason] {
+				return nil, errors.Errorf("insert row %d: %s: %s", ie.Index, e.Reason, e.Message)
+			}
+		}
+		failed = append(failed, rows[ie.Index])
+	}
+	return failed, errors.Errorf("%d of %d rows failed with transient errors", len(failed), len(rows))
+}
@@ -0,0 +1,210 @@
+package bigquery
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/thanos-community/obslytics/pkg/dataframe"
+	"github.com/thanos-community/obslytics/pkg/exporter/memory"
+	"github.com/thanos-io/thanos/pkg/testutil"
+	bq "google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/option"
+)
+
+var testSchema = dataframe.Schema{
+	{Name: "instance", Type: dataframe.TypeString},
+	{Name: "job", Type: dataframe.TypeString},
+	{Name: "_series_id", Type: dataframe.TypeUint},
+	{Name: "_sample_start", Type: dataframe.TypeTime},
+	{Name: "_count", Type: dataframe.TypeUint},
+	{Name: "_max", Type: dataframe.TypeFloat},
+}
+
+func TestParseConfig(t *testing.T) {
+	conf, err := ParseConfig([]byte("project: p\ndataset: d\ntable: t\n"))
+	testutil.Ok(t, err)
+	testutil.Equals(t, 500, conf.MaxRowsPerRequest)
+	testutil.Equals(t, LabelsRepeated, conf.LabelsFormat)
+
+	for _, c := range []string{
+		"project: p\ndataset: d\n",
+		"project: p\ndataset: d\ntable: t\nlabels_format: xml\n",
+		"project: p\ndataset: d\ntable: t\nmax_rows_per_request: 100000\n",
+		"project: p\ndataset: d\ntable: t\nunknown: true\n",
+	} {
+		_, err := ParseConfig([]byte(c))
+		testutil.NotOk(t, err, c)
+	}
+}
+
+func TestWriter_Export(t *testing.T) {
+	var (
+		mtx     sync.Mutex
+		created *bq.Table
+		inserts int
+		rows    []map[string]interface{}
+		ids     = map[string]struct{}{}
+	)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/bigquery/v2/projects/p/datasets/d/tables/t", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error": {"code": 404, "message": "not found"}}`, http.StatusNotFound)
+	})
+	mux.HandleFunc("/bigquery/v2/projects/p/datasets/d/tables", func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		defer mtx.Unlock()
+
+		created = &bq.Table{}
+		testutil.Ok(t, json.NewDecoder(r.Body).Decode(created))
+		testutil.Ok(t, json.NewEncoder(w).Encode(created))
+	})
+	mux.HandleFunc("/bigquery/v2/projects/p/datasets/d/tables/t/insertAll", func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		defer mtx.Unlock()
+
+		var req struct {
+			Rows []struct {
+				InsertID string                 `json:"insertId"`
+				JSON     map[string]interface{} `json:"json"`
+			} `json:"rows"`
+		}
+		testutil.Ok(t, json.NewDecoder(r.Body).Decode(&req))
+		inserts++
+
+		resp := bq.TableDataInsertAllResponse{}
+		for i, row := range req.Rows {
+			// The first row of the first request fails with a transient error.
+			if inserts == 1 && i == 0 {
+				resp.InsertErrors = append(resp.InsertErrors, &bq.TableDataInsertAllResponseInsertErrors{
+					Index:  int64(i),
+					Errors: []*bq.ErrorProto{{Reason: "backendError"}},
+				})
+				continue
+			}
+			ids[row.InsertID] = struct{}{}
+			rows = append(rows, row.JSON)
+		}
+		testutil.Ok(t, json.NewEncoder(w).Encode(resp))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	conf, err := ParseConfig([]byte("project: p\ndataset: d\ntable: t\ncreate_table: true\nmax_rows_per_request: 2\nmin_backoff: 1ms\n"))
+	testutil.Ok(t, err)
+	w := NewWriter(log.NewNopLogger(), conf, 0, option.WithEndpoint(srv.URL+"/bigquery/v2/"), option.WithoutAuthentication())
+
+	start := time.Unix(60, 0)
+	testutil.Ok(t, w.Export(context.Background(), memory.Frame{Columns: testSchema, Rows: []dataframe.Row{
+		{"a", "node", uint64(math.MaxUint64), start, uint64(2), 1.5},
+		{nil, "node", uint64(1), start, uint64(1), math.NaN()},
+		{"c", "node", uint64(2), start, uint64(3), 2.0},
+	}}))
+
+	testutil.Equals(t, 3, inserts)
+	testutil.Equals(t, 3, len(ids))
+	testutil.Equals(t, []string{"labels", "_series_id", "_sample_start", "_count", "_max"}, fieldNames(created.Schema.Fields))
+	testutil.Equals(t, "REPEATED", created.Schema.Fields[0].Mode)
+
+	// The retried row comes after the rest of its batch.
+	testutil.Equals(t, []map[string]interface{}{
+		{
+			"labels":        []interface{}{map[string]interface{}{"name": "job", "value": "node"}},
+			"_series_id":    "1",
+			"_sample_start": "1970-01-01T00:01:00Z",
+			"_count":        "1",
+			"_max":          "NaN",
+		},
+		{
+			"labels": []interface{}{
+				map[string]interface{}{"name": "instance", "value": "a"},
+				map[string]interface{}{"name": "job", "value": "node"},
+			},
+			"_series_id":    "-1",
+			"_sample_start": "1970-01-01T00:01:00Z",
+			"_count":        "2",
+			"_max":          1.5,
+		},
+		{
+			"labels": []interface{}{
+				map[string]interface{}{"name": "instance", "value": "c"},
+				map[string]interface{}{"name": "job", "value": "node"},
+			},
+			"_series_id":    "2",
+			"_sample_start": "1970-01-01T00:01:00Z",
+			"_count":        "3",
+			"_max":          2.0,
+		},
+	}, rows)
+	testutil.Equals(t, "p.d.t", w.Outputs()[0].Path)
+	testutil.Assert(t, w.Outputs()[0].Bytes > 0)
+}
+
+func TestWriter_Export_LabelsJSON(t *testing.T) {
+	conf, err := ParseConfig([]byte("project: p\ndataset: d\ntable: t\nlabels_format: json\n"))
+	testutil.Ok(t, err)
+	w := NewWriter(log.NewNopLogger(), conf, 0)
+
+	row, err := w.row(testSchema, dataframe.Row{"a", "node", uint64(1), time.Unix(0, 0), uint64(1), 1.0})
+	testutil.Ok(t, err)
+	testutil.Equals(t, `{"instance":"a","job":"node"}`, row["labels"])
+	testutil.Equals(t, "STRING", w.tableSchema(testSchema).Fields[0].Type)
+}
+
+func TestWriter_Export_PermanentError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		testutil.Ok(t, json.NewEncoder(w).Encode(bq.TableDataInsertAllResponse{InsertErrors: []*bq.TableDataInsertAllResponseInsertErrors{
+			{Index: 0, Errors: []*bq.ErrorProto{{Reason: "invalid", Message: "no such field"}}},
+		}}))
+	}))
+	defer srv.Close()
+
+	conf, err := ParseConfig([]byte("project: p\ndataset: d\ntable: t\n"))
+	testutil.Ok(t, err)
+	w := NewWriter(log.NewNopLogger(), conf, 0, option.WithEndpoint(srv.URL+"/"), option.WithoutAuthentication())
+	err = w.Export(context.Background(), memory.Frame{Columns: testSchema, Rows: []dataframe.Row{{"a", "node", uint64(1), time.Unix(0, 0), uint64(1), 1.0}}})
+	testutil.NotOk(t, err)
+	testutil.Equals(t, "insert row 0: invalid: no such field", err.Error())
+}
+
+func TestWriter_Export_Timeout(t *testing.T) {
+	var (
+		mtx     sync.Mutex
+		inserts int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		inserts++
+		first := inserts == 1
+		mtx.Unlock()
+		if first {
+			// The first insert hangs past the request timeout.
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+			return
+		}
+		testutil.Ok(t, json.NewEncoder(w).Encode(bq.TableDataInsertAllResponse{}))
+	}))
+	defer srv.Close()
+
+	conf, err := ParseConfig([]byte("project: p\ndataset: d\ntable: t\ntimeout: 50ms\nmin_backoff: 1ms\n"))
+	testutil.Ok(t, err)
+	w := NewWriter(log.NewNopLogger(), conf, 0, option.WithEndpoint(srv.URL+"/"), option.WithoutAuthentication())
+	testutil.Ok(t, w.Export(context.Background(), memory.Frame{Columns: testSchema, Rows: []dataframe.Row{{"a", "node", uint64(1), time.Unix(0, 0), uint64(1), 1.0}}}))
+	testutil.Equals(t, 2, inserts)
+}
+
+func fieldNames(fs []*bq.TableFieldSchema) []string {
+	ret := make([]string, 0, len(fs))
+	for _, f := range fs {
+		ret = append(ret, f.Name)
+	}
+	return ret
+}
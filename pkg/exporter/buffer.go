@@ -0,0 +1,121 @@
+package exporter
+
+import (
+	"bufio"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+)
+
+// defaultWriteBufferSize is the size of the buffer of the encoded output, if not configured.
+const defaultWriteBufferSize = 64 * 1024
+
+// BufferConfig configures buffering the encoded output of the file outputs, so that the encoders writing small
+// pieces (e.g. a line per row) do not pay a write to the temporary file or a handoff to the upload for every piece.
+type BufferConfig struct {
+	// Size is the size of the buffer in bytes, 64KiB by default.
+	Size int `yaml:"size"`
+	// FlushInterval, if set, flushes the buffered output at least this often, e.g. to keep the upload of a slowly
+	// encoded file progressing. By default, the buffer is flushed once full and at the end of every file.
+	FlushInterval model.Duration `yaml:"flush_interval"`
+	// FlushOnSeries flushes the buffered output once a series is written whole, so that the readers tailing the output
+	// (e.g. a file of the filesystem storage) see the series complete as soon as possible. Series larger than the buffer
+	// are flushed also while written. Supported by the line-based PROMTEXT output only, which flushes once the label
+	// set of the written lines changes. PARQUET and HDF5 files are readable only once finalized, the other outputs are
+	// not buffered. The files are not uploaded until encoded whole when retry is enabled.
+	FlushOnSeries bool `yaml:"flush_on_series"`
+}
+
+// Validate returns error if the options are out of range.
+func (c BufferConfig) Validate() error {
+	switch {
+	case c.Size < 0:
+		return errors.New("write_buffer size cannot be negative")
+	case c.FlushInterval < 0:
+		return errors.New("write_buffer flush_interval cannot be negative")
+	}
+	return nil
+}
+
+// EndSeries marks the end of the series written by the encoder into the writer, flushing the buffered output if it's
+// configured to flush on the series boundaries. The encoders of the formats readable while written call it after every
+// series complete in the output.
+func EndSeries(w io.Writer) error {
+	if s, ok := w.(interface{ EndSeries() error }); ok {
+		return s.EndSeries()
+	}
+	return nil
+}
+
+// bufferedWriter buffers the writes into the underlying writer, flushing them periodically if configured.
+type bufferedWriter struct {
+	mtx sync.Mutex
+	w   *bufio.Writer
+
+	flushOnSeries bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newBufferedWriter(w io.Writer, conf BufferConfig) *bufferedWriter {
+	size := conf.Size
+	if size == 0 {
+		size = defaultWriteBufferSize
+	}
+	b := &bufferedWriter{w: bufio.NewWriterSize(w, size), flushOnSeries: conf.FlushOnSeries}
+	if conf.FlushInterval > 0 {
+		b.stop, b.done = make(chan struct{}), make(chan struct{})
+		go b.flushEvery(time.Duration(conf.FlushInterval))
+	}
+	return b
+}
+
+func (b *bufferedWriter) Write(p []byte) (int, error) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.w.Write(p)
+}
+
+// EndSeries flushes the buffer if configured to flush on the series boundaries.
+func (b *bufferedWriter) EndSeries() error {
+	if !b.flushOnSeries {
+		return nil
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.w.Flush()
+}
+
+// flushEvery flushes the buffer every interval until stopped. The write errors are kept by the buffer and returned by
+// the following writes and the final flush.
+func (b *bufferedWriter) flushEvery(interval time.Duration) {
+	defer close(b.done)
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-t.C:
+			b.mtx.Lock()
+			_ = b.w.Flush()
+			b.mtx.Unlock()
+		}
+	}
+}
+
+// Close stops the periodic flushes and flushes the rest of the output. The underlying writer is not closed.
+func (b *bufferedWriter) Close() error {
+	if b.stop != nil {
+		close(b.stop)
+		<-b.done
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.w.Flush()
+}
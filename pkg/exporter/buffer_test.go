@@ -0,0 +1,143 @@
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"github.com/thanos-community/obslytics/pkg/dataframe"
+	"github.com/thanos-io/thanos/pkg/objstore"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+// syncBuffer is a bytes.Buffer safe for concurrent use.
+type syncBuffer struct {
+	mtx sync.Mutex
+	b   bytes.Buffer
+}
+
+func (s *syncBuffer) Write(p []byte) (int, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.b.Write(p)
+}
+
+func (s *syncBuffer) String() string {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.b.String()
+}
+
+func TestBufferedWriter(t *testing.T) {
+	var dst syncBuffer
+	w := newBufferedWriter(&dst, BufferConfig{})
+	_, err := io.WriteString(w, "aaa\n")
+	testutil.Ok(t, err)
+	testutil.Equals(t, "", dst.String())
+	testutil.Ok(t, w.Close())
+	testutil.Equals(t, "aaa\n", dst.String())
+
+	// The periodic flushes write the buffered output before the buffer fills up.
+	dst = syncBuffer{}
+	w = newBufferedWriter(&dst, BufferConfig{FlushInterval: model.Duration(time.Millisecond)})
+	_, err = io.WriteString(w, "bbb\n")
+	testutil.Ok(t, err)
+	for deadline := time.Now().Add(5 * time.Second); dst.String() == "" && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	testutil.Equals(t, "bbb\n", dst.String())
+	testutil.Ok(t, w.Close())
+
+	testutil.NotOk(t, BufferConfig{Size: -1}.Validate())
+	testutil.NotOk(t, BufferConfig{FlushInterval: model.Duration(-time.Second)}.Validate())
+}
+
+func TestEndSeries(t *testing.T) {
+	var dst syncBuffer
+	w := newBufferedWriter(&dst, BufferConfig{})
+	_, err := io.WriteString(w, "aaa\n")
+	testutil.Ok(t, err)
+	testutil.Ok(t, EndSeries(&countingWriter{w: w}))
+	testutil.Equals(t, "", dst.String())
+
+	// The series written whole are flushed, through the counting of the written bytes too.
+	w = newBufferedWriter(&dst, BufferConfig{FlushOnSeries: true})
+	_, err = io.WriteString(w, "bbb\n")
+	testutil.Ok(t, err)
+	testutil.Ok(t, EndSeries(&countingWriter{w: w}))
+	testutil.Equals(t, "bbb\n", dst.String())
+	testutil.Ok(t, w.Close())
+
+	// Writers other than the buffer are written unbuffered.
+	testutil.Ok(t, EndSeries(&dst))
+}
+
+// failingEncoder writes the rows as lines and fails at the end.
+type failingEncoder struct{}
+
+func (failingEncoder) Encode(w io.Writer, df dataframe.Dataframe) error {
+	if err := (lineEncoder{}).Encode(w, df); err != nil {
+		return err
+	}
+	return errors.New("interrupted")
+}
+
+func TestExporter_WriteBuffer(t *testing.T) {
+	ctx := context.Background()
+	df := testDataframe{{"aaa"}, {"bbb"}}
+
+	// The output encoded until the failure is flushed, as of interrupted exports.
+	bkt := objstore.NewInMemBucket()
+	testutil.NotOk(t, New(failingEncoder{}, "out.txt", bkt, WithWriteBuffer(BufferConfig{Size: 1024})).Export(ctx, df))
+	r, err := bkt.Get(ctx, "out.txt")
+	testutil.Ok(t, err)
+	b, err := ioutil.ReadAll(r)
+	testutil.Ok(t, err)
+	testutil.Equals(t, "aaa\nbbb\n", string(b))
+}
+
+// BenchmarkBufferedWriter measures writing the lines of the rows into a file, without and with buffers of the sizes.
+func BenchmarkBufferedWriter(b *testing.B) {
+	df := make(testDataframe, 0, 10000)
+	for i := 0; i < cap(df); i++ {
+		df = append(df, dataframe.Row{fmt.Sprintf(`up{instance="host-%d:9100",job="node"} 1 1600000000000`, i)})
+	}
+	var size int64
+	for _, r := range df {
+		size += int64(len(r[0].(string)) + 1)
+	}
+
+	for _, bufSize := range []int{0, 4096, defaultWriteBufferSize} {
+		b.Run(fmt.Sprintf("buffer=%d", bufSize), func(b *testing.B) {
+			f, err := ioutil.TempFile("", "obslytics-bench")
+			testutil.Ok(b, err)
+			defer func() {
+				_ = f.Close()
+				_ = os.Remove(f.Name())
+			}()
+
+			b.SetBytes(size)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				_, err := f.Seek(0, io.SeekStart)
+				testutil.Ok(b, err)
+				if bufSize == 0 {
+					testutil.Ok(b, (lineEncoder{}).Encode(f, df))
+					continue
+				}
+				w := newBufferedWriter(f, BufferConfig{Size: bufSize})
+				testutil.Ok(b, (lineEncoder{}).Encode(w, df))
+				testutil.Ok(b, w.Close())
+			}
+		})
+	}
+}
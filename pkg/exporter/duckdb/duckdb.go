@@ -0,0 +1,198 @@
+package duckdb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/thanos-community/obslytics/pkg/dataframe"
+	"github.com/thanos-community/obslytics/pkg/exporter"
+	"github.com/thanos-community/obslytics/pkg/exporter/parquet"
+	"gopkg.in/yaml.v2"
+)
+
+// Compile-time check if DuckDB Writer implements exporter.Writer interface.
+var _ exporter.Writer = &Writer{}
+
+// Config contains the options of the DuckDB output.
+type Config struct {
+	// Binary is the DuckDB CLI executable loading the rows, "duckdb" looked up in PATH by default.
+	Binary string `yaml:"binary"`
+	// Table is the table the rows are written into, "samples" by default.
+	Table string `yaml:"table"`
+	// Append inserts the rows into the existing table (created if missing), same as the append if_exists policy of
+	// the output.
+	Append bool `yaml:"append"`
+	// Indexes are the columns indexed, each by an index of its own, e.g. the labels the queries filter by.
+	Indexes []string `yaml:"indexes"`
+}
+
+// ParseConfig parses the YAML DuckDB configuration.
+func ParseConfig(conf []byte) (Config, error) {
+	config := Config{Binary: "duckdb", Table: "samples"}
+	if err := yaml.UnmarshalStrict(conf, &config); err != nil {
+		return Config{}, err
+	}
+	if config.Binary == "" || config.Table == "" {
+		return Config{}, errors.New("binary and table cannot be empty")
+	}
+	for _, c := range config.Indexes {
+		if c == "" {
+			return Config{}, errors.New("indexes cannot contain empty column names")
+		}
+	}
+	return config, nil
+}
+
+// Writer writes the dataframe into a table of a DuckDB database file, with a column per dataframe column. The rows
+// are staged as a Parquet file next to the database, loaded by the DuckDB CLI in a single transaction, so that a
+// failed load leaves the database unchanged. The table existing before the first export of the writer is handled by
+// the exists policy: the export fails (default), replaces the table, appends to it or is skipped. The following
+// exports of the writer append to the table. Inserting by column name requires DuckDB 0.8 or newer.
+type Writer struct {
+	logger log.Logger
+	conf   Config
+	path   string
+	enc    *parquet.Encoder
+	exists exporter.ExistsPolicy
+
+	skipped, exported bool
+	written           int64
+}
+
+// NewWriter returns Writer into the database file at the path, created if it does not exist, with timestamps staged
+// in the given unit and the existing table handled by the policy, the append one if Append is set.
+func NewWriter(logger log.Logger, conf Config, path string, timeUnit exporter.TimeUnit, exists exporter.ExistsPolicy) *Writer {
+	switch {
+	case conf.Append:
+		exists = exporter.ExistsAppend
+	case exists == "":
+		exists = exporter.ExistsFail
+	}
+	return &Writer{logger: logger, conf: conf, path: path, enc: parquet.NewEncoder(timeUnit, parquet.Config{}), exists: exists}
+}
+
+// Export stages the dataframe rows and loads them into the table, creating the indexes missing.
+func (w *Writer) Export(ctx context.Context, df dataframe.Dataframe) error {
+	columns := map[string]struct{}{}
+	for _, c := range df.Schema() {
+		columns[c.Name] = struct{}{}
+	}
+	for _, c := range w.conf.Indexes {
+		if _, ok := columns[c]; !ok {
+			return errors.Errorf("index column %s is not in the exported columns", c)
+		}
+	}
+	if w.skipped {
+		return nil
+	}
+	// The existing table is checked until the first load succeeds, the failed loads leave the database unchanged.
+	if !w.exported {
+		exists, err := w.tableExists(ctx)
+		if err != nil {
+			return err
+		}
+		switch {
+		case exists && w.exists == exporter.ExistsFail:
+			return errors.Wrapf(exporter.ErrOutputExists, "table %s of %s, see if_exists", w.conf.Table, w.path)
+		case exists && w.exists == exporter.ExistsSkip:
+			level.Info(w.logger).Log("msg", "output exists, skipping the export", "path", w.path, "table", w.conf.Table)
+			w.skipped = true
+			return nil
+		}
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(w.path), ".obslytics-*.parquet")
+	if err != nil {
+		return errors.Wrap(err, "create staging file")
+	}
+	defer os.Remove(f.Name())
+	if err := w.enc.Encode(f, df); err != nil {
+		_ = f.Close()
+		return errors.Wrap(err, "stage rows")
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "stage rows")
+	}
+	st, err := os.Stat(f.Name())
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.run(ctx, w.script(f.Name(), !w.exported && w.exists == exporter.ExistsOverwrite)); err != nil {
+		return errors.Wrapf(err, "load into %s", w.path)
+	}
+	w.exported = true
+	w.written += st.Size()
+	return nil
+}
+
+// tableExists returns true if the table exists in the database.
+func (w *Writer) tableExists(ctx context.Context) (bool, error) {
+	if _, err := os.Stat(w.path); os.IsNotExist(err) {
+		return false, nil
+	}
+	out, err := w.run(ctx, fmt.Sprintf("SELECT count(*) FROM information_schema.tables WHERE table_name = %s;\n", quoteString(w.conf.Table)),
+		"-csv", "-noheader")
+	if err != nil {
+		return false, errors.Wrapf(err, "check table %s of %s", w.conf.Table, w.path)
+	}
+	return strings.TrimSpace(out) != "0", nil
+}
+
+// run runs the SQL by the DuckDB CLI against the database, returning the standard output.
+func (w *Writer) run(ctx context.Context, sql string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, w.conf.Binary, append(append([]string{"-bail"}, args...), w.path)...)
+	cmd.Stdin = strings.NewReader(sql)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", errors.Wrap(err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// script returns the SQL loading the staged Parquet file into the table, replacing it if replace is set.
+func (w *Writer) script(file string, replace bool) string {
+	var (
+		b     strings.Builder
+		table = quoteIdent(w.conf.Table)
+		src   = fmt.Sprintf("read_parquet(%s)", quoteString(file))
+	)
+	b.WriteString("BEGIN TRANSACTION;\n")
+	if replace {
+		fmt.Fprintf(&b, "DROP TABLE IF EXISTS %s;\n", table)
+	}
+	fmt.Fprintf(&b, "CREATE TABLE IF NOT EXISTS %s AS SELECT * FROM %s LIMIT 0;\n", table, src)
+	fmt.Fprintf(&b, "INSERT INTO %s BY NAME SELECT * FROM %s;\n", table, src)
+	for _, c := range w.conf.Indexes {
+		fmt.Fprintf(&b, "CREATE INDEX IF NOT EXISTS %s ON %s (%s);\n", quoteIdent(w.conf.Table+"_"+c+"_idx"), table, quoteIdent(c))
+	}
+	b.WriteString("COMMIT;\n")
+	return b.String()
+}
+
+// Outputs returns the database file with the size of the rows staged so far.
+func (w *Writer) Outputs() []exporter.Output {
+	if !w.exported {
+		return nil
+	}
+	return []exporter.Output{{Path: w.path, Bytes: w.written}}
+}
+
+func quoteIdent(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+func quoteString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -0,0 +1,274 @@
+package duckdb
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/thanos-community/obslytics/pkg/dataframe"
+	"github.com/thanos-community/obslytics/pkg/exporter"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+type testDataframe []dataframe.Row
+
+func (d testDataframe) Schema() dataframe.Schema {
+	return dataframe.Schema{
+		{Name: "job", Type: dataframe.TypeString},
+		{Name: "_sample_start", Type: dataframe.TypeTime},
+		{Name: "_sum", Type: dataframe.TypeFloat},
+	}
+}
+
+func (d testDataframe) RowsIterator() dataframe.RowsIterator {
+	return &testRowsIterator{rows: d, i: -1}
+}
+
+// otherDataframe has the rows of a single other column.
+type otherDataframe struct{ testDataframe }
+
+func (otherDataframe) Schema() dataframe.Schema {
+	return dataframe.Schema{{Name: "other", Type: dataframe.TypeString}}
+}
+
+type testRowsIterator struct {
+	rows []dataframe.Row
+	i    int
+}
+
+func (it *testRowsIterator) Next() bool {
+	it.i++
+	return it.i < len(it.rows)
+}
+
+func (it *testRowsIterator) At() dataframe.Row { return it.rows[it.i] }
+
+func TestParseConfig(t *testing.T) {
+	conf, err := ParseConfig([]byte("indexes: [job]\n"))
+	testutil.Ok(t, err)
+	testutil.Equals(t, Config{Binary: "duckdb", Table: "samples", Indexes: []string{"job"}}, conf)
+
+	for _, c := range []string{
+		"table: \"\"\n",
+		"indexes: [\"\"]\n",
+		"unknown: true\n",
+	} {
+		_, err := ParseConfig([]byte(c))
+		testutil.NotOk(t, err, c)
+	}
+}
+
+func TestWriter_Script(t *testing.T) {
+	w := NewWriter(log.NewNopLogger(), Config{Table: `u"p`, Indexes: []string{"job"}}, "out.duckdb", exporter.TimeUnitMilliseconds, "")
+	load := `CREATE TABLE IF NOT EXISTS "u""p" AS SELECT * FROM read_parquet('it''s.parquet') LIMIT 0;
+INSERT INTO "u""p" BY NAME SELECT * FROM read_parquet('it''s.parquet');
+CREATE INDEX IF NOT EXISTS "u""p_job_idx" ON "u""p" ("job");
+COMMIT;
+`
+	testutil.Equals(t, "BEGIN TRANSACTION;\n"+load, w.script("it's.parquet", false))
+	testutil.Equals(t, "BEGIN TRANSACTION;\nDROP TABLE IF EXISTS \"u\"\"p\";\n"+load, w.script("it's.parquet", true))
+}
+
+// fakeDuckDB writes the executable recording its arguments and the SQL loads given, failing if the SQL contains
+// "fail". The table is created by the first load, as told by the information_schema queries.
+func fakeDuckDB(t *testing.T, dir string) string {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake DuckDB CLI is a shell script")
+	}
+	bin := filepath.Join(dir, "duckdb")
+	script := `#!/bin/sh
+eval db=\${$#}
+echo "$@" >> "` + dir + `/args"
+sql=$(cat)
+if printf '%s\n' "$sql" | grep -q information_schema; then
+	if [ -f "` + dir + `/table" ]; then echo 1; else echo 0; fi
+	exit 0
+fi
+printf '%s\n' "$sql" >> "` + dir + `/sql"
+if printf '%s\n' "$sql" | grep -q fail; then
+	echo "Error: table fail does not exist" >&2
+	exit 1
+fi
+touch "$db" "` + dir + `/table"
+`
+	testutil.Ok(t, ioutil.WriteFile(bin, []byte(script), 0755))
+	return bin
+}
+
+func TestWriter_Export(t *testing.T) {
+	dir := t.TempDir()
+	db := filepath.Join(dir, "out.duckdb")
+	bin := fakeDuckDB(t, dir)
+	df := testDataframe{{"a", time.Unix(0, 0), 1.0}, {"b", time.Unix(60, 0), 2.0}}
+	read := func(name string) string {
+		b, err := ioutil.ReadFile(filepath.Join(dir, name))
+		testutil.Ok(t, err)
+		testutil.Ok(t, os.Remove(filepath.Join(dir, name)))
+		// The staging files are named randomly.
+		return regexp.MustCompile(`'[^']*\.obslytics-[0-9]+\.parquet'`).ReplaceAllString(string(b), "'staged'")
+	}
+	newWriter := func(exists exporter.ExistsPolicy) *Writer {
+		return NewWriter(log.NewNopLogger(), Config{Binary: bin, Table: "up", Indexes: []string{"job"}}, db, exporter.TimeUnitMilliseconds, exists)
+	}
+	load := `BEGIN TRANSACTION;
+CREATE TABLE IF NOT EXISTS "up" AS SELECT * FROM read_parquet('staged') LIMIT 0;
+INSERT INTO "up" BY NAME SELECT * FROM read_parquet('staged');
+CREATE INDEX IF NOT EXISTS "up_job_idx" ON "up" ("job");
+COMMIT;
+`
+
+	// The missing database is not queried for the table, the following exports of the writer append to it.
+	w := newWriter("")
+	testutil.Equals(t, 0, len(w.Outputs()))
+	testutil.Ok(t, w.Export(context.Background(), df))
+	testutil.Ok(t, w.Export(context.Background(), df))
+	testutil.Equals(t, "-bail "+db+"\n-bail "+db+"\n", read("args"))
+	testutil.Equals(t, load+load, read("sql"))
+
+	outputs := w.Outputs()
+	testutil.Equals(t, 1, len(outputs))
+	testutil.Equals(t, db, outputs[0].Path)
+	testutil.Assert(t, outputs[0].Bytes > 0)
+
+	// The staging files are removed.
+	files, err := filepath.Glob(filepath.Join(dir, ".obslytics-*"))
+	testutil.Ok(t, err)
+	testutil.Equals(t, 0, len(files))
+
+	// The existing table is handled by the policy.
+	err = newWriter(exporter.ExistsFail).Export(context.Background(), df)
+	testutil.NotOk(t, err)
+	testutil.Equals(t, exporter.ErrOutputExists, errors.Cause(err))
+	testutil.Equals(t, "-bail -csv -noheader "+db+"\n", read("args"))
+
+	w = newWriter(exporter.ExistsSkip)
+	testutil.Ok(t, w.Export(context.Background(), df))
+	testutil.Ok(t, w.Export(context.Background(), df))
+	testutil.Equals(t, 0, len(w.Outputs()))
+	testutil.Equals(t, "-bail -csv -noheader "+db+"\n", read("args"))
+
+	w = newWriter(exporter.ExistsAppend)
+	testutil.Ok(t, w.Export(context.Background(), df))
+	testutil.Equals(t, load, read("sql"))
+
+	// Only the first export of the writer replaces the table.
+	w = newWriter(exporter.ExistsOverwrite)
+	testutil.Ok(t, w.Export(context.Background(), df))
+	testutil.Ok(t, w.Export(context.Background(), df))
+	testutil.Equals(t, strings.Replace(load, "\n", "\nDROP TABLE IF EXISTS \"up\";\n", 1)+load, read("sql"))
+}
+
+func TestWriter_Export_Errors(t *testing.T) {
+	dir := t.TempDir()
+	db := filepath.Join(dir, "out.duckdb")
+	df := testDataframe{{"a", time.Unix(0, 0), 1.0}}
+
+	w := NewWriter(log.NewNopLogger(), Config{Binary: fakeDuckDB(t, dir), Table: "fail", Append: true}, db, exporter.TimeUnitMilliseconds, "")
+	err := w.Export(context.Background(), df)
+	testutil.NotOk(t, err)
+	testutil.Assert(t, strings.Contains(err.Error(), "table fail does not exist"), err.Error())
+	testutil.Equals(t, 0, len(w.Outputs()))
+
+	// Index columns are checked before loading.
+	w = NewWriter(log.NewNopLogger(), Config{Binary: fakeDuckDB(t, dir), Table: "up", Indexes: []string{"instance"}}, db, exporter.TimeUnitMilliseconds, "")
+	testutil.NotOk(t, w.Export(context.Background(), df))
+}
+
+// duckDB returns the DuckDB CLI in PATH, skipping the test without it.
+func duckDB(t *testing.T) string {
+	bin, err := exec.LookPath("duckdb")
+	if err != nil {
+		t.Skip("DuckDB CLI not found in PATH")
+	}
+	return bin
+}
+
+// query returns the CSV result of the query against the database.
+func query(t *testing.T, bin, db, q string) string {
+	out, err := exec.Command(bin, "-csv", "-noheader", db, q).Output()
+	testutil.Ok(t, err)
+	return strings.TrimSpace(string(out))
+}
+
+func TestWriter_Export_DuckDB(t *testing.T) {
+	bin := duckDB(t)
+	dir := t.TempDir()
+	db := filepath.Join(dir, "out.duckdb")
+	df := testDataframe{{"a", time.Unix(0, 0), 1.0}, {"b", time.Unix(60, 0), 2.0}}
+	newWriter := func(exists exporter.ExistsPolicy) *Writer {
+		return NewWriter(log.NewNopLogger(), Config{Binary: bin, Table: "up", Indexes: []string{"job"}}, db, exporter.TimeUnitMilliseconds, exists)
+	}
+
+	// The following exports of the writer append to the table.
+	w := newWriter("")
+	testutil.Equals(t, 0, len(w.Outputs()))
+	testutil.Ok(t, w.Export(context.Background(), df))
+	testutil.Ok(t, w.Export(context.Background(), df))
+	testutil.Equals(t, "4", query(t, bin, db, `SELECT count(*) FROM up`))
+	testutil.Equals(t, "3.0", query(t, bin, db, `SELECT sum(_sum) FROM up WHERE job = 'b'`))
+	testutil.Equals(t, "up_job_idx", query(t, bin, db, `SELECT index_name FROM duckdb_indexes()`))
+
+	outputs := w.Outputs()
+	testutil.Equals(t, 1, len(outputs))
+	testutil.Equals(t, db, outputs[0].Path)
+	testutil.Assert(t, outputs[0].Bytes > 0)
+
+	// The staging files are removed.
+	files, err := filepath.Glob(filepath.Join(dir, ".obslytics-*"))
+	testutil.Ok(t, err)
+	testutil.Equals(t, 0, len(files))
+
+	// The existing table is handled by the policy.
+	err = newWriter(exporter.ExistsFail).Export(context.Background(), df)
+	testutil.NotOk(t, err)
+	testutil.Equals(t, exporter.ErrOutputExists, errors.Cause(err))
+
+	w = newWriter(exporter.ExistsSkip)
+	testutil.Ok(t, w.Export(context.Background(), df))
+	testutil.Ok(t, w.Export(context.Background(), df))
+	testutil.Equals(t, 0, len(w.Outputs()))
+	testutil.Equals(t, "4", query(t, bin, db, `SELECT count(*) FROM up`))
+
+	testutil.Ok(t, newWriter(exporter.ExistsAppend).Export(context.Background(), df))
+	testutil.Equals(t, "6", query(t, bin, db, `SELECT count(*) FROM up`))
+
+	w = newWriter(exporter.ExistsOverwrite)
+	testutil.Ok(t, w.Export(context.Background(), df))
+	testutil.Ok(t, w.Export(context.Background(), df))
+	testutil.Equals(t, "4", query(t, bin, db, `SELECT count(*) FROM up`))
+}
+
+func TestWriter_Export_Errors_DuckDB(t *testing.T) {
+	bin := duckDB(t)
+	dir := t.TempDir()
+	db := filepath.Join(dir, "out.duckdb")
+	df := testDataframe{{"a", time.Unix(0, 0), 1.0}}
+
+	// A failed load leaves the database unchanged.
+	testutil.Ok(t, NewWriter(log.NewNopLogger(), Config{Binary: bin, Table: "up"}, db, exporter.TimeUnitMilliseconds, "").Export(context.Background(), df))
+	other := otherDataframe{testDataframe{{"x"}}}
+	w := NewWriter(log.NewNopLogger(), Config{Binary: bin, Table: "up"}, db, exporter.TimeUnitMilliseconds, exporter.ExistsAppend)
+	testutil.NotOk(t, w.Export(context.Background(), other))
+	testutil.Equals(t, 0, len(w.Outputs()))
+	testutil.Equals(t, "1", query(t, bin, db, `SELECT count(*) FROM up`))
+
+	// Index columns are checked before loading.
+	w = NewWriter(log.NewNopLogger(), Config{Binary: bin, Table: "up", Indexes: []string{"instance"}}, db, exporter.TimeUnitMilliseconds, exporter.ExistsAppend)
+	testutil.NotOk(t, w.Export(context.Background(), df))
+}
+
+func TestWriter_Export_MissingBinary(t *testing.T) {
+	dir := t.TempDir()
+	w := NewWriter(log.NewNopLogger(), Config{Binary: filepath.Join(dir, "missing"), Table: "up"}, filepath.Join(dir, "out.duckdb"),
+		exporter.TimeUnitMilliseconds, "")
+	testutil.NotOk(t, w.Export(context.Background(), testDataframe{{"a", time.Unix(0, 0), 1.0}}))
+}
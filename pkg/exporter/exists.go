@@ -0,0 +1,96 @@
+package exporter
+
+import (
+	"context"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+)
+
+// ErrOutputExists is returned when the file to write exists already and the ExistsFail policy is set. Use
+// errors.Cause to check for it.
+var ErrOutputExists = errors.New("output exists")
+
+// ExistsPolicy defines how the files of the file outputs (PARQUET, HDF5 and PROMTEXT) that exist already, e.g. of an
+// earlier run of a scheduled export, are handled. The DUCKDB output applies it to its table, which is appended to in
+// place.
+type ExistsPolicy string
+
+const (
+	// ExistsFail fails the export with ErrOutputExists before writing the existing file. This is the default.
+	ExistsFail ExistsPolicy = "fail"
+	// ExistsOverwrite overwrites the existing files.
+	ExistsOverwrite ExistsPolicy = "overwrite"
+	// ExistsAppend writes the files next to the existing ones, as the following parts of the output, e.g.
+	// out-1.parquet next to out.parquet. Neither the objects nor the file formats can be appended to in place.
+	ExistsAppend ExistsPolicy = "append"
+	// ExistsSkip skips the export if its first file exists, logging it. The later parts of an export rolled over,
+	// see RollOver, are overwritten, as they are left from an earlier export of the same path.
+	ExistsSkip ExistsPolicy = "skip"
+)
+
+// Validate returns error if the policy is not known.
+func (p ExistsPolicy) Validate() error {
+	switch p {
+	case "", ExistsFail, ExistsOverwrite, ExistsAppend, ExistsSkip:
+		return nil
+	}
+	return errors.Errorf("unsupported if_exists policy %q, expected fail, overwrite, append or skip", p)
+}
+
+// WithExistsPolicy checks the files exist before writing them, handling the existing ones according to the policy.
+// The Exporter overwrites the existing files without it. The files written by the Exporter itself are overwritten
+// regardless of the policy.
+func WithExistsPolicy(logger log.Logger, p ExistsPolicy) Option {
+	return func(e *Exporter) {
+		if p == "" {
+			p = ExistsFail
+		}
+		e.existsLogger = logger
+		e.exists = p
+	}
+}
+
+// part returns the part of the file to write, from the part n, according to the exists policy. The first file of the
+// export is skipped by returning false.
+func (e *Exporter) part(ctx context.Context, n int, first bool) (int, bool, error) {
+	if e.exists == "" || e.exists == ExistsOverwrite {
+		return n, true, nil
+	}
+	for ; ; n++ {
+		p := partPath(e.path, n)
+		if e.written(p) {
+			return n, true, nil
+		}
+		ok, err := e.bkt.Exists(ctx, p)
+		if err != nil {
+			return 0, false, errors.Wrapf(err, "check if %v exists", p)
+		}
+		if !ok {
+			return n, true, nil
+		}
+
+		switch e.exists {
+		case ExistsAppend:
+			continue
+		case ExistsSkip:
+			if !first {
+				return n, true, nil
+			}
+			level.Info(e.existsLogger).Log("msg", "output exists, skipping the export", "path", p)
+			return 0, false, nil
+		}
+		return 0, false, errors.Wrapf(ErrOutputExists, "%v, see if_exists", p)
+	}
+}
+
+// written returns true if the Exporter wrote the file.
+func (e *Exporter) written(p string) bool {
+	for _, o := range e.outputs {
+		if o.Path == p {
+			return true
+		}
+	}
+	return false
+}
@@ -2,18 +2,41 @@ package exporter
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
 	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
 
+	"github.com/go-kit/kit/log"
+	"github.com/minio/minio-go/v7"
 	"github.com/pkg/errors"
 	"github.com/thanos-community/obslytics/pkg/dataframe"
 	"github.com/thanos-io/thanos/pkg/objstore"
 	"github.com/thanos-io/thanos/pkg/objstore/client"
+	"google.golang.org/api/googleapi"
 )
 
 type Type string
 
 const (
 	PARQUET Type = "PARQUET"
+	KAFKA   Type = "KAFKA"
+	// REMOTEWRITE pushes the aggregated series to a Prometheus compatible remote write endpoint.
+	REMOTEWRITE Type = "REMOTEWRITE"
+	// BIGQUERY streams the rows into a Google BigQuery table.
+	BIGQUERY Type = "BIGQUERY"
+	// HDF5 writes a file with a dataset per series, grouped by metric name.
+	HDF5 Type = "HDF5"
+	// PROMTEXT writes a file in the Prometheus text exposition format.
+	PROMTEXT Type = "PROMTEXT"
+	// DUCKDB writes a table of a local DuckDB database file.
+	DUCKDB Type = "DUCKDB"
 )
 
 // Config contains the options determining the object storage where files will be uploaded to.
@@ -21,6 +44,51 @@ type Config struct {
 	Type    Type                `yaml:"type"`
 	Path    string              `yaml:"path"`
 	Storage client.BucketConfig `yaml:"storage"`
+	// TimestampUnit is the precision of the written timestamps, milliseconds by default.
+	TimestampUnit TimeUnit `yaml:"timestamp_unit"`
+	// MaxOutputBytes limits the size of the output, no limit if 0. Once reached, the current file is finalized
+	// and the export fails with ErrOutputLimitReached, unless RollOver is set. The rows buffered by the encoder, e.g.
+	// into a row group, count by their approximate size.
+	MaxOutputBytes int64 `yaml:"max_output_bytes"`
+	// RollOver continues the export into a new file (e.g. out-1.parquet) when MaxOutputBytes is reached.
+	RollOver bool `yaml:"roll_over"`
+	// Validate reads every written file back after the upload and checks it parses and contains all the rows.
+	Validate bool `yaml:"validate"`
+	// Checksum writes the SHA-256 checksum of every file into a sidecar object next to it, e.g. out.parquet.sha256,
+	// in the sha256sum format. The checksum is computed while uploading.
+	Checksum bool `yaml:"checksum"`
+	// MissingLabel, if set, is written for the labels missing in some of the series, e.g. "" or "__missing__".
+	// By default, the missing labels are written as null, which both Parquet and JSON or Avro messages support.
+	// Supported by the PARQUET, KAFKA and DUCKDB outputs only, the others write the labels as label sets.
+	MissingLabel *string `yaml:"missing_label"`
+	// Retry retries the failed writes with exponential backoff. The files are retried by uploading them again
+	// whole, KAFKA retries producing the failed messages. REMOTEWRITE and BIGQUERY have their own retry options.
+	Retry RetryConfig `yaml:"retry"`
+	// WriteBuffer buffers the encoded output of the file outputs (PARQUET, HDF5 and PROMTEXT).
+	WriteBuffer BufferConfig `yaml:"write_buffer"`
+	// IfExists is the policy for the files of the file outputs and the DUCKDB table that exist already: fail
+	// (default), overwrite, append or skip.
+	IfExists ExistsPolicy `yaml:"if_exists"`
+	// MetricColumn is the column of the metric name of the rows, __name__ if empty, as set by the metric-column export
+	// flag. The PROMTEXT and REMOTEWRITE outputs name the metrics by it rather than writing it as a label.
+	MetricColumn string `yaml:"-"`
+	// Config is the type specific configuration, e.g. the producer configuration for KAFKA.
+	Config interface{} `yaml:"config"`
+}
+
+// Writer exports the dataframe to its destination.
+type Writer interface {
+	Export(context.Context, dataframe.Dataframe) error
+	// Outputs returns the outputs written so far.
+	Outputs() []Output
+}
+
+// Output describes a single written output, e.g. a file.
+type Output struct {
+	Path  string `json:"path"`
+	Bytes int64  `json:"bytes"`
+	// SHA256 is the hex encoded checksum of the output, if enabled.
+	SHA256 string `json:"sha256,omitempty"`
 }
 
 // An Encoder writes serialized type to an output stream.
@@ -29,31 +97,181 @@ type Encoder interface {
 	Encode(io.Writer, dataframe.Dataframe) (err error)
 }
 
+// A Validator checks the encoded output is readable.
+type Validator interface {
+	// Validate returns error if the encoded file at the local path cannot be parsed or does not contain the given
+	// number of rows.
+	Validate(path string, rows int64) error
+}
+
+// Compile-time check if Exporter implements Writer interface.
+var _ Writer = &Exporter{}
+
+// Exporter writes the encoded dataframe into the object storage.
 type Exporter struct {
 	enc Encoder
 
 	path string
 	bkt  objstore.Bucket
+
+	maxBytes int64
+	rollOver bool
+	validate bool
+	checksum bool
+	retry    RetryConfig
+	buffer   BufferConfig
+	logger   log.Logger
+
+	exists       ExistsPolicy
+	existsLogger log.Logger
+
+	outputs []Output
+}
+
+// Option customizes the Exporter.
+type Option func(*Exporter)
+
+// WithOutputLimit limits the size of the written output. When the limit is reached, the current file is
+// finalized and Export returns ErrOutputLimitReached or, with rollOver, continues into a new file.
+// Encoders buffering the output (e.g. parquet row groups) might overshoot the limit by the buffered size.
+func WithOutputLimit(maxBytes int64, rollOver bool) Option {
+	return func(e *Exporter) {
+		e.maxBytes = maxBytes
+		e.rollOver = rollOver
+	}
+}
+
+// WithValidation reads every file back after the upload and validates it. The encoder has to implement Validator.
+func WithValidation() Option {
+	return func(e *Exporter) {
+		e.validate = true
+	}
+}
+
+// WithChecksum writes the SHA-256 checksum of every file into a sidecar object with .sha256 suffix, once the file
+// is uploaded.
+func WithChecksum() Option {
+	return func(e *Exporter) {
+		e.checksum = true
+	}
+}
+
+// WithRetry retries the failed uploads of the files. Every file is encoded into a temporary file first, so that it can
+// be uploaded again. The upload overwrites the object, so a retried upload does not leave partial objects behind.
+func WithRetry(logger log.Logger, conf RetryConfig) Option {
+	return func(e *Exporter) {
+		e.logger = logger
+		e.retry = conf
+	}
+}
+
+// WithWriteBuffer configures the buffer of the encoded output, 64KiB flushed once full by default. The buffer is
+// flushed at the end of every file, also when the encoding fails, so that interrupted exports keep the encoded output.
+func WithWriteBuffer(conf BufferConfig) Option {
+	return func(e *Exporter) {
+		e.buffer = conf
+	}
 }
 
-func New(c Encoder, path string, bkt objstore.Bucket) *Exporter {
-	return &Exporter{
+func New(c Encoder, path string, bkt objstore.Bucket, opts ...Option) *Exporter {
+	e := &Exporter{
 		enc:  c,
 		path: path,
 		bkt:  bkt,
 	}
+	for _, o := range opts {
+		o(e)
+	}
+	return e
 }
 
 // Export encodes and streams the dataframe to given bucket. On error partial result might occur.
 // It's caller responsibility to clean after error.
-func (e *Exporter) Export(ctx context.Context, df dataframe.Dataframe) (err error) {
+func (e *Exporter) Export(ctx context.Context, df dataframe.Dataframe) error {
+	part, ok, err := e.part(ctx, 0, true)
+	if err != nil || !ok {
+		return err
+	}
+	if e.maxBytes <= 0 {
+		return e.export(ctx, partPath(e.path, part), df, &countingWriter{})
+	}
+
+	rows := &limitedRows{rows: df.RowsIterator(), max: e.maxBytes}
+	for {
+		cw := &countingWriter{}
+		rows.cw, rows.buffered, rows.limited = cw, 0, false
+		if err := e.export(ctx, partPath(e.path, part), limitedDataframe{schema: df.Schema(), rows: rows}, cw); err != nil {
+			return err
+		}
+		if !rows.limited {
+			return nil
+		}
+		if !e.rollOver {
+			return errors.Wrapf(ErrOutputLimitReached, "%v finalized after %d bytes", partPath(e.path, part), cw.n)
+		}
+		if part, _, err = e.part(ctx, part+1, false); err != nil {
+			return err
+		}
+	}
+}
+
+// Outputs returns the files uploaded so far.
+func (e *Exporter) Outputs() []Output { return e.outputs }
+
+// export encodes the dataframe into a single file, counting the written bytes in cw.
+func (e *Exporter) export(ctx context.Context, path string, df dataframe.Dataframe, cw *countingWriter) error {
+	rows := &countingRows{}
+	var h hash.Hash
+	if e.checksum {
+		h = sha256.New()
+	}
+	upload := e.upload
+	if e.retry.Enabled() {
+		upload = e.spooledUpload
+	}
+	if err := upload(ctx, path, countingDataframe{Dataframe: df, rows: rows}, cw, h); err != nil {
+		return err
+	}
+	out := Output{Path: path, Bytes: cw.n}
+	if h != nil {
+		out.SHA256 = hex.EncodeToString(h.Sum(nil))
+		if err := Retry(ctx, e.logger, e.retry, func() error {
+			return e.bkt.Upload(ctx, path+".sha256", strings.NewReader(checksumFile(path, out.SHA256)))
+		}); err != nil {
+			return errors.Wrap(err, "upload checksum")
+		}
+	}
+	e.outputs = append(e.outputs, out)
+
+	if !e.validate {
+		return nil
+	}
+	return e.validateObject(ctx, path, rows.n)
+}
+
+// checksumFile returns the content of the checksum sidecar of the file, in the sha256sum format, so that the file
+// can be verified with sha256sum -c next to it.
+func checksumFile(p, sum string) string {
+	return fmt.Sprintf("%s  %s\n", sum, path.Base(p))
+}
+
+// upload encodes and uploads the dataframe, writing the uploaded bytes also into h, if given.
+func (e *Exporter) upload(ctx context.Context, path string, df dataframe.Dataframe, cw *countingWriter, h hash.Hash) (err error) {
 	r, w := io.Pipe()
 
 	errch := make(chan error, 1)
 	go func() {
 		// TODO(bwplotka): Log error from close (e.g using runutil.Close... package).
 		defer w.Close()
-		if err := e.enc.Encode(w, df); err != nil {
+
+		bw := newBufferedWriter(w, e.buffer)
+		cw.w = bw
+		err := e.enc.Encode(cw, df)
+		if ferr := bw.Close(); ferr != nil && err == nil {
+			errch <- errors.Wrap(ferr, "flush")
+			return
+		}
+		if err != nil {
 			errch <- errors.Wrap(err, "encode")
 			return
 		}
@@ -67,8 +285,104 @@ func (e *Exporter) Export(ctx context.Context, df dataframe.Dataframe) (err erro
 		}
 	}()
 
-	if err := e.bkt.Upload(ctx, e.path, r); err != nil {
+	var src io.Reader = r
+	if h != nil {
+		src = io.TeeReader(r, h)
+	}
+	if err := e.bkt.Upload(ctx, path, src); err != nil {
 		return errors.Wrap(err, "upload")
 	}
 	return nil
 }
+
+// spooledUpload encodes the dataframe into a temporary file and uploads it, retrying the failed uploads. The encoded
+// bytes are written also into h, if given.
+func (e *Exporter) spooledUpload(ctx context.Context, path string, df dataframe.Dataframe, cw *countingWriter, h hash.Hash) error {
+	f, err := ioutil.TempFile("", "obslytics-upload")
+	if err != nil {
+		return errors.Wrap(err, "create temporary file")
+	}
+	defer func() {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	}()
+
+	var dst io.Writer = f
+	if h != nil {
+		dst = io.MultiWriter(f, h)
+	}
+	bw := newBufferedWriter(dst, e.buffer)
+	cw.w = bw
+	err = e.enc.Encode(cw, df)
+	if ferr := bw.Close(); ferr != nil && err == nil {
+		return errors.Wrap(ferr, "flush")
+	}
+	if err != nil {
+		return errors.Wrap(err, "encode")
+	}
+	return Retry(ctx, e.logger, e.retry, func() error {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return Permanent(errors.Wrap(err, "rewind temporary file"))
+		}
+		if err := e.bkt.Upload(ctx, path, f); err != nil {
+			if isPermanentBucketErr(e.bkt, err) {
+				return Permanent(errors.Wrap(err, "upload"))
+			}
+			return errors.Wrap(err, "upload")
+		}
+		return nil
+	})
+}
+
+// isPermanentBucketErr returns true if the upload failed for a reason that retrying does not fix: the bucket does not
+// exist, or the credentials are rejected or not allowed to write, as told by the status code of the S3 and GCS errors.
+func isPermanentBucketErr(bkt objstore.Bucket, err error) bool {
+	permanentStatus := func(code int) bool {
+		return code == http.StatusUnauthorized || code == http.StatusForbidden || code == http.StatusNotFound
+	}
+	if bkt.IsObjNotFoundErr(err) {
+		return true
+	}
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) {
+		return permanentStatus(gerr.Code)
+	}
+	if resp := minio.ToErrorResponse(errors.Cause(err)); resp.StatusCode != 0 {
+		return permanentStatus(resp.StatusCode)
+	}
+	return os.IsPermission(errors.Cause(err))
+}
+
+// validateObject reads the uploaded object back and validates it contains the given number of rows. The object is
+// downloaded into a temporary file, rather than into memory, and validated from there.
+func (e *Exporter) validateObject(ctx context.Context, path string, rows int64) error {
+	v, ok := e.enc.(Validator)
+	if !ok {
+		return errors.Errorf("validation is not supported by %T encoder", e.enc)
+	}
+
+	r, err := e.bkt.Get(ctx, path)
+	if err != nil {
+		return errors.Wrap(err, "validate: get written object")
+	}
+	defer r.Close()
+
+	f, err := ioutil.TempFile("", "obslytics-validate")
+	if err != nil {
+		return errors.Wrap(err, "validate: create temporary file")
+	}
+	defer func() {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	}()
+	if _, err := io.Copy(f, r); err != nil {
+		return errors.Wrap(err, "validate: read written object")
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "validate: read written object")
+	}
+	if err := v.Validate(f.Name(), rows); err != nil {
+		return errors.Wrapf(err, "validate %v", path)
+	}
+	return nil
+}
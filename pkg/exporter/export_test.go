@@ -0,0 +1,342 @@
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/minio/minio-go/v7"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"github.com/thanos-community/obslytics/pkg/dataframe"
+	"github.com/thanos-io/thanos/pkg/objstore"
+	"github.com/thanos-io/thanos/pkg/testutil"
+	"google.golang.org/api/googleapi"
+)
+
+// lineEncoder writes every row as a single line.
+type lineEncoder struct{}
+
+func (lineEncoder) Encode(w io.Writer, df dataframe.Dataframe) error {
+	i := df.RowsIterator()
+	for i.Next() {
+		if _, err := fmt.Fprintln(w, i.At()...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type testRows struct {
+	rows []dataframe.Row
+	i    int
+}
+
+func (r *testRows) Next() bool {
+	r.i++
+	return r.i <= len(r.rows)
+}
+
+func (r *testRows) At() dataframe.Row { return r.rows[r.i-1] }
+
+type testDataframe []dataframe.Row
+
+func (d testDataframe) Schema() dataframe.Schema {
+	return dataframe.Schema{{Name: "v", Type: dataframe.TypeString}}
+}
+
+func (d testDataframe) RowsIterator() dataframe.RowsIterator { return &testRows{rows: d} }
+
+func TestExporter_OutputLimit(t *testing.T) {
+	ctx := context.Background()
+	// Every row takes 4 bytes.
+	df := testDataframe{{"aaa"}, {"bbb"}, {"ccc"}, {"ddd"}, {"eee"}}
+
+	content := func(t *testing.T, bkt objstore.Bucket, name string) string {
+		r, err := bkt.Get(ctx, name)
+		testutil.Ok(t, err)
+		defer r.Close()
+
+		b, err := ioutil.ReadAll(r)
+		testutil.Ok(t, err)
+		return string(b)
+	}
+
+	t.Run("no limit", func(t *testing.T) {
+		bkt := objstore.NewInMemBucket()
+		testutil.Ok(t, New(lineEncoder{}, "out.txt", bkt).Export(ctx, df))
+		testutil.Equals(t, "aaa\nbbb\nccc\nddd\neee\n", content(t, bkt, "out.txt"))
+	})
+	t.Run("limit not reached", func(t *testing.T) {
+		bkt := objstore.NewInMemBucket()
+		testutil.Ok(t, New(lineEncoder{}, "out.txt", bkt, WithOutputLimit(20, false)).Export(ctx, df))
+		testutil.Equals(t, "aaa\nbbb\nccc\nddd\neee\n", content(t, bkt, "out.txt"))
+		testutil.Equals(t, 1, len(bkt.Objects()))
+	})
+	t.Run("limit reached", func(t *testing.T) {
+		bkt := objstore.NewInMemBucket()
+		err := New(lineEncoder{}, "out.txt", bkt, WithOutputLimit(6, false)).Export(ctx, df)
+		testutil.NotOk(t, err)
+		testutil.Equals(t, ErrOutputLimitReached, errors.Cause(err))
+		testutil.Equals(t, "aaa\nbbb\n", content(t, bkt, "out.txt"))
+	})
+	t.Run("roll over", func(t *testing.T) {
+		bkt := objstore.NewInMemBucket()
+		testutil.Ok(t, New(lineEncoder{}, "dir/out.txt", bkt, WithOutputLimit(8, true)).Export(ctx, df))
+		testutil.Equals(t, "aaa\nbbb\n", content(t, bkt, "dir/out.txt"))
+		testutil.Equals(t, "ccc\nddd\n", content(t, bkt, "dir/out-1.txt"))
+		testutil.Equals(t, "eee\n", content(t, bkt, "dir/out-2.txt"))
+		testutil.Equals(t, 3, len(bkt.Objects()))
+	})
+}
+
+// validatingEncoder is lineEncoder checking the number of lines.
+type validatingEncoder struct {
+	lineEncoder
+
+	corrupt bool
+}
+
+func (e validatingEncoder) Encode(w io.Writer, df dataframe.Dataframe) error {
+	if err := e.lineEncoder.Encode(w, df); err != nil {
+		return err
+	}
+	if e.corrupt {
+		_, err := fmt.Fprintln(w, "extra")
+		return err
+	}
+	return nil
+}
+
+func (validatingEncoder) Validate(path string, rows int64) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if n := int64(strings.Count(string(data), "\n")); n != rows {
+		return errors.Errorf("expected %d rows, got %d", rows, n)
+	}
+	return nil
+}
+
+func TestExporter_Validation(t *testing.T) {
+	ctx := context.Background()
+	df := testDataframe{{"aaa"}, {"bbb"}, {"ccc"}}
+
+	testutil.Ok(t, New(validatingEncoder{}, "out.txt", objstore.NewInMemBucket(), WithValidation()).Export(ctx, df))
+	testutil.Ok(t, New(validatingEncoder{}, "out.txt", objstore.NewInMemBucket(), WithValidation(), WithOutputLimit(4, true)).Export(ctx, df))
+	testutil.NotOk(t, New(validatingEncoder{corrupt: true}, "out.txt", objstore.NewInMemBucket(), WithValidation()).Export(ctx, df))
+
+	// Corrupted output is not detected without validation.
+	testutil.Ok(t, New(validatingEncoder{corrupt: true}, "out.txt", objstore.NewInMemBucket()).Export(ctx, df))
+	// Encoder not implementing Validator.
+	testutil.NotOk(t, New(lineEncoder{}, "out.txt", objstore.NewInMemBucket(), WithValidation()).Export(ctx, df))
+}
+
+func TestExporter_Checksum(t *testing.T) {
+	ctx := context.Background()
+	df := testDataframe{{"aaa"}, {"bbb"}, {"ccc"}}
+	// sha256 of "aaa\nbbb\n" and "ccc\n".
+	sums := []string{
+		"46fc473c1332d06d55a91c357c58d1472f9b35008d40a8f8c2ce230e9b051618",
+		"5695d82a086b677962a0b0428ed1a213208285b7b40d7d3604876d36a710302a",
+	}
+
+	bkt := objstore.NewInMemBucket()
+	e := New(lineEncoder{}, "dir/out.txt", bkt, WithOutputLimit(8, true), WithChecksum())
+	testutil.Ok(t, e.Export(ctx, df))
+	testutil.Equals(t, []Output{
+		{Path: "dir/out.txt", Bytes: 8, SHA256: sums[0]},
+		{Path: "dir/out-1.txt", Bytes: 4, SHA256: sums[1]},
+	}, e.Outputs())
+
+	for i, name := range []string{"out.txt", "out-1.txt"} {
+		r, err := bkt.Get(ctx, "dir/"+name+".sha256")
+		testutil.Ok(t, err)
+		b, err := ioutil.ReadAll(r)
+		testutil.Ok(t, err)
+		testutil.Equals(t, sums[i]+"  "+name+"\n", string(b))
+	}
+	testutil.Equals(t, 4, len(bkt.Objects()))
+}
+
+// flakyBucket fails the uploads until the given number of failures, after reading part of the data, with the err or
+// a connection reset.
+type flakyBucket struct {
+	objstore.Bucket
+
+	failures int
+	err      error
+}
+
+func (b *flakyBucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	if b.failures > 0 {
+		b.failures--
+		_, _ = io.ReadFull(r, make([]byte, 2))
+		if b.err != nil {
+			return b.err
+		}
+		return errors.New("connection reset")
+	}
+	return b.Bucket.Upload(ctx, name, r)
+}
+
+func TestExporter_Retry(t *testing.T) {
+	ctx := context.Background()
+	df := testDataframe{{"aaa"}, {"bbb"}, {"ccc"}}
+	conf := RetryConfig{MaxAttempts: 3, MinBackoff: model.Duration(time.Millisecond)}
+
+	bkt := &flakyBucket{Bucket: objstore.NewInMemBucket(), failures: 2}
+	e := New(lineEncoder{}, "out.txt", bkt, WithChecksum(), WithOutputLimit(8, true), WithRetry(log.NewNopLogger(), conf))
+	testutil.Ok(t, e.Export(ctx, df))
+	testutil.Equals(t, []Output{
+		{Path: "out.txt", Bytes: 8, SHA256: "46fc473c1332d06d55a91c357c58d1472f9b35008d40a8f8c2ce230e9b051618"},
+		{Path: "out-1.txt", Bytes: 4, SHA256: "5695d82a086b677962a0b0428ed1a213208285b7b40d7d3604876d36a710302a"},
+	}, e.Outputs())
+
+	r, err := bkt.Get(ctx, "out.txt")
+	testutil.Ok(t, err)
+	b, err := ioutil.ReadAll(r)
+	testutil.Ok(t, err)
+	testutil.Equals(t, "aaa\nbbb\n", string(b))
+
+	bkt = &flakyBucket{Bucket: objstore.NewInMemBucket(), failures: 3}
+	testutil.NotOk(t, New(lineEncoder{}, "out.txt", bkt, WithRetry(log.NewNopLogger(), conf)).Export(ctx, df))
+	// Without retries.
+	bkt = &flakyBucket{Bucket: objstore.NewInMemBucket(), failures: 1}
+	testutil.NotOk(t, New(lineEncoder{}, "out.txt", bkt).Export(ctx, df))
+
+	// The errors of the rejected credentials and missing buckets are not retried.
+	for _, err := range []error{
+		minio.ErrorResponse{StatusCode: http.StatusForbidden, Code: "AccessDenied"},
+		errors.Wrap(minio.ErrorResponse{StatusCode: http.StatusNotFound, Code: "NoSuchBucket"}, "upload s3 object"),
+		&googleapi.Error{Code: http.StatusUnauthorized},
+	} {
+		bkt = &flakyBucket{Bucket: objstore.NewInMemBucket(), failures: 2, err: err}
+		err = New(lineEncoder{}, "out.txt", bkt, WithRetry(log.NewNopLogger(), conf)).Export(ctx, df)
+		testutil.Assert(t, IsPermanent(err), "expected permanent error, got %v", err)
+		testutil.Equals(t, 1, bkt.failures)
+	}
+	// The server errors are.
+	bkt = &flakyBucket{Bucket: objstore.NewInMemBucket(), failures: 2, err: minio.ErrorResponse{StatusCode: http.StatusServiceUnavailable}}
+	testutil.Ok(t, New(lineEncoder{}, "out.txt", bkt, WithRetry(log.NewNopLogger(), conf)).Export(ctx, df))
+}
+
+func TestExporter_ExistsPolicy(t *testing.T) {
+	ctx := context.Background()
+	df := testDataframe{{"aaa"}, {"bbb"}, {"ccc"}}
+
+	// The bucket with the files of an earlier export rolled over into two parts.
+	newBucket := func(t *testing.T) objstore.Bucket {
+		bkt := objstore.NewInMemBucket()
+		testutil.Ok(t, bkt.Upload(ctx, "out.txt", strings.NewReader("old\nold\n")))
+		testutil.Ok(t, bkt.Upload(ctx, "out-1.txt", strings.NewReader("old\n")))
+		return bkt
+	}
+	content := func(t *testing.T, bkt objstore.Bucket, name string) string {
+		r, err := bkt.Get(ctx, name)
+		testutil.Ok(t, err)
+		b, err := ioutil.ReadAll(r)
+		testutil.Ok(t, err)
+		return string(b)
+	}
+
+	for _, tcase := range []struct {
+		policy   ExistsPolicy
+		expected []string
+		contents map[string]string
+	}{
+		{policy: ExistsOverwrite, expected: []string{"out.txt", "out-1.txt"}, contents: map[string]string{
+			"out.txt": "aaa\nbbb\n", "out-1.txt": "ccc\n",
+		}},
+		{policy: ExistsAppend, expected: []string{"out-2.txt", "out-3.txt"}, contents: map[string]string{
+			"out.txt": "old\nold\n", "out-1.txt": "old\n", "out-2.txt": "aaa\nbbb\n", "out-3.txt": "ccc\n",
+		}},
+		{policy: ExistsSkip, contents: map[string]string{"out.txt": "old\nold\n", "out-1.txt": "old\n"}},
+	} {
+		t.Run(string(tcase.policy), func(t *testing.T) {
+			bkt := newBucket(t)
+			e := New(lineEncoder{}, "out.txt", bkt, WithOutputLimit(8, true), WithExistsPolicy(log.NewNopLogger(), tcase.policy))
+			testutil.Ok(t, e.Export(ctx, df))
+			var paths []string
+			for _, o := range e.Outputs() {
+				paths = append(paths, o.Path)
+			}
+			testutil.Equals(t, tcase.expected, paths)
+			for name, c := range tcase.contents {
+				testutil.Equals(t, c, content(t, bkt, name))
+			}
+		})
+	}
+
+	// Fails by default, before writing anything.
+	bkt := newBucket(t)
+	e := New(lineEncoder{}, "out.txt", bkt, WithExistsPolicy(log.NewNopLogger(), ""))
+	err := e.Export(ctx, df)
+	testutil.NotOk(t, err)
+	testutil.Equals(t, ErrOutputExists, errors.Cause(err))
+	testutil.Equals(t, "old\nold\n", content(t, bkt, "out.txt"))
+
+	// The files written by the exporter itself are overwritten.
+	e = New(lineEncoder{}, "new.txt", bkt, WithExistsPolicy(log.NewNopLogger(), ExistsFail))
+	testutil.Ok(t, e.Export(ctx, df))
+	testutil.Ok(t, e.Export(ctx, df[:1]))
+	testutil.Equals(t, "aaa\n", content(t, bkt, "new.txt"))
+
+	testutil.NotOk(t, ExistsPolicy("replace").Validate())
+}
+
+// raggedDataframe has rows of series with different label sets.
+type raggedDataframe []dataframe.Row
+
+func (d raggedDataframe) Schema() dataframe.Schema {
+	return dataframe.Schema{
+		{Name: "instance", Type: dataframe.TypeString},
+		{Name: "job", Type: dataframe.TypeString},
+		{Name: "_sum", Type: dataframe.TypeFloat},
+	}
+}
+
+func (d raggedDataframe) RowsIterator() dataframe.RowsIterator { return &testRows{rows: d} }
+
+func TestFillMissingLabels(t *testing.T) {
+	ctx := context.Background()
+	df := func() raggedDataframe {
+		return raggedDataframe{{"a", "x", 1.0}, {nil, "x", 2.0}, {"b", nil, nil}}
+	}
+
+	for _, tcase := range []struct {
+		fill     *string
+		expected string
+	}{
+		{expected: "a x 1\n<nil> x 2\nb <nil> <nil>\n"},
+		{fill: new(string), expected: "a x 1\n x 2\nb  <nil>\n"},
+		{fill: func() *string { s := "__missing__"; return &s }(), expected: "a x 1\n__missing__ x 2\nb __missing__ <nil>\n"},
+	} {
+		bkt := objstore.NewInMemBucket()
+		var w Writer = New(lineEncoder{}, "out.txt", bkt)
+		if tcase.fill != nil {
+			w = FillMissingLabels(w, *tcase.fill)
+		}
+		testutil.Ok(t, w.Export(ctx, df()))
+		testutil.Equals(t, []Output{{Path: "out.txt", Bytes: int64(len(tcase.expected))}}, w.Outputs())
+
+		r, err := bkt.Get(ctx, "out.txt")
+		testutil.Ok(t, err)
+		b, err := ioutil.ReadAll(r)
+		testutil.Ok(t, err)
+		// Only the label (string) columns are filled.
+		testutil.Equals(t, tcase.expected, string(b))
+	}
+
+	// The rows of the dataframe are not filled, e.g. for the other outputs.
+	d := df()
+	testutil.Ok(t, FillMissingLabels(New(lineEncoder{}, "out.txt", objstore.NewInMemBucket()), "__missing__").Export(ctx, d))
+	testutil.Equals(t, df(), d)
+}
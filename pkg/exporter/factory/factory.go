@@ -1,20 +1,237 @@
 package factory
 
 import (
+	"fmt"
 	"path"
 	"strings"
+	"sync"
 
 	"github.com/go-kit/kit/log"
 	"github.com/pkg/errors"
 	"github.com/thanos-community/obslytics/pkg/exporter"
+	"github.com/thanos-community/obslytics/pkg/exporter/bigquery"
+	"github.com/thanos-community/obslytics/pkg/exporter/duckdb"
+	"github.com/thanos-community/obslytics/pkg/exporter/hdf5"
+	"github.com/thanos-community/obslytics/pkg/exporter/kafka"
 	"github.com/thanos-community/obslytics/pkg/exporter/parquet"
+	"github.com/thanos-community/obslytics/pkg/exporter/promtext"
+	"github.com/thanos-community/obslytics/pkg/exporter/remotewrite"
 	"github.com/thanos-community/obslytics/pkg/version"
 	"github.com/thanos-io/thanos/pkg/objstore/client"
 	"gopkg.in/yaml.v2"
 )
 
-// NewExporter returns exporter based on configuration file.
-func NewExporter(logger log.Logger, cfg exporter.Config) (*exporter.Exporter, error) {
+// WriterFactory creates the exporter.Writer of the output configuration. The common options (e.g. timestamp unit,
+// output limit and missing label) are validated and applied by NewExporter.
+type WriterFactory func(logger log.Logger, cfg exporter.Config) (exporter.Writer, error)
+
+var (
+	writersMtx sync.RWMutex
+	writers    = map[exporter.Type]WriterFactory{}
+)
+
+// RegisterWriter makes the output type available by the name, e.g. for a custom sink registered from the init
+// function of its package. The type specific options are given by the config of the output configuration. The
+// names are case-insensitive. It panics if the name is registered already.
+func RegisterWriter(name string, f WriterFactory) {
+	writersMtx.Lock()
+	defer writersMtx.Unlock()
+
+	t := exporter.Type(strings.ToUpper(name))
+	if _, ok := writers[t]; ok {
+		panic(fmt.Sprintf("output type %s registered twice", t))
+	}
+	writers[t] = f
+}
+
+func init() {
+	RegisterWriter(string(exporter.PARQUET), newParquetWriter)
+	RegisterWriter(string(exporter.KAFKA), newKafkaWriter)
+	RegisterWriter(string(exporter.REMOTEWRITE), newRemoteWriteWriter)
+	RegisterWriter(string(exporter.BIGQUERY), newBigQueryWriter)
+	RegisterWriter(string(exporter.HDF5), newHDF5Writer)
+	RegisterWriter(string(exporter.PROMTEXT), newPromTextWriter)
+	RegisterWriter(string(exporter.DUCKDB), newDuckDBWriter)
+}
+
+// NewExporter returns exporter of the registered type of the configuration.
+func NewExporter(logger log.Logger, cfg exporter.Config) (exporter.Writer, error) {
+	if cfg.MissingLabel == nil {
+		return newWriter(logger, cfg)
+	}
+	switch t := exporter.Type(strings.ToUpper(string(cfg.Type))); t {
+	case exporter.REMOTEWRITE, exporter.BIGQUERY, exporter.HDF5, exporter.PROMTEXT:
+		return nil, errors.Errorf("missing_label is not supported by the %v output", t)
+	}
+	w, err := newWriter(logger, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return exporter.FillMissingLabels(w, *cfg.MissingLabel), nil
+}
+
+func newWriter(logger log.Logger, cfg exporter.Config) (exporter.Writer, error) {
+	if err := cfg.TimestampUnit.Validate(); err != nil {
+		return nil, err
+	}
+
+	if cfg.MaxOutputBytes < 0 {
+		return nil, errors.New("max_output_bytes cannot be negative")
+	}
+	if err := cfg.Retry.Validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.WriteBuffer.Validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.IfExists.Validate(); err != nil {
+		return nil, err
+	}
+	switch t := exporter.Type(strings.ToUpper(string(cfg.Type))); t {
+	case exporter.KAFKA, exporter.REMOTEWRITE, exporter.BIGQUERY:
+		if cfg.IfExists != "" {
+			return nil, errors.Errorf("if_exists is not supported by the %v output", t)
+		}
+	}
+
+	writersMtx.RLock()
+	f, ok := writers[exporter.Type(strings.ToUpper(string(cfg.Type)))]
+	writersMtx.RUnlock()
+	if !ok {
+		return nil, errors.Errorf("unsupported export type %v", cfg.Type)
+	}
+	return f(logger, cfg)
+}
+
+func newParquetWriter(logger log.Logger, cfg exporter.Config) (exporter.Writer, error) {
+	conf, err := yaml.Marshal(cfg.Config)
+	if err != nil {
+		return nil, errors.Wrap(err, "parquet configuration")
+	}
+	parquetConf, err := parquet.ParseConfig(conf)
+	if err != nil {
+		return nil, errors.Wrap(err, "parquet configuration")
+	}
+	if cfg.WriteBuffer.FlushOnSeries {
+		return nil, errors.New("write_buffer flush_on_series is not supported by the PARQUET output, the files are readable only once finalized")
+	}
+	return NewBucketExporter(logger, cfg, parquet.NewEncoder(cfg.TimestampUnit, parquetConf))
+}
+
+func newHDF5Writer(logger log.Logger, cfg exporter.Config) (exporter.Writer, error) {
+	conf, err := yaml.Marshal(cfg.Config)
+	if err != nil {
+		return nil, errors.Wrap(err, "hdf5 configuration")
+	}
+	hdf5Conf, err := hdf5.ParseConfig(conf)
+	if err != nil {
+		return nil, errors.Wrap(err, "hdf5 configuration")
+	}
+	if cfg.MaxOutputBytes > 0 || cfg.Validate {
+		return nil, errors.New("max_output_bytes and validate are not supported by the HDF5 output")
+	}
+	if cfg.WriteBuffer.FlushOnSeries {
+		return nil, errors.New("write_buffer flush_on_series is not supported by the HDF5 output, the files are readable only once finalized")
+	}
+	return NewBucketExporter(logger, cfg, hdf5.NewEncoder(cfg.TimestampUnit, hdf5Conf))
+}
+
+func newPromTextWriter(logger log.Logger, cfg exporter.Config) (exporter.Writer, error) {
+	conf, err := yaml.Marshal(cfg.Config)
+	if err != nil {
+		return nil, errors.Wrap(err, "prometheus text configuration")
+	}
+	textConf, err := promtext.ParseConfig(conf)
+	if err != nil {
+		return nil, errors.Wrap(err, "prometheus text configuration")
+	}
+	textConf.MetricColumn = cfg.MetricColumn
+	if cfg.MaxOutputBytes > 0 || cfg.Validate {
+		return nil, errors.New("max_output_bytes and validate are not supported by the PROMTEXT output")
+	}
+	return NewBucketExporter(logger, cfg, promtext.NewEncoder(textConf))
+}
+
+func newKafkaWriter(logger log.Logger, cfg exporter.Config) (exporter.Writer, error) {
+	conf, err := yaml.Marshal(cfg.Config)
+	if err != nil {
+		return nil, errors.Wrap(err, "kafka configuration")
+	}
+	kafkaConf, err := kafka.ParseConfig(conf)
+	if err != nil {
+		return nil, errors.Wrap(err, "kafka configuration")
+	}
+	if cfg.RollOver || cfg.Validate || cfg.Checksum {
+		return nil, errors.New("roll_over, validate and checksum are not supported by the KAFKA output")
+	}
+	return kafka.NewWriter(logger, kafkaConf, cfg.TimestampUnit, cfg.MaxOutputBytes, cfg.Retry)
+}
+
+func newRemoteWriteWriter(logger log.Logger, cfg exporter.Config) (exporter.Writer, error) {
+	conf, err := yaml.Marshal(cfg.Config)
+	if err != nil {
+		return nil, errors.Wrap(err, "remote write configuration")
+	}
+	rwConf, err := remotewrite.ParseConfig(conf)
+	if err != nil {
+		return nil, errors.Wrap(err, "remote write configuration")
+	}
+	rwConf.MetricColumn = cfg.MetricColumn
+	if cfg.RollOver || cfg.Validate || cfg.Checksum {
+		return nil, errors.New("roll_over, validate and checksum are not supported by the REMOTEWRITE output")
+	}
+	if cfg.Retry != (exporter.RetryConfig{}) {
+		return nil, errors.New("retry is not supported by the REMOTEWRITE output, use its max_retries, min_backoff and max_backoff")
+	}
+	return remotewrite.NewWriter(logger, rwConf, cfg.MaxOutputBytes), nil
+}
+
+func newBigQueryWriter(logger log.Logger, cfg exporter.Config) (exporter.Writer, error) {
+	conf, err := yaml.Marshal(cfg.Config)
+	if err != nil {
+		return nil, errors.Wrap(err, "bigquery configuration")
+	}
+	bqConf, err := bigquery.ParseConfig(conf)
+	if err != nil {
+		return nil, errors.Wrap(err, "bigquery configuration")
+	}
+	if cfg.RollOver || cfg.Validate || cfg.Checksum {
+		return nil, errors.New("roll_over, validate and checksum are not supported by the BIGQUERY output")
+	}
+	if cfg.Retry != (exporter.RetryConfig{}) {
+		return nil, errors.New("retry is not supported by the BIGQUERY output, use its max_retries, min_backoff and max_backoff")
+	}
+	return bigquery.NewWriter(logger, bqConf, cfg.MaxOutputBytes), nil
+}
+
+func newDuckDBWriter(logger log.Logger, cfg exporter.Config) (exporter.Writer, error) {
+	conf, err := yaml.Marshal(cfg.Config)
+	if err != nil {
+		return nil, errors.Wrap(err, "duckdb configuration")
+	}
+	duckConf, err := duckdb.ParseConfig(conf)
+	if err != nil {
+		return nil, errors.Wrap(err, "duckdb configuration")
+	}
+	if cfg.Storage.Type != "" {
+		return nil, errors.New("storage is not supported by the DUCKDB output, path is the local database file")
+	}
+	if cfg.MaxOutputBytes > 0 || cfg.RollOver || cfg.Validate || cfg.Checksum || cfg.Retry.Enabled() {
+		return nil, errors.New("max_output_bytes, roll_over, validate, checksum and retry are not supported by the DUCKDB output")
+	}
+	if cfg.Path == "" {
+		return nil, errors.New("path of the database file is required by the DUCKDB output")
+	}
+	if duckConf.Append && cfg.IfExists != "" && cfg.IfExists != exporter.ExistsAppend {
+		return nil, errors.Errorf("append of the DUCKDB output conflicts with if_exists %s", cfg.IfExists)
+	}
+	return duckdb.NewWriter(logger, duckConf, cfg.Path, cfg.TimestampUnit, cfg.IfExists), nil
+}
+
+// NewBucketExporter returns the writer uploading the files encoded by the encoder into the object storage of the
+// output configuration, with its output limit, validation, checksum, retry and if_exists options. Custom file formats
+// registered by RegisterWriter can use it with their own encoder.
+func NewBucketExporter(logger log.Logger, cfg exporter.Config, e exporter.Encoder) (exporter.Writer, error) {
 	storageConf, err := yaml.Marshal(cfg.Storage)
 	if err != nil {
 		return nil, errors.Wrap(err, "storage configuration")
@@ -23,14 +240,19 @@ func NewExporter(logger log.Logger, cfg exporter.Config) (*exporter.Exporter, er
 	if err != nil {
 		return nil, errors.Wrap(err, "creating storage")
 	}
-
-	var e exporter.Encoder
-	switch exporter.Type(strings.ToUpper(string(cfg.Type))) {
-	case exporter.PARQUET:
-		e = parquet.NewEncoder()
-	default:
-		return nil, errors.Errorf("unsupported export type %v", cfg.Type)
+	opts := []exporter.Option{
+		exporter.WithOutputLimit(cfg.MaxOutputBytes, cfg.RollOver),
+		exporter.WithWriteBuffer(cfg.WriteBuffer),
+		exporter.WithExistsPolicy(logger, cfg.IfExists),
 	}
-
-	return exporter.New(e, cfg.Path, bkt), nil
+	if cfg.Validate {
+		opts = append(opts, exporter.WithValidation())
+	}
+	if cfg.Checksum {
+		opts = append(opts, exporter.WithChecksum())
+	}
+	if cfg.Retry.Enabled() {
+		opts = append(opts, exporter.WithRetry(logger, cfg.Retry))
+	}
+	return exporter.New(e, cfg.Path, bkt, opts...), nil
 }
@@ -0,0 +1,63 @@
+package factory
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/thanos-community/obslytics/pkg/dataframe"
+	"github.com/thanos-community/obslytics/pkg/exporter"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+type customWriter struct{ conf interface{} }
+
+func (customWriter) Export(context.Context, dataframe.Dataframe) error { return nil }
+
+func (customWriter) Outputs() []exporter.Output { return nil }
+
+func TestRegisterWriter(t *testing.T) {
+	RegisterWriter("custom", func(_ log.Logger, cfg exporter.Config) (exporter.Writer, error) {
+		return customWriter{conf: cfg.Config}, nil
+	})
+
+	w, err := NewExporter(log.NewNopLogger(), exporter.Config{Type: "Custom", Config: map[string]string{"a": "b"}})
+	testutil.Ok(t, err)
+	testutil.Equals(t, customWriter{conf: map[string]string{"a": "b"}}, w)
+
+	// The common options are validated for the registered types too.
+	_, err = NewExporter(log.NewNopLogger(), exporter.Config{Type: "custom", MaxOutputBytes: -1})
+	testutil.NotOk(t, err)
+
+	// Flushing on the series boundaries is supported by the formats readable while written only.
+	for _, typ := range []exporter.Type{exporter.PARQUET, exporter.HDF5} {
+		_, err = NewExporter(log.NewNopLogger(), exporter.Config{Type: typ, WriteBuffer: exporter.BufferConfig{FlushOnSeries: true}})
+		testutil.NotOk(t, err)
+	}
+
+	// The exists policy applies to the files and the DUCKDB tables only.
+	_, err = NewExporter(log.NewNopLogger(), exporter.Config{Type: exporter.KAFKA, IfExists: exporter.ExistsOverwrite})
+	testutil.NotOk(t, err)
+	_, err = NewExporter(log.NewNopLogger(), exporter.Config{
+		Type:     exporter.DUCKDB,
+		Path:     "out.duckdb",
+		IfExists: exporter.ExistsOverwrite,
+		Config:   map[string]interface{}{},
+	})
+	testutil.Ok(t, err)
+	_, err = NewExporter(log.NewNopLogger(), exporter.Config{
+		Type:     exporter.DUCKDB,
+		Path:     "out.duckdb",
+		IfExists: exporter.ExistsOverwrite,
+		Config:   map[string]interface{}{"append": true},
+	})
+	testutil.NotOk(t, err)
+	_, err = NewExporter(log.NewNopLogger(), exporter.Config{Type: exporter.PARQUET, IfExists: "replace"})
+	testutil.NotOk(t, err)
+
+	_, err = NewExporter(log.NewNopLogger(), exporter.Config{Type: "missing"})
+	testutil.NotOk(t, err)
+
+	defer func() { testutil.Assert(t, recover() != nil, "expected panic on duplicate registration") }()
+	RegisterWriter(string(exporter.PARQUET), nil)
+}
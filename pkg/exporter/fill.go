@@ -0,0 +1,63 @@
+package exporter
+
+import (
+	"context"
+
+	"github.com/thanos-community/obslytics/pkg/dataframe"
+)
+
+// FillMissingLabels returns the writer exporting the dataframes with the missing label values (nil values of
+// string columns), e.g. of series without some of the labels, replaced by the fill, e.g. "" or "__missing__".
+// Without it, the missing labels are written as null.
+func FillMissingLabels(w Writer, fill string) Writer {
+	return &fillingWriter{Writer: w, fill: fill}
+}
+
+type fillingWriter struct {
+	Writer
+
+	fill string
+}
+
+func (w *fillingWriter) Export(ctx context.Context, df dataframe.Dataframe) error {
+	return w.Writer.Export(ctx, filledDataframe{Dataframe: df, fill: w.fill})
+}
+
+type filledDataframe struct {
+	dataframe.Dataframe
+
+	fill string
+}
+
+// Unwrap returns the dataframe without the missing labels filled, e.g. to be split by series. The rows of the split
+// dataframes are not filled, the outputs supporting the fill read the rows of the filled dataframe.
+func (d filledDataframe) Unwrap() dataframe.Dataframe { return d.Dataframe }
+
+func (d filledDataframe) RowsIterator() dataframe.RowsIterator {
+	return &filledRows{RowsIterator: d.Dataframe.RowsIterator(), schema: d.Schema(), fill: d.fill}
+}
+
+type filledRows struct {
+	dataframe.RowsIterator
+
+	schema dataframe.Schema
+	fill   string
+}
+
+// At returns the row with the missing labels filled. The rows of the dataframe are not modified, as they might be
+// exported again, e.g. by the other outputs of a tee: the filled row is a copy.
+func (r *filledRows) At() dataframe.Row {
+	row := r.RowsIterator.At()
+	copied := false
+	for i, c := range r.schema {
+		if c.Type != dataframe.TypeString || row[i] != nil {
+			continue
+		}
+		if !copied {
+			row = append(dataframe.Row(nil), row...)
+			copied = true
+		}
+		row[i] = r.fill
+	}
+	return row
+}
@@ -0,0 +1,509 @@
+package hdf5
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"math/bits"
+
+	"github.com/pkg/errors"
+)
+
+// The subset of the HDF5 file format (https://support.hdfgroup.org/HDF5/doc/H5.format.html) written by the encoder:
+// superblock version 2 with 8 byte offsets and lengths, version 2 object headers, compact (link message) groups,
+// contiguous or chunked datasets indexed by a version 1 B-tree and compact attributes. Readable by HDF5 1.8 and later.
+
+const (
+	// undefAddr is the undefined address, e.g. of the absent superblock extension.
+	undefAddr = ^uint64(0)
+
+	superblockSize = 48
+
+	// chunkBTreeK is the default K of the chunk B-tree nodes, used by the library with superblock version 2.
+	// Every node is stored with space for 2K children.
+	chunkBTreeK = 32
+)
+
+var signature = []byte("\x89HDF\r\n\x1a\n")
+
+// Header message types.
+const (
+	msgDataspace      = 0x01
+	msgLinkInfo       = 0x02
+	msgDatatype       = 0x03
+	msgFillValue      = 0x05
+	msgLink           = 0x06
+	msgDataLayout     = 0x08
+	msgGroupInfo      = 0x0A
+	msgFilterPipeline = 0x0B
+	msgAttribute      = 0x0C
+)
+
+// Datatype classes.
+const (
+	classFixed    = 0
+	classFloat    = 1
+	classString   = 3
+	classCompound = 6
+)
+
+// buffer appends little-endian encoded values.
+type buffer []byte
+
+func (b *buffer) u8(v uint8) { *b = append(*b, v) }
+
+func (b *buffer) u16(v uint16) { *b = append(*b, byte(v), byte(v>>8)) }
+
+func (b *buffer) u32(v uint32) {
+	*b = append(*b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func (b *buffer) u64(v uint64) {
+	var s [8]byte
+	binary.LittleEndian.PutUint64(s[:], v)
+	*b = append(*b, s[:]...)
+}
+
+// uvar appends the value in n bytes.
+func (b *buffer) uvar(v uint64, n int) {
+	for i := 0; i < n; i++ {
+		b.u8(byte(v >> (8 * i)))
+	}
+}
+
+func (b *buffer) bytes(p []byte) { *b = append(*b, p...) }
+
+// sizeBytes returns the number of bytes needed to encode the value, as by the variable sized fields of the format.
+func sizeBytes(v uint64) int {
+	if v == 0 {
+		return 1
+	}
+	return (bits.Len64(v)-1)/8 + 1
+}
+
+// fieldBytes returns the size of the 1, 2, 4 or 8 byte field the value fits in, flagged by the log2 of the size.
+func fieldBytes(v uint64) int {
+	switch n := sizeBytes(v); {
+	case n <= 2:
+		return n
+	case n <= 4:
+		return 4
+	}
+	return 8
+}
+
+// datatype is an encoded datatype message.
+type datatype struct {
+	enc  []byte
+	size uint32
+}
+
+func fixedType(size uint32, signed bool, version uint8) datatype {
+	var b buffer
+	b.u8(classFixed | version<<4)
+	var flags uint8
+	if signed {
+		flags |= 0x08
+	}
+	b.bytes([]byte{flags, 0, 0})
+	b.u32(size)
+	b.u16(0)
+	b.u16(uint16(size * 8))
+	return datatype{enc: b, size: size}
+}
+
+// float64Type is the IEEE 754 double precision little-endian type.
+func float64Type(version uint8) datatype {
+	var b buffer
+	b.u8(classFloat | version<<4)
+	// Implied most significant mantissa bit, sign at bit 63.
+	b.bytes([]byte{0x20, 63, 0})
+	b.u32(8)
+	b.u16(0)
+	b.u16(64)
+	b.bytes([]byte{52, 11, 0, 52})
+	b.u32(1023)
+	return datatype{enc: b, size: 8}
+}
+
+// stringType is the fixed length, null padded UTF-8 string type.
+func stringType(size uint32) datatype {
+	var b buffer
+	b.u8(classString | 1<<4)
+	b.bytes([]byte{0x01 | 0x01<<4, 0, 0})
+	b.u32(size)
+	return datatype{enc: b, size: size}
+}
+
+// member is a member of the compound type.
+type member struct {
+	name string
+	typ  datatype
+}
+
+// compoundType returns the version 3 compound type of the members packed in order.
+func compoundType(members []member) datatype {
+	var size uint32
+	for _, m := range members {
+		size += m.typ.size
+	}
+	var b buffer
+	b.u8(classCompound | 3<<4)
+	b.u16(uint16(len(members)))
+	b.u8(0)
+	b.u32(size)
+
+	var offset uint32
+	for _, m := range members {
+		b.bytes([]byte(m.name))
+		b.u8(0)
+		b.uvar(uint64(offset), sizeBytes(uint64(size)))
+		b.bytes(m.typ.enc)
+		offset += m.typ.size
+	}
+	return datatype{enc: b, size: size}
+}
+
+// message is a header message of an object.
+type message struct {
+	typ  uint8
+	data []byte
+}
+
+func dataspaceMessage(dims ...uint64) message {
+	var b buffer
+	// Scalar if there are no dimensions, simple otherwise.
+	typ := uint8(1)
+	if len(dims) == 0 {
+		typ = 0
+	}
+	b.bytes([]byte{2, uint8(len(dims)), 0, typ})
+	for _, d := range dims {
+		b.u64(d)
+	}
+	return message{typ: msgDataspace, data: b}
+}
+
+func datatypeMessage(t datatype) message { return message{typ: msgDatatype, data: t.enc} }
+
+// fillValueMessage keeps the default (zero) fill value, allocating the space late (contiguous) or incrementally
+// (chunked).
+func fillValueMessage(chunked bool) message {
+	flags := uint8(2)
+	if chunked {
+		flags = 3
+	}
+	// Fill value written if set by the user.
+	flags |= 2 << 2
+	return message{typ: msgFillValue, data: []byte{3, flags}}
+}
+
+func contiguousLayoutMessage(addr, size uint64) message {
+	b := buffer{3, 1}
+	b.u64(addr)
+	b.u64(size)
+	return message{typ: msgDataLayout, data: b}
+}
+
+func chunkedLayoutMessage(btree uint64, chunkRows, elemSize uint32) message {
+	b := buffer{3, 2, 2}
+	b.u64(btree)
+	b.u32(chunkRows)
+	b.u32(elemSize)
+	return message{typ: msgDataLayout, data: b}
+}
+
+// deflateMessage is the filter pipeline with the deflate (gzip) filter.
+func deflateMessage(level int) message {
+	b := buffer{2, 1}
+	b.u16(1)
+	b.u16(0)
+	b.u16(1)
+	b.u32(uint32(level))
+	return message{typ: msgFilterPipeline, data: b}
+}
+
+// attributeMessage returns the scalar string attribute.
+func attributeMessage(name, value string) (message, error) {
+	size := uint32(len(value))
+	if size == 0 {
+		size = 1
+	}
+	dt := stringType(size)
+	ds := dataspaceMessage().data
+
+	var b buffer
+	b.bytes([]byte{3, 0})
+	b.u16(uint16(len(name) + 1))
+	b.u16(uint16(len(dt.enc)))
+	b.u16(uint16(len(ds)))
+	// UTF-8 name.
+	b.u8(1)
+	b.bytes([]byte(name))
+	b.u8(0)
+	b.bytes(dt.enc)
+	b.bytes(ds)
+	b.bytes([]byte(value))
+	if len(value) == 0 {
+		b.u8(0)
+	}
+	if len(b) > 0xffff {
+		return message{}, errors.Errorf("attribute %s too long", name)
+	}
+	return message{typ: msgAttribute, data: b}, nil
+}
+
+// linkInfoMessage and groupInfoMessage make the object a group with links stored in the object header.
+func linkInfoMessage() message {
+	b := buffer{0, 0}
+	b.u64(undefAddr)
+	b.u64(undefAddr)
+	return message{typ: msgLinkInfo, data: b}
+}
+
+func groupInfoMessage() message { return message{typ: msgGroupInfo, data: []byte{0, 0}} }
+
+// linkMessage is the hard link of the name to the object header address.
+func linkMessage(name string, addr uint64) message {
+	n := fieldBytes(uint64(len(name)))
+	b := buffer{1, uint8(bits.TrailingZeros(uint(n)))}
+	b.uvar(uint64(len(name)), n)
+	b.bytes([]byte(name))
+	b.u64(addr)
+	return message{typ: msgLink, data: b}
+}
+
+// file assembles the HDF5 file in memory, as the superblock at the start of the file points to the root group and
+// the end of the file, known only once all the objects are written.
+type file struct {
+	buf buffer
+}
+
+func newFile() *file {
+	return &file{buf: make(buffer, superblockSize)}
+}
+
+func (f *file) addr() uint64 { return uint64(len(f.buf)) }
+
+// objectHeader writes the version 2 object header of the messages and returns its address.
+func (f *file) objectHeader(msgs []message) (uint64, error) {
+	var body buffer
+	for _, m := range msgs {
+		if len(m.data) > 0xffff {
+			return 0, errors.Errorf("header message of type %d too long", m.typ)
+		}
+		body.u8(m.typ)
+		body.u16(uint16(len(m.data)))
+		body.u8(0)
+		body.bytes(m.data)
+	}
+
+	n := fieldBytes(uint64(len(body)))
+	addr := f.addr()
+	var b buffer
+	b.bytes([]byte("OHDR"))
+	b.u8(2)
+	b.u8(uint8(bits.TrailingZeros(uint(n))))
+	b.uvar(uint64(len(body)), n)
+	b.bytes(body)
+	b.u32(lookup3(b, 0))
+	f.buf.bytes(b)
+	return addr, nil
+}
+
+// group writes the group of the links, by name, and returns its address.
+func (f *file) group(names []string, addrs []uint64) (uint64, error) {
+	msgs := []message{linkInfoMessage(), groupInfoMessage()}
+	for i, name := range names {
+		msgs = append(msgs, linkMessage(name, addrs[i]))
+	}
+	return f.objectHeader(msgs)
+}
+
+// dataset writes the one-dimensional dataset of the rows of the element type and returns its address. The data is
+// stored contiguously if chunkRows is 0, otherwise in chunks of chunkRows rows compressed by deflate of the level,
+// unless the level is 0.
+func (f *file) dataset(data []byte, rows uint64, dt datatype, chunkRows uint64, level int, attrs []message) (uint64, error) {
+	msgs := []message{dataspaceMessage(rows), datatypeMessage(dt), fillValueMessage(chunkRows > 0 && rows > 0)}
+	if chunkRows == 0 || rows == 0 {
+		msgs = append(msgs, contiguousLayoutMessage(f.addr(), uint64(len(data))))
+		f.buf.bytes(data)
+	} else {
+		if chunkRows > rows {
+			chunkRows = rows
+		}
+		btree, err := f.chunks(data, rows, chunkRows, uint64(dt.size), level)
+		if err != nil {
+			return 0, err
+		}
+		msgs = append(msgs, chunkedLayoutMessage(btree, uint32(chunkRows), dt.size))
+		if level > 0 {
+			msgs = append(msgs, deflateMessage(level))
+		}
+	}
+	return f.objectHeader(append(msgs, attrs...))
+}
+
+// chunkKey is the key of a chunk in the chunk B-tree.
+type chunkKey struct {
+	size   uint32
+	offset uint64
+}
+
+// chunks writes the chunks of the data and the B-tree indexing them, and returns the address of the B-tree root.
+func (f *file) chunks(data []byte, rows, chunkRows, elemSize uint64, level int) (uint64, error) {
+	var (
+		keys  []chunkKey
+		addrs []uint64
+		chunk = make([]byte, chunkRows*elemSize)
+	)
+	for off := uint64(0); off < rows; off += chunkRows {
+		// Edge chunks are stored whole, padded with the fill value.
+		n := copy(chunk, data[off*elemSize:])
+		for i := n; i < len(chunk); i++ {
+			chunk[i] = 0
+		}
+
+		stored := chunk
+		if level > 0 {
+			var b bytes.Buffer
+			zw, err := zlib.NewWriterLevel(&b, level)
+			if err != nil {
+				return 0, err
+			}
+			if _, err := zw.Write(chunk); err != nil {
+				return 0, err
+			}
+			if err := zw.Close(); err != nil {
+				return 0, err
+			}
+			stored = b.Bytes()
+		}
+		keys = append(keys, chunkKey{size: uint32(len(stored)), offset: off})
+		addrs = append(addrs, f.addr())
+		f.buf.bytes(stored)
+	}
+
+	// The right bound of the last chunk.
+	end := chunkKey{offset: uint64(len(keys)) * chunkRows}
+	for level := uint8(0); ; level++ {
+		var (
+			parentKeys  []chunkKey
+			parentAddrs []uint64
+		)
+		for i := 0; i < len(keys); i += 2 * chunkBTreeK {
+			j := i + 2*chunkBTreeK
+			right := end
+			if j < len(keys) {
+				right = keys[j]
+			} else {
+				j = len(keys)
+			}
+			parentKeys = append(parentKeys, keys[i])
+			parentAddrs = append(parentAddrs, f.btreeNode(level, append(keys[i:j:j], right), addrs[i:j]))
+		}
+		if len(parentAddrs) == 1 {
+			return parentAddrs[0], nil
+		}
+		keys, addrs = parentKeys, parentAddrs
+	}
+}
+
+// btreeNode writes the version 1 B-tree node of the chunks (or child nodes) with the keys bounding them and returns
+// its address.
+func (f *file) btreeNode(level uint8, keys []chunkKey, children []uint64) uint64 {
+	const keySize = 4 + 4 + 2*8
+	addr := f.addr()
+	var b buffer
+	b.bytes([]byte("TREE"))
+	// Raw data chunks node.
+	b.u8(1)
+	b.u8(level)
+	b.u16(uint16(len(children)))
+	b.u64(undefAddr)
+	b.u64(undefAddr)
+	for i, k := range keys {
+		b.u32(k.size)
+		// Filter mask, all filters applied.
+		b.u32(0)
+		b.u64(k.offset)
+		b.u64(0)
+		if i < len(children) {
+			b.u64(children[i])
+		}
+	}
+	size := 24 + 2*chunkBTreeK*8 + (2*chunkBTreeK+1)*keySize
+	b.bytes(make([]byte, size-len(b)))
+	f.buf.bytes(b)
+	return addr
+}
+
+// finish writes the superblock pointing to the root group and returns the file.
+func (f *file) finish(root uint64) []byte {
+	var b buffer
+	b.bytes(signature)
+	// Superblock version, size of offsets and lengths, file consistency flags.
+	b.bytes([]byte{2, 8, 8, 0})
+	// Base address, superblock extension, end of file and root group object header addresses.
+	b.u64(0)
+	b.u64(undefAddr)
+	b.u64(f.addr())
+	b.u64(root)
+	b.u32(lookup3(b, 0))
+	copy(f.buf, b)
+	return f.buf
+}
+
+// lookup3 is the Jenkins lookup3 hash (hashlittle) of the checksums of the metadata.
+func lookup3(k []byte, initval uint32) uint32 {
+	a := 0xdeadbeef + uint32(len(k)) + initval
+	b, c := a, a
+	for len(k) > 12 {
+		a += binary.LittleEndian.Uint32(k)
+		b += binary.LittleEndian.Uint32(k[4:])
+		c += binary.LittleEndian.Uint32(k[8:])
+		a -= c
+		a ^= bits.RotateLeft32(c, 4)
+		c += b
+		b -= a
+		b ^= bits.RotateLeft32(a, 6)
+		a += c
+		c -= b
+		c ^= bits.RotateLeft32(b, 8)
+		b += a
+		a -= c
+		a ^= bits.RotateLeft32(c, 16)
+		c += b
+		b -= a
+		b ^= bits.RotateLeft32(a, 19)
+		a += c
+		c -= b
+		c ^= bits.RotateLeft32(b, 4)
+		b += a
+		k = k[12:]
+	}
+	if len(k) == 0 {
+		return c
+	}
+	var tail [12]byte
+	copy(tail[:], k)
+	a += binary.LittleEndian.Uint32(tail[:])
+	b += binary.LittleEndian.Uint32(tail[4:])
+	c += binary.LittleEndian.Uint32(tail[8:])
+
+	c ^= b
+	c -= bits.RotateLeft32(b, 14)
+	a ^= c
+	a -= bits.RotateLeft32(c, 11)
+	b ^= a
+	b -= bits.RotateLeft32(a, 25)
+	c ^= b
+	c -= bits.RotateLeft32(b, 16)
+	a ^= c
+	a -= bits.RotateLeft32(c, 4)
+	b ^= a
+	b -= bits.RotateLeft32(a, 14)
+	c ^= b
+	c -= bits.RotateLeft32(b, 24)
+	return c
+}
@@ -0,0 +1,207 @@
+package hdf5
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/thanos-community/obslytics/pkg/dataframe"
+	"github.com/thanos-community/obslytics/pkg/exporter"
+	"gopkg.in/yaml.v2"
+)
+
+// Compile-time check if HDF5 Encoder implements exporter.Encoder interface.
+var _ exporter.Encoder = &Encoder{}
+
+// defaultChunkRows is the number of rows of the chunks of the compressed datasets, unless configured.
+const defaultChunkRows = 1024
+
+// Config contains the options of the HDF5 encoding, given by the HDF5 output config.
+type Config struct {
+	// ChunkRows is the number of rows of every dataset chunk. The datasets are stored contiguously if 0, unless
+	// compressed, in which case the chunks have 1024 rows.
+	ChunkRows int `yaml:"chunk_rows"`
+	// Compression is the filter compressing the chunks: none (default) or gzip.
+	Compression string `yaml:"compression"`
+	// CompressionLevel is the gzip level from 1 (fastest) to 9 (best), 4 by default as by h5py.
+	CompressionLevel int `yaml:"compression_level"`
+}
+
+// ParseConfig parses the YAML HDF5 configuration.
+func ParseConfig(conf []byte) (Config, error) {
+	config := Config{}
+	if err := yaml.UnmarshalStrict(conf, &config); err != nil {
+		return Config{}, err
+	}
+	if config.ChunkRows < 0 {
+		return Config{}, errors.New("chunk_rows cannot be negative")
+	}
+	switch strings.ToLower(config.Compression) {
+	case "", "none":
+		if config.CompressionLevel != 0 {
+			return Config{}, errors.New("compression_level requires gzip compression")
+		}
+	case "gzip":
+		if config.CompressionLevel < 0 || config.CompressionLevel > 9 {
+			return Config{}, errors.Errorf("compression_level has to be from 1 to 9, got %d", config.CompressionLevel)
+		}
+	default:
+		return Config{}, errors.Errorf("unsupported compression %q, expected gzip or none", config.Compression)
+	}
+	return config, nil
+}
+
+// Encoder writes the dataframe into an HDF5 file with a group per metric name and a dataset per series, named by the
+// hex encoded series fingerprint, e.g. /up/5f3a2b1c0d9e8f7a. The dataset is a one-dimensional array of the rows
+// of the series, of a compound type with the non-label columns as members (timestamps as 64-bit integers in the
+// time unit, counts as unsigned and values as 64-bit floats). The labels of the series (including __name__) are
+// string attributes of the dataset. Series without metric name are stored in the root group.
+//
+// The file is assembled in memory before it's written, as the HDF5 superblock at the start of the file points to its
+// end.
+type Encoder struct {
+	timeUnit  exporter.TimeUnit
+	chunkRows uint64
+	level     int
+}
+
+// NewEncoder returns HDF5 encoder writing timestamps in the given unit. The config is expected to be validated by
+// ParseConfig, zero values select the defaults.
+func NewEncoder(timeUnit exporter.TimeUnit, conf Config) *Encoder {
+	e := &Encoder{timeUnit: timeUnit, chunkRows: uint64(conf.ChunkRows)}
+	if strings.ToLower(conf.Compression) == "gzip" {
+		e.level = conf.CompressionLevel
+		if e.level == 0 {
+			e.level = 4
+		}
+		if e.chunkRows == 0 {
+			e.chunkRows = defaultChunkRows
+		}
+	}
+	return e
+}
+
+func (e *Encoder) Encode(w io.Writer, df dataframe.Dataframe) error {
+	sdfs, ok := dataframe.SplitSeries(df)
+	if !ok {
+		return errors.New("HDF5 output requires the dataframe of series, summaries, shard-merge and read-window are not supported")
+	}
+
+	var (
+		schema  = df.Schema()
+		members []member
+		columns []int
+	)
+	for i, c := range schema {
+		var t datatype
+		switch c.Type {
+		case dataframe.TypeString:
+			// Labels are stored as attributes.
+			continue
+		case dataframe.TypeTime:
+			t = fixedType(8, true, 3)
+		case dataframe.TypeUint:
+			t = fixedType(8, false, 3)
+		case dataframe.TypeFloat:
+			t = float64Type(3)
+		default:
+			return errors.Errorf("unsupported type %v of column %s", c.Type, c.Name)
+		}
+		members = append(members, member{name: c.Name, typ: t})
+		columns = append(columns, i)
+	}
+	dt := compoundType(members)
+
+	var (
+		f       = newFile()
+		metrics = map[string]*links{}
+		root    = &links{}
+	)
+	for _, sdf := range sdfs {
+		var (
+			data []byte
+			rows uint64
+		)
+		for i := sdf.RowsIterator(); i.Next(); rows++ {
+			row := i.At()
+			for _, c := range columns {
+				data = e.appendValue(data, row[c])
+			}
+		}
+
+		attrs := make([]message, 0, len(sdf.Labels))
+		for _, l := range sdf.Labels {
+			m, err := attributeMessage(l.Name, l.Value)
+			if err != nil {
+				return err
+			}
+			attrs = append(attrs, m)
+		}
+		addr, err := f.dataset(data, rows, dt, e.chunkRows, e.level, attrs)
+		if err != nil {
+			return errors.Wrapf(err, "writing series %s", sdf.Labels)
+		}
+
+		l := root
+		if name := sdf.Labels.Get(labels.MetricName); name != "" {
+			if l = metrics[name]; l == nil {
+				l = &links{}
+				metrics[name] = l
+			}
+		}
+		l.add(fmt.Sprintf("%016x", sdf.Fingerprint), addr)
+	}
+
+	names := make([]string, 0, len(metrics))
+	for name := range metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		addr, err := f.group(metrics[name].names, metrics[name].addrs)
+		if err != nil {
+			return errors.Wrapf(err, "writing group %s", name)
+		}
+		root.add(name, addr)
+	}
+	addr, err := f.group(root.names, root.addrs)
+	if err != nil {
+		return errors.Wrap(err, "writing root group")
+	}
+
+	_, err = w.Write(f.finish(addr))
+	return err
+}
+
+// appendValue appends the little-endian encoded value of the cell.
+func (e *Encoder) appendValue(b []byte, cell interface{}) []byte {
+	var v uint64
+	switch c := cell.(type) {
+	case time.Time:
+		v = uint64(e.timeUnit.FromTime(c))
+	case uint64:
+		v = c
+	case float64:
+		v = math.Float64bits(c)
+	}
+	var s [8]byte
+	binary.LittleEndian.PutUint64(s[:], v)
+	return append(b, s[:]...)
+}
+
+// links are the links of a group, in order.
+type links struct {
+	names []string
+	addrs []uint64
+}
+
+func (l *links) add(name string, addr uint64) {
+	l.names = append(l.names, name)
+	l.addrs = append(l.addrs, addr)
+}
@@ -0,0 +1,394 @@
+package hdf5
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/tsdbutil"
+	"github.com/thanos-community/obslytics/pkg/dataframe"
+	"github.com/thanos-community/obslytics/pkg/exporter"
+	"github.com/thanos-community/obslytics/pkg/series/memory"
+	"github.com/thanos-io/thanos/pkg/objstore"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestLookup3(t *testing.T) {
+	testutil.Equals(t, uint32(0xdeadbeef), lookup3(nil, 0))
+	testutil.Equals(t, uint32(0x17770551), lookup3([]byte("Four score and seven years ago"), 0))
+	testutil.Equals(t, uint32(0xcd628161), lookup3([]byte("Four score and seven years ago"), 1))
+}
+
+type sample struct {
+	t int64
+	v float64
+}
+
+func (s sample) T() int64   { return s.t }
+func (s sample) V() float64 { return s.v }
+
+// object is a parsed object header, its messages by type.
+type object map[uint8][][]byte
+
+// reader reads the files written by the encoder back, checking the checksums of the metadata.
+type reader struct {
+	t    *testing.T
+	data []byte
+}
+
+func (r reader) root() uint64 {
+	testutil.Equals(r.t, signature, r.data[:8])
+	testutil.Equals(r.t, byte(2), r.data[8])
+	testutil.Equals(r.t, lookup3(r.data[:44], 0), binary.LittleEndian.Uint32(r.data[44:]))
+	testutil.Equals(r.t, uint64(len(r.data)), binary.LittleEndian.Uint64(r.data[28:]))
+	return binary.LittleEndian.Uint64(r.data[36:])
+}
+
+func (r reader) object(addr uint64) object {
+	d := r.data[addr:]
+	testutil.Equals(r.t, "OHDR", string(d[:4]))
+	testutil.Equals(r.t, byte(2), d[4])
+	n := 1 << (d[5] & 3)
+	size := uvar(d[6 : 6+n])
+	end := 6 + n + int(size)
+	testutil.Equals(r.t, lookup3(d[:end], 0), binary.LittleEndian.Uint32(d[end:]))
+
+	o := object{}
+	for p := d[6+n : end]; len(p) > 0; {
+		typ, l := p[0], int(binary.LittleEndian.Uint16(p[1:]))
+		o[typ] = append(o[typ], p[4:4+l])
+		p = p[4+l:]
+	}
+	return o
+}
+
+// links returns the links of the group object.
+func (r reader) links(o object) map[string]uint64 {
+	testutil.Equals(r.t, 1, len(o[msgLinkInfo]))
+	testutil.Equals(r.t, 1, len(o[msgGroupInfo]))
+	ret := map[string]uint64{}
+	for _, m := range o[msgLink] {
+		testutil.Equals(r.t, byte(1), m[0])
+		n := 1 << (m[1] & 3)
+		l := int(uvar(m[2 : 2+n]))
+		ret[string(m[2+n:2+n+l])] = binary.LittleEndian.Uint64(m[2+n+l:])
+	}
+	return ret
+}
+
+// dataset returns the members, rows and attributes of the dataset object. The kinds of the members are their datatype
+// classes, with the signed flag of the fixed-point ones.
+func (r reader) dataset(o object) (members []string, rows [][]uint64, attrs map[string]string, kinds []byte) {
+	ds := o[msgDataspace][0]
+	testutil.Equals(r.t, []byte{2, 1, 0, 1}, ds[:4])
+	n := binary.LittleEndian.Uint64(ds[4:])
+
+	dt := o[msgDatatype][0]
+	testutil.Equals(r.t, byte(classCompound|3<<4), dt[0])
+	size := uint64(binary.LittleEndian.Uint32(dt[4:]))
+	var offsets []uint64
+	for p, i := dt[8:], 0; i < int(binary.LittleEndian.Uint16(dt[1:])); i++ {
+		name := string(p[:bytes.IndexByte(p, 0)])
+		p = p[len(name)+1:]
+		offsets = append(offsets, uvar(p[:sizeBytes(size)]))
+		p = p[sizeBytes(size):]
+		testutil.Equals(r.t, uint32(8), binary.LittleEndian.Uint32(p[4:]))
+		members = append(members, name)
+		kinds = append(kinds, p[0]&0x0f|p[1]&0x08)
+		if p[0]&0x0f == classFloat {
+			p = p[20:]
+		} else {
+			p = p[12:]
+		}
+	}
+
+	var data []byte
+	layout := o[msgDataLayout][0]
+	switch layout[1] {
+	case 1:
+		addr, l := binary.LittleEndian.Uint64(layout[2:]), binary.LittleEndian.Uint64(layout[10:])
+		data = r.data[addr : addr+l]
+	case 2:
+		chunkRows := uint64(binary.LittleEndian.Uint32(layout[11:]))
+		testutil.Equals(r.t, size, uint64(binary.LittleEndian.Uint32(layout[15:])))
+		data = make([]byte, (n/chunkRows+1)*chunkRows*size)
+		r.chunks(binary.LittleEndian.Uint64(layout[3:]), len(o[msgFilterPipeline]) > 0, chunkRows, size, data)
+	}
+	for i := uint64(0); i < n; i++ {
+		var row []uint64
+		for _, off := range offsets {
+			row = append(row, binary.LittleEndian.Uint64(data[i*size+off:]))
+		}
+		rows = append(rows, row)
+	}
+
+	attrs = map[string]string{}
+	for _, a := range o[msgAttribute] {
+		testutil.Equals(r.t, byte(3), a[0])
+		nameSize := int(binary.LittleEndian.Uint16(a[2:]))
+		dtSize := int(binary.LittleEndian.Uint16(a[4:]))
+		dsSize := int(binary.LittleEndian.Uint16(a[6:]))
+		name := string(a[9 : 9+nameSize-1])
+		value := a[9+nameSize+dtSize+dsSize:]
+		testutil.Equals(r.t, int(binary.LittleEndian.Uint32(a[9+nameSize+4:])), len(value))
+		attrs[name] = strings.TrimRight(string(value), "\x00")
+	}
+	return members, rows, attrs, kinds
+}
+
+// chunks reads the chunks of the B-tree node into the data.
+func (r reader) chunks(addr uint64, deflate bool, chunkRows, rowSize uint64, data []byte) {
+	d := r.data[addr:]
+	testutil.Equals(r.t, "TREE", string(d[:4]))
+	level, entries := d[5], int(binary.LittleEndian.Uint16(d[6:]))
+	testutil.Assert(r.t, entries <= 2*chunkBTreeK)
+	for i := 0; i < entries; i++ {
+		key := d[24+i*32:]
+		child := binary.LittleEndian.Uint64(key[24:])
+		if level > 0 {
+			r.chunks(child, deflate, chunkRows, rowSize, data)
+			continue
+		}
+		chunk := r.data[child : child+uint64(binary.LittleEndian.Uint32(key))]
+		if deflate {
+			zr, err := zlib.NewReader(bytes.NewReader(chunk))
+			testutil.Ok(r.t, err)
+			chunk, err = ioutil.ReadAll(zr)
+			testutil.Ok(r.t, err)
+		}
+		testutil.Equals(r.t, chunkRows*rowSize, uint64(len(chunk)))
+		copy(data[binary.LittleEndian.Uint64(key[8:])*rowSize:], chunk)
+	}
+}
+
+func uvar(b []byte) uint64 {
+	var v uint64
+	for i := len(b) - 1; i >= 0; i-- {
+		v = v<<8 | uint64(b[i])
+	}
+	return v
+}
+
+// testDataframe returns the dataframe of a series of many rows, series of other metrics and a series without metric
+// name.
+func testDataframe(t *testing.T) dataframe.Dataframe {
+	var many []tsdbutil.Sample
+	for i := int64(0); i < 200; i++ {
+		many = append(many, sample{t: i * 60000, v: float64(i)})
+	}
+	df, err := dataframe.FromSeries(memory.NewSet(
+		storage.NewListSeries(labels.FromStrings("__name__", "up", "instance", "a"), many),
+		storage.NewListSeries(labels.FromStrings("__name__", "up", "instance", ""), []tsdbutil.Sample{sample{t: 0, v: 1}}),
+		storage.NewListSeries(labels.FromStrings("__name__", "down", "instance", "a"), []tsdbutil.Sample{sample{t: 0, v: 2}}),
+		storage.NewListSeries(labels.FromStrings("instance", "b"), []tsdbutil.Sample{sample{t: 0, v: 3}, sample{t: 1000, v: 4}}),
+	), time.Minute, func(o *dataframe.AggrsOptions) {
+		o.Count.Enabled = true
+		o.Sum.Enabled = true
+	})
+	testutil.Ok(t, err)
+	return df
+}
+
+func TestEncoder(t *testing.T) {
+	for _, conf := range []Config{
+		{},
+		{ChunkRows: 7},
+		// A B-tree of multiple levels.
+		{ChunkRows: 1, Compression: "gzip"},
+		{Compression: "gzip", CompressionLevel: 9},
+	} {
+		t.Run(fmt.Sprintf("%+v", conf), func(t *testing.T) {
+			df := testDataframe(t)
+			var b bytes.Buffer
+			testutil.Ok(t, NewEncoder(exporter.TimeUnitMilliseconds, conf).Encode(&b, df))
+			r := reader{t: t, data: b.Bytes()}
+
+			root := r.links(r.object(r.root()))
+			sdfs, ok := dataframe.SplitSeries(df)
+			testutil.Assert(t, ok)
+			testutil.Equals(t, 3, len(root))
+			for _, sdf := range sdfs {
+				links := root
+				if name := sdf.Labels.Get(labels.MetricName); name != "" {
+					links = r.links(r.object(root[name]))
+				}
+				addr, ok := links[fmt.Sprintf("%016x", sdf.Fingerprint)]
+				testutil.Assert(t, ok, "missing dataset of %s", sdf.Labels)
+
+				members, rows, attrs, _ := r.dataset(r.object(addr))
+				testutil.Equals(t, sdf.Labels.Map(), attrs)
+				testutil.Equals(t, []string{"_sample_start", "_sample_end", "_min_time", "_max_time", "_count", "_sum"}, members)
+
+				var exp [][]uint64
+				for i := sdf.RowsIterator(); i.Next(); {
+					row := i.At()
+					// Skipping the label column.
+					exp = append(exp, []uint64{
+						uint64(row[1].(time.Time).UnixNano() / 1e6),
+						uint64(row[2].(time.Time).UnixNano() / 1e6),
+						uint64(row[3].(time.Time).UnixNano() / 1e6),
+						uint64(row[4].(time.Time).UnixNano() / 1e6),
+						row[5].(uint64),
+						math.Float64bits(row[6].(float64)),
+					})
+				}
+				testutil.Equals(t, exp, rows)
+			}
+		})
+	}
+}
+
+// TestEncoder_RoundTrip reads the rows written by the exporter back into the values of the dataframe, typed by the
+// datatypes of the file. Unlike TestEncoder_HDF5Library, it runs without the HDF5 library.
+func TestEncoder_RoundTrip(t *testing.T) {
+	ctx := context.Background()
+	for _, unit := range []exporter.TimeUnit{exporter.TimeUnitMilliseconds, exporter.TimeUnitSeconds} {
+		t.Run(string(unit), func(t *testing.T) {
+			df := testDataframe(t)
+			bkt := objstore.NewInMemBucket()
+			testutil.Ok(t, exporter.New(NewEncoder(unit, Config{ChunkRows: 7, Compression: "gzip"}), "out.h5", bkt).Export(ctx, df))
+			rc, err := bkt.Get(ctx, "out.h5")
+			testutil.Ok(t, err)
+			data, err := ioutil.ReadAll(rc)
+			testutil.Ok(t, err)
+			testutil.Ok(t, rc.Close())
+
+			r := reader{t: t, data: data}
+			root := r.links(r.object(r.root()))
+			sdfs, ok := dataframe.SplitSeries(df)
+			testutil.Assert(t, ok)
+			for _, sdf := range sdfs {
+				links := root
+				if name := sdf.Labels.Get(labels.MetricName); name != "" {
+					links = r.links(r.object(root[name]))
+				}
+				members, rows, attrs, kinds := r.dataset(r.object(links[fmt.Sprintf("%016x", sdf.Fingerprint)]))
+				testutil.Equals(t, sdf.Labels.Map(), attrs)
+
+				var got []dataframe.Row
+				for _, row := range rows {
+					var values dataframe.Row
+					for j, v := range row {
+						switch kinds[j] {
+						case classFloat:
+							values = append(values, math.Float64frombits(v))
+						case classFixed | 0x08:
+							values = append(values, unit.Time(int64(v)))
+						default:
+							values = append(values, v)
+						}
+					}
+					got = append(got, values)
+				}
+
+				// The labels are the attributes, the timestamps are of the unit precision.
+				var (
+					columns []string
+					exp     []dataframe.Row
+				)
+				for _, c := range sdf.Schema() {
+					if c.Type != dataframe.TypeString {
+						columns = append(columns, c.Name)
+					}
+				}
+				for i := sdf.RowsIterator(); i.Next(); {
+					var values dataframe.Row
+					for j, c := range sdf.Schema() {
+						switch c.Type {
+						case dataframe.TypeString:
+						case dataframe.TypeTime:
+							values = append(values, unit.Time(unit.FromTime(i.At()[j].(time.Time))))
+						default:
+							values = append(values, i.At()[j])
+						}
+					}
+					exp = append(exp, values)
+				}
+				testutil.Equals(t, columns, members)
+				testutil.Equals(t, exp, got)
+			}
+		})
+	}
+}
+
+// TestEncoder_HDF5Library reads the files written by the encoder by the HDF5 library, by its h5dump tool, rather than
+// by the reader of the test.
+func TestEncoder_HDF5Library(t *testing.T) {
+	bin, err := exec.LookPath("h5dump")
+	if err != nil {
+		t.Skip("h5dump of the HDF5 library not found in PATH")
+	}
+	dir, err := ioutil.TempDir("", "hdf5")
+	testutil.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	for i, conf := range []Config{{}, {ChunkRows: 1, Compression: "gzip"}} {
+		df := testDataframe(t)
+		var b bytes.Buffer
+		testutil.Ok(t, NewEncoder(exporter.TimeUnitMilliseconds, conf).Encode(&b, df))
+		file := filepath.Join(dir, fmt.Sprintf("%d.h5", i))
+		testutil.Ok(t, ioutil.WriteFile(file, b.Bytes(), 0600))
+
+		out, err := exec.Command(bin, file).CombinedOutput()
+		testutil.Ok(t, err, string(out))
+		testutil.Assert(t, !strings.Contains(string(out), "error"), string(out))
+
+		sdfs, ok := dataframe.SplitSeries(df)
+		testutil.Assert(t, ok)
+		var down dataframe.SeriesDataframe
+		for _, sdf := range sdfs {
+			if sdf.Labels.Get(labels.MetricName) == "down" {
+				down = sdf
+			}
+			rows := 0
+			for i := sdf.RowsIterator(); i.Next(); {
+				rows++
+			}
+			dataset := regexp.MustCompile(fmt.Sprintf(`DATASET "%016x" \{[^}]*\}\s*DATASPACE\s+SIMPLE \{ \( %d \) / \( %d \) \}`, sdf.Fingerprint, rows, rows))
+			testutil.Assert(t, dataset.Match(out), "missing dataset of %s with %d rows:\n%s", sdf.Labels, rows, out)
+			for _, l := range sdf.Labels {
+				testutil.Assert(t, strings.Contains(string(out), fmt.Sprintf("ATTRIBUTE \"%s\"", l.Name)), "missing attribute %s", l.Name)
+			}
+		}
+		// The values of the row are read as written, the timestamps in milliseconds.
+		it := down.RowsIterator()
+		testutil.Assert(t, it.Next())
+		row := it.At()
+		values := regexp.MustCompile(fmt.Sprintf(`\(0\): \{\s*%d,\s*%d,\s*%d,\s*%d,\s*%d,\s*%g\s*\}`,
+			row[1].(time.Time).UnixNano()/1e6, row[2].(time.Time).UnixNano()/1e6, row[3].(time.Time).UnixNano()/1e6,
+			row[4].(time.Time).UnixNano()/1e6, row[5].(uint64), row[6].(float64)))
+		out, err = exec.Command(bin, "-d", fmt.Sprintf("/down/%016x", down.Fingerprint), "-m", "%g", file).CombinedOutput()
+		testutil.Ok(t, err, string(out))
+		testutil.Assert(t, values.Match(out), string(out))
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+	conf, err := ParseConfig([]byte("chunk_rows: 10\ncompression: GZIP\ncompression_level: 6"))
+	testutil.Ok(t, err)
+	testutil.Equals(t, Config{ChunkRows: 10, Compression: "GZIP", CompressionLevel: 6}, conf)
+
+	for _, c := range []string{
+		"chunk_rows: -1",
+		"compression: lzf",
+		"compression_level: 5",
+		"compression: gzip\ncompression_level: 10",
+		"unknown: 1",
+	} {
+		_, err := ParseConfig([]byte(c))
+		testutil.NotOk(t, err, c)
+	}
+}
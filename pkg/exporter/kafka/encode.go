@@ -0,0 +1,133 @@
+package kafka
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/thanos-community/obslytics/pkg/dataframe"
+	"github.com/thanos-community/obslytics/pkg/exporter"
+)
+
+// Encoding of the message values.
+type Encoding string
+
+const (
+	JSON Encoding = "JSON"
+	AVRO Encoding = "AVRO"
+)
+
+// rowEncoder encodes a single dataframe row into a message value.
+type rowEncoder func(dataframe.Schema, dataframe.Row) ([]byte, error)
+
+func newRowEncoder(enc Encoding, timeUnit exporter.TimeUnit, nullValue *string) (rowEncoder, error) {
+	switch Encoding(strings.ToUpper(string(enc))) {
+	case "", JSON:
+		var null interface{}
+		if nullValue != nil {
+			null = *nullValue
+		}
+		return func(s dataframe.Schema, r dataframe.Row) ([]byte, error) {
+			return encodeJSON(s, r, timeUnit, null)
+		}, nil
+	case AVRO:
+		return func(s dataframe.Schema, r dataframe.Row) ([]byte, error) {
+			return encodeAvro(s, r, timeUnit)
+		}, nil
+	default:
+		return nil, errors.Errorf("unsupported encoding %v", enc)
+	}
+}
+
+// encodeJSON encodes the row as a JSON object keyed by the column names. The absent values (missing labels and NaN
+// values) are written as null.
+func encodeJSON(s dataframe.Schema, r dataframe.Row, timeUnit exporter.TimeUnit, null interface{}) ([]byte, error) {
+	obj := make(map[string]interface{}, len(s))
+	for i, c := range s {
+		if c.Type == dataframe.TypeTime && r[i] != nil {
+			obj[c.Name] = timeUnit.FromTime(r[i].(time.Time))
+			continue
+		}
+		// JSON has no representation of NaN and infinities, e.g. of windows without samples.
+		if f, ok := r[i].(float64); ok && math.IsInf(f, 0) {
+			obj[c.Name] = nil
+			continue
+		}
+		if f, ok := r[i].(float64); r[i] == nil || ok && math.IsNaN(f) {
+			obj[c.Name] = null
+			continue
+		}
+		obj[c.Name] = r[i]
+	}
+	return json.Marshal(obj)
+}
+
+// AvroSchema returns the Avro schema of the messages produced for the dataframe schema.
+// String (label) columns are nullable, as not every series has to have all the labels.
+func AvroSchema(s dataframe.Schema, timeUnit exporter.TimeUnit) string {
+	fields := make([]string, 0, len(s))
+	for _, c := range s {
+		var t string
+		switch c.Type {
+		case dataframe.TypeString:
+			t = `["null", "string"]`
+		case dataframe.TypeFloat:
+			t = `"double"`
+		case dataframe.TypeUint:
+			t = `"long"`
+		case dataframe.TypeTime:
+			t = `{"type": "long", "logicalType": "timestamp-millis"}`
+			if timeUnit == exporter.TimeUnitSeconds {
+				// Avro has no logical type for timestamps in seconds.
+				t = `"long"`
+			}
+		}
+		fields = append(fields, fmt.Sprintf(`{"name": %q, "type": %s}`, c.Name, t))
+	}
+	return fmt.Sprintf(`{"type": "record", "name": "Row", "namespace": "obslytics", "fields": [%s]}`, strings.Join(fields, ", "))
+}
+
+// encodeAvro encodes the row using Avro binary encoding of the record defined by AvroSchema.
+func encodeAvro(s dataframe.Schema, r dataframe.Row, timeUnit exporter.TimeUnit) ([]byte, error) {
+	b := make([]byte, 0, 16*len(s))
+	for i, c := range s {
+		switch c.Type {
+		case dataframe.TypeString:
+			if r[i] == nil {
+				b = appendAvroLong(b, 0)
+				continue
+			}
+			v := r[i].(string)
+			b = appendAvroLong(b, 1)
+			b = appendAvroLong(b, int64(len(v)))
+			b = append(b, v...)
+		case dataframe.TypeFloat:
+			b = appendAvroDouble(b, r[i].(float64))
+		case dataframe.TypeUint:
+			// Avro has no unsigned types, values are stored with the same bits.
+			b = appendAvroLong(b, int64(r[i].(uint64)))
+		case dataframe.TypeTime:
+			b = appendAvroLong(b, timeUnit.FromTime(r[i].(time.Time)))
+		default:
+			return nil, errors.Errorf("unsupported column type %v", c.Type)
+		}
+	}
+	return b, nil
+}
+
+// appendAvroLong appends v as zig-zag encoded variable-length integer.
+func appendAvroLong(b []byte, v int64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutVarint(buf[:], v)]...)
+}
+
+// appendAvroDouble appends v as 8 bytes in little-endian order.
+func appendAvroDouble(b []byte, v float64) []byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], math.Float64bits(v))
+	return append(b, buf[:]...)
+}
@@ -0,0 +1,74 @@
+package kafka
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/thanos-community/obslytics/pkg/dataframe"
+	"github.com/thanos-community/obslytics/pkg/exporter"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+var (
+	testSchema = dataframe.Schema{
+		{Name: "job", Type: dataframe.TypeString},
+		{Name: "instance", Type: dataframe.TypeString},
+		{Name: "_sample_start", Type: dataframe.TypeTime},
+		{Name: "_count", Type: dataframe.TypeUint},
+		{Name: "_sum", Type: dataframe.TypeFloat},
+	}
+	testRow = dataframe.Row{"a", nil, time.Unix(1, 0), uint64(3), 1.5}
+)
+
+func TestEncodeJSON(t *testing.T) {
+	b, err := encodeJSON(testSchema, testRow, exporter.TimeUnitMilliseconds, nil)
+	testutil.Ok(t, err)
+
+	var got map[string]interface{}
+	testutil.Ok(t, json.Unmarshal(b, &got))
+	testutil.Equals(t, map[string]interface{}{
+		"job":           "a",
+		"instance":      nil,
+		"_sample_start": float64(1000),
+		"_count":        float64(3),
+		"_sum":          1.5,
+	}, got)
+
+	nanRow := dataframe.Row{"a", nil, time.Unix(1, 0), uint64(0), math.NaN()}
+	b, err = encodeJSON(testSchema, nanRow, exporter.TimeUnitMilliseconds, nil)
+	testutil.Ok(t, err)
+	testutil.Ok(t, json.Unmarshal(b, &got))
+	testutil.Equals(t, nil, got["_sum"])
+
+	// Configured null value is written for both the NaN value and the missing label.
+	null := `\N`
+	enc, err := newRowEncoder(JSON, exporter.TimeUnitMilliseconds, &null)
+	testutil.Ok(t, err)
+	b, err = enc(testSchema, nanRow)
+	testutil.Ok(t, err)
+	testutil.Equals(t, `{"_count":0,"_sample_start":1000,"_sum":"\\N","instance":"\\N","job":"a"}`, string(b))
+	b, err = enc(testSchema, dataframe.Row{"a", "b", time.Unix(1, 0), uint64(1), math.Inf(1)})
+	testutil.Ok(t, err)
+	testutil.Equals(t, `{"_count":1,"_sample_start":1000,"_sum":null,"instance":"b","job":"a"}`, string(b))
+
+	_, err = ParseConfig([]byte(`{brokers: [localhost:9092], topic: t, encoding: avro, null_value: ""}`))
+	testutil.NotOk(t, err)
+}
+
+func TestEncodeAvro(t *testing.T) {
+	b, err := encodeAvro(testSchema, testRow, exporter.TimeUnitMilliseconds)
+	testutil.Ok(t, err)
+	testutil.Equals(t, []byte{
+		0x02, 0x02, 'a', // Union index 1 (string), length 1, "a".
+		0x00,       // Union index 0 (null).
+		0xd0, 0x0f, // 1000 zig-zag encoded.
+		0x06,                                           // 3 zig-zag encoded.
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xf8, 0x3f, // 1.5 in little-endian.
+	}, b)
+
+	var schema map[string]interface{}
+	testutil.Ok(t, json.Unmarshal([]byte(AvroSchema(testSchema, exporter.TimeUnitMilliseconds)), &schema))
+	testutil.Equals(t, 5, len(schema["fields"].([]interface{})))
+}
@@ -0,0 +1,271 @@
+package kafka
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/Shopify/sarama"
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/thanos-community/obslytics/pkg/dataframe"
+	"github.com/thanos-community/obslytics/pkg/exporter"
+	http_util "github.com/thanos-io/thanos/pkg/http"
+	thanostls "github.com/thanos-io/thanos/pkg/tls"
+	"gopkg.in/yaml.v2"
+)
+
+// Compile-time check if kafka Writer implements exporter.Writer interface.
+var _ exporter.Writer = &Writer{}
+
+// Acks determines which acknowledgement the producer waits for.
+type Acks string
+
+const (
+	AcksNone   Acks = "none"
+	AcksLeader Acks = "leader"
+	AcksAll    Acks = "all"
+)
+
+// Config contains the options of the Kafka producer.
+type Config struct {
+	Brokers []string `yaml:"brokers"`
+	Topic   string   `yaml:"topic"`
+	// Encoding of the message values, JSON or AVRO. See AvroSchema for the Avro record definition.
+	Encoding Encoding `yaml:"encoding"`
+	// Acks is the acknowledgement to wait for: none, leader or all (default).
+	Acks Acks `yaml:"acks"`
+	// BatchSize is the number of messages sent in one request.
+	BatchSize int `yaml:"batch_size"`
+	// NullValue, if set, is the string written by the JSON encoding for the absent values, i.e. NaN values (e.g. of
+	// windows without samples) and missing labels, e.g. "", "NaN" or \N. By default, they are written as null.
+	// Infinities, not representable in JSON, are written as null regardless.
+	NullValue *string `yaml:"null_value"`
+
+	TLSConfig http_util.TLSConfig `yaml:"tls_config"`
+	// TLSEnabled enables TLS even without any certificates configured.
+	TLSEnabled bool       `yaml:"tls_enabled"`
+	SASL       SASLConfig `yaml:"sasl"`
+}
+
+// SASLConfig contains the credentials for the SASL/PLAIN authentication.
+type SASLConfig struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// ParseConfig parses the YAML Kafka configuration.
+func ParseConfig(conf []byte) (Config, error) {
+	config := Config{Acks: AcksAll, BatchSize: 1000}
+	if err := yaml.UnmarshalStrict(conf, &config); err != nil {
+		return Config{}, err
+	}
+	if len(config.Brokers) == 0 {
+		return Config{}, errors.New("no Kafka brokers specified")
+	}
+	if config.Topic == "" {
+		return Config{}, errors.New("no Kafka topic specified")
+	}
+	if config.BatchSize <= 0 {
+		return Config{}, errors.New("batch_size has to be positive")
+	}
+	if config.NullValue != nil && Encoding(strings.ToUpper(string(config.Encoding))) == AVRO {
+		return Config{}, errors.New("null_value is supported by the JSON encoding only, Avro has nullable labels and NaN doubles")
+	}
+	return config, nil
+}
+
+func newSaramaConfig(logger log.Logger, conf Config) (*sarama.Config, error) {
+	c := sarama.NewConfig()
+	// Required by the sync producer.
+	c.Producer.Return.Successes = true
+	c.Producer.Flush.MaxMessages = conf.BatchSize
+
+	switch Acks(strings.ToLower(string(conf.Acks))) {
+	case AcksNone:
+		c.Producer.RequiredAcks = sarama.NoResponse
+	case AcksLeader:
+		c.Producer.RequiredAcks = sarama.WaitForLocal
+	case "", AcksAll:
+		c.Producer.RequiredAcks = sarama.WaitForAll
+	default:
+		return nil, errors.Errorf("unsupported acks %v", conf.Acks)
+	}
+
+	if conf.TLSEnabled || conf.TLSConfig.CAFile != "" || conf.TLSConfig.CertFile != "" || conf.TLSConfig.KeyFile != "" {
+		tlsCfg, err := thanostls.NewClientConfig(logger,
+			conf.TLSConfig.CertFile,
+			conf.TLSConfig.KeyFile,
+			conf.TLSConfig.CAFile,
+			conf.TLSConfig.ServerName,
+			conf.TLSConfig.InsecureSkipVerify,
+		)
+		if err != nil {
+			return nil, errors.Wrap(err, "building TLS config")
+		}
+		c.Net.TLS.Enable = true
+		c.Net.TLS.Config = tlsCfg
+	}
+
+	if conf.SASL.Username != "" {
+		c.Net.SASL.Enable = true
+		c.Net.SASL.User = conf.SASL.Username
+		c.Net.SASL.Password = conf.SASL.Password
+	}
+	return c, nil
+}
+
+// Writer produces one message per dataframe row into a Kafka topic. Messages are keyed by the series
+// fingerprint, so that all rows of the same series end up in the same partition.
+type Writer struct {
+	logger log.Logger
+	conf   Config
+	enc    rowEncoder
+
+	maxBytes int64
+	written  int64
+	retry    exporter.RetryConfig
+}
+
+// NewWriter returns Writer for the given configuration. If maxBytes is positive, Export stops with
+// exporter.ErrOutputLimitReached once the produced keys and values reach maxBytes. The messages failed to be
+// produced are retried as configured by retry. A message is produced again only if it was not acknowledged, which
+// happens also when the broker wrote it but the acknowledgement was lost, so retries can duplicate messages.
+func NewWriter(logger log.Logger, conf Config, timeUnit exporter.TimeUnit, maxBytes int64, retry exporter.RetryConfig) (*Writer, error) {
+	enc, err := newRowEncoder(conf.Encoding, timeUnit, conf.NullValue)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{logger: logger, conf: conf, enc: enc, maxBytes: maxBytes, retry: retry}, nil
+}
+
+// Export produces the dataframe rows. On error, part of the rows might have been produced already.
+func (w *Writer) Export(ctx context.Context, df dataframe.Dataframe) error {
+	c, err := newSaramaConfig(w.logger, w.conf)
+	if err != nil {
+		return err
+	}
+	producer, err := sarama.NewSyncProducer(w.conf.Brokers, c)
+	if err != nil {
+		return errors.Wrap(err, "creating Kafka producer")
+	}
+	defer producer.Close()
+
+	s := df.Schema()
+	batch := make([]*sarama.ProducerMessage, 0, w.conf.BatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		msgs := batch
+		if err := exporter.Retry(ctx, w.logger, w.retry, func() error {
+			err := producer.SendMessages(msgs)
+			var perrs sarama.ProducerErrors
+			if !errors.As(err, &perrs) {
+				return errors.Wrap(err, "producing messages")
+			}
+			// Only the failed messages are produced again.
+			msgs = make([]*sarama.ProducerMessage, 0, len(perrs))
+			for _, perr := range perrs {
+				if permanentErrors[perr.Err] {
+					return exporter.Permanent(errors.Wrap(perr.Err, "producing messages"))
+				}
+				msgs = append(msgs, perr.Msg)
+			}
+			return errors.Wrap(err, "producing messages")
+		}); err != nil {
+			return err
+		}
+		batch = batch[:0]
+		return nil
+	}
+
+	if err := rows(df, func(r dataframe.Row, fingerprint uint64) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		v, err := w.enc(s, r)
+		if err != nil {
+			return errors.Wrap(err, "encoding a row")
+		}
+		k := strconv.FormatUint(fingerprint, 10)
+		if w.maxBytes > 0 && w.written+int64(len(k)+len(v)) > w.maxBytes {
+			if err := flush(); err != nil {
+				return err
+			}
+			return errors.Wrapf(exporter.ErrOutputLimitReached, "stopped after %d bytes", w.written)
+		}
+		w.written += int64(len(k) + len(v))
+
+		batch = append(batch, &sarama.ProducerMessage{
+			Topic: w.conf.Topic,
+			Key:   sarama.StringEncoder(k),
+			Value: sarama.ByteEncoder(v),
+		})
+		if len(batch) >= w.conf.BatchSize {
+			return flush()
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	return flush()
+}
+
+// permanentErrors are the producer errors not worth retrying.
+var permanentErrors = map[error]bool{
+	sarama.ErrInvalidMessage:             true,
+	sarama.ErrMessageSizeTooLarge:        true,
+	sarama.ErrInvalidTopic:               true,
+	sarama.ErrTopicAuthorizationFailed:   true,
+	sarama.ErrClusterAuthorizationFailed: true,
+}
+
+// Outputs returns the topic with the size of keys and values produced so far. Messages of the last
+// batch are included even if producing them failed.
+func (w *Writer) Outputs() []exporter.Output {
+	return []exporter.Output{{Path: "kafka://" + w.conf.Topic, Bytes: w.written}}
+}
+
+// rows calls f with every row of the dataframe and the fingerprint of its series, the same as of the _series_id column.
+// The rows are fingerprinted by their _series_id column if any. Otherwise, the rows of the dataframes of FromSeries
+// are matched to their series by their labels, as the metric name column or the unit conversions change them, and the
+// rows of the other dataframes, or changed by the wrapping dataframes, e.g. filling the missing labels, are
+// fingerprinted by their labels.
+func rows(df dataframe.Dataframe, f func(r dataframe.Row, fingerprint uint64) error) error {
+	s := df.Schema()
+	id := -1
+	for i, c := range s {
+		if c.Name == "_series_id" && c.Type == dataframe.TypeUint {
+			id = i
+		}
+	}
+
+	var series map[uint64]uint64
+	if sdfs, ok := dataframe.SplitSeries(df); ok && id < 0 {
+		series = make(map[uint64]uint64, len(sdfs))
+		for _, sdf := range sdfs {
+			// The labels are the same in all the rows of the series.
+			if j := sdf.RowsIterator(); j.Next() {
+				series[dataframe.Fingerprint(dataframe.RowLabels(s, j.At()))] = sdf.Fingerprint
+			}
+		}
+	}
+	for i := df.RowsIterator(); i.Next(); {
+		r := i.At()
+		var fingerprint uint64
+		if id >= 0 {
+			fingerprint = r[id].(uint64)
+		} else {
+			fingerprint = dataframe.Fingerprint(dataframe.RowLabels(s, r))
+			if sfp, ok := series[fingerprint]; ok {
+				fingerprint = sfp
+			}
+		}
+		if err := f(r, fingerprint); err != nil {
+			return err
+		}
+	}
+	return nil
+}
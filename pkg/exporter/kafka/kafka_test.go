@@ -0,0 +1,111 @@
+package kafka
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/tsdbutil"
+	"github.com/thanos-community/obslytics/pkg/dataframe"
+	"github.com/thanos-community/obslytics/pkg/exporter"
+	"github.com/thanos-community/obslytics/pkg/exporter/memory"
+	inmemory "github.com/thanos-community/obslytics/pkg/series/memory"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+type sample struct {
+	t int64
+	v float64
+}
+
+func (s sample) T() int64   { return s.t }
+func (s sample) V() float64 { return s.v }
+
+// keysWriter records the message keys of the rows it exports.
+type keysWriter struct {
+	keys []uint64
+	rows []dataframe.Row
+}
+
+func (w *keysWriter) Export(_ context.Context, df dataframe.Dataframe) error {
+	return rows(df, func(r dataframe.Row, fingerprint uint64) error {
+		w.keys = append(w.keys, fingerprint)
+		w.rows = append(w.rows, r)
+		return nil
+	})
+}
+
+func (w *keysWriter) Outputs() []exporter.Output { return nil }
+
+// skipFirstRow wraps the dataframe without its first row.
+type skipFirstRow struct {
+	dataframe.Dataframe
+}
+
+func (df skipFirstRow) Unwrap() dataframe.Dataframe { return df.Dataframe }
+
+func (df skipFirstRow) RowsIterator() dataframe.RowsIterator {
+	i := df.Dataframe.RowsIterator()
+	i.Next()
+	return i
+}
+
+func TestRows_Fingerprint(t *testing.T) {
+	a := labels.FromStrings("__name__", "transmit_bytes_total", "job", "a")
+	b := labels.FromStrings("__name__", "transmit_bytes_total", "instance", "x")
+	df, err := dataframe.FromSeries(inmemory.NewSet(
+		storage.NewListSeries(a, []tsdbutil.Sample{sample{t: 0, v: 1}, sample{t: 60000, v: 2}}),
+		storage.NewListSeries(b, []tsdbutil.Sample{sample{t: 0, v: 3}}),
+	), time.Minute, func(o *dataframe.AggrsOptions) {
+		o.Sum.Enabled = true
+		o.SeriesID.Enabled = true
+		o.MetricName.Enabled = true
+		o.MetricName.Column = "metric"
+		o.Units.Conversions = map[string]dataframe.UnitConversion{"transmit_bytes_total": {Scale: 8, Unit: "bits"}}
+	})
+	testutil.Ok(t, err)
+
+	// The keys join to the _series_id of the rows, also with the missing labels filled.
+	w := &keysWriter{}
+	testutil.Ok(t, exporter.FillMissingLabels(w, "__missing__").Export(context.Background(), df))
+	id := -1
+	for i, c := range df.Schema() {
+		if c.Name == "_series_id" {
+			id = i
+		}
+	}
+	testutil.Equals(t, []uint64{dataframe.Fingerprint(a), dataframe.Fingerprint(a), dataframe.Fingerprint(b)}, w.keys)
+	for i, r := range w.rows {
+		testutil.Equals(t, r[id], w.keys[i])
+	}
+	testutil.Equals(t, "__missing__", w.rows[0][1])
+
+	// Without the _series_id, the rows are matched to their series by their labels, not by their position.
+	df, err = dataframe.FromSeries(inmemory.NewSet(
+		storage.NewListSeries(a, []tsdbutil.Sample{sample{t: 0, v: 1}, sample{t: 60000, v: 2}}),
+		storage.NewListSeries(b, []tsdbutil.Sample{sample{t: 0, v: 3}}),
+	), time.Minute, func(o *dataframe.AggrsOptions) {
+		o.Sum.Enabled = true
+		o.MetricName.Enabled = true
+		o.MetricName.Column = "metric"
+	})
+	testutil.Ok(t, err)
+	w = &keysWriter{}
+	testutil.Ok(t, w.Export(context.Background(), skipFirstRow{Dataframe: df}))
+	testutil.Equals(t, []uint64{dataframe.Fingerprint(a), dataframe.Fingerprint(b)}, w.keys)
+
+	// The rows of the other dataframes are fingerprinted by their labels, without the unit column.
+	w = &keysWriter{}
+	testutil.Ok(t, w.Export(context.Background(), memory.Frame{
+		Columns: dataframe.Schema{
+			{Name: "__name__", Type: dataframe.TypeString},
+			{Name: "instance", Type: dataframe.TypeString},
+			{Name: "job", Type: dataframe.TypeString},
+			{Name: "_unit", Type: dataframe.TypeString},
+		},
+		Rows: []dataframe.Row{{"transmit_bytes_total", nil, "a", "bits"}},
+	}))
+	testutil.Equals(t, []uint64{dataframe.Fingerprint(a)}, w.keys)
+}
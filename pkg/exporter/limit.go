@@ -0,0 +1,123 @@
+package exporter
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/thanos-community/obslytics/pkg/dataframe"
+)
+
+// ErrOutputLimitReached is returned when the output reached the configured MaxOutputBytes. Use errors.Cause
+// to check for it, the output written until then is complete and valid.
+var ErrOutputLimitReached = errors.New("output size limit reached")
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingWriter) EndSeries() error { return EndSeries(c.w) }
+
+// RowsBuffer is implemented by the rows iterators given to the encoders. The encoders buffering the rows before
+// writing them, e.g. into row groups, report the approximate size of the rows buffered and not written yet, counted
+// as written by the output size limit.
+type RowsBuffer interface {
+	Buffered(bytes int64)
+}
+
+// Buffered reports the size of the rows buffered by the encoder to the rows iterator, if it implements RowsBuffer.
+func Buffered(it dataframe.RowsIterator, bytes int64) {
+	if b, ok := it.(RowsBuffer); ok {
+		b.Buffered(bytes)
+	}
+}
+
+// limitedRows stops iterating the rows once the written size, with the size buffered by the encoder, reaches the
+// limit. The row read to check if there is anything left is carried over, so the iteration can continue in the next
+// file.
+type limitedRows struct {
+	rows dataframe.RowsIterator
+	max  int64
+
+	cw       *countingWriter
+	buffered int64
+	carried  bool
+	limited  bool
+}
+
+func (l *limitedRows) Next() bool {
+	if l.carried {
+		l.carried = false
+		return true
+	}
+	if l.cw.n+l.buffered >= l.max {
+		l.limited = l.rows.Next()
+		l.carried = l.limited
+		return false
+	}
+	return l.rows.Next()
+}
+
+func (l *limitedRows) At() dataframe.Row { return l.rows.At() }
+
+func (l *limitedRows) Buffered(bytes int64) { l.buffered = bytes }
+
+// limitedDataframe exposes the rows of a single output file.
+type limitedDataframe struct {
+	schema dataframe.Schema
+	rows   *limitedRows
+}
+
+func (d limitedDataframe) Schema() dataframe.Schema { return d.schema }
+
+func (d limitedDataframe) RowsIterator() dataframe.RowsIterator { return d.rows }
+
+// countingRows counts the rows iterated through it.
+type countingRows struct {
+	dataframe.RowsIterator
+	n int64
+}
+
+func (c *countingRows) Next() bool {
+	if !c.RowsIterator.Next() {
+		return false
+	}
+	c.n++
+	return true
+}
+
+func (c *countingRows) Buffered(bytes int64) { Buffered(c.RowsIterator, bytes) }
+
+// countingDataframe counts the rows read by the encoder. RowsIterator is expected to be called once.
+type countingDataframe struct {
+	dataframe.Dataframe
+	rows *countingRows
+}
+
+// Unwrap returns the counted dataframe, e.g. for the encoders splitting it by series. The rows of the split
+// dataframes are not counted.
+func (d countingDataframe) Unwrap() dataframe.Dataframe { return d.Dataframe }
+
+func (d countingDataframe) RowsIterator() dataframe.RowsIterator {
+	d.rows.RowsIterator = d.Dataframe.RowsIterator()
+	return d.rows
+}
+
+// partPath returns the path of the n-th output file, e.g. out.parquet, out-1.parquet, out-2.parquet...
+func partPath(p string, n int) string {
+	if n == 0 {
+		return p
+	}
+	ext := path.Ext(p)
+	return fmt.Sprintf("%s-%d%s", strings.TrimSuffix(p, ext), n, ext)
+}
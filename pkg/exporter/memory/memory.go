@@ -0,0 +1,111 @@
+package memory
+
+import (
+	"context"
+	"strconv"
+	"sync"
+
+	"github.com/thanos-community/obslytics/pkg/dataframe"
+	"github.com/thanos-community/obslytics/pkg/exporter"
+)
+
+// Compile-time check if memory Writer implements exporter.Writer interface.
+var _ exporter.Writer = &Writer{}
+
+// Writer implements exporter.Writer recording the exported dataframes in memory, e.g. to assert the output of the
+// pipelines embedding obslytics in tests. Writer is safe for concurrent exports.
+type Writer struct {
+	mtx    sync.Mutex
+	frames []Frame
+}
+
+// NewWriter returns the empty Writer.
+func NewWriter() *Writer {
+	return &Writer{}
+}
+
+// Export reads all the rows of the dataframe into a Frame. Once the context is canceled, the rows read so far are
+// recorded and the context error returned, as the other writers leave partial outputs.
+func (w *Writer) Export(ctx context.Context, df dataframe.Dataframe) error {
+	f := Frame{Columns: df.Schema()}
+	var err error
+	for i := df.RowsIterator(); i.Next(); {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+		f.Rows = append(f.Rows, append(dataframe.Row(nil), i.At()...))
+	}
+
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	w.frames = append(w.frames, f)
+	return err
+}
+
+// Outputs returns an output per export, memory://<n> where n counts the exports from 0, without size.
+func (w *Writer) Outputs() []exporter.Output {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	ret := make([]exporter.Output, 0, len(w.frames))
+	for i := range w.frames {
+		ret = append(ret, exporter.Output{Path: "memory://" + strconv.Itoa(i)})
+	}
+	return ret
+}
+
+// Frames returns the exported dataframes, in the order of the exports.
+func (w *Writer) Frames() []Frame {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	return append([]Frame(nil), w.frames...)
+}
+
+// Rows returns the rows of all the exports, in order.
+func (w *Writer) Rows() []dataframe.Row {
+	var ret []dataframe.Row
+	for _, f := range w.Frames() {
+		ret = append(ret, f.Rows...)
+	}
+	return ret
+}
+
+// Reset forgets the exported dataframes, e.g. between the iterations of a benchmark.
+func (w *Writer) Reset() {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	w.frames = nil
+}
+
+// Frame is an exported dataframe, with all its rows in memory. Frame implements dataframe.Dataframe, so that it can be
+// exported again.
+type Frame struct {
+	Columns dataframe.Schema
+	Rows    []dataframe.Row
+}
+
+func (f Frame) Schema() dataframe.Schema { return f.Columns }
+
+func (f Frame) RowsIterator() dataframe.RowsIterator { return &rowsIterator{rows: f.Rows, i: -1} }
+
+// Column returns the values of the column in all the rows, nil if there is no such column.
+func (f Frame) Column(name string) []interface{} {
+	for c, col := range f.Columns {
+		if col.Name != name {
+			continue
+		}
+		ret := make([]interface{}, 0, len(f.Rows))
+		for _, r := range f.Rows {
+			ret = append(ret, r[c])
+		}
+		return ret
+	}
+	return nil
+}
+
+type rowsIterator struct {
+	rows []dataframe.Row
+	i    int
+}
+
+func (it *rowsIterator) Next() bool        { it.i++; return it.i < len(it.rows) }
+func (it *rowsIterator) At() dataframe.Row { return it.rows[it.i] }
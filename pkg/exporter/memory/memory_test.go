@@ -0,0 +1,58 @@
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/tsdbutil"
+	"github.com/thanos-community/obslytics/pkg/dataframe"
+	"github.com/thanos-community/obslytics/pkg/exporter"
+	"github.com/thanos-community/obslytics/pkg/series"
+	inmemory "github.com/thanos-community/obslytics/pkg/series/memory"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+type sample struct {
+	t int64
+	v float64
+}
+
+func (s sample) T() int64   { return s.t }
+func (s sample) V() float64 { return s.v }
+
+func TestWriter(t *testing.T) {
+	in := inmemory.NewSeries(
+		storage.NewListSeries(labels.FromStrings("__name__", "up", "job", "a"), []tsdbutil.Sample{sample{0, 1}, sample{30000, 2}, sample{60000, 3}}),
+		storage.NewListSeries(labels.FromStrings("__name__", "up", "job", "b"), []tsdbutil.Sample{sample{0, 4}}),
+	)
+	set, err := in.Read(context.Background(), series.Params{MinTime: time.Unix(0, 0), MaxTime: time.Unix(120, 0)})
+	testutil.Ok(t, err)
+	df, err := dataframe.FromSeries(set, time.Minute, func(o *dataframe.AggrsOptions) { o.Sum.Enabled = true })
+	testutil.Ok(t, err)
+
+	w := NewWriter()
+	testutil.Ok(t, w.Export(context.Background(), df))
+	testutil.Equals(t, []exporter.Output{{Path: "memory://0"}}, w.Outputs())
+
+	frames := w.Frames()
+	testutil.Equals(t, 1, len(frames))
+	testutil.Equals(t, df.Schema(), frames[0].Schema())
+	testutil.Equals(t, []interface{}{"a", "a", "b"}, frames[0].Column("job"))
+	testutil.Equals(t, []interface{}{3.0, 3.0, 4.0}, frames[0].Column("_sum"))
+	testutil.Equals(t, []interface{}(nil), frames[0].Column("_count"))
+
+	// Frames can be exported again.
+	testutil.Ok(t, w.Export(context.Background(), frames[0]))
+	testutil.Equals(t, 6, len(w.Rows()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	testutil.Equals(t, context.Canceled, w.Export(ctx, df))
+	testutil.Equals(t, 0, len(w.Frames()[2].Rows))
+
+	w.Reset()
+	testutil.Equals(t, 0, len(w.Outputs()))
+}
@@ -4,29 +4,115 @@ import (
 	"fmt"
 	"io"
 	"runtime"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
 	"github.com/thanos-community/obslytics/pkg/dataframe"
 	"github.com/thanos-community/obslytics/pkg/exporter"
+	"github.com/xitongsys/parquet-go-source/local"
 	parquetwriter "github.com/xitongsys/parquet-go-source/writerfile"
 	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/reader"
 	"github.com/xitongsys/parquet-go/source"
 	"github.com/xitongsys/parquet-go/writer"
+	"gopkg.in/yaml.v2"
 )
 
-// Compile-time check if parquet Encoder implements exporter.Encoder interface.
-var _ exporter.Encoder = &Encoder{}
+// Compile-time check if parquet Encoder implements exporter.Encoder and exporter.Validator interfaces.
+var (
+	_ exporter.Encoder   = &Encoder{}
+	_ exporter.Validator = &Encoder{}
+)
+
+// Config contains the options of the parquet encoding, given by the PARQUET output config.
+type Config struct {
+	// Compression is the codec compressing the pages: snappy (default), gzip, zstd or uncompressed.
+	// The compression levels are fixed by parquet-go (default level of every codec).
+	Compression string `yaml:"compression"`
+	// Parallelism is the number of goroutines encoding and compressing the pages of a row group.
+	// Defaults to GOMAXPROCS.
+	Parallelism int `yaml:"parallelism"`
+	// RowGroupSize is the approximate size of a row group in bytes, as the size of its values before encoding, 128MB
+	// by default. Rows of a row group are buffered in memory, with the dictionaries of the string columns, until it is
+	// written, so it bounds the memory of the encoding regardless of the size of the file.
+	RowGroupSize int64 `yaml:"row_group_size"`
+	// RowGroupRows is the maximum number of rows of a row group, unlimited by default. It bounds the memory of the
+	// encoding of wide rows, whose size is approximate, and gives row groups of the same number of rows.
+	RowGroupRows int64 `yaml:"row_group_rows"`
+}
+
+// defaultRowGroupSize is the default size of a row group, as of parquet-go.
+const defaultRowGroupSize = 128 * 1024 * 1024
+
+// ParseConfig parses the YAML parquet configuration.
+func ParseConfig(conf []byte) (Config, error) {
+	config := Config{}
+	if err := yaml.UnmarshalStrict(conf, &config); err != nil {
+		return Config{}, err
+	}
+	if _, err := compressionCodec(config.Compression); err != nil {
+		return Config{}, err
+	}
+	if config.Parallelism < 0 {
+		return Config{}, errors.New("parallelism cannot be negative")
+	}
+	if config.RowGroupSize < 0 {
+		return Config{}, errors.New("row_group_size cannot be negative")
+	}
+	if config.RowGroupRows < 0 {
+		return Config{}, errors.New("row_group_rows cannot be negative")
+	}
+	return config, nil
+}
 
-type Encoder struct{}
+// compressionCodec returns the parquet codec of the compression name.
+func compressionCodec(name string) (parquet.CompressionCodec, error) {
+	switch strings.ToLower(name) {
+	case "", "snappy":
+		return parquet.CompressionCodec_SNAPPY, nil
+	case "gzip":
+		return parquet.CompressionCodec_GZIP, nil
+	case "zstd":
+		return parquet.CompressionCodec_ZSTD, nil
+	case "uncompressed", "none":
+		return parquet.CompressionCodec_UNCOMPRESSED, nil
+	}
+	return 0, errors.Errorf("unsupported compression %q, expected snappy, gzip, zstd or uncompressed", name)
+}
 
-func NewEncoder() *Encoder {
-	return &Encoder{}
+type Encoder struct {
+	timeUnit     exporter.TimeUnit
+	compression  parquet.CompressionCodec
+	parallelism  int64
+	rowGroupSize int64
+	rowGroupRows int64
 }
 
+// NewEncoder returns parquet encoder writing timestamps in the given unit. The config is expected to be
+// validated by ParseConfig, zero values select the defaults.
+func NewEncoder(timeUnit exporter.TimeUnit, conf Config) *Encoder {
+	e := &Encoder{
+		timeUnit:     timeUnit,
+		parallelism:  int64(conf.Parallelism),
+		rowGroupSize: conf.RowGroupSize,
+		rowGroupRows: conf.RowGroupRows,
+	}
+	e.compression, _ = compressionCodec(conf.Compression)
+	if e.parallelism == 0 {
+		e.parallelism = int64(runtime.GOMAXPROCS(0))
+	}
+	if e.rowGroupSize == 0 {
+		e.rowGroupSize = defaultRowGroupSize
+	}
+	return e
+}
+
+// Encode writes the rows of the dataframe as parquet, writing every row group once it fills, so that only the rows of
+// a single row group are buffered. The footer is written last, also on errors, once the buffered rows are written.
 func (e *Encoder) Encode(w io.Writer, df dataframe.Dataframe) (err error) {
 	parqf := parquetwriter.NewWriterFile(w)
-	parqw, err := initCSVWriter(parqf, df)
+	parqw, err := e.initCSVWriter(parqf, df)
 	if err != nil {
 		return errors.Wrap(err, "initializing the schema")
 	}
@@ -36,12 +122,18 @@ func (e *Encoder) Encode(w io.Writer, df dataframe.Dataframe) (err error) {
 		}
 	}()
 
+	// parquet-go flushes the row groups by the size of their encoded pages, which does not include the dictionaries of
+	// the columns, so that the row groups of dictionary encoded columns are never flushed before the end of the file.
+	// The row groups are flushed here by the size of their values instead, reported as buffered for the output size
+	// limit.
+	var size, rows int64
 	i := df.RowsIterator()
 	s := df.Schema()
 	for i.Next() {
 		r := i.At()
 		d := make([]interface{}, 0, len(r))
 		for i, cell := range r {
+			size += valueSize(cell)
 			c := s[i]
 			switch c.Type {
 			case dataframe.TypeString:
@@ -54,7 +146,7 @@ func (e *Encoder) Encode(w io.Writer, df dataframe.Dataframe) (err error) {
 				d = append(d, int64(v))
 			case dataframe.TypeTime:
 				v := cell.(time.Time)
-				d = append(d, v.Unix()*1000)
+				d = append(d, e.timeUnit.FromTime(v))
 			default:
 				d = append(d, cell)
 			}
@@ -62,12 +154,58 @@ func (e *Encoder) Encode(w io.Writer, df dataframe.Dataframe) (err error) {
 		if err := parqw.Write(d); err != nil {
 			return errors.Wrap(err, "writing a row")
 		}
-		runtime.GC() // Force GC run to clean memory.
+
+		rows++
+		if size >= e.rowGroupSize || (e.rowGroupRows > 0 && rows >= e.rowGroupRows) {
+			if err := parqw.Flush(true); err != nil {
+				return errors.Wrap(err, "writing a row group")
+			}
+			size, rows = 0, 0
+		}
+		exporter.Buffered(i, size)
 	}
 	return nil
 }
 
-func initCSVWriter(parqf source.ParquetFile, df dataframe.Dataframe) (*writer.CSVWriter, error) {
+// valueSize returns the approximate size of the cell value buffered by the parquet writer.
+func valueSize(cell interface{}) int64 {
+	if s, ok := cell.(string); ok {
+		return int64(len(s)) + 16
+	}
+	// The interface value of numbers and timestamps.
+	return 16
+}
+
+// Validate reads all the columns of the parquet file and checks they contain the given number of rows.
+func (e *Encoder) Validate(path string, rows int64) error {
+	f, err := local.NewLocalFileReader(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, err := reader.NewParquetColumnReader(f, 1)
+	if err != nil {
+		return errors.Wrap(err, "reading parquet footer")
+	}
+	defer r.ReadStop()
+
+	if n := r.GetNumRows(); n != rows {
+		return errors.Errorf("expected %d rows, file contains %d", rows, n)
+	}
+	for i := int64(0); i < r.SchemaHandler.GetColumnNum(); i++ {
+		vals, _, _, err := r.ReadColumnByIndex(i, rows)
+		if err != nil {
+			return errors.Wrapf(err, "reading column %d", i)
+		}
+		if int64(len(vals)) != rows {
+			return errors.Errorf("expected %d rows, column %d contains %d", rows, i, len(vals))
+		}
+	}
+	return nil
+}
+
+func (e *Encoder) initCSVWriter(parqf source.ParquetFile, df dataframe.Dataframe) (*writer.CSVWriter, error) {
+	timeUnit := e.timeUnit
 	schema := df.Schema()
 	pqSchema := make([]string, 0, len(schema))
 	for _, c := range schema {
@@ -81,15 +219,45 @@ func initCSVWriter(parqf source.ParquetFile, df dataframe.Dataframe) (*writer.CS
 			pqType = "UINT_64"
 		case dataframe.TypeTime:
 			pqType = "TIMESTAMP_MILLIS"
+			if timeUnit == exporter.TimeUnitSeconds {
+				// Parquet has no logical type for timestamps in seconds.
+				pqType = "INT64"
+			}
 		}
 		pqSchema = append(pqSchema, fmt.Sprintf("name=%s, type=%s", c.Name, pqType))
 	}
 
-	parqw, err := writer.NewCSVWriter(pqSchema, parqf, 4)
+	parqw, err := writer.NewCSVWriter(pqSchema, parqf, e.parallelism)
 	if err != nil {
 		return nil, err
 	}
-	parqw.CompressionType = parquet.CompressionCodec_SNAPPY
+	// parquet-go sets only the legacy converted types, annotate the columns with logical types as well,
+	// so readers (e.g. Spark or Trino) infer the types without casts. First element is the schema root.
+	for i, c := range schema {
+		parqw.SchemaHandler.SchemaElements[i+1].LogicalType = logicalType(c.Type, timeUnit)
+	}
+	parqw.CompressionType = e.compression
+	parqw.RowGroupSize = e.rowGroupSize
 
 	return parqw, nil
 }
+
+// logicalType returns the parquet logical type annotation of the column type, nil if there is none.
+func logicalType(t dataframe.Type, timeUnit exporter.TimeUnit) *parquet.LogicalType {
+	switch t {
+	case dataframe.TypeString:
+		return &parquet.LogicalType{STRING: parquet.NewStringType()}
+	case dataframe.TypeUint:
+		return &parquet.LogicalType{INTEGER: &parquet.IntType{BitWidth: 64, IsSigned: false}}
+	case dataframe.TypeTime:
+		if timeUnit == exporter.TimeUnitSeconds {
+			return nil
+		}
+		return &parquet.LogicalType{TIMESTAMP: &parquet.TimestampType{
+			IsAdjustedToUTC: true,
+			Unit:            &parquet.TimeUnit{MILLIS: parquet.NewMilliSeconds()},
+		}}
+	}
+	// Floats are plain DOUBLE values.
+	return nil
+}
@@ -0,0 +1,396 @@
+package parquet
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/thanos-community/obslytics/pkg/dataframe"
+	"github.com/thanos-community/obslytics/pkg/exporter"
+	"github.com/thanos-io/thanos/pkg/objstore"
+	"github.com/thanos-io/thanos/pkg/testutil"
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+// testDataframe implements dataframe.Dataframe on top of in-memory rows.
+type testDataframe struct {
+	schema dataframe.Schema
+	rows   []dataframe.Row
+}
+
+func (df testDataframe) Schema() dataframe.Schema { return df.schema }
+
+func (df testDataframe) RowsIterator() dataframe.RowsIterator {
+	return &testRowsIterator{rows: df.rows, i: -1}
+}
+
+type testRowsIterator struct {
+	rows []dataframe.Row
+	i    int
+}
+
+func (it *testRowsIterator) Next() bool {
+	it.i++
+	return it.i < len(it.rows)
+}
+
+func (it *testRowsIterator) At() dataframe.Row { return it.rows[it.i] }
+
+// validate validates the encoded data written into a file.
+func validate(t *testing.T, e *Encoder, data []byte, rows int64) error {
+	f := filepath.Join(t.TempDir(), "out.parquet")
+	testutil.Ok(t, ioutil.WriteFile(f, data, 0600))
+	return e.Validate(f, rows)
+}
+
+// encode encodes the dataframe and returns a column reader for the result.
+func encode(t *testing.T, e *Encoder, df dataframe.Dataframe) *reader.ParquetReader {
+	b := &bytes.Buffer{}
+	testutil.Ok(t, e.Encode(b, df))
+
+	f, err := buffer.NewBufferFile(b.Bytes())
+	testutil.Ok(t, err)
+	r, err := reader.NewParquetColumnReader(f, 1)
+	testutil.Ok(t, err)
+	return r
+}
+
+func TestEncoder_TimestampUnit(t *testing.T) {
+	ts := time.Unix(1600000000, 123*int64(time.Millisecond)).UTC()
+	df := testDataframe{
+		schema: dataframe.Schema{{Name: "_sample_start", Type: dataframe.TypeTime}},
+		rows:   []dataframe.Row{{ts}},
+	}
+
+	for _, tcase := range []struct {
+		unit     exporter.TimeUnit
+		expected int64
+	}{
+		{unit: exporter.TimeUnitMilliseconds, expected: 1600000000123},
+		{unit: exporter.TimeUnitSeconds, expected: 1600000000},
+	} {
+		t.Run(string(tcase.unit), func(t *testing.T) {
+			r := encode(t, NewEncoder(tcase.unit, Config{}), df)
+			defer r.ReadStop()
+
+			testutil.Equals(t, int64(1), r.GetNumRows())
+			vals, _, _, err := r.ReadColumnByIndex(0, 1)
+			testutil.Ok(t, err)
+			testutil.Equals(t, []interface{}{tcase.expected}, vals)
+			testutil.Equals(t, ts.Truncate(timeUnitPrecision(tcase.unit)), tcase.unit.Time(vals[0].(int64)))
+		})
+	}
+}
+
+func TestEncoder_Schema(t *testing.T) {
+	df := testDataframe{
+		schema: dataframe.Schema{
+			{Name: "__name__", Type: dataframe.TypeString},
+			{Name: "_series_id", Type: dataframe.TypeUint},
+			{Name: "_sample_start", Type: dataframe.TypeTime},
+			{Name: "_sum", Type: dataframe.TypeFloat},
+		},
+		rows: []dataframe.Row{{"up", uint64(1), time.Unix(1600000000, 0), 1.0}},
+	}
+
+	r := encode(t, NewEncoder(exporter.TimeUnitMilliseconds, Config{}), df)
+	defer r.ReadStop()
+
+	// First element is the schema root.
+	s := r.Footer.Schema[1:]
+	testutil.Equals(t, 4, len(s))
+
+	testutil.Equals(t, "__name__", r.SchemaHandler.Infos[1].ExName)
+	testutil.Equals(t, parquet.Type_BYTE_ARRAY, s[0].GetType())
+	testutil.Equals(t, parquet.ConvertedType_UTF8, s[0].GetConvertedType())
+	testutil.Assert(t, s[0].GetLogicalType().IsSetSTRING())
+
+	testutil.Equals(t, parquet.Type_INT64, s[1].GetType())
+	testutil.Equals(t, parquet.ConvertedType_UINT_64, s[1].GetConvertedType())
+	testutil.Equals(t, &parquet.IntType{BitWidth: 64, IsSigned: false}, s[1].GetLogicalType().GetINTEGER())
+
+	testutil.Equals(t, parquet.Type_INT64, s[2].GetType())
+	testutil.Equals(t, parquet.ConvertedType_TIMESTAMP_MILLIS, s[2].GetConvertedType())
+	ts := s[2].GetLogicalType().GetTIMESTAMP()
+	testutil.Assert(t, ts.IsAdjustedToUTC)
+	testutil.Assert(t, ts.GetUnit().IsSetMILLIS())
+
+	testutil.Equals(t, parquet.Type_DOUBLE, s[3].GetType())
+	testutil.Assert(t, !s[3].IsSetConvertedType())
+	testutil.Assert(t, !s[3].IsSetLogicalType())
+
+	r = encode(t, NewEncoder(exporter.TimeUnitSeconds, Config{}), df)
+	defer r.ReadStop()
+
+	testutil.Equals(t, parquet.Type_INT64, r.Footer.Schema[3].GetType())
+	testutil.Assert(t, !r.Footer.Schema[3].IsSetConvertedType())
+	testutil.Assert(t, !r.Footer.Schema[3].IsSetLogicalType())
+}
+
+func TestEncoder_MissingLabels(t *testing.T) {
+	// Series with different label sets, the missing labels are written as nulls.
+	df := testDataframe{
+		schema: dataframe.Schema{
+			{Name: "instance", Type: dataframe.TypeString},
+			{Name: "job", Type: dataframe.TypeString},
+			{Name: "_sum", Type: dataframe.TypeFloat},
+		},
+		rows: []dataframe.Row{{"a", "x", 1.0}, {nil, "x", 2.0}, {"b", nil, 3.0}},
+	}
+
+	r := encode(t, NewEncoder(exporter.TimeUnitMilliseconds, Config{}), df)
+	defer r.ReadStop()
+
+	testutil.Equals(t, parquet.FieldRepetitionType_OPTIONAL, r.Footer.Schema[1].GetRepetitionType())
+	vals, _, _, err := r.ReadColumnByIndex(0, 3)
+	testutil.Ok(t, err)
+	testutil.Equals(t, []interface{}{"a", nil, "b"}, vals)
+	vals, _, _, err = r.ReadColumnByIndex(1, 3)
+	testutil.Ok(t, err)
+	testutil.Equals(t, []interface{}{"x", "x", nil}, vals)
+}
+
+func TestEncoder_Validate(t *testing.T) {
+	df := testDataframe{
+		schema: dataframe.Schema{
+			{Name: "__name__", Type: dataframe.TypeString},
+			{Name: "_sum", Type: dataframe.TypeFloat},
+		},
+		rows: []dataframe.Row{{"up", 1.0}, {"up", 2.0}},
+	}
+
+	e := NewEncoder(exporter.TimeUnitMilliseconds, Config{})
+	b := &bytes.Buffer{}
+	testutil.Ok(t, e.Encode(b, df))
+
+	testutil.Ok(t, validate(t, e, b.Bytes(), 2))
+	testutil.NotOk(t, validate(t, e, b.Bytes(), 3))
+	testutil.NotOk(t, validate(t, e, b.Bytes()[:b.Len()/2], 2))
+}
+
+func TestEncoder_RowGroups(t *testing.T) {
+	df := benchDataframe(1000)
+	for _, tcase := range []struct {
+		name     string
+		conf     Config
+		expected []int64
+	}{
+		{name: "default", conf: Config{}, expected: []int64{1000}},
+		{name: "rows", conf: Config{RowGroupRows: 400}, expected: []int64{400, 400, 200}},
+		// The rows are 74 or 75 bytes, 16 per cell and the length of the instance value, the row group is written with
+		// the row reaching the size.
+		{name: "size", conf: Config{RowGroupSize: 45000}, expected: []int64{601, 399}},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			e := NewEncoder(exporter.TimeUnitMilliseconds, tcase.conf)
+			b := &bytes.Buffer{}
+			testutil.Ok(t, e.Encode(b, df))
+			testutil.Ok(t, validate(t, e, b.Bytes(), 1000))
+
+			r := encode(t, e, df)
+			defer r.ReadStop()
+			var rows []int64
+			for _, rg := range r.Footer.RowGroups {
+				rows = append(rows, rg.NumRows)
+			}
+			testutil.Equals(t, tcase.expected, rows)
+
+			// The dictionaries are per row group, the values of the later row groups are read as written.
+			vals, _, _, err := r.ReadColumnByIndex(0, 1000)
+			testutil.Ok(t, err)
+			testutil.Equals(t, "instance-99", vals[999])
+		})
+	}
+}
+
+func TestEncoder_OutputLimit(t *testing.T) {
+	ctx := context.Background()
+	bkt := objstore.NewInMemBucket()
+	// The limit is far below the row group size, the rows buffered in the row group count against it.
+	e := exporter.New(NewEncoder(exporter.TimeUnitMilliseconds, Config{}), "out.parquet", bkt, exporter.WithOutputLimit(20000, true))
+	testutil.Ok(t, e.Export(ctx, benchDataframe(1000)))
+
+	outputs := e.Outputs()
+	testutil.Assert(t, len(outputs) > 1, "expected the output rolled over, got %v", outputs)
+	var rows int64
+	for _, o := range outputs {
+		r, err := bkt.Get(ctx, o.Path)
+		testutil.Ok(t, err)
+		b, err := ioutil.ReadAll(r)
+		testutil.Ok(t, err)
+		testutil.Ok(t, r.Close())
+
+		f, err := buffer.NewBufferFile(b)
+		testutil.Ok(t, err)
+		pr, err := reader.NewParquetColumnReader(f, 1)
+		testutil.Ok(t, err)
+		rows += pr.GetNumRows()
+		pr.ReadStop()
+	}
+	testutil.Equals(t, int64(1000), rows)
+}
+
+func TestEncoder_Compression(t *testing.T) {
+	df := benchDataframe(100)
+	for _, tcase := range []struct {
+		compression string
+		expected    parquet.CompressionCodec
+	}{
+		{compression: "", expected: parquet.CompressionCodec_SNAPPY},
+		{compression: "gzip", expected: parquet.CompressionCodec_GZIP},
+		{compression: "ZSTD", expected: parquet.CompressionCodec_ZSTD},
+		{compression: "uncompressed", expected: parquet.CompressionCodec_UNCOMPRESSED},
+	} {
+		t.Run(tcase.compression, func(t *testing.T) {
+			conf, err := ParseConfig([]byte("compression: " + tcase.compression + "\nparallelism: 2"))
+			testutil.Ok(t, err)
+
+			r := encode(t, NewEncoder(exporter.TimeUnitMilliseconds, conf), df)
+			defer r.ReadStop()
+
+			testutil.Equals(t, int64(100), r.GetNumRows())
+			for _, c := range r.Footer.RowGroups[0].Columns {
+				testutil.Equals(t, tcase.expected, c.MetaData.Codec)
+			}
+			vals, _, _, err := r.ReadColumnByIndex(3, 100)
+			testutil.Ok(t, err)
+			testutil.Equals(t, 99.0, vals[99])
+		})
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+	conf, err := ParseConfig([]byte("compression: zstd\nparallelism: 8\nrow_group_size: 1048576\nrow_group_rows: 10000"))
+	testutil.Ok(t, err)
+	testutil.Equals(t, Config{Compression: "zstd", Parallelism: 8, RowGroupSize: 1048576, RowGroupRows: 10000}, conf)
+
+	_, err = ParseConfig([]byte("compression: lz4"))
+	testutil.NotOk(t, err)
+	_, err = ParseConfig([]byte("parallelism: -1"))
+	testutil.NotOk(t, err)
+	_, err = ParseConfig([]byte("row_group_rows: -1"))
+	testutil.NotOk(t, err)
+	_, err = ParseConfig([]byte("level: 3"))
+	testutil.NotOk(t, err)
+}
+
+// benchDataframe returns a dataframe of n rows resembling the aggregated output.
+func benchDataframe(n int) testDataframe {
+	df := testDataframe{
+		schema: dataframe.Schema{
+			{Name: "instance", Type: dataframe.TypeString},
+			{Name: "_series_id", Type: dataframe.TypeUint},
+			{Name: "_sample_start", Type: dataframe.TypeTime},
+			{Name: "_sum", Type: dataframe.TypeFloat},
+		},
+	}
+	start := time.Unix(1600000000, 0)
+	for i := 0; i < n; i++ {
+		df.rows = append(df.rows, dataframe.Row{
+			fmt.Sprintf("instance-%d", i%100), uint64(i % 100), start.Add(time.Duration(i/100) * time.Minute), float64(i),
+		})
+	}
+	return df
+}
+
+func BenchmarkEncoder(b *testing.B) {
+	df := benchDataframe(100000)
+	for _, compression := range []string{"snappy", "gzip", "zstd"} {
+		for _, parallelism := range []int{1, 4} {
+			b.Run(fmt.Sprintf("compression=%s/parallelism=%d", compression, parallelism), func(b *testing.B) {
+				e := NewEncoder(exporter.TimeUnitMilliseconds, Config{Compression: compression, Parallelism: parallelism})
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					buf := &bytes.Buffer{}
+					if err := e.Encode(buf, df); err != nil {
+						b.Fatal(err)
+					}
+					b.SetBytes(int64(buf.Len()))
+				}
+			})
+		}
+	}
+}
+
+// wideDataframe returns a dataframe of n rows of the given number of high cardinality label columns.
+func wideDataframe(n, labels int) testDataframe {
+	df := testDataframe{schema: dataframe.Schema{{Name: "_sample_start", Type: dataframe.TypeTime}}}
+	for l := 0; l < labels; l++ {
+		df.schema = append(df.schema, dataframe.Column{Name: fmt.Sprintf("label_%d", l), Type: dataframe.TypeString})
+	}
+	start := time.Unix(1600000000, 0)
+	for i := 0; i < n; i++ {
+		r := dataframe.Row{start.Add(time.Duration(i) * time.Minute)}
+		for l := 0; l < labels; l++ {
+			r = append(r, fmt.Sprintf("value-%d-%d", l, i))
+		}
+		df.rows = append(df.rows, r)
+	}
+	return df
+}
+
+// peakHeapWriter discards the encoded bytes, sampling the live heap on the first write of every row group, when the
+// encoder buffers the most.
+type peakHeapWriter struct {
+	peak   uint64
+	writes int
+}
+
+func (w *peakHeapWriter) Write(p []byte) (int, error) {
+	// The pages of a row group are written one by one, sampling every page would be too slow.
+	if w.writes%50 == 0 {
+		runtime.GC()
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+		if ms.HeapAlloc > w.peak {
+			w.peak = ms.HeapAlloc
+		}
+	}
+	w.writes++
+	return len(p), nil
+}
+
+// BenchmarkEncoder_PeakHeap reports the peak heap used by the encoding of wide rows, over the heap of the dataframe.
+func BenchmarkEncoder_PeakHeap(b *testing.B) {
+	df := wideDataframe(20000, 100)
+	for _, tcase := range []struct {
+		name string
+		conf Config
+	}{
+		{name: "default", conf: Config{}},
+		{name: "row_group_size=8MB", conf: Config{RowGroupSize: 8 << 20}},
+		{name: "row_group_rows=1000", conf: Config{RowGroupRows: 1000}},
+	} {
+		b.Run(tcase.name, func(b *testing.B) {
+			e := NewEncoder(exporter.TimeUnitMilliseconds, tcase.conf)
+			var peak uint64
+			for i := 0; i < b.N; i++ {
+				runtime.GC()
+				var ms runtime.MemStats
+				runtime.ReadMemStats(&ms)
+				w := &peakHeapWriter{}
+				if err := e.Encode(w, df); err != nil {
+					b.Fatal(err)
+				}
+				if w.peak > ms.HeapAlloc && w.peak-ms.HeapAlloc > peak {
+					peak = w.peak - ms.HeapAlloc
+				}
+			}
+			b.ReportMetric(float64(peak)/(1<<20), "peak-MB")
+		})
+	}
+}
+
+func timeUnitPrecision(u exporter.TimeUnit) time.Duration {
+	if u == exporter.TimeUnitSeconds {
+		return time.Second
+	}
+	return time.Millisecond
+}
@@ -0,0 +1,302 @@
+package promtext
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/thanos-community/obslytics/pkg/dataframe"
+	"github.com/thanos-community/obslytics/pkg/exporter"
+	"gopkg.in/yaml.v2"
+)
+
+// Compile-time check if Prometheus text Encoder implements exporter.Encoder interface.
+var _ exporter.Encoder = &Encoder{}
+
+const (
+	// SamplesLast writes the last window of every series.
+	SamplesLast = "last"
+	// SamplesAll writes every window of every series.
+	SamplesAll = "all"
+)
+
+// Config contains the options of the Prometheus text encoding, given by the PROMTEXT output config.
+type Config struct {
+	// MetricName is the base name of the metrics of the rows without the metric name, neither in the metric name
+	// (__name__) column nor in the labels of their series, e.g. exported with an empty metric-column and shard-merge.
+	// The rows with the metric name are named by it, so that the samples of different metrics are kept apart.
+	MetricName string `yaml:"metric_name"`
+	// Samples selects the written windows: last (default) or all.
+	Samples string `yaml:"samples"`
+	// OmitTimestamps writes the samples without the timestamps, as required e.g. by the Pushgateway. It cannot be used
+	// with all the samples, as the windows of a series would be written as duplicate lines.
+	OmitTimestamps bool `yaml:"omit_timestamps"`
+	// Help is the HELP text of the metrics written for the metric name, e.g. {"up": "Whether the target is up."}.
+	// The inputs do not read the metric metadata, so the HELP lines are written only for the metrics given here.
+	Help map[string]string `yaml:"help"`
+
+	// MetricColumn is the column of the metric name of the rows, __name__ if empty. It's given by the MetricColumn of
+	// the output config rather than by the PROMTEXT config.
+	MetricColumn string `yaml:"-"`
+}
+
+func (c Config) metricColumn() string {
+	if c.MetricColumn == "" {
+		return labels.MetricName
+	}
+	return c.MetricColumn
+}
+
+// ParseConfig parses the YAML Prometheus text configuration.
+func ParseConfig(conf []byte) (Config, error) {
+	config := Config{}
+	if err := yaml.UnmarshalStrict(conf, &config); err != nil {
+		return Config{}, err
+	}
+	if config.MetricName != "" && !model.IsValidMetricName(model.LabelValue(config.MetricName)) {
+		return Config{}, errors.Errorf("invalid metric_name %q", config.MetricName)
+	}
+	switch strings.ToLower(config.Samples) {
+	case "", SamplesLast:
+	case SamplesAll:
+		if config.OmitTimestamps {
+			return Config{}, errors.New("omit_timestamps cannot be used with samples: all, the windows of a series would be duplicate lines")
+		}
+	default:
+		return Config{}, errors.Errorf("unsupported samples %q, expected last or all", config.Samples)
+	}
+	return config, nil
+}
+
+// Encoder writes the dataframe in the Prometheus text exposition format. Every aggregation column becomes a metric
+// of its own, named by the metric name and the column, e.g. the _sum column of "up" is written as "up_sum", with the
+// labels of the row and the window end as the timestamp (in milliseconds, as required by the format, regardless of
+// the timestamp unit). The aggregated values are written as gauges. The rows are named by their metric name column,
+// by the labels of their series if the dataframe keeps them, or by the configured metric_name otherwise.
+//
+// The lines of every metric are written together, as required by the format, so the samples of a metric name are
+// kept in memory until they are written.
+type Encoder struct {
+	conf Config
+	all  bool
+}
+
+// NewEncoder returns Prometheus text encoder. The config is expected to be validated by ParseConfig.
+func NewEncoder(conf Config) *Encoder {
+	return &Encoder{conf: conf, all: strings.ToLower(conf.Samples) == SamplesAll}
+}
+
+func (e *Encoder) Encode(w io.Writer, df dataframe.Dataframe) error {
+	metrics := map[string]*metric{}
+	if sdfs, ok := dataframe.SplitSeries(df); ok {
+		for _, sdf := range sdfs {
+			if err := e.addRows(metrics, sdf, sdf.Labels.Get(labels.MetricName)); err != nil {
+				return err
+			}
+		}
+	} else if err := e.addRows(metrics, df, ""); err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(metrics))
+	for name := range metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := metrics[name].write(w); err != nil {
+			return errors.Wrapf(err, "metric %s", name)
+		}
+	}
+	return nil
+}
+
+// addRows adds the rows of the dataframe to the metrics of their names. The rows without the metric name column are
+// named by the series name, or by the configured metric name if it's empty.
+func (e *Encoder) addRows(metrics map[string]*metric, df dataframe.Dataframe, seriesName string) error {
+	s := df.Schema()
+	nameCol := -1
+	for i, c := range s {
+		if c.Name == e.conf.metricColumn() && c.Type == dataframe.TypeString {
+			nameCol = i
+		}
+	}
+
+	for i := df.RowsIterator(); i.Next(); {
+		r := i.At()
+		name := seriesName
+		if nameCol >= 0 {
+			if n, ok := r[nameCol].(string); ok && n != "" {
+				name = n
+			}
+		}
+		if name == "" {
+			name = e.conf.MetricName
+		}
+		if name == "" {
+			return errors.Errorf("series %s has no metric name, metric_name has to be set", rowLabels(s, r, nameCol))
+		}
+
+		m, ok := metrics[name]
+		if !ok {
+			var err error
+			if m, err = e.newMetric(name, s); err != nil {
+				return errors.Wrapf(err, "metric %s", name)
+			}
+			metrics[name] = m
+		}
+		m.add(r)
+	}
+	return nil
+}
+
+// metric holds the metric families of the aggregation columns of a metric name until they are written.
+type metric struct {
+	schema   dataframe.Schema
+	tsCol    int
+	nameCol  int
+	columns  []int
+	families []*dto.MetricFamily
+	all      bool
+	omitTS   bool
+	// index of the series in the families by the labels, replacing the earlier windows unless all are written.
+	index map[string]int
+	// keys are the labels of the metrics of the families, by position.
+	keys []string
+}
+
+func (e *Encoder) newMetric(name string, s dataframe.Schema) (*metric, error) {
+	m := &metric{schema: s, tsCol: -1, nameCol: -1, all: e.all, omitTS: e.conf.OmitTimestamps, index: map[string]int{}}
+	for i, c := range s {
+		switch {
+		case c.Name == "_sample_end":
+			m.tsCol = i
+		case c.Name == e.conf.metricColumn() && c.Type == dataframe.TypeString:
+			m.nameCol = i
+		}
+		if !isAggregation(c) {
+			continue
+		}
+		fname := name + c.Name
+		if !model.IsValidMetricName(model.LabelValue(fname)) {
+			return nil, errors.Errorf("invalid metric name %q", fname)
+		}
+		mf := &dto.MetricFamily{Name: &fname, Type: dto.MetricType_GAUGE.Enum()}
+		if help, ok := e.conf.Help[name]; ok {
+			mf.Help = &help
+		}
+		m.columns = append(m.columns, i)
+		m.families = append(m.families, mf)
+	}
+	if m.tsCol < 0 {
+		return nil, errors.New("dataframe has no _sample_end column")
+	}
+	return m, nil
+}
+
+func (m *metric) add(r dataframe.Row) {
+	ls := rowLabels(m.schema, r, m.nameCol)
+	var ts *int64
+	if !m.omitTS {
+		t := timestamp.FromTime(r[m.tsCol].(time.Time))
+		ts = &t
+	}
+
+	key := ls.String()
+	pos, ok := m.index[key]
+	if !ok || m.all {
+		pos = len(m.families[0].Metric)
+		m.index[key] = pos
+		m.keys = append(m.keys, key)
+		for _, mf := range m.families {
+			mf.Metric = append(mf.Metric, nil)
+		}
+	}
+	for j, c := range m.columns {
+		m.families[j].Metric[pos] = &dto.Metric{
+			Label:       labelPairs(ls),
+			Gauge:       &dto.Gauge{Value: value(r[c])},
+			TimestampMs: ts,
+		}
+	}
+}
+
+// write writes the families, ending the series once the labels of the written lines change.
+func (m *metric) write(w io.Writer) error {
+	for _, mf := range m.families {
+		if len(mf.Metric) == 0 {
+			continue
+		}
+		b := &bytes.Buffer{}
+		if _, err := expfmt.MetricFamilyToText(b, mf); err != nil {
+			return err
+		}
+		// Every gauge is a single line, after the HELP and TYPE lines.
+		lines := strings.SplitAfter(b.String(), "\n")
+		header := 1
+		if mf.Help != nil {
+			header = 2
+		}
+		if _, err := io.WriteString(w, strings.Join(lines[:header], "")); err != nil {
+			return err
+		}
+		for i := range mf.Metric {
+			if _, err := io.WriteString(w, lines[header+i]); err != nil {
+				return err
+			}
+			if i+1 < len(mf.Metric) && m.keys[i+1] == m.keys[i] {
+				continue
+			}
+			if err := exporter.EndSeries(w); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// isAggregation returns true for the numeric columns holding the aggregated values.
+func isAggregation(c dataframe.Column) bool {
+	return c.Name != "_series_id" && (c.Type == dataframe.TypeFloat || c.Type == dataframe.TypeUint)
+}
+
+// rowLabels returns the labels of the row, given by its non-empty string columns. The metric name column, nameCol if
+// any, is skipped, the written metrics are named by the aggregation columns.
+func rowLabels(s dataframe.Schema, r dataframe.Row, nameCol int) labels.Labels {
+	var ls labels.Labels
+	for i, c := range s {
+		if c.Type == dataframe.TypeString && i != nameCol && c.Name != labels.MetricName && r[i] != nil && r[i].(string) != "" {
+			ls = append(ls, labels.Label{Name: c.Name, Value: r[i].(string)})
+		}
+	}
+	sort.Sort(ls)
+	return ls
+}
+
+func labelPairs(ls labels.Labels) []*dto.LabelPair {
+	ret := make([]*dto.LabelPair, 0, len(ls))
+	for i := range ls {
+		ret = append(ret, &dto.LabelPair{Name: &ls[i].Name, Value: &ls[i].Value})
+	}
+	return ret
+}
+
+func value(v interface{}) *float64 {
+	f := math.NaN()
+	switch v := v.(type) {
+	case float64:
+		f = v
+	case uint64:
+		f = float64(v)
+	}
+	return &f
+}
@@ -0,0 +1,209 @@
+package promtext
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/tsdbutil"
+	"github.com/thanos-community/obslytics/pkg/dataframe"
+	"github.com/thanos-community/obslytics/pkg/exporter/memory"
+	"github.com/thanos-io/thanos/pkg/testutil"
+
+	inmemory "github.com/thanos-community/obslytics/pkg/series/memory"
+)
+
+// testFrame returns the frame of the aggregated windows of the rows, each of the metric name, the label and its value,
+// the window end in seconds and the count and sum of the window.
+func testFrame(rows ...[]interface{}) memory.Frame {
+	f := memory.Frame{Columns: dataframe.Schema{
+		{Name: "__name__", Type: dataframe.TypeString},
+		{Name: "instance", Type: dataframe.TypeString},
+		{Name: "path", Type: dataframe.TypeString},
+		{Name: "_sample_end", Type: dataframe.TypeTime},
+		{Name: "_count", Type: dataframe.TypeUint},
+		{Name: "_sum", Type: dataframe.TypeFloat},
+	}}
+	for _, r := range rows {
+		f.Rows = append(f.Rows, dataframe.Row{r[0], r[1], r[2], time.Unix(int64(r[3].(int)), 0), uint64(r[4].(int)), r[5]})
+	}
+	return f
+}
+
+func TestEncoder(t *testing.T) {
+	frame := testFrame(
+		[]interface{}{"up", "a", "", 60, 2, 1.0},
+		[]interface{}{"up", "a", "", 120, 1, 1.0},
+		[]interface{}{"down", "", "C:\\dir \"x\"\n", 60, 1, 2.0},
+		[]interface{}{"up", "b", "", 60, 1, 1.0},
+		[]interface{}{"", "c", "", 60, 1, 5.0},
+	)
+
+	for _, tcase := range []struct {
+		name string
+		conf Config
+		exp  string
+	}{
+		{
+			name: "last",
+			conf: Config{MetricName: "m", Help: map[string]string{"up": "Whether\nthe target is up."}},
+			exp: `# TYPE down_count gauge
+down_count{path="C:\\dir \"x\"\n"} 1 60000
+# TYPE down_sum gauge
+down_sum{path="C:\\dir \"x\"\n"} 2 60000
+# TYPE m_count gauge
+m_count{instance="c"} 1 60000
+# TYPE m_sum gauge
+m_sum{instance="c"} 5 60000
+# HELP up_count Whether\nthe target is up.
+# TYPE up_count gauge
+up_count{instance="a"} 1 120000
+up_count{instance="b"} 1 60000
+# HELP up_sum Whether\nthe target is up.
+# TYPE up_sum gauge
+up_sum{instance="a"} 1 120000
+up_sum{instance="b"} 1 60000
+`,
+		},
+		{
+			name: "all",
+			conf: Config{MetricName: "m", Samples: "all"},
+			exp: `# TYPE down_count gauge
+down_count{path="C:\\dir \"x\"\n"} 1 60000
+# TYPE down_sum gauge
+down_sum{path="C:\\dir \"x\"\n"} 2 60000
+# TYPE m_count gauge
+m_count{instance="c"} 1 60000
+# TYPE m_sum gauge
+m_sum{instance="c"} 5 60000
+# TYPE up_count gauge
+up_count{instance="a"} 2 60000
+up_count{instance="a"} 1 120000
+up_count{instance="b"} 1 60000
+# TYPE up_sum gauge
+up_sum{instance="a"} 1 60000
+up_sum{instance="a"} 1 120000
+up_sum{instance="b"} 1 60000
+`,
+		},
+		{
+			name: "last without timestamps",
+			conf: Config{MetricName: "m", OmitTimestamps: true},
+			exp: `# TYPE down_count gauge
+down_count{path="C:\\dir \"x\"\n"} 1
+# TYPE down_sum gauge
+down_sum{path="C:\\dir \"x\"\n"} 2
+# TYPE m_count gauge
+m_count{instance="c"} 1
+# TYPE m_sum gauge
+m_sum{instance="c"} 5
+# TYPE up_count gauge
+up_count{instance="a"} 1
+up_count{instance="b"} 1
+# TYPE up_sum gauge
+up_sum{instance="a"} 1
+up_sum{instance="b"} 1
+`,
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			var b bytes.Buffer
+			testutil.Ok(t, NewEncoder(tcase.conf).Encode(&b, frame))
+			testutil.Equals(t, tcase.exp, b.String())
+
+			_, err := (&expfmt.TextParser{}).TextToMetricFamilies(&b)
+			testutil.Ok(t, err)
+		})
+	}
+}
+
+// seriesWriter records the output written by every series, up to its end.
+type seriesWriter struct {
+	bytes.Buffer
+	ends []string
+}
+
+func (w *seriesWriter) EndSeries() error {
+	w.ends = append(w.ends, w.String())
+	w.Reset()
+	return nil
+}
+
+func TestEncoder_EndSeries(t *testing.T) {
+	frame := testFrame(
+		[]interface{}{"up", "a", "", 60, 1, 1.0},
+		[]interface{}{"up", "a", "", 120, 1, 2.0},
+		[]interface{}{"up", "b", "", 60, 1, 3.0},
+	)
+	var w seriesWriter
+	testutil.Ok(t, NewEncoder(Config{Samples: "all", Help: map[string]string{"up": "Up."}}).Encode(&w, frame))
+
+	// The series end once the labels of the written lines change.
+	testutil.Equals(t, []string{
+		"# HELP up_count Up.\n# TYPE up_count gauge\nup_count{instance=\"a\"} 1 60000\nup_count{instance=\"a\"} 1 120000\n",
+		"up_count{instance=\"b\"} 1 60000\n",
+		"# HELP up_sum Up.\n# TYPE up_sum gauge\nup_sum{instance=\"a\"} 1 60000\nup_sum{instance=\"a\"} 2 120000\n",
+		"up_sum{instance=\"b\"} 3 60000\n",
+	}, w.ends)
+}
+
+type sample struct {
+	t int64
+	v float64
+}
+
+func (s sample) T() int64   { return s.t }
+func (s sample) V() float64 { return s.v }
+
+func TestEncoder_SeriesNames(t *testing.T) {
+	// Without the metric name column, the series are named by their labels.
+	df, err := dataframe.FromSeries(inmemory.NewSet(
+		storage.NewListSeries(labels.FromStrings("__name__", "up", "instance", "a"), []tsdbutil.Sample{sample{t: 0, v: 1}}),
+		storage.NewListSeries(labels.FromStrings("instance", "b"), []tsdbutil.Sample{sample{t: 0, v: 2}}),
+	), time.Minute, func(o *dataframe.AggrsOptions) { o.Sum.Enabled = true })
+	testutil.Ok(t, err)
+
+	var b bytes.Buffer
+	testutil.Ok(t, NewEncoder(Config{MetricName: "m"}).Encode(&b, df))
+	testutil.Equals(t, "# TYPE m_sum gauge\nm_sum{instance=\"b\"} 2 60000\n# TYPE up_sum gauge\nup_sum{instance=\"a\"} 1 60000\n", b.String())
+
+	// The rows of the merged dataframe have no metric name.
+	b.Reset()
+	testutil.Ok(t, NewEncoder(Config{MetricName: "m"}).Encode(&b, dataframe.Merge(df)))
+	testutil.Equals(t, "# TYPE m_sum gauge\nm_sum{instance=\"a\"} 1 60000\nm_sum{instance=\"b\"} 2 60000\n", b.String())
+
+	testutil.NotOk(t, NewEncoder(Config{}).Encode(&bytes.Buffer{}, df))
+	testutil.NotOk(t, NewEncoder(Config{}).Encode(&bytes.Buffer{}, dataframe.Merge(df)))
+
+	// The metric name column is given by its configured name, not written as a label.
+	df, err = dataframe.FromSeries(inmemory.NewSet(
+		storage.NewListSeries(labels.FromStrings("__name__", "up", "instance", "a"), []tsdbutil.Sample{sample{t: 0, v: 1}}),
+	), time.Minute, func(o *dataframe.AggrsOptions) {
+		o.Sum.Enabled = true
+		o.MetricName.Enabled = true
+		o.MetricName.Column = "metric"
+	})
+	testutil.Ok(t, err)
+	b.Reset()
+	testutil.Ok(t, NewEncoder(Config{MetricColumn: "metric"}).Encode(&b, dataframe.Merge(df)))
+	testutil.Equals(t, "# TYPE up_sum gauge\nup_sum{instance=\"a\"} 1 60000\n", b.String())
+}
+
+func TestParseConfig(t *testing.T) {
+	conf, err := ParseConfig([]byte("metric_name: m\nsamples: ALL\nhelp:\n  up: Up."))
+	testutil.Ok(t, err)
+	testutil.Equals(t, Config{MetricName: "m", Samples: "ALL", Help: map[string]string{"up": "Up."}}, conf)
+
+	for _, c := range []string{
+		"metric_name: 1m",
+		"samples: first",
+		"samples: all\nomit_timestamps: true",
+		"unknown: 1",
+	} {
+		_, err := ParseConfig([]byte(c))
+		testutil.NotOk(t, err, c)
+	}
+}
@@ -0,0 +1,348 @@
+package remotewrite
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/golang/snappy"
+	"github.com/pkg/errors"
+	config_util "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/thanos-community/obslytics/pkg/dataframe"
+	"github.com/thanos-community/obslytics/pkg/exporter"
+	"github.com/thanos-community/obslytics/pkg/version"
+	"gopkg.in/yaml.v2"
+)
+
+// Compile-time check if remote write Writer implements exporter.Writer interface.
+var _ exporter.Writer = &Writer{}
+
+// Config contains the options of the remote write client. TLS and authentication options (tls_config,
+// basic_auth, bearer_token etc.) are the same as in the Prometheus remote_write configuration.
+type Config struct {
+	URL string `yaml:"url"`
+	// MetricName is the base name of the series of the rows without the metric name (__name__) column, e.g. exported
+	// with an empty metric-column. Every aggregation column becomes a separate series named by the metric and the
+	// column, e.g. the _sum column of "up" is written as "up_sum". The rows with the metric name are named by it, so
+	// that the samples of different metrics are kept apart.
+	MetricName string `yaml:"metric_name"`
+	// MaxSamplesPerSend is the maximum number of samples in a single request.
+	MaxSamplesPerSend int `yaml:"max_samples_per_send"`
+	// RemoteTimeout is the timeout of a single request.
+	RemoteTimeout model.Duration `yaml:"remote_timeout"`
+	// MaxRetries is the number of retries of requests failing with 5xx or 429 (Too Many Requests) status.
+	// The Retry-After header of 429 responses is honored, exponential backoff is used otherwise.
+	MaxRetries int            `yaml:"max_retries"`
+	MinBackoff model.Duration `yaml:"min_backoff"`
+	MaxBackoff model.Duration `yaml:"max_backoff"`
+
+	HTTPClientConfig config_util.HTTPClientConfig `yaml:",inline"`
+
+	// MetricColumn is the column of the metric name of the rows, __name__ if empty. It's given by the MetricColumn of
+	// the output config rather than by the REMOTEWRITE config.
+	MetricColumn string `yaml:"-"`
+}
+
+func (c Config) metricColumn() string {
+	if c.MetricColumn == "" {
+		return labels.MetricName
+	}
+	return c.MetricColumn
+}
+
+// ParseConfig parses the YAML remote write configuration.
+func ParseConfig(conf []byte) (Config, error) {
+	config := Config{
+		MaxSamplesPerSend: 500,
+		RemoteTimeout:     model.Duration(30 * time.Second),
+		MaxRetries:        10,
+		MinBackoff:        model.Duration(30 * time.Millisecond),
+		MaxBackoff:        model.Duration(5 * time.Second),
+		HTTPClientConfig:  config_util.DefaultHTTPClientConfig,
+	}
+	if err := yaml.UnmarshalStrict(conf, &config); err != nil {
+		return Config{}, err
+	}
+	if _, err := url.Parse(config.URL); err != nil || config.URL == "" {
+		return Config{}, errors.Errorf("invalid remote write url %q", config.URL)
+	}
+	if config.MetricName != "" && !model.IsValidMetricName(model.LabelValue(config.MetricName)) {
+		return Config{}, errors.Errorf("invalid metric_name %q", config.MetricName)
+	}
+	if config.MaxSamplesPerSend <= 0 {
+		return Config{}, errors.New("max_samples_per_send has to be positive")
+	}
+	if config.MaxRetries < 0 {
+		return Config{}, errors.New("max_retries cannot be negative")
+	}
+	if err := config.HTTPClientConfig.Validate(); err != nil {
+		return Config{}, err
+	}
+	return config, nil
+}
+
+// Writer pushes the dataframe rows to a Prometheus compatible remote write endpoint. Every aggregation column
+// of a row becomes a sample of its own series, with the labels of the row and the window end as the timestamp.
+type Writer struct {
+	logger log.Logger
+	conf   Config
+
+	maxBytes int64
+	written  int64
+}
+
+// NewWriter returns Writer for the given configuration. If maxBytes is positive, Export stops with
+// exporter.ErrOutputLimitReached once the sent (compressed) requests reach maxBytes.
+func NewWriter(logger log.Logger, conf Config, maxBytes int64) *Writer {
+	return &Writer{logger: logger, conf: conf, maxBytes: maxBytes}
+}
+
+// Export sends the dataframe rows in batches of at most MaxSamplesPerSend samples. On error, part of the rows
+// might have been sent already.
+func (w *Writer) Export(ctx context.Context, df dataframe.Dataframe) error {
+	client, err := config_util.NewClientFromConfig(w.conf.HTTPClientConfig, "remote_write")
+	if err != nil {
+		return err
+	}
+
+	s := df.Schema()
+	tsCol, nameCol := -1, -1
+	for i, c := range s {
+		switch {
+		case c.Name == "_sample_end":
+			tsCol = i
+		case c.Name == w.conf.metricColumn() && c.Type == dataframe.TypeString:
+			nameCol = i
+		}
+	}
+	if tsCol < 0 {
+		return errors.New("dataframe has no _sample_end column")
+	}
+
+	b := newBatch()
+	flush := func() error {
+		if b.samples == 0 {
+			return nil
+		}
+		req, err := b.request()
+		if err != nil {
+			return err
+		}
+		if w.maxBytes > 0 && w.written+int64(len(req)) > w.maxBytes {
+			return errors.Wrapf(exporter.ErrOutputLimitReached, "stopped after %d bytes", w.written)
+		}
+		if err := w.send(ctx, client, req); err != nil {
+			return err
+		}
+		w.written += int64(len(req))
+		b = newBatch()
+		return nil
+	}
+
+	i := df.RowsIterator()
+	for i.Next() {
+		r := i.At()
+		ts := timestamp.FromTime(r[tsCol].(time.Time))
+		lset := rowLabels(s, r, nameCol)
+		metric := w.conf.MetricName
+		if nameCol >= 0 {
+			if n, ok := r[nameCol].(string); ok && n != "" {
+				metric = n
+			}
+		}
+		if metric == "" {
+			return errors.Errorf("series %s has no metric name, see metric_name", lset)
+		}
+		for j, c := range s {
+			if !isAggregation(c) {
+				continue
+			}
+			b.add(lset, metric+c.Name, ts, r[j])
+		}
+		if b.samples >= w.conf.MaxSamplesPerSend {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
+
+// Outputs returns the endpoint with the size of the requests sent so far.
+func (w *Writer) Outputs() []exporter.Output {
+	return []exporter.Output{{Path: w.conf.URL, Bytes: w.written}}
+}
+
+// recoverableError is returned for responses worth retrying. retryAfter is the delay requested by the server.
+type recoverableError struct {
+	error
+	retryAfter time.Duration
+}
+
+// send posts the compressed request, retrying recoverable failures with backoff.
+func (w *Writer) send(ctx context.Context, client *http.Client, req []byte) error {
+	backoff := time.Duration(w.conf.MinBackoff)
+	for try := 0; ; try++ {
+		err := w.post(ctx, client, req)
+		if err == nil {
+			return nil
+		}
+		var rerr recoverableError
+		if !errors.As(err, &rerr) || try >= w.conf.MaxRetries {
+			return err
+		}
+
+		sleep := backoff
+		if rerr.retryAfter > 0 {
+			sleep = rerr.retryAfter
+		}
+		level.Warn(w.logger).Log("msg", "remote write failed, retrying", "err", err, "backoff", sleep)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(sleep):
+		}
+
+		backoff *= 2
+		if backoff > time.Duration(w.conf.MaxBackoff) {
+			backoff = time.Duration(w.conf.MaxBackoff)
+		}
+	}
+}
+
+func (w *Writer) post(parent context.Context, client *http.Client, req []byte) error {
+	ctx, cancel := context.WithTimeout(parent, time.Duration(w.conf.RemoteTimeout))
+	defer cancel()
+
+	httpReq, err := http.NewRequest(http.MethodPost, w.conf.URL, bytes.NewReader(req))
+	if err != nil {
+		return errors.Wrap(err, "create request")
+	}
+	httpReq.Header.Add("Content-Encoding", "snappy")
+	httpReq.Header.Set("Content-Type", "application/x-protobuf")
+	httpReq.Header.Set("User-Agent", path.Join("obslytics", version.Version))
+	httpReq.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	resp, err := client.Do(httpReq.WithContext(ctx))
+	if err != nil {
+		// Network errors and the timeouts of the request are worth retrying, unless the export is canceled.
+		if parent.Err() != nil {
+			return err
+		}
+		return recoverableError{error: err}
+	}
+	defer func() {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	line := ""
+	if scanner := bufio.NewScanner(io.LimitReader(resp.Body, 512)); scanner.Scan() {
+		line = scanner.Text()
+	}
+	err = errors.Errorf("server returned HTTP status %s: %s", resp.Status, line)
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return recoverableError{error: err, retryAfter: retryAfter(resp.Header.Get("Retry-After"))}
+	case resp.StatusCode/100 == 5:
+		return recoverableError{error: err}
+	}
+	return err
+}
+
+// retryAfter parses the Retry-After header given in seconds or as HTTP date. Returns 0 if not set or invalid.
+func retryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if s, err := strconv.Atoi(v); err == nil {
+		return time.Duration(s) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+// isAggregation returns true for the numeric columns holding the aggregated values.
+func isAggregation(c dataframe.Column) bool {
+	return c.Name != "_series_id" && (c.Type == dataframe.TypeFloat || c.Type == dataframe.TypeUint)
+}
+
+// rowLabels returns the labels of the row, given by its non-empty string columns. The metric name column is skipped,
+// the written metrics are named by the aggregation columns.
+func rowLabels(s dataframe.Schema, r dataframe.Row, nameCol int) labels.Labels {
+	var ls labels.Labels
+	for i, c := range s {
+		if c.Type == dataframe.TypeString && i != nameCol && c.Name != labels.MetricName && r[i] != nil && r[i].(string) != "" {
+			ls = append(ls, labels.Label{Name: c.Name, Value: r[i].(string)})
+		}
+	}
+	return ls
+}
+
+// batch collects the samples of a single write request, grouped by series.
+type batch struct {
+	series  map[string]*prompb.TimeSeries
+	order   []string
+	samples int
+}
+
+func newBatch() *batch {
+	return &batch{series: map[string]*prompb.TimeSeries{}}
+}
+
+func (b *batch) add(lset labels.Labels, name string, ts int64, v interface{}) {
+	key := name + "\xff" + lset.String()
+	s, ok := b.series[key]
+	if !ok {
+		ls := append(labels.Labels{{Name: labels.MetricName, Value: name}}, lset...)
+		sort.Sort(ls)
+		s = &prompb.TimeSeries{Labels: make([]prompb.Label, 0, len(ls))}
+		for _, l := range ls {
+			s.Labels = append(s.Labels, prompb.Label{Name: l.Name, Value: l.Value})
+		}
+		b.series[key] = s
+		b.order = append(b.order, key)
+	}
+
+	var f float64
+	switch v := v.(type) {
+	case float64:
+		f = v
+	case uint64:
+		f = float64(v)
+	}
+	s.Samples = append(s.Samples, prompb.Sample{Value: f, Timestamp: ts})
+	b.samples++
+}
+
+// request returns the snappy compressed write request.
+func (b *batch) request() ([]byte, error) {
+	req := &prompb.WriteRequest{Timeseries: make([]prompb.TimeSeries, 0, len(b.order))}
+	for _, k := range b.order {
+		req.Timeseries = append(req.Timeseries, *b.series[k])
+	}
+	data, err := req.Marshal()
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal write request")
+	}
+	return snappy.Encode(nil, data), nil
+}
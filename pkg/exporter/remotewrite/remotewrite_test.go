@@ -0,0 +1,226 @@
+package remotewrite
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/thanos-community/obslytics/pkg/dataframe"
+	"github.com/thanos-community/obslytics/pkg/exporter/memory"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+type testDataframe []dataframe.Row
+
+func (d testDataframe) Schema() dataframe.Schema {
+	return dataframe.Schema{
+		{Name: "job", Type: dataframe.TypeString},
+		{Name: "_series_id", Type: dataframe.TypeUint},
+		{Name: "_sample_start", Type: dataframe.TypeTime},
+		{Name: "_sample_end", Type: dataframe.TypeTime},
+		{Name: "_count", Type: dataframe.TypeUint},
+		{Name: "_sum", Type: dataframe.TypeFloat},
+	}
+}
+
+func (d testDataframe) RowsIterator() dataframe.RowsIterator {
+	return &testRowsIterator{rows: d, i: -1}
+}
+
+type testRowsIterator struct {
+	rows []dataframe.Row
+	i    int
+}
+
+func (it *testRowsIterator) Next() bool {
+	it.i++
+	return it.i < len(it.rows)
+}
+
+func (it *testRowsIterator) At() dataframe.Row { return it.rows[it.i] }
+
+func TestParseConfig(t *testing.T) {
+	conf, err := ParseConfig([]byte("url: http://localhost:9090/api/v1/write\nmetric_name: up\nbasic_auth:\n  username: a\n  password: b\n"))
+	testutil.Ok(t, err)
+	testutil.Equals(t, 500, conf.MaxSamplesPerSend)
+	testutil.Equals(t, "a", conf.HTTPClientConfig.BasicAuth.Username)
+
+	_, err = ParseConfig([]byte("url: http://localhost:9090/api/v1/write\n"))
+	testutil.Ok(t, err)
+	_, err = ParseConfig([]byte("url: http://localhost:9090/api/v1/write\nmetric_name: 1up\n"))
+	testutil.NotOk(t, err)
+	_, err = ParseConfig([]byte("url: http://localhost:9090/api/v1/write\nmetric_name: up\nunknown: true\n"))
+	testutil.NotOk(t, err)
+}
+
+func TestWriter_Export(t *testing.T) {
+	var (
+		mtx   sync.Mutex
+		calls int
+		reqs  []prompb.WriteRequest
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		defer mtx.Unlock()
+
+		calls++
+		if calls == 1 {
+			// The receiver is overloaded at first.
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+
+		b, err := ioutil.ReadAll(r.Body)
+		testutil.Ok(t, err)
+		data, err := snappy.Decode(nil, b)
+		testutil.Ok(t, err)
+		var req prompb.WriteRequest
+		testutil.Ok(t, req.Unmarshal(data))
+		reqs = append(reqs, req)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	conf, err := ParseConfig([]byte("url: " + srv.URL + "\nmetric_name: up\nmax_samples_per_send: 4\nmin_backoff: 1ms\n"))
+	testutil.Ok(t, err)
+	w := NewWriter(log.NewNopLogger(), conf, 0)
+
+	df := testDataframe{
+		{"a", uint64(1), time.Unix(0, 0), time.Unix(60, 0), uint64(3), 1.5},
+		{"a", uint64(1), time.Unix(60, 0), time.Unix(120, 0), uint64(2), 2.5},
+		{"b", uint64(2), time.Unix(0, 0), time.Unix(60, 0), uint64(1), 0.5},
+	}
+	testutil.Ok(t, w.Export(context.Background(), df))
+
+	testutil.Equals(t, 3, calls)
+	testutil.Equals(t, 2, len(reqs))
+	testutil.Equals(t, []prompb.TimeSeries{
+		{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "up_count"}, {Name: "job", Value: "a"}},
+			Samples: []prompb.Sample{{Value: 3, Timestamp: 60000}, {Value: 2, Timestamp: 120000}},
+		},
+		{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "up_sum"}, {Name: "job", Value: "a"}},
+			Samples: []prompb.Sample{{Value: 1.5, Timestamp: 60000}, {Value: 2.5, Timestamp: 120000}},
+		},
+	}, reqs[0].Timeseries)
+	testutil.Equals(t, 2, len(reqs[1].Timeseries))
+	testutil.Equals(t, srv.URL, w.Outputs()[0].Path)
+	testutil.Assert(t, w.Outputs()[0].Bytes > 0)
+}
+
+func TestWriter_Export_NonRecoverable(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		http.Error(w, "out of order sample", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	conf, err := ParseConfig([]byte("url: " + srv.URL + "\nmetric_name: up\n"))
+	testutil.Ok(t, err)
+	err = NewWriter(log.NewNopLogger(), conf, 0).Export(context.Background(), testDataframe{
+		{"a", uint64(1), time.Unix(0, 0), time.Unix(60, 0), uint64(3), 1.5},
+	})
+	testutil.NotOk(t, err)
+	testutil.Equals(t, 1, calls)
+}
+
+func TestWriter_Export_MetricNames(t *testing.T) {
+	var req prompb.WriteRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := ioutil.ReadAll(r.Body)
+		testutil.Ok(t, err)
+		data, err := snappy.Decode(nil, b)
+		testutil.Ok(t, err)
+		testutil.Ok(t, req.Unmarshal(data))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	df := memory.Frame{
+		Columns: dataframe.Schema{
+			{Name: "__name__", Type: dataframe.TypeString},
+			{Name: "job", Type: dataframe.TypeString},
+			{Name: "_sample_end", Type: dataframe.TypeTime},
+			{Name: "_sum", Type: dataframe.TypeFloat},
+		},
+		Rows: []dataframe.Row{
+			{"up", "a", time.Unix(60, 0), 1.0},
+			{"scrape_duration_seconds", "a", time.Unix(60, 0), 0.5},
+			{nil, "a", time.Unix(60, 0), 2.0},
+		},
+	}
+
+	// The series of every metric are kept apart, the rows without the metric name are named by metric_name.
+	conf, err := ParseConfig([]byte("url: " + srv.URL + "\nmetric_name: other\n"))
+	testutil.Ok(t, err)
+	testutil.Ok(t, NewWriter(log.NewNopLogger(), conf, 0).Export(context.Background(), df))
+	testutil.Equals(t, []prompb.TimeSeries{
+		{Labels: []prompb.Label{{Name: "__name__", Value: "up_sum"}, {Name: "job", Value: "a"}}, Samples: []prompb.Sample{{Value: 1, Timestamp: 60000}}},
+		{Labels: []prompb.Label{{Name: "__name__", Value: "scrape_duration_seconds_sum"}, {Name: "job", Value: "a"}}, Samples: []prompb.Sample{{Value: 0.5, Timestamp: 60000}}},
+		{Labels: []prompb.Label{{Name: "__name__", Value: "other_sum"}, {Name: "job", Value: "a"}}, Samples: []prompb.Sample{{Value: 2, Timestamp: 60000}}},
+	}, req.Timeseries)
+
+	// The metric name column is given by its configured name, not written as a label.
+	df.Columns[0].Name = "metric"
+	conf.MetricColumn = "metric"
+	req = prompb.WriteRequest{}
+	testutil.Ok(t, NewWriter(log.NewNopLogger(), conf, 0).Export(context.Background(), df))
+	testutil.Equals(t, []prompb.TimeSeries{
+		{Labels: []prompb.Label{{Name: "__name__", Value: "up_sum"}, {Name: "job", Value: "a"}}, Samples: []prompb.Sample{{Value: 1, Timestamp: 60000}}},
+		{Labels: []prompb.Label{{Name: "__name__", Value: "scrape_duration_seconds_sum"}, {Name: "job", Value: "a"}}, Samples: []prompb.Sample{{Value: 0.5, Timestamp: 60000}}},
+		{Labels: []prompb.Label{{Name: "__name__", Value: "other_sum"}, {Name: "job", Value: "a"}}, Samples: []prompb.Sample{{Value: 2, Timestamp: 60000}}},
+	}, req.Timeseries)
+
+	conf, err = ParseConfig([]byte("url: " + srv.URL + "\n"))
+	testutil.Ok(t, err)
+	testutil.NotOk(t, NewWriter(log.NewNopLogger(), conf, 0).Export(context.Background(), df))
+}
+
+func TestWriter_Export_Timeout(t *testing.T) {
+	var (
+		mtx   sync.Mutex
+		calls int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		calls++
+		first := calls == 1
+		mtx.Unlock()
+		if first {
+			// The first request hangs past the remote timeout.
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	conf, err := ParseConfig([]byte("url: " + srv.URL + "\nmetric_name: up\nremote_timeout: 50ms\nmin_backoff: 1ms\n"))
+	testutil.Ok(t, err)
+	testutil.Ok(t, NewWriter(log.NewNopLogger(), conf, 0).Export(context.Background(), testDataframe{
+		{"a", uint64(1), time.Unix(0, 0), time.Unix(60, 0), uint64(3), 1.5},
+	}))
+	testutil.Equals(t, 2, calls)
+
+	// The canceled export is not retried.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	calls = 0
+	testutil.NotOk(t, NewWriter(log.NewNopLogger(), conf, 0).Export(ctx, testDataframe{
+		{"a", uint64(1), time.Unix(0, 0), time.Unix(60, 0), uint64(3), 1.5},
+	}))
+	testutil.Equals(t, 0, calls)
+}
@@ -0,0 +1,111 @@
+package exporter
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+)
+
+const (
+	defaultRetryMinBackoff = 100 * time.Millisecond
+	defaultRetryMaxBackoff = 10 * time.Second
+)
+
+// RetryConfig configures retrying the failed writes of the outputs with exponential backoff. The outputs retry the
+// writes that are safe to repeat only, see the output types.
+type RetryConfig struct {
+	// MaxAttempts is the number of attempts of a write. 0 or 1 (default) fails on the first error.
+	MaxAttempts int `yaml:"max_attempts"`
+	// MinBackoff is the wait before the second attempt, doubled by every following one up to MaxBackoff. Defaults
+	// to 100ms and 10s.
+	MinBackoff model.Duration `yaml:"min_backoff"`
+	MaxBackoff model.Duration `yaml:"max_backoff"`
+	// Jitter is the fraction of the backoff randomized, so that concurrent exports do not retry in lockstep, e.g. 0.2
+	// waits from 80% to 120% of the backoff. No jitter by default.
+	Jitter float64 `yaml:"jitter"`
+}
+
+// Validate returns error if the options are out of range.
+func (c RetryConfig) Validate() error {
+	switch {
+	case c.MaxAttempts < 0:
+		return errors.New("retry max_attempts cannot be negative")
+	case c.MinBackoff < 0 || c.MaxBackoff < 0:
+		return errors.New("retry backoff cannot be negative")
+	case c.Jitter < 0 || c.Jitter > 1:
+		return errors.Errorf("retry jitter has to be from 0 to 1, got %v", c.Jitter)
+	}
+	return nil
+}
+
+// Enabled returns true if the writes are attempted more than once.
+func (c RetryConfig) Enabled() bool { return c.MaxAttempts > 1 }
+
+// permanentError is an error not worth retrying.
+type permanentError struct {
+	error
+}
+
+func (e permanentError) Cause() error  { return e.error }
+func (e permanentError) Unwrap() error { return e.error }
+
+// Permanent marks the error as not worth retrying, e.g. of failed authentication or of data rejected by the sink.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{error: err}
+}
+
+// IsPermanent returns true if the error, or any error it wraps, is marked by Permanent.
+func IsPermanent(err error) bool {
+	var perr permanentError
+	return errors.As(err, &perr)
+}
+
+// Retry calls the write until it succeeds, fails with a permanent error, the attempts are exhausted or the context is
+// done. The last error is returned.
+func Retry(ctx context.Context, logger log.Logger, conf RetryConfig, write func() error) error {
+	backoff, maxBackoff := time.Duration(conf.MinBackoff), time.Duration(conf.MaxBackoff)
+	if backoff == 0 {
+		backoff = defaultRetryMinBackoff
+	}
+	if maxBackoff == 0 {
+		maxBackoff = defaultRetryMaxBackoff
+	}
+	for attempt := 1; ; attempt++ {
+		err := write()
+		if err == nil {
+			return nil
+		}
+		if attempt >= conf.MaxAttempts || IsPermanent(err) || ctx.Err() != nil {
+			return err
+		}
+
+		sleep := jitter(backoff, conf.Jitter)
+		level.Warn(logger).Log("msg", "write failed, retrying", "err", err, "attempt", attempt, "backoff", sleep)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(sleep):
+		}
+
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// jitter returns the duration randomized by up to the fraction of it, in both directions.
+func jitter(d time.Duration, fraction float64) time.Duration {
+	if fraction == 0 {
+		return d
+	}
+	return d + time.Duration((2*rand.Float64()-1)*fraction*float64(d))
+}
@@ -0,0 +1,70 @@
+package exporter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestRetry(t *testing.T) {
+	ctx := context.Background()
+	conf := RetryConfig{MaxAttempts: 3, MinBackoff: model.Duration(time.Millisecond), Jitter: 0.5}
+
+	failing := func(failures int, err error) (func() error, *int) {
+		attempts := 0
+		return func() error {
+			attempts++
+			if attempts <= failures {
+				return err
+			}
+			return nil
+		}, &attempts
+	}
+
+	f, attempts := failing(2, errors.New("transient"))
+	testutil.Ok(t, Retry(ctx, log.NewNopLogger(), conf, f))
+	testutil.Equals(t, 3, *attempts)
+
+	f, attempts = failing(3, errors.New("transient"))
+	testutil.NotOk(t, Retry(ctx, log.NewNopLogger(), conf, f))
+	testutil.Equals(t, 3, *attempts)
+
+	// Permanent errors fail fast, also when wrapped.
+	f, attempts = failing(3, errors.Wrap(Permanent(errors.New("unauthorized")), "upload"))
+	err := Retry(ctx, log.NewNopLogger(), conf, f)
+	testutil.NotOk(t, err)
+	testutil.Equals(t, "upload: unauthorized", err.Error())
+	testutil.Equals(t, 1, *attempts)
+
+	// No retries by default.
+	f, attempts = failing(1, errors.New("transient"))
+	testutil.NotOk(t, Retry(ctx, log.NewNopLogger(), RetryConfig{}, f))
+	testutil.Equals(t, 1, *attempts)
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	f, attempts = failing(3, errors.New("transient"))
+	testutil.NotOk(t, Retry(cctx, log.NewNopLogger(), conf, f))
+	testutil.Equals(t, 1, *attempts)
+}
+
+func TestJitter(t *testing.T) {
+	testutil.Equals(t, time.Second, jitter(time.Second, 0))
+	for i := 0; i < 100; i++ {
+		d := jitter(time.Second, 0.2)
+		testutil.Assert(t, d >= 800*time.Millisecond && d <= 1200*time.Millisecond, "jitter out of range: %v", d)
+	}
+}
+
+func TestRetryConfig_Validate(t *testing.T) {
+	testutil.Ok(t, RetryConfig{}.Validate())
+	testutil.Ok(t, RetryConfig{MaxAttempts: 5, Jitter: 1}.Validate())
+	testutil.NotOk(t, RetryConfig{MaxAttempts: -1}.Validate())
+	testutil.NotOk(t, RetryConfig{MinBackoff: -1}.Validate())
+	testutil.NotOk(t, RetryConfig{Jitter: 1.5}.Validate())
+}
@@ -0,0 +1,42 @@
+package exporter
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+)
+
+// TimeUnit determines the precision of timestamps written to the output. All timestamps read from the
+// inputs are in milliseconds (see timestamp.FromTime), encoders must convert them only via TimeUnit.
+type TimeUnit string
+
+const (
+	TimeUnitMilliseconds TimeUnit = "ms"
+	TimeUnitSeconds      TimeUnit = "s"
+)
+
+// Validate returns an error for unsupported units. Empty unit defaults to milliseconds.
+func (u TimeUnit) Validate() error {
+	switch u {
+	case "", TimeUnitMilliseconds, TimeUnitSeconds:
+		return nil
+	}
+	return errors.Errorf("unsupported timestamp unit %q", u)
+}
+
+// FromTime returns the timestamp of t in the unit.
+func (u TimeUnit) FromTime(t time.Time) int64 {
+	if u == TimeUnitSeconds {
+		return t.Unix()
+	}
+	return timestamp.FromTime(t)
+}
+
+// Time returns the time for the timestamp ts in the unit.
+func (u TimeUnit) Time(ts int64) time.Time {
+	if u == TimeUnitSeconds {
+		return time.Unix(ts, 0).UTC()
+	}
+	return timestamp.Time(ts)
+}
@@ -0,0 +1,24 @@
+package exporter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestTimeUnit(t *testing.T) {
+	// Input timestamps are always in milliseconds.
+	ts := timestamp.Time(1600000000123)
+
+	testutil.Equals(t, int64(1600000000123), TimeUnit("").FromTime(ts))
+	testutil.Equals(t, int64(1600000000123), TimeUnitMilliseconds.FromTime(ts))
+	testutil.Equals(t, int64(1600000000), TimeUnitSeconds.FromTime(ts))
+
+	testutil.Equals(t, ts, TimeUnitMilliseconds.Time(TimeUnitMilliseconds.FromTime(ts)))
+	testutil.Equals(t, ts.Truncate(time.Second), TimeUnitSeconds.Time(TimeUnitSeconds.FromTime(ts)))
+
+	testutil.Ok(t, TimeUnit("").Validate())
+	testutil.NotOk(t, TimeUnit("us").Validate())
+}
@@ -0,0 +1,278 @@
+package bucket
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/thanos-community/obslytics/pkg/series"
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/model"
+	"github.com/thanos-io/thanos/pkg/objstore"
+	"github.com/thanos-io/thanos/pkg/objstore/client"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	defaultConcurrency   = 20
+	defaultMaxCacheBytes = 10 << 30
+)
+
+// Series implements series.Reader reading the Thanos blocks directly from the object storage, without a store
+// gateway. The raw blocks overlapping the read range are downloaded whole to the cache directory and read from there,
+// blocks are immutable so the cached ones are reused by the following reads. The blocks of a read have to fit in the
+// cache size.
+type Series struct {
+	logger          log.Logger
+	conf            series.BucketConfig
+	bkt             objstore.InstrumentedBucket
+	duplicateLabels series.DuplicateLabelsPolicy
+}
+
+// NewSeries returns the reader of the blocks in the configured bucket.
+func NewSeries(logger log.Logger, conf series.Config) (Series, error) {
+	bconf := conf.Bucket
+	if bconf.Storage.Type == "" {
+		return Series{}, errors.New("bucket storage configuration is required by the BUCKET input")
+	}
+	if err := conf.DuplicateLabels.Validate(); err != nil {
+		return Series{}, err
+	}
+	if bconf.CacheDir == "" {
+		bconf.CacheDir = filepath.Join(os.TempDir(), "obslytics-bucket")
+	}
+	if bconf.Concurrency <= 0 {
+		bconf.Concurrency = defaultConcurrency
+	}
+	if bconf.MaxCacheBytes <= 0 {
+		bconf.MaxCacheBytes = defaultMaxCacheBytes
+	}
+
+	storageConf, err := yaml.Marshal(bconf.Storage)
+	if err != nil {
+		return Series{}, errors.Wrap(err, "marshal bucket storage configuration")
+	}
+	bkt, err := client.NewBucket(logger, storageConf, nil, "obslytics")
+	if err != nil {
+		return Series{}, errors.Wrap(err, "create bucket")
+	}
+	return Series{logger: logger, conf: bconf, bkt: bkt, duplicateLabels: conf.DuplicateLabels}, nil
+}
+
+func (s Series) Read(ctx context.Context, params series.Params) (series.Set, error) {
+	if err := params.Validate(); err != nil {
+		return nil, err
+	}
+	matchers, err := params.PromMatchers()
+	if err != nil {
+		return nil, err
+	}
+	metas, err := s.blocks(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	mint, maxt := params.Bounds()
+	hints := &storage.SelectHints{Start: mint, End: maxt}
+	if params.SkipChunks {
+		hints.Func = "series"
+	}
+
+	set := &blockSet{snapshot: params.Snapshot}
+	for _, m := range metas {
+		// The blocks are kept in the cache while they are read.
+		set.dirs = append(set.dirs, cache.acquire(s.conf.CacheDir, m.ULID))
+	}
+	if err := s.makeRoom(metas); err != nil {
+		_ = set.Close()
+		return nil, err
+	}
+
+	sets := make([]storage.SeriesSet, 0, len(metas))
+	for _, m := range metas {
+		dir, err := s.download(ctx, m.ULID)
+		if err != nil {
+			_ = set.Close()
+			return nil, err
+		}
+		b, err := tsdb.OpenBlock(s.logger, dir, nil)
+		if err != nil {
+			_ = set.Close()
+			return nil, errors.Wrapf(err, "open block %s", m.ULID)
+		}
+		set.blocks = append(set.blocks, b)
+
+		q, err := tsdb.NewBlockQuerier(b, mint, maxt)
+		if err != nil {
+			_ = set.Close()
+			return nil, errors.Wrapf(err, "query block %s", m.ULID)
+		}
+		set.queriers = append(set.queriers, q)
+		sets = append(sets, q.Select(true, hints, matchers...))
+	}
+	if err := cache.evict(s.logger, s.conf.CacheDir, s.conf.MaxCacheBytes); err != nil {
+		level.Warn(s.logger).Log("msg", "failed to evict cached blocks", "err", err)
+	}
+	// Series of overlapping blocks (e.g. of HA replicas or not yet compacted) are merged into one.
+	set.SeriesSet = storage.NewMergeSeriesSet(sets, storage.ChainedSeriesMerge)
+	return series.NewLabelsChecker(s.logger, s.duplicateLabels).Set(set), nil
+}
+
+// blocks returns metadata of the raw blocks overlapping the params range, sorted by their min time.
+func (s Series) blocks(ctx context.Context, params series.Params) ([]*metadata.Meta, error) {
+	start, end := params.Range()
+	mint, maxt := timestamp.Time(start), timestamp.Time(end)
+	fetcher, err := block.NewMetaFetcher(s.logger, s.conf.Concurrency, s.bkt, s.conf.CacheDir, nil,
+		[]block.MetadataFilter{
+			block.NewTimePartitionMetaFilter(model.TimeOrDurationValue{Time: &mint}, model.TimeOrDurationValue{Time: &maxt}),
+			block.NewIgnoreDeletionMarkFilter(s.logger, s.bkt, 0, s.conf.Concurrency),
+			block.NewDeduplicateFilter(),
+		}, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "create block metadata fetcher")
+	}
+	all, partial, err := fetcher.Fetch(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "fetch block metadata")
+	}
+	if len(partial) > 0 {
+		level.Warn(s.logger).Log("msg", "skipping partially uploaded or corrupted blocks", "blocks", len(partial))
+	}
+
+	metas := make([]*metadata.Meta, 0, len(all))
+	for _, m := range all {
+		// Downsampled chunks are not readable as raw ones, the raw blocks are kept along them by the compactor.
+		if m.Thanos.Downsample.Resolution > 0 {
+			continue
+		}
+		metas = append(metas, m)
+	}
+	sort.Slice(metas, func(i, j int) bool { return metas[i].MinTime < metas[j].MinTime })
+	level.Debug(s.logger).Log("msg", "reading blocks", "blocks", len(metas))
+	return metas, nil
+}
+
+// makeRoom bounds the disk used by the read. The blocks are downloaded whole, as they are read through their local
+// index and chunks, so the read fails if its blocks do not fit in the cache size, and the least recently read cached
+// blocks are evicted to make room for the ones to download. The size of a block is the one of its files recorded in
+// its metadata, the blocks uploaded without it are not bounded.
+func (s Series) makeRoom(metas []*metadata.Meta) error {
+	var total, download int64
+	for _, m := range metas {
+		size := blockSize(m)
+		total += size
+		if !cached(filepath.Join(s.conf.CacheDir, m.ULID.String())) {
+			download += size
+		}
+	}
+	if total > s.conf.MaxCacheBytes {
+		return errors.Errorf("the %d blocks of the read range take %d bytes, more than the bucket max_cache_bytes %d, "+
+			"read a shorter range or raise the cache size", len(metas), total, s.conf.MaxCacheBytes)
+	}
+	if download == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(s.conf.CacheDir, 0750); err != nil {
+		return errors.Wrap(err, "create cache directory")
+	}
+	if err := cache.evict(s.logger, s.conf.CacheDir, s.conf.MaxCacheBytes-download); err != nil {
+		level.Warn(s.logger).Log("msg", "failed to evict cached blocks", "err", err)
+	}
+	return nil
+}
+
+// blockSize returns the size of the block files recorded in its metadata.
+func blockSize(m *metadata.Meta) int64 {
+	var size int64
+	for _, f := range m.Thanos.Files {
+		size += f.SizeBytes
+	}
+	return size
+}
+
+// download returns the local directory of the block, downloading it unless it is cached. The block is downloaded
+// to a temporary directory of its own first, so only complete blocks are cached, and the concurrent reads of the same
+// block, e.g. by the shards, do not interfere: the first complete download is cached and the others are dropped.
+func (s Series) download(ctx context.Context, id ulid.ULID) (string, error) {
+	dir := filepath.Join(s.conf.CacheDir, id.String())
+	if cached(dir) {
+		return dir, touch(dir)
+	}
+
+	if err := os.MkdirAll(s.conf.CacheDir, 0750); err != nil {
+		return "", errors.Wrap(err, "create cache directory")
+	}
+	tmp, err := os.MkdirTemp(s.conf.CacheDir, id.String()+"-*")
+	if err != nil {
+		return "", errors.Wrap(err, "create block download directory")
+	}
+	defer os.RemoveAll(tmp)
+	if err := block.Download(ctx, s.logger, s.bkt, id, tmp); err != nil {
+		return "", errors.Wrapf(err, "download block %s", id)
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		if cached(dir) {
+			return dir, touch(dir)
+		}
+		return "", errors.Wrapf(err, "cache block %s", id)
+	}
+	return dir, touch(dir)
+}
+
+// cached returns true if the block directory is complete.
+func cached(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, block.MetaFilename))
+	return err == nil
+}
+
+// touch marks the cached block as read, for the eviction of the least recently read blocks.
+func touch(dir string) error {
+	now := time.Now()
+	return errors.Wrap(os.Chtimes(dir, now, now), "touch cached block")
+}
+
+// blockSet implements series.Set over the merged series of the opened blocks.
+type blockSet struct {
+	storage.SeriesSet
+
+	snapshot bool
+	dirs     []string
+	blocks   []*tsdb.Block
+	queriers []storage.Querier
+}
+
+func (s *blockSet) At() storage.Series {
+	if s.snapshot {
+		return series.LatestSeries{Series: s.SeriesSet.At()}
+	}
+	return s.SeriesSet.At()
+}
+
+func (s *blockSet) Close() error {
+	var err error
+	for _, q := range s.queriers {
+		if cerr := q.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	for _, b := range s.blocks {
+		if cerr := b.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	for _, dir := range s.dirs {
+		cache.release(dir)
+	}
+	s.dirs = nil
+	return err
+}
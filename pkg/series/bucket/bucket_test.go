@@ -0,0 +1,249 @@
+package bucket
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/tsdbutil"
+	"github.com/thanos-community/obslytics/pkg/series"
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/objstore/client"
+	"github.com/thanos-io/thanos/pkg/objstore/filesystem"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+type sample struct {
+	t int64
+	v float64
+}
+
+func (s sample) T() int64   { return s.t }
+func (s sample) V() float64 { return s.v }
+
+// uploadBlock creates a block of the series with the samples and uploads it to the bucket directory.
+func uploadBlock(t *testing.T, bktDir string, lsets []labels.Labels, samples ...sample) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "block")
+	testutil.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	var ss []storage.Series
+	for _, lset := range lsets {
+		tsamples := make([]tsdbutil.Sample, 0, len(samples))
+		for _, s := range samples {
+			tsamples = append(tsamples, s)
+		}
+		ss = append(ss, storage.NewListSeries(lset, tsamples))
+	}
+	logger := log.NewNopLogger()
+	bdir, err := tsdb.CreateBlock(ss, dir, 0, logger)
+	testutil.Ok(t, err)
+	_, err = metadata.InjectThanos(logger, bdir, metadata.Thanos{
+		Labels: map[string]string{"replica": "a"},
+		Source: metadata.TestSource,
+	}, nil)
+	testutil.Ok(t, err)
+
+	bkt, err := filesystem.NewBucket(bktDir)
+	testutil.Ok(t, err)
+	testutil.Ok(t, block.Upload(context.Background(), logger, bkt, bdir, metadata.NoneFunc))
+}
+
+func TestSeries_Read(t *testing.T) {
+	bktDir, err := ioutil.TempDir("", "bucket")
+	testutil.Ok(t, err)
+	defer os.RemoveAll(bktDir)
+	cacheDir, err := ioutil.TempDir("", "cache")
+	testutil.Ok(t, err)
+	defer os.RemoveAll(cacheDir)
+
+	lsets := []labels.Labels{
+		labels.FromStrings("__name__", "up", "job", "a"),
+		labels.FromStrings("__name__", "up", "job", "b"),
+		labels.FromStrings("__name__", "other", "job", "a"),
+	}
+	// Overlapping blocks, as of not yet compacted ones.
+	uploadBlock(t, bktDir, lsets, sample{t: 0, v: 1}, sample{t: 30000, v: 2}, sample{t: 60000, v: 3})
+	uploadBlock(t, bktDir, lsets, sample{t: 60000, v: 3}, sample{t: 90000, v: 4}, sample{t: 180000, v: 5})
+
+	s, err := NewSeries(log.NewNopLogger(), series.Config{
+		Type: series.BUCKET,
+		Bucket: series.BucketConfig{
+			Storage:  client.BucketConfig{Type: client.FILESYSTEM, Config: filesystem.Config{Directory: bktDir}},
+			CacheDir: cacheDir,
+		},
+	})
+	testutil.Ok(t, err)
+
+	read := func() map[string][]sample {
+		set, err := s.Read(context.Background(), series.Params{
+			Matchers: []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "__name__", "up")},
+			MinTime:  time.Unix(30, 0),
+			MaxTime:  time.Unix(90, 0),
+		})
+		testutil.Ok(t, err)
+		defer func() { testutil.Ok(t, set.Close()) }()
+
+		got := map[string][]sample{}
+		for set.Next() {
+			var samples []sample
+			it := set.At().Iterator()
+			for it.Next() {
+				ts, v := it.At()
+				samples = append(samples, sample{t: ts, v: v})
+			}
+			testutil.Ok(t, it.Err())
+			got[set.At().Labels().String()] = samples
+		}
+		testutil.Ok(t, set.Err())
+		return got
+	}
+
+	exp := map[string][]sample{
+		`{__name__="up", job="a"}`: {{t: 30000, v: 2}, {t: 60000, v: 3}, {t: 90000, v: 4}},
+		`{__name__="up", job="b"}`: {{t: 30000, v: 2}, {t: 60000, v: 3}, {t: 90000, v: 4}},
+	}
+	testutil.Equals(t, exp, read())
+
+	// The blocks are read from the cache.
+	cached, err := filepath.Glob(filepath.Join(cacheDir, "*", "index"))
+	testutil.Ok(t, err)
+	testutil.Equals(t, 2, len(cached))
+	uploaded, err := filepath.Glob(filepath.Join(bktDir, "*", "chunks"))
+	testutil.Ok(t, err)
+	for _, dir := range uploaded {
+		testutil.Ok(t, os.RemoveAll(dir))
+		testutil.Ok(t, os.Remove(filepath.Join(filepath.Dir(dir), "index")))
+	}
+	testutil.Equals(t, exp, read())
+}
+
+func TestSeries_Cache(t *testing.T) {
+	bktDir, err := ioutil.TempDir("", "bucket")
+	testutil.Ok(t, err)
+	defer os.RemoveAll(bktDir)
+	cacheDir, err := ioutil.TempDir("", "cache")
+	testutil.Ok(t, err)
+	defer os.RemoveAll(cacheDir)
+
+	lsets := []labels.Labels{labels.FromStrings("__name__", "up", "job", "a")}
+	uploadBlock(t, bktDir, lsets, sample{t: 0, v: 1}, sample{t: 30000, v: 2})
+	uploadBlock(t, bktDir, lsets, sample{t: 120000, v: 3}, sample{t: 150000, v: 4})
+
+	// The cache fits a single block, along with its metadata.
+	var maxBytes, maxFilesBytes int64
+	uploaded, err := filepath.Glob(filepath.Join(bktDir, "*", block.MetaFilename))
+	testutil.Ok(t, err)
+	for _, f := range uploaded {
+		dir := filepath.Dir(f)
+		size, err := dirSize(dir)
+		testutil.Ok(t, err)
+		if size > maxBytes {
+			maxBytes = size
+		}
+		meta, err := metadata.ReadFromDir(dir)
+		testutil.Ok(t, err)
+		if size := blockSize(meta); size > maxFilesBytes {
+			maxFilesBytes = size
+		}
+	}
+
+	newSeries := func(maxBytes int64) Series {
+		s, err := NewSeries(log.NewNopLogger(), series.Config{
+			Type: series.BUCKET,
+			Bucket: series.BucketConfig{
+				Storage:       client.BucketConfig{Type: client.FILESYSTEM, Config: filesystem.Config{Directory: bktDir}},
+				CacheDir:      cacheDir,
+				MaxCacheBytes: maxBytes,
+			},
+		})
+		testutil.Ok(t, err)
+		return s
+	}
+	s := newSeries(maxBytes)
+
+	read := func(mint, maxt int64) (int, error) {
+		set, err := s.Read(context.Background(), series.Params{
+			Matchers: []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "__name__", "up")},
+			MinTime:  time.Unix(mint, 0),
+			MaxTime:  time.Unix(maxt, 0),
+		})
+		if err != nil {
+			return 0, err
+		}
+		defer set.Close()
+		n := 0
+		for set.Next() {
+			for it := set.At().Iterator(); it.Next(); {
+				n++
+			}
+		}
+		return n, set.Err()
+	}
+	cachedBlocks := func() int {
+		cached, err := filepath.Glob(filepath.Join(cacheDir, "*", "index"))
+		testutil.Ok(t, err)
+		return len(cached)
+	}
+
+	// The concurrent reads of the same blocks download them separately, caching one of the downloads.
+	var wg sync.WaitGroup
+	errs := make([]error, 8)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var n int
+			n, errs[i] = read(0, 30)
+			if errs[i] == nil && n != 2 {
+				errs[i] = errors.Errorf("read %d samples, expected 2", n)
+			}
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		testutil.Ok(t, err)
+	}
+	testutil.Equals(t, 1, cachedBlocks())
+
+	// The blocks not being read are evicted over the cache size.
+	n, err := read(120, 150)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 2, n)
+	testutil.Equals(t, 1, cachedBlocks())
+	_, err = os.Stat(filepath.Join(cacheDir, "meta-syncer"))
+	testutil.Ok(t, err)
+
+	// The read of the blocks not fitting in the cache fails before downloading them.
+	s = newSeries(maxFilesBytes)
+	_, err = read(0, 150)
+	testutil.NotOk(t, err)
+	testutil.Equals(t, 1, cachedBlocks())
+}
+
+func TestNewSeries_NoStorage(t *testing.T) {
+	_, err := NewSeries(log.NewNopLogger(), series.Config{Type: series.BUCKET})
+	testutil.NotOk(t, err)
+}
+
+func TestNewSeries_DuplicateLabels(t *testing.T) {
+	// The labels of the blocks are checked by the policy, although the blocks written by Prometheus have no duplicates.
+	_, err := NewSeries(log.NewNopLogger(), series.Config{
+		Type:            series.BUCKET,
+		Bucket:          series.BucketConfig{Storage: client.BucketConfig{Type: client.FILESYSTEM, Config: filesystem.Config{Directory: "bkt"}}},
+		DuplicateLabels: "unknown",
+	})
+	testutil.NotOk(t, err)
+}
@@ -0,0 +1,112 @@
+package bucket
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+)
+
+// cache tracks the cached blocks being read, shared by the readers of the process so that none of them evicts the
+// blocks read by another.
+var cache = &blockCache{reading: map[string]int{}}
+
+type blockCache struct {
+	mtx     sync.Mutex
+	reading map[string]int
+}
+
+// acquire marks the block directory in the cache directory as being read until it's released, returning the
+// directory.
+func (c *blockCache) acquire(cacheDir string, id ulid.ULID) string {
+	dir := filepath.Join(cacheDir, id.String())
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.reading[dir]++
+	return dir
+}
+
+func (c *blockCache) release(dir string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.reading[dir]--; c.reading[dir] <= 0 {
+		delete(c.reading, dir)
+	}
+}
+
+type cachedBlock struct {
+	dir  string
+	size int64
+	read time.Time
+}
+
+// evict removes the least recently read blocks of the cache directory not being read until the cached blocks fit in
+// maxBytes. Only the complete blocks are evicted, the downloads in progress and the block metadata cache are kept.
+func (c *blockCache) evict(logger log.Logger, cacheDir string, maxBytes int64) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	entries, err := ioutil.ReadDir(cacheDir)
+	if err != nil {
+		return errors.Wrap(err, "list cache directory")
+	}
+	var (
+		blocks []cachedBlock
+		total  int64
+	)
+	for _, e := range entries {
+		if _, err := ulid.Parse(e.Name()); err != nil || !e.IsDir() {
+			continue
+		}
+		dir := filepath.Join(cacheDir, e.Name())
+		size, err := dirSize(dir)
+		if err != nil {
+			return err
+		}
+		total += size
+		blocks = append(blocks, cachedBlock{dir: dir, size: size, read: e.ModTime()})
+	}
+	if total <= maxBytes {
+		return nil
+	}
+
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i].read.Before(blocks[j].read) })
+	for _, b := range blocks {
+		if total <= maxBytes {
+			return nil
+		}
+		if c.reading[b.dir] > 0 {
+			continue
+		}
+		if err := os.RemoveAll(b.dir); err != nil {
+			return errors.Wrapf(err, "evict cached block %s", filepath.Base(b.dir))
+		}
+		total -= b.size
+		level.Debug(logger).Log("msg", "evicted cached block", "block", filepath.Base(b.dir), "bytes", b.size)
+	}
+	if total > maxBytes {
+		level.Warn(logger).Log("msg", "blocks being read exceed the cache size", "bytes", total, "max", maxBytes)
+	}
+	return nil
+}
+
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, errors.Wrapf(err, "size of %s", dir)
+}
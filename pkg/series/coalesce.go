@@ -0,0 +1,150 @@
+package series
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+)
+
+// CoalesceMode defines the value of the samples coalesced into one.
+type CoalesceMode string
+
+const (
+	// CoalesceLast keeps the value of the last of the coalesced samples. This is the default.
+	CoalesceLast CoalesceMode = "last"
+	// CoalesceAverage writes the average value of the coalesced samples.
+	CoalesceAverage CoalesceMode = "average"
+)
+
+// CoalesceConfig configures coalescing the samples of a series with timestamps within a tiny window, e.g. the near
+// duplicates of replicas merged with slightly different timestamps, into a single sample.
+//
+// The samples are coalesced once they are in timestamp order and their duplicate timestamps are handled by the
+// duplicates policy, so that keep-first, keep-last or average pick the value of equal timestamps first, and the error
+// policy fails before coalescing. With the default keep-all, the samples with equal timestamps are coalesced as well.
+type CoalesceConfig struct {
+	// Epsilon is the window the samples are coalesced within: the samples from the first one of the window up to
+	// Epsilon later become a single sample at the timestamp of the last one. 0 (default) disables coalescing. It is
+	// meant to be tiny compared to the resolution, as the coalesced samples move to the later timestamp, possibly into
+	// the next window of the aggregation.
+	Epsilon model.Duration `yaml:"epsilon"`
+	// Mode is the value of the coalesced sample: last (default) or average.
+	Mode CoalesceMode `yaml:"mode"`
+}
+
+// Validate returns error if the options are out of range.
+func (c CoalesceConfig) Validate() error {
+	if c.Epsilon < 0 {
+		return errors.New("coalesce epsilon cannot be negative")
+	}
+	switch c.Mode {
+	case "", CoalesceLast, CoalesceAverage:
+		return nil
+	}
+	return errors.Errorf("unsupported coalesce mode %q, expected last or average", c.Mode)
+}
+
+// NewCoalescedSet returns the set coalescing the samples of every series, expected in timestamp order, within the
+// epsilon of the config. The set is returned as it is if coalescing is disabled.
+func NewCoalescedSet(s Set, conf CoalesceConfig) Set {
+	if conf.Epsilon <= 0 {
+		return s
+	}
+	return &coalescedSet{Set: s, epsilon: time.Duration(conf.Epsilon).Milliseconds(), average: conf.Mode == CoalesceAverage}
+}
+
+type coalescedSet struct {
+	Set
+
+	epsilon int64
+	average bool
+}
+
+func (s *coalescedSet) At() storage.Series {
+	return &coalescedSeries{Series: s.Set.At(), set: s}
+}
+
+type coalescedSeries struct {
+	storage.Series
+
+	set *coalescedSet
+}
+
+func (s *coalescedSeries) Unwrap() storage.Series { return s.Series }
+
+func (s *coalescedSeries) Iterator() chunkenc.Iterator {
+	return &coalesceIterator{it: s.Series.Iterator(), epsilon: s.set.epsilon, average: s.set.average}
+}
+
+// coalesceIterator merges the consecutive samples within the epsilon of the first one of an ordered iterator.
+type coalesceIterator struct {
+	it      chunkenc.Iterator
+	epsilon int64
+	average bool
+
+	started, ok, hasNext bool
+	cur, next            sample
+	curCount, nextCount  uint64
+}
+
+func (it *coalesceIterator) Next() bool {
+	if !it.started {
+		it.started = true
+		it.advance()
+	}
+	if !it.hasNext {
+		it.ok = false
+		return false
+	}
+
+	var (
+		cur   = it.next
+		count = it.nextCount
+		start = cur.t
+		sum   = cur.v
+		n     = 1
+	)
+	for it.advance() && it.next.t-start <= it.epsilon {
+		cur, count = it.next, it.nextCount
+		sum += it.next.v
+		n++
+	}
+	if it.average {
+		cur.v = sum / float64(n)
+	}
+	it.cur, it.curCount, it.ok = cur, count, true
+	return true
+}
+
+// advance reads the next sample of the wrapped iterator.
+func (it *coalesceIterator) advance() bool {
+	it.hasNext = it.it.Next()
+	if it.hasNext {
+		it.next.t, it.next.v = it.it.At()
+		it.nextCount = SampleCount(it.it)
+	}
+	return it.hasNext
+}
+
+func (it *coalesceIterator) Seek(t int64) bool {
+	if it.ok && it.cur.t >= t {
+		return true
+	}
+	for it.Next() {
+		if it.cur.t >= t {
+			return true
+		}
+	}
+	return false
+}
+
+func (it *coalesceIterator) At() (int64, float64) { return it.cur.t, it.cur.v }
+
+// SampleCount returns the count of the last of the coalesced samples, which are expected to be replicas of the same
+// samples.
+func (it *coalesceIterator) SampleCount() uint64 { return it.curCount }
+
+func (it *coalesceIterator) Err() error { return it.it.Err() }
@@ -0,0 +1,50 @@
+package series
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/tsdbutil"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestCoalescedSet(t *testing.T) {
+	read := func(t *testing.T, s Set) []sample {
+		testutil.Assert(t, s.Next())
+		var got []sample
+		it := s.At().Iterator()
+		for it.Next() {
+			ts, v := it.At()
+			got = append(got, sample{t: ts, v: v})
+		}
+		testutil.Ok(t, it.Err())
+		return got
+	}
+	newSet := func(duplicates DuplicatesPolicy, conf CoalesceConfig) Set {
+		s := NewOrderedSet(log.NewNopLogger(), &listSet{i: -1, series: []storage.Series{
+			storage.NewListSeries(labels.FromStrings("a", "b"), []tsdbutil.Sample{
+				sample{t: 10, v: 1}, sample{t: 11, v: 2}, sample{t: 13, v: 3}, sample{t: 20, v: 4}, sample{t: 21, v: 5},
+				sample{t: 21, v: 7}, sample{t: 30, v: 6},
+			}),
+		}}, "", duplicates, SortBufferConfig{})
+		return NewCoalescedSet(s, conf)
+	}
+	epsilon := model.Duration(2 * time.Millisecond)
+
+	// The windows start with their first sample, 13 is not within the epsilon of 10.
+	testutil.Equals(t, []sample{{11, 2}, {13, 3}, {21, 7}, {30, 6}}, read(t, newSet("", CoalesceConfig{Epsilon: epsilon})))
+	testutil.Equals(t, []sample{{11, 1.5}, {13, 3}, {21, 16.0 / 3}, {30, 6}},
+		read(t, newSet("", CoalesceConfig{Epsilon: epsilon, Mode: CoalesceAverage})))
+	// The duplicate timestamps are handled first.
+	testutil.Equals(t, []sample{{11, 1.5}, {13, 3}, {21, 4.5}, {30, 6}},
+		read(t, newSet(DuplicatesKeepFirst, CoalesceConfig{Epsilon: epsilon, Mode: CoalesceAverage})))
+	// Disabled by default.
+	testutil.Equals(t, 7, len(read(t, newSet("", CoalesceConfig{}))))
+
+	testutil.NotOk(t, CoalesceConfig{Epsilon: -1}.Validate())
+	testutil.NotOk(t, CoalesceConfig{Mode: "first"}.Validate())
+}
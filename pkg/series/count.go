@@ -0,0 +1,66 @@
+package series
+
+import (
+	"sort"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+)
+
+// MetricCount is the number of distinct series of a single metric.
+type MetricCount struct {
+	Name   string
+	Series int
+}
+
+// CountByMetricName counts the distinct series per metric name. Only labels of the series are used, so the set
+// can be read with Params.SkipChunks. The result is sorted by the number of series (descending) and the name.
+func CountByMetricName(s Set) ([]MetricCount, error) {
+	defer s.Close()
+
+	seen := map[string]map[uint64]struct{}{}
+	for s.Next() {
+		ls := s.At().Labels()
+		name := ls.Get(labels.MetricName)
+		if _, ok := seen[name]; !ok {
+			seen[name] = map[uint64]struct{}{}
+		}
+		seen[name][ls.Hash()] = struct{}{}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	ret := make([]MetricCount, 0, len(seen))
+	for name, hashes := range seen {
+		ret = append(ret, MetricCount{Name: name, Series: len(hashes)})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Series != ret[j].Series {
+			return ret[i].Series > ret[j].Series
+		}
+		return ret[i].Name < ret[j].Name
+	})
+	return ret, nil
+}
+
+// LabelValues returns the sorted distinct values of the label. An empty value is included if some series don't
+// have the label, so that matching every value (including the empty one) selects all the series. Like
+// CountByMetricName, it can be used with Params.SkipChunks.
+func LabelValues(s Set, name string) ([]string, error) {
+	defer s.Close()
+
+	seen := map[string]struct{}{}
+	for s.Next() {
+		seen[s.At().Labels().Get(name)] = struct{}{}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	ret := make([]string, 0, len(seen))
+	for v := range seen {
+		ret = append(ret, v)
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
@@ -0,0 +1,62 @@
+package series
+
+import (
+	"testing"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+// labelsSet implements Set on top of series labels only.
+type labelsSet struct {
+	lsets []labels.Labels
+	i     int
+}
+
+func (s *labelsSet) Next() bool {
+	s.i++
+	return s.i < len(s.lsets)
+}
+
+func (s *labelsSet) At() storage.Series         { return labelsSeries(s.lsets[s.i]) }
+func (s *labelsSet) Err() error                 { return nil }
+func (s *labelsSet) Warnings() storage.Warnings { return nil }
+func (s *labelsSet) Close() error               { return nil }
+
+type labelsSeries labels.Labels
+
+func (s labelsSeries) Labels() labels.Labels       { return labels.Labels(s) }
+func (s labelsSeries) Iterator() chunkenc.Iterator { return chunkenc.NewNopIterator() }
+
+func TestCountByMetricName(t *testing.T) {
+	set := &labelsSet{i: -1, lsets: []labels.Labels{
+		labels.FromStrings(labels.MetricName, "up", "job", "a"),
+		labels.FromStrings(labels.MetricName, "up", "job", "b"),
+		// The same series partitioned between multiple iterations is counted once.
+		labels.FromStrings(labels.MetricName, "up", "job", "b"),
+		labels.FromStrings(labels.MetricName, "scrape_duration_seconds", "job", "a"),
+		labels.FromStrings(labels.MetricName, "go_goroutines", "job", "a"),
+	}}
+
+	counts, err := CountByMetricName(set)
+	testutil.Ok(t, err)
+	testutil.Equals(t, []MetricCount{
+		{Name: "up", Series: 2},
+		{Name: "go_goroutines", Series: 1},
+		{Name: "scrape_duration_seconds", Series: 1},
+	}, counts)
+}
+
+func TestLabelValues(t *testing.T) {
+	set := &labelsSet{i: -1, lsets: []labels.Labels{
+		labels.FromStrings(labels.MetricName, "up", "instance", "b"),
+		labels.FromStrings(labels.MetricName, "up", "instance", "a"),
+		labels.FromStrings(labels.MetricName, "up", "instance", "b"),
+		labels.FromStrings(labels.MetricName, "up"),
+	}}
+	values, err := LabelValues(set, "instance")
+	testutil.Ok(t, err)
+	testutil.Equals(t, []string{"", "a", "b"}, values)
+}
@@ -0,0 +1,203 @@
+package series
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+)
+
+// DeltaFirst defines how the first sample of a series, having no previous sample to compute the delta from, is
+// handled.
+type DeltaFirst string
+
+const (
+	// DeltaFirstOmit drops the first sample. This is the default.
+	DeltaFirstOmit DeltaFirst = "omit"
+	// DeltaFirstZero emits zero delta for the first sample.
+	DeltaFirstZero DeltaFirst = "zero"
+)
+
+// Validate returns error if the option is not known.
+func (f DeltaFirst) Validate() error {
+	switch f {
+	case "", DeltaFirstOmit, DeltaFirstZero:
+		return nil
+	}
+	return errors.Errorf("unsupported delta first sample option %q, expected omit or zero", f)
+}
+
+// RateUnit is the time unit the per-second deltas are normalized to, e.g. to export throughputs in bytes per minute.
+type RateUnit string
+
+const (
+	// RateUnitSecond normalizes the deltas per second. This is the default.
+	RateUnitSecond RateUnit = "second"
+	RateUnitMinute RateUnit = "minute"
+	RateUnitHour   RateUnit = "hour"
+)
+
+// Validate returns error if the unit is not known.
+func (u RateUnit) Validate() error {
+	switch u {
+	case "", RateUnitSecond, RateUnitMinute, RateUnitHour:
+		return nil
+	}
+	return errors.Errorf("unsupported rate unit %q, expected second, minute or hour", u)
+}
+
+// Duration returns the duration of the unit.
+func (u RateUnit) Duration() time.Duration {
+	switch u {
+	case RateUnitMinute:
+		return time.Minute
+	case RateUnitHour:
+		return time.Hour
+	}
+	return time.Second
+}
+
+// Symbol returns the symbol of the unit, e.g. s for the bytes/s unit.
+func (u RateUnit) Symbol() string {
+	switch u {
+	case RateUnitMinute:
+		return "min"
+	case RateUnitHour:
+		return "h"
+	}
+	return "s"
+}
+
+// ElapsedIterator is implemented by the iterators of the per-second deltas, so that the aggregations can weight the
+// deltas of irregularly spaced samples by the time they span, and by the iterators passing their samples through.
+type ElapsedIterator interface {
+	// Elapsed returns the milliseconds between the current sample and the previous one the delta is computed from,
+	// 0 for the samples without the previous sample.
+	Elapsed() int64
+}
+
+// Elapsed returns the milliseconds the current per-second delta of the iterator spans, 0 unless the iterator implements
+// ElapsedIterator.
+func Elapsed(it chunkenc.Iterator) int64 {
+	if e, ok := it.(ElapsedIterator); ok {
+		return e.Elapsed()
+	}
+	return 0
+}
+
+// DeltaOptions configure the delta of consecutive samples.
+type DeltaOptions struct {
+	// PerSecond divides the delta by the seconds elapsed since the previous sample, giving the derivative. Samples
+	// with the timestamp of the previous sample are dropped. Every delta is divided by its own elapsed time, so the
+	// irregularly spaced samples give the correct derivatives.
+	PerSecond bool
+	// Unit normalizes the PerSecond deltas per minute or hour instead, e.g. for bytes per minute.
+	Unit RateUnit
+	// First handles the first sample of every series.
+	First DeltaFirst
+	// Counter handles the decreases as counter resets, the delta being the value after the reset, as by the
+	// Prometheus increase and rate functions.
+	Counter bool
+}
+
+// NewDeltaSet returns the set with the value of every sample replaced by its difference from the previous sample of
+// the series, e.g. the per-step change of a gauge. Unlike counter rates, decreases are kept as negative deltas. Only
+// the samples of the set are considered, so the first sample of the read range has no previous sample.
+func NewDeltaSet(s Set, opts DeltaOptions) Set {
+	return &deltaSet{Set: s, opts: opts}
+}
+
+type deltaSet struct {
+	Set
+
+	opts DeltaOptions
+}
+
+func (s *deltaSet) At() storage.Series {
+	return NewDeltaSeries(s.Set.At(), s.opts)
+}
+
+// NewDeltaSeries returns the series with the delta of consecutive samples, see NewDeltaSet.
+func NewDeltaSeries(s storage.Series, opts DeltaOptions) storage.Series {
+	return &deltaSeries{Series: s, opts: opts}
+}
+
+type deltaSeries struct {
+	storage.Series
+
+	opts DeltaOptions
+}
+
+func (s *deltaSeries) Iterator() chunkenc.Iterator {
+	return &deltaIterator{it: s.Series.Iterator(), opts: s.opts}
+}
+
+type deltaIterator struct {
+	it   chunkenc.Iterator
+	opts DeltaOptions
+
+	prev    sample
+	hasPrev bool
+	ok      bool
+	cur     sample
+	elapsed int64
+}
+
+func (it *deltaIterator) Next() bool {
+	for it.it.Next() {
+		t, v := it.it.At()
+		prev, hasPrev := it.prev, it.hasPrev
+		it.prev, it.hasPrev = sample{t: t, v: v}, true
+
+		if !hasPrev {
+			if it.opts.First == DeltaFirstZero {
+				it.cur, it.ok, it.elapsed = sample{t: t, v: 0}, true, 0
+				return true
+			}
+			continue
+		}
+
+		d := v - prev.v
+		if it.opts.Counter && d < 0 {
+			d = v
+		}
+		if it.opts.PerSecond {
+			if t == prev.t {
+				continue
+			}
+			d /= float64(t-prev.t) / float64(it.opts.Unit.Duration().Milliseconds())
+		}
+		it.cur, it.ok, it.elapsed = sample{t: t, v: d}, true, t-prev.t
+		return true
+	}
+	it.ok = false
+	return false
+}
+
+// Seek reads the samples up to t, as every delta depends on the previous sample.
+func (it *deltaIterator) Seek(t int64) bool {
+	if it.ok && it.cur.t >= t {
+		return true
+	}
+	for it.Next() {
+		if it.cur.t >= t {
+			return true
+		}
+	}
+	return false
+}
+
+func (it *deltaIterator) At() (int64, float64) { return it.cur.t, it.cur.v }
+
+func (it *deltaIterator) Err() error { return it.it.Err() }
+
+func (it *deltaIterator) SampleCount() uint64 { return SampleCount(it.it) }
+
+// Elapsed implements ElapsedIterator for the per-second deltas, 0 otherwise.
+func (it *deltaIterator) Elapsed() int64 {
+	if !it.opts.PerSecond {
+		return 0
+	}
+	return it.elapsed
+}
@@ -0,0 +1,50 @@
+package series
+
+import (
+	"testing"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/tsdbutil"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestDeltaSet(t *testing.T) {
+	var elapsed []int64
+	read := func(opts DeltaOptions) []sample {
+		set := NewDeltaSet(&listSet{i: -1, series: []storage.Series{
+			storage.NewListSeries(labels.FromStrings("__name__", "temp"), []tsdbutil.Sample{
+				sample{t: 1000, v: 10}, sample{t: 3000, v: 14}, sample{t: 3000, v: 15}, sample{t: 7000, v: 7},
+			}),
+		}}, opts)
+		testutil.Assert(t, set.Next())
+
+		var got []sample
+		elapsed = nil
+		it := set.At().Iterator()
+		for it.Next() {
+			ts, v := it.At()
+			got = append(got, sample{t: ts, v: v})
+			elapsed = append(elapsed, it.(ElapsedIterator).Elapsed())
+		}
+		testutil.Ok(t, it.Err())
+		return got
+	}
+
+	testutil.Equals(t, []sample{{3000, 4}, {3000, 1}, {7000, -8}}, read(DeltaOptions{}))
+	testutil.Equals(t, []sample{{1000, 0}, {3000, 4}, {3000, 1}, {7000, -8}}, read(DeltaOptions{First: DeltaFirstZero}))
+	// Per second, the sample at the same timestamp is dropped.
+	testutil.Equals(t, []sample{{3000, 2}, {7000, -2}}, read(DeltaOptions{PerSecond: true}))
+	testutil.Equals(t, []int64{2000, 4000}, elapsed)
+	testutil.Equals(t, []sample{{3000, 120}, {7000, -120}}, read(DeltaOptions{PerSecond: true, Unit: RateUnitMinute}))
+	// The elapsed time is of the per-second deltas only.
+	read(DeltaOptions{})
+	testutil.Equals(t, []int64{0, 0, 0}, elapsed)
+	// Decrease of a counter is its reset.
+	testutil.Equals(t, []sample{{3000, 4}, {3000, 1}, {7000, 7}}, read(DeltaOptions{Counter: true}))
+
+	testutil.Ok(t, DeltaFirstOmit.Validate())
+	testutil.NotOk(t, DeltaFirst("first").Validate())
+	testutil.Ok(t, RateUnitHour.Validate())
+	testutil.NotOk(t, RateUnit("day").Validate())
+}
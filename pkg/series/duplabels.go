@@ -0,0 +1,180 @@
+package series
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+)
+
+// DuplicateLabelsPolicy defines how label names repeated within the labels of a series are handled. Such malformed
+// series, e.g. from faulty stores, would otherwise be exported with one of the values picked by the order of the labels.
+type DuplicateLabelsPolicy string
+
+const (
+	// DuplicateLabelsError fails the read on the first series with a duplicate label name. This is the default.
+	DuplicateLabelsError DuplicateLabelsPolicy = "error"
+	// DuplicateLabelsKeepFirst keeps the first of the labels with the same name, in the order they are decoded,
+	// logging a warning.
+	DuplicateLabelsKeepFirst DuplicateLabelsPolicy = "keep-first"
+	// DuplicateLabelsKeepLast keeps the last of the labels with the same name, in the order they are decoded,
+	// logging a warning.
+	DuplicateLabelsKeepLast DuplicateLabelsPolicy = "keep-last"
+)
+
+// Validate returns error if the policy is not known.
+func (p DuplicateLabelsPolicy) Validate() error {
+	switch p {
+	case "", DuplicateLabelsError, DuplicateLabelsKeepFirst, DuplicateLabelsKeepLast:
+		return nil
+	}
+	return errors.Errorf("unsupported duplicate_labels policy %q, expected error, keep-first or keep-last", p)
+}
+
+// LabelsChecker checks the decoded labels of series for duplicate label names, handling them according to the
+// policy. The warning is logged for the first series with duplicates kept, the others are logged at debug level.
+// It is safe for concurrent use.
+type LabelsChecker struct {
+	logger log.Logger
+	policy DuplicateLabelsPolicy
+
+	mtx    sync.Mutex
+	series int
+}
+
+// NewLabelsChecker returns the LabelsChecker with the policy.
+func NewLabelsChecker(logger log.Logger, policy DuplicateLabelsPolicy) *LabelsChecker {
+	if policy == "" {
+		policy = DuplicateLabelsError
+	}
+	return &LabelsChecker{logger: logger, policy: policy}
+}
+
+// Check returns the labels, as decoded, with the duplicate label names handled. The labels with duplicates are
+// returned sorted by name, the others as they are.
+func (c *LabelsChecker) Check(lset labels.Labels) (labels.Labels, error) {
+	ret, dup := dedupLabels(lset, c.policy == DuplicateLabelsKeepLast)
+	if dup == "" {
+		return lset, nil
+	}
+	if c.policy == DuplicateLabelsError {
+		return nil, errors.Errorf("series %s has the duplicate label name %q", lset, dup)
+	}
+
+	c.mtx.Lock()
+	c.series++
+	first := c.series == 1
+	c.mtx.Unlock()
+	lvl := level.Debug
+	if first {
+		lvl = level.Warn
+	}
+	lvl(c.logger).Log("msg", "series with duplicate label names", "series", lset.String(), "name", dup,
+		"policy", c.policy, "kept", ret.Get(dup))
+	return ret, nil
+}
+
+// dedupLabels returns the labels sorted by name with a single label per name, the first or the last one in order,
+// and the first duplicate name, empty for the labels without duplicates.
+func dedupLabels(lset labels.Labels, keepLast bool) (labels.Labels, string) {
+	if !hasDuplicateNames(lset) {
+		return lset, ""
+	}
+
+	ret := make(labels.Labels, len(lset))
+	copy(ret, lset)
+	// The stable sort keeps the labels with the same name in the order decoded.
+	sort.SliceStable(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+
+	dup := ""
+	n := 0
+	for i, l := range ret {
+		if n > 0 && ret[n-1].Name == l.Name {
+			if dup == "" {
+				dup = l.Name
+			}
+			if keepLast {
+				ret[n-1] = ret[i]
+			}
+			continue
+		}
+		ret[n] = l
+		n++
+	}
+	return ret[:n], dup
+}
+
+// hasDuplicateNames returns whether any label name is repeated, without allocations for labels sorted by name, as
+// decoded from well-formed series.
+func hasDuplicateNames(lset labels.Labels) bool {
+	for i := 1; i < len(lset); i++ {
+		if lset[i].Name == lset[i-1].Name {
+			return true
+		}
+		if lset[i].Name < lset[i-1].Name {
+			seen := make(map[string]struct{}, len(lset))
+			for _, l := range lset {
+				if _, ok := seen[l.Name]; ok {
+					return true
+				}
+				seen[l.Name] = struct{}{}
+			}
+			return false
+		}
+	}
+	return false
+}
+
+// Set returns the set with the labels of every series checked.
+func (c *LabelsChecker) Set(s Set) Set {
+	return &labelsCheckedSet{Set: s, c: c}
+}
+
+type labelsCheckedSet struct {
+	Set
+
+	c   *LabelsChecker
+	cur storage.Series
+	err error
+}
+
+func (s *labelsCheckedSet) Next() bool {
+	if s.err != nil || !s.Set.Next() {
+		return false
+	}
+	ser := s.Set.At()
+	lset, err := s.c.Check(ser.Labels())
+	if err != nil {
+		s.err = err
+		return false
+	}
+	s.cur = ser
+	if len(lset) != len(ser.Labels()) {
+		s.cur = &dedupedSeries{Series: ser, lset: lset}
+	}
+	return true
+}
+
+func (s *labelsCheckedSet) At() storage.Series { return s.cur }
+
+func (s *labelsCheckedSet) Err() error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.Set.Err()
+}
+
+// dedupedSeries is the series with the labels with duplicates handled.
+type dedupedSeries struct {
+	storage.Series
+
+	lset labels.Labels
+}
+
+func (s *dedupedSeries) Labels() labels.Labels { return s.lset }
+
+func (s *dedupedSeries) Unwrap() storage.Series { return s.Series }
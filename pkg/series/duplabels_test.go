@@ -0,0 +1,37 @@
+package series
+
+import (
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestLabelsChecker(t *testing.T) {
+	// The labels are not sorted, as decoded from a malformed series.
+	lset := labels.Labels{{Name: "job", Value: "a"}, {Name: "__name__", Value: "up"}, {Name: "job", Value: "b"}, {Name: "job", Value: "c"}}
+
+	_, err := NewLabelsChecker(log.NewNopLogger(), "").Check(lset)
+	testutil.NotOk(t, err)
+
+	got, err := NewLabelsChecker(log.NewNopLogger(), DuplicateLabelsKeepFirst).Check(lset)
+	testutil.Ok(t, err)
+	testutil.Equals(t, labels.FromStrings("__name__", "up", "job", "a"), got)
+
+	got, err = NewLabelsChecker(log.NewNopLogger(), DuplicateLabelsKeepLast).Check(lset)
+	testutil.Ok(t, err)
+	testutil.Equals(t, labels.FromStrings("__name__", "up", "job", "c"), got)
+
+	// The labels without duplicates are kept as they are, sorted or not.
+	for _, lset := range []labels.Labels{
+		labels.FromStrings("__name__", "up", "job", "a"),
+		{{Name: "job", Value: "a"}, {Name: "__name__", Value: "up"}},
+	} {
+		got, err := NewLabelsChecker(log.NewNopLogger(), "").Check(lset)
+		testutil.Ok(t, err)
+		testutil.Equals(t, lset, got)
+	}
+
+	testutil.NotOk(t, DuplicateLabelsPolicy("ignore").Validate())
+}
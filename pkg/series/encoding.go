@@ -0,0 +1,111 @@
+package series
+
+import (
+	"sort"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+)
+
+// UnknownEncoding is the encoding reported for the series not exposing the encodings of their chunks.
+const UnknownEncoding = "unknown"
+
+// ChunkEncoding describes the chunks of a single encoding of a series, as read from the storage.
+type ChunkEncoding struct {
+	// Encoding is the name of the chunk encoding, e.g. XOR.
+	Encoding string
+	Chunks   int
+	// Bytes is the encoded size of the chunks, of all their aggregates for downsampled chunks.
+	Bytes int
+}
+
+// EncodedSeries is implemented by the series read from stored chunks, e.g. by the StoreAPI input, exposing the
+// encodings of the chunks as they were read, before decoding.
+type EncodedSeries interface {
+	storage.Series
+	// ChunkEncodings returns the chunks of the series by encoding, sorted by the encoding name.
+	ChunkEncodings() []ChunkEncoding
+}
+
+// SeriesWrapper is implemented by the series wrapping another series, e.g. to bound or relabel it, so that the
+// wrapped series can be inspected by ChunkEncodingsOf.
+type SeriesWrapper interface {
+	Unwrap() storage.Series
+}
+
+// ChunkEncodingsOf returns the chunk encodings of the series, looking through the series wrappers. Returns false if
+// the series does not expose them.
+func ChunkEncodingsOf(s storage.Series) ([]ChunkEncoding, bool) {
+	for {
+		if es, ok := s.(EncodedSeries); ok {
+			return es.ChunkEncodings(), true
+		}
+		w, ok := s.(SeriesWrapper)
+		if !ok {
+			return nil, false
+		}
+		s = w.Unwrap()
+	}
+}
+
+// EncodingCount is the number of series, chunks and bytes of a single chunk encoding of a metric.
+type EncodingCount struct {
+	Name     string
+	Encoding string
+	Series   int
+	Chunks   int
+	Bytes    int
+}
+
+// CountByEncoding counts the series, chunks and bytes per metric name and chunk encoding, e.g. to understand the
+// storage and decoding costs. The series not exposing their encodings are counted with UnknownEncoding, without chunks.
+// The set has to be read with the chunks, the samples are not decoded. The same series read multiple times (e.g.
+// partitioned) are counted once, with all their chunks. The result is sorted by the name and the encoding.
+func CountByEncoding(s Set) ([]EncodingCount, error) {
+	defer s.Close()
+
+	type key struct{ name, encoding string }
+	var (
+		counts = map[key]*EncodingCount{}
+		seen   = map[key]map[uint64]struct{}{}
+	)
+	add := func(ls labels.Labels, e ChunkEncoding) {
+		k := key{name: ls.Get(labels.MetricName), encoding: e.Encoding}
+		c, ok := counts[k]
+		if !ok {
+			c = &EncodingCount{Name: k.name, Encoding: k.encoding}
+			counts[k] = c
+			seen[k] = map[uint64]struct{}{}
+		}
+		c.Chunks += e.Chunks
+		c.Bytes += e.Bytes
+		if _, ok := seen[k][ls.Hash()]; !ok {
+			seen[k][ls.Hash()] = struct{}{}
+			c.Series++
+		}
+	}
+	for s.Next() {
+		encodings, ok := ChunkEncodingsOf(s.At())
+		if !ok {
+			encodings = []ChunkEncoding{{Encoding: UnknownEncoding}}
+		}
+		for _, e := range encodings {
+			add(s.At().Labels(), e)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	ret := make([]EncodingCount, 0, len(counts))
+	for _, c := range counts {
+		ret = append(ret, *c)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Name != ret[j].Name {
+			return ret[i].Name < ret[j].Name
+		}
+		return ret[i].Encoding < ret[j].Encoding
+	})
+	return ret, nil
+}
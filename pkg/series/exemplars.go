@@ -0,0 +1,41 @@
+package series
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+)
+
+// ErrExemplarsUnsupported is returned by ExemplarsReader when the endpoint does not serve exemplars.
+var ErrExemplarsUnsupported = errors.New("exemplars are not supported by the endpoint")
+
+// Exemplar is a single exemplar, e.g. a sample with the trace_id label referencing the trace it comes from.
+type Exemplar struct {
+	Labels labels.Labels
+	Value  float64
+	// Ts is the timestamp in milliseconds.
+	Ts int64
+}
+
+// SeriesExemplars holds the exemplars of a single series.
+type SeriesExemplars struct {
+	SeriesLabels labels.Labels
+	Exemplars    []Exemplar
+}
+
+// ExemplarsReader is implemented by the inputs able to read exemplars of the series.
+type ExemplarsReader interface {
+	// ReadExemplars returns the exemplars of the series matching the params in their time range.
+	ReadExemplars(context.Context, Params) ([]SeriesExemplars, error)
+}
+
+// MatchersQuery returns the PromQL selector of the given matchers, e.g. {__name__="up",job=~"prom.*"}.
+func MatchersQuery(ms []*labels.Matcher) string {
+	s := make([]string, 0, len(ms))
+	for _, m := range ms {
+		s = append(s, m.String())
+	}
+	return "{" + strings.Join(s, ",") + "}"
+}
@@ -1,23 +1,76 @@
 package factory
 
 import (
+	"fmt"
 	"strings"
+	"sync"
 
 	"github.com/go-kit/kit/log"
 	"github.com/pkg/errors"
 	"github.com/thanos-community/obslytics/pkg/series"
+	"github.com/thanos-community/obslytics/pkg/series/bucket"
 	"github.com/thanos-community/obslytics/pkg/series/promread"
 	"github.com/thanos-community/obslytics/pkg/series/storeapi"
+	"github.com/thanos-community/obslytics/pkg/series/textfile"
+	"google.golang.org/grpc"
 )
 
-// NewSeriesReader creates series.Reader based on configuration file.
-func NewSeriesReader(logger log.Logger, cfg series.Config) (series.Reader, error) {
-	switch series.Type(strings.ToUpper(string(cfg.Type))) {
-	case series.REMOTEREAD:
+// ReaderFactory creates the series.Reader of the input configuration. The dial options are to be appended to the
+// configured ones by the gRPC inputs, e.g. to instrument the client.
+type ReaderFactory func(logger log.Logger, cfg series.Config, dialOpts ...grpc.DialOption) (series.Reader, error)
+
+var (
+	readersMtx sync.RWMutex
+	readers    = map[series.Type]ReaderFactory{}
+)
+
+// RegisterReader makes the input type available by the name, e.g. for a custom input registered from the init
+// function of its package. The type specific options can be given by the config of the input configuration. The
+// names are case-insensitive. It panics if the name is registered already.
+func RegisterReader(name string, f ReaderFactory) {
+	readersMtx.Lock()
+	defer readersMtx.Unlock()
+
+	t := series.Type(strings.ToUpper(name))
+	if _, ok := readers[t]; ok {
+		panic(fmt.Sprintf("input type %s registered twice", t))
+	}
+	readers[t] = f
+}
+
+func init() {
+	RegisterReader(string(series.REMOTEREAD), func(logger log.Logger, cfg series.Config, _ ...grpc.DialOption) (series.Reader, error) {
 		return promread.NewSeries(logger, cfg)
-	case series.STOREAPI:
-		return storeapi.NewSeries(logger, cfg)
-	default:
+	})
+	RegisterReader(string(series.STOREAPI), func(logger log.Logger, cfg series.Config, dialOpts ...grpc.DialOption) (series.Reader, error) {
+		return storeapi.NewSeries(logger, cfg, storeapi.WithDialOptions(dialOpts...))
+	})
+	RegisterReader(string(series.BUCKET), func(logger log.Logger, cfg series.Config, _ ...grpc.DialOption) (series.Reader, error) {
+		return bucket.NewSeries(logger, cfg)
+	})
+	RegisterReader(string(series.TEXTFILE), func(logger log.Logger, cfg series.Config, _ ...grpc.DialOption) (series.Reader, error) {
+		return textfile.NewSeries(logger, cfg)
+	})
+}
+
+// NewSeriesReader creates series.Reader of the registered type of the configuration. The dial options are appended
+// to the configured ones by the gRPC inputs (STOREAPI), e.g. to instrument the client.
+func NewSeriesReader(logger log.Logger, cfg series.Config, dialOpts ...grpc.DialOption) (series.Reader, error) {
+	readersMtx.RLock()
+	f, ok := readers[series.Type(strings.ToUpper(string(cfg.Type)))]
+	readersMtx.RUnlock()
+	if !ok {
 		return nil, errors.Errorf("unsupported Reader type %s", cfg.Type)
 	}
+	if cfg.Tenant.Enabled() && series.Type(strings.ToUpper(string(cfg.Type))) != series.STOREAPI {
+		return nil, errors.Errorf("tenant can only be enforced by STOREAPI input, not %v", cfg.Type)
+	}
+	if cfg.Coalesce != (series.CoalesceConfig{}) {
+		switch series.Type(strings.ToUpper(string(cfg.Type))) {
+		case series.STOREAPI, series.REMOTEREAD:
+		default:
+			return nil, errors.Errorf("coalesce is only supported by STOREAPI and REMOTEREAD inputs, not %v", cfg.Type)
+		}
+	}
+	return f(logger, cfg, dialOpts...)
 }
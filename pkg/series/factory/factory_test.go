@@ -0,0 +1,39 @@
+package factory
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/thanos-community/obslytics/pkg/series"
+	"github.com/thanos-io/thanos/pkg/testutil"
+	"google.golang.org/grpc"
+)
+
+type customReader struct{ conf interface{} }
+
+func (customReader) Read(context.Context, series.Params) (series.Set, error) { return nil, nil }
+
+func TestRegisterReader(t *testing.T) {
+	RegisterReader("custom", func(_ log.Logger, cfg series.Config, _ ...grpc.DialOption) (series.Reader, error) {
+		return customReader{conf: cfg.Config}, nil
+	})
+
+	r, err := NewSeriesReader(log.NewNopLogger(), series.Config{Type: "Custom", Config: map[string]string{"a": "b"}})
+	testutil.Ok(t, err)
+	testutil.Equals(t, customReader{conf: map[string]string{"a": "b"}}, r)
+
+	_, err = NewSeriesReader(log.NewNopLogger(), series.Config{Type: "missing"})
+	testutil.NotOk(t, err)
+
+	// Only STOREAPI input enforces the tenant.
+	_, err = NewSeriesReader(log.NewNopLogger(), series.Config{Type: "custom", Tenant: series.TenantConfig{Value: "a"}})
+	testutil.NotOk(t, err)
+
+	// The other inputs do not coalesce the samples.
+	_, err = NewSeriesReader(log.NewNopLogger(), series.Config{Type: "custom", Coalesce: series.CoalesceConfig{Mode: series.CoalesceAverage}})
+	testutil.NotOk(t, err)
+
+	defer func() { testutil.Assert(t, recover() != nil, "expected panic on duplicate registration") }()
+	RegisterReader(string(series.STOREAPI), nil)
+}
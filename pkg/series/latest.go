@@ -0,0 +1,61 @@
+package series
+
+import (
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+)
+
+// LatestSeries wraps a series to expose only its most recent sample.
+type LatestSeries struct {
+	storage.Series
+}
+
+func (s LatestSeries) Iterator() chunkenc.Iterator {
+	return &latestSeriesIterator{it: s.Series.Iterator()}
+}
+
+func (s LatestSeries) Unwrap() storage.Series { return s.Series }
+
+// latestSeriesIterator drains the wrapped iterator and emits its last sample only.
+type latestSeriesIterator struct {
+	it    chunkenc.Iterator
+	t     int64
+	v     float64
+	count uint64
+	ok    bool
+	done  bool
+}
+
+func (it *latestSeriesIterator) Seek(t int64) bool {
+	if !it.done {
+		it.Next()
+	}
+	return it.ok && it.t >= t
+}
+
+func (it *latestSeriesIterator) At() (int64, float64) {
+	return it.t, it.v
+}
+
+func (it *latestSeriesIterator) Next() bool {
+	if it.done {
+		return false
+	}
+	it.done = true
+
+	for it.it.Next() {
+		it.t, it.v = it.it.At()
+		it.count = SampleCount(it.it)
+		it.ok = true
+	}
+	it.ok = it.ok && it.it.Err() == nil
+	return it.ok
+}
+
+func (it *latestSeriesIterator) Err() error {
+	return it.it.Err()
+}
+
+func (it *latestSeriesIterator) SampleCount() uint64 {
+	return it.count
+}
@@ -0,0 +1,82 @@
+package memory
+
+import (
+	"context"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/thanos-community/obslytics/pkg/series"
+	"github.com/thanos-community/obslytics/pkg/series/storeapi"
+)
+
+// Compile-time check if memory Series implements series.Reader interface.
+var _ series.Reader = &Series{}
+
+// Series implements series.Reader over the series given by the caller, e.g. to test or benchmark the pipelines
+// embedding obslytics without a store. Every read yields the series matching the matchers with samples within the
+// time range, in the order given, so the series are to be given sorted by their labels as the other inputs yield
+// them. The samples are not copied, the series are wrapped to bound their iterators to the time range.
+type Series struct {
+	series []storage.Series
+}
+
+// NewSeries returns the reader of the series, e.g. created by storage.NewListSeries with samples sorted by timestamp.
+func NewSeries(series ...storage.Series) *Series {
+	return &Series{series: series}
+}
+
+func (s *Series) Read(ctx context.Context, params series.Params) (series.Set, error) {
+	if err := params.Validate(); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	matchers, err := params.PromMatchers()
+	if err != nil {
+		return nil, err
+	}
+
+	mint, maxt := params.Bounds()
+	ret := make([]storage.Series, 0, len(s.series))
+	for _, ser := range s.series {
+		if !matches(matchers, ser.Labels()) {
+			continue
+		}
+		var b storage.Series = storeapi.BoundedSeries{Series: ser, MinTime: mint, MaxTime: maxt}
+		if !b.Iterator().Seek(mint) {
+			// No samples within the time range.
+			continue
+		}
+		if params.Snapshot {
+			b = series.LatestSeries{Series: b}
+		}
+		ret = append(ret, b)
+	}
+	return NewSet(ret...), nil
+}
+
+func matches(ms []*labels.Matcher, lset labels.Labels) bool {
+	for _, m := range ms {
+		if !m.Matches(lset.Get(m.Name)) {
+			return false
+		}
+	}
+	return true
+}
+
+// NewSet returns the set iterating the series, e.g. to feed the series directly to dataframe.FromSeries.
+func NewSet(series ...storage.Series) series.Set {
+	return &set{series: series, i: -1}
+}
+
+type set struct {
+	series []storage.Series
+	i      int
+}
+
+func (s *set) Next() bool                 { s.i++; return s.i < len(s.series) }
+func (s *set) At() storage.Series         { return s.series[s.i] }
+func (s *set) Err() error                 { return nil }
+func (s *set) Warnings() storage.Warnings { return nil }
+func (s *set) Close() error               { return nil }
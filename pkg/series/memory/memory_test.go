@@ -0,0 +1,74 @@
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/tsdbutil"
+	"github.com/thanos-community/obslytics/pkg/series"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+type sample struct {
+	t int64
+	v float64
+}
+
+func (s sample) T() int64   { return s.t }
+func (s sample) V() float64 { return s.v }
+
+func TestSeries_Read(t *testing.T) {
+	s := NewSeries(
+		storage.NewListSeries(labels.FromStrings("__name__", "up", "job", "a"), []tsdbutil.Sample{sample{0, 1}, sample{30000, 2}, sample{60000, 3}}),
+		storage.NewListSeries(labels.FromStrings("__name__", "up", "job", "b"), []tsdbutil.Sample{sample{90000, 4}}),
+		storage.NewListSeries(labels.FromStrings("__name__", "other", "job", "a"), []tsdbutil.Sample{sample{0, 5}}),
+	)
+
+	read := func(params series.Params) (ret map[string][]sample) {
+		set, err := s.Read(context.Background(), params)
+		testutil.Ok(t, err)
+		defer func() { testutil.Ok(t, set.Close()) }()
+
+		ret = map[string][]sample{}
+		for set.Next() {
+			it := set.At().Iterator()
+			ss := []sample{}
+			for it.Next() {
+				ts, v := it.At()
+				ss = append(ss, sample{t: ts, v: v})
+			}
+			testutil.Ok(t, it.Err())
+			ret[set.At().Labels().String()] = ss
+		}
+		testutil.Ok(t, set.Err())
+		return ret
+	}
+
+	params := series.Params{
+		Matchers:         []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "__name__", "up")},
+		MinTime:          time.Unix(0, 0),
+		MaxTime:          time.Unix(60, 0),
+		MinTimeExclusive: true,
+	}
+	testutil.Equals(t, map[string][]sample{`{__name__="up", job="a"}`: {{30000, 2}, {60000, 3}}}, read(params))
+
+	params.Snapshot, params.MaxTime = true, time.Unix(90, 0)
+	testutil.Equals(t, map[string][]sample{
+		`{__name__="up", job="a"}`: {{60000, 3}},
+		`{__name__="up", job="b"}`: {{90000, 4}},
+	}, read(params))
+
+	// The series can be read again.
+	testutil.Equals(t, 2, len(read(params)))
+
+	params.Matchers = nil
+	params.StoreMatchers = []storepb.LabelMatcher{{Type: storepb.LabelMatcher_EQ, Name: "job", Value: "b"}}
+	testutil.Equals(t, map[string][]sample{`{__name__="up", job="b"}`: {{90000, 4}}}, read(params))
+
+	_, err := s.Read(context.Background(), series.Params{MinTime: time.Unix(60, 0), MaxTime: time.Unix(0, 0)})
+	testutil.NotOk(t, err)
+}
@@ -0,0 +1,322 @@
+package series
+
+import (
+	"math"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+)
+
+// OutOfOrderPolicy defines how samples out of timestamp order within a series are handled. Samples with equal
+// timestamps are not considered out of order.
+type OutOfOrderPolicy string
+
+const (
+	// OutOfOrderError fails the read on the first out-of-order sample. This is the default, while the earlier versions
+	// skipped the out-of-order StoreAPI chunks and read the out-of-order samples of the other inputs as they came.
+	OutOfOrderError OutOfOrderPolicy = "error"
+	// OutOfOrderSort sorts the samples of series with out-of-order samples, logging a warning. Samples of every
+	// series are buffered to be sorted, in memory unless bounded by the sort buffer.
+	OutOfOrderSort OutOfOrderPolicy = "sort"
+	// OutOfOrderDrop drops samples older than the latest sample read so far, logging a warning.
+	OutOfOrderDrop OutOfOrderPolicy = "drop"
+)
+
+// Validate returns error if the policy is not known.
+func (p OutOfOrderPolicy) Validate() error {
+	switch p {
+	case "", OutOfOrderError, OutOfOrderSort, OutOfOrderDrop:
+		return nil
+	}
+	return errors.Errorf("unsupported out_of_order policy %q, expected error, sort or drop", p)
+}
+
+// DuplicatesPolicy defines how samples with equal timestamps within a series are handled.
+type DuplicatesPolicy string
+
+const (
+	// DuplicatesKeepAll keeps all the samples with equal timestamps. This is the default.
+	DuplicatesKeepAll DuplicatesPolicy = "keep-all"
+	// DuplicatesKeepFirst keeps the first of the samples with equal timestamps, in the order they are read.
+	DuplicatesKeepFirst DuplicatesPolicy = "keep-first"
+	// DuplicatesKeepLast keeps the last of the samples with equal timestamps, in the order they are read.
+	DuplicatesKeepLast DuplicatesPolicy = "keep-last"
+	// DuplicatesAverage replaces the samples with equal timestamps with a single sample of their average value.
+	DuplicatesAverage DuplicatesPolicy = "average"
+	// DuplicatesError fails the read on the first duplicate timestamp.
+	DuplicatesError DuplicatesPolicy = "error"
+)
+
+// Validate returns error if the policy is not known.
+func (p DuplicatesPolicy) Validate() error {
+	switch p {
+	case "", DuplicatesKeepAll, DuplicatesKeepFirst, DuplicatesKeepLast, DuplicatesAverage, DuplicatesError:
+		return nil
+	}
+	return errors.Errorf("unsupported duplicates policy %q, expected keep-all, keep-first, keep-last, average or error", p)
+}
+
+// NewOrderedSet returns the set with the samples of every series checked to be in timestamp order, handling
+// out-of-order samples and then samples with duplicate timestamps according to the policies. The sort buffer bounds
+// the memory used by the sort policy, the temporary files it spills to are removed when the set is closed at latest.
+func NewOrderedSet(logger log.Logger, s Set, outOfOrder OutOfOrderPolicy, duplicates DuplicatesPolicy, sortBuffer SortBufferConfig) Set {
+	if outOfOrder == "" {
+		outOfOrder = OutOfOrderError
+	}
+	if duplicates == "" {
+		duplicates = DuplicatesKeepAll
+	}
+	return &orderedSet{Set: s, logger: logger, policy: outOfOrder, duplicates: duplicates, sortBuffer: sortBuffer, spills: &spillFiles{}}
+}
+
+type orderedSet struct {
+	Set
+
+	logger     log.Logger
+	policy     OutOfOrderPolicy
+	duplicates DuplicatesPolicy
+	sortBuffer SortBufferConfig
+	spills     *spillFiles
+}
+
+func (s *orderedSet) At() storage.Series {
+	return &orderedSeries{Series: s.Set.At(), set: s}
+}
+
+func (s *orderedSet) Close() error {
+	err := s.spills.removeAll()
+	if cerr := s.Set.Close(); cerr != nil {
+		return cerr
+	}
+	return err
+}
+
+type orderedSeries struct {
+	storage.Series
+
+	set *orderedSet
+}
+
+func (s *orderedSeries) Unwrap() storage.Series { return s.Series }
+
+func (s *orderedSeries) Iterator() chunkenc.Iterator {
+	var it chunkenc.Iterator
+	if s.set.policy == OutOfOrderSort {
+		it = s.sortedIterator()
+	} else {
+		it = &orderCheckingIterator{Iterator: s.Series.Iterator(), series: s, lastT: math.MinInt64}
+	}
+	if s.set.duplicates == DuplicatesKeepAll {
+		return it
+	}
+	return &dedupIterator{it: it, series: s}
+}
+
+// sortedIterator reads all the samples and sorts them if needed. Samples exceeding the sort buffer are spilled
+// to disk in sorted runs, merged by the returned iterator.
+func (s *orderedSeries) sortedIterator() chunkenc.Iterator {
+	var (
+		it     = s.Series.Iterator()
+		sorter = newSpillSorter(s.set.spills, s.set.sortBuffer)
+		lastT  = int64(math.MinInt64)
+		ooo    int
+	)
+	for it.Next() {
+		t, v := it.At()
+		if t < lastT {
+			ooo++
+		} else {
+			lastT = t
+		}
+		if err := sorter.add(countedSample{sample: sample{t: t, v: v}, count: SampleCount(it)}); err != nil {
+			_ = sorter.close()
+			return errIterator{err: err}
+		}
+	}
+	if err := it.Err(); err != nil {
+		_ = sorter.close()
+		return errIterator{err: err}
+	}
+	if ooo > 0 {
+		level.Warn(s.set.logger).Log("msg", "sorted out-of-order samples", "series", s.Labels(), "samples", ooo)
+	}
+	return sorter.iterator()
+}
+
+// orderCheckingIterator fails or drops the out-of-order samples.
+type orderCheckingIterator struct {
+	chunkenc.Iterator
+
+	series  *orderedSeries
+	lastT   int64
+	dropped int
+	err     error
+}
+
+func (it *orderCheckingIterator) Next() bool {
+	for it.err == nil && it.Iterator.Next() {
+		t, _ := it.Iterator.At()
+		if t >= it.lastT {
+			it.lastT = t
+			return true
+		}
+		if it.series.set.policy == OutOfOrderError {
+			it.err = errors.Errorf("series %s: out-of-order sample at %d after %d, see out_of_order input option",
+				it.series.Labels(), t, it.lastT)
+			return false
+		}
+		it.dropped++
+	}
+	it.logDropped()
+	return false
+}
+
+func (it *orderCheckingIterator) Seek(t int64) bool {
+	if it.err != nil {
+		return false
+	}
+	if it.lastT != math.MinInt64 && it.lastT >= t {
+		return true
+	}
+	for it.Next() {
+		if ts, _ := it.Iterator.At(); ts >= t {
+			return true
+		}
+	}
+	return false
+}
+
+func (it *orderCheckingIterator) Err() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.Iterator.Err()
+}
+
+func (it *orderCheckingIterator) SampleCount() uint64 { return SampleCount(it.Iterator) }
+
+func (it *orderCheckingIterator) Elapsed() int64 { return Elapsed(it.Iterator) }
+
+func (it *orderCheckingIterator) Aggregate() (Aggregate, bool) { return SampleAggregate(it.Iterator) }
+
+func (it *orderCheckingIterator) logDropped() {
+	if it.dropped > 0 {
+		level.Warn(it.series.set.logger).Log("msg", "dropped out-of-order samples", "series", it.series.Labels(), "samples", it.dropped)
+		it.dropped = 0
+	}
+}
+
+// dedupIterator merges the consecutive samples with equal timestamps of an ordered iterator.
+type dedupIterator struct {
+	it     chunkenc.Iterator
+	series *orderedSeries
+
+	started, ok         bool
+	cur, next           sample
+	curCount, nextCount uint64
+	hasNext             bool
+	err                 error
+}
+
+func (it *dedupIterator) Next() bool {
+	if !it.started {
+		it.started = true
+		it.advance()
+	}
+	if it.err != nil || !it.hasNext {
+		it.ok = false
+		return false
+	}
+
+	var (
+		cur   = it.next
+		count = it.nextCount
+		sum   = cur.v
+		n     = 1
+	)
+	for it.advance() && it.next.t == cur.t {
+		switch it.series.set.duplicates {
+		case DuplicatesKeepLast:
+			cur.v, count = it.next.v, it.nextCount
+		case DuplicatesAverage:
+			sum += it.next.v
+			n++
+		case DuplicatesError:
+			it.err = errors.Errorf("series %s: duplicate samples at %d, see duplicates input option", it.series.Labels(), cur.t)
+			it.ok = false
+			return false
+		}
+	}
+	if it.series.set.duplicates == DuplicatesAverage {
+		cur.v = sum / float64(n)
+	}
+	it.cur, it.curCount, it.ok = cur, count, true
+	return true
+}
+
+// advance reads the next sample of the wrapped iterator.
+func (it *dedupIterator) advance() bool {
+	it.hasNext = it.it.Next()
+	if it.hasNext {
+		it.next.t, it.next.v = it.it.At()
+		it.nextCount = SampleCount(it.it)
+	}
+	return it.hasNext
+}
+
+func (it *dedupIterator) Seek(t int64) bool {
+	if it.ok && it.cur.t >= t {
+		return true
+	}
+	for it.Next() {
+		if it.cur.t >= t {
+			return true
+		}
+	}
+	return false
+}
+
+func (it *dedupIterator) At() (int64, float64) { return it.cur.t, it.cur.v }
+
+// SampleCount returns the count of the sample kept, of the first one for the average of the duplicates, which are
+// expected to be replicas of the same samples.
+func (it *dedupIterator) SampleCount() uint64 { return it.curCount }
+
+func (it *dedupIterator) Err() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.it.Err()
+}
+
+type sample struct {
+	t int64
+	v float64
+}
+
+func (s sample) T() int64   { return s.t }
+func (s sample) V() float64 { return s.v }
+
+// countedSample is a sample with the count of the raw samples it aggregates, see SampleCount.
+type countedSample struct {
+	sample
+	count uint64
+}
+
+// sortableSamples implements sort.Interface.
+type sortableSamples []countedSample
+
+func (s sortableSamples) Len() int           { return len(s) }
+func (s sortableSamples) Less(i, j int) bool { return s[i].t < s[j].t }
+func (s sortableSamples) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// errIterator is an empty iterator failing with the error.
+type errIterator struct{ err error }
+
+func (errIterator) Next() bool           { return false }
+func (errIterator) Seek(int64) bool      { return false }
+func (errIterator) At() (int64, float64) { return 0, 0 }
+func (it errIterator) Err() error        { return it.err }
@@ -0,0 +1,128 @@
+package series
+
+import (
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/prometheus/tsdb/tsdbutil"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+type listSet struct {
+	series []storage.Series
+	i      int
+}
+
+func (s *listSet) Next() bool {
+	s.i++
+	return s.i < len(s.series)
+}
+func (s *listSet) At() storage.Series         { return s.series[s.i] }
+func (s *listSet) Err() error                 { return nil }
+func (s *listSet) Warnings() storage.Warnings { return nil }
+func (s *listSet) Close() error               { return nil }
+
+func TestOrderedSet(t *testing.T) {
+	newSet := func(policy OutOfOrderPolicy) Set {
+		return NewOrderedSet(log.NewNopLogger(), &listSet{i: -1, series: []storage.Series{
+			storage.NewListSeries(labels.FromStrings("a", "b"), []tsdbutil.Sample{
+				sample{t: 10, v: 1}, sample{t: 30, v: 3}, sample{t: 20, v: 2}, sample{t: 30, v: 4},
+			}),
+		}}, policy, "", SortBufferConfig{})
+	}
+
+	s := newSet("")
+	testutil.Assert(t, s.Next())
+	it := s.At().Iterator()
+	for it.Next() {
+	}
+	testutil.NotOk(t, it.Err())
+
+	s = newSet(OutOfOrderSort)
+	testutil.Assert(t, s.Next())
+	testutil.Equals(t, []int64{10, 20, 30, 30}, expandTimes(t, s.At().Iterator()))
+
+	s = newSet(OutOfOrderDrop)
+	testutil.Assert(t, s.Next())
+	testutil.Equals(t, []int64{10, 30, 30}, expandTimes(t, s.At().Iterator()))
+
+	testutil.NotOk(t, OutOfOrderPolicy("ignore").Validate())
+}
+
+func TestOrderedSet_Seek(t *testing.T) {
+	set := NewOrderedSet(log.NewNopLogger(), &listSet{i: -1, series: []storage.Series{
+		storage.NewListSeries(labels.FromStrings("a", "b"), []tsdbutil.Sample{
+			sample{t: 10, v: 1}, sample{t: 30, v: 3}, sample{t: 20, v: 2}, sample{t: 40, v: 4},
+		}),
+	}}, OutOfOrderDrop, "", SortBufferConfig{})
+	testutil.Assert(t, set.Next())
+
+	it := set.At().Iterator()
+	testutil.Assert(t, it.Seek(15))
+	ts, _ := it.At()
+	testutil.Equals(t, int64(30), ts)
+	// Seeking backwards keeps the position, the out-of-order sample is dropped.
+	testutil.Assert(t, it.Seek(20))
+	testutil.Equals(t, []int64{40}, expandTimes(t, it))
+}
+
+func expandTimes(t *testing.T, it chunkenc.Iterator) []int64 {
+	var ret []int64
+	for it.Next() {
+		ts, _ := it.At()
+		ret = append(ret, ts)
+	}
+	testutil.Ok(t, it.Err())
+	return ret
+}
+
+func TestOrderedSet_Duplicates(t *testing.T) {
+	for _, tcase := range []struct {
+		policy   DuplicatesPolicy
+		expected []sample
+	}{
+		{policy: "", expected: []sample{{10, 1}, {20, 2}, {20, 4}, {20, 6}, {30, 3}}},
+		{policy: DuplicatesKeepAll, expected: []sample{{10, 1}, {20, 2}, {20, 4}, {20, 6}, {30, 3}}},
+		{policy: DuplicatesKeepFirst, expected: []sample{{10, 1}, {20, 2}, {30, 3}}},
+		{policy: DuplicatesKeepLast, expected: []sample{{10, 1}, {20, 6}, {30, 3}}},
+		{policy: DuplicatesAverage, expected: []sample{{10, 1}, {20, 4}, {30, 3}}},
+	} {
+		t.Run(string(tcase.policy), func(t *testing.T) {
+			testutil.Ok(t, tcase.policy.Validate())
+			set := NewOrderedSet(log.NewNopLogger(), &listSet{i: -1, series: []storage.Series{
+				storage.NewListSeries(labels.FromStrings("a", "b"), []tsdbutil.Sample{
+					sample{t: 10, v: 1}, sample{t: 20, v: 2}, sample{t: 20, v: 4}, sample{t: 20, v: 6}, sample{t: 30, v: 3},
+				}),
+			}}, "", tcase.policy, SortBufferConfig{})
+			testutil.Assert(t, set.Next())
+
+			var got []sample
+			it := set.At().Iterator()
+			for it.Next() {
+				ts, v := it.At()
+				got = append(got, sample{t: ts, v: v})
+			}
+			testutil.Ok(t, it.Err())
+			testutil.Equals(t, tcase.expected, got)
+		})
+	}
+
+	t.Run("error", func(t *testing.T) {
+		set := NewOrderedSet(log.NewNopLogger(), &listSet{i: -1, series: []storage.Series{
+			storage.NewListSeries(labels.FromStrings("a", "b"), []tsdbutil.Sample{
+				sample{t: 10, v: 1}, sample{t: 20, v: 2}, sample{t: 20, v: 4},
+			}),
+		}}, "", DuplicatesError, SortBufferConfig{})
+		testutil.Assert(t, set.Next())
+
+		it := set.At().Iterator()
+		testutil.Assert(t, it.Seek(10))
+		testutil.Assert(t, !it.Next())
+		testutil.NotOk(t, it.Err())
+	})
+
+	testutil.NotOk(t, DuplicatesPolicy("keep-middle").Validate())
+}
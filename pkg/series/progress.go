@@ -0,0 +1,134 @@
+package series
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+)
+
+// ProgressFunc receives the estimated fraction of a read completed, from 0 to 1.
+type ProgressFunc func(fraction float64)
+
+// progressSteps is the number of steps of the estimate reported, so that the function is not called per sample.
+const progressSteps = 100
+
+// Progress estimates the progress of the reads of a time range by the latest sample timestamp read so far, as
+// (latest - mint) / (maxt - mint). The samples of every series come in time order, so the estimate grows as the series
+// stream in, but it is approximate: the series are read one after another, so it reaches 1 with the first series
+// spanning the whole range. It is most accurate for reads split into consecutive windows, which end in time order,
+// and for reads of few long series. The estimate is shared by the sets it wraps, e.g. by the windows and shards of an
+// export, and never decreases.
+type Progress struct {
+	mint, maxt int64
+	fn         ProgressFunc
+
+	// next is the timestamp of the next step of the estimate to report.
+	next int64
+
+	mtx      sync.Mutex
+	fraction float64
+}
+
+// NewProgress returns the Progress of the reads of the time range, reporting the estimate to the function every time
+// it grows by at least 1%. The function is called by the reading goroutines, one call at a time.
+func NewProgress(mint, maxt time.Time, fn ProgressFunc) *Progress {
+	p := &Progress{mint: timestamp.FromTime(mint), maxt: timestamp.FromTime(maxt), fn: fn}
+	p.next = p.mint
+	return p
+}
+
+// Fraction returns the latest estimate reported.
+func (p *Progress) Fraction() float64 {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	return p.fraction
+}
+
+// Set returns the set observing the timestamps of the samples read from its series.
+func (p *Progress) Set(s Set) Set {
+	return &progressSet{Set: s, p: p}
+}
+
+// observe reports the estimate if the sample timestamp reaches its next step.
+func (p *Progress) observe(t int64) {
+	if t < atomic.LoadInt64(&p.next) {
+		return
+	}
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if t < p.next {
+		return
+	}
+
+	f := 1.0
+	if span := p.maxt - p.mint; span > 0 {
+		f = math.Min(float64(t-p.mint)/float64(span), 1)
+	}
+	next := int64(math.MaxInt64)
+	if f < 1 {
+		// The first timestamp of the next step, in integers to not skip it by rounding.
+		span := p.maxt - p.mint
+		step := (t - p.mint) * progressSteps / span
+		next = p.mint + ((step+1)*span+progressSteps-1)/progressSteps
+	}
+	atomic.StoreInt64(&p.next, next)
+	p.fraction = f
+	p.fn(f)
+}
+
+type progressSet struct {
+	Set
+
+	p *Progress
+}
+
+func (s *progressSet) At() storage.Series {
+	return &progressSeries{Series: s.Set.At(), p: s.p}
+}
+
+type progressSeries struct {
+	storage.Series
+
+	p *Progress
+}
+
+func (s *progressSeries) Unwrap() storage.Series { return s.Series }
+
+func (s *progressSeries) Iterator() chunkenc.Iterator {
+	return &progressIterator{Iterator: s.Series.Iterator(), p: s.p}
+}
+
+type progressIterator struct {
+	chunkenc.Iterator
+
+	p *Progress
+}
+
+func (it *progressIterator) Next() bool {
+	if !it.Iterator.Next() {
+		return false
+	}
+	t, _ := it.Iterator.At()
+	it.p.observe(t)
+	return true
+}
+
+func (it *progressIterator) SampleCount() uint64 { return SampleCount(it.Iterator) }
+
+func (it *progressIterator) Elapsed() int64 { return Elapsed(it.Iterator) }
+
+func (it *progressIterator) Aggregate() (Aggregate, bool) { return SampleAggregate(it.Iterator) }
+
+func (it *progressIterator) Seek(t int64) bool {
+	if !it.Iterator.Seek(t) {
+		return false
+	}
+	t, _ = it.Iterator.At()
+	it.p.observe(t)
+	return true
+}
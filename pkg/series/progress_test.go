@@ -0,0 +1,58 @@
+package series
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/tsdbutil"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestProgress(t *testing.T) {
+	window := func(mint, maxt int64) Set {
+		var samples []tsdbutil.Sample
+		for ts := mint; ts <= maxt; ts += 10 {
+			samples = append(samples, sample{t: ts, v: 1})
+		}
+		return &listSet{i: -1, series: []storage.Series{
+			storage.NewListSeries(labels.FromStrings("name", "a"), samples),
+			storage.NewListSeries(labels.FromStrings("name", "b"), samples),
+		}}
+	}
+	read := func(s Set) {
+		for s.Next() {
+			for it := s.At().Iterator(); it.Next(); {
+			}
+		}
+		testutil.Ok(t, s.Err())
+	}
+
+	var reported []float64
+	p := NewProgress(time.Unix(0, 0), time.Unix(1, 0), func(f float64) { reported = append(reported, f) })
+	testutil.Equals(t, 0.0, p.Fraction())
+
+	// The estimate is reported every 1% of the range, once for the samples of the windows read again.
+	read(p.Set(window(0, 500)))
+	testutil.Equals(t, 51, len(reported))
+	testutil.Equals(t, 0.0, reported[0])
+	testutil.Equals(t, 0.5, reported[50])
+	testutil.Equals(t, 0.5, p.Fraction())
+
+	read(p.Set(window(200, 1000)))
+	testutil.Equals(t, 101, len(reported))
+	testutil.Equals(t, 1.0, p.Fraction())
+	for i := 1; i < len(reported); i++ {
+		testutil.Assert(t, reported[i] > reported[i-1], "estimate has to grow, got %v", reported)
+	}
+
+	// The samples past the range are read at most once.
+	read(p.Set(window(1000, 1200)))
+	testutil.Equals(t, 101, len(reported))
+
+	// Empty ranges are complete with the first sample.
+	p = NewProgress(time.Unix(1, 0), time.Unix(1, 0), func(f float64) { reported = append(reported, f) })
+	read(p.Set(window(1000, 1000)))
+	testutil.Equals(t, 1.0, p.Fraction())
+}
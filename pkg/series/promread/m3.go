@@ -0,0 +1,62 @@
+package promread
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/pkg/errors"
+	config_util "github.com/prometheus/common/config"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/thanos-community/obslytics/pkg/series"
+)
+
+const (
+	m3MetricsTypeHeader   = "M3-Metrics-Type"
+	m3StoragePolicyHeader = "M3-Storage-Policy"
+	// m3ResultsLimitedHeader is set by the M3 coordinator on results cut by its series or docs query limits.
+	m3ResultsLimitedHeader = "M3-Results-Limited"
+)
+
+// readM3 reads the series from the M3 coordinator remote read endpoint, which serves the sampled responses only.
+// The results cut by the coordinator limits fail the read, as the export would silently miss series otherwise.
+func (i Series) readM3(
+	ctx context.Context,
+	httpConfig config_util.HTTPClientConfig,
+	u *url.URL,
+	query *prompb.Query,
+	params series.Params,
+) (series.Set, error) {
+	client, err := config_util.NewClientFromConfig(httpConfig, "remote_read")
+	if err != nil {
+		return nil, err
+	}
+
+	header := http.Header{}
+	if t := i.conf.RemoteRead.M3.MetricsType; t != "" {
+		header.Set(m3MetricsTypeHeader, t)
+	}
+	if p := i.conf.RemoteRead.M3.StoragePolicy; p != "" {
+		header.Set(m3StoragePolicyHeader, p)
+	}
+	req := &prompb.ReadRequest{
+		Queries:               []*prompb.Query{query},
+		AcceptedResponseTypes: []prompb.ReadRequest_ResponseType{prompb.ReadRequest_SAMPLES},
+	}
+	resp, err := i.post(ctx, client, u, req, header)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if limited := resp.Header.Get(m3ResultsLimitedHeader); limited != "" {
+		return nil, errors.Errorf("remote read against %v: M3 coordinator returned incomplete results limited by %s, "+
+			"narrow the matchers or the time range, or raise the coordinator query limits", u.Redacted(), limited)
+	}
+	contentType := resp.Header.Get("Content-Type")
+	if isStreamedResponse(contentType) {
+		return nil, errors.Errorf("remote read against %v: got the streamed response with the m3 compatibility, "+
+			"is the endpoint a Prometheus server? Use the prometheus compatibility for it", u.Redacted())
+	}
+	return i.readSampledResponse(ctx, resp.Body, contentType, params)
+}
@@ -0,0 +1,99 @@
+package promread
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/thanos-community/obslytics/pkg/series"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestReadM3(t *testing.T) {
+	var header http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		testutil.Equals(t, "aggregated", r.Header.Get("M3-Metrics-Type"))
+		testutil.Equals(t, "1m:40d", r.Header.Get("M3-Storage-Policy"))
+		testutil.Equals(t, "Bearer secret", r.Header.Get("Authorization"))
+
+		compressed, err := ioutil.ReadAll(r.Body)
+		testutil.Ok(t, err)
+		data, err := snappy.Decode(nil, compressed)
+		testutil.Ok(t, err)
+		var req prompb.ReadRequest
+		testutil.Ok(t, req.Unmarshal(data))
+		testutil.Equals(t, []prompb.ReadRequest_ResponseType{prompb.ReadRequest_SAMPLES}, req.AcceptedResponseTypes)
+
+		for k, vs := range header {
+			w.Header()[k] = vs
+		}
+		resp := &prompb.ReadResponse{Results: []*prompb.QueryResult{{Timeseries: []*prompb.TimeSeries{{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "up"}},
+			Samples: []prompb.Sample{{Timestamp: 1000, Value: 1}, {Timestamp: 9000, Value: 9}},
+		}}}}}
+		b, err := resp.Marshal()
+		testutil.Ok(t, err)
+		_, err = w.Write(snappy.Encode(nil, b))
+		testutil.Ok(t, err)
+	}))
+	defer srv.Close()
+
+	s, err := NewSeries(log.NewNopLogger(), series.Config{
+		Endpoint:    srv.URL,
+		BearerToken: "secret",
+		RemoteRead: series.RemoteReadConfig{
+			Compatibility: series.RemoteReadM3,
+			M3:            series.M3RemoteReadConfig{MetricsType: "aggregated", StoragePolicy: "1m:40d"},
+		},
+	})
+	testutil.Ok(t, err)
+	read := func() (series.Set, error) {
+		return s.Read(context.Background(), series.Params{
+			Matchers: []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "__name__", "up")},
+			MinTime:  timestamp.Time(0),
+			MaxTime:  timestamp.Time(5000),
+		})
+	}
+
+	header = http.Header{"Content-Type": {"application/x-protobuf"}}
+	set, err := read()
+	testutil.Ok(t, err)
+	testutil.Assert(t, set.Next())
+	testutil.Equals(t, labels.FromStrings("__name__", "up"), set.At().Labels())
+	var samples []prompb.Sample
+	for it := set.At().Iterator(); it.Next(); {
+		ts, v := it.At()
+		samples = append(samples, prompb.Sample{Timestamp: ts, Value: v})
+	}
+	testutil.Equals(t, []prompb.Sample{{Timestamp: 1000, Value: 1}}, samples)
+	testutil.Assert(t, !set.Next())
+	testutil.Ok(t, set.Err())
+
+	// Results cut by the coordinator limits fail the read.
+	header = http.Header{"Content-Type": {"application/x-protobuf"}, "M3-Results-Limited": {"max_fetch_series_limit_applied"}}
+	_, err = read()
+	testutil.NotOk(t, err)
+	testutil.Assert(t, strings.Contains(err.Error(), "incomplete results limited by max_fetch_series_limit_applied"), err.Error())
+
+	header = http.Header{"Content-Type": {"application/x-streamed-protobuf; proto=prometheus.ChunkedReadResponse"}}
+	_, err = read()
+	testutil.NotOk(t, err)
+	testutil.Assert(t, strings.Contains(err.Error(), "Use the prometheus compatibility"), err.Error())
+}
+
+func TestReadSampledResponse_Errors(t *testing.T) {
+	s, err := NewSeries(log.NewNopLogger(), series.Config{})
+	testutil.Ok(t, err)
+
+	_, err = s.readSampledResponse(context.Background(), strings.NewReader("<html></html>"), "text/html", series.Params{})
+	testutil.NotOk(t, err)
+	testutil.Assert(t, strings.Contains(err.Error(), `content type "text/html", is the endpoint a remote read API?`), err.Error())
+}
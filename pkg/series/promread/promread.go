@@ -4,13 +4,13 @@ import (
 	"context"
 	"net/url"
 	"path"
+	"sort"
 
 	"github.com/go-kit/kit/log"
 	"github.com/pkg/errors"
 	config_util "github.com/prometheus/common/config"
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/pkg/labels"
-	"github.com/prometheus/prometheus/pkg/timestamp"
 	"github.com/prometheus/prometheus/prompb"
 	"github.com/prometheus/prometheus/storage"
 	"github.com/prometheus/prometheus/storage/remote"
@@ -20,12 +20,13 @@ import (
 )
 
 type Series struct {
-	logger log.Logger
-	conf   series.Config
+	logger  log.Logger
+	conf    series.Config
+	limiter *series.Limiter
 }
 
 func NewSeries(logger log.Logger, conf series.Config) (Series, error) {
-	return Series{logger: logger, conf: conf}, nil
+	return Series{logger: logger, conf: conf, limiter: series.NewLimiter(conf.RateLimit)}, nil
 }
 
 // TranslatePromMatchers returns proto matchers (prompb) from Prometheus matchers.
@@ -53,6 +54,28 @@ func TranslatePromMatchers(ms ...*labels.Matcher) ([]*prompb.LabelMatcher, error
 }
 
 func (i Series) Read(ctx context.Context, params series.Params) (series.Set, error) {
+	if err := params.Validate(); err != nil {
+		return nil, err
+	}
+	if i.conf.TLSConfig.PKCS12File != "" {
+		return nil, errors.New("pkcs12_file is not supported by the REMOTEREAD input, use cert_file and key_file")
+	}
+	if err := i.conf.OutOfOrder.Validate(); err != nil {
+		return nil, err
+	}
+	if err := i.conf.Duplicates.Validate(); err != nil {
+		return nil, err
+	}
+	if err := i.conf.DuplicateLabels.Validate(); err != nil {
+		return nil, err
+	}
+	if err := i.conf.Coalesce.Validate(); err != nil {
+		return nil, err
+	}
+	if err := i.conf.RemoteRead.Validate(); err != nil {
+		return nil, err
+	}
+
 	tlsConfig := config_util.TLSConfig{
 		CAFile:             i.conf.TLSConfig.CAFile,
 		CertFile:           i.conf.TLSConfig.CertFile,
@@ -61,8 +84,14 @@ func (i Series) Read(ctx context.Context, params series.Params) (series.Set, err
 		InsecureSkipVerify: i.conf.TLSConfig.InsecureSkipVerify,
 	}
 
+	if i.conf.BearerToken != "" && i.conf.BearerTokenFile != "" {
+		return nil, errors.New("bearer_token and bearer_token_file cannot be used together")
+	}
+	// The token file is read on every request by the HTTP client.
 	httpConfig := config_util.HTTPClientConfig{
-		TLSConfig: tlsConfig,
+		TLSConfig:       tlsConfig,
+		BearerToken:     config_util.Secret(i.conf.BearerToken),
+		BearerTokenFile: i.conf.BearerTokenFile,
 	}
 
 	parsedUrl, err := url.Parse(i.conf.Endpoint)
@@ -78,6 +107,7 @@ func (i Series) Read(ctx context.Context, params series.Params) (series.Set, err
 		URL:              &config_util.URL{URL: parsedUrl},
 		Timeout:          timeoutDuration,
 		HTTPClientConfig: httpConfig,
+		Headers:          i.conf.Metadata,
 	}
 
 	client, err := remote.NewReadClient(path.Join("obslytics", version.Version), clientConfig)
@@ -85,38 +115,114 @@ func (i Series) Read(ctx context.Context, params series.Params) (series.Set, err
 		return nil, err
 	}
 
-	promLabelMatchers, err := TranslatePromMatchers(params.Matchers...)
+	matchers, err := params.PromMatchers()
+	if err != nil {
+		return nil, err
+	}
+	promLabelMatchers, err := TranslatePromMatchers(matchers...)
 	if err != nil {
 		return nil, err
 	}
 
 	// Construct Query.
+	start, end := params.Range()
 	query := &prompb.Query{
-		StartTimestampMs: timestamp.FromTime(params.MinTime),
-		EndTimestampMs:   timestamp.FromTime(params.MaxTime),
+		StartTimestampMs: start,
+		EndTimestampMs:   end,
 		Matchers:         promLabelMatchers,
 	}
-	// TODO: Move to streaming remote read version when available.
+	if err := i.limiter.WaitRequest(ctx); err != nil {
+		return nil, err
+	}
+	if i.conf.RemoteRead.Compatibility == series.RemoteReadM3 {
+		s, err := i.readM3(ctx, httpConfig, parsedUrl, query, params)
+		if err != nil {
+			return nil, err
+		}
+		return i.checked(s), nil
+	}
+	if i.conf.RemoteRead.Streamed {
+		s, err := i.readStreamed(ctx, httpConfig, parsedUrl, query, params)
+		if err != nil {
+			return nil, err
+		}
+		return i.checked(s), nil
+	}
+
 	readResponse, err := client.Read(ctx, query)
 	if err != nil {
 		return nil, err
 	}
-
-	readSeriesList := make([]ReadSeries, 0, len(readResponse.Timeseries))
-
-	// Convert Timeseries List to a Read Series List.
-	for index := range readResponse.Timeseries {
-		readSeriesList = append(readSeriesList, ReadSeries{
-			timeseries: *readResponse.Timeseries[index],
-		})
+	if err := i.limiter.WaitBytes(ctx, readResponse.Size()); err != nil {
+		return nil, err
 	}
 
-	return &iterator{
+	return i.checked(&iterator{
 		ctx:                ctx,
 		client:             client,
-		seriesList:         readSeriesList,
+		seriesList:         readSeriesList(readResponse.Timeseries, params),
 		currentSeriesIndex: -1,
-	}, nil
+	}), nil
+}
+
+// checked returns the set with the samples order and the label names of every series checked by the policies, and
+// the samples coalesced.
+func (i Series) checked(s series.Set) series.Set {
+	s = series.NewOrderedSet(i.logger, s, i.conf.OutOfOrder, i.conf.Duplicates, i.conf.SortBuffer)
+	s = series.NewCoalescedSet(s, i.conf.Coalesce)
+	return series.NewLabelsChecker(i.logger, i.conf.DuplicateLabels).Set(s)
+}
+
+// readSeriesList converts the time series into read series, limited to the params time range.
+func readSeriesList(timeseries []*prompb.TimeSeries, params series.Params) []ReadSeries {
+	readSeriesList := make([]ReadSeries, 0, len(timeseries))
+
+	mint, maxt := params.Bounds()
+	for index := range timeseries {
+		readSeriesList = append(readSeriesList, newReadSeries(*timeseries[index], mint, maxt, params.Snapshot))
+	}
+	return readSeriesList
+}
+
+func newReadSeries(ts prompb.TimeSeries, mint, maxt int64, snapshot bool) ReadSeries {
+	ts.Samples = boundedSamples(ts.Samples, mint, maxt)
+	if snapshot {
+		ts.Samples = latestSample(ts.Samples, maxt)
+	}
+	return ReadSeries{timeseries: ts, lset: promLabels(ts.Labels)}
+}
+
+// promLabels converts the labels in a single allocation.
+func promLabels(ls []prompb.Label) labels.Labels {
+	lset := make(labels.Labels, 0, len(ls))
+	for _, l := range ls {
+		lset = append(lset, labels.Label{Name: l.Name, Value: l.Value})
+	}
+	if !sort.IsSorted(lset) {
+		sort.Sort(lset)
+	}
+	return lset
+}
+
+// boundedSamples returns the samples within the inclusive [mint, maxt] range. Samples are expected
+// to be sorted by timestamp.
+func boundedSamples(samples []prompb.Sample, mint, maxt int64) []prompb.Sample {
+	start := sort.Search(len(samples), func(i int) bool { return samples[i].Timestamp >= mint })
+	end := sort.Search(len(samples), func(i int) bool { return samples[i].Timestamp > maxt })
+	if start >= end {
+		return samples[:0]
+	}
+	return samples[start:end]
+}
+
+// latestSample returns the last sample at or before maxt. Samples are expected to be sorted by timestamp.
+func latestSample(samples []prompb.Sample, maxt int64) []prompb.Sample {
+	for i := len(samples) - 1; i >= 0; i-- {
+		if samples[i].Timestamp <= maxt {
+			return samples[i : i+1]
+		}
+	}
+	return samples[:0]
 }
 
 // iterator implements input.Set.
@@ -148,18 +254,12 @@ func (i *iterator) Close() error               { return nil }
 // ReadSeries implements storage.Series.
 type ReadSeries struct {
 	timeseries prompb.TimeSeries
+	// lset is converted once, as the labels are read by multiple stages of the export.
+	lset labels.Labels
 }
 
 func (r ReadSeries) Labels() labels.Labels {
-	var labelList []labels.Label
-	for i := range r.timeseries.Labels {
-		labelList = append(labelList, labels.Label{
-			Name:  r.timeseries.Labels[i].Name,
-			Value: r.timeseries.Labels[i].Value,
-		})
-
-	}
-	return labels.New(labelList...)
+	return r.lset
 }
 
 func (r ReadSeries) Iterator() chunkenc.Iterator {
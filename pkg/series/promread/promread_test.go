@@ -77,8 +77,10 @@ func TestRemoteReadInput_Open(t *testing.T) {
 
 		inConfig := series.Config{
 			Endpoint: "http://" + prom.HTTPEndpoint() + "/api/v1/read",
-			TLSConfig: http_util.TLSConfig{
-				InsecureSkipVerify: true,
+			TLSConfig: series.TLSConfig{
+				TLSConfig: http_util.TLSConfig{
+					InsecureSkipVerify: true,
+				},
 			},
 		}
 		remoteReadInput, err := NewSeries(nil, inConfig)
@@ -0,0 +1,238 @@
+package promread
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/pkg/errors"
+	config_util "github.com/prometheus/common/config"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/storage/remote"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/thanos-community/obslytics/pkg/series"
+	"github.com/thanos-community/obslytics/pkg/version"
+)
+
+// readStreamed reads the series using the streamed remote read, decoding the response frame by frame.
+// If the endpoint replies with the sampled response instead, it's decoded as a whole.
+func (i Series) readStreamed(
+	ctx context.Context,
+	httpConfig config_util.HTTPClientConfig,
+	u *url.URL,
+	query *prompb.Query,
+	params series.Params,
+) (series.Set, error) {
+	client, err := config_util.NewClientFromConfig(httpConfig, "remote_read")
+	if err != nil {
+		return nil, err
+	}
+
+	req := &prompb.ReadRequest{
+		Queries:               []*prompb.Query{query},
+		AcceptedResponseTypes: []prompb.ReadRequest_ResponseType{prompb.ReadRequest_STREAMED_XOR_CHUNKS},
+	}
+	resp, err := i.post(ctx, client, u, req, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	mint, maxt := params.Bounds()
+	if !isStreamedResponse(resp.Header.Get("Content-Type")) {
+		defer resp.Body.Close()
+		return i.readSampledResponse(ctx, resp.Body, resp.Header.Get("Content-Type"), params)
+	}
+
+	limit := i.conf.RemoteRead.ChunkedReadLimit
+	if limit == 0 {
+		limit = remote.DefaultChunkedReadLimit
+	}
+	return &streamedIterator{
+		ctx:      ctx,
+		body:     resp.Body,
+		reader:   remote.NewChunkedReader(resp.Body, limit, nil),
+		limiter:  i.limiter,
+		mint:     mint,
+		maxt:     maxt,
+		snapshot: params.Snapshot,
+	}, nil
+}
+
+// post sends the remote read request with the headers given on top of the protocol ones, returning the response of
+// a 2xx status only. The error of other statuses includes the beginning of the response body.
+func (i Series) post(ctx context.Context, client *http.Client, u *url.URL, req *prompb.ReadRequest, header http.Header) (*http.Response, error) {
+	data, err := req.Marshal()
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal read request")
+	}
+
+	httpReq, err := http.NewRequest(http.MethodPost, u.String(), bytes.NewReader(snappy.Encode(nil, data)))
+	if err != nil {
+		return nil, errors.Wrap(err, "create request")
+	}
+	httpReq.Header.Add("Content-Encoding", "snappy")
+	httpReq.Header.Add("Accept-Encoding", "snappy")
+	httpReq.Header.Set("Content-Type", "application/x-protobuf")
+	httpReq.Header.Set("User-Agent", path.Join("obslytics", version.Version))
+	httpReq.Header.Set("X-Prometheus-Remote-Read-Version", "0.1.0")
+	for k, vs := range header {
+		httpReq.Header[k] = vs
+	}
+	for k, v := range i.conf.Metadata {
+		httpReq.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(httpReq.WithContext(ctx))
+	if err != nil {
+		return nil, errors.Wrapf(err, "remote read against %v", u.Redacted())
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, errors.Errorf("remote read against %v: server returned HTTP status %s: %s", u.Redacted(), resp.Status, bytes.TrimSpace(msg))
+	}
+	return resp, nil
+}
+
+// isStreamedResponse returns true for the streamed content type. Parameters are compared regardless
+// of their formatting, as not all servers format them the same way as Prometheus.
+func isStreamedResponse(contentType string) bool {
+	mt, p, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mt == "application/x-streamed-protobuf" && p["proto"] == "prometheus.ChunkedReadResponse"
+}
+
+// readSampledResponse decodes the sampled (non-streamed) remote read response. The decoding errors include the
+// response content type, as they are mostly caused by endpoints other than the remote read API, e.g. the Prometheus
+// query API or an HTML page of a proxy.
+func (i Series) readSampledResponse(ctx context.Context, r io.Reader, contentType string, params series.Params) (series.Set, error) {
+	compressed, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "read response")
+	}
+	if err := i.limiter.WaitBytes(ctx, len(compressed)); err != nil {
+		return nil, err
+	}
+	data, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		return nil, errors.Wrapf(err, "decode snappy response of content type %q, is the endpoint a remote read API?", contentType)
+	}
+
+	var resp prompb.ReadResponse
+	if err := resp.Unmarshal(data); err != nil {
+		return nil, errors.Wrapf(err, "unmarshal protobuf response of content type %q, is the endpoint a remote read API?", contentType)
+	}
+	if len(resp.Results) != 1 {
+		return nil, errors.Errorf("expected 1 result, got %d", len(resp.Results))
+	}
+	return &iterator{
+		ctx:                ctx,
+		seriesList:         readSeriesList(resp.Results[0].Timeseries, params),
+		currentSeriesIndex: -1,
+	}, nil
+}
+
+// streamedIterator implements series.Set on top of the streamed remote read response. The same series
+// can be split into multiple frames, in which case it's returned in multiple iterations.
+type streamedIterator struct {
+	ctx     context.Context
+	body    io.ReadCloser
+	reader  *remote.ChunkedReader
+	limiter *series.Limiter
+
+	mint, maxt int64
+	snapshot   bool
+
+	pending []*prompb.ChunkedSeries
+	current ReadSeries
+	err     error
+}
+
+func (i *streamedIterator) Next() bool {
+	for {
+		for len(i.pending) > 0 {
+			cs := i.pending[0]
+			i.pending = i.pending[1:]
+
+			ts, err := chunkedSeriesToTimeSeries(cs)
+			if err != nil {
+				i.err = err
+				return false
+			}
+			i.current = newReadSeries(ts, i.mint, i.maxt, i.snapshot)
+			if len(i.current.timeseries.Samples) > 0 {
+				return true
+			}
+		}
+
+		var resp prompb.ChunkedReadResponse
+		if err := i.reader.NextProto(&resp); err != nil {
+			if err != io.EOF {
+				i.err = errors.Wrap(err, "read streamed response")
+			}
+			return false
+		}
+		if err := i.limiter.WaitBytes(i.ctx, resp.Size()); err != nil {
+			i.err = err
+			return false
+		}
+		i.pending = resp.ChunkedSeries
+	}
+}
+
+func (i *streamedIterator) At() storage.Series         { return i.current }
+func (i *streamedIterator) Warnings() storage.Warnings { return nil }
+func (i *streamedIterator) Err() error                 { return i.err }
+func (i *streamedIterator) Close() error               { return i.body.Close() }
+
+// xorIterators pools the chunk iterators, which don't outlive the decoding of a single series.
+var xorIterators = sync.Pool{
+	New: func() interface{} { return chunkenc.NewNopIterator() },
+}
+
+// chunkedSeriesToTimeSeries decodes the XOR chunks of the series. Chunks are expected in start time
+// order but might overlap, samples not after the previous one are dropped.
+func chunkedSeriesToTimeSeries(cs *prompb.ChunkedSeries) (prompb.TimeSeries, error) {
+	chks := make([]chunkenc.Chunk, 0, len(cs.Chunks))
+	n := 0
+	for _, c := range cs.Chunks {
+		if c.Type != prompb.Chunk_XOR {
+			return prompb.TimeSeries{}, errors.Errorf("unsupported chunk encoding %v", c.Type)
+		}
+		chk, err := chunkenc.FromData(chunkenc.EncXOR, c.Data)
+		if err != nil {
+			return prompb.TimeSeries{}, errors.Wrap(err, "decode chunk")
+		}
+		chks = append(chks, chk)
+		n += chk.NumSamples()
+	}
+
+	// The samples are allocated at once, as the number of samples is known from the chunk headers.
+	ts := prompb.TimeSeries{Labels: cs.Labels, Samples: make([]prompb.Sample, 0, n)}
+	it := xorIterators.Get().(chunkenc.Iterator)
+	defer func() { xorIterators.Put(it) }()
+	for _, chk := range chks {
+		it = chk.Iterator(it)
+		for it.Next() {
+			t, v := it.At()
+			if n := len(ts.Samples); n > 0 && t <= ts.Samples[n-1].Timestamp {
+				continue
+			}
+			ts.Samples = append(ts.Samples, prompb.Sample{Timestamp: t, Value: v})
+		}
+		if err := it.Err(); err != nil {
+			return prompb.TimeSeries{}, errors.Wrap(err, "iterate chunk")
+		}
+	}
+	return ts, nil
+}
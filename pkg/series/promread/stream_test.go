@@ -0,0 +1,143 @@
+package promread
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/storage/remote"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/thanos-community/obslytics/pkg/series"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+// xorChunk encodes the samples as the remote read XOR chunk.
+func xorChunk(t testing.TB, samples ...prompb.Sample) prompb.Chunk {
+	c := chunkenc.NewXORChunk()
+	a, err := c.Appender()
+	testutil.Ok(t, err)
+	for _, s := range samples {
+		a.Append(s.Timestamp, s.Value)
+	}
+	return prompb.Chunk{
+		MinTimeMs: samples[0].Timestamp,
+		MaxTimeMs: samples[len(samples)-1].Timestamp,
+		Type:      prompb.Chunk_XOR,
+		Data:      c.Bytes(),
+	}
+}
+
+func TestReadStreamed(t *testing.T) {
+	frames := []*prompb.ChunkedReadResponse{
+		{ChunkedSeries: []*prompb.ChunkedSeries{{
+			Labels: []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "a"}},
+			Chunks: []prompb.Chunk{
+				xorChunk(t, prompb.Sample{Timestamp: 1000, Value: 1}, prompb.Sample{Timestamp: 2000, Value: 2}),
+				// Overlapping chunk.
+				xorChunk(t, prompb.Sample{Timestamp: 2000, Value: 2}, prompb.Sample{Timestamp: 3000, Value: 3}),
+			},
+		}}},
+		{ChunkedSeries: []*prompb.ChunkedSeries{{
+			Labels: []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "b"}},
+			// Out of the requested range.
+			Chunks: []prompb.Chunk{xorChunk(t, prompb.Sample{Timestamp: 9000, Value: 9})},
+		}}},
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		testutil.Equals(t, "0.1.0", r.Header.Get("X-Prometheus-Remote-Read-Version"))
+		testutil.Equals(t, "backfill", r.Header.Get("Job"))
+
+		compressed, err := ioutil.ReadAll(r.Body)
+		testutil.Ok(t, err)
+		data, err := snappy.Decode(nil, compressed)
+		testutil.Ok(t, err)
+		var req prompb.ReadRequest
+		testutil.Ok(t, req.Unmarshal(data))
+		testutil.Equals(t, []prompb.ReadRequest_ResponseType{prompb.ReadRequest_STREAMED_XOR_CHUNKS}, req.AcceptedResponseTypes)
+
+		// Parameters formatted differently than by Prometheus.
+		w.Header().Set("Content-Type", `application/x-streamed-protobuf;proto="prometheus.ChunkedReadResponse"`)
+		cw := remote.NewChunkedWriter(w, w.(http.Flusher))
+		for _, f := range frames {
+			b, err := f.Marshal()
+			testutil.Ok(t, err)
+			_, err = cw.Write(b)
+			testutil.Ok(t, err)
+		}
+	}))
+	defer srv.Close()
+
+	s, err := NewSeries(log.NewNopLogger(), series.Config{
+		Endpoint:   srv.URL,
+		RemoteRead: series.RemoteReadConfig{Streamed: true},
+		Metadata:   map[string]string{"job": "backfill"},
+	})
+	testutil.Ok(t, err)
+
+	set, err := s.Read(context.Background(), series.Params{
+		Matchers: []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "__name__", "up")},
+		MinTime:  timestamp.Time(0),
+		MaxTime:  timestamp.Time(5000),
+	})
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, set.Close()) }()
+
+	testutil.Assert(t, set.Next())
+	testutil.Equals(t, labels.FromStrings("__name__", "up", "job", "a"), set.At().Labels())
+
+	var samples []prompb.Sample
+	it := set.At().Iterator()
+	for it.Next() {
+		ts, v := it.At()
+		samples = append(samples, prompb.Sample{Timestamp: ts, Value: v})
+	}
+	testutil.Equals(t, []prompb.Sample{{Timestamp: 1000, Value: 1}, {Timestamp: 2000, Value: 2}, {Timestamp: 3000, Value: 3}}, samples)
+
+	testutil.Assert(t, !set.Next())
+	testutil.Ok(t, set.Err())
+}
+
+func TestIsStreamedResponse(t *testing.T) {
+	testutil.Assert(t, isStreamedResponse("application/x-streamed-protobuf; proto=prometheus.ChunkedReadResponse"))
+	testutil.Assert(t, isStreamedResponse(`application/x-streamed-protobuf;proto="prometheus.ChunkedReadResponse"`))
+	testutil.Assert(t, !isStreamedResponse("application/x-protobuf"))
+	testutil.Assert(t, !isStreamedResponse(""))
+}
+
+func BenchmarkStreamedSeries(b *testing.B) {
+	lset := make([]prompb.Label, 0, 20)
+	for i := 0; i < cap(lset); i++ {
+		lset = append(lset, prompb.Label{Name: fmt.Sprintf("label-%02d", cap(lset)-i), Value: "something"})
+	}
+	var chunks []prompb.Chunk
+	for c := 0; c < 4; c++ {
+		samples := make([]prompb.Sample, 0, 120)
+		for s := 0; s < cap(samples); s++ {
+			samples = append(samples, prompb.Sample{Timestamp: int64(c*cap(samples)+s) * 15000, Value: float64(s)})
+		}
+		chunks = append(chunks, xorChunk(b, samples...))
+	}
+	cs := &prompb.ChunkedSeries{Labels: lset, Chunks: chunks}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ts, err := chunkedSeriesToTimeSeries(cs)
+		testutil.Ok(b, err)
+		s := newReadSeries(ts, math.MinInt64, math.MaxInt64, false)
+		// Labels are read by the counting, relabeling and aggregation stages.
+		for j := 0; j < 3; j++ {
+			_ = s.Labels()
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package series
+
+import (
+	"context"
+
+	"golang.org/x/time/rate"
+)
+
+// RateLimitConfig throttles the requests sent to the input endpoint, so that large backfills
+// don't overload shared infrastructure. Zero values disable the respective limit.
+type RateLimitConfig struct {
+	// RequestsPerSecond limits the rate of read requests (e.g. StoreAPI Series calls).
+	RequestsPerSecond float64 `yaml:"requests_per_second"`
+	// BytesPerSecond limits the rate of received series data.
+	BytesPerSecond int `yaml:"bytes_per_second"`
+}
+
+// Limiter applies RateLimitConfig. It is safe for concurrent use, a nil Limiter does not throttle.
+type Limiter struct {
+	requests *rate.Limiter
+	bytes    *rate.Limiter
+}
+
+// NewLimiter returns a Limiter for the given config, or nil if no limit is configured.
+func NewLimiter(cfg RateLimitConfig) *Limiter {
+	if cfg.RequestsPerSecond <= 0 && cfg.BytesPerSecond <= 0 {
+		return nil
+	}
+
+	l := &Limiter{}
+	if cfg.RequestsPerSecond > 0 {
+		l.requests = rate.NewLimiter(rate.Limit(cfg.RequestsPerSecond), 1)
+	}
+	if cfg.BytesPerSecond > 0 {
+		l.bytes = rate.NewLimiter(rate.Limit(cfg.BytesPerSecond), cfg.BytesPerSecond)
+	}
+	return l
+}
+
+// WaitRequest blocks until another request is allowed to be sent.
+func (l *Limiter) WaitRequest(ctx context.Context) error {
+	if l == nil || l.requests == nil {
+		return nil
+	}
+	return l.requests.Wait(ctx)
+}
+
+// WaitBytes blocks until n more bytes are allowed to be received.
+func (l *Limiter) WaitBytes(ctx context.Context, n int) error {
+	if l == nil || l.bytes == nil {
+		return nil
+	}
+	// Messages larger than the burst are accounted in burst-sized parts.
+	for burst := l.bytes.Burst(); n > 0; n -= burst {
+		part := n
+		if part > burst {
+			part = burst
+		}
+		if err := l.bytes.WaitN(ctx, part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package series
+
+import "github.com/prometheus/prometheus/tsdb/chunkenc"
+
+// SampleCounter is implemented by the iterators of series whose samples are aggregated from multiple raw samples, e.g.
+// of the downsampled data read as averages by the StoreAPI input, and by the iterators passing their samples through.
+type SampleCounter interface {
+	// SampleCount returns the number of raw samples the current sample was aggregated from.
+	SampleCount() uint64
+}
+
+// SampleCount returns the number of raw samples the current sample of the iterator was aggregated from, 1 unless the
+// iterator implements SampleCounter.
+func SampleCount(it chunkenc.Iterator) uint64 {
+	if c, ok := it.(SampleCounter); ok {
+		return c.SampleCount()
+	}
+	return 1
+}
+
+// Aggregate is the count, sum, min and max of the raw samples a downsampled sample was aggregated from.
+type Aggregate struct {
+	Count    uint64
+	Sum      float64
+	Min, Max float64
+}
+
+// AggregateIterator is implemented by the iterators of the downsampled data read with the aggregates of the raw
+// samples by the StoreAPI input, and by the iterators passing their samples through unchanged. The iterators changing
+// or merging the samples do not, their samples are aggregated as they are.
+type AggregateIterator interface {
+	// Aggregate returns the aggregate of the raw samples of the current sample, false if it is a raw sample.
+	Aggregate() (Aggregate, bool)
+}
+
+// SampleAggregate returns the aggregate of the raw samples of the current sample of the iterator, false if it is a raw
+// sample or the iterator does not implement AggregateIterator.
+func SampleAggregate(it chunkenc.Iterator) (Aggregate, bool) {
+	if a, ok := it.(AggregateIterator); ok {
+		return a.Aggregate()
+	}
+	return Aggregate{}, false
+}
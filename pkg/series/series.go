@@ -2,11 +2,17 @@ package series
 
 import (
 	"context"
+	"math"
 	"time"
 
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/timestamp"
 	"github.com/prometheus/prometheus/storage"
 	http_util "github.com/thanos-io/thanos/pkg/http"
+	"github.com/thanos-io/thanos/pkg/objstore/client"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
 )
 
 type Type string
@@ -14,20 +20,290 @@ type Type string
 const (
 	REMOTEREAD Type = "REMOTEREAD"
 	STOREAPI   Type = "STOREAPI"
+	// BUCKET reads the blocks directly from the object storage, without a store gateway.
+	BUCKET Type = "BUCKET"
+	// TEXTFILE reads the Prometheus or OpenMetrics text files, e.g. archived scrape dumps.
+	TEXTFILE Type = "TEXTFILE"
 )
 
 // Config contains the options determining the endpoint to talk to.
 type Config struct {
-	Endpoint  string              `yaml:"endpoint"`
-	TLSConfig http_util.TLSConfig `yaml:"tls_config"`
-	Type      Type                `yaml:"type"`
+	// Endpoint is the address of the input. STOREAPI endpoint can be a Unix domain socket, e.g.
+	// unix:///var/run/store.sock, for stores running alongside. The TLS options are optional for it as for TCP.
+	Endpoint  string          `yaml:"endpoint"`
+	TLSConfig TLSConfig       `yaml:"tls_config"`
+	Type      Type            `yaml:"type"`
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+	// RemoteRead contains the REMOTEREAD input specific options.
+	RemoteRead RemoteReadConfig `yaml:"remote_read"`
+	// Bucket contains the BUCKET input specific options.
+	Bucket BucketConfig `yaml:"bucket"`
+	// TextFile contains the TEXTFILE input specific options.
+	TextFile TextFileConfig `yaml:"text_file"`
+	// Metadata is sent with every request, e.g. job name or run ID allowing the endpoint operators to attribute
+	// the load. Sent as gRPC metadata by STOREAPI and as HTTP headers by REMOTEREAD input.
+	Metadata map[string]string `yaml:"metadata"`
+	// BearerToken authenticates the requests, as gRPC authorization metadata by STOREAPI and as HTTP Authorization
+	// header by REMOTEREAD input. BearerTokenFile is an alternative re-read on every request, so that short-lived
+	// tokens rotated in the file keep long extractions authenticated.
+	BearerToken     string `yaml:"bearer_token"`
+	BearerTokenFile string `yaml:"bearer_token_file"`
+	// OutOfOrder is the policy for samples out of timestamp order within a series: error (default), sort or drop.
+	OutOfOrder OutOfOrderPolicy `yaml:"out_of_order"`
+	// Duplicates is the policy for samples with equal timestamps within a series: keep-all (default), keep-first,
+	// keep-last, average or error.
+	Duplicates DuplicatesPolicy `yaml:"duplicates"`
+	// Coalesce merges the samples within a tiny window of a series into one, once the duplicates are handled. It is
+	// supported by the STOREAPI and REMOTEREAD inputs only.
+	Coalesce CoalesceConfig `yaml:"coalesce"`
+	// DuplicateLabels is the policy for label names repeated within the labels of a series: error (default),
+	// keep-first or keep-last.
+	DuplicateLabels DuplicateLabelsPolicy `yaml:"duplicate_labels"`
+	// Tenant scopes the STOREAPI reads to the series of a single tenant by their tenant label. Not supported by the
+	// other inputs.
+	Tenant TenantConfig `yaml:"tenant"`
+	// SortBuffer bounds the memory used by the sort out_of_order policy, spilling to temporary files.
+	SortBuffer SortBufferConfig `yaml:"sort_buffer"`
+	// SeriesBatch pages the STOREAPI series requests by label values.
+	SeriesBatch SeriesBatchConfig `yaml:"series_batch"`
+	// Reconnect reopens the STOREAPI series streams failed mid-stream, e.g. on flaky networks.
+	Reconnect ReconnectConfig `yaml:"reconnect"`
+	// StreamTimeout bounds the wait for every response of the STOREAPI Series calls, i.e. of every batch and every
+	// reconnect, independently of the context of the whole read, so that a hung store fails the call instead of
+	// blocking the read, while the streams receiving the series run as long as they need. No timeout if 0.
+	StreamTimeout model.Duration `yaml:"stream_timeout"`
+	// GRPCWeb calls the STOREAPI endpoint over gRPC-Web instead of the native gRPC, for the endpoints behind proxies
+	// forwarding only gRPC-Web. The endpoint is then the address of the proxy, called over HTTPS if the TLS options
+	// are set. Only the unary and server streaming calls are supported, which covers the reads, and the responses
+	// cannot be compressed.
+	GRPCWeb bool `yaml:"grpc_web"`
+	// Config is the type specific configuration of the inputs registered by factory.RegisterReader.
+	Config interface{} `yaml:"config"`
+}
+
+// ReconnectConfig configures reopening of the STOREAPI series stream failed with a recoverable error (unavailable,
+// aborted or internal, e.g. connection reset, or the stream_timeout exceeded), including the initial open. The rest of
+// the series received last is read by a request narrowed to its labels and to the time after its last chunk received,
+// then the call is reopened with the same request and the series received before are skipped, so the store reads them
+// again, while they are emitted once.
+type ReconnectConfig struct {
+	// MaxRetries is the number of reconnects of a stream. 0 (default) fails the read on the first error.
+	MaxRetries int `yaml:"max_retries"`
+	// MinBackoff is the wait before the first reconnect, doubled by every following one up to MaxBackoff. Defaults
+	// to 100ms and 10s.
+	MinBackoff model.Duration `yaml:"min_backoff"`
+	MaxBackoff model.Duration `yaml:"max_backoff"`
+}
+
+// RemoteReadConfig contains the remote read protocol options.
+type RemoteReadConfig struct {
+	// Streamed requests the streamed (chunked) remote read responses, as served by recent Prometheus versions
+	// or by VictoriaMetrics. Endpoints replying with the sampled response are still supported.
+	Streamed bool `yaml:"streamed"`
+	// ChunkedReadLimit is the maximum size of a single streamed frame in bytes. Defaults to 50MB when 0.
+	ChunkedReadLimit uint64 `yaml:"chunked_read_limit"`
+	// Compatibility adapts the reads to the remote read implementation of the endpoint: prometheus (default) or m3,
+	// for the M3 coordinator serving M3DB or Graphite data. The TLS and authorization options apply to both.
+	Compatibility RemoteReadCompatibility `yaml:"compatibility"`
+	// M3 contains the m3 compatibility specific options.
+	M3 M3RemoteReadConfig `yaml:"m3"`
+}
+
+// RemoteReadCompatibility is the remote read implementation of the REMOTEREAD endpoint.
+type RemoteReadCompatibility string
+
+const (
+	// RemoteReadPrometheus is the remote read as implemented by Prometheus. This is the default.
+	RemoteReadPrometheus RemoteReadCompatibility = "prometheus"
+	// RemoteReadM3 is the remote read of the M3 coordinator. It serves the sampled responses only and reports the
+	// results cut by its query limits in the M3-Results-Limited header, failing the read instead of exporting partial
+	// data.
+	RemoteReadM3 RemoteReadCompatibility = "m3"
+)
+
+// M3RemoteReadConfig contains the options of the reads from the M3 coordinator, sent as the M3 request headers.
+type M3RemoteReadConfig struct {
+	// MetricsType is the type of the M3DB namespace read, unaggregated or aggregated (M3-Metrics-Type header).
+	// Defaults to the coordinator choosing the namespaces by the range.
+	MetricsType string `yaml:"metrics_type"`
+	// StoragePolicy is the resolution and retention of the aggregated namespace read, e.g. 1m:40d
+	// (M3-Storage-Policy header). Required by the aggregated metrics type.
+	StoragePolicy string `yaml:"storage_policy"`
+}
+
+// Validate returns error if the remote read options are inconsistent.
+func (c RemoteReadConfig) Validate() error {
+	switch c.Compatibility {
+	case "", RemoteReadPrometheus:
+		if c.M3 != (M3RemoteReadConfig{}) {
+			return errors.New("remote_read m3 options require the m3 compatibility")
+		}
+		return nil
+	case RemoteReadM3:
+	default:
+		return errors.Errorf("unsupported remote_read compatibility %q, expected prometheus or m3", c.Compatibility)
+	}
+
+	if c.Streamed {
+		return errors.New("M3 coordinator does not serve the streamed remote read, disable streamed with the m3 compatibility")
+	}
+	switch c.M3.MetricsType {
+	case "", "unaggregated":
+		if c.M3.StoragePolicy != "" {
+			return errors.New("remote_read m3 storage_policy requires the aggregated metrics_type")
+		}
+	case "aggregated":
+		if c.M3.StoragePolicy == "" {
+			return errors.New("remote_read m3 aggregated metrics_type requires the storage_policy, e.g. 1m:40d")
+		}
+	default:
+		return errors.Errorf("unsupported remote_read m3 metrics_type %q, expected unaggregated or aggregated", c.M3.MetricsType)
+	}
+	return nil
+}
+
+// SeriesBatchConfig configures paging of the STOREAPI series. StoreAPI has no pagination of the Series stream, so the
+// values of the label are listed first (LabelValues) and the series are read by a Series request per batch of the
+// values. This bounds the size of every response stream, and the memory the store needs to serve it, at the cost of
+// an additional request and of the store evaluating the matchers for every batch. The series are sorted within a
+// batch only, unless batched by the metric name. Stores not implementing LabelValues are read in a single stream.
+type SeriesBatchConfig struct {
+	// Size is the number of label values per Series request. 0 (default) reads all the series in a single stream.
+	Size int `yaml:"size"`
+	// Label the series are batched by, the metric name (__name__) by default. The series without the label are read
+	// by the last request.
+	Label string `yaml:"label"`
+}
+
+// BucketConfig configures reading the blocks directly from the object storage.
+type BucketConfig struct {
+	// Storage is the object storage configuration of the blocks, same as of the Thanos components.
+	Storage client.BucketConfig `yaml:"storage"`
+	// CacheDir is the directory the downloaded blocks and their metadata are cached in, reused by the following
+	// reads. Defaults to obslytics-bucket in the temporary directory.
+	CacheDir string `yaml:"cache_dir"`
+	// MaxCacheBytes bounds the size of the blocks cached in CacheDir, 10GiB by default. The blocks are downloaded
+	// whole, the reads of blocks exceeding it fail, and the least recently read blocks not being read are evicted to
+	// make room for the downloaded ones.
+	MaxCacheBytes int64 `yaml:"max_cache_bytes"`
+	// Concurrency is the number of block metadata files fetched in parallel, 20 by default.
+	Concurrency int `yaml:"concurrency"`
+}
+
+// TextFileConfig configures reading the Prometheus or OpenMetrics text files.
+type TextFileConfig struct {
+	// Paths are the read files, glob patterns allowed, e.g. /archive/*.prom.gz. The files can be gzip compressed.
+	Paths []string `yaml:"paths"`
+}
+
+// TLSConfig extends the common TLS options with connection-level tuning.
+type TLSConfig struct {
+	http_util.TLSConfig `yaml:",inline"`
+
+	// NextProtos is the list of ALPN protocols to advertise, e.g. when connecting through a proxy.
+	NextProtos []string `yaml:"next_protos"`
+	// SessionCacheSize is the number of TLS sessions cached for resumption. Defaults to 64 when 0.
+	SessionCacheSize int `yaml:"session_cache_size"`
+
+	// PKCS12File is the PKCS#12 (.p12/.pfx) bundle with the client certificate and key, an alternative
+	// to cert_file and key_file. Supported by the STOREAPI input only.
+	PKCS12File     string `yaml:"pkcs12_file"`
+	PKCS12Password string `yaml:"pkcs12_password"`
+
+	// Renegotiation is the TLS renegotiation accepted from the server: never (default), once or freely. TLS 1.3
+	// has no renegotiation. Supported by the STOREAPI input only.
+	Renegotiation TLSRenegotiation `yaml:"renegotiation"`
 }
 
 // Params determines what data should be loaded from the input.
 type Params struct {
 	Matchers []*labels.Matcher
-	MinTime  time.Time
-	MaxTime  time.Time
+	// StoreMatchers are the StoreAPI matchers, an alternative to Matchers for the callers having them already, e.g.
+	// from other Thanos tooling. STOREAPI input sends them as given, the others convert them to the Prometheus
+	// matchers. Matchers and StoreMatchers cannot be used together.
+	StoreMatchers []storepb.LabelMatcher
+	// MinTime and MaxTime bound the samples read. Zero time, or time at the math.MinInt64 or math.MaxInt64
+	// milliseconds, leaves the range unbounded from that side ("all time").
+	MinTime time.Time
+	MaxTime time.Time
+
+	// Snapshot limits every series to its most recent sample at or before MaxTime.
+	Snapshot bool
+	// MaxResolution allows the input to serve data pre-aggregated (downsampled) server-side up to the
+	// given resolution. The input picks the coarsest resolution it can serve, inputs not supporting it return
+	// raw data. Zero means raw data only. The downsampled samples are the averages of the downsampling windows, with the
+	// number of raw samples they average given by SampleCount and their aggregate by SampleAggregate.
+	MaxResolution time.Duration
+	// SkipChunks hints the input that only labels of the series are needed.
+	SkipChunks bool
+
+	// MinTimeExclusive and MaxTimeExclusive exclude samples exactly at the MinTime and MaxTime
+	// boundaries. By default, both boundaries are inclusive.
+	MinTimeExclusive bool
+	MaxTimeExclusive bool
+}
+
+// Range returns the MinTime and MaxTime in milliseconds, math.MinInt64 and math.MaxInt64 for the unbounded sides.
+func (p Params) Range() (mint, maxt int64) {
+	mint, maxt = math.MinInt64, math.MaxInt64
+	if !p.MinTime.IsZero() {
+		mint = timestamp.FromTime(p.MinTime)
+	}
+	if !p.MaxTime.IsZero() {
+		maxt = timestamp.FromTime(p.MaxTime)
+	}
+	return mint, maxt
+}
+
+// Bounds returns the inclusive range of sample timestamps (in milliseconds) to be read.
+func (p Params) Bounds() (mint, maxt int64) {
+	mint, maxt = p.Range()
+	if p.MinTimeExclusive && mint != math.MinInt64 {
+		mint++
+	}
+	if p.MaxTimeExclusive && maxt != math.MaxInt64 {
+		maxt--
+	}
+	return mint, maxt
+}
+
+// PromMatchers returns the Matchers, or the StoreMatchers converted to the Prometheus matchers if given.
+func (p Params) PromMatchers() ([]*labels.Matcher, error) {
+	if len(p.StoreMatchers) == 0 {
+		return p.Matchers, nil
+	}
+	ms, err := storepb.MatchersToPromMatchers(p.StoreMatchers...)
+	if err != nil {
+		return nil, errors.Wrap(err, "convert store matchers")
+	}
+	return ms, nil
+}
+
+// Validate returns error if the time range is inverted or empty, as the inputs would silently return no data, or if
+// both kinds of the matchers are given.
+func (p Params) Validate() error {
+	if len(p.Matchers) > 0 && len(p.StoreMatchers) > 0 {
+		return errors.New("matchers and store matchers cannot be used together")
+	}
+	mint, maxt := p.Range()
+	if mint >= maxt {
+		return errors.Errorf("invalid time range: min time %s has to be before max time %s", formatTimestamp(mint), formatTimestamp(maxt))
+	}
+	if bmint, bmaxt := p.Bounds(); bmint > bmaxt {
+		return errors.Errorf("invalid time range: no timestamp between min time %s and max time %s, both exclusive",
+			formatTimestamp(mint), formatTimestamp(maxt))
+	}
+	return nil
+}
+
+func formatTimestamp(t int64) string {
+	switch t {
+	case math.MinInt64:
+		return "-inf"
+	case math.MaxInt64:
+		return "+inf"
+	}
+	return timestamp.Time(t).Format(time.RFC3339Nano)
 }
 
 type Reader interface {
@@ -0,0 +1,122 @@
+package series
+
+import (
+	"math"
+	"testing"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestParams_Bounds(t *testing.T) {
+	p := Params{MinTime: timestamp.Time(1000), MaxTime: timestamp.Time(2000)}
+
+	mint, maxt := p.Bounds()
+	testutil.Equals(t, int64(1000), mint)
+	testutil.Equals(t, int64(2000), maxt)
+
+	p.MinTimeExclusive, p.MaxTimeExclusive = true, true
+	mint, maxt = p.Bounds()
+	testutil.Equals(t, int64(1001), mint)
+	testutil.Equals(t, int64(1999), maxt)
+
+	// Unbounded sides are kept by the exclusive bounds.
+	for _, p := range []Params{
+		{},
+		{MinTime: timestamp.Time(math.MinInt64), MaxTime: timestamp.Time(math.MaxInt64)},
+		{MinTimeExclusive: true, MaxTimeExclusive: true},
+	} {
+		mint, maxt = p.Bounds()
+		testutil.Equals(t, int64(math.MinInt64), mint)
+		testutil.Equals(t, int64(math.MaxInt64), maxt)
+		testutil.Ok(t, p.Validate())
+	}
+	mint, maxt = Params{MaxTime: timestamp.Time(2000)}.Bounds()
+	testutil.Equals(t, int64(math.MinInt64), mint)
+	testutil.Equals(t, int64(2000), maxt)
+}
+
+func TestParams_Validate(t *testing.T) {
+	for _, c := range []struct {
+		p   Params
+		err string
+	}{
+		{p: Params{MinTime: timestamp.Time(1000), MaxTime: timestamp.Time(2000)}},
+		{p: Params{MinTime: timestamp.Time(1000), MaxTime: timestamp.Time(1002), MinTimeExclusive: true, MaxTimeExclusive: true}},
+		{p: Params{MinTime: timestamp.Time(1000)}},
+		{
+			p:   Params{MinTime: timestamp.Time(2000), MaxTime: timestamp.Time(1000)},
+			err: "invalid time range: min time 1970-01-01T00:00:02Z has to be before max time 1970-01-01T00:00:01Z",
+		},
+		{
+			p:   Params{MinTime: timestamp.Time(1000), MaxTime: timestamp.Time(1000)},
+			err: "invalid time range: min time 1970-01-01T00:00:01Z has to be before max time 1970-01-01T00:00:01Z",
+		},
+		{
+			p:   Params{MinTime: timestamp.Time(1000), MaxTime: timestamp.Time(1001), MinTimeExclusive: true, MaxTimeExclusive: true},
+			err: "invalid time range: no timestamp between min time 1970-01-01T00:00:01Z and max time 1970-01-01T00:00:01.001Z, both exclusive",
+		},
+		{
+			p: Params{
+				Matchers:      []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "job", "a")},
+				StoreMatchers: []storepb.LabelMatcher{{Type: storepb.LabelMatcher_EQ, Name: "job", Value: "a"}},
+				MinTime:       timestamp.Time(1000),
+			},
+			err: "matchers and store matchers cannot be used together",
+		},
+		{
+			p:   Params{MinTime: timestamp.Time(math.MaxInt64)},
+			err: "invalid time range: min time +inf has to be before max time +inf",
+		},
+	} {
+		err := c.p.Validate()
+		if c.err == "" {
+			testutil.Ok(t, err)
+			continue
+		}
+		testutil.NotOk(t, err)
+		testutil.Equals(t, c.err, err.Error())
+	}
+}
+
+func TestRemoteReadConfig_Validate(t *testing.T) {
+	for _, c := range []struct {
+		c   RemoteReadConfig
+		err string
+	}{
+		{c: RemoteReadConfig{Streamed: true}},
+		{c: RemoteReadConfig{Compatibility: RemoteReadM3}},
+		{c: RemoteReadConfig{Compatibility: RemoteReadM3, M3: M3RemoteReadConfig{MetricsType: "aggregated", StoragePolicy: "1m:40d"}}},
+		{c: RemoteReadConfig{Compatibility: "thanos"}, err: `unsupported remote_read compatibility "thanos", expected prometheus or m3`},
+		{c: RemoteReadConfig{M3: M3RemoteReadConfig{MetricsType: "aggregated"}}, err: "remote_read m3 options require the m3 compatibility"},
+		{
+			c:   RemoteReadConfig{Compatibility: RemoteReadM3, Streamed: true},
+			err: "M3 coordinator does not serve the streamed remote read, disable streamed with the m3 compatibility",
+		},
+		{
+			c:   RemoteReadConfig{Compatibility: RemoteReadM3, M3: M3RemoteReadConfig{MetricsType: "aggregated"}},
+			err: "remote_read m3 aggregated metrics_type requires the storage_policy, e.g. 1m:40d",
+		},
+		{
+			c:   RemoteReadConfig{Compatibility: RemoteReadM3, M3: M3RemoteReadConfig{StoragePolicy: "1m:40d"}},
+			err: "remote_read m3 storage_policy requires the aggregated metrics_type",
+		},
+	} {
+		err := c.c.Validate()
+		if c.err == "" {
+			testutil.Ok(t, err)
+			continue
+		}
+		testutil.NotOk(t, err)
+		testutil.Equals(t, c.err, err.Error())
+	}
+}
+
+func TestMatchersQuery(t *testing.T) {
+	testutil.Equals(t, `{__name__="up",job=~"prom.*"}`, MatchersQuery([]*labels.Matcher{
+		labels.MustNewMatcher(labels.MatchEqual, "__name__", "up"),
+		labels.MustNewMatcher(labels.MatchRegexp, "job", "prom.*"),
+	}))
+}
@@ -0,0 +1,301 @@
+package series
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+)
+
+// SortBufferConfig bounds the memory used by the sort out_of_order policy.
+type SortBufferConfig struct {
+	// MaxSamples is the number of samples of a series buffered in memory to be sorted. Once exceeded, the buffer is
+	// sorted and spilled to a temporary file, and the spilled runs are merged when the series is read. 0 (default)
+	// buffers all the samples of the series in memory.
+	MaxSamples int `yaml:"max_samples"`
+	// Dir is the directory of the temporary files, the system temporary directory by default.
+	Dir string `yaml:"dir"`
+}
+
+// sampleSize is the size of a spilled sample: timestamp, value bits and count, 8 bytes each.
+const sampleSize = 24
+
+// spillFiles tracks the temporary files of a set, so that the ones of iterators not read until the end are removed
+// when the set is closed.
+type spillFiles struct {
+	mtx   sync.Mutex
+	files map[*os.File]struct{}
+}
+
+func (s *spillFiles) create(dir string) (*os.File, error) {
+	f, err := ioutil.TempFile(dir, "obslytics-sort-")
+	if err != nil {
+		return nil, errors.Wrap(err, "create sort spill file")
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.files == nil {
+		s.files = map[*os.File]struct{}{}
+	}
+	s.files[f] = struct{}{}
+	return f, nil
+}
+
+// remove closes and removes the file, it's a no-op for files already removed.
+func (s *spillFiles) remove(f *os.File) error {
+	s.mtx.Lock()
+	_, ok := s.files[f]
+	delete(s.files, f)
+	s.mtx.Unlock()
+	if !ok {
+		return nil
+	}
+
+	err := f.Close()
+	if rerr := os.Remove(f.Name()); rerr != nil && err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// removeAll closes and removes all the files.
+func (s *spillFiles) removeAll() error {
+	s.mtx.Lock()
+	files := make([]*os.File, 0, len(s.files))
+	for f := range s.files {
+		files = append(files, f)
+	}
+	s.mtx.Unlock()
+
+	var err error
+	for _, f := range files {
+		if rerr := s.remove(f); rerr != nil && err == nil {
+			err = rerr
+		}
+	}
+	return err
+}
+
+// spillSorter sorts the samples of a series in bounded memory. Samples are buffered up to maxSamples, then the
+// buffer is sorted and appended to the temporary file as a run. Runs are merged by the iterator.
+type spillSorter struct {
+	files      *spillFiles
+	dir        string
+	maxSamples int
+
+	buf  sortableSamples
+	f    *os.File
+	w    *bufio.Writer
+	runs []spillRun
+	size int64
+}
+
+// spillRun is the section of the spill file with a sorted run of samples.
+type spillRun struct {
+	off, n int64
+}
+
+func newSpillSorter(files *spillFiles, conf SortBufferConfig) *spillSorter {
+	return &spillSorter{files: files, dir: conf.Dir, maxSamples: conf.MaxSamples}
+}
+
+func (s *spillSorter) add(smpl countedSample) error {
+	s.buf = append(s.buf, smpl)
+	if s.maxSamples <= 0 || len(s.buf) < s.maxSamples {
+		return nil
+	}
+	return s.spill()
+}
+
+// spill sorts the buffer and writes it as a run to the spill file.
+func (s *spillSorter) spill() error {
+	if s.f == nil {
+		f, err := s.files.create(s.dir)
+		if err != nil {
+			return err
+		}
+		s.f, s.w = f, bufio.NewWriter(f)
+	}
+
+	sort.Stable(s.buf)
+	var b [sampleSize]byte
+	for _, smpl := range s.buf {
+		binary.LittleEndian.PutUint64(b[:8], uint64(smpl.t))
+		binary.LittleEndian.PutUint64(b[8:16], math.Float64bits(smpl.v))
+		binary.LittleEndian.PutUint64(b[16:], smpl.count)
+		if _, err := s.w.Write(b[:]); err != nil {
+			return errors.Wrap(err, "write sort spill file")
+		}
+	}
+	s.runs = append(s.runs, spillRun{off: s.size, n: int64(len(s.buf))})
+	s.size += int64(len(s.buf)) * sampleSize
+	s.buf = s.buf[:0]
+	return nil
+}
+
+// close removes the spill file, if any.
+func (s *spillSorter) close() error {
+	if s.f == nil {
+		return nil
+	}
+	return s.files.remove(s.f)
+}
+
+// iterator returns the iterator over all the added samples in timestamp order. Samples with equal timestamps are
+// kept in the order they were added. The spill file is removed once the iterator is exhausted or fails.
+func (s *spillSorter) iterator() chunkenc.Iterator {
+	if s.f != nil {
+		if err := s.w.Flush(); err != nil {
+			_ = s.close()
+			return errIterator{err: errors.Wrap(err, "flush sort spill file")}
+		}
+	}
+
+	// The in-memory buffer is the last run, so that it is merged after the spilled samples with equal timestamps.
+	sort.Stable(s.buf)
+	runs := make([]*runReader, 0, len(s.runs)+1)
+	for i, r := range s.runs {
+		runs = append(runs, &runReader{
+			idx: i,
+			r:   bufio.NewReader(io.NewSectionReader(s.f, r.off, r.n*sampleSize)),
+			n:   r.n,
+		})
+	}
+	runs = append(runs, &runReader{idx: len(s.runs), mem: s.buf, n: int64(len(s.buf))})
+
+	it := &mergeIterator{sorter: s}
+	for _, r := range runs {
+		if !r.next() {
+			if r.err != nil {
+				_ = s.close()
+				return errIterator{err: r.err}
+			}
+			continue
+		}
+		it.heap = append(it.heap, r)
+	}
+	heap.Init(&it.heap)
+	return it
+}
+
+// runReader reads a sorted run from the spill file or from memory.
+type runReader struct {
+	idx int
+	r   *bufio.Reader
+	mem sortableSamples
+	n   int64
+
+	cur countedSample
+	err error
+}
+
+func (r *runReader) next() bool {
+	if r.n == 0 {
+		return false
+	}
+	r.n--
+	if r.r == nil {
+		r.cur, r.mem = r.mem[0], r.mem[1:]
+		return true
+	}
+
+	var b [sampleSize]byte
+	if _, err := io.ReadFull(r.r, b[:]); err != nil {
+		r.err = errors.Wrap(err, "read sort spill file")
+		return false
+	}
+	r.cur = countedSample{
+		sample: sample{
+			t: int64(binary.LittleEndian.Uint64(b[:8])),
+			v: math.Float64frombits(binary.LittleEndian.Uint64(b[8:16])),
+		},
+		count: binary.LittleEndian.Uint64(b[16:]),
+	}
+	return true
+}
+
+// runHeap orders the runs by their current sample, runs added earlier first for equal timestamps.
+type runHeap []*runReader
+
+func (h runHeap) Len() int { return len(h) }
+func (h runHeap) Less(i, j int) bool {
+	if h[i].cur.t != h[j].cur.t {
+		return h[i].cur.t < h[j].cur.t
+	}
+	return h[i].idx < h[j].idx
+}
+func (h runHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *runHeap) Push(x interface{}) { *h = append(*h, x.(*runReader)) }
+func (h *runHeap) Pop() interface{} {
+	old := *h
+	r := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return r
+}
+
+// mergeIterator merges the sorted runs of the spill sorter, the in-memory buffer being the only run if nothing was
+// spilled.
+type mergeIterator struct {
+	sorter *spillSorter
+	heap   runHeap
+
+	cur           countedSample
+	started, done bool
+	err           error
+}
+
+func (it *mergeIterator) Next() bool {
+	if it.done {
+		return false
+	}
+	if len(it.heap) == 0 {
+		it.finish(nil)
+		return false
+	}
+
+	r := it.heap[0]
+	it.cur, it.started = r.cur, true
+	if r.next() {
+		heap.Fix(&it.heap, 0)
+	} else {
+		if r.err != nil {
+			it.finish(r.err)
+			return false
+		}
+		heap.Pop(&it.heap)
+	}
+	return true
+}
+
+// finish removes the spill file once the samples are read or the read failed.
+func (it *mergeIterator) finish(err error) {
+	it.done = true
+	it.err = err
+	if cerr := it.sorter.close(); cerr != nil && it.err == nil {
+		it.err = errors.Wrap(cerr, "remove sort spill file")
+	}
+}
+
+func (it *mergeIterator) Seek(t int64) bool {
+	if it.started && !it.done && it.cur.t >= t {
+		return true
+	}
+	for it.Next() {
+		if it.cur.t >= t {
+			return true
+		}
+	}
+	return false
+}
+
+func (it *mergeIterator) At() (int64, float64) { return it.cur.t, it.cur.v }
+func (it *mergeIterator) SampleCount() uint64  { return it.cur.count }
+func (it *mergeIterator) Err() error           { return it.err }
@@ -0,0 +1,120 @@
+package series
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/prometheus/tsdb/tsdbutil"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestOrderedSet_SortSpill(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sort-spill")
+	testutil.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	// Values tell the read order apart for equal timestamps.
+	rnd := rand.New(rand.NewSource(1))
+	var samples []tsdbutil.Sample
+	for i := 0; i < 1000; i++ {
+		samples = append(samples, sample{t: rnd.Int63n(300), v: float64(i)})
+	}
+	newSet := func(conf SortBufferConfig) Set {
+		return NewOrderedSet(log.NewNopLogger(), &listSet{i: -1, series: []storage.Series{
+			storage.NewListSeries(labels.FromStrings("a", "b"), samples),
+		}}, OutOfOrderSort, "", conf)
+	}
+	read := func(s Set) []sample {
+		testutil.Assert(t, s.Next())
+		var got []sample
+		it := s.At().Iterator()
+		for it.Next() {
+			ts, v := it.At()
+			got = append(got, sample{t: ts, v: v})
+		}
+		testutil.Ok(t, it.Err())
+		return got
+	}
+	spilled := func() []string {
+		files, err := filepath.Glob(filepath.Join(dir, "*"))
+		testutil.Ok(t, err)
+		return files
+	}
+
+	exp := read(newSet(SortBufferConfig{}))
+	testutil.Equals(t, 1000, len(exp))
+
+	// Spilled runs are merged into the same (stable) order, the file is removed once read.
+	for _, max := range []int{1, 7, 100, 999, 1000} {
+		s := newSet(SortBufferConfig{MaxSamples: max, Dir: dir})
+		testutil.Equals(t, exp, read(s))
+		testutil.Equals(t, 0, len(spilled()))
+		testutil.Ok(t, s.Close())
+	}
+
+	// Seek within the merged runs.
+	s := newSet(SortBufferConfig{MaxSamples: 100, Dir: dir})
+	testutil.Assert(t, s.Next())
+	it := s.At().Iterator()
+	testutil.Assert(t, it.Seek(150))
+	ts, _ := it.At()
+	testutil.Assert(t, ts >= 150, "seek to 150 returned %d", ts)
+	testutil.Assert(t, it.Seek(0))
+	testutil.Equals(t, 1, len(spilled()))
+
+	// The files of the iterators not read until the end are removed by the set close.
+	testutil.Ok(t, s.Close())
+	testutil.Equals(t, 0, len(spilled()))
+
+	// Failure to spill fails the iterator.
+	s = newSet(SortBufferConfig{MaxSamples: 100, Dir: filepath.Join(dir, "missing")})
+	testutil.Assert(t, s.Next())
+	it = s.At().Iterator()
+	testutil.Assert(t, !it.Next())
+	testutil.NotOk(t, it.Err())
+	testutil.Ok(t, s.Close())
+}
+
+// countedSeries counts each sample as aggregated from as many raw samples as its value.
+type countedSeries struct{ storage.Series }
+
+func (s countedSeries) Iterator() chunkenc.Iterator { return countedIterator{s.Series.Iterator()} }
+
+type countedIterator struct{ chunkenc.Iterator }
+
+func (it countedIterator) SampleCount() uint64 {
+	_, v := it.At()
+	return uint64(v)
+}
+
+func TestOrderedSet_SortSampleCount(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sort-spill")
+	testutil.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	// The sorted samples keep their counts, both in memory and through the spilled runs.
+	for _, max := range []int{0, 1, 2, 100} {
+		s := NewOrderedSet(log.NewNopLogger(), &listSet{i: -1, series: []storage.Series{
+			countedSeries{storage.NewListSeries(labels.FromStrings("a", "b"), []tsdbutil.Sample{
+				sample{t: 30, v: 3}, sample{t: 10, v: 1}, sample{t: 40, v: 4}, sample{t: 20, v: 2},
+			})},
+		}}, OutOfOrderSort, "", SortBufferConfig{MaxSamples: max, Dir: dir})
+		testutil.Assert(t, s.Next())
+
+		var counts []uint64
+		it := s.At().Iterator()
+		for it.Next() {
+			counts = append(counts, SampleCount(it))
+		}
+		testutil.Ok(t, it.Err())
+		testutil.Equals(t, []uint64{1, 2, 3, 4}, counts)
+		testutil.Ok(t, s.Close())
+	}
+}
@@ -0,0 +1,138 @@
+package storeapi
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// TokenSource returns the bearer token of a StoreAPI request. It's invoked on every RPC, so that short-lived tokens
+// are rotated without restarting long extractions.
+type TokenSource func(ctx context.Context) (string, error)
+
+// WithTokenSource authenticates the requests with the bearer token of the source, instead of the configured
+// bearer_token or bearer_token_file.
+func WithTokenSource(source TokenSource) Option {
+	return func(s *Series) {
+		s.tokens = source
+	}
+}
+
+// fileTokenSource re-reads the token file on every call, e.g. as rotated by a sidecar or a projected volume.
+func fileTokenSource(file string) TokenSource {
+	return func(context.Context) (string, error) {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", errors.Wrap(err, "read bearer token file")
+		}
+		return strings.TrimSpace(string(b)), nil
+	}
+}
+
+// tokenCredentials implements credentials.PerRPCCredentials with the token of the source.
+type tokenCredentials struct {
+	source TokenSource
+}
+
+func (c tokenCredentials) GetRequestMetadata(ctx context.Context, _ ...string) (map[string]string, error) {
+	token, err := c.source(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"authorization": "Bearer " + token}, nil
+}
+
+// RequireTransportSecurity allows the token over plaintext connections, e.g. to a local proxy terminating TLS.
+func (c tokenCredentials) RequireTransportSecurity() bool { return false }
+
+// tokenDialOptions authenticate the calls with the token of the source. The calls rejected as Unauthenticated, e.g.
+// having the token expired right before the request, are retried once with the token read again. Streams are retried
+// only until their first response, so the requests are never repeated after data has been received.
+func tokenDialOptions(logger log.Logger, source TokenSource) []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithPerRPCCredentials(tokenCredentials{source: source}),
+		grpc.WithChainUnaryInterceptor(func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			err := invoker(ctx, method, req, reply, cc, opts...)
+			if status.Code(err) != codes.Unauthenticated {
+				return err
+			}
+			level.Debug(logger).Log("msg", "call unauthenticated, retrying with refreshed token", "method", method, "err", err)
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}),
+		grpc.WithChainStreamInterceptor(func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			open := func() (grpc.ClientStream, error) { return streamer(ctx, desc, cc, method, opts...) }
+			cs, err := open()
+			if status.Code(err) == codes.Unauthenticated {
+				level.Debug(logger).Log("msg", "stream unauthenticated, retrying with refreshed token", "method", method, "err", err)
+				cs, err = open()
+			}
+			if err != nil {
+				return nil, err
+			}
+			return &retryStream{ClientStream: cs, logger: logger, method: method, open: open}, nil
+		}),
+	}
+}
+
+// retryStream re-opens the stream rejected as Unauthenticated before its first response, replaying the sent messages.
+type retryStream struct {
+	grpc.ClientStream
+
+	logger log.Logger
+	method string
+	open   func() (grpc.ClientStream, error)
+
+	sent     []interface{}
+	closed   bool
+	received bool
+	retried  bool
+}
+
+func (s *retryStream) SendMsg(m interface{}) error {
+	if !s.received {
+		s.sent = append(s.sent, m)
+	}
+	return s.ClientStream.SendMsg(m)
+}
+
+func (s *retryStream) CloseSend() error {
+	s.closed = true
+	return s.ClientStream.CloseSend()
+}
+
+func (s *retryStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err == nil {
+		s.received, s.sent = true, nil
+		return nil
+	}
+	if s.received || s.retried || status.Code(err) != codes.Unauthenticated {
+		return err
+	}
+	s.retried = true
+	level.Debug(s.logger).Log("msg", "stream unauthenticated, retrying with refreshed token", "method", s.method, "err", err)
+
+	cs, rerr := s.open()
+	if rerr != nil {
+		return rerr
+	}
+	for _, sent := range s.sent {
+		if rerr := cs.SendMsg(sent); rerr != nil {
+			return rerr
+		}
+	}
+	if s.closed {
+		if rerr := cs.CloseSend(); rerr != nil {
+			return rerr
+		}
+	}
+	s.ClientStream = cs
+	return s.RecvMsg(m)
+}
@@ -0,0 +1,97 @@
+package storeapi
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/thanos-community/obslytics/pkg/series"
+	"github.com/thanos-io/thanos/pkg/store/labelpb"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"github.com/thanos-io/thanos/pkg/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestSeries_BearerToken(t *testing.T) {
+	valid := "fresh"
+	var tokens []string
+	srv := grpc.NewServer(grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		md, _ := metadata.FromIncomingContext(ss.Context())
+		tokens = append(tokens, md.Get("authorization")...)
+		if got := md.Get("authorization"); len(got) != 1 || got[0] != "Bearer "+valid {
+			return status.Error(codes.Unauthenticated, "invalid token")
+		}
+		return handler(srv, ss)
+	}))
+	storepb.RegisterStoreServer(srv, &seriesStoreServer{series: []*storepb.Series{{
+		Labels: []labelpb.ZLabel{{Name: "__name__", Value: "up"}},
+	}}})
+
+	l, err := net.Listen("tcp", "localhost:0")
+	testutil.Ok(t, err)
+	go func() { _ = srv.Serve(l) }()
+	defer srv.Stop()
+
+	read := func(s Series) error {
+		set, err := s.Read(context.Background(), series.Params{MinTime: time.Unix(0, 0), MaxTime: time.Unix(60, 0)})
+		testutil.Ok(t, err)
+		defer func() { testutil.Ok(t, set.Close()) }()
+		n := 0
+		for set.Next() {
+			n++
+		}
+		if set.Err() != nil {
+			return set.Err()
+		}
+		testutil.Equals(t, 1, n)
+		return nil
+	}
+
+	dir, err := ioutil.TempDir("", "token")
+	testutil.Ok(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "token")
+	testutil.Ok(t, ioutil.WriteFile(file, []byte("fresh\n"), 0600))
+
+	s, err := NewSeries(log.NewNopLogger(), series.Config{Endpoint: l.Addr().String(), BearerTokenFile: file})
+	testutil.Ok(t, err)
+	testutil.Ok(t, read(s))
+	testutil.Equals(t, []string{"Bearer fresh"}, tokens)
+
+	// Rotated token is read by the following request.
+	valid, tokens = "rotated", nil
+	testutil.Ok(t, ioutil.WriteFile(file, []byte("rotated"), 0600))
+	testutil.Ok(t, read(s))
+	testutil.Equals(t, []string{"Bearer rotated"}, tokens)
+
+	// Expired token is refreshed and the stream retried once.
+	valid, tokens = "fresh", nil
+	source := []string{"stale", "fresh"}
+	s, err = NewSeries(log.NewNopLogger(), series.Config{Endpoint: l.Addr().String()}, WithTokenSource(func(context.Context) (string, error) {
+		token := source[0]
+		if len(source) > 1 {
+			source = source[1:]
+		}
+		return token, nil
+	}))
+	testutil.Ok(t, err)
+	testutil.Ok(t, read(s))
+	testutil.Equals(t, []string{"Bearer stale", "Bearer fresh"}, tokens)
+
+	// Not retried more than once.
+	valid, tokens = "other", nil
+	err = read(s)
+	testutil.Equals(t, codes.Unauthenticated, status.Code(err))
+	testutil.Equals(t, []string{"Bearer fresh", "Bearer fresh"}, tokens)
+
+	_, err = NewSeries(log.NewNopLogger(), series.Config{BearerToken: "a", BearerTokenFile: file})
+	testutil.NotOk(t, err)
+}
@@ -0,0 +1,121 @@
+package storeapi
+
+import (
+	"context"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// seriesStream is the stream of the Series responses read by the iterator.
+type seriesStream interface {
+	Recv() (*storepb.SeriesResponse, error)
+	CloseSend() error
+}
+
+// batchMatchers returns the extra matchers of every Series request paging the series by the values of the batch
+// label, listed by the LabelValues call with the request matchers and time range. The last batch selects the series
+// without the label, if any. Returns nil if the store does not implement LabelValues, so that the series are read in a
+// single stream.
+func (i Series) batchMatchers(ctx context.Context, client storepb.StoreClient, req *storepb.SeriesRequest) ([][]storepb.LabelMatcher, error) {
+	conf := i.conf.SeriesBatch
+	label := conf.Label
+	if label == "" {
+		label = labels.MetricName
+	}
+
+	if err := i.limiter.WaitRequest(ctx); err != nil {
+		return nil, err
+	}
+	resp, err := client.LabelValues(ctx, &storepb.LabelValuesRequest{
+		Label:                   label,
+		Start:                   req.MinTime,
+		End:                     req.MaxTime,
+		Matchers:                req.Matchers,
+		PartialResponseStrategy: storepb.PartialResponseStrategy_ABORT,
+	})
+	if status.Code(err) == codes.Unimplemented {
+		level.Warn(i.logger).Log("msg", "store does not support LabelValues, reading series in a single stream", "endpoint", i.conf.Endpoint)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "storepb.LabelValues against %v", i.conf.Endpoint)
+	}
+	for _, w := range resp.Warnings {
+		level.Warn(i.logger).Log("msg", "label values warning", "warning", w)
+	}
+
+	// Sorted values keep the series sorted across the batches by the metric name.
+	values := append([]string(nil), resp.Values...)
+	sort.Strings(values)
+
+	var batches [][]storepb.LabelMatcher
+	for len(values) > 0 {
+		n := conf.Size
+		if n > len(values) {
+			n = len(values)
+		}
+		quoted := make([]string, 0, n)
+		for _, v := range values[:n] {
+			quoted = append(quoted, regexp.QuoteMeta(v))
+		}
+		values = values[n:]
+		batches = append(batches, []storepb.LabelMatcher{{Type: storepb.LabelMatcher_RE, Name: label, Value: strings.Join(quoted, "|")}})
+	}
+	if label != labels.MetricName {
+		// Empty value matches the series without the label.
+		batches = append(batches, []storepb.LabelMatcher{{Type: storepb.LabelMatcher_EQ, Name: label, Value: ""}})
+	}
+	level.Debug(i.logger).Log("msg", "reading series in batches", "label", label, "batches", len(batches))
+	return batches, nil
+}
+
+// batchedStream reads the series of the batches one Series request after another.
+type batchedStream struct {
+	open    func(*storepb.SeriesRequest) (seriesStream, error)
+	req     storepb.SeriesRequest
+	batches [][]storepb.LabelMatcher
+
+	cur seriesStream
+}
+
+func (s *batchedStream) Recv() (*storepb.SeriesResponse, error) {
+	for {
+		if s.cur == nil {
+			if len(s.batches) == 0 {
+				return nil, io.EOF
+			}
+			req := s.req
+			req.Matchers = append(append([]storepb.LabelMatcher(nil), s.req.Matchers...), s.batches[0]...)
+			s.batches = s.batches[1:]
+
+			cur, err := s.open(&req)
+			if err != nil {
+				return nil, errors.Wrap(err, "storepb.Series batch")
+			}
+			s.cur = cur
+		}
+
+		resp, err := s.cur.Recv()
+		if err == io.EOF {
+			s.cur = nil
+			continue
+		}
+		return resp, err
+	}
+}
+
+func (s *batchedStream) CloseSend() error {
+	if s.cur == nil {
+		return nil
+	}
+	return s.cur.CloseSend()
+}
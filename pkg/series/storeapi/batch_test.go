@@ -0,0 +1,129 @@
+package storeapi
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/thanos-community/obslytics/pkg/series"
+	"github.com/thanos-io/thanos/pkg/store/labelpb"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"github.com/thanos-io/thanos/pkg/testutil"
+	"google.golang.org/grpc"
+)
+
+// batchStoreServer serves the series matching the request matchers and records the requests.
+type batchStoreServer struct {
+	*storepb.UnimplementedStoreServer
+
+	series      []labels.Labels
+	chunk       storepb.AggrChunk
+	labelValues bool
+	requests    []string
+}
+
+func (s *batchStoreServer) LabelValues(ctx context.Context, req *storepb.LabelValuesRequest) (*storepb.LabelValuesResponse, error) {
+	if !s.labelValues {
+		return s.UnimplementedStoreServer.LabelValues(ctx, req)
+	}
+	seen := map[string]struct{}{}
+	resp := &storepb.LabelValuesResponse{}
+	for _, ls := range s.series {
+		if v := ls.Get(req.Label); v != "" {
+			if _, ok := seen[v]; !ok {
+				seen[v] = struct{}{}
+				resp.Values = append(resp.Values, v)
+			}
+		}
+	}
+	return resp, nil
+}
+
+func (s *batchStoreServer) Series(req *storepb.SeriesRequest, srv storepb.Store_SeriesServer) error {
+	s.requests = append(s.requests, storepb.MatchersToString(req.Matchers...))
+	ms, err := storepb.MatchersToPromMatchers(req.Matchers...)
+	if err != nil {
+		return err
+	}
+	for _, ls := range s.series {
+		matches := true
+		for _, m := range ms {
+			matches = matches && m.Matches(ls.Get(m.Name))
+		}
+		if !matches {
+			continue
+		}
+		if err := srv.Send(storepb.NewSeriesResponse(&storepb.Series{
+			Labels: labelpb.ZLabelsFromPromLabels(ls),
+			Chunks: []storepb.AggrChunk{s.chunk},
+		})); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestSeries_Batch(t *testing.T) {
+	store := &batchStoreServer{series: []labels.Labels{
+		labels.FromStrings("__name__", "up", "instance", "a"),
+		labels.FromStrings("__name__", "up", "instance", "b.c"),
+		labels.FromStrings("__name__", "up", "instance", "c"),
+		labels.FromStrings("__name__", "up"),
+		labels.FromStrings("__name__", "down", "instance", "a"),
+	}, chunk: rawChunk(t, sample{10, 1})}
+	srv := grpc.NewServer()
+	storepb.RegisterStoreServer(srv, store)
+
+	l, err := net.Listen("tcp", "localhost:0")
+	testutil.Ok(t, err)
+	go func() { _ = srv.Serve(l) }()
+	defer srv.Stop()
+
+	read := func(batch series.SeriesBatchConfig) []string {
+		store.requests = nil
+		s, err := NewSeries(log.NewNopLogger(), series.Config{Endpoint: l.Addr().String(), SeriesBatch: batch})
+		testutil.Ok(t, err)
+		set, err := s.Read(context.Background(), series.Params{
+			Matchers: []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "__name__", "up")},
+			MinTime:  time.Unix(0, 0),
+			MaxTime:  time.Unix(60, 0),
+		})
+		testutil.Ok(t, err)
+		defer func() { testutil.Ok(t, set.Close()) }()
+
+		var got []string
+		for set.Next() {
+			got = append(got, set.At().Labels().String())
+		}
+		testutil.Ok(t, set.Err())
+		return got
+	}
+
+	all := read(series.SeriesBatchConfig{})
+	testutil.Equals(t, 4, len(all))
+	testutil.Equals(t, []string{`{__name__="up"}`}, store.requests)
+
+	// Not supported by the store, read in a single stream.
+	testutil.Equals(t, all, read(series.SeriesBatchConfig{Size: 2, Label: "instance"}))
+	testutil.Equals(t, []string{`{__name__="up"}`}, store.requests)
+
+	store.labelValues = true
+	testutil.Equals(t, []string{
+		`{__name__="up", instance="a"}`,
+		`{__name__="up", instance="b.c"}`,
+		`{__name__="up", instance="c"}`,
+		`{__name__="up"}`,
+	}, read(series.SeriesBatchConfig{Size: 2, Label: "instance"}))
+	testutil.Equals(t, []string{
+		`{__name__="up", instance=~"a|b\\.c"}`,
+		`{__name__="up", instance=~"c"}`,
+		`{__name__="up", instance=""}`,
+	}, store.requests)
+
+	// Batched by the metric name by default.
+	testutil.Equals(t, all, read(series.SeriesBatchConfig{Size: 1}))
+	testutil.Equals(t, []string{`{__name__="up", __name__=~"down"}`, `{__name__="up", __name__=~"up"}`}, store.requests)
+}
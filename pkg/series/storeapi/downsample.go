@@ -0,0 +1,54 @@
+package storeapi
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/thanos-io/thanos/pkg/compact/downsample"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// downsampleResolutions are the resolutions of the blocks downsampled by the Thanos compactor in milliseconds,
+// coarsest first.
+var downsampleResolutions = []int64{downsample.ResLevel2, downsample.ResLevel1}
+
+// downsampleResolution returns the resolution window to request the data downsampled to, the coarsest resolution of
+// the compactor not coarser than the max resolution, or 0 to request raw data. The Info of this StoreAPI version does
+// not advertise the resolutions of the store, so the store type tells whether the store can serve downsampled data at
+// all: only the object storage (store gateways, and queriers in front of them) holds downsampled blocks, sidecars,
+// rulers and receivers serve raw data only. Stores not implementing Info are read raw.
+func (i Series) downsampleResolution(ctx context.Context, client storepb.StoreClient, maxResolution time.Duration) (int64, error) {
+	res := int64(0)
+	for _, r := range downsampleResolutions {
+		if r <= maxResolution.Milliseconds() {
+			res = r
+			break
+		}
+	}
+	if res == 0 {
+		return 0, nil
+	}
+
+	if err := i.limiter.WaitRequest(ctx); err != nil {
+		return 0, err
+	}
+	info, err := client.Info(ctx, &storepb.InfoRequest{})
+	if status.Code(err) == codes.Unimplemented {
+		level.Warn(i.logger).Log("msg", "store does not support Info, reading raw data", "endpoint", i.conf.Endpoint)
+		return 0, nil
+	}
+	if err != nil {
+		return 0, errors.Wrapf(err, "storepb.Info against %v", i.conf.Endpoint)
+	}
+	switch info.StoreType {
+	case storepb.StoreType_SIDECAR, storepb.StoreType_RULE, storepb.StoreType_RECEIVE:
+		level.Debug(i.logger).Log("msg", "store serves raw data only", "endpoint", i.conf.Endpoint, "type", info.StoreType)
+		return 0, nil
+	}
+	level.Debug(i.logger).Log("msg", "reading downsampled data", "endpoint", i.conf.Endpoint, "resolution", time.Duration(res)*time.Millisecond)
+	return res, nil
+}
@@ -0,0 +1,103 @@
+package storeapi
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/thanos-community/obslytics/pkg/dataframe"
+	"github.com/thanos-community/obslytics/pkg/series"
+	"github.com/thanos-community/obslytics/pkg/series/storeapi/storetest"
+	"github.com/thanos-io/thanos/pkg/store/labelpb"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestSeries_DownsampleResolution(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		storeType     storepb.StoreType
+		maxResolution time.Duration
+		expected      int64
+	}{
+		{name: "raw", storeType: storepb.StoreType_STORE},
+		{name: "finer than the downsampling levels", storeType: storepb.StoreType_STORE, maxResolution: time.Minute},
+		{name: "5m", storeType: storepb.StoreType_STORE, maxResolution: 30 * time.Minute, expected: 5 * 60 * 1000},
+		{name: "1h", storeType: storepb.StoreType_QUERY, maxResolution: 24 * time.Hour, expected: 60 * 60 * 1000},
+		{name: "sidecar", storeType: storepb.StoreType_SIDECAR, maxResolution: 24 * time.Hour},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			store := &storetest.Store{
+				Fixtures:  []*storepb.Series{storetest.NewSeries(labels.FromStrings("__name__", "up"), storetest.Sample{T: 0, V: 1})},
+				StoreType: tc.storeType,
+			}
+			in, err := NewSeries(log.NewNopLogger(), series.Config{Endpoint: storetest.Serve(t, store)})
+			testutil.Ok(t, err)
+
+			set, err := in.Read(context.Background(), series.Params{
+				MinTime:       time.Unix(0, 0),
+				MaxTime:       time.Unix(60, 0),
+				MaxResolution: tc.maxResolution,
+			})
+			testutil.Ok(t, err)
+			n := 0
+			for set.Next() {
+				n++
+			}
+			testutil.Ok(t, set.Err())
+			testutil.Ok(t, set.Close())
+			testutil.Equals(t, 1, n)
+
+			reqs := store.Requests()
+			testutil.Equals(t, 1, len(reqs))
+			testutil.Equals(t, tc.expected, reqs[0].MaxResolutionWindow)
+			if tc.expected > 0 {
+				testutil.Equals(t, []storepb.Aggr{storepb.Aggr_COUNT, storepb.Aggr_SUM, storepb.Aggr_MIN, storepb.Aggr_MAX}, reqs[0].Aggregates)
+			} else {
+				testutil.Equals(t, 0, len(reqs[0].Aggregates))
+			}
+		})
+	}
+}
+
+func TestSeries_DownsampledAggregations(t *testing.T) {
+	// Two 5m aggregates of 4 and 6 raw samples, summing to 8 and 30, between 1 and 3 and between 2 and 9.
+	store := &storetest.Store{Fixtures: []*storepb.Series{{
+		Labels: []labelpb.ZLabel{{Name: "__name__", Value: "up"}},
+		Chunks: []storepb.AggrChunk{{
+			MinTime: 30, MaxTime: 40,
+			Count: xorChunk(t, sample{30, 4}, sample{40, 6}),
+			Sum:   xorChunk(t, sample{30, 8}, sample{40, 30}),
+			Min:   xorChunk(t, sample{30, 1}, sample{40, 2}),
+			Max:   xorChunk(t, sample{30, 3}, sample{40, 9}),
+		}},
+	}}}
+	in, err := NewSeries(log.NewNopLogger(), series.Config{Endpoint: storetest.Serve(t, store)})
+	testutil.Ok(t, err)
+	set, err := in.Read(context.Background(), series.Params{MinTime: time.Unix(0, 0), MaxTime: time.Unix(60, 0), MaxResolution: time.Hour})
+	testutil.Ok(t, err)
+
+	df, err := dataframe.FromSeries(set, time.Minute, func(o *dataframe.AggrsOptions) {
+		o.Count.Enabled = true
+		o.Sum.Enabled = true
+		o.Min.Enabled = true
+		o.Max.Enabled = true
+		o.SampleCount.Enabled = true
+	})
+	testutil.Ok(t, err)
+
+	it := df.RowsIterator()
+	testutil.Assert(t, it.Next())
+	got := map[string]interface{}{}
+	for i, c := range df.Schema() {
+		got[c.Name] = it.At()[i]
+	}
+	// The aggregations are of the raw samples, read from the aggregates rather than from the averages 2 and 5.
+	testutil.Equals(t, uint64(10), got["_count"])
+	testutil.Equals(t, 38.0, got["_sum"])
+	testutil.Equals(t, 1.0, got["_min"])
+	testutil.Equals(t, 9.0, got["_max"])
+	testutil.Equals(t, uint64(10), got["_sample_count"])
+}
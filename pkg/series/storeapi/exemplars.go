@@ -0,0 +1,102 @@
+package storeapi
+
+import (
+	"context"
+	"io"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/thanos-community/obslytics/pkg/series"
+	"github.com/thanos-io/thanos/pkg/exemplars/exemplarspb"
+	"github.com/thanos-io/thanos/pkg/store/labelpb"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Compile-time check if Series implements series.ExemplarsReader interface.
+var _ series.ExemplarsReader = Series{}
+
+// ReadExemplars reads the exemplars via the Exemplars API. Returns series.ErrExemplarsUnsupported when
+// the endpoint does not implement it (e.g. older Thanos components).
+func (i Series) ReadExemplars(ctx context.Context, params series.Params) ([]series.SeriesExemplars, error) {
+	if err := params.Validate(); err != nil {
+		return nil, err
+	}
+	if err := i.conf.Tenant.Validate(); err != nil {
+		return nil, err
+	}
+	matchers, err := params.PromMatchers()
+	if err != nil {
+		return nil, err
+	}
+	if m := i.conf.Tenant.Matcher(); m != nil {
+		matchers = append(matchers[:len(matchers):len(matchers)], m)
+	}
+	conn, err := i.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := i.limiter.WaitRequest(ctx); err != nil {
+		return nil, err
+	}
+
+	start, end := params.Range()
+	client, err := conn.exemplarsClient().Exemplars(ctx, &exemplarspb.ExemplarsRequest{
+		Query:                   series.MatchersQuery(matchers),
+		Start:                   start,
+		End:                     end,
+		PartialResponseStrategy: storepb.PartialResponseStrategy_ABORT,
+	})
+	if err != nil {
+		return nil, exemplarsErr(err, i.conf.Endpoint)
+	}
+
+	mint, maxt := params.Bounds()
+	var res []series.SeriesExemplars
+	for {
+		resp, err := client.Recv()
+		if err == io.EOF {
+			return res, nil
+		}
+		if err != nil {
+			return nil, exemplarsErr(err, i.conf.Endpoint)
+		}
+		if err := i.limiter.WaitBytes(ctx, resp.Size()); err != nil {
+			return nil, err
+		}
+
+		if w := resp.GetWarning(); w != "" {
+			level.Warn(i.logger).Log("msg", "exemplars warning", "warning", w)
+			continue
+		}
+
+		data := resp.GetData()
+		se := series.SeriesExemplars{SeriesLabels: labelpb.ZLabelsToPromLabels(data.SeriesLabels.Labels)}
+		if err := i.conf.Tenant.Check(se.SeriesLabels); err != nil {
+			return nil, err
+		}
+		for _, e := range data.Exemplars {
+			if e.Ts < mint || e.Ts > maxt {
+				continue
+			}
+			se.Exemplars = append(se.Exemplars, series.Exemplar{
+				Labels: labelpb.ZLabelsToPromLabels(e.Labels.Labels),
+				Value:  e.Value,
+				Ts:     e.Ts,
+			})
+		}
+		if len(se.Exemplars) > 0 {
+			res = append(res, se)
+		}
+	}
+}
+
+func exemplarsErr(err error, endpoint string) error {
+	if status.Code(err) == codes.Unimplemented {
+		return series.ErrExemplarsUnsupported
+	}
+	return errors.Wrapf(err, "exemplarspb.Exemplars against %v", endpoint)
+}
@@ -0,0 +1,534 @@
+package storeapi
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/thanos-community/obslytics/pkg/series"
+	"github.com/thanos-community/obslytics/pkg/version"
+	"github.com/thanos-io/thanos/pkg/exemplars/exemplarspb"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	grpcWebContentType = "application/grpc-web+proto"
+
+	// grpcWebTrailerFlag marks the frame of the trailers, sent last in the body.
+	grpcWebTrailerFlag = 0x80
+	// grpcWebCompressedFlag marks the compressed message frame, never requested by the client.
+	grpcWebCompressedFlag = 0x01
+)
+
+// clientConn is the connection to the StoreAPI endpoint, either the native gRPC or the gRPC-Web one.
+type clientConn interface {
+	io.Closer
+
+	storeClient() storepb.StoreClient
+	exemplarsClient() exemplarspb.ExemplarsClient
+}
+
+type grpcConn struct {
+	*grpc.ClientConn
+}
+
+func (c grpcConn) storeClient() storepb.StoreClient { return storepb.NewStoreClient(c.ClientConn) }
+
+func (c grpcConn) exemplarsClient() exemplarspb.ExemplarsClient {
+	return exemplarspb.NewExemplarsClient(c.ClientConn)
+}
+
+// dial connects to the StoreAPI endpoint with the transport of the configuration.
+func (i Series) dial(ctx context.Context) (clientConn, error) {
+	if i.conf.GRPCWeb {
+		if len(i.dialOpts) > 0 {
+			return nil, errors.New("gRPC dial options cannot be used with grpc_web")
+		}
+		return newWebConn(i.logger, i.conf, i.tokens)
+	}
+
+	dialOpts, err := dialOptions(i.logger, i.conf, i.tokens)
+	if err != nil {
+		return nil, errors.Wrap(err, "error initializing GRPC options")
+	}
+	dialOpts = append(dialOpts, i.dialOpts...)
+
+	conn, err := grpc.DialContext(ctx, i.conf.Endpoint, dialOpts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "error initializing GRPC dial context")
+	}
+	return grpcConn{ClientConn: conn}, nil
+}
+
+// webConn calls the endpoint over gRPC-Web, for the endpoints behind the proxies forwarding only gRPC-Web, e.g.
+// Envoy's grpc_web filter or the improbable-eng grpcwebproxy. The binary (not base64 text) protocol is used over HTTP/2,
+// or over HTTP/1.1 if the proxy doesn't negotiate HTTP/2. Only the unary and server streaming calls are supported,
+// which is all the StoreAPI reads need. Like the native gRPC, the metadata and the bearer token are sent as headers
+// and the unauthenticated calls are retried once with the token read again.
+type webConn struct {
+	logger log.Logger
+	client *http.Client
+	// base is the scheme and the host of the endpoint.
+	base   string
+	header http.Header
+	tokens TokenSource
+
+	// open are the streams with the responses not read to the end, released on Close like the streams of the gRPC
+	// connection.
+	mtx  sync.Mutex
+	open map[*webStream]struct{}
+}
+
+func newWebConn(logger log.Logger, conf series.Config, tokens TokenSource) (*webConn, error) {
+	transport := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		ForceAttemptHTTP2:   true,
+		IdleConnTimeout:     90 * time.Second,
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+	host := conf.Endpoint
+	if socket, ok := unixSocketPath(conf.Endpoint); ok {
+		host = "localhost"
+		transport.Proxy = nil
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		}
+	}
+
+	scheme := "http"
+	if conf.TLSConfig.CertFile != "" || conf.TLSConfig.KeyFile != "" || conf.TLSConfig.CAFile != "" || conf.TLSConfig.PKCS12File != "" {
+		tlsCfg, err := newCustomClientConfig(logger, conf)
+		if err != nil {
+			return nil, errors.Wrap(err, "building TLS config")
+		}
+		transport.TLSClientConfig = tlsCfg
+		scheme = "https"
+	}
+
+	header := http.Header{}
+	for k, v := range conf.Metadata {
+		header.Set(k, v)
+	}
+	header.Set("Content-Type", grpcWebContentType)
+	header.Set("Accept", grpcWebContentType)
+	header.Set("X-Grpc-Web", "1")
+	header.Set("User-Agent", path.Join("obslytics", version.Version))
+	return &webConn{
+		logger: logger,
+		client: &http.Client{Transport: transport},
+		base:   scheme + "://" + host,
+		header: header,
+		tokens: tokens,
+		open:   map[*webStream]struct{}{},
+	}, nil
+}
+
+// Invoke calls the unary method as a server streaming one with a single response.
+func (c *webConn) Invoke(ctx context.Context, method string, args, reply interface{}, opts ...grpc.CallOption) error {
+	s, err := c.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true}, method, opts...)
+	if err != nil {
+		return err
+	}
+	if err := s.SendMsg(args); err != nil {
+		return err
+	}
+	if err := s.RecvMsg(reply); err != nil {
+		if err == io.EOF {
+			return status.Errorf(codes.Internal, "%s: no response", method)
+		}
+		return err
+	}
+	// Read up to the trailers, which carry the status of the call.
+	if err := s.RecvMsg(reply); err != io.EOF {
+		if err == nil {
+			return status.Errorf(codes.Internal, "%s: more than one response of the unary call", method)
+		}
+		return err
+	}
+	return nil
+}
+
+func (c *webConn) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, _ ...grpc.CallOption) (grpc.ClientStream, error) {
+	if desc.ClientStreams {
+		return nil, status.Errorf(codes.Unimplemented, "%s: gRPC-Web supports only unary and server streaming calls", method)
+	}
+	return &webStream{ctx: ctx, conn: c, method: method}, nil
+}
+
+func (c *webConn) Close() error {
+	c.mtx.Lock()
+	for s := range c.open {
+		_ = s.resp.Body.Close()
+	}
+	c.open = map[*webStream]struct{}{}
+	c.mtx.Unlock()
+
+	c.client.CloseIdleConnections()
+	return nil
+}
+
+// post sends the request message, returning the response with the headers received.
+func (c *webConn) post(ctx context.Context, method string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.base+method, bytes.NewReader(body))
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	req.Header = c.header.Clone()
+	if md, ok := metadata.FromOutgoingContext(ctx); ok {
+		for k, vs := range md {
+			for _, v := range vs {
+				req.Header.Add(k, v)
+			}
+		}
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		// The timeout is rounded up, so that the short ones are not sent as 0m, i.e. no time left.
+		timeout := time.Until(deadline)
+		if timeout <= 0 {
+			return nil, status.Errorf(codes.DeadlineExceeded, "%s: deadline exceeded before sending the request", method)
+		}
+		req.Header.Set("Grpc-Timeout", strconv.FormatInt(int64((timeout+time.Millisecond-1)/time.Millisecond), 10)+"m")
+	}
+	if c.tokens != nil {
+		token, err := c.tokens(ctx)
+		if err != nil {
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, contextStatus(ctx, err)
+	}
+	if err := responseStatus(resp); err != nil {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		_ = resp.Body.Close()
+		return nil, err
+	}
+	return resp, nil
+}
+
+// responseStatus returns the error of the response failed before any message, either by the HTTP status, e.g. of the
+// proxy, or by the status of the call in the headers of the response without a body.
+func responseStatus(resp *http.Response) error {
+	if resp.StatusCode != http.StatusOK {
+		return status.Errorf(httpStatusCode(resp.StatusCode), "gRPC-Web request failed with HTTP status %s", resp.Status)
+	}
+	if s := resp.Header.Get("Grpc-Status"); s != "" {
+		return trailerStatus(s, resp.Header.Get("Grpc-Message"))
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/grpc-web") {
+		return status.Errorf(codes.Unknown, "unexpected content type %q of the gRPC-Web response, is the endpoint a gRPC-Web proxy?", ct)
+	}
+	return nil
+}
+
+// httpStatusCode maps the HTTP status to the gRPC code as gRPC does for the responses without a gRPC status.
+func httpStatusCode(code int) codes.Code {
+	switch code {
+	case http.StatusBadRequest:
+		return codes.Internal
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.Unimplemented
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return codes.Unavailable
+	}
+	return codes.Unknown
+}
+
+func trailerStatus(code, msg string) error {
+	c, err := strconv.Atoi(code)
+	if err != nil {
+		return status.Errorf(codes.Internal, "invalid grpc-status %q", code)
+	}
+	if c == int(codes.OK) {
+		return nil
+	}
+	if m, err := url.PathUnescape(msg); err == nil {
+		msg = m
+	}
+	return status.Error(codes.Code(c), msg)
+}
+
+// contextStatus returns the status of the error of the canceled or timed out call, or Unavailable otherwise, e.g. on
+// a connection reset.
+func contextStatus(ctx context.Context, err error) error {
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	case context.Canceled:
+		return status.Error(codes.Canceled, err.Error())
+	}
+	return status.Error(codes.Unavailable, err.Error())
+}
+
+// webStream implements grpc.ClientStream of the server streaming call. The request is sent by SendMsg, the responses
+// are read from the body by RecvMsg, up to the trailers.
+type webStream struct {
+	ctx    context.Context
+	conn   *webConn
+	method string
+
+	resp    *http.Response
+	body    *bufio.Reader
+	trailer metadata.MD
+	done    bool
+}
+
+func (s *webStream) Context() context.Context { return s.ctx }
+
+func (s *webStream) Header() (metadata.MD, error) {
+	if s.resp == nil {
+		return nil, nil
+	}
+	return headerMetadata(s.resp.Header), nil
+}
+
+func (s *webStream) Trailer() metadata.MD { return s.trailer }
+
+// CloseSend is a no-op, the request is sent whole by SendMsg.
+func (s *webStream) CloseSend() error { return nil }
+
+func (s *webStream) SendMsg(m interface{}) error {
+	if s.resp != nil {
+		return status.Errorf(codes.Internal, "%s: gRPC-Web supports a single request message", s.method)
+	}
+	msg, ok := m.(interface{ Marshal() ([]byte, error) })
+	if !ok {
+		return status.Errorf(codes.Internal, "%s: cannot marshal %T", s.method, m)
+	}
+	b, err := msg.Marshal()
+	if err != nil {
+		return status.Errorf(codes.Internal, "%s: marshal request: %v", s.method, err)
+	}
+	body := make([]byte, 5+len(b))
+	binary.BigEndian.PutUint32(body[1:5], uint32(len(b)))
+	copy(body[5:], b)
+
+	resp, err := s.conn.post(s.ctx, s.method, body)
+	if status.Code(err) == codes.Unauthenticated && s.conn.tokens != nil {
+		level.Debug(s.conn.logger).Log("msg", "stream unauthenticated, retrying with refreshed token", "method", s.method, "err", err)
+		resp, err = s.conn.post(s.ctx, s.method, body)
+	}
+	if err != nil {
+		return err
+	}
+	s.resp = resp
+	s.body = bufio.NewReader(resp.Body)
+	s.conn.mtx.Lock()
+	s.conn.open[s] = struct{}{}
+	s.conn.mtx.Unlock()
+	return nil
+}
+
+func (s *webStream) RecvMsg(m interface{}) error {
+	if s.resp == nil {
+		return status.Errorf(codes.Internal, "%s: no request sent", s.method)
+	}
+	if s.done {
+		return io.EOF
+	}
+	for {
+		flag, b, err := s.readFrame()
+		if err != nil {
+			s.finish()
+			return err
+		}
+		if flag&grpcWebTrailerFlag != 0 {
+			s.finish()
+			return s.readTrailer(b)
+		}
+		if flag&grpcWebCompressedFlag != 0 {
+			s.finish()
+			return status.Errorf(codes.Unimplemented, "%s: compressed gRPC-Web responses are not supported", s.method)
+		}
+		msg, ok := m.(interface{ Unmarshal([]byte) error })
+		if !ok {
+			s.finish()
+			return status.Errorf(codes.Internal, "%s: cannot unmarshal %T", s.method, m)
+		}
+		if err := msg.Unmarshal(b); err != nil {
+			s.finish()
+			return status.Errorf(codes.Internal, "%s: unmarshal response: %v", s.method, err)
+		}
+		return nil
+	}
+}
+
+// readFrame reads the next frame of the body, failing on the body ended without the trailers.
+func (s *webStream) readFrame() (byte, []byte, error) {
+	var h [5]byte
+	if _, err := io.ReadFull(s.body, h[:]); err != nil {
+		if err == io.EOF && s.resp.Header.Get("Grpc-Status") != "" {
+			// The response without messages has the status in the headers, checked by post.
+			return 0, nil, io.EOF
+		}
+		if err == io.EOF {
+			return 0, nil, status.Errorf(codes.Internal, "%s: gRPC-Web response ended without trailers", s.method)
+		}
+		return 0, nil, contextStatus(s.ctx, err)
+	}
+	n := binary.BigEndian.Uint32(h[1:])
+	if n > maxRecvMsgSize {
+		return 0, nil, status.Errorf(codes.ResourceExhausted, "%s: received gRPC-Web message larger than max (%d vs. %d)", s.method, n, maxRecvMsgSize)
+	}
+	// The buffer grows by the bytes read rather than being allocated up front by the length prefix.
+	b := &bytes.Buffer{}
+	if _, err := io.CopyN(b, s.body, int64(n)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, nil, contextStatus(s.ctx, err)
+	}
+	return h[0], b.Bytes(), nil
+}
+
+// readTrailer parses the trailers frame, in the HTTP/1 header format, returning io.EOF on the successful call.
+func (s *webStream) readTrailer(b []byte) error {
+	// The trailers lack the blank line ending the headers.
+	hdr, err := textproto.NewReader(bufio.NewReader(io.MultiReader(bytes.NewReader(b), strings.NewReader("\r\n")))).ReadMIMEHeader()
+	if err != nil {
+		return status.Errorf(codes.Internal, "%s: parse gRPC-Web trailers: %v", s.method, err)
+	}
+	s.trailer = headerMetadata(http.Header(hdr))
+	code := hdr.Get("Grpc-Status")
+	if code == "" {
+		return status.Errorf(codes.Internal, "%s: gRPC-Web trailers without grpc-status", s.method)
+	}
+	if err := trailerStatus(code, hdr.Get("Grpc-Message")); err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+// finish releases the response once the call ended.
+func (s *webStream) finish() {
+	s.done = true
+	_ = s.resp.Body.Close()
+	s.conn.mtx.Lock()
+	delete(s.conn.open, s)
+	s.conn.mtx.Unlock()
+}
+
+func headerMetadata(h http.Header) metadata.MD {
+	md := make(metadata.MD, len(h))
+	for k, vs := range h {
+		md[strings.ToLower(k)] = vs
+	}
+	return md
+}
+
+func (c *webConn) storeClient() storepb.StoreClient { return webStoreClient{conn: c} }
+
+func (c *webConn) exemplarsClient() exemplarspb.ExemplarsClient { return webExemplarsClient{conn: c} }
+
+// serverStream opens the server streaming call with the request.
+func (c *webConn) serverStream(ctx context.Context, method string, in interface{}, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	s, err := c.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true}, method, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.SendMsg(in); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// webStoreClient implements storepb.StoreClient over gRPC-Web, as the generated client requires the gRPC connection.
+type webStoreClient struct {
+	conn *webConn
+}
+
+func (c webStoreClient) Info(ctx context.Context, in *storepb.InfoRequest, opts ...grpc.CallOption) (*storepb.InfoResponse, error) {
+	out := new(storepb.InfoResponse)
+	if err := c.conn.Invoke(ctx, "/thanos.Store/Info", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c webStoreClient) Series(ctx context.Context, in *storepb.SeriesRequest, opts ...grpc.CallOption) (storepb.Store_SeriesClient, error) {
+	s, err := c.conn.serverStream(ctx, "/thanos.Store/Series", in, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return webSeriesClient{ClientStream: s}, nil
+}
+
+func (c webStoreClient) LabelNames(ctx context.Context, in *storepb.LabelNamesRequest, opts ...grpc.CallOption) (*storepb.LabelNamesResponse, error) {
+	out := new(storepb.LabelNamesResponse)
+	if err := c.conn.Invoke(ctx, "/thanos.Store/LabelNames", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c webStoreClient) LabelValues(ctx context.Context, in *storepb.LabelValuesRequest, opts ...grpc.CallOption) (*storepb.LabelValuesResponse, error) {
+	out := new(storepb.LabelValuesResponse)
+	if err := c.conn.Invoke(ctx, "/thanos.Store/LabelValues", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+type webSeriesClient struct {
+	grpc.ClientStream
+}
+
+func (x webSeriesClient) Recv() (*storepb.SeriesResponse, error) {
+	m := new(storepb.SeriesResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// webExemplarsClient implements exemplarspb.ExemplarsClient over gRPC-Web.
+type webExemplarsClient struct {
+	conn *webConn
+}
+
+func (c webExemplarsClient) Exemplars(ctx context.Context, in *exemplarspb.ExemplarsRequest, opts ...grpc.CallOption) (exemplarspb.Exemplars_ExemplarsClient, error) {
+	s, err := c.conn.serverStream(ctx, "/thanos.Exemplars/Exemplars", in, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return webExemplarsStream{ClientStream: s}, nil
+}
+
+type webExemplarsStream struct {
+	grpc.ClientStream
+}
+
+func (x webExemplarsStream) Recv() (*exemplarspb.ExemplarsResponse, error) {
+	m := new(exemplarspb.ExemplarsResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
@@ -0,0 +1,81 @@
+package storeapi
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/improbable-eng/grpc-web/go/grpcweb"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/thanos-community/obslytics/pkg/series"
+	"github.com/thanos-community/obslytics/pkg/series/storeapi/storetest"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"github.com/thanos-io/thanos/pkg/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// serveGRPCWeb serves the store by the improbable-eng gRPC-Web server, the one of grpcwebproxy, until the end of the
+// test, returning the endpoint to read it by.
+func serveGRPCWeb(t *testing.T, store storepb.StoreServer) string {
+	srv := grpc.NewServer()
+	storepb.RegisterStoreServer(srv, store)
+	web := httptest.NewServer(grpcweb.WrapServer(srv))
+	t.Cleanup(web.Close)
+	return strings.TrimPrefix(web.URL, "http://")
+}
+
+// TestSeries_GRPCWeb_Interop reads the store served by the gRPC-Web server of the proxies rather than by the frames of
+// the test server.
+func TestSeries_GRPCWeb_Interop(t *testing.T) {
+	read := func(endpoint string) (map[string][]float64, storage.Warnings, error) {
+		s, err := NewSeries(log.NewNopLogger(), series.Config{Endpoint: endpoint, GRPCWeb: true})
+		testutil.Ok(t, err)
+		set, err := s.Read(context.Background(), series.Params{
+			Matchers: []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "__name__", "up")},
+			MinTime:  time.Unix(0, 0),
+			MaxTime:  time.Unix(60, 0),
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		defer func() { testutil.Ok(t, set.Close()) }()
+
+		got := map[string][]float64{}
+		for set.Next() {
+			lset := set.At().Labels().String()
+			it := set.At().Iterator()
+			for it.Next() {
+				_, v := it.At()
+				got[lset] = append(got[lset], v)
+			}
+			testutil.Ok(t, it.Err())
+		}
+		return got, set.Warnings(), set.Err()
+	}
+
+	store := &storetest.Store{
+		Fixtures: []*storepb.Series{
+			storetest.NewSeries(labels.FromStrings("__name__", "up", "job", "a"), storetest.Sample{T: 1000, V: 1}, storetest.Sample{T: 2000, V: 2}),
+			storetest.NewSeries(labels.FromStrings("__name__", "up", "job", "b"), storetest.Sample{T: 1000, V: 3}),
+			storetest.NewSeries(labels.FromStrings("__name__", "down", "job", "a"), storetest.Sample{T: 1000, V: 4}),
+		},
+		Warnings: []string{"store c unavailable"},
+	}
+	got, warnings, err := read(serveGRPCWeb(t, store))
+	testutil.Ok(t, err)
+	testutil.Equals(t, map[string][]float64{`{__name__="up", job="a"}`: {1, 2}, `{__name__="up", job="b"}`: {3}}, got)
+	testutil.Equals(t, 1, len(warnings))
+	testutil.Equals(t, int64(60000), store.Requests()[0].MaxTime)
+
+	// The status of the failed call is read from the response of the server.
+	_, _, err = read(serveGRPCWeb(t, &storetest.Store{PartialErr: errors.New("store c unavailable")}))
+	testutil.Equals(t, codes.Aborted, status.Code(errors.Cause(err)))
+	testutil.Assert(t, strings.Contains(err.Error(), "store c unavailable"), err.Error())
+}
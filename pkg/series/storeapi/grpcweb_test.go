@@ -0,0 +1,176 @@
+package storeapi
+
+import (
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/thanos-community/obslytics/pkg/series"
+	"github.com/thanos-io/thanos/pkg/store/labelpb"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"github.com/thanos-io/thanos/pkg/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func grpcWebFrame(flag byte, b []byte) []byte {
+	f := make([]byte, 5, 5+len(b))
+	f[0] = flag
+	binary.BigEndian.PutUint32(f[1:], uint32(len(b)))
+	return append(f, b...)
+}
+
+// grpcWebStore serves the Series call over gRPC-Web, failing as requested by the run metadata.
+type grpcWebStore struct {
+	t   *testing.T
+	req storepb.SeriesRequest
+	hdr http.Header
+}
+
+func (s *grpcWebStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/thanos.Store/Series" || r.Header.Get("Content-Type") != grpcWebContentType {
+		http.NotFound(w, r)
+		return
+	}
+	s.hdr = r.Header
+	b, err := ioutil.ReadAll(r.Body)
+	testutil.Ok(s.t, err)
+	testutil.Equals(s.t, int(binary.BigEndian.Uint32(b[1:5])), len(b)-5)
+	testutil.Ok(s.t, s.req.Unmarshal(b[5:]))
+
+	w.Header().Set("Content-Type", grpcWebContentType)
+	if r.Header.Get("run") == "denied" {
+		w.Header().Set("Grpc-Status", "7")
+		w.Header().Set("Grpc-Message", "no%20access")
+		return
+	}
+	for _, name := range []string{"a", "b"} {
+		b, err := storepb.NewSeriesResponse(&storepb.Series{Labels: []labelpb.ZLabel{{Name: "__name__", Value: name}}}).Marshal()
+		testutil.Ok(s.t, err)
+		_, _ = w.Write(grpcWebFrame(0, b))
+	}
+	if r.Header.Get("run") == "aborted" {
+		_, _ = w.Write(grpcWebFrame(grpcWebTrailerFlag, []byte("grpc-status: 10\r\ngrpc-message: store%20restarted\r\n")))
+		return
+	}
+	_, _ = w.Write(grpcWebFrame(grpcWebTrailerFlag, []byte("grpc-status: 0\r\n")))
+}
+
+func TestSeries_GRPCWeb(t *testing.T) {
+	store := &grpcWebStore{t: t}
+	srv := httptest.NewServer(store)
+	defer srv.Close()
+
+	read := func(run string) ([]string, error) {
+		s, err := NewSeries(log.NewNopLogger(), series.Config{
+			Endpoint:    strings.TrimPrefix(srv.URL, "http://"),
+			GRPCWeb:     true,
+			Metadata:    map[string]string{"run": run},
+			BearerToken: "secret",
+		})
+		testutil.Ok(t, err)
+		set, err := s.Read(context.Background(), series.Params{MinTime: time.Unix(0, 0), MaxTime: time.Unix(60, 0)})
+		if err != nil {
+			return nil, err
+		}
+		defer func() { testutil.Ok(t, set.Close()) }()
+
+		var names []string
+		for set.Next() {
+			names = append(names, set.At().Labels().Get("__name__"))
+		}
+		return names, set.Err()
+	}
+
+	names, err := read("ok")
+	testutil.Ok(t, err)
+	testutil.Equals(t, []string{"a", "b"}, names)
+	testutil.Equals(t, int64(60000), store.req.MaxTime)
+	testutil.Equals(t, "Bearer secret", store.hdr.Get("Authorization"))
+	testutil.Equals(t, "1", store.hdr.Get("X-Grpc-Web"))
+
+	// The status of the trailers fails the read after the series received.
+	names, err = read("aborted")
+	testutil.Equals(t, []string{"a", "b"}, names)
+	testutil.Equals(t, codes.Aborted, status.Code(err))
+	testutil.Assert(t, strings.Contains(err.Error(), "store restarted"), err.Error())
+
+	// The status of the response without messages fails the call.
+	_, err = read("denied")
+	testutil.Equals(t, codes.PermissionDenied, status.Code(errors.Cause(err)))
+	testutil.Assert(t, strings.Contains(err.Error(), "no access"), err.Error())
+}
+
+func TestWebConn_Deadline(t *testing.T) {
+	var timeouts []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timeouts = append(timeouts, r.Header.Get("Grpc-Timeout"))
+		w.Header().Set("Content-Type", grpcWebContentType)
+		_, _ = w.Write(grpcWebFrame(grpcWebTrailerFlag, []byte("grpc-status: 0\r\n")))
+	}))
+	defer srv.Close()
+
+	c, err := newWebConn(log.NewNopLogger(), series.Config{Endpoint: strings.TrimPrefix(srv.URL, "http://")}, nil)
+	testutil.Ok(t, err)
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	_, err = c.storeClient().LabelNames(ctx, &storepb.LabelNamesRequest{})
+	testutil.Equals(t, codes.Internal, status.Code(err))
+	testutil.Equals(t, 1, len(timeouts))
+	testutil.Assert(t, timeouts[0] == "60000m" || timeouts[0] == "59999m", timeouts[0])
+
+	// The expired deadline fails the call without sending it.
+	ctx, cancel = context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	_, err = c.storeClient().LabelNames(ctx, &storepb.LabelNamesRequest{})
+	testutil.Equals(t, codes.DeadlineExceeded, status.Code(err))
+	testutil.Equals(t, 1, len(timeouts))
+}
+
+func TestWebConn_MaxRecvMsgSize(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", grpcWebContentType)
+		h := make([]byte, 5)
+		binary.BigEndian.PutUint32(h[1:], map[string]uint32{"over": math.MaxUint32, "short": 1 << 30}[r.Header.Get("run")])
+		_, _ = w.Write(append(h, 1, 2, 3))
+	}))
+	defer srv.Close()
+
+	call := func(run string) error {
+		c, err := newWebConn(log.NewNopLogger(), series.Config{
+			Endpoint: strings.TrimPrefix(srv.URL, "http://"),
+			Metadata: map[string]string{"run": run},
+		}, nil)
+		testutil.Ok(t, err)
+		defer c.Close()
+		_, err = c.storeClient().LabelNames(context.Background(), &storepb.LabelNamesRequest{})
+		return err
+	}
+
+	// The message over the limit fails the call before it is read.
+	err := call("over")
+	testutil.Equals(t, codes.ResourceExhausted, status.Code(err))
+
+	// The length of a message is not trusted to allocate it, the body ends before.
+	err = call("short")
+	testutil.Equals(t, codes.Unavailable, status.Code(err))
+	testutil.Assert(t, strings.Contains(err.Error(), "unexpected EOF"), err.Error())
+}
+
+func TestWebConn_ClientStreams(t *testing.T) {
+	c, err := newWebConn(log.NewNopLogger(), series.Config{Endpoint: "localhost:1"}, nil)
+	testutil.Ok(t, err)
+	_, err = c.NewStream(context.Background(), &grpc.StreamDesc{ClientStreams: true}, "/thanos.Store/Series")
+	testutil.Equals(t, codes.Unimplemented, status.Code(err))
+}
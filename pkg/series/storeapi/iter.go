@@ -1,14 +1,23 @@
 package storeapi
 
 import (
+	"sort"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
 	"github.com/pkg/errors"
 	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
 	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/thanos-community/obslytics/pkg/series"
 
 	"github.com/thanos-io/thanos/pkg/compact/downsample"
 	"github.com/thanos-io/thanos/pkg/store/storepb"
 )
 
+// Compile-time check if chunkSeries implements series.EncodedSeries interface.
+var _ series.EncodedSeries = &chunkSeries{}
+
 // chunkSeries implements storage.Series for a series on storepb types.
 type chunkSeries struct {
 	lset       labels.Labels
@@ -32,6 +41,41 @@ func (s *chunkSeries) Labels() labels.Labels {
 	return s.lset
 }
 
+// ChunkEncodings implements series.EncodedSeries. The raw chunks are counted by their encoding, the downsampled ones
+// by the encoding of their aggregates.
+func (s *chunkSeries) ChunkEncodings() []series.ChunkEncoding {
+	index := map[string]int{}
+	var ret []series.ChunkEncoding
+	for _, c := range s.chunks {
+		var (
+			enc   string
+			bytes int
+		)
+		for _, ch := range []*storepb.Chunk{c.Raw, c.Count, c.Sum, c.Min, c.Max, c.Counter} {
+			if ch == nil {
+				continue
+			}
+			if enc == "" {
+				enc = ch.Type.String()
+			}
+			bytes += len(ch.Data)
+		}
+		if enc == "" {
+			continue
+		}
+		i, ok := index[enc]
+		if !ok {
+			i = len(ret)
+			index[enc] = i
+			ret = append(ret, series.ChunkEncoding{Encoding: enc})
+		}
+		ret[i].Chunks++
+		ret[i].Bytes += bytes
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Encoding < ret[j].Encoding })
+	return ret
+}
+
 func (s *chunkSeries) Iterator() chunkenc.Iterator {
 	var sit chunkenc.Iterator
 	its := make([]chunkenc.Iterator, 0, len(s.chunks))
@@ -69,20 +113,23 @@ func (s *chunkSeries) Iterator() chunkenc.Iterator {
 		return newBoundedSeriesIterator(sit, s.mint, s.maxt)
 	}
 
-	if len(s.aggrs) != 2 {
-		return errSeriesIterator{err: errors.Errorf("unexpected result aggregate type %v", s.aggrs)}
-	}
-
 	switch {
-	case s.aggrs[0] == storepb.Aggr_SUM && s.aggrs[1] == storepb.Aggr_COUNT,
-		s.aggrs[0] == storepb.Aggr_COUNT && s.aggrs[1] == storepb.Aggr_SUM:
-
+	case len(s.aggrs) == 2 && hasAggrs(s.aggrs, storepb.Aggr_COUNT, storepb.Aggr_SUM):
 		for _, c := range s.chunks {
 			if c.Raw != nil {
 				its = append(its, getFirstIterator(c.Raw))
 			} else {
 				sum, cnt := getFirstIterator(c.Sum), getFirstIterator(c.Count)
-				its = append(its, downsample.NewAverageChunkIterator(cnt, sum))
+				its = append(its, &averageIterator{Iterator: downsample.NewAverageChunkIterator(cnt, sum), cnt: cnt})
+			}
+		}
+		sit = newChunkSeriesIterator(its)
+	case len(s.aggrs) == 4 && hasAggrs(s.aggrs, storepb.Aggr_COUNT, storepb.Aggr_SUM, storepb.Aggr_MIN, storepb.Aggr_MAX):
+		for _, c := range s.chunks {
+			if c.Raw != nil {
+				its = append(its, getFirstIterator(c.Raw))
+			} else {
+				its = append(its, newAggrIterator(c))
 			}
 		}
 		sit = newChunkSeriesIterator(its)
@@ -92,6 +139,122 @@ func (s *chunkSeries) Iterator() chunkenc.Iterator {
 	return newBoundedSeriesIterator(sit, s.mint, s.maxt)
 }
 
+// hasAggrs returns true if all the wanted aggregates are in aggrs.
+func hasAggrs(aggrs []storepb.Aggr, want ...storepb.Aggr) bool {
+	for _, w := range want {
+		found := false
+		for _, a := range aggrs {
+			if a == w {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// averageIterator exposes the count of the raw samples of the averages of the downsampled chunks, advanced in lockstep
+// with the sum by the average iterator.
+type averageIterator struct {
+	chunkenc.Iterator
+
+	cnt chunkenc.Iterator
+}
+
+func (it *averageIterator) SampleCount() uint64 {
+	_, v := it.cnt.At()
+	return uint64(v)
+}
+
+// aggrIterator iterates the averages of a downsampled chunk, exposing the count, sum, min and max of their raw samples.
+// The aggregate chunks are advanced in lockstep and have to share their timestamps.
+type aggrIterator struct {
+	cnt, sum, min, max chunkenc.Iterator
+
+	err error
+}
+
+func newAggrIterator(c storepb.AggrChunk) chunkenc.Iterator {
+	if c.Count == nil || c.Sum == nil || c.Min == nil || c.Max == nil {
+		return errSeriesIterator{errors.New("downsampled chunk without count, sum, min and max aggregates")}
+	}
+	return &aggrIterator{
+		cnt: getFirstIterator(c.Count),
+		sum: getFirstIterator(c.Sum),
+		min: getFirstIterator(c.Min),
+		max: getFirstIterator(c.Max),
+	}
+}
+
+func (it *aggrIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	its := []chunkenc.Iterator{it.cnt, it.sum, it.min, it.max}
+	ok := it.cnt.Next()
+	t, _ := it.cnt.At()
+	for _, ait := range its[1:] {
+		if ait.Next() != ok {
+			it.err = errors.New("downsampled aggregate chunks of different lengths")
+			return false
+		}
+		if at, _ := ait.At(); ok && at != t {
+			it.err = errors.Errorf("downsampled aggregate chunks misaligned at %d and %d", t, at)
+			return false
+		}
+	}
+	return ok
+}
+
+func (it *aggrIterator) Seek(t int64) bool {
+	if it.err != nil {
+		return false
+	}
+	if ct, _ := it.cnt.At(); ct >= t {
+		return true
+	}
+	for it.Next() {
+		if ct, _ := it.cnt.At(); ct >= t {
+			return true
+		}
+	}
+	return false
+}
+
+func (it *aggrIterator) At() (int64, float64) {
+	t, cnt := it.cnt.At()
+	_, sum := it.sum.At()
+	return t, sum / cnt
+}
+
+func (it *aggrIterator) Err() error {
+	if it.err != nil {
+		return it.err
+	}
+	for _, ait := range []chunkenc.Iterator{it.cnt, it.sum, it.min, it.max} {
+		if err := ait.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (it *aggrIterator) SampleCount() uint64 {
+	_, v := it.cnt.At()
+	return uint64(v)
+}
+
+func (it *aggrIterator) Aggregate() (series.Aggregate, bool) {
+	_, cnt := it.cnt.At()
+	_, sum := it.sum.At()
+	_, min := it.min.At()
+	_, max := it.max.At()
+	return series.Aggregate{Count: uint64(cnt), Sum: sum, Min: min, Max: max}, true
+}
+
 func getFirstIterator(cs ...*storepb.Chunk) chunkenc.Iterator {
 	for _, c := range cs {
 		if c == nil {
@@ -124,7 +287,7 @@ func (errSeriesIterator) At() (int64, float64) { return 0, 0 }
 func (it errSeriesIterator) Err() error        { return it.err }
 
 // boundedSeriesIterator wraps a series iterator and ensures that it only emits
-// samples within a fixed time range.
+// samples within a fixed, inclusive time range.
 type boundedSeriesIterator struct {
 	it         chunkenc.Iterator
 	mint, maxt int64
@@ -141,7 +304,12 @@ func (it *boundedSeriesIterator) Seek(t int64) (ok bool) {
 	if t < it.mint {
 		t = it.mint
 	}
-	return it.it.Seek(t)
+	if !it.it.Seek(t) {
+		return false
+	}
+	// Seeking can land on a sample past the valid interval.
+	t, _ = it.it.At()
+	return t <= it.maxt
 }
 
 func (it *boundedSeriesIterator) At() (t int64, v float64) {
@@ -169,6 +337,14 @@ func (it *boundedSeriesIterator) Err() error {
 	return it.it.Err()
 }
 
+func (it *boundedSeriesIterator) SampleCount() uint64 {
+	return series.SampleCount(it.it)
+}
+
+func (it *boundedSeriesIterator) Aggregate() (series.Aggregate, bool) {
+	return series.SampleAggregate(it.it)
+}
+
 // chunkSeriesIterator implements a series iterator on top
 // of a list of time-sorted, non-overlapping chunks.
 type chunkSeriesIterator struct {
@@ -224,3 +400,70 @@ func (it *chunkSeriesIterator) Next() bool {
 func (it *chunkSeriesIterator) Err() error {
 	return it.chunks[it.i].Err()
 }
+
+func (it *chunkSeriesIterator) SampleCount() uint64 {
+	return series.SampleCount(it.chunks[it.i])
+}
+
+func (it *chunkSeriesIterator) Aggregate() (series.Aggregate, bool) {
+	return series.SampleAggregate(it.chunks[it.i])
+}
+
+// tailChunks returns only the last chunk starting at or before maxt, so that
+// snapshot reads don't need to decode the whole history of a series.
+func tailChunks(chunks []storepb.AggrChunk, maxt int64) []storepb.AggrChunk {
+	for i := len(chunks) - 1; i > 0; i-- {
+		if chunks[i].MinTime <= maxt {
+			return chunks[i : i+1]
+		}
+	}
+	// Keep the first chunk (if any) and let the bounded iterator filter it out.
+	if len(chunks) > 1 {
+		return chunks[:1]
+	}
+	return chunks
+}
+
+// BoundedSeries wraps a series to expose only its samples within the inclusive time range.
+type BoundedSeries struct {
+	storage.Series
+
+	MinTime, MaxTime int64
+}
+
+func (s BoundedSeries) Iterator() chunkenc.Iterator {
+	return newBoundedSeriesIterator(s.Series.Iterator(), s.MinTime, s.MaxTime)
+}
+
+func (s BoundedSeries) Unwrap() storage.Series { return s.Series }
+
+// orderChunks checks the chunks are sorted by their min time, as expected by the chunk series iterator. Out-of-order
+// chunks (e.g. merged from multiple endpoints) fail the series or get sorted according to the policy. With the drop
+// policy, samples of chunks overlapping the previous ones are skipped by the iterator.
+func orderChunks(logger log.Logger, lset labels.Labels, chunks []storepb.AggrChunk, policy series.OutOfOrderPolicy) ([]storepb.AggrChunk, error) {
+	less := func(i, j int) bool { return chunks[i].MinTime < chunks[j].MinTime }
+	if sort.SliceIsSorted(chunks, less) {
+		return chunks, nil
+	}
+
+	switch policy {
+	case series.OutOfOrderSort:
+		level.Warn(logger).Log("msg", "sorted out-of-order chunks", "series", lset)
+		sort.SliceStable(chunks, less)
+	case series.OutOfOrderDrop:
+		level.Warn(logger).Log("msg", "dropping samples of out-of-order chunks", "series", lset)
+	default:
+		return nil, errors.Errorf("series %s: out-of-order chunks, see out_of_order input option", lset)
+	}
+	return chunks, nil
+}
+
+// errSeries is a series failing to iterate with the error.
+type errSeries struct {
+	lset labels.Labels
+	err  error
+}
+
+func (s errSeries) Labels() labels.Labels { return s.lset }
+
+func (s errSeries) Iterator() chunkenc.Iterator { return errSeriesIterator{err: s.err} }
@@ -0,0 +1,164 @@
+package storeapi
+
+import (
+	"testing"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/thanos-community/obslytics/pkg/series"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+type sample struct {
+	t int64
+	v float64
+}
+
+// rawChunk creates a raw XOR chunk with the given samples.
+func rawChunk(t *testing.T, smpls ...sample) storepb.AggrChunk {
+	return storepb.AggrChunk{
+		MinTime: smpls[0].t,
+		MaxTime: smpls[len(smpls)-1].t,
+		Raw:     xorChunk(t, smpls...),
+	}
+}
+
+func xorChunk(t *testing.T, smpls ...sample) *storepb.Chunk {
+	c := chunkenc.NewXORChunk()
+	a, err := c.Appender()
+	testutil.Ok(t, err)
+
+	for _, s := range smpls {
+		a.Append(s.t, s.v)
+	}
+	return &storepb.Chunk{Type: storepb.Chunk_XOR, Data: c.Bytes()}
+}
+
+func expandSamples(t *testing.T, it chunkenc.Iterator) []sample {
+	var ret []sample
+	for it.Next() {
+		ts, v := it.At()
+		ret = append(ret, sample{t: ts, v: v})
+	}
+	testutil.Ok(t, it.Err())
+	return ret
+}
+
+func TestChunkSeries_TrimsToRange(t *testing.T) {
+	// Chunks straddle both the 20 and 50 boundaries.
+	chunks := []storepb.AggrChunk{
+		rawChunk(t, sample{10, 1}, sample{20, 2}, sample{30, 3}),
+		rawChunk(t, sample{40, 4}, sample{50, 5}, sample{60, 6}),
+	}
+	aggrs := []storepb.Aggr{storepb.Aggr_COUNT, storepb.Aggr_SUM}
+
+	for _, tcase := range []struct {
+		name       string
+		mint, maxt int64
+		expected   []sample
+	}{
+		{
+			name: "inclusive", mint: 20, maxt: 50,
+			expected: []sample{{20, 2}, {30, 3}, {40, 4}, {50, 5}},
+		},
+		{
+			name: "exclusive", mint: 21, maxt: 49,
+			expected: []sample{{30, 3}, {40, 4}},
+		},
+		{
+			name: "range between samples", mint: 31, maxt: 39,
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			s := newChunkSeries(labels.FromStrings("a", "b"), chunks, tcase.mint, tcase.maxt, aggrs)
+			testutil.Equals(t, tcase.expected, expandSamples(t, s.Iterator()))
+
+			// Seeking must not leak samples outside of the range either.
+			it := s.Iterator()
+			if len(tcase.expected) == 0 {
+				testutil.Assert(t, !it.Seek(0))
+				return
+			}
+			testutil.Assert(t, it.Seek(0))
+			ts, _ := it.At()
+			testutil.Equals(t, tcase.expected[0].t, ts)
+		})
+	}
+}
+
+func TestChunkSeries_SampleCount(t *testing.T) {
+	chunks := []storepb.AggrChunk{
+		rawChunk(t, sample{10, 1}, sample{20, 2}),
+		{
+			MinTime: 30, MaxTime: 40,
+			Count: xorChunk(t, sample{30, 4}, sample{40, 6}),
+			Sum:   xorChunk(t, sample{30, 8}, sample{40, 30}),
+		},
+	}
+	s := newChunkSeries(labels.FromStrings("a", "b"), chunks, 0, 50, []storepb.Aggr{storepb.Aggr_COUNT, storepb.Aggr_SUM})
+
+	type counted struct {
+		sample
+		count uint64
+	}
+	expand := func(it chunkenc.Iterator) []counted {
+		var ret []counted
+		for it.Next() {
+			ts, v := it.At()
+			ret = append(ret, counted{sample{ts, v}, series.SampleCount(it)})
+		}
+		testutil.Ok(t, it.Err())
+		return ret
+	}
+	// Raw samples count once, the downsampled averages by the counts of their aggregates.
+	testutil.Equals(t, []counted{{sample{10, 1}, 1}, {sample{20, 2}, 1}, {sample{30, 2}, 4}, {sample{40, 5}, 6}}, expand(s.Iterator()))
+	testutil.Equals(t, []counted{{sample{40, 5}, 6}}, expand(series.LatestSeries{Series: s}.Iterator()))
+}
+
+func TestChunkSeries_Aggregate(t *testing.T) {
+	chunks := []storepb.AggrChunk{
+		rawChunk(t, sample{10, 1}),
+		{
+			MinTime: 30, MaxTime: 40,
+			Count: xorChunk(t, sample{30, 4}, sample{40, 6}),
+			Sum:   xorChunk(t, sample{30, 8}, sample{40, 30}),
+			Min:   xorChunk(t, sample{30, 1}, sample{40, 2}),
+			Max:   xorChunk(t, sample{30, 3}, sample{40, 9}),
+		},
+	}
+	s := newChunkSeries(labels.FromStrings("a", "b"), chunks, 0, 50, rawOrAggregates)
+
+	type aggregated struct {
+		sample
+		aggr series.Aggregate
+		ok   bool
+	}
+	var got []aggregated
+	it := s.Iterator()
+	for it.Next() {
+		ts, v := it.At()
+		a, ok := series.SampleAggregate(it)
+		got = append(got, aggregated{sample{ts, v}, a, ok})
+	}
+	testutil.Ok(t, it.Err())
+	testutil.Equals(t, []aggregated{
+		{sample: sample{10, 1}},
+		{sample{30, 2}, series.Aggregate{Count: 4, Sum: 8, Min: 1, Max: 3}, true},
+		{sample{40, 5}, series.Aggregate{Count: 6, Sum: 30, Min: 2, Max: 9}, true},
+	}, got)
+
+	t.Run("misaligned aggregates", func(t *testing.T) {
+		chunks := []storepb.AggrChunk{{
+			MinTime: 30, MaxTime: 40,
+			Count: xorChunk(t, sample{30, 4}, sample{40, 6}),
+			Sum:   xorChunk(t, sample{30, 8}, sample{40, 30}),
+			Min:   xorChunk(t, sample{30, 1}, sample{41, 2}),
+			Max:   xorChunk(t, sample{30, 3}, sample{40, 9}),
+		}}
+		it := newChunkSeries(labels.FromStrings("a", "b"), chunks, 0, 50, rawOrAggregates).Iterator()
+		testutil.Assert(t, it.Next())
+		testutil.Assert(t, !it.Next())
+		testutil.NotOk(t, it.Err())
+	})
+}
@@ -0,0 +1,32 @@
+package storeapi
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// metadataUnaryInterceptor attaches the static metadata to every unary call.
+func metadataUnaryInterceptor(md map[string]string) grpc.UnaryClientInterceptor {
+	pairs := metadataPairs(md)
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(metadata.AppendToOutgoingContext(ctx, pairs...), method, req, reply, cc, opts...)
+	}
+}
+
+// metadataStreamInterceptor attaches the static metadata to every stream.
+func metadataStreamInterceptor(md map[string]string) grpc.StreamClientInterceptor {
+	pairs := metadataPairs(md)
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(metadata.AppendToOutgoingContext(ctx, pairs...), desc, cc, method, opts...)
+	}
+}
+
+func metadataPairs(md map[string]string) []string {
+	pairs := make([]string, 0, 2*len(md))
+	for k, v := range md {
+		pairs = append(pairs, k, v)
+	}
+	return pairs
+}
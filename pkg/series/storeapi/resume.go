@@ -0,0 +1,175 @@
+package storeapi
+
+import (
+	"context"
+	"io"
+	"math"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/thanos-community/obslytics/pkg/series"
+	"github.com/thanos-io/thanos/pkg/store/labelpb"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// resumableStream reopens the Series call failed with a recoverable error, e.g. a connection reset, including the
+// initial open. StoreAPI cannot resume a call from a given series, but the stores send the series sorted by their
+// labels, possibly splitting a long series into consecutive responses. So the rest of the series received last is read
+// first, by the request narrowed to its labels and to the time after the last chunk received, and then the call is
+// reopened with the request as given, skipping the series up to the last one received.
+type resumableStream struct {
+	ctx    context.Context
+	logger log.Logger
+	conf   series.ReconnectConfig
+	req    storepb.SeriesRequest
+	open   func(*storepb.SeriesRequest) (seriesStream, error)
+
+	cur seriesStream
+	// last are the labels of the series received last, lastT the max time of its chunks received.
+	last  labels.Labels
+	lastT int64
+	// tail is whether cur reads the rest of the last series, and complete whether the last series is read in full.
+	tail, complete bool
+	// skip is whether the series up to the last one are skipped, after the reconnect.
+	skip    bool
+	retries int
+	backoff time.Duration
+}
+
+func newResumableStream(ctx context.Context, logger log.Logger, conf series.ReconnectConfig, req *storepb.SeriesRequest, open func(*storepb.SeriesRequest) (seriesStream, error)) (*resumableStream, error) {
+	s := &resumableStream{ctx: ctx, logger: logger, conf: conf, req: *req, open: open, lastT: math.MinInt64, backoff: time.Duration(conf.MinBackoff)}
+	if err := s.reopen(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// reopen opens the next stream, retrying the recoverable errors.
+func (s *resumableStream) reopen() error {
+	for {
+		req := s.req
+		s.tail = s.last != nil && !s.complete && s.lastT != math.MinInt64 && s.lastT < s.req.MaxTime
+		if s.tail {
+			req.MinTime = s.lastT + 1
+			req.Matchers = append([]storepb.LabelMatcher(nil), s.req.Matchers...)
+			for _, l := range s.last {
+				req.Matchers = append(req.Matchers, storepb.LabelMatcher{Type: storepb.LabelMatcher_EQ, Name: l.Name, Value: l.Value})
+			}
+		}
+		cur, err := s.open(&req)
+		if err == nil {
+			s.cur, s.skip = cur, s.last != nil
+			return nil
+		}
+		if !s.retry(err) {
+			return err
+		}
+	}
+}
+
+func (s *resumableStream) Recv() (*storepb.SeriesResponse, error) {
+	for {
+		if s.cur == nil {
+			if err := s.reopen(); err != nil {
+				return nil, err
+			}
+		}
+
+		resp, err := s.cur.Recv()
+		if err == io.EOF && s.tail {
+			// The rest of the last series is read, the call is reopened for the following series.
+			s.cur, s.complete = nil, true
+			continue
+		}
+		if err == io.EOF {
+			return nil, err
+		}
+		if err != nil {
+			_ = s.cur.CloseSend()
+			s.cur = nil
+			if s.retry(err) {
+				continue
+			}
+			return nil, err
+		}
+
+		ser := resp.GetSeries()
+		if ser == nil {
+			// Warnings and hints are received before the reconnect already.
+			if s.skip {
+				continue
+			}
+			return resp, nil
+		}
+		lset := labelpb.ZLabelsToPromLabels(ser.Labels)
+		switch {
+		case s.tail:
+			// Stores match the labels of the longer series too, and send the chunks overlapping the narrowed range.
+			if !labels.Equal(lset, s.last) {
+				continue
+			}
+			chunks := ser.Chunks[:0]
+			for _, c := range ser.Chunks {
+				if c.MaxTime > s.lastT {
+					chunks = append(chunks, c)
+				}
+			}
+			if ser.Chunks = chunks; len(chunks) == 0 {
+				continue
+			}
+		case s.skip && labels.Compare(lset, s.last) <= 0:
+			continue
+		}
+		s.skip = false
+
+		if !labels.Equal(lset, s.last) {
+			s.last, s.lastT, s.complete = lset, math.MinInt64, false
+		}
+		for _, c := range ser.Chunks {
+			if c.MaxTime > s.lastT {
+				s.lastT = c.MaxTime
+			}
+		}
+		return resp, nil
+	}
+}
+
+// retry waits before the next reconnect, if the error is recoverable and the retries are not exhausted.
+func (s *resumableStream) retry(err error) bool {
+	if !recoverable(err) || s.retries >= s.conf.MaxRetries || s.ctx.Err() != nil {
+		return false
+	}
+	s.retries++
+	level.Warn(s.logger).Log("msg", "series stream failed, reconnecting", "err", err, "retry", s.retries, "last_series", s.last, "backoff", s.backoff)
+	select {
+	case <-s.ctx.Done():
+		return false
+	case <-time.After(s.backoff):
+	}
+	s.backoff *= 2
+	if s.backoff > time.Duration(s.conf.MaxBackoff) {
+		s.backoff = time.Duration(s.conf.MaxBackoff)
+	}
+	return true
+}
+
+func (s *resumableStream) CloseSend() error {
+	if s.cur == nil {
+		return nil
+	}
+	return s.cur.CloseSend()
+}
+
+// recoverable returns whether the stream failure is likely transient, e.g. the connection was lost or reset. The
+// exceeded deadline is of the stream timeout, the read is not retried once its own context is done.
+func recoverable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.Aborted, codes.Internal, codes.DeadlineExceeded:
+		return true
+	}
+	return false
+}
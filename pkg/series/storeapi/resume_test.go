@@ -0,0 +1,189 @@
+package storeapi
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/common/model"
+	"github.com/thanos-community/obslytics/pkg/series"
+	"github.com/thanos-io/thanos/pkg/store/labelpb"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"github.com/thanos-io/thanos/pkg/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// flakyStoreServer fails the Series calls after sending failAfter series, as many times as failures. The series are
+// selected by the equal matchers and by their chunks overlapping the requested range, if they have any.
+type flakyStoreServer struct {
+	storepb.StoreServer
+
+	series    []storepb.Series
+	failAfter int
+	failures  int
+	requests  []storepb.SeriesRequest
+}
+
+func (s *flakyStoreServer) Series(req *storepb.SeriesRequest, srv storepb.Store_SeriesServer) error {
+	s.requests = append(s.requests, *req)
+	sent := 0
+	for _, ser := range s.series {
+		if !matches(req, ser) {
+			continue
+		}
+		if sent == s.failAfter && s.failures > 0 {
+			s.failures--
+			return status.Error(codes.Unavailable, "connection reset")
+		}
+		ser := ser
+		if err := srv.Send(storepb.NewSeriesResponse(&ser)); err != nil {
+			return err
+		}
+		sent++
+	}
+	return nil
+}
+
+func matches(req *storepb.SeriesRequest, ser storepb.Series) bool {
+	lset := labelpb.ZLabelsToPromLabels(ser.Labels)
+	for _, m := range req.Matchers {
+		if m.Type == storepb.LabelMatcher_EQ && lset.Get(m.Name) != m.Value {
+			return false
+		}
+	}
+	if len(ser.Chunks) == 0 {
+		return true
+	}
+	for _, c := range ser.Chunks {
+		if c.MaxTime >= req.MinTime && c.MinTime <= req.MaxTime {
+			return true
+		}
+	}
+	return false
+}
+
+func testSeries(name string, chunks ...int64) storepb.Series {
+	ser := storepb.Series{Labels: []labelpb.ZLabel{{Name: "__name__", Value: name}}}
+	for i := 0; i+1 < len(chunks); i += 2 {
+		ser.Chunks = append(ser.Chunks, storepb.AggrChunk{MinTime: chunks[i], MaxTime: chunks[i+1]})
+	}
+	return ser
+}
+
+func TestSeries_Reconnect(t *testing.T) {
+	store := &flakyStoreServer{series: []storepb.Series{testSeries("a"), testSeries("b"), testSeries("c"), testSeries("d")}, failAfter: 2}
+	srv := grpc.NewServer()
+	storepb.RegisterStoreServer(srv, store)
+
+	l, err := net.Listen("tcp", "localhost:0")
+	testutil.Ok(t, err)
+	go func() { _ = srv.Serve(l) }()
+	defer srv.Stop()
+
+	read := func(reconnect series.ReconnectConfig) ([]string, error) {
+		store.requests = nil
+		s, err := NewSeries(log.NewNopLogger(), series.Config{Endpoint: l.Addr().String(), Reconnect: reconnect})
+		testutil.Ok(t, err)
+		set, err := s.Read(context.Background(), series.Params{MinTime: time.Unix(0, 0), MaxTime: time.Unix(60, 0)})
+		testutil.Ok(t, err)
+		defer func() { testutil.Ok(t, set.Close()) }()
+
+		var got []string
+		for set.Next() {
+			got = append(got, set.At().Labels().Get("__name__"))
+		}
+		return got, set.Err()
+	}
+	reconnect := series.ReconnectConfig{MaxRetries: 2, MinBackoff: model.Duration(time.Millisecond)}
+
+	// Not reconnected by default.
+	store.failures = 1
+	_, err = read(series.ReconnectConfig{})
+	testutil.Equals(t, codes.Unavailable, status.Code(err))
+
+	// Series received before the failure are emitted once.
+	store.failures = 2
+	got, err := read(reconnect)
+	testutil.Ok(t, err)
+	testutil.Equals(t, []string{"a", "b", "c", "d"}, got)
+	testutil.Equals(t, 3, len(store.requests))
+
+	// Retries are exhausted.
+	store.failures = 3
+	_, err = read(reconnect)
+	testutil.Equals(t, codes.Unavailable, status.Code(err))
+	testutil.Equals(t, 3, len(store.requests))
+	store.failures = 0
+
+	// The rest of the series received last is read with the narrowed range, before the following series.
+	longer := testSeries("a", 10, 19)
+	longer.Labels = append(longer.Labels, labelpb.ZLabel{Name: "x", Value: "1"})
+	store.series = []storepb.Series{testSeries("a", 0, 9), testSeries("a", 10, 19), longer, testSeries("b", 0, 19)}
+	store.failAfter, store.failures, store.requests = 1, 1, nil
+	conn, err := grpc.Dial(l.Addr().String(), grpc.WithInsecure())
+	testutil.Ok(t, err)
+	defer conn.Close()
+	req := &storepb.SeriesRequest{MinTime: 0, MaxTime: 60}
+	rs, err := newResumableStream(context.Background(), log.NewNopLogger(), reconnect, req, func(req *storepb.SeriesRequest) (seriesStream, error) {
+		return storepb.NewStoreClient(conn).Series(context.Background(), req)
+	})
+	testutil.Ok(t, err)
+	var recv []string
+	for {
+		resp, err := rs.Recv()
+		if err == io.EOF {
+			break
+		}
+		testutil.Ok(t, err)
+		ser := resp.GetSeries()
+		recv = append(recv, fmt.Sprintf("%s %v", labelpb.ZLabelsToPromLabels(ser.Labels), ser.Chunks))
+	}
+	testutil.Equals(t, []string{
+		fmt.Sprintf(`{__name__="a"} %v`, testSeries("a", 0, 9).Chunks),
+		fmt.Sprintf(`{__name__="a"} %v`, testSeries("a", 10, 19).Chunks),
+		fmt.Sprintf(`{__name__="a", x="1"} %v`, longer.Chunks),
+		fmt.Sprintf(`{__name__="b"} %v`, testSeries("b", 0, 19).Chunks),
+	}, recv)
+	testutil.Equals(t, 3, len(store.requests))
+	testutil.Equals(t, int64(10), store.requests[1].MinTime)
+	testutil.Equals(t, []storepb.LabelMatcher{{Type: storepb.LabelMatcher_EQ, Name: "__name__", Value: "a"}}, store.requests[1].Matchers)
+	testutil.Equals(t, *req, store.requests[2])
+}
+
+func TestResumableStream_Open(t *testing.T) {
+	reconnect := series.ReconnectConfig{MaxRetries: 2, MinBackoff: model.Duration(time.Millisecond)}
+	opens := 0
+	open := func(fail int, code codes.Code) func(*storepb.SeriesRequest) (seriesStream, error) {
+		opens = 0
+		return func(*storepb.SeriesRequest) (seriesStream, error) {
+			if opens++; opens <= fail {
+				return nil, status.Error(code, "open failed")
+			}
+			return emptyStream{}, nil
+		}
+	}
+
+	// The failed initial open is retried.
+	_, err := newResumableStream(context.Background(), log.NewNopLogger(), reconnect, &storepb.SeriesRequest{}, open(2, codes.Unavailable))
+	testutil.Ok(t, err)
+	testutil.Equals(t, 3, opens)
+
+	_, err = newResumableStream(context.Background(), log.NewNopLogger(), reconnect, &storepb.SeriesRequest{}, open(3, codes.Unavailable))
+	testutil.Equals(t, codes.Unavailable, status.Code(err))
+
+	// Unrecoverable errors are not retried.
+	_, err = newResumableStream(context.Background(), log.NewNopLogger(), reconnect, &storepb.SeriesRequest{}, open(1, codes.InvalidArgument))
+	testutil.Equals(t, codes.InvalidArgument, status.Code(err))
+	testutil.Equals(t, 1, opens)
+}
+
+type emptyStream struct{}
+
+func (emptyStream) Recv() (*storepb.SeriesResponse, error) { return nil, io.EOF }
+func (emptyStream) CloseSend() error                       { return nil }
@@ -3,114 +3,253 @@ package storeapi
 import (
 	"context"
 	"io"
+	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/pkg/errors"
-	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/storage"
 	"github.com/thanos-community/obslytics/pkg/series"
-	"github.com/thanos-io/thanos/pkg/extgrpc"
 	"github.com/thanos-io/thanos/pkg/store/labelpb"
 	"github.com/thanos-io/thanos/pkg/store/storepb"
-	tracing "github.com/thanos-io/thanos/pkg/tracing/client"
 	"google.golang.org/grpc"
 )
 
 // Series implements input.Reader.
 type Series struct {
-	logger log.Logger
-	conf   series.Config
+	logger   log.Logger
+	conf     series.Config
+	limiter  *series.Limiter
+	dialOpts []grpc.DialOption
+	tokens   TokenSource
 }
 
-func NewSeries(logger log.Logger, conf series.Config) (Series, error) {
-	return Series{logger: logger, conf: conf}, nil
-}
-
-func (i Series) Read(ctx context.Context, params series.Params) (series.Set, error) {
-	// set as true for authenticated connection if cert, key and/or ca are defined.
-	secure := i.conf.TLSConfig.CertFile != "" ||
-		i.conf.TLSConfig.KeyFile != "" ||
-		i.conf.TLSConfig.CAFile != ""
-	dialOpts, err := extgrpc.StoreClientGRPCOpts(i.logger, nil, tracing.NoopTracer(),
-		secure,
-		i.conf.TLSConfig.InsecureSkipVerify,
-		i.conf.TLSConfig.CertFile,
-		i.conf.TLSConfig.KeyFile,
-		i.conf.TLSConfig.CAFile,
-		i.conf.Endpoint,
-	)
+// Option customizes Series.
+type Option func(*Series)
 
-	if err != nil {
-		return nil, errors.Wrap(err, "error initializing GRPC options")
+// WithDialOptions appends extra gRPC dial options (e.g. custom balancer or stats handler) to the ones
+// built from the configuration.
+func WithDialOptions(opts ...grpc.DialOption) Option {
+	return func(s *Series) {
+		s.dialOpts = append(s.dialOpts, opts...)
 	}
+}
 
-	conn, err := grpc.DialContext(ctx, i.conf.Endpoint, dialOpts...)
-	if err != nil {
-		return nil, errors.Wrap(err, "error initializing GRPC dial context")
+func NewSeries(logger log.Logger, conf series.Config, opts ...Option) (Series, error) {
+	if conf.BearerToken != "" && conf.BearerTokenFile != "" {
+		return Series{}, errors.New("bearer_token and bearer_token_file cannot be used together")
+	}
+	if conf.Reconnect.MaxRetries < 0 {
+		return Series{}, errors.New("reconnect max_retries cannot be negative")
 	}
+	if conf.StreamTimeout < 0 {
+		return Series{}, errors.New("stream_timeout cannot be negative")
+	}
+	if conf.Reconnect.MinBackoff == 0 {
+		conf.Reconnect.MinBackoff = model.Duration(100 * time.Millisecond)
+	}
+	if conf.Reconnect.MaxBackoff == 0 {
+		conf.Reconnect.MaxBackoff = model.Duration(10 * time.Second)
+	}
+	s := Series{logger: logger, conf: conf, limiter: series.NewLimiter(conf.RateLimit)}
+	switch {
+	case conf.BearerTokenFile != "":
+		s.tokens = fileTokenSource(conf.BearerTokenFile)
+	case conf.BearerToken != "":
+		token := conf.BearerToken
+		s.tokens = func(context.Context) (string, error) { return token, nil }
+	}
+	for _, o := range opts {
+		o(&s)
+	}
+	return s, nil
+}
 
-	matchers, err := storepb.PromMatchersToMatchers(params.Matchers...)
+func (i Series) Read(ctx context.Context, params series.Params) (_ series.Set, err error) {
+	if err := params.Validate(); err != nil {
+		return nil, err
+	}
+	if err := i.conf.OutOfOrder.Validate(); err != nil {
+		return nil, err
+	}
+	if err := i.conf.Duplicates.Validate(); err != nil {
+		return nil, err
+	}
+	if err := i.conf.DuplicateLabels.Validate(); err != nil {
+		return nil, err
+	}
+	if err := i.conf.Coalesce.Validate(); err != nil {
+		return nil, err
+	}
+	if err := i.conf.Tenant.Validate(); err != nil {
+		return nil, err
+	}
+	conn, err := i.dial(ctx)
 	if err != nil {
 		return nil, err
 	}
+	// The connection is closed by the returned set, or here if none is returned.
+	defer func() {
+		if err != nil {
+			_ = conn.Close()
+		}
+	}()
 
-	client := storepb.NewStoreClient(conn)
-	seriesClient, err := client.Series(ctx, &storepb.SeriesRequest{
-		MinTime:                 timestamp.FromTime(params.MinTime),
-		MaxTime:                 timestamp.FromTime(params.MaxTime),
+	// The store matchers are sent as given, without the round trip through the Prometheus matchers.
+	matchers := params.StoreMatchers
+	if len(matchers) == 0 {
+		matchers, err = storepb.PromMatchersToMatchers(params.Matchers...)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if m := i.conf.Tenant.Matcher(); m != nil {
+		matchers = append(matchers[:len(matchers):len(matchers)], storepb.LabelMatcher{Type: storepb.LabelMatcher_EQ, Name: m.Name, Value: m.Value})
+	}
+
+	mint, maxt := params.Range()
+	req := &storepb.SeriesRequest{
+		MinTime:                 mint,
+		MaxTime:                 maxt,
 		Matchers:                matchers,
 		PartialResponseStrategy: storepb.PartialResponseStrategy_ABORT,
-	})
-	if err != nil {
-		return nil, errors.Wrapf(err, "storepb.Series against %v", i.conf.Endpoint)
+		SkipChunks:              params.SkipChunks,
+	}
+
+	client := conn.storeClient()
+	if params.MaxResolution > 0 && !params.SkipChunks {
+		// Push the aggregation down to the store, if it can serve downsampled data. Stores without downsampled
+		// data for (a part of) the range keep sending raw chunks, which are then aggregated client-side.
+		res, err := i.downsampleResolution(ctx, client, params.MaxResolution)
+		if err != nil {
+			return nil, err
+		}
+		if res > 0 {
+			req.MaxResolutionWindow = res
+			req.Aggregates = rawOrAggregates
+		}
 	}
+	open := func(req *storepb.SeriesRequest) (seriesStream, error) {
+		if err := i.limiter.WaitRequest(ctx); err != nil {
+			return nil, err
+		}
+		if i.conf.StreamTimeout <= 0 {
+			return client.Series(ctx, req)
+		}
+		return openWithTimeout(ctx, time.Duration(i.conf.StreamTimeout), func(ctx context.Context) (seriesStream, error) {
+			return client.Series(ctx, req)
+		})
+	}
+	if i.conf.Reconnect.MaxRetries > 0 {
+		call := open
+		open = func(req *storepb.SeriesRequest) (seriesStream, error) {
+			return newResumableStream(ctx, i.logger, i.conf.Reconnect, req, call)
+		}
+	}
+
+	var stream seriesStream
+	if i.conf.SeriesBatch.Size > 0 {
+		batches, err := i.batchMatchers(ctx, client, req)
+		if err != nil {
+			return nil, err
+		}
+		if batches != nil {
+			stream = &batchedStream{open: open, req: *req, batches: batches}
+		}
+	}
+	if stream == nil {
+		if stream, err = open(req); err != nil {
+			return nil, errors.Wrapf(err, "storepb.Series against %v", i.conf.Endpoint)
+		}
+	}
+
+	mint, maxt = params.Bounds()
+	s := series.NewOrderedSet(i.logger, &iterator{
+		ctx:      ctx,
+		conn:     conn,
+		client:   stream,
+		limiter:  i.limiter,
+		mint:     mint,
+		maxt:     maxt,
+		snapshot: params.Snapshot,
 
-	return &iterator{
-		ctx:    ctx,
-		conn:   conn,
-		client: seriesClient,
-		mint:   timestamp.FromTime(params.MinTime),
-		maxt:   timestamp.FromTime(params.MaxTime),
-	}, nil
+		logger:     i.logger,
+		outOfOrder: i.conf.OutOfOrder,
+	}, i.conf.OutOfOrder, i.conf.Duplicates, i.conf.SortBuffer)
+	s = series.NewCoalescedSet(s, i.conf.Coalesce)
+	s = series.NewLabelsChecker(i.logger, i.conf.DuplicateLabels).Set(s)
+	return series.NewTenantSet(s, i.conf.Tenant), nil
 }
 
+// rawOrAggregates prefers raw chunks, downsampled ones are read as averages of their count and sum, along with the
+// count, sum, min and max of their raw samples.
+var rawOrAggregates = []storepb.Aggr{storepb.Aggr_COUNT, storepb.Aggr_SUM, storepb.Aggr_MIN, storepb.Aggr_MAX}
+
 // iterator implements input.Set.
 type iterator struct {
 	ctx           context.Context
-	conn          *grpc.ClientConn
-	client        storepb.Store_SeriesClient
+	conn          clientConn
+	client        seriesStream
+	limiter       *series.Limiter
 	currentSeries *storepb.Series
 
 	mint, maxt int64
+	snapshot   bool
+
+	logger     log.Logger
+	outOfOrder series.OutOfOrderPolicy
 
-	err error
+	warnings storage.Warnings
+	err      error
 }
 
 func (i *iterator) Next() bool {
-	seriesResp, err := i.client.Recv()
-	if err == io.EOF {
-		return false
-	}
-	if err != nil {
-		i.err = err
-		return false
-	}
+	for {
+		seriesResp, err := i.client.Recv()
+		if err == io.EOF {
+			return false
+		}
+		if err != nil {
+			i.err = err
+			return false
+		}
+		if err := i.limiter.WaitBytes(i.ctx, seriesResp.Size()); err != nil {
+			i.err = err
+			return false
+		}
 
-	i.currentSeries = seriesResp.GetSeries()
-	return true
+		if w := seriesResp.GetWarning(); w != "" {
+			i.warnings = append(i.warnings, errors.New(w))
+			continue
+		}
+		// Hints are skipped.
+		if i.currentSeries = seriesResp.GetSeries(); i.currentSeries != nil {
+			return true
+		}
+	}
 }
 
 func (i *iterator) At() storage.Series {
-	// We support only raw data for now.
-	return newChunkSeries(
-		labelpb.ZLabelsToPromLabels(i.currentSeries.Labels),
-		i.currentSeries.Chunks,
-		i.mint, i.maxt,
-		[]storepb.Aggr{storepb.Aggr_COUNT, storepb.Aggr_SUM},
-	)
+	// Labels are converted without copying, they reference the memory of the received message.
+	lset := labelpb.ZLabelsToPromLabels(i.currentSeries.Labels)
+	chunks, err := orderChunks(i.logger, lset, i.currentSeries.Chunks, i.outOfOrder)
+	if err != nil {
+		return errSeries{lset: lset, err: err}
+	}
+	if i.snapshot {
+		chunks = tailChunks(chunks, i.maxt)
+	}
+
+	s := newChunkSeries(lset, chunks, i.mint, i.maxt, rawOrAggregates)
+	if i.snapshot {
+		return series.LatestSeries{Series: s}
+	}
+	return s
 }
 
-func (i *iterator) Warnings() storage.Warnings { return nil }
+// Warnings returns the warnings received so far, e.g. of the stores failed to respond while partial responses are
+// allowed.
+func (i *iterator) Warnings() storage.Warnings { return i.warnings }
 
 func (i *iterator) Err() error {
 	return i.err
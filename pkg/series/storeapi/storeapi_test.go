@@ -0,0 +1,351 @@
+package storeapi
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/thanos-community/obslytics/pkg/series"
+	"github.com/thanos-community/obslytics/pkg/series/storeapi/storetest"
+	"github.com/thanos-io/thanos/pkg/store/labelpb"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"github.com/thanos-io/thanos/pkg/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/metadata"
+)
+
+// metadataStoreServer records the received Series requests and their metadata.
+type metadataStoreServer struct {
+	storepb.StoreServer
+
+	req *storepb.SeriesRequest
+	md  metadata.MD
+}
+
+func (s *metadataStoreServer) Series(req *storepb.SeriesRequest, srv storepb.Store_SeriesServer) error {
+	s.req = req
+	s.md, _ = metadata.FromIncomingContext(srv.Context())
+	return nil
+}
+
+func TestSeries_Metadata(t *testing.T) {
+	srv := grpc.NewServer()
+	store := &metadataStoreServer{}
+	storepb.RegisterStoreServer(srv, store)
+
+	l, err := net.Listen("tcp", "localhost:0")
+	testutil.Ok(t, err)
+	go func() { _ = srv.Serve(l) }()
+	defer srv.Stop()
+
+	s, err := NewSeries(log.NewNopLogger(), series.Config{
+		Endpoint: l.Addr().String(),
+		Metadata: map[string]string{"job": "backfill", "run-id": "42"},
+	})
+	testutil.Ok(t, err)
+
+	set, err := s.Read(context.Background(), series.Params{MinTime: time.Unix(0, 0), MaxTime: time.Unix(60, 0)})
+	testutil.Ok(t, err)
+	for set.Next() {
+	}
+	testutil.Ok(t, set.Err())
+	testutil.Ok(t, set.Close())
+
+	testutil.Equals(t, []string{"backfill"}, store.md.Get("job"))
+	testutil.Equals(t, []string{"42"}, store.md.Get("run-id"))
+	ua := store.md.Get("user-agent")
+	testutil.Equals(t, 1, len(ua))
+	testutil.Assert(t, strings.HasPrefix(ua[0], "obslytics/"), "unexpected user agent %q", ua[0])
+}
+
+func TestSeries_StoreMatchers(t *testing.T) {
+	srv := grpc.NewServer()
+	store := &metadataStoreServer{}
+	storepb.RegisterStoreServer(srv, store)
+
+	l, err := net.Listen("tcp", "localhost:0")
+	testutil.Ok(t, err)
+	go func() { _ = srv.Serve(l) }()
+	defer srv.Stop()
+
+	s, err := NewSeries(log.NewNopLogger(), series.Config{Endpoint: l.Addr().String()})
+	testutil.Ok(t, err)
+	read := func(params series.Params) {
+		params.MinTime, params.MaxTime = time.Unix(0, 0), time.Unix(60, 0)
+		set, err := s.Read(context.Background(), params)
+		testutil.Ok(t, err)
+		for set.Next() {
+		}
+		testutil.Ok(t, set.Err())
+		testutil.Ok(t, set.Close())
+	}
+
+	// The store matchers are sent as given, the Prometheus matchers are translated.
+	ms := []storepb.LabelMatcher{{Type: storepb.LabelMatcher_RE, Name: "job", Value: "a|b"}}
+	read(series.Params{StoreMatchers: ms})
+	testutil.Equals(t, ms, store.req.Matchers)
+
+	read(series.Params{Matchers: []*labels.Matcher{labels.MustNewMatcher(labels.MatchRegexp, "job", "a|b")}})
+	testutil.Equals(t, ms, store.req.Matchers)
+}
+
+func TestSeries_InvalidTimeRange(t *testing.T) {
+	s, err := NewSeries(log.NewNopLogger(), series.Config{Endpoint: "localhost:1"})
+	testutil.Ok(t, err)
+	_, err = s.Read(context.Background(), series.Params{MinTime: time.Unix(60, 0), MaxTime: time.Unix(0, 0)})
+	testutil.NotOk(t, err)
+	testutil.Assert(t, strings.HasPrefix(err.Error(), "invalid time range"), err.Error())
+}
+
+func TestSeries_UnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storeapi-uds")
+	testutil.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	srv := grpc.NewServer()
+	storepb.RegisterStoreServer(srv, &seriesStoreServer{series: []*storepb.Series{{
+		Labels: []labelpb.ZLabel{{Name: "__name__", Value: "up"}},
+	}}})
+	socket := filepath.Join(dir, "store.sock")
+	l, err := net.Listen("unix", socket)
+	testutil.Ok(t, err)
+	go func() { _ = srv.Serve(l) }()
+	defer srv.Stop()
+
+	s, err := NewSeries(log.NewNopLogger(), series.Config{Endpoint: "unix://" + socket})
+	testutil.Ok(t, err)
+	set, err := s.Read(context.Background(), series.Params{MinTime: time.Unix(0, 0), MaxTime: time.Unix(60, 0)})
+	testutil.Ok(t, err)
+	testutil.Assert(t, set.Next())
+	testutil.Equals(t, `{__name__="up"}`, set.At().Labels().String())
+	testutil.Assert(t, !set.Next())
+	testutil.Ok(t, set.Err())
+	testutil.Ok(t, set.Close())
+}
+
+// seriesStoreServer sends the series responses.
+type seriesStoreServer struct {
+	storepb.StoreServer
+
+	series []*storepb.Series
+}
+
+func (s *seriesStoreServer) Series(_ *storepb.SeriesRequest, srv storepb.Store_SeriesServer) error {
+	for _, ser := range s.series {
+		if err := srv.Send(storepb.NewSeriesResponse(ser)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestSeries_OutOfOrderChunks(t *testing.T) {
+	srv := grpc.NewServer()
+	storepb.RegisterStoreServer(srv, &seriesStoreServer{series: []*storepb.Series{{
+		Labels: []labelpb.ZLabel{{Name: "__name__", Value: "up"}},
+		// The chunks are sent out of order, e.g. as merged from multiple endpoints.
+		Chunks: []storepb.AggrChunk{
+			rawChunk(t, sample{40, 4}, sample{50, 5}),
+			rawChunk(t, sample{10, 1}, sample{20, 2}, sample{50, 6}, sample{60, 7}),
+		},
+	}}})
+
+	l, err := net.Listen("tcp", "localhost:0")
+	testutil.Ok(t, err)
+	go func() { _ = srv.Serve(l) }()
+	defer srv.Stop()
+
+	read := func(policy series.OutOfOrderPolicy) ([]sample, error) {
+		s, err := NewSeries(log.NewNopLogger(), series.Config{Endpoint: l.Addr().String(), OutOfOrder: policy})
+		testutil.Ok(t, err)
+		set, err := s.Read(context.Background(), series.Params{MinTime: time.Unix(0, 0), MaxTime: time.Unix(60, 0)})
+		if err != nil {
+			return nil, err
+		}
+		defer set.Close()
+
+		testutil.Assert(t, set.Next())
+		var ret []sample
+		it := set.At().Iterator()
+		for it.Next() {
+			ts, v := it.At()
+			ret = append(ret, sample{t: ts, v: v})
+		}
+		return ret, it.Err()
+	}
+
+	_, err = read("")
+	testutil.NotOk(t, err)
+	testutil.Assert(t, strings.Contains(err.Error(), "out-of-order chunks"), err.Error())
+
+	// Once sorted, overlapping samples of the later chunk are skipped as usual.
+	got, err := read(series.OutOfOrderSort)
+	testutil.Ok(t, err)
+	testutil.Equals(t, []sample{{10, 1}, {20, 2}, {50, 6}, {60, 7}}, got)
+
+	got, err = read(series.OutOfOrderDrop)
+	testutil.Ok(t, err)
+	testutil.Equals(t, []sample{{40, 4}, {50, 5}, {60, 7}}, got)
+
+	_, err = read("ignore")
+	testutil.NotOk(t, err)
+}
+
+func TestSeries_ChunkEncodings(t *testing.T) {
+	samples := make([]storetest.Sample, 0, 200)
+	for i := int64(0); i < 200; i++ {
+		samples = append(samples, storetest.Sample{T: i * 15000, V: float64(i)})
+	}
+	store := &storetest.Store{Fixtures: []*storepb.Series{
+		storetest.NewSeries(labels.FromStrings("__name__", "up", "job", "a"), samples...),
+		storetest.NewSeries(labels.FromStrings("__name__", "up", "job", "b"), samples[:10]...),
+	}}
+	in, err := NewSeries(log.NewNopLogger(), series.Config{Endpoint: storetest.Serve(t, store)})
+	testutil.Ok(t, err)
+	params := series.Params{MinTime: time.Unix(0, 0), MaxTime: time.Unix(3600, 0)}
+
+	set, err := in.Read(context.Background(), params)
+	testutil.Ok(t, err)
+	counts, err := series.CountByEncoding(set)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, len(counts))
+	testutil.Equals(t, "up", counts[0].Name)
+	testutil.Equals(t, "XOR", counts[0].Encoding)
+	testutil.Equals(t, 2, counts[0].Series)
+	testutil.Equals(t, 3, counts[0].Chunks)
+	testutil.Assert(t, counts[0].Bytes > 0)
+
+	// The encodings are exposed through the series wrappers, e.g. of snapshots.
+	params.Snapshot = true
+	set, err = in.Read(context.Background(), params)
+	testutil.Ok(t, err)
+	testutil.Assert(t, set.Next())
+	encodings, ok := series.ChunkEncodingsOf(set.At())
+	testutil.Assert(t, ok)
+	testutil.Equals(t, 1, len(encodings))
+	testutil.Equals(t, 1, encodings[0].Chunks)
+	testutil.Ok(t, set.Close())
+}
+
+func TestSeries_DuplicateLabels(t *testing.T) {
+	srv := grpc.NewServer()
+	storepb.RegisterStoreServer(srv, &seriesStoreServer{series: []*storepb.Series{{
+		// A malformed series of a faulty store.
+		Labels: []labelpb.ZLabel{{Name: "__name__", Value: "up"}, {Name: "job", Value: "a"}, {Name: "job", Value: "b"}},
+		Chunks: []storepb.AggrChunk{rawChunk(t, sample{10, 1})},
+	}}})
+
+	l, err := net.Listen("tcp", "localhost:0")
+	testutil.Ok(t, err)
+	go func() { _ = srv.Serve(l) }()
+	defer srv.Stop()
+
+	read := func(policy series.DuplicateLabelsPolicy) (labels.Labels, error) {
+		s, err := NewSeries(log.NewNopLogger(), series.Config{Endpoint: l.Addr().String(), DuplicateLabels: policy})
+		testutil.Ok(t, err)
+		set, err := s.Read(context.Background(), series.Params{MinTime: time.Unix(0, 0), MaxTime: time.Unix(60, 0)})
+		if err != nil {
+			return nil, err
+		}
+		defer set.Close()
+
+		if !set.Next() {
+			return nil, set.Err()
+		}
+		return set.At().Labels(), nil
+	}
+
+	_, err = read("")
+	testutil.NotOk(t, err)
+	testutil.Assert(t, strings.Contains(err.Error(), `duplicate label name "job"`), err.Error())
+
+	got, err := read(series.DuplicateLabelsKeepFirst)
+	testutil.Ok(t, err)
+	testutil.Equals(t, labels.FromStrings("__name__", "up", "job", "a"), got)
+
+	got, err = read(series.DuplicateLabelsKeepLast)
+	testutil.Ok(t, err)
+	testutil.Equals(t, labels.FromStrings("__name__", "up", "job", "b"), got)
+
+	_, err = read("ignore")
+	testutil.NotOk(t, err)
+}
+
+func TestSeries_Tenant(t *testing.T) {
+	conf := series.TenantConfig{Label: "tenant", Value: "a"}
+	params := series.Params{
+		Matchers: []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "__name__", "up")},
+		MinTime:  time.Unix(0, 0),
+		MaxTime:  time.Unix(60, 0),
+	}
+	read := func(endpoint string) ([]labels.Labels, error) {
+		s, err := NewSeries(log.NewNopLogger(), series.Config{Endpoint: endpoint, Tenant: conf})
+		testutil.Ok(t, err)
+		set, err := s.Read(context.Background(), params)
+		if err != nil {
+			return nil, err
+		}
+		defer set.Close()
+
+		var got []labels.Labels
+		for set.Next() {
+			got = append(got, set.At().Labels())
+		}
+		return got, set.Err()
+	}
+
+	// The tenant matcher is added to the request.
+	store := &storetest.Store{Fixtures: []*storepb.Series{
+		storetest.NewSeries(labels.FromStrings("__name__", "up", "tenant", "a"), storetest.Sample{T: 10000, V: 1}),
+		storetest.NewSeries(labels.FromStrings("__name__", "up", "tenant", "b"), storetest.Sample{T: 10000, V: 2}),
+	}}
+	got, err := read(storetest.Serve(t, store))
+	testutil.Ok(t, err)
+	testutil.Equals(t, []labels.Labels{labels.FromStrings("__name__", "up", "tenant", "a")}, got)
+	testutil.Equals(t, []storepb.LabelMatcher{
+		{Type: storepb.LabelMatcher_EQ, Name: "__name__", Value: "up"},
+		{Type: storepb.LabelMatcher_EQ, Name: "tenant", Value: "a"},
+	}, store.Requests()[0].Matchers)
+
+	// The series of the other tenants returned regardless of the matchers fail the read.
+	srv := grpc.NewServer()
+	storepb.RegisterStoreServer(srv, &seriesStoreServer{series: []*storepb.Series{
+		{Labels: []labelpb.ZLabel{{Name: "__name__", Value: "up"}, {Name: "tenant", Value: "a"}}, Chunks: []storepb.AggrChunk{rawChunk(t, sample{10, 1})}},
+		{Labels: []labelpb.ZLabel{{Name: "__name__", Value: "up"}, {Name: "tenant", Value: "b"}}, Chunks: []storepb.AggrChunk{rawChunk(t, sample{10, 2})}},
+	}})
+	l, err := net.Listen("tcp", "localhost:0")
+	testutil.Ok(t, err)
+	go func() { _ = srv.Serve(l) }()
+	defer srv.Stop()
+
+	_, err = read(l.Addr().String())
+	testutil.NotOk(t, err)
+	testutil.Assert(t, strings.Contains(err.Error(), "cross-tenant"), err.Error())
+}
+
+func TestSeries_ClosesConnOnError(t *testing.T) {
+	endpoint := storetest.Serve(t, &storetest.Store{PartialErr: errors.New("store down")})
+
+	var cc *grpc.ClientConn
+	capture := grpc.WithChainUnaryInterceptor(func(ctx context.Context, method string, req, reply interface{}, c *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		cc = c
+		return invoker(ctx, method, req, reply, c, opts...)
+	})
+	s, err := NewSeries(log.NewNopLogger(), series.Config{Endpoint: endpoint, SeriesBatch: series.SeriesBatchConfig{Size: 1}}, WithDialOptions(capture))
+	testutil.Ok(t, err)
+
+	// The batches are not listed, no set is returned to close the connection.
+	_, err = s.Read(context.Background(), series.Params{MinTime: time.Unix(0, 0), MaxTime: time.Unix(60, 0)})
+	testutil.NotOk(t, err)
+	testutil.Assert(t, cc != nil, "expected the LabelValues call")
+	testutil.Equals(t, connectivity.Shutdown, cc.GetState())
+}
@@ -0,0 +1,253 @@
+package storetest
+
+import (
+	"context"
+	"math"
+	"net"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/thanos-io/thanos/pkg/store/labelpb"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Compile-time check if Store implements storepb.StoreServer interface.
+var _ storepb.StoreServer = &Store{}
+
+// samplesPerChunk is the number of samples of the fixture chunks, the same as of the chunks written by Prometheus.
+const samplesPerChunk = 120
+
+// Sample is a sample of the fixture series.
+type Sample struct {
+	T int64
+	V float64
+}
+
+// NewSeries returns the fixture series of the samples, sorted by timestamp, in raw XOR chunks.
+func NewSeries(lset labels.Labels, samples ...Sample) *storepb.Series {
+	s := &storepb.Series{Labels: labelpb.ZLabelsFromPromLabels(lset)}
+	for len(samples) > 0 {
+		n := samplesPerChunk
+		if n > len(samples) {
+			n = len(samples)
+		}
+		s.Chunks = append(s.Chunks, rawChunk(samples[:n]))
+		samples = samples[n:]
+	}
+	return s
+}
+
+func rawChunk(samples []Sample) storepb.AggrChunk {
+	c := chunkenc.NewXORChunk()
+	a, err := c.Appender()
+	if err != nil {
+		// Appending to a new chunk cannot fail.
+		panic(err)
+	}
+	for _, s := range samples {
+		a.Append(s.T, s.V)
+	}
+	return storepb.AggrChunk{
+		MinTime: samples[0].T,
+		MaxTime: samples[len(samples)-1].T,
+		Raw:     &storepb.Chunk{Type: storepb.Chunk_XOR, Data: c.Bytes()},
+	}
+}
+
+// Store implements storepb.StoreServer over the fixture series, e.g. to test the StoreAPI input end-to-end without a
+// Thanos store. The series matching the request matchers with chunks overlapping the request time range are sent
+// sorted by labels, with all their overlapping chunks, as the stores do. Series without chunks are sent regardless of
+// the time range. The downsampling options of the requests are ignored, the raw chunks are sent.
+type Store struct {
+	// Fixtures are the series of the store, e.g. created by NewSeries.
+	Fixtures []*storepb.Series
+	// ExternalLabels are the labels of the store returned by Info.
+	ExternalLabels labels.Labels
+	// StoreType is the type of the store returned by Info, STORE if unset.
+	StoreType storepb.StoreType
+	// Warnings are sent after the series.
+	Warnings []string
+	// PartialErr, if set, is the failure of a part of the data, e.g. of a store behind a querier. With the partial
+	// response strategy ABORT, the calls fail with it, with WARN it is sent as a warning after the series.
+	PartialErr error
+
+	mtx      sync.Mutex
+	requests []*storepb.SeriesRequest
+}
+
+// Requests returns the Series requests received so far, in order.
+func (s *Store) Requests() []*storepb.SeriesRequest {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return append([]*storepb.SeriesRequest(nil), s.requests...)
+}
+
+func (s *Store) Info(context.Context, *storepb.InfoRequest) (*storepb.InfoResponse, error) {
+	resp := &storepb.InfoResponse{
+		Labels:    labelpb.ZLabelsFromPromLabels(s.ExternalLabels),
+		MinTime:   math.MaxInt64,
+		MaxTime:   math.MinInt64,
+		StoreType: s.StoreType,
+	}
+	if resp.StoreType == storepb.StoreType_UNKNOWN {
+		resp.StoreType = storepb.StoreType_STORE
+	}
+	if len(s.ExternalLabels) > 0 {
+		resp.LabelSets = []labelpb.ZLabelSet{{Labels: resp.Labels}}
+	}
+	for _, ser := range s.Fixtures {
+		for _, c := range ser.Chunks {
+			if c.MinTime < resp.MinTime {
+				resp.MinTime = c.MinTime
+			}
+			if c.MaxTime > resp.MaxTime {
+				resp.MaxTime = c.MaxTime
+			}
+		}
+	}
+	if resp.MinTime > resp.MaxTime {
+		resp.MinTime, resp.MaxTime = math.MinInt64, math.MaxInt64
+	}
+	return resp, nil
+}
+
+func (s *Store) Series(req *storepb.SeriesRequest, srv storepb.Store_SeriesServer) error {
+	s.mtx.Lock()
+	r := *req
+	s.requests = append(s.requests, &r)
+	s.mtx.Unlock()
+
+	warnings, err := s.partial(req.PartialResponseStrategy)
+	if err != nil {
+		return err
+	}
+	ms, err := storepb.MatchersToPromMatchers(req.Matchers...)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	for _, ser := range s.selected(ms, req.MinTime, req.MaxTime) {
+		if req.SkipChunks {
+			ser.Chunks = nil
+		}
+		if err := srv.Send(storepb.NewSeriesResponse(ser)); err != nil {
+			return err
+		}
+	}
+	for _, w := range warnings {
+		if err := srv.Send(storepb.NewWarnSeriesResponse(errors.New(w))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) LabelNames(_ context.Context, req *storepb.LabelNamesRequest) (*storepb.LabelNamesResponse, error) {
+	warnings, err := s.partial(req.PartialResponseStrategy)
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]struct{}{}
+	for _, ser := range s.selected(nil, req.Start, req.End) {
+		for _, l := range ser.Labels {
+			names[l.Name] = struct{}{}
+		}
+	}
+	return &storepb.LabelNamesResponse{Names: sortedKeys(names), Warnings: warnings}, nil
+}
+
+func (s *Store) LabelValues(_ context.Context, req *storepb.LabelValuesRequest) (*storepb.LabelValuesResponse, error) {
+	warnings, err := s.partial(req.PartialResponseStrategy)
+	if err != nil {
+		return nil, err
+	}
+	ms, err := storepb.MatchersToPromMatchers(req.Matchers...)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	values := map[string]struct{}{}
+	for _, ser := range s.selected(ms, req.Start, req.End) {
+		if v := labelpb.ZLabelsToPromLabels(ser.Labels).Get(req.Label); v != "" {
+			values[v] = struct{}{}
+		}
+	}
+	return &storepb.LabelValuesResponse{Values: sortedKeys(values), Warnings: warnings}, nil
+}
+
+// partial returns the warnings to send by the strategy, or the error to fail with.
+func (s *Store) partial(strategy storepb.PartialResponseStrategy) ([]string, error) {
+	warnings := append([]string(nil), s.Warnings...)
+	if s.PartialErr == nil {
+		return warnings, nil
+	}
+	if strategy == storepb.PartialResponseStrategy_ABORT {
+		return nil, status.Error(codes.Aborted, s.PartialErr.Error())
+	}
+	return append(warnings, s.PartialErr.Error()), nil
+}
+
+// selected returns the copies of the series matching the matchers with their chunks overlapping the time range,
+// sorted by labels.
+func (s *Store) selected(ms []*labels.Matcher, mint, maxt int64) []*storepb.Series {
+	var ret []*storepb.Series
+	for _, ser := range s.Fixtures {
+		lset := labelpb.ZLabelsToPromLabels(ser.Labels)
+		if !matches(ms, lset) {
+			continue
+		}
+		sel := &storepb.Series{Labels: ser.Labels}
+		for _, c := range ser.Chunks {
+			if c.MaxTime >= mint && c.MinTime <= maxt {
+				sel.Chunks = append(sel.Chunks, c)
+			}
+		}
+		if len(ser.Chunks) > 0 && len(sel.Chunks) == 0 {
+			continue
+		}
+		ret = append(ret, sel)
+	}
+	sort.SliceStable(ret, func(i, j int) bool {
+		return labels.Compare(labelpb.ZLabelsToPromLabels(ret[i].Labels), labelpb.ZLabelsToPromLabels(ret[j].Labels)) < 0
+	})
+	return ret
+}
+
+func matches(ms []*labels.Matcher, lset labels.Labels) bool {
+	for _, m := range ms {
+		if !m.Matches(lset.Get(m.Name)) {
+			return false
+		}
+	}
+	return true
+}
+
+func sortedKeys(m map[string]struct{}) []string {
+	ret := make([]string, 0, len(m))
+	for k := range m {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// Serve serves the store over gRPC on a local port until the end of the test, returning the endpoint to read it by,
+// e.g. as the endpoint of the StoreAPI input.
+func Serve(t testing.TB, store storepb.StoreServer) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	storepb.RegisterStoreServer(srv, store)
+	go func() { _ = srv.Serve(l) }()
+	t.Cleanup(srv.Stop)
+	return l.Addr().String()
+}
@@ -0,0 +1,121 @@
+package storetest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/thanos-community/obslytics/pkg/series"
+	"github.com/thanos-community/obslytics/pkg/series/storeapi"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"github.com/thanos-io/thanos/pkg/testutil"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestStore_Read(t *testing.T) {
+	samples := make([]Sample, 0, 200)
+	for i := int64(0); i < 200; i++ {
+		samples = append(samples, Sample{T: i * 15000, V: float64(i)})
+	}
+	store := &Store{
+		Fixtures: []*storepb.Series{
+			NewSeries(labels.FromStrings("__name__", "up", "job", "b"), samples...),
+			NewSeries(labels.FromStrings("__name__", "up", "job", "a"), Sample{T: 0, V: 1}),
+			NewSeries(labels.FromStrings("__name__", "other", "job", "a"), Sample{T: 0, V: 1}),
+		},
+		Warnings: []string{"slow store"},
+	}
+	testutil.Equals(t, 2, len(store.Fixtures[0].Chunks))
+
+	in, err := storeapi.NewSeries(log.NewNopLogger(), series.Config{Endpoint: Serve(t, store)})
+	testutil.Ok(t, err)
+	read := func(params series.Params) (lsets []string, samples int, warnings []string) {
+		set, err := in.Read(context.Background(), params)
+		testutil.Ok(t, err)
+		defer func() { testutil.Ok(t, set.Close()) }()
+		for set.Next() {
+			lsets = append(lsets, set.At().Labels().String())
+			for it := set.At().Iterator(); it.Next(); {
+				samples++
+			}
+		}
+		testutil.Ok(t, set.Err())
+		for _, w := range set.Warnings() {
+			warnings = append(warnings, w.Error())
+		}
+		return lsets, samples, warnings
+	}
+
+	params := series.Params{
+		Matchers: []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "__name__", "up")},
+		MinTime:  time.Unix(0, 0),
+		MaxTime:  time.Unix(30*60, 0),
+	}
+	lsets, n, warnings := read(params)
+	testutil.Equals(t, []string{`{__name__="up", job="a"}`, `{__name__="up", job="b"}`}, lsets)
+	// The samples are trimmed to the range by the input.
+	testutil.Equals(t, 1+121, n)
+	testutil.Equals(t, []string{"slow store"}, warnings)
+
+	reqs := store.Requests()
+	testutil.Equals(t, 1, len(reqs))
+	testutil.Equals(t, []storepb.LabelMatcher{{Type: storepb.LabelMatcher_EQ, Name: "__name__", Value: "up"}}, reqs[0].Matchers)
+	testutil.Equals(t, int64(0), reqs[0].MinTime)
+	testutil.Equals(t, int64(30*60*1000), reqs[0].MaxTime)
+	testutil.Equals(t, storepb.PartialResponseStrategy_ABORT, reqs[0].PartialResponseStrategy)
+
+	// Only the series with chunks overlapping the range are sent.
+	params.MinTime, params.MaxTime = time.Unix(40*60, 0), time.Unix(60*60, 0)
+	lsets, n, _ = read(params)
+	testutil.Equals(t, []string{`{__name__="up", job="b"}`}, lsets)
+	testutil.Equals(t, 40, n)
+}
+
+func TestStore_PartialResponse(t *testing.T) {
+	store := &Store{
+		Fixtures:   []*storepb.Series{NewSeries(labels.FromStrings("__name__", "up"), Sample{T: 0, V: 1})},
+		PartialErr: errors.New("store unavailable"),
+	}
+	in, err := storeapi.NewSeries(log.NewNopLogger(), series.Config{Endpoint: Serve(t, store)})
+	testutil.Ok(t, err)
+
+	// The input aborts on partial responses.
+	set, err := in.Read(context.Background(), series.Params{MinTime: time.Unix(0, 0), MaxTime: time.Unix(60, 0)})
+	testutil.Ok(t, err)
+	testutil.Assert(t, !set.Next())
+	testutil.Equals(t, codes.Aborted, status.Code(set.Err()))
+	testutil.Ok(t, set.Close())
+
+	resp, err := store.LabelValues(context.Background(), &storepb.LabelValuesRequest{
+		Label:                   "__name__",
+		Start:                   0,
+		End:                     60000,
+		PartialResponseStrategy: storepb.PartialResponseStrategy_WARN,
+	})
+	testutil.Ok(t, err)
+	testutil.Equals(t, []string{"up"}, resp.Values)
+	testutil.Equals(t, []string{"store unavailable"}, resp.Warnings)
+}
+
+func TestStore_Info(t *testing.T) {
+	store := &Store{
+		Fixtures: []*storepb.Series{
+			NewSeries(labels.FromStrings("__name__", "up"), Sample{T: 10, V: 1}, Sample{T: 20, V: 1}),
+			NewSeries(labels.FromStrings("__name__", "up", "job", "a"), Sample{T: 5, V: 1}),
+		},
+		ExternalLabels: labels.FromStrings("replica", "a"),
+	}
+	info, err := store.Info(context.Background(), &storepb.InfoRequest{})
+	testutil.Ok(t, err)
+	testutil.Equals(t, int64(5), info.MinTime)
+	testutil.Equals(t, int64(20), info.MaxTime)
+	testutil.Equals(t, 1, len(info.LabelSets))
+
+	names, err := store.LabelNames(context.Background(), &storepb.LabelNamesRequest{Start: 0, End: 30})
+	testutil.Ok(t, err)
+	testutil.Equals(t, []string{"__name__", "job"}, names.Names)
+}
@@ -0,0 +1,72 @@
+package storeapi
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// openWithTimeout opens the stream with the context derived from ctx canceled once no response is received within the
+// idle timeout, so that a hung store fails the call, while a healthy stream runs as long as it needs. Only the time
+// spent opening the stream and waiting in Recv counts, not the time the caller takes between the responses. The context
+// is released once the stream ends, fails or is closed.
+func openWithTimeout(ctx context.Context, timeout time.Duration, open func(context.Context) (seriesStream, error)) (seriesStream, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	s := &timeoutStream{cancel: cancel, timeout: timeout}
+	s.timer = time.AfterFunc(timeout, func() {
+		atomic.StoreInt32(&s.idle, 1)
+		cancel()
+	})
+
+	var err error
+	if s.seriesStream, err = open(ctx); err != nil {
+		s.release()
+		return nil, s.err(err)
+	}
+	s.timer.Stop()
+	return s, nil
+}
+
+// timeoutStream fails the stream idle for the timeout with DeadlineExceeded, and releases its context with its end.
+type timeoutStream struct {
+	seriesStream
+
+	cancel  context.CancelFunc
+	timer   *time.Timer
+	timeout time.Duration
+	idle    int32
+}
+
+func (s *timeoutStream) Recv() (*storepb.SeriesResponse, error) {
+	s.timer.Reset(s.timeout)
+	resp, err := s.seriesStream.Recv()
+	s.timer.Stop()
+	if err != nil {
+		s.release()
+		return resp, s.err(err)
+	}
+	return resp, nil
+}
+
+func (s *timeoutStream) CloseSend() error {
+	err := s.seriesStream.CloseSend()
+	s.release()
+	return err
+}
+
+func (s *timeoutStream) release() {
+	s.timer.Stop()
+	s.cancel()
+}
+
+// err returns the error of the stream canceled by the idle timeout as DeadlineExceeded, the other errors as they are.
+func (s *timeoutStream) err(err error) error {
+	if atomic.LoadInt32(&s.idle) == 1 {
+		return status.Errorf(codes.DeadlineExceeded, "no series response within stream_timeout %v: %v", s.timeout, err)
+	}
+	return err
+}
@@ -0,0 +1,153 @@
+package storeapi
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/common/model"
+	"github.com/thanos-community/obslytics/pkg/series"
+	"github.com/thanos-io/thanos/pkg/store/labelpb"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"github.com/thanos-io/thanos/pkg/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// hungStoreServer sends the first series and hangs until the call is canceled, as many times as hangs.
+type hungStoreServer struct {
+	storepb.StoreServer
+
+	hangs int32
+}
+
+func (s *hungStoreServer) Series(_ *storepb.SeriesRequest, srv storepb.Store_SeriesServer) error {
+	for _, name := range []string{"a", "b"} {
+		if err := srv.Send(storepb.NewSeriesResponse(&storepb.Series{
+			Labels: []labelpb.ZLabel{{Name: "__name__", Value: name}},
+		})); err != nil {
+			return err
+		}
+		if atomic.AddInt32(&s.hangs, -1) >= 0 {
+			<-srv.Context().Done()
+			return srv.Context().Err()
+		}
+	}
+	return nil
+}
+
+func TestSeries_StreamTimeout(t *testing.T) {
+	store := &hungStoreServer{}
+	srv := grpc.NewServer()
+	storepb.RegisterStoreServer(srv, store)
+
+	l, err := net.Listen("tcp", "localhost:0")
+	testutil.Ok(t, err)
+	go func() { _ = srv.Serve(l) }()
+	defer srv.Stop()
+
+	read := func(conf series.Config) (int, error) {
+		conf.Endpoint, conf.StreamTimeout = l.Addr().String(), model.Duration(200*time.Millisecond)
+		s, err := NewSeries(log.NewNopLogger(), conf)
+		testutil.Ok(t, err)
+		// The context of the read has no deadline, the hung call fails by the stream timeout.
+		set, err := s.Read(context.Background(), series.Params{MinTime: time.Unix(0, 0), MaxTime: time.Unix(60, 0)})
+		testutil.Ok(t, err)
+		defer func() { testutil.Ok(t, set.Close()) }()
+		n := 0
+		for set.Next() {
+			n++
+		}
+		return n, set.Err()
+	}
+
+	atomic.StoreInt32(&store.hangs, 1)
+	n, err := read(series.Config{})
+	testutil.Equals(t, 1, n)
+	testutil.Equals(t, codes.DeadlineExceeded, status.Code(err))
+
+	// The timed out stream is reopened with the deadline of its own.
+	atomic.StoreInt32(&store.hangs, 1)
+	n, err = read(series.Config{Reconnect: series.ReconnectConfig{MaxRetries: 1}})
+	testutil.Ok(t, err)
+	testutil.Equals(t, 2, n)
+
+	_, err = NewSeries(log.NewNopLogger(), series.Config{StreamTimeout: model.Duration(-time.Second)})
+	testutil.NotOk(t, err)
+}
+
+// slowStoreServer sends the series one by one, with a delay before each.
+type slowStoreServer struct {
+	storepb.StoreServer
+
+	series int
+	delay  time.Duration
+}
+
+func (s *slowStoreServer) Series(_ *storepb.SeriesRequest, srv storepb.Store_SeriesServer) error {
+	for i := 0; i < s.series; i++ {
+		time.Sleep(s.delay)
+		if err := srv.Send(storepb.NewSeriesResponse(&storepb.Series{
+			Labels: []labelpb.ZLabel{{Name: "__name__", Value: "up"}, {Name: "i", Value: string(rune('a' + i))}},
+		})); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestSeries_StreamTimeout_Idle(t *testing.T) {
+	srv := grpc.NewServer()
+	storepb.RegisterStoreServer(srv, &slowStoreServer{series: 6, delay: 50 * time.Millisecond})
+
+	l, err := net.Listen("tcp", "localhost:0")
+	testutil.Ok(t, err)
+	go func() { _ = srv.Serve(l) }()
+	defer srv.Stop()
+
+	// The stream running longer than the timeout is not cut off while it receives the series.
+	s, err := NewSeries(log.NewNopLogger(), series.Config{Endpoint: l.Addr().String(), StreamTimeout: model.Duration(200 * time.Millisecond)})
+	testutil.Ok(t, err)
+	set, err := s.Read(context.Background(), series.Params{MinTime: time.Unix(0, 0), MaxTime: time.Unix(60, 0)})
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, set.Close()) }()
+	n := 0
+	for set.Next() {
+		n++
+	}
+	testutil.Ok(t, set.Err())
+	testutil.Equals(t, 6, n)
+}
+
+// ctxStream responds right away until its context is canceled.
+type ctxStream struct {
+	ctx context.Context
+}
+
+func (s ctxStream) Recv() (*storepb.SeriesResponse, error) {
+	if err := s.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return storepb.NewSeriesResponse(&storepb.Series{}), nil
+}
+
+func (ctxStream) CloseSend() error { return nil }
+
+func TestOpenWithTimeout_SlowConsumer(t *testing.T) {
+	s, err := openWithTimeout(context.Background(), 50*time.Millisecond, func(ctx context.Context) (seriesStream, error) {
+		return ctxStream{ctx: ctx}, nil
+	})
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, s.CloseSend()) }()
+
+	// The time the caller spends between the responses does not count as the stream being idle.
+	for i := 0; i < 3; i++ {
+		time.Sleep(100 * time.Millisecond)
+		_, err := s.Recv()
+		testutil.Ok(t, err)
+	}
+}
@@ -0,0 +1,142 @@
+package storeapi
+
+import (
+	"context"
+	"crypto/tls"
+	"math"
+	"net"
+	"path"
+	"strings"
+
+	"github.com/go-kit/kit/log"
+	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
+	"github.com/opentracing/opentracing-go"
+	"github.com/pkg/errors"
+	"github.com/thanos-community/obslytics/pkg/series"
+	"github.com/thanos-community/obslytics/pkg/version"
+	thanostls "github.com/thanos-io/thanos/pkg/tls"
+	"github.com/thanos-io/thanos/pkg/tracing"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// newCustomClientConfig builds the client TLS configuration. On top of the common certificate options
+// it enables TLS session resumption and ALPN negotiation to reduce handshake overhead of repeated dials.
+// The client certificate is reloaded once its files change, so that the rotated certificates are used by
+// the new handshakes of long-lived clients, e.g. in follow mode.
+func newCustomClientConfig(logger log.Logger, conf series.Config) (*tls.Config, error) {
+	renegotiation, err := conf.TLSConfig.Renegotiation.Support()
+	if err != nil {
+		return nil, err
+	}
+
+	serverName := conf.TLSConfig.ServerName
+	if serverName == "" {
+		serverName = conf.Endpoint
+		if _, ok := unixSocketPath(conf.Endpoint); ok {
+			serverName = "localhost"
+		}
+	}
+
+	tlsCfg, err := thanostls.NewClientConfig(logger,
+		conf.TLSConfig.CertFile,
+		conf.TLSConfig.KeyFile,
+		conf.TLSConfig.CAFile,
+		serverName,
+		conf.TLSConfig.InsecureSkipVerify,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var reloader *series.CertReloader
+	switch {
+	case conf.TLSConfig.PKCS12File != "":
+		if conf.TLSConfig.CertFile != "" || conf.TLSConfig.KeyFile != "" {
+			return nil, errors.New("pkcs12_file cannot be used together with cert_file and key_file")
+		}
+		if reloader, err = series.NewPKCS12Reloader(logger, conf.TLSConfig.PKCS12File, conf.TLSConfig.PKCS12Password); err != nil {
+			return nil, err
+		}
+	case conf.TLSConfig.CertFile != "":
+		if reloader, err = series.NewCertReloader(logger, conf.TLSConfig.CertFile, conf.TLSConfig.KeyFile); err != nil {
+			return nil, errors.Wrap(err, "client credentials")
+		}
+	}
+	if reloader != nil {
+		tlsCfg.Certificates = nil
+		tlsCfg.GetClientCertificate = reloader.GetClientCertificate
+	}
+	tlsCfg.Renegotiation = renegotiation
+
+	// tls.NewLRUClientSessionCache uses the default capacity for non-positive sizes.
+	tlsCfg.ClientSessionCache = tls.NewLRUClientSessionCache(conf.TLSConfig.SessionCacheSize)
+	tlsCfg.NextProtos = conf.TLSConfig.NextProtos
+	return tlsCfg, nil
+}
+
+// unixSocketPath returns the path of the unix:// endpoint, e.g. of the store gateway running next to obslytics.
+func unixSocketPath(endpoint string) (string, bool) {
+	if !strings.HasPrefix(endpoint, "unix://") {
+		return "", false
+	}
+	return strings.TrimPrefix(endpoint, "unix://"), true
+}
+
+// maxRecvMsgSize is the size limit of the messages received by the gRPC and gRPC-Web calls.
+const maxRecvMsgSize = math.MaxInt32
+
+// instrumentDialOptions returns the metrics and tracing interceptors of extgrpc.StoreClientGRPCOpts, which can't
+// be used as a whole since it builds its own transport credentials.
+func instrumentDialOptions() []grpc.DialOption {
+	grpcMets := grpc_prometheus.NewClientMetrics()
+	grpcMets.EnableClientHandlingTimeHistogram(
+		grpc_prometheus.WithHistogramBuckets([]float64{0.001, 0.01, 0.1, 0.3, 0.6, 1, 3, 6, 9, 20, 30, 60, 90, 120, 240, 360, 720}),
+	)
+	tracer := opentracing.NoopTracer{}
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(grpcMets.UnaryClientInterceptor(), tracing.UnaryClientInterceptor(tracer)),
+		grpc.WithChainStreamInterceptor(grpcMets.StreamClientInterceptor(), tracing.StreamClientInterceptor(tracer)),
+	}
+}
+
+// dialOptions returns the gRPC dial options for the StoreAPI endpoint, authenticated by the tokens if set.
+func dialOptions(logger log.Logger, conf series.Config, tokens TokenSource) ([]grpc.DialOption, error) {
+	dialOpts := append([]grpc.DialOption{
+		grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(maxRecvMsgSize)),
+		grpc.WithUserAgent(path.Join("obslytics", version.Version)),
+	}, instrumentDialOptions()...)
+	if len(conf.Metadata) > 0 {
+		dialOpts = append(dialOpts,
+			grpc.WithChainUnaryInterceptor(metadataUnaryInterceptor(conf.Metadata)),
+			grpc.WithChainStreamInterceptor(metadataStreamInterceptor(conf.Metadata)),
+		)
+	}
+	if tokens != nil {
+		dialOpts = append(dialOpts, tokenDialOptions(logger, tokens)...)
+	}
+	if socket, ok := unixSocketPath(conf.Endpoint); ok {
+		dialOpts = append(dialOpts,
+			grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			}),
+			grpc.WithAuthority("localhost"),
+		)
+	}
+
+	// set as true for authenticated connection if cert, key and/or ca are defined.
+	secure := conf.TLSConfig.CertFile != "" ||
+		conf.TLSConfig.KeyFile != "" ||
+		conf.TLSConfig.CAFile != "" ||
+		conf.TLSConfig.PKCS12File != ""
+	if !secure {
+		return append(dialOpts, grpc.WithInsecure()), nil
+	}
+
+	tlsCfg, err := newCustomClientConfig(logger, conf)
+	if err != nil {
+		return nil, errors.Wrap(err, "building TLS config")
+	}
+	return append(dialOpts, grpc.WithTransportCredentials(credentials.NewTLS(tlsCfg))), nil
+}
@@ -0,0 +1,120 @@
+package storeapi
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/thanos-community/obslytics/pkg/series"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+// newTLSServer serves TLS on localhost with a self-signed certificate, written to the returned CA file. Every
+// connection gets a single byte, so that the clients receive the session tickets sent after the handshake.
+func newTLSServer(t *testing.T, dir string, nextProtos []string) (net.Listener, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	testutil.Ok(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	testutil.Ok(t, err)
+	caFile := filepath.Join(dir, "ca.crt")
+	testutil.Ok(t, ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+
+	l, err := tls.Listen("tcp", "localhost:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		NextProtos:   nextProtos,
+	})
+	testutil.Ok(t, err)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = conn.Write([]byte{1})
+			_ = conn.Close()
+		}
+	}()
+	return l, caFile
+}
+
+func TestNewCustomClientConfig_SessionCacheAndALPN(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	testutil.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	l, caFile := newTLSServer(t, dir, []string{"h2", "obslytics"})
+	defer l.Close()
+
+	conf := series.Config{Endpoint: l.Addr().String()}
+	conf.TLSConfig.CAFile = caFile
+	conf.TLSConfig.ServerName = "localhost"
+	conf.TLSConfig.NextProtos = []string{"obslytics"}
+	conf.TLSConfig.SessionCacheSize = 8
+	tlsCfg, err := newCustomClientConfig(log.NewNopLogger(), conf)
+	testutil.Ok(t, err)
+	testutil.Assert(t, tlsCfg.ClientSessionCache != nil, "expected a session cache")
+
+	handshake := func() tls.ConnectionState {
+		conn, err := tls.Dial("tcp", l.Addr().String(), tlsCfg)
+		testutil.Ok(t, err)
+		defer conn.Close()
+		_, err = io.ReadFull(conn, make([]byte, 1))
+		testutil.Ok(t, err)
+		return conn.ConnectionState()
+	}
+
+	state := handshake()
+	testutil.Equals(t, "obslytics", state.NegotiatedProtocol)
+	testutil.Assert(t, !state.DidResume, "expected a full handshake first")
+
+	state = handshake()
+	testutil.Equals(t, "obslytics", state.NegotiatedProtocol)
+	testutil.Assert(t, state.DidResume, "expected the cached session to be resumed")
+}
+
+func TestNewCustomClientConfig_NoALPN(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	testutil.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	l, caFile := newTLSServer(t, dir, nil)
+	defer l.Close()
+
+	conf := series.Config{Endpoint: l.Addr().String()}
+	conf.TLSConfig.CAFile = caFile
+	conf.TLSConfig.ServerName = "localhost"
+	conf.TLSConfig.Renegotiation = series.RenegotiateOnce
+	tlsCfg, err := newCustomClientConfig(log.NewNopLogger(), conf)
+	testutil.Ok(t, err)
+	testutil.Equals(t, tls.RenegotiateOnceAsClient, tlsCfg.Renegotiation)
+
+	conn, err := tls.Dial("tcp", l.Addr().String(), tlsCfg)
+	testutil.Ok(t, err)
+	defer conn.Close()
+	testutil.Ok(t, conn.Handshake())
+	testutil.Equals(t, "", conn.ConnectionState().NegotiatedProtocol)
+}
@@ -0,0 +1,97 @@
+package series
+
+import (
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+)
+
+// DefaultTenantLabel is the tenant label of the series written by the multi-tenant Thanos receivers.
+const DefaultTenantLabel = "tenant_id"
+
+// TenantConfig scopes the read to the series of a single tenant by their tenant label, as a safety control in
+// multi-tenant setups, independently of the tenant sent with the request metadata: the tenant matcher is added to
+// every request and the read fails on any series returned without it, e.g. by a misconfigured store.
+type TenantConfig struct {
+	// Label is the name of the tenant label, tenant_id by default.
+	Label string `yaml:"label"`
+	// Value is the tenant the read is scoped to. Empty (default) disables the enforcement.
+	Value string `yaml:"value"`
+}
+
+// Enabled returns true if the read is scoped to a tenant.
+func (c TenantConfig) Enabled() bool { return c.Value != "" }
+
+// Validate returns error if the options are invalid.
+func (c TenantConfig) Validate() error {
+	if c.Label != "" && !c.Enabled() {
+		return errors.Errorf("tenant label %q set without the tenant value", c.Label)
+	}
+	if c.Enabled() && !model.LabelName(c.label()).IsValid() {
+		return errors.Errorf("invalid tenant label name %q", c.label())
+	}
+	return nil
+}
+
+func (c TenantConfig) label() string {
+	if c.Label == "" {
+		return DefaultTenantLabel
+	}
+	return c.Label
+}
+
+// Matcher returns the matcher of the series of the tenant, nil if the enforcement is disabled.
+func (c TenantConfig) Matcher() *labels.Matcher {
+	if !c.Enabled() {
+		return nil
+	}
+	return labels.MustNewMatcher(labels.MatchEqual, c.label(), c.Value)
+}
+
+// Check returns error if the labels are not of the tenant.
+func (c TenantConfig) Check(lset labels.Labels) error {
+	if !c.Enabled() {
+		return nil
+	}
+	if v := lset.Get(c.label()); v != c.Value {
+		return errors.Errorf("series %s is not of the tenant %s=%q, refusing the cross-tenant data", lset, c.label(), c.Value)
+	}
+	return nil
+}
+
+// NewTenantSet returns the set failing on the first series not of the tenant. The set is returned as it is if the
+// enforcement is disabled.
+func NewTenantSet(s Set, conf TenantConfig) Set {
+	if !conf.Enabled() {
+		return s
+	}
+	return &tenantSet{Set: s, conf: conf}
+}
+
+type tenantSet struct {
+	Set
+
+	conf TenantConfig
+	err  error
+}
+
+func (s *tenantSet) Next() bool {
+	if s.err != nil || !s.Set.Next() {
+		return false
+	}
+	if err := s.conf.Check(s.Set.At().Labels()); err != nil {
+		s.err = err
+		return false
+	}
+	return true
+}
+
+func (s *tenantSet) At() storage.Series { return s.Set.At() }
+
+func (s *tenantSet) Err() error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.Set.Err()
+}
@@ -0,0 +1,25 @@
+package series
+
+import (
+	"testing"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestTenantConfig(t *testing.T) {
+	conf := TenantConfig{Value: "a"}
+	testutil.Ok(t, conf.Validate())
+	testutil.Equals(t, labels.MustNewMatcher(labels.MatchEqual, "tenant_id", "a"), conf.Matcher())
+	testutil.Ok(t, conf.Check(labels.FromStrings("__name__", "up", "tenant_id", "a")))
+	testutil.NotOk(t, conf.Check(labels.FromStrings("__name__", "up", "tenant_id", "b")))
+	testutil.NotOk(t, conf.Check(labels.FromStrings("__name__", "up")))
+
+	// Disabled without the tenant.
+	testutil.Ok(t, TenantConfig{}.Validate())
+	testutil.Assert(t, TenantConfig{}.Matcher() == nil)
+	testutil.Ok(t, TenantConfig{}.Check(labels.FromStrings("__name__", "up")))
+
+	testutil.NotOk(t, TenantConfig{Label: "tenant"}.Validate())
+	testutil.NotOk(t, TenantConfig{Label: "tenant-id", Value: "a"}.Validate())
+}
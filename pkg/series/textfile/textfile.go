@@ -0,0 +1,224 @@
+package textfile
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/textparse"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/tsdbutil"
+	"github.com/thanos-community/obslytics/pkg/series"
+	"github.com/thanos-community/obslytics/pkg/series/memory"
+)
+
+// Series implements series.Reader reading the samples of the Prometheus or OpenMetrics text files, e.g. archived
+// scrape dumps. The files are parsed on every read, and the matching samples are kept in memory, grouped into series
+// sorted by their labels, with the samples sorted by timestamp.
+//
+// The format of every file is detected by its content: files ending with "# EOF" are OpenMetrics, the others the
+// Prometheus text format. Gzip compressed files are detected by their content as well. Samples without a timestamp,
+// as usual for scrape dumps, get the modification time of the file.
+type Series struct {
+	logger          log.Logger
+	conf            series.TextFileConfig
+	duplicateLabels series.DuplicateLabelsPolicy
+}
+
+// NewSeries returns the reader of the configured text files.
+func NewSeries(logger log.Logger, conf series.Config) (Series, error) {
+	if len(conf.TextFile.Paths) == 0 {
+		return Series{}, errors.New("text_file paths are required by the TEXTFILE input")
+	}
+	for _, p := range conf.TextFile.Paths {
+		if _, err := filepath.Match(p, ""); err != nil {
+			return Series{}, errors.Wrapf(err, "text_file path %q", p)
+		}
+	}
+	if err := conf.DuplicateLabels.Validate(); err != nil {
+		return Series{}, err
+	}
+	return Series{logger: logger, conf: conf.TextFile, duplicateLabels: conf.DuplicateLabels}, nil
+}
+
+func (s Series) Read(ctx context.Context, params series.Params) (series.Set, error) {
+	if err := params.Validate(); err != nil {
+		return nil, err
+	}
+	matchers, err := params.PromMatchers()
+	if err != nil {
+		return nil, err
+	}
+	files, err := s.files()
+	if err != nil {
+		return nil, err
+	}
+
+	mint, maxt := params.Bounds()
+	r := &fileReader{
+		matchers: matchers,
+		mint:     mint,
+		maxt:     maxt,
+		labels:   series.NewLabelsChecker(s.logger, s.duplicateLabels),
+		series:   map[uint64]*fileSeries{},
+	}
+	for _, f := range files {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err := r.read(f); err != nil {
+			return nil, errors.Wrapf(err, "read %s", f)
+		}
+	}
+	level.Debug(s.logger).Log("msg", "read text files", "files", len(files), "series", len(r.series))
+	return r.set(params.Snapshot), nil
+}
+
+// files returns the files matching the configured paths, in order.
+func (s Series) files() ([]string, error) {
+	var ret []string
+	for _, p := range s.conf.Paths {
+		matches, err := filepath.Glob(p)
+		if err != nil {
+			return nil, errors.Wrapf(err, "text_file path %q", p)
+		}
+		if len(matches) == 0 {
+			level.Warn(s.logger).Log("msg", "no files match the text_file path", "path", p)
+		}
+		ret = append(ret, matches...)
+	}
+	return ret, nil
+}
+
+// fileSeries is a series read from the files.
+type fileSeries struct {
+	labels  labels.Labels
+	samples []tsdbutil.Sample
+}
+
+type sample struct {
+	t int64
+	v float64
+}
+
+func (s sample) T() int64   { return s.t }
+func (s sample) V() float64 { return s.v }
+
+// fileReader collects the samples of the files matching the matchers and the time range.
+type fileReader struct {
+	matchers   []*labels.Matcher
+	mint, maxt int64
+	labels     *series.LabelsChecker
+
+	series map[uint64]*fileSeries
+}
+
+func (r *fileReader) read(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	if len(data) > 1 && data[0] == 0x1f && data[1] == 0x8b {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return errors.Wrap(err, "gzip")
+		}
+		if data, err = ioutil.ReadAll(zr); err != nil {
+			return errors.Wrap(err, "gzip")
+		}
+	}
+
+	contentType := "text/plain"
+	if bytes.HasSuffix(bytes.TrimSpace(data), []byte("# EOF")) {
+		contentType = "application/openmetrics-text"
+	}
+	return r.parse(textparse.New(data, contentType), timestamp.FromTime(stat.ModTime()))
+}
+
+// parse adds the matching samples of the parser, defaulting the timestamp to the given one.
+func (r *fileReader) parse(p textparse.Parser, defaultTime int64) error {
+	for {
+		entry, err := p.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if entry != textparse.EntrySeries {
+			continue
+		}
+
+		_, ts, v := p.Series()
+		t := defaultTime
+		if ts != nil {
+			t = *ts
+		}
+		if t < r.mint || t > r.maxt {
+			continue
+		}
+
+		var lset labels.Labels
+		p.Metric(&lset)
+		// The duplicates are handled before the samples are grouped, so that they go to the series of the labels kept.
+		if lset, err = r.labels.Check(lset); err != nil {
+			return err
+		}
+		if !matches(r.matchers, lset) {
+			continue
+		}
+		h := lset.Hash()
+		s, ok := r.series[h]
+		if !ok {
+			s = &fileSeries{labels: lset}
+			r.series[h] = s
+		}
+		s.samples = append(s.samples, sample{t: t, v: v})
+	}
+}
+
+func matches(ms []*labels.Matcher, lset labels.Labels) bool {
+	for _, m := range ms {
+		if !m.Matches(lset.Get(m.Name)) {
+			return false
+		}
+	}
+	return true
+}
+
+// set returns the series sorted by labels, with their samples sorted by timestamp.
+func (r *fileReader) set(snapshot bool) series.Set {
+	ret := make([]storage.Series, 0, len(r.series))
+	for _, s := range r.series {
+		sort.SliceStable(s.samples, func(i, j int) bool { return s.samples[i].T() < s.samples[j].T() })
+		var ser storage.Series = storage.NewListSeries(s.labels, s.samples)
+		if snapshot {
+			ser = series.LatestSeries{Series: ser}
+		}
+		ret = append(ret, ser)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return labels.Compare(ret[i].Labels(), ret[j].Labels()) < 0
+	})
+	return memory.NewSet(ret...)
+}
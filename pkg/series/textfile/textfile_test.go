@@ -0,0 +1,108 @@
+package textfile
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/thanos-community/obslytics/pkg/series"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestSeries_Read(t *testing.T) {
+	dir, err := ioutil.TempDir("", "textfile")
+	testutil.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	testutil.Ok(t, ioutil.WriteFile(filepath.Join(dir, "a.prom"), []byte(`# HELP up Whether the target is up.
+# TYPE up gauge
+up{job="b"} 1 30000
+up{job="a",path="C:\\dir \"x\""} 0 60000
+other{job="a"} 1 60000
+`), 0666))
+
+	// Samples without timestamps get the modification time.
+	testutil.Ok(t, ioutil.WriteFile(filepath.Join(dir, "b.prom"), []byte("up{job=\"b\"} 2\n"), 0666))
+	testutil.Ok(t, os.Chtimes(filepath.Join(dir, "b.prom"), time.Unix(45, 0), time.Unix(45, 0)))
+
+	// Gzip compressed OpenMetrics, with timestamps in seconds.
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	_, err = zw.Write([]byte(`# TYPE up gauge
+up{job="b"} 3 15.5
+up{job="b"} 4 90
+up{job="b"} 5 120
+# EOF
+`))
+	testutil.Ok(t, err)
+	testutil.Ok(t, zw.Close())
+	testutil.Ok(t, ioutil.WriteFile(filepath.Join(dir, "c.om.gz"), b.Bytes(), 0666))
+
+	s, err := NewSeries(log.NewNopLogger(), series.Config{
+		Type:     series.TEXTFILE,
+		TextFile: series.TextFileConfig{Paths: []string{filepath.Join(dir, "*.prom"), filepath.Join(dir, "*.gz")}},
+	})
+	testutil.Ok(t, err)
+
+	read := func(params series.Params) (ret []string, samples [][]sample) {
+		set, err := s.Read(context.Background(), params)
+		testutil.Ok(t, err)
+		defer func() { testutil.Ok(t, set.Close()) }()
+
+		for set.Next() {
+			var ss []sample
+			it := set.At().Iterator()
+			for it.Next() {
+				ts, v := it.At()
+				ss = append(ss, sample{t: ts, v: v})
+			}
+			testutil.Ok(t, it.Err())
+			ret = append(ret, set.At().Labels().String())
+			samples = append(samples, ss)
+		}
+		testutil.Ok(t, set.Err())
+		return ret, samples
+	}
+
+	params := series.Params{
+		Matchers: []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "__name__", "up")},
+		MinTime:  time.Unix(15, 0),
+		MaxTime:  time.Unix(90, 0),
+	}
+	lsets, samples := read(params)
+	testutil.Equals(t, []string{`{__name__="up", job="a", path="C:\\dir \"x\""}`, `{__name__="up", job="b"}`}, lsets)
+	testutil.Equals(t, [][]sample{
+		{{t: 60000, v: 0}},
+		{{t: 15500, v: 3}, {t: 30000, v: 1}, {t: 45000, v: 2}, {t: 90000, v: 4}},
+	}, samples)
+
+	params.Snapshot, params.MaxTimeExclusive = true, true
+	_, samples = read(params)
+	testutil.Equals(t, [][]sample{{{t: 60000, v: 0}}, {{t: 45000, v: 2}}}, samples)
+}
+
+func TestSeries_Read_InvalidFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "textfile")
+	testutil.Ok(t, err)
+	defer os.RemoveAll(dir)
+	testutil.Ok(t, ioutil.WriteFile(filepath.Join(dir, "a.prom"), []byte("up{job=\"a\" 1\n"), 0666))
+
+	s, err := NewSeries(log.NewNopLogger(), series.Config{TextFile: series.TextFileConfig{Paths: []string{filepath.Join(dir, "a.prom")}}})
+	testutil.Ok(t, err)
+	_, err = s.Read(context.Background(), series.Params{})
+	testutil.NotOk(t, err)
+}
+
+func TestNewSeries_NoPaths(t *testing.T) {
+	_, err := NewSeries(log.NewNopLogger(), series.Config{Type: series.TEXTFILE})
+	testutil.NotOk(t, err)
+	_, err = NewSeries(log.NewNopLogger(), series.Config{TextFile: series.TextFileConfig{Paths: []string{"["}}})
+	testutil.NotOk(t, err)
+}
@@ -0,0 +1,158 @@
+package series
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/pkcs12"
+)
+
+// LoadPKCS12 reads the client certificate and its private key from the PKCS#12 (.p12/.pfx) bundle,
+// decrypting it with the password.
+func LoadPKCS12(file, password string) (tls.Certificate, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return tls.Certificate{}, errors.Wrap(err, "reading PKCS#12 bundle")
+	}
+	blocks, err := pkcs12.ToPEM(data, password)
+	if err != nil {
+		return tls.Certificate{}, errors.Wrapf(err, "decoding PKCS#12 bundle %v", file)
+	}
+
+	var certPEM, keyPEM []byte
+	for _, b := range blocks {
+		if b.Type == "CERTIFICATE" {
+			certPEM = append(certPEM, pem.EncodeToMemory(b)...)
+		} else {
+			keyPEM = append(keyPEM, pem.EncodeToMemory(b)...)
+		}
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return tls.Certificate{}, errors.Wrapf(err, "loading key pair from PKCS#12 bundle %v", file)
+	}
+	return cert, nil
+}
+
+// TLSRenegotiation defines the TLS renegotiation accepted from the server.
+type TLSRenegotiation string
+
+const (
+	RenegotiateNever  TLSRenegotiation = "never"
+	RenegotiateOnce   TLSRenegotiation = "once"
+	RenegotiateFreely TLSRenegotiation = "freely"
+)
+
+// Support returns the renegotiation support of the tls.Config, error if the renegotiation is not known.
+func (r TLSRenegotiation) Support() (tls.RenegotiationSupport, error) {
+	switch r {
+	case "", RenegotiateNever:
+		return tls.RenegotiateNever, nil
+	case RenegotiateOnce:
+		return tls.RenegotiateOnceAsClient, nil
+	case RenegotiateFreely:
+		return tls.RenegotiateFreelyAsClient, nil
+	}
+	return 0, errors.Errorf("unsupported renegotiation %q, expected never, once or freely", r)
+}
+
+// CertReloader loads the client certificate from its files again once they change, e.g. rotated by cert-manager, so
+// that long-lived clients present the current certificate on new handshakes without a restart. The files are checked
+// by their modification time and size on every handshake, which is rare compared to the requests sent over the
+// connections. If the changed files fail to load, e.g. the key is not yet written, the previous certificate is used
+// and the load is retried on the next handshake.
+type CertReloader struct {
+	logger log.Logger
+	files  []string
+	load   func() (tls.Certificate, error)
+
+	mtx    sync.Mutex
+	cert   *tls.Certificate
+	stamps []fileStamp
+}
+
+// fileStamp identifies the version of a file.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// NewCertReloader returns the reloader of the PEM encoded certificate and key files, loading them.
+func NewCertReloader(logger log.Logger, certFile, keyFile string) (*CertReloader, error) {
+	return newCertReloader(logger, []string{certFile, keyFile}, func() (tls.Certificate, error) {
+		return tls.LoadX509KeyPair(certFile, keyFile)
+	})
+}
+
+// NewPKCS12Reloader returns the reloader of the PKCS#12 bundle, loading it. See LoadPKCS12.
+func NewPKCS12Reloader(logger log.Logger, file, password string) (*CertReloader, error) {
+	return newCertReloader(logger, []string{file}, func() (tls.Certificate, error) {
+		return LoadPKCS12(file, password)
+	})
+}
+
+func newCertReloader(logger log.Logger, files []string, load func() (tls.Certificate, error)) (*CertReloader, error) {
+	r := &CertReloader{logger: logger, files: files, load: load}
+	stamps, err := r.stat()
+	if err != nil {
+		return nil, err
+	}
+	cert, err := load()
+	if err != nil {
+		return nil, err
+	}
+	r.cert, r.stamps = &cert, stamps
+	return r, nil
+}
+
+func (r *CertReloader) stat() ([]fileStamp, error) {
+	ret := make([]fileStamp, 0, len(r.files))
+	for _, f := range r.files {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, fileStamp{modTime: fi.ModTime(), size: fi.Size()})
+	}
+	return ret, nil
+}
+
+// GetClientCertificate returns the current certificate, reloading it if the files changed. It is meant to be set as
+// tls.Config.GetClientCertificate.
+func (r *CertReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	stamps, err := r.stat()
+	if err != nil {
+		level.Warn(r.logger).Log("msg", "checking client certificate files failed, using the previous certificate", "err", err)
+		return r.cert, nil
+	}
+	if stampsEqual(stamps, r.stamps) {
+		return r.cert, nil
+	}
+	cert, err := r.load()
+	if err != nil {
+		level.Warn(r.logger).Log("msg", "reloading client certificate failed, using the previous certificate", "err", err)
+		return r.cert, nil
+	}
+	level.Info(r.logger).Log("msg", "client certificate reloaded", "files", len(r.files))
+	r.cert, r.stamps = &cert, stamps
+	return r.cert, nil
+}
+
+func stampsEqual(a, b []fileStamp) bool {
+	for i := range a {
+		if !a[i].modTime.Equal(b[i].modTime) || a[i].size != b[i].size {
+			return false
+		}
+	}
+	return true
+}
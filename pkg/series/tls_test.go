@@ -0,0 +1,106 @@
+package series
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestLoadPKCS12(t *testing.T) {
+	cert, err := LoadPKCS12("testdata/client.p12", "secret")
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, len(cert.Certificate))
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	testutil.Ok(t, err)
+	testutil.Equals(t, "obslytics-test", leaf.Subject.CommonName)
+
+	_, err = LoadPKCS12("testdata/client.p12", "wrong")
+	testutil.NotOk(t, err)
+
+	_, err = LoadPKCS12("testdata/missing.p12", "secret")
+	testutil.NotOk(t, err)
+}
+
+// writeKeyPair writes a self-signed certificate of the common name and its key, modified at the time.
+func writeKeyPair(t *testing.T, certFile, keyFile, cn string, modTime time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	testutil.Ok(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	testutil.Ok(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	testutil.Ok(t, err)
+
+	testutil.Ok(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	testutil.Ok(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	testutil.Ok(t, os.Chtimes(certFile, modTime, modTime))
+	testutil.Ok(t, os.Chtimes(keyFile, modTime, modTime))
+}
+
+func TestCertReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	testutil.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	writeKeyPair(t, certFile, keyFile, "first", time.Unix(1000, 0))
+
+	r, err := NewCertReloader(log.NewNopLogger(), certFile, keyFile)
+	testutil.Ok(t, err)
+	cn := func() string {
+		cert, err := r.GetClientCertificate(&tls.CertificateRequestInfo{})
+		testutil.Ok(t, err)
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		testutil.Ok(t, err)
+		return leaf.Subject.CommonName
+	}
+	testutil.Equals(t, "first", cn())
+
+	writeKeyPair(t, certFile, keyFile, "rotated", time.Unix(2000, 0))
+	testutil.Equals(t, "rotated", cn())
+
+	// The previous certificate is used until the changed files load.
+	testutil.Ok(t, ioutil.WriteFile(keyFile, []byte("partial"), 0600))
+	testutil.Equals(t, "rotated", cn())
+	testutil.Ok(t, os.Remove(keyFile))
+	testutil.Equals(t, "rotated", cn())
+
+	_, err = NewCertReloader(log.NewNopLogger(), certFile, keyFile)
+	testutil.NotOk(t, err)
+
+	p, err := NewPKCS12Reloader(log.NewNopLogger(), "testdata/client.p12", "secret")
+	testutil.Ok(t, err)
+	cert, err := p.GetClientCertificate(&tls.CertificateRequestInfo{})
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, len(cert.Certificate))
+}
+
+func TestTLSRenegotiation_Support(t *testing.T) {
+	s, err := TLSRenegotiation("").Support()
+	testutil.Ok(t, err)
+	testutil.Equals(t, tls.RenegotiateNever, s)
+	s, err = RenegotiateOnce.Support()
+	testutil.Ok(t, err)
+	testutil.Equals(t, tls.RenegotiateOnceAsClient, s)
+	_, err = TLSRenegotiation("always").Support()
+	testutil.NotOk(t, err)
+}
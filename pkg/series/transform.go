@@ -0,0 +1,78 @@
+package series
+
+import (
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+)
+
+// SampleTransform transforms a sample of the series with the labels, e.g. to redact values above a threshold or
+// to apply a calibration curve. Returning false drops the sample. Transformed timestamps have to keep the order
+// of the samples.
+type SampleTransform func(lset labels.Labels, t int64, v float64) (int64, float64, bool)
+
+// NewTransformedSet returns the set with the transform applied to every sample.
+func NewTransformedSet(s Set, transform SampleTransform) Set {
+	return &transformedSet{Set: s, transform: transform}
+}
+
+type transformedSet struct {
+	Set
+
+	transform SampleTransform
+}
+
+func (s *transformedSet) At() storage.Series {
+	return &transformedSeries{Series: s.Set.At(), transform: s.transform}
+}
+
+type transformedSeries struct {
+	storage.Series
+
+	transform SampleTransform
+}
+
+func (s *transformedSeries) Iterator() chunkenc.Iterator {
+	return &transformedIterator{it: s.Series.Iterator(), lset: s.Labels(), transform: s.transform}
+}
+
+type transformedIterator struct {
+	it        chunkenc.Iterator
+	lset      labels.Labels
+	transform SampleTransform
+
+	ok  bool
+	cur sample
+}
+
+func (it *transformedIterator) Next() bool {
+	for it.it.Next() {
+		t, v := it.it.At()
+		if it.cur.t, it.cur.v, it.ok = it.transform(it.lset, t, v); it.ok {
+			return true
+		}
+	}
+	it.ok = false
+	return false
+}
+
+// Seek compares the transformed timestamps, as the transform may shift them.
+func (it *transformedIterator) Seek(t int64) bool {
+	if it.ok && it.cur.t >= t {
+		return true
+	}
+	for it.Next() {
+		if it.cur.t >= t {
+			return true
+		}
+	}
+	return false
+}
+
+func (it *transformedIterator) At() (int64, float64) { return it.cur.t, it.cur.v }
+
+func (it *transformedIterator) Err() error { return it.it.Err() }
+
+func (it *transformedIterator) SampleCount() uint64 { return SampleCount(it.it) }
+
+func (it *transformedIterator) Elapsed() int64 { return Elapsed(it.it) }
@@ -0,0 +1,55 @@
+package series
+
+import (
+	"testing"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/tsdbutil"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestTransformedSet(t *testing.T) {
+	set := NewTransformedSet(&listSet{i: -1, series: []storage.Series{
+		storage.NewListSeries(labels.FromStrings("__name__", "temp", "sensor", "a"), []tsdbutil.Sample{
+			sample{t: 10, v: 1}, sample{t: 20, v: 200}, sample{t: 30, v: 3}, sample{t: 40, v: 4},
+		}),
+		storage.NewListSeries(labels.FromStrings("__name__", "up"), []tsdbutil.Sample{sample{t: 10, v: 500}}),
+	}}, func(lset labels.Labels, t int64, v float64) (int64, float64, bool) {
+		if lset.Get(labels.MetricName) != "temp" {
+			return t, v, true
+		}
+		// Calibrate and redact the values above the threshold, shifting the timestamps.
+		return t + 5, v * 2, v < 100
+	})
+
+	var got [][]sample
+	for set.Next() {
+		var ss []sample
+		it := set.At().Iterator()
+		for it.Next() {
+			ts, v := it.At()
+			ss = append(ss, sample{t: ts, v: v})
+		}
+		testutil.Ok(t, it.Err())
+		got = append(got, ss)
+	}
+	testutil.Equals(t, [][]sample{{{15, 2}, {35, 6}, {45, 8}}, {{10, 500}}}, got)
+
+	set = NewTransformedSet(&listSet{i: -1, series: []storage.Series{
+		storage.NewListSeries(labels.FromStrings("a", "b"), []tsdbutil.Sample{
+			sample{t: 10, v: 1}, sample{t: 20, v: 2}, sample{t: 30, v: 3},
+		}),
+	}}, func(_ labels.Labels, t int64, v float64) (int64, float64, bool) { return t + 5, v, v != 2 })
+	testutil.Assert(t, set.Next())
+
+	// Seek uses the transformed timestamps, skipping the dropped samples.
+	it := set.At().Iterator()
+	testutil.Assert(t, it.Seek(15))
+	ts, _ := it.At()
+	testutil.Equals(t, int64(15), ts)
+	testutil.Assert(t, it.Seek(16))
+	ts, _ = it.At()
+	testutil.Equals(t, int64(35), ts)
+	testutil.Assert(t, !it.Seek(36))
+}
@@ -0,0 +1,56 @@
+package series
+
+import (
+	"math"
+
+	"github.com/prometheus/prometheus/storage"
+)
+
+// NewVaryingSet returns the set without the series with constant values, i.e. with all the samples within the epsilon
+// of the first sample, e.g. to export only the changes of config or state metrics. NaN values (e.g. staleness
+// markers) equal only each other. The series with a single sample (or none) are constant.
+//
+// The samples of every series are scanned before the series is returned, by an iterator of its own, so the samples of
+// the returned series are decoded twice: the scan of a varying series stops at its first changed sample, while
+// constant series are decoded whole once and dropped. Series failing the scan are returned, so that the error
+// surfaces when they are read.
+func NewVaryingSet(s Set, epsilon float64) Set {
+	return &varyingSet{Set: s, epsilon: epsilon}
+}
+
+type varyingSet struct {
+	Set
+
+	epsilon float64
+}
+
+func (s *varyingSet) Next() bool {
+	for s.Set.Next() {
+		if s.varies(s.Set.At()) {
+			return true
+		}
+	}
+	return false
+}
+
+// varies returns true if any sample of the series differs from the first one by more than the epsilon.
+func (s *varyingSet) varies(ser storage.Series) bool {
+	it := ser.Iterator()
+	if !it.Next() {
+		return it.Err() != nil
+	}
+	_, first := it.At()
+	for it.Next() {
+		_, v := it.At()
+		if math.IsNaN(first) || math.IsNaN(v) {
+			if math.IsNaN(first) != math.IsNaN(v) {
+				return true
+			}
+			continue
+		}
+		if math.Abs(v-first) > s.epsilon {
+			return true
+		}
+	}
+	return it.Err() != nil
+}
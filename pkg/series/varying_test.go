@@ -0,0 +1,43 @@
+package series
+
+import (
+	"math"
+	"testing"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/tsdbutil"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestVaryingSet(t *testing.T) {
+	list := func() Set {
+		return &listSet{i: -1, series: []storage.Series{
+			storage.NewListSeries(labels.FromStrings("name", "constant"), []tsdbutil.Sample{sample{t: 10, v: 1}, sample{t: 20, v: 1}, sample{t: 30, v: 1}}),
+			storage.NewListSeries(labels.FromStrings("name", "single"), []tsdbutil.Sample{sample{t: 10, v: 5}}),
+			storage.NewListSeries(labels.FromStrings("name", "empty"), nil),
+			storage.NewListSeries(labels.FromStrings("name", "noisy"), []tsdbutil.Sample{sample{t: 10, v: 1}, sample{t: 20, v: 1.05}, sample{t: 30, v: 0.95}}),
+			storage.NewListSeries(labels.FromStrings("name", "drifting"), []tsdbutil.Sample{sample{t: 10, v: 1}, sample{t: 20, v: 1.08}, sample{t: 30, v: 1.16}}),
+			storage.NewListSeries(labels.FromStrings("name", "stale"), []tsdbutil.Sample{sample{t: 10, v: 1}, sample{t: 20, v: math.NaN()}}),
+			storage.NewListSeries(labels.FromStrings("name", "nan"), []tsdbutil.Sample{sample{t: 10, v: math.NaN()}, sample{t: 20, v: math.NaN()}}),
+		}}
+	}
+	names := func(s Set) []string {
+		var ret []string
+		for s.Next() {
+			ret = append(ret, s.At().Labels().Get("name"))
+			// The returned series are read whole.
+			n := 0
+			for it := s.At().Iterator(); it.Next(); {
+				n++
+			}
+			testutil.Assert(t, n > 1)
+		}
+		testutil.Ok(t, s.Err())
+		return ret
+	}
+
+	testutil.Equals(t, []string{"noisy", "drifting", "stale"}, names(NewVaryingSet(list(), 0)))
+	// The values are compared with the first sample, so slow drifts are detected.
+	testutil.Equals(t, []string{"drifting", "stale"}, names(NewVaryingSet(list(), 0.1)))
+}
@@ -0,0 +1,221 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+)
+
+// Job describes a single extraction request.
+type Job struct {
+	// Match is the series selector, e.g. up{a="1"}.
+	Match      string         `json:"match"`
+	MinTime    time.Time      `json:"min_time"`
+	MaxTime    time.Time      `json:"max_time"`
+	Resolution model.Duration `json:"resolution"`
+	// Path is the output target, relative to the configured output storage.
+	Path string `json:"path"`
+}
+
+// Validate returns an error if the job is not complete.
+func (j Job) Validate() error {
+	if j.Match == "" {
+		return errors.New("match is required")
+	}
+	if !j.MinTime.Before(j.MaxTime) {
+		return errors.Errorf("min_time %s has to be before max_time %s", j.MinTime, j.MaxTime)
+	}
+	if j.Resolution <= 0 {
+		return errors.New("resolution has to be positive")
+	}
+	if j.Path == "" {
+		return errors.New("path is required")
+	}
+	// The path is relative to the output storage, it must not escape it, e.g. the directory of the filesystem one.
+	if path.IsAbs(j.Path) || filepath.IsAbs(j.Path) {
+		return errors.Errorf("path %q has to be relative", j.Path)
+	}
+	for _, seg := range strings.FieldsFunc(j.Path, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if seg == ".." {
+			return errors.Errorf("path %q must not contain .. segments", j.Path)
+		}
+	}
+	return nil
+}
+
+// State of a job.
+type State string
+
+const (
+	StateRunning   State = "running"
+	StateSucceeded State = "succeeded"
+	StateFailed    State = "failed"
+)
+
+// Status reports the progress of a job.
+type Status struct {
+	ID       string     `json:"id"`
+	Job      Job        `json:"job"`
+	State    State      `json:"state"`
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Error    string     `json:"error,omitempty"`
+	// Progress is the estimated fraction of the job completed, from 0 to 1.
+	Progress float64 `json:"progress"`
+}
+
+// ExportFunc runs the read, transform and write pipeline for the job, reporting the estimated fraction of the job
+// completed to the progress function as it runs.
+type ExportFunc func(ctx context.Context, job Job, progress func(fraction float64)) error
+
+// maxFinishedJobs is the number of finished jobs whose status is kept, the oldest ones are dropped first.
+const maxFinishedJobs = 100
+
+// Server exposes extraction over HTTP. Jobs run synchronously within the request, so that the
+// cancellation of the request cancels the job.
+type Server struct {
+	logger log.Logger
+	export ExportFunc
+	slots  chan struct{}
+
+	mtx      sync.Mutex
+	nextID   int
+	jobs     map[string]*Status
+	finished []string
+}
+
+// New returns a Server running at most maxConcurrentJobs jobs at the same time.
+func New(logger log.Logger, export ExportFunc, maxConcurrentJobs int) *Server {
+	return &Server{
+		logger: logger,
+		export: export,
+		slots:  make(chan struct{}, maxConcurrentJobs),
+		jobs:   map[string]*Status{},
+	}
+}
+
+// Handler returns the HTTP handler of the API.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/export", s.handleExport)
+	mux.HandleFunc("/api/v1/jobs", s.handleJobs)
+	return mux
+}
+
+func (s *Server) handleExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var job Job
+	if err := json.NewDecoder(r.Body).Decode(&job); err != nil {
+		http.Error(w, errors.Wrap(err, "decoding job").Error(), http.StatusBadRequest)
+		return
+	}
+	if err := job.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	select {
+	case s.slots <- struct{}{}:
+		defer func() { <-s.slots }()
+	default:
+		http.Error(w, "too many concurrent jobs", http.StatusTooManyRequests)
+		return
+	}
+
+	status := s.start(job)
+	level.Info(s.logger).Log("msg", "starting job", "id", status.ID, "match", job.Match, "path", job.Path)
+
+	err := s.export(r.Context(), job, func(f float64) { s.progress(status.ID, f) })
+	status = s.finish(status.ID, err)
+	if err != nil {
+		level.Error(s.logger).Log("msg", "job failed", "id", status.ID, "err", err)
+		writeJSON(w, http.StatusInternalServerError, status)
+		return
+	}
+	level.Info(s.logger).Log("msg", "job finished", "id", status.ID)
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (s *Server) handleJobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.mtx.Lock()
+	ret := make([]Status, 0, len(s.jobs))
+	for _, st := range s.jobs {
+		ret = append(ret, *st)
+	}
+	s.mtx.Unlock()
+
+	sort.Slice(ret, func(i, j int) bool {
+		a, _ := strconv.Atoi(ret[i].ID)
+		b, _ := strconv.Atoi(ret[j].ID)
+		return a < b
+	})
+
+	writeJSON(w, http.StatusOK, ret)
+}
+
+func (s *Server) start(job Job) Status {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	st := &Status{ID: strconv.Itoa(s.nextID), Job: job, State: StateRunning, Started: time.Now()}
+	s.jobs[st.ID] = st
+	s.nextID++
+	return *st
+}
+
+func (s *Server) progress(id string, fraction float64) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.jobs[id].Progress = fraction
+}
+
+func (s *Server) finish(id string, err error) Status {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	st := s.jobs[id]
+	s.finished = append(s.finished, id)
+	if len(s.finished) > maxFinishedJobs {
+		delete(s.jobs, s.finished[0])
+		s.finished = s.finished[1:]
+	}
+
+	now := time.Now()
+	st.Finished = &now
+	st.State = StateSucceeded
+	if err != nil {
+		st.State = StateFailed
+		st.Error = err.Error()
+		return *st
+	}
+	st.Progress = 1
+	return *st
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
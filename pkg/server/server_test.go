@@ -0,0 +1,118 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+const validJob = `{"match": "up", "min_time": "2020-01-01T00:00:00Z", "max_time": "2020-01-02T00:00:00Z", "resolution": "5m", "path": "up.parquet"}`
+
+func post(t *testing.T, h http.Handler, body string) (int, Status) {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/export", strings.NewReader(body)))
+
+	var st Status
+	if rec.Header().Get("Content-Type") == "application/json" {
+		testutil.Ok(t, json.NewDecoder(rec.Body).Decode(&st))
+	}
+	return rec.Code, st
+}
+
+func TestServer_Export(t *testing.T) {
+	var jobs []Job
+	s := New(log.NewNopLogger(), func(_ context.Context, job Job, progress func(float64)) error {
+		jobs = append(jobs, job)
+		progress(0.5)
+		if job.Path == "fail.parquet" {
+			return errors.New("failed")
+		}
+		return nil
+	}, 1)
+	h := s.Handler()
+
+	code, st := post(t, h, validJob)
+	testutil.Equals(t, http.StatusOK, code)
+	testutil.Equals(t, StateSucceeded, st.State)
+	testutil.Equals(t, "up.parquet", st.Job.Path)
+	testutil.Equals(t, 1.0, st.Progress)
+
+	code, st = post(t, h, strings.Replace(validJob, "up.parquet", "fail.parquet", 1))
+	testutil.Equals(t, http.StatusInternalServerError, code)
+	testutil.Equals(t, StateFailed, st.State)
+	testutil.Equals(t, "failed", st.Error)
+	testutil.Equals(t, 0.5, st.Progress)
+
+	code, _ = post(t, h, strings.Replace(validJob, `"match": "up"`, `"match": ""`, 1))
+	testutil.Equals(t, http.StatusBadRequest, code)
+	testutil.Equals(t, 2, len(jobs))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/jobs", nil))
+	var statuses []Status
+	testutil.Ok(t, json.NewDecoder(rec.Body).Decode(&statuses))
+	testutil.Equals(t, 2, len(statuses))
+	testutil.Equals(t, "0", statuses[0].ID)
+	testutil.Equals(t, "1", statuses[1].ID)
+}
+
+func TestServer_ConcurrencyLimit(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	s := New(log.NewNopLogger(), func(ctx context.Context, _ Job, _ func(float64)) error {
+		close(started)
+		<-release
+		return nil
+	}, 1)
+	h := s.Handler()
+
+	done := make(chan int)
+	go func() {
+		code, _ := post(t, h, validJob)
+		done <- code
+	}()
+	<-started
+
+	code, _ := post(t, h, validJob)
+	testutil.Equals(t, http.StatusTooManyRequests, code)
+
+	close(release)
+	testutil.Equals(t, http.StatusOK, <-done)
+}
+
+func TestJob_ValidatePath(t *testing.T) {
+	for _, p := range []string{"up.parquet", "dir/up.parquet", "dir/..up.parquet"} {
+		job := Job{Match: "up", MinTime: time.Unix(0, 0), MaxTime: time.Unix(60, 0), Resolution: model.Duration(time.Minute), Path: p}
+		testutil.Ok(t, job.Validate())
+	}
+	for _, p := range []string{"/etc/up.parquet", "../up.parquet", "dir/../../up.parquet", "dir/..", `dir\..\..\up.parquet`} {
+		job := Job{Match: "up", MinTime: time.Unix(0, 0), MaxTime: time.Unix(60, 0), Resolution: model.Duration(time.Minute), Path: p}
+		testutil.NotOk(t, job.Validate(), p)
+	}
+}
+
+func TestServer_PrunesFinishedJobs(t *testing.T) {
+	s := New(log.NewNopLogger(), func(context.Context, Job, func(float64)) error { return nil }, 1)
+	h := s.Handler()
+	for i := 0; i < maxFinishedJobs+2; i++ {
+		code, _ := post(t, h, validJob)
+		testutil.Equals(t, http.StatusOK, code)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/jobs", nil))
+	var statuses []Status
+	testutil.Ok(t, json.NewDecoder(rec.Body).Decode(&statuses))
+	testutil.Equals(t, maxFinishedJobs, len(statuses))
+	testutil.Equals(t, "2", statuses[0].ID)
+	testutil.Equals(t, strconv.Itoa(maxFinishedJobs+1), statuses[len(statuses)-1].ID)
+}
@@ -0,0 +1,60 @@
+package timerange
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+)
+
+// Layouts of absolute times accepted by Parse. Times without zone offset are in UTC.
+var layouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+// Parse parses s as absolute or relative time. Supported formats are:
+//   - "now",
+//   - duration before now, e.g. "30d", "1h30m" or "-2w",
+//   - absolute time in RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02T15:04"
+//     or "2006-01-02" format, in UTC unless the zone offset is given,
+//   - Unix timestamp in seconds.
+func Parse(s string, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}, errors.New("empty time")
+	}
+	if strings.EqualFold(s, "now") {
+		return now.UTC(), nil
+	}
+
+	if d, err := model.ParseDuration(strings.TrimPrefix(s, "-")); err == nil {
+		return now.Add(-time.Duration(d)).UTC(), nil
+	}
+	for _, l := range layouts {
+		if t, err := time.ParseInLocation(l, s, time.UTC); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	if sec, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(sec, 0).UTC(), nil
+	}
+	return time.Time{}, errors.Errorf("unsupported time %q, expected \"now\", a duration (e.g. 30d) or an absolute time (e.g. 2006-01-02T15:04:05Z)", s)
+}
+
+// Validate returns an error if mint is not before maxt or if the range is longer than maxRange.
+// No limit is applied when maxRange is 0.
+func Validate(mint, maxt time.Time, maxRange time.Duration) error {
+	if !mint.Before(maxt) {
+		return errors.Errorf("min time %s has to be before max time %s", mint.Format(time.RFC3339), maxt.Format(time.RFC3339))
+	}
+	if r := maxt.Sub(mint); maxRange > 0 && r > maxRange {
+		return errors.Errorf("time range %s is longer than %s", model.Duration(r), model.Duration(maxRange))
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package timerange
+
+import (
+	"testing"
+	"time"
+
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestParse(t *testing.T) {
+	now := time.Date(2020, 5, 10, 12, 30, 0, 0, time.FixedZone("CEST", 2*60*60))
+
+	for _, tcase := range []struct {
+		input    string
+		expected time.Time
+	}{
+		{input: "now", expected: now.UTC()},
+		{input: "NOW", expected: now.UTC()},
+		{input: "30d", expected: now.Add(-30 * 24 * time.Hour).UTC()},
+		{input: "-1h30m", expected: now.Add(-90 * time.Minute).UTC()},
+		{input: "2020-05-01T10:00:00Z", expected: time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)},
+		{input: "2020-05-01T10:00:00+02:00", expected: time.Date(2020, 5, 1, 8, 0, 0, 0, time.UTC)},
+		{input: "2020-05-01 10:00:00", expected: time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)},
+		{input: "2020-05-01", expected: time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)},
+		{input: "1588327200", expected: time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)},
+	} {
+		t.Run(tcase.input, func(t *testing.T) {
+			got, err := Parse(tcase.input, now)
+			testutil.Ok(t, err)
+			testutil.Equals(t, tcase.expected, got)
+		})
+	}
+
+	_, err := Parse("yesterday", now)
+	testutil.NotOk(t, err)
+	_, err = Parse("", now)
+	testutil.NotOk(t, err)
+}
+
+func TestValidate(t *testing.T) {
+	mint := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	testutil.Ok(t, Validate(mint, mint.Add(time.Hour), 0))
+	testutil.Ok(t, Validate(mint, mint.Add(time.Hour), time.Hour))
+	testutil.NotOk(t, Validate(mint, mint, 0))
+	testutil.NotOk(t, Validate(mint, mint.Add(-time.Hour), 0))
+	testutil.NotOk(t, Validate(mint, mint.Add(2*time.Hour), time.Hour))
+}